@@ -1,7 +1,9 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -11,19 +13,323 @@ type Config struct {
 	TwilioAccountSID  string
 	TwilioAuthToken   string
 	TwilioPhoneNumber string
+	// VoicemailPhoneNumber is a message-only line: callers leave a voicemail instead of a live session
+	VoicemailPhoneNumber string
+	// TransferPhoneNumber is where callers asking for a person are put through, empty when no one takes calls
+	TransferPhoneNumber string
+	// TwilioValidateSignature refuses Twilio webhooks without a valid X-Twilio-Signature
+	TwilioValidateSignature bool
+	// PublicBaseURL is the https URL Twilio reaches the service at, which webhook
+	// signatures are checked against and TwiML points back to; empty takes it from requests
+	PublicBaseURL string
 
 	// Google Cloud Configuration
 	GoogleProjectID       string
 	GoogleCredentialsPath string
+	// Variables holding Secret Manager references (sm://...) are resolved at startup, and
+	// those on the latest version are fetched again this often; 0 never refreshes them
+	SecretsRefreshSeconds int
 
 	// Server Configuration
 	Port string
+	// AdminToken is the bearer token of the /admin, conversation, caller and callback
+	// endpoints, which are off when it's empty
+	AdminToken string
+	// APIAuth makes the API, all but the health check, need one of APIKeys, given as
+	// name=key, or the admin token
+	APIAuth bool
+	APIKeys []string
+	// PartnerKeys, given as name=key, are the only keys besides the admin token that can
+	// register referrals, and are taken nowhere else
+	PartnerKeys []string
+	// TrustProxy takes client addresses from X-Forwarded-For, for rate limits behind a load
+	// balancer
+	TrustProxy bool
+	// Requests allowed per client IP on the API, per Twilio account on the Twilio webhooks,
+	// and calls per caller number, each in bursts of up to the burst; 0 for no limit. Callers
+	// over the limit hear RateLimitMessage.
+	RateLimitAPIPerMinute     int
+	RateLimitAPIBurst         int
+	RateLimitWebhookPerMinute int
+	RateLimitWebhookBurst     int
+	RateLimitCallsPerHour     int
+	RateLimitCallsBurst       int
+	RateLimitMessage          string
+	// Callers matching the denylist, or not matching a non-empty allowlist, hear
+	// CallerBlockedMessage and are hung up on. Entries are numbers, caller hashes, patterns
+	// like +1900* or "withheld".
+	// CallerHashSecret keys the hashes callers are known by in the API and storage
+	CallerHashSecret     string
+	CallerAllowlist      []string
+	CallerDenylist       []string
+	CallerBlockedMessage string
+	// Audio download links are signed with AudioURLSecret, a random key when empty, and
+	// expire after AudioURLTTLSeconds
+	AudioURLSecret     string
+	AudioURLTTLSeconds int
+	// The deep health check gives each dependency probe this long, and reuses its results
+	// for HealthProbeCacheSeconds
+	HealthProbeTimeoutMs    int
+	HealthProbeCacheSeconds int
+	// A synthetic turn, canned audio of the phrase through STT, the LLM and TTS, is run
+	// this often to catch provider outages; 0 runs none. Its audio is cached in the file.
+	SyntheticMonitorIntervalSeconds int
+	SyntheticMonitorTimeoutSeconds  int
+	SyntheticMonitorPhrase          string
+	SyntheticMonitorAudioFile       string
 
 	// Logging Configuration
 	LogLevel string
+	// LogFormat is text, a line per record, or json, an object per record for Cloud Logging or ELK
+	LogFormat string
+	// LogTranscripts lets loggers at DEBUG write what callers said and what was answered in
+	// full; otherwise only its length is logged
+	LogTranscripts bool
+
+	// Error reporting: errors logged at ERROR and recovered panics are sent to the
+	// Sentry-compatible DSN when one is set
+	SentryDSN         string
+	SentryEnvironment string
+	SentryRelease     string
 
 	// Audio Configuration
 	AudioOutputDirectory string
+	AudioFileType        string // wav or raw, the file type synthesized responses are saved as
+
+	// Partner referrals expire if the caller hasn't called within this, 0 keeps them
+	ReferralTTLHours int
+	// Callback offers are dropped this long after they were queued, 0 keeps them until
+	// the queue is full
+	CallbackRetentionHours int
+
+	// Speech-to-Text Configuration
+	STTProvider             string // google, deepgram, whisper, assemblyai or azure
+	STTLanguageCode         string
+	STTModel                string
+	STTAlternativeLanguages []string // Other languages callers may speak, detected by the Google recognizer
+	STTLocation             string   // Google Speech-to-Text V2 region, e.g. global or us-central1
+	STTRecognizer           string   // Recognizer ID or full resource name; "_" uses the implicit recognizer
+	STTEncoding             string   // Overrides the negotiated encoding when set, e.g. MULAW
+	STTSampleRate           int      // Overrides the negotiated sample rate when > 0
+	STTAutomaticPunctuation bool
+	STTInterimResults       bool
+	STTMinConfidence        float64 // Final results below this are met with a request to repeat and interim ones ignored, 0 disables
+	STTMinStability         float64 // Interim results less stable than this are ignored, 0 disables
+	STTProfanityFilter      bool    // Ask the provider to mask profanity in results
+	STTKeepaliveMs          int     // Send silence after this long without audio so streams don't time out, 0 disables
+
+	// Text-to-Speech Configuration
+	TTSProvider string // google, azure, polly or openai
+	// TTSProviders is an ordered failover chain; when set it replaces TTSProvider
+	TTSProviders         []string
+	TTSProviderTimeoutMs int // How long each provider in the chain gets before the next is tried
+	// Google voice selection. An empty language is taken from the voice name, or is the
+	// caller's language in translation mode
+	TTSLanguageCode    string
+	TTSVoice           string
+	TTSGender          string   // NEUTRAL, FEMALE or MALE
+	TTSEffectsProfiles []string // Audio effects profiles applied in order; "none" disables them
+	TTSSSML            bool     // Speak responses with SSML pauses, prosody and number readings
+	TTSVoiceOptions    []string // label=voice entries callers can choose between, in menu order
+	TTSLanguageVoices  []string // language=voice entries for callers speaking another language
+	TTSSpeakingRate    float64  // 1 is the voice's normal pace
+	TTSPitch           float64  // Semitones up or down from the voice's normal pitch
+	TTSParallelism     int      // Sentences of a response synthesized at once
+
+	// LLM Configuration
+	LLMProvider  string  // gemini, openai, claude or ollama
+	LLMRateLimit float64 // Requests per second across all calls, 0 disables the throttle
+	LLMBurst     int
+	// Generation params; 0 for top-p, top-k and max output tokens uses the provider's default
+	LLMTemperature         float64
+	LLMTopP                float64
+	LLMTopK                int
+	LLMMaxOutputTokens     int
+	GeminiSafetyThresholds string // One block threshold, or category=threshold pairs
+	LLMPersonaParamsFile   string // JSON of persona to generation params overrides
+	// Model retried when the primary fails or exceeds the latency budget, empty for none
+	LLMFallbackModel   string
+	LLMLatencyBudgetMs int // Time the primary has to answer, or start streaming; 0 for no budget
+	// History sent with each request, within a token budget; older turns are summarized
+	LLMHistoryTokens    int // 0 sends the whole history
+	LLMHistoryKeep      int // Latest messages always sent verbatim
+	LLMHistorySummarize bool
+	CallSummaryEnabled  bool // Summarize each call with the LLM once it ends
+	SessionNotesEnabled bool // Write SOAP notes on each call for clinicians once it ends
+	// Per-call LLM budget: near a ceiling responses are kept short, past it the budget model answers
+	LLMCallMaxTokens         int     // 0 for no token ceiling
+	LLMCallMaxCost           float64 // 0 for no cost ceiling
+	LLMPromptPrice           float64 // Price of 1000 prompt tokens
+	LLMResponsePrice         float64 // Price of 1000 response tokens
+	LLMBudgetWarnFraction    float64 // Share of a ceiling past which responses are kept short
+	LLMBudgetModel           string  // Cheaper model for calls over a ceiling, empty to keep the model
+	LLMBudgetMaxOutputTokens int     // Response cap near and over a ceiling
+	// Checks on responses before they are spoken
+	GuardrailsEnabled bool
+	GuardrailMaxChars int // Longest response spoken, cut at a sentence boundary
+	// Actions the LLM can take during calls, with Gemini
+	LLMToolsEnabled     bool
+	LLMStructuredOutput bool   // Replies of speech and actions, e.g. flag_risk or end_call
+	CrisisResourcesFile string // JSON list of local crisis resources, ahead of the national ones
+
+	// Translation mode: the caller speaks STT_LANGUAGE_CODE and the LLM works in the pivot language
+	TranslationEnabled       bool
+	TranslationPivotLanguage string
+
+	// Turn-taking: how long to wait after a final transcript for the caller to go on
+	TurnFinalGraceMs int
+
+	// Inbound caller audio recording, only for callers who consent
+	RecordCallerAudio      bool
+	RecordingConsentNotice string
+
+	// Inbound audio clean-up before speech recognition
+	AudioPreprocess  bool
+	AudioTargetLevel float64 // RMS level speech is normalized toward, in 16-bit PCM
+	AudioMaxGain     float64
+	AudioNoiseGate   float64 // RMS level below which audio is treated as background noise
+
+	// Voice activity detection: skip streaming silence to the STT provider
+	VADEnabled    bool
+	VADThreshold  float64 // Minimum RMS level of speech, in 16-bit PCM
+	VADHangoverMs int     // Keep streaming this long after speech stops
+	VADPreRollMs  int     // Audio from before speech onset sent with it
+
+	// Fallback phrases spoken when a response can't be generated
+	FallbackPhrasesFile string
+	FallbackAudioDir    string // Cache of pre-synthesized fallback audio, can be shipped with the deployment
+	BackchannelDelayMs  int    // Wait for a response before playing a backchannel, 0 disables them
+
+	// Sensitive terms masked in transcripts before they're stored or exported, one per line
+	MaskedTermsFile string
+
+	// In-memory state of ended calls is evicted after the retention, 0 keeps it for good
+	CallRetentionMinutes     int
+	CallSweepIntervalSeconds int
+	// Session records of evicted calls are archived here, empty archives nothing. They are
+	// encrypted with AES-GCM when the key, base64 of 16, 24 or 32 bytes, is set.
+	TranscriptArchiveDir    string
+	TranscriptEncryptionKey string
+	// AudioEncryptionKey, a base64 AES key, encrypts saved responses and recordings
+	AudioEncryptionKey string
+	// StorageBackend is where session records and caller profiles are kept: disk, in the
+	// archive dir, firestore, in the project's Firestore database, or sql, in DatabaseURL
+	StorageBackend            string
+	FirestoreDatabase         string
+	FirestoreCollectionPrefix string
+	// SQL storage backends connect with the database/sql driver to the URL, and their
+	// schema is migrated at startup; empty URL connects to no database
+	DatabaseDriver string
+	DatabaseURL    string
+
+	// Call lifecycle events are POSTed to the webhook URLs, signed with the secret
+	WebhookURLs        []string
+	WebhookSecret      string
+	WebhookMaxAttempts int
+	WebhookTimeoutMs   int
+
+	// Call events are also published to the Pub/Sub topic, a name in the project or
+	// projects/<project>/topics/<name>; empty publishes nothing
+	PubSubTopic       string
+	PubSubMaxAttempts int
+
+	// Latency SLOs of the turns' stages, 0 for none: SLOObjective of turns should stay within
+	// them over the window. Alerts go to the event publishers and Slack when the error budget
+	// burns SLOBurnRateAlert times faster than sustainable.
+	SLOGenerationMs    int
+	SLOSynthesisMs     int
+	SLOObjective       float64
+	SLOWindowMinutes   int
+	SLOBurnRateAlert   float64
+	SLOSlackWebhookURL string
+
+	// Administrative and operator actions are appended to the audit log file; empty keeps
+	// them in memory only
+	AuditLogFile string
+
+	// Ended calls are streamed into the BigQuery table, dataset.table, in batches; empty
+	// exports nothing. Transcripts are exported none, redacted or full.
+	BigQueryTable        string
+	BigQueryTranscripts  string
+	BigQueryBatchSize    int
+	BigQueryFlushSeconds int
+	BigQueryMaxAttempts  int
+
+	// Speech adaptation phrase sets, reloaded when the file changes
+	PhraseSetsFile          string
+	PhraseSetsReloadSeconds int
+
+	// System prompt templates, <dir>/<persona>.tmpl, reloaded when they change
+	PromptsDir           string
+	PromptsReloadSeconds int
+	PersonaName          string // Name the therapist goes by in the prompt, optional
+	PersonasFile         string // JSON array of personas callers can talk with, optional
+	// Personas and LLMPersonaParams are the config file's personas and persona_params
+	// sections, as JSON, used when PersonasFile and LLMPersonaParamsFile aren't set
+	Personas         json.RawMessage
+	LLMPersonaParams json.RawMessage
+
+	// Tenants served from this process, a JSON array in TenantsFile or the config file's
+	// tenants section; none serves every call as the configured organization
+	TenantsFile string
+	Tenants     json.RawMessage
+
+	// Deepgram Configuration
+	DeepgramAPIKey string
+	DeepgramModel  string
+	DeepgramURL    string
+
+	// Whisper Configuration
+	WhisperAPIKey         string
+	WhisperModel          string
+	WhisperURL            string // OpenAI or a local whisper.cpp server
+	WhisperSegmentSeconds int    // Longest audio segment sent in one request
+
+	// AssemblyAI Configuration
+	AssemblyAIAPIKey  string
+	AssemblyAIURL     string // Real-time websocket endpoint
+	AssemblyAIRESTURL string // Pre-recorded transcription API
+
+	// Azure Speech Configuration, shared by the azure STT and TTS providers
+	AzureSpeechKey    string
+	AzureSpeechRegion string
+	AzureTTSVoice     string
+	AzureTTSStyle     string // Speaking style of neural voices, e.g. empathetic; empty for none
+
+	// Gemini Configuration: the AI Studio API with GEMINI_API_KEY, or Vertex AI with the
+	// application default credentials
+	GeminiModel       string
+	GeminiBackend     string // studio or vertex
+	VertexProjectID   string
+	VertexLocation    string
+	VertexRequestType string // dedicated to use only provisioned throughput, shared to skip it, empty for both
+
+	// Anthropic Configuration for the claude LLM provider
+	AnthropicAPIKey    string
+	AnthropicModel     string
+	AnthropicMaxTokens int // Longest response, in tokens
+	AnthropicURL       string
+
+	// Ollama Configuration for the ollama LLM provider, any OpenAI-compatible server works
+	OllamaURL    string
+	OllamaModel  string
+	OllamaAPIKey string // Only for servers behind an authenticating proxy
+
+	// OpenAI Configuration for the openai LLM and TTS providers
+	OpenAIAPIKey   string
+	OpenAIModel    string
+	OpenAIChatURL  string
+	OpenAITTSModel string
+	OpenAITTSVoice string
+	OpenAITTSURL   string
+
+	// AWS Configuration for the polly TTS provider
+	AWSRegion          string
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+	PollyVoice         string
+	PollyEngine        string // neural or standard
 }
 
 // Load loads configuration from environment variables
@@ -44,14 +350,289 @@ func Load() *Config {
 		audioOutputDir = "saved_audio" // Default output directory
 	}
 
+	fileMu.RLock()
+	personas, personaParams, tenants := fileSections.personas, fileSections.personaParams, fileSections.tenants
+	fileMu.RUnlock()
+
 	return &Config{
-		TwilioAccountSID:      os.Getenv("TWILIO_ACCOUNT_SID"),
-		TwilioAuthToken:       os.Getenv("TWILIO_AUTH_TOKEN"),
-		TwilioPhoneNumber:     os.Getenv("TWILIO_PHONE_NUMBER"),
-		GoogleProjectID:       os.Getenv("GOOGLE_PROJECT_ID"),
-		GoogleCredentialsPath: os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"),
-		Port:                  port,
-		LogLevel:              logLevel,
-		AudioOutputDirectory:  audioOutputDir,
+		TwilioAccountSID:                os.Getenv("TWILIO_ACCOUNT_SID"),
+		TwilioAuthToken:                 os.Getenv("TWILIO_AUTH_TOKEN"),
+		TwilioPhoneNumber:               os.Getenv("TWILIO_PHONE_NUMBER"),
+		VoicemailPhoneNumber:            os.Getenv("VOICEMAIL_PHONE_NUMBER"),
+		TransferPhoneNumber:             os.Getenv("TRANSFER_PHONE_NUMBER"),
+		TwilioValidateSignature:         getEnvBool("TWILIO_VALIDATE_SIGNATURE", true),
+		PublicBaseURL:                   os.Getenv("PUBLIC_BASE_URL"),
+		GoogleProjectID:                 os.Getenv("GOOGLE_PROJECT_ID"),
+		GoogleCredentialsPath:           os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"),
+		SecretsRefreshSeconds:           getEnvInt("SECRETS_REFRESH_SECONDS", 300),
+		Port:                            port,
+		AdminToken:                      os.Getenv("ADMIN_TOKEN"),
+		APIAuth:                         getEnvBool("API_AUTH", true),
+		APIKeys:                         getEnvList("API_KEYS", nil),
+		PartnerKeys:                     getEnvList("PARTNER_KEYS", nil),
+		TrustProxy:                      getEnvBool("TRUST_PROXY", false),
+		RateLimitAPIPerMinute:           getEnvInt("RATE_LIMIT_API_PER_MINUTE", 120),
+		RateLimitAPIBurst:               getEnvInt("RATE_LIMIT_API_BURST", 30),
+		RateLimitWebhookPerMinute:       getEnvInt("RATE_LIMIT_WEBHOOK_PER_MINUTE", 600),
+		RateLimitWebhookBurst:           getEnvInt("RATE_LIMIT_WEBHOOK_BURST", 100),
+		RateLimitCallsPerHour:           getEnvInt("RATE_LIMIT_CALLS_PER_HOUR", 20),
+		RateLimitCallsBurst:             getEnvInt("RATE_LIMIT_CALLS_BURST", 5),
+		AudioURLSecret:                  os.Getenv("AUDIO_URL_SECRET"),
+		AudioURLTTLSeconds:              getEnvInt("AUDIO_URL_TTL_SECONDS", 900),
+		CallerHashSecret:                os.Getenv("CALLER_HASH_SECRET"),
+		CallerAllowlist:                 getEnvList("CALLER_ALLOWLIST", nil),
+		CallerDenylist:                  getEnvList("CALLER_DENYLIST", nil),
+		CallerBlockedMessage:            getEnv("CALLER_BLOCKED_MESSAGE", "Sorry, this line can't take your call. If you are in crisis, call or text 988. Goodbye."),
+		RateLimitMessage:                getEnv("RATE_LIMIT_MESSAGE", "We are receiving too many calls from this number right now. Please try again later. If you are in crisis, call or text 988. Goodbye."),
+		HealthProbeTimeoutMs:            getEnvInt("HEALTH_PROBE_TIMEOUT_MS", 5000),
+		HealthProbeCacheSeconds:         getEnvInt("HEALTH_PROBE_CACHE_SECONDS", 30),
+		SyntheticMonitorIntervalSeconds: getEnvInt("SYNTHETIC_MONITOR_INTERVAL_SECONDS", 0),
+		SyntheticMonitorTimeoutSeconds:  getEnvInt("SYNTHETIC_MONITOR_TIMEOUT_SECONDS", 30),
+		SyntheticMonitorPhrase:          getEnv("SYNTHETIC_MONITOR_PHRASE", "I have been feeling stressed about work lately."),
+		SyntheticMonitorAudioFile:       os.Getenv("SYNTHETIC_MONITOR_AUDIO_FILE"),
+		LogLevel:                        logLevel,
+		LogFormat:                       strings.ToLower(getEnv("LOG_FORMAT", "text")),
+		LogTranscripts:                  getEnvBool("LOG_TRANSCRIPTS", false),
+		SentryDSN:                       os.Getenv("SENTRY_DSN"),
+		SentryEnvironment:               getEnv("SENTRY_ENVIRONMENT", "production"),
+		SentryRelease:                   os.Getenv("SENTRY_RELEASE"),
+		AudioOutputDirectory:            audioOutputDir,
+		AudioFileType:                   strings.ToLower(getEnv("AUDIO_FILE_TYPE", "wav")),
+		ReferralTTLHours:                getEnvInt("REFERRAL_TTL_HOURS", 72),
+		CallbackRetentionHours:          getEnvInt("CALLBACK_RETENTION_HOURS", 168),
+
+		STTProvider:             strings.ToLower(getEnv("STT_PROVIDER", "google")),
+		STTLanguageCode:         getEnv("STT_LANGUAGE_CODE", "en-US"),
+		STTModel:                getEnv("STT_MODEL", "telephony"),
+		STTAlternativeLanguages: getEnvList("STT_ALTERNATIVE_LANGUAGES", nil),
+		STTLocation:             getEnv("STT_LOCATION", "global"),
+		STTRecognizer:           getEnv("STT_RECOGNIZER", "_"),
+		STTEncoding:             strings.ToUpper(os.Getenv("STT_ENCODING")),
+		STTSampleRate:           getEnvInt("STT_SAMPLE_RATE", 0),
+		STTAutomaticPunctuation: getEnvBool("STT_AUTOMATIC_PUNCTUATION", true),
+		STTInterimResults:       getEnvBool("STT_INTERIM_RESULTS", true),
+		STTMinConfidence:        getEnvFloat("STT_MIN_CONFIDENCE", 0),
+		STTMinStability:         getEnvFloat("STT_MIN_STABILITY", 0),
+		STTProfanityFilter:      getEnvBool("STT_PROFANITY_FILTER", false),
+		STTKeepaliveMs:          getEnvInt("STT_KEEPALIVE_MS", 1000),
+
+		TTSProvider:          strings.ToLower(getEnv("TTS_PROVIDER", "google")),
+		TTSProviders:         getEnvList("TTS_PROVIDERS", nil),
+		TTSProviderTimeoutMs: getEnvInt("TTS_PROVIDER_TIMEOUT_MS", 5000),
+		TTSLanguageCode:      os.Getenv("TTS_LANGUAGE_CODE"),
+		TTSVoice:             os.Getenv("TTS_VOICE"),
+		TTSGender:            strings.ToUpper(getEnv("TTS_GENDER", "NEUTRAL")),
+		TTSEffectsProfiles:   getEnvList("TTS_EFFECTS_PROFILES", []string{"telephony-class-application"}),
+		TTSSSML:              getEnvBool("TTS_SSML", false),
+		TTSVoiceOptions:      getEnvList("TTS_VOICE_OPTIONS", nil),
+		TTSLanguageVoices:    getEnvList("TTS_LANGUAGE_VOICES", nil),
+		TTSSpeakingRate:      getEnvFloat("TTS_SPEAKING_RATE", 1),
+		TTSPitch:             getEnvFloat("TTS_PITCH", 0),
+		TTSParallelism:       getEnvInt("TTS_PARALLELISM", 3),
+
+		LLMProvider:  strings.ToLower(getEnv("LLM_PROVIDER", "gemini")),
+		LLMRateLimit: getEnvFloat("LLM_RATE_LIMIT", 0),
+		LLMBurst:     getEnvInt("LLM_BURST", 5),
+
+		LLMTemperature:         getEnvFloat("LLM_TEMPERATURE", 0.4),
+		LLMTopP:                getEnvFloat("LLM_TOP_P", 0),
+		LLMTopK:                getEnvInt("LLM_TOP_K", 0),
+		LLMMaxOutputTokens:     getEnvInt("LLM_MAX_OUTPUT_TOKENS", 0),
+		GeminiSafetyThresholds: getEnv("GEMINI_SAFETY_THRESHOLDS", ""),
+		LLMPersonaParamsFile:   getEnv("LLM_PERSONA_PARAMS_FILE", ""),
+		LLMFallbackModel:       getEnv("LLM_FALLBACK_MODEL", ""),
+		LLMLatencyBudgetMs:     getEnvInt("LLM_LATENCY_BUDGET_MS", 8000),
+
+		LLMHistoryTokens:    getEnvInt("LLM_HISTORY_TOKENS", 3000),
+		LLMHistoryKeep:      getEnvInt("LLM_HISTORY_KEEP_MESSAGES", 6),
+		LLMHistorySummarize: getEnvBool("LLM_HISTORY_SUMMARIZE", true),
+		CallSummaryEnabled:  getEnvBool("CALL_SUMMARY_ENABLED", true),
+		SessionNotesEnabled: getEnvBool("SESSION_NOTES_ENABLED", true),
+
+		LLMCallMaxTokens:         getEnvInt("LLM_CALL_MAX_TOKENS", 0),
+		LLMCallMaxCost:           getEnvFloat("LLM_CALL_MAX_COST", 0),
+		LLMPromptPrice:           getEnvFloat("LLM_PROMPT_PRICE", 0),
+		LLMResponsePrice:         getEnvFloat("LLM_RESPONSE_PRICE", 0),
+		LLMBudgetWarnFraction:    getEnvFloat("LLM_BUDGET_WARN_FRACTION", 0.8),
+		LLMBudgetModel:           getEnv("LLM_BUDGET_MODEL", ""),
+		LLMBudgetMaxOutputTokens: getEnvInt("LLM_BUDGET_MAX_OUTPUT_TOKENS", 100),
+
+		GuardrailsEnabled: getEnvBool("GUARDRAILS_ENABLED", true),
+		GuardrailMaxChars: getEnvInt("GUARDRAIL_MAX_CHARS", 600),
+
+		LLMToolsEnabled:     getEnvBool("LLM_TOOLS_ENABLED", false),
+		LLMStructuredOutput: getEnvBool("LLM_STRUCTURED_OUTPUT", false),
+		CrisisResourcesFile: getEnv("CRISIS_RESOURCES_FILE", ""),
+
+		TranslationEnabled:       getEnvBool("TRANSLATION_ENABLED", false),
+		TranslationPivotLanguage: getEnv("TRANSLATION_PIVOT_LANGUAGE", "en-US"),
+
+		TurnFinalGraceMs: getEnvInt("TURN_FINAL_GRACE_MS", 700),
+
+		RecordCallerAudio:      getEnvBool("RECORD_CALLER_AUDIO", false),
+		RecordingConsentNotice: getEnv("RECORDING_CONSENT_NOTICE", "To help us improve this service, we would like to record your side of this call. Press 1 to allow recording, or stay on the line to continue without it."),
+
+		AudioPreprocess:  getEnvBool("AUDIO_PREPROCESS", false),
+		AudioTargetLevel: getEnvFloat("AUDIO_TARGET_LEVEL", 3000),
+		AudioMaxGain:     getEnvFloat("AUDIO_MAX_GAIN", 8),
+		AudioNoiseGate:   getEnvFloat("AUDIO_NOISE_GATE", 100),
+
+		VADEnabled:    getEnvBool("VAD_ENABLED", false),
+		VADThreshold:  getEnvFloat("VAD_THRESHOLD", 300),
+		VADHangoverMs: getEnvInt("VAD_HANGOVER_MS", 800),
+		VADPreRollMs:  getEnvInt("VAD_PREROLL_MS", 300),
+
+		FallbackPhrasesFile: os.Getenv("FALLBACK_PHRASES_FILE"),
+		FallbackAudioDir:    getEnv("FALLBACK_AUDIO_DIR", "fallback_audio"),
+		BackchannelDelayMs:  getEnvInt("BACKCHANNEL_DELAY_MS", 1500),
+
+		MaskedTermsFile: os.Getenv("MASKED_TERMS_FILE"),
+
+		CallRetentionMinutes:      getEnvInt("CALL_RETENTION_MINUTES", 1440),
+		CallSweepIntervalSeconds:  getEnvInt("CALL_SWEEP_INTERVAL_SECONDS", 60),
+		TranscriptArchiveDir:      os.Getenv("TRANSCRIPT_ARCHIVE_DIR"),
+		TranscriptEncryptionKey:   os.Getenv("TRANSCRIPT_ENCRYPTION_KEY"),
+		AudioEncryptionKey:        os.Getenv("AUDIO_ENCRYPTION_KEY"),
+		StorageBackend:            getEnv("STORAGE_BACKEND", "disk"),
+		FirestoreDatabase:         getEnv("FIRESTORE_DATABASE", "(default)"),
+		FirestoreCollectionPrefix: os.Getenv("FIRESTORE_COLLECTION_PREFIX"),
+		DatabaseDriver:            getEnv("DATABASE_DRIVER", "postgres"),
+		DatabaseURL:               os.Getenv("DATABASE_URL"),
+
+		WebhookURLs:        getEnvList("WEBHOOK_URLS", nil),
+		WebhookSecret:      os.Getenv("WEBHOOK_SECRET"),
+		WebhookMaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		WebhookTimeoutMs:   getEnvInt("WEBHOOK_TIMEOUT_MS", 5000),
+		PubSubTopic:        os.Getenv("PUBSUB_TOPIC"),
+		PubSubMaxAttempts:  getEnvInt("PUBSUB_MAX_ATTEMPTS", 5),
+
+		SLOGenerationMs:    getEnvInt("SLO_GENERATION_MS", 0),
+		SLOSynthesisMs:     getEnvInt("SLO_SYNTHESIS_MS", 0),
+		SLOObjective:       getEnvFloat("SLO_OBJECTIVE", 0.95),
+		SLOWindowMinutes:   getEnvInt("SLO_WINDOW_MINUTES", 60),
+		SLOBurnRateAlert:   getEnvFloat("SLO_BURN_RATE_ALERT", 2),
+		SLOSlackWebhookURL: os.Getenv("SLO_SLACK_WEBHOOK_URL"),
+
+		AuditLogFile: getEnv("AUDIT_LOG_FILE", "audit.jsonl"),
+
+		BigQueryTable:        os.Getenv("BIGQUERY_TABLE"),
+		BigQueryTranscripts:  getEnv("BIGQUERY_TRANSCRIPTS", "none"),
+		BigQueryBatchSize:    getEnvInt("BIGQUERY_BATCH_SIZE", 50),
+		BigQueryFlushSeconds: getEnvInt("BIGQUERY_FLUSH_SECONDS", 10),
+		BigQueryMaxAttempts:  getEnvInt("BIGQUERY_MAX_ATTEMPTS", 5),
+
+		PhraseSetsFile:          os.Getenv("PHRASE_SETS_FILE"),
+		PhraseSetsReloadSeconds: getEnvInt("PHRASE_SETS_RELOAD_SECONDS", 30),
+
+		PromptsDir:           getEnv("PROMPTS_DIR", "prompts"),
+		PromptsReloadSeconds: getEnvInt("PROMPTS_RELOAD_SECONDS", 30),
+		PersonaName:          os.Getenv("PERSONA_NAME"),
+		PersonasFile:         os.Getenv("PERSONAS_FILE"),
+		Personas:             personas,
+		LLMPersonaParams:     personaParams,
+		TenantsFile:          os.Getenv("TENANTS_FILE"),
+		Tenants:              tenants,
+
+		DeepgramAPIKey: os.Getenv("DEEPGRAM_API_KEY"),
+		DeepgramModel:  getEnv("DEEPGRAM_MODEL", "nova-2-phonecall"),
+		DeepgramURL:    getEnv("DEEPGRAM_URL", "wss://api.deepgram.com/v1/listen"),
+
+		WhisperAPIKey:         getEnv("WHISPER_API_KEY", os.Getenv("OPENAI_API_KEY")),
+		WhisperModel:          getEnv("WHISPER_MODEL", "whisper-1"),
+		WhisperURL:            getEnv("WHISPER_URL", "https://api.openai.com/v1/audio/transcriptions"),
+		WhisperSegmentSeconds: getEnvInt("WHISPER_SEGMENT_SECONDS", 8),
+
+		AssemblyAIAPIKey:  os.Getenv("ASSEMBLYAI_API_KEY"),
+		AssemblyAIURL:     getEnv("ASSEMBLYAI_URL", "wss://streaming.assemblyai.com/v3/ws"),
+		AssemblyAIRESTURL: getEnv("ASSEMBLYAI_REST_URL", "https://api.assemblyai.com"),
+
+		AzureSpeechKey:    os.Getenv("AZURE_SPEECH_KEY"),
+		AzureSpeechRegion: os.Getenv("AZURE_SPEECH_REGION"),
+		AzureTTSVoice:     getEnv("AZURE_TTS_VOICE", "en-US-JennyNeural"),
+		AzureTTSStyle:     os.Getenv("AZURE_TTS_STYLE"),
+
+		GeminiModel:       getEnv("GEMINI_MODEL", "gemini-1.5-pro"),
+		GeminiBackend:     strings.ToLower(getEnv("GEMINI_BACKEND", "studio")),
+		VertexProjectID:   getEnv("VERTEX_PROJECT_ID", os.Getenv("GOOGLE_PROJECT_ID")),
+		VertexLocation:    getEnv("VERTEX_LOCATION", "us-central1"),
+		VertexRequestType: strings.ToLower(os.Getenv("VERTEX_REQUEST_TYPE")),
+
+		AnthropicAPIKey:    os.Getenv("ANTHROPIC_API_KEY"),
+		AnthropicModel:     getEnv("ANTHROPIC_MODEL", "claude-3-5-sonnet-latest"),
+		AnthropicMaxTokens: getEnvInt("ANTHROPIC_MAX_TOKENS", 1024),
+		AnthropicURL:       getEnv("ANTHROPIC_URL", "https://api.anthropic.com/v1/messages"),
+
+		OllamaURL:    getEnv("OLLAMA_URL", "http://localhost:11434/v1/chat/completions"),
+		OllamaModel:  getEnv("OLLAMA_MODEL", "llama3.1"),
+		OllamaAPIKey: os.Getenv("OLLAMA_API_KEY"),
+
+		OpenAIAPIKey:   os.Getenv("OPENAI_API_KEY"),
+		OpenAIModel:    getEnv("OPENAI_MODEL", "gpt-4o-mini"),
+		OpenAIChatURL:  getEnv("OPENAI_CHAT_URL", "https://api.openai.com/v1/chat/completions"),
+		OpenAITTSModel: getEnv("OPENAI_TTS_MODEL", "tts-1"),
+		OpenAITTSVoice: getEnv("OPENAI_TTS_VOICE", "alloy"),
+		OpenAITTSURL:   getEnv("OPENAI_TTS_URL", "https://api.openai.com/v1/audio/speech"),
+
+		AWSRegion:          getEnv("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION")),
+		AWSAccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		AWSSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		AWSSessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		PollyVoice:         getEnv("POLLY_VOICE", "Joanna"),
+		PollyEngine:        strings.ToLower(getEnv("POLLY_ENGINE", "neural")),
+	}
+}
+
+// getEnv returns the environment variable or the default when unset
+func getEnv(key, def string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvInt returns the environment variable as an int or the default when unset or invalid
+func getEnvInt(key string, def int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// getEnvFloat returns the environment variable as a float or the default when unset or invalid
+func getEnvFloat(key string, def float64) float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv(key)), 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// getEnvBool returns the environment variable as a bool or the default when unset or invalid
+func getEnvBool(key string, def bool) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// getEnvList returns the comma-separated environment variable or the default when unset;
+// "none" yields an empty list
+func getEnvList(key string, def []string) []string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	if strings.EqualFold(value, "none") {
+		return nil
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
 }
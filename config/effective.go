@@ -0,0 +1,106 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Where the value of a setting came from
+const (
+	SourceFlag          = "flag"
+	SourceEnvironment   = "environment"
+	SourceFile          = "file"
+	SourceSecretManager = "secret-manager"
+	SourceDefault       = "default"
+)
+
+// maskedValue stands in for credentials too short to show any of
+const maskedValue = "********"
+
+// EffectiveSetting is the value a setting has in the running process and where it came
+// from. Credentials are masked.
+type EffectiveSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Masked bool   `json:"masked,omitempty"`
+}
+
+// secretVariables are the variables resolved from Secret Manager references
+var secretVariables sync.Map
+
+// MarkSecret records that the variable's value was resolved from a secret, so it is
+// always masked
+func MarkSecret(variable string) {
+	secretVariables.Store(variable, true)
+}
+
+// LoadedFile is the path of the config file loaded last, empty when there is none
+func LoadedFile() string {
+	fileMu.RLock()
+	defer fileMu.RUnlock()
+	return loadedFile
+}
+
+// Effective reports every setting as the running process has it: flags, then the
+// environment, then the config file, then the documented default
+func Effective() []EffectiveSetting {
+	fileMu.RLock()
+	defer fileMu.RUnlock()
+
+	settings := make([]EffectiveSetting, 0, len(Settings))
+	for _, setting := range Settings {
+		effective := EffectiveSetting{Name: setting.Name, Source: SourceDefault, Value: setting.Default}
+		if value, set := os.LookupEnv(setting.Name); set {
+			effective.Value = value
+			_, secret := secretVariables.Load(setting.Name)
+			flagged, fromFlag := flagVariables.Load(setting.Name)
+			fromFile, inFile := fileVariables[setting.Name]
+			switch {
+			case secret:
+				effective.Source = SourceSecretManager
+			case fromFlag && flagged == value:
+				effective.Source = SourceFlag
+			case inFile && fromFile == value:
+				effective.Source = SourceFile
+			default:
+				effective.Source = SourceEnvironment
+			}
+			effective.Value, effective.Masked = maskSetting(setting.Name, value, secret)
+		}
+		settings = append(settings, effective)
+	}
+	return settings
+}
+
+// sensitive tells whether a variable holds a credential, going by its name
+func sensitive(name string) bool {
+	for _, marker := range []string{"KEY", "TOKEN", "SECRET", "PASSWORD", "DSN", "SLACK"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSetting hides credentials, keeping the last four characters of long ones so
+// operators can tell which was loaded, and passwords in URLs
+func maskSetting(name, value string, secret bool) (string, bool) {
+	if value == "" {
+		return value, false
+	}
+	if secret || sensitive(name) {
+		if len(value) < 16 {
+			return maskedValue, true
+		}
+		return maskedValue + value[len(value)-4:], true
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted(), true
+		}
+	}
+	return value, false
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestEffectiveSettingsMaskCredentials(t *testing.T) {
+	t.Setenv("TWILIO_AUTH_TOKEN", "0123456789abcdefWXYZ")
+	t.Setenv("WEBHOOK_SECRET", "short")
+	t.Setenv("DATABASE_URL", "postgres://app:hunter2@db:5432/calls")
+	t.Setenv("STT_PROVIDER", "deepgram")
+	for _, key := range []string{"TTS_PROVIDER", "LLM_PROVIDER"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"-llm-provider", "claude"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	settings := make(map[string]EffectiveSetting)
+	for _, setting := range Effective() {
+		settings[setting.Name] = setting
+	}
+	for name, want := range map[string]EffectiveSetting{
+		"TWILIO_AUTH_TOKEN": {Name: "TWILIO_AUTH_TOKEN", Value: "********WXYZ", Source: SourceEnvironment, Masked: true},
+		"WEBHOOK_SECRET":    {Name: "WEBHOOK_SECRET", Value: "********", Source: SourceEnvironment, Masked: true},
+		"DATABASE_URL":      {Name: "DATABASE_URL", Value: "postgres://app:xxxxx@db:5432/calls", Source: SourceEnvironment, Masked: true},
+		"STT_PROVIDER":      {Name: "STT_PROVIDER", Value: "deepgram", Source: SourceEnvironment},
+		"LLM_PROVIDER":      {Name: "LLM_PROVIDER", Value: "claude", Source: SourceFlag},
+		"TTS_PROVIDER":      {Name: "TTS_PROVIDER", Value: "google", Source: SourceDefault},
+	} {
+		if settings[name] != want {
+			t.Errorf("Expected %+v, got %+v", want, settings[name])
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Sections of the config file kept as structured JSON instead of flattened to variables
+const (
+	personasSection      = "personas"       // Same as the PERSONAS_FILE array
+	personaParamsSection = "persona_params" // Same as the LLM_PERSONA_PARAMS_FILE object
+	tenantsSection       = "tenants"        // Same as the TENANTS_FILE array
+)
+
+// fileSections are the structured sections of the loaded config file, read by Load
+var fileSections struct {
+	personas      json.RawMessage
+	personaParams json.RawMessage
+	tenants       json.RawMessage
+}
+
+// fileVariables are the variables the config file set and their values, so loading it
+// again replaces them while leaving those set in the environment alone
+var fileVariables = map[string]string{}
+
+// loadedFile is the path of the config file last loaded
+var loadedFile string
+
+// fileMu guards what the loaded config file set, which a reload replaces
+var fileMu sync.RWMutex
+
+// LoadFile reads a YAML (.yaml, .yml) or TOML (.toml) config file and layers it under
+// the environment: each setting is flattened to its variable name, nested keys joined by
+// underscores (stt: {provider: google} is STT_PROVIDER), and set unless the environment
+// already sets it. Lists become comma-separated. The personas, persona_params and
+// tenants sections are kept as they are, standing in for PERSONAS_FILE,
+// LLM_PERSONA_PARAMS_FILE and TENANTS_FILE when those aren't set. Loading the file again, e.g. on reload,
+// replaces what it set before.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	var personas, personaParams, tenants json.RawMessage
+	variables := make(map[string]string)
+	for key, value := range settings {
+		switch strings.ToLower(key) {
+		case personasSection:
+			if personas, err = json.Marshal(value); err != nil {
+				return fmt.Errorf("%s in %s: %w", key, path, err)
+			}
+		case personaParamsSection:
+			if personaParams, err = json.Marshal(value); err != nil {
+				return fmt.Errorf("%s in %s: %w", key, path, err)
+			}
+		case tenantsSection:
+			if tenants, err = json.Marshal(value); err != nil {
+				return fmt.Errorf("%s in %s: %w", key, path, err)
+			}
+		default:
+			if err := flattenSetting(envName("", key), value, variables); err != nil {
+				return fmt.Errorf("%s in %s: %w", key, path, err)
+			}
+		}
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	fileSections.personas, fileSections.personaParams, fileSections.tenants = personas, personaParams, tenants
+	loadedFile = path
+
+	// Settings dropped from the file since it was last loaded go back to their defaults
+	for name, value := range fileVariables {
+		if _, ok := variables[name]; !ok && os.Getenv(name) == value {
+			os.Unsetenv(name)
+			delete(fileVariables, name)
+		}
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		current, set := os.LookupEnv(name)
+		if previous, ok := fileVariables[name]; set && !(ok && current == previous) {
+			continue // Set in the environment
+		}
+		os.Setenv(name, variables[name])
+		fileVariables[name] = variables[name]
+	}
+	return nil
+}
+
+// envName is the variable of a key under the prefix, e.g. STT_LANGUAGE_CODE for
+// language-code under STT
+func envName(prefix, key string) string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(key))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// flattenSetting adds the variables a setting stands for
+func flattenSetting(name string, value any, variables map[string]string) error {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, nested := range value {
+			if err := flattenSetting(envName(name, key), nested, variables); err != nil {
+				return err
+			}
+		}
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			switch item.(type) {
+			case map[string]any, []any:
+				return fmt.Errorf("%s can only list plain values", name)
+			}
+			items = append(items, scalarSetting(item))
+		}
+		variables[name] = strings.Join(items, ",")
+	case []map[string]any:
+		return fmt.Errorf("%s can only list plain values", name)
+	case nil:
+	default:
+		variables[name] = scalarSetting(value)
+	}
+	return nil
+}
+
+// scalarSetting writes a plain value as its variable would hold it
+func scalarSetting(value any) string {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
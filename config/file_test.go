@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFileLayersUnderTheEnvironment(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.yaml": `
+stt:
+  provider: deepgram
+  language-code: es-US
+tts_language_voices: [es-US=es-US-Neural2-A, fr-FR=fr-FR-Neural2-A]
+call_retention_minutes: 60
+log_level: debug
+personas:
+  - name: calm
+    traits: [warm]
+persona_params:
+  calm:
+    temperature: 0.3
+`,
+		"config.toml": `
+call_retention_minutes = 60
+log_level = "debug"
+tts_language_voices = ["es-US=es-US-Neural2-A", "fr-FR=fr-FR-Neural2-A"]
+
+[stt]
+provider = "deepgram"
+language-code = "es-US"
+
+[[personas]]
+name = "calm"
+traits = ["warm"]
+
+[persona_params.calm]
+temperature = 0.3
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			os.WriteFile(path, []byte(content), 0600)
+			t.Setenv("LOG_LEVEL", "WARN")
+			for _, key := range []string{"STT_PROVIDER", "STT_LANGUAGE_CODE", "TTS_LANGUAGE_VOICES", "CALL_RETENTION_MINUTES", "PERSONAS_FILE"} {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+
+			if err := LoadFile(path); err != nil {
+				t.Fatalf("Expected the config file loaded, got %v", err)
+			}
+			cfg := Load()
+			if cfg.STTProvider != "deepgram" || cfg.STTLanguageCode != "es-US" || cfg.CallRetentionMinutes != 60 {
+				t.Errorf("Expected nested settings flattened, got %q, %q, %d", cfg.STTProvider, cfg.STTLanguageCode, cfg.CallRetentionMinutes)
+			}
+			if len(cfg.TTSLanguageVoices) != 2 || cfg.TTSLanguageVoices[1] != "fr-FR=fr-FR-Neural2-A" {
+				t.Errorf("Expected lists comma-separated, got %v", cfg.TTSLanguageVoices)
+			}
+			if cfg.LogLevel != "WARN" {
+				t.Errorf("Expected the environment to override the file, got %q", cfg.LogLevel)
+			}
+			if string(cfg.Personas) != `[{"name":"calm","traits":["warm"]}]` || string(cfg.LLMPersonaParams) != `{"calm":{"temperature":0.3}}` {
+				t.Errorf("Expected the structured sections as JSON, got %s and %s", cfg.Personas, cfg.LLMPersonaParams)
+			}
+		})
+	}
+}
+
+func TestLoadFileRejectsUnknownFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{}`), 0600)
+	if err := LoadFile(path); err == nil {
+		t.Error("Expected a .json config file rejected")
+	}
+
+	path = filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("stt:\n  - provider: google\n"), 0600)
+	if err := LoadFile(path); err == nil {
+		t.Error("Expected a list of sections rejected")
+	}
+}
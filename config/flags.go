@@ -0,0 +1,277 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Setting is a configuration variable, as documented by its command-line flag
+type Setting struct {
+	Name    string // Environment variable, e.g. STT_PROVIDER
+	Default string // Shown in the help; Load holds the actual default
+	Usage   string
+}
+
+// Settings are the variables Load and the services read, in the order the help lists them
+var Settings = []Setting{
+	// Server and logging
+	{"PORT", "8080", "Port the server listens on"},
+	{"ADMIN_TOKEN", "", "Bearer token of the /admin, conversation, caller and callback endpoints, which are off without one"},
+	{"API_AUTH", "true", "Require an API key or the admin token on the API, all but the health check"},
+	{"API_KEYS", "", "API keys as name=key, comma separated; the name is recorded in the audit log"},
+	{"PARTNER_KEYS", "", "Partner keys as name=key, comma separated, the only keys that register referrals"},
+	{"TRUST_PROXY", "false", "Take client addresses from X-Forwarded-For, behind a load balancer that sets it"},
+	{"RATE_LIMIT_API_PER_MINUTE", "120", "API requests allowed per client IP, 0 for no limit"},
+	{"RATE_LIMIT_API_BURST", "30", "API requests a client IP can make at once"},
+	{"RATE_LIMIT_WEBHOOK_PER_MINUTE", "600", "Twilio webhook requests allowed per Twilio account, or per client IP without signature checks, 0 for no limit"},
+	{"RATE_LIMIT_WEBHOOK_BURST", "100", "Twilio webhook requests an account or client IP can make at once"},
+	{"RATE_LIMIT_CALLS_PER_HOUR", "20", "Calls allowed per caller number, 0 for no limit"},
+	{"RATE_LIMIT_CALLS_BURST", "5", "Calls a caller number can make in a row"},
+	{"RATE_LIMIT_MESSAGE", "We are receiving too many calls from this number right now. Please try again later. If you are in crisis, call or text 988. Goodbye.", "What callers over a rate limit hear before the call ends"},
+	{"AUDIO_URL_SECRET", "", "Signs audio download links; set it, shared by every instance, or links break on restart"},
+	{"AUDIO_URL_TTL_SECONDS", "900", "How long an audio download link works"},
+	{"CALLER_HASH_SECRET", "", "Keys the hashes callers are known by; set it, or hashes can be reversed by trying numbers"},
+	{"CALLER_ALLOWLIST", "", "Only these callers get through: numbers, caller hashes, patterns like +1555* or withheld"},
+	{"CALLER_DENYLIST", "", "Callers refused: numbers, caller hashes, patterns like +1900* or withheld"},
+	{"CALLER_BLOCKED_MESSAGE", "Sorry, this line can't take your call. If you are in crisis, call or text 988. Goodbye.", "What blocked callers hear before the call ends"},
+	{"HEALTH_PROBE_TIMEOUT_MS", "5000", "How long each dependency probe of /health?deep=true may take"},
+	{"HEALTH_PROBE_CACHE_SECONDS", "30", "How long /health?deep=true reuses its probe results"},
+	{"SYNTHETIC_MONITOR_INTERVAL_SECONDS", "0", "How often a synthetic turn is run through STT, the LLM and TTS, 0 never"},
+	{"SYNTHETIC_MONITOR_TIMEOUT_SECONDS", "30", "How long a synthetic turn may take"},
+	{"SYNTHETIC_MONITOR_PHRASE", "I have been feeling stressed about work lately.", "What the synthetic caller says"},
+	{"SYNTHETIC_MONITOR_AUDIO_FILE", "", "Raw 8kHz mu-law audio of the phrase, written from TTS when missing; empty synthesizes it at startup"},
+	{"LOG_LEVEL", "INFO", "DEBUG, INFO, WARN or ERROR"},
+	{"LOG_FORMAT", "text", "text, a line per record, or json, an object per record with level, component, callSid and turn fields"},
+	{"LOG_TRANSCRIPTS", "false", "Log caller utterances and responses in full at DEBUG; otherwise only their length is logged"},
+	{"SENTRY_DSN", "", "Sentry-compatible DSN errors and panics are reported to, none when empty"},
+	{"SENTRY_ENVIRONMENT", "production", "Environment error reports are tagged with"},
+	{"SENTRY_RELEASE", "", "Release error reports are tagged with, e.g. the image tag"},
+	{"AUDIO_OUTPUT_DIR", "saved_audio", "Where response audio is saved for review"},
+
+	// Twilio
+	{"TWILIO_ACCOUNT_SID", "", "Twilio account SID"},
+	{"TWILIO_AUTH_TOKEN", "", "Twilio auth token"},
+	{"TWILIO_PHONE_NUMBER", "", "Twilio number texts are sent from"},
+	{"VOICEMAIL_PHONE_NUMBER", "", "Message-only line: callers leave a voicemail instead of a live session"},
+	{"TRANSFER_PHONE_NUMBER", "", "Where callers asking for a person are put through, empty when no one takes calls"},
+	{"TWILIO_VALIDATE_SIGNATURE", "true", "Refuse Twilio webhooks without a valid X-Twilio-Signature"},
+	{"PUBLIC_BASE_URL", "", "https URL Twilio reaches the service at, e.g. behind a proxy; empty takes it from requests"},
+
+	// Google Cloud
+	{"GOOGLE_PROJECT_ID", "", "Google Cloud project"},
+	{"GOOGLE_APPLICATION_CREDENTIALS", "", "Google Cloud service account key file"},
+	{"SECRETS_REFRESH_SECONDS", "300", "How often secret references on their latest version are fetched again, 0 never"},
+
+	// Saved audio
+	{"AUDIO_FILE_TYPE", "wav", "wav plays in standard players; raw keeps the headerless call audio"},
+
+	// Speech-to-Text
+	{"STT_PROVIDER", "google", "google, deepgram, whisper, assemblyai or azure"},
+	{"STT_LANGUAGE_CODE", "en-US", "Recognition language"},
+	{"STT_MODEL", "telephony", "Recognition model, e.g. telephony, telephony_short, long"},
+	{"STT_ALTERNATIVE_LANGUAGES", "", "Other languages callers may speak, e.g. es-US,fr-CA (Google STT)"},
+	{"STT_LOCATION", "global", "Speech-to-Text V2 region"},
+	{"STT_RECOGNIZER", "_", "Recognizer ID (created if missing) or full resource name; _ for none"},
+	{"STT_ENCODING", "", "Override the encoding negotiated with Twilio (MULAW, LINEAR16)"},
+	{"STT_SAMPLE_RATE", "", "Override the negotiated sample rate"},
+	{"STT_AUTOMATIC_PUNCTUATION", "true", "Have the recognizer punctuate transcripts"},
+	{"STT_INTERIM_RESULTS", "true", "Stream interim results while the caller speaks"},
+	{"STT_MIN_CONFIDENCE", "", "Ask the caller to repeat when a final result is less confident than this (0-1), and ignore such interim results; 0 disables"},
+	{"STT_MIN_STABILITY", "", "Ignore interim results less stable than this (0-1, google), 0 disables"},
+	{"STT_PROFANITY_FILTER", "false", "Have the provider mask profanity (google, deepgram, azure)"},
+	{"STT_KEEPALIVE_MS", "1000", "Send a silence frame after this long without caller audio, 0 disables"},
+
+	// Text-to-Speech
+	{"TTS_PROVIDER", "google", "google, azure, polly or openai"},
+	{"TTS_PROVIDERS", "", "Failover chain tried in order, e.g. google,azure; replaces TTS_PROVIDER"},
+	{"TTS_PROVIDER_TIMEOUT_MS", "5000", "Time each provider in the chain gets before the next is tried"},
+	{"TTS_LANGUAGE_CODE", "", "Synthesis language, defaults to the voice's locale"},
+	{"TTS_VOICE", "", "Google voice name, e.g. en-US-Neural2-F; defaults to en-US-Standard-I"},
+	{"TTS_GENDER", "NEUTRAL", "NEUTRAL, FEMALE or MALE"},
+	{"TTS_EFFECTS_PROFILES", "telephony-class-application", "Google audio effects profiles, comma-separated, or none"},
+	{"TTS_SSML", "false", "Speak with SSML: pauses between sentences, a pace suited to the caller's mood, phone numbers read digit by digit"},
+	{"TTS_VOICE_OPTIONS", "", "Voices callers can pick by keypress, e.g. calm=en-US-Neural2-F,warm=en-US-Neural2-D"},
+	{"TTS_LANGUAGE_VOICES", "", "Voices for callers detected speaking another language, e.g. es=es-US-Neural2-A"},
+	{"TTS_SPEAKING_RATE", "1", "1 is the voice's normal pace; callers who ask us to slow down get a slower rate for the rest of the call"},
+	{"TTS_PITCH", "", "Semitones from the voice's normal pitch (not supported by OpenAI or Polly neural voices)"},
+	{"TTS_PARALLELISM", "3", "Sentences of a response synthesized at once; they still play in order"},
+
+	// LLM generation
+	{"LLM_PROVIDER", "gemini", "gemini, openai, claude or ollama"},
+	{"LLM_RATE_LIMIT", "", "Requests per second across all calls, 0 disables"},
+	{"LLM_BURST", "5", "Requests allowed at once above LLM_RATE_LIMIT"},
+	{"LLM_TEMPERATURE", "0.4", "Sampling temperature, lower is more consistent"},
+	{"LLM_TOP_P", "", "Nucleus sampling, unset for the provider's default"},
+	{"LLM_TOP_K", "", "Top-k sampling, Gemini and Claude only"},
+	{"LLM_MAX_OUTPUT_TOKENS", "", "Longest response, overrides ANTHROPIC_MAX_TOKENS"},
+	{"GEMINI_SAFETY_THRESHOLDS", "", "Block threshold, e.g. BLOCK_ONLY_HIGH, or category=threshold pairs"},
+	{"LLM_PERSONA_PARAMS_FILE", "", "JSON of per-persona overrides of the generation params"},
+	{"LLM_FALLBACK_MODEL", "", "Model retried when the primary fails or is slow, e.g. gemini-1.5-flash"},
+	{"LLM_LATENCY_BUDGET_MS", "8000", "Time the primary model has to answer, or start streaming, before the fallback"},
+	{"LLM_HISTORY_TOKENS", "3000", "Budget for the history sent with each request, 0 sends it all"},
+	{"LLM_HISTORY_KEEP_MESSAGES", "6", "Latest messages always sent verbatim"},
+	{"LLM_HISTORY_SUMMARIZE", "true", "Fold older messages into a running summary instead of dropping them"},
+	{"CALL_SUMMARY_ENABLED", "true", "Summarize each call with the LLM once it ends"},
+	{"SESSION_NOTES_ENABLED", "true", "Write SOAP session notes on each call for clinicians once it ends"},
+	{"LLM_CALL_MAX_TOKENS", "", "Per-call token ceiling, 0 for none"},
+	{"LLM_CALL_MAX_COST", "", "Per-call cost ceiling, priced with LLM_PROMPT_PRICE and LLM_RESPONSE_PRICE per 1000 tokens"},
+	{"LLM_PROMPT_PRICE", "", "Cost of 1000 prompt tokens, for LLM_CALL_MAX_COST"},
+	{"LLM_RESPONSE_PRICE", "", "Cost of 1000 response tokens, for LLM_CALL_MAX_COST"},
+	{"LLM_BUDGET_WARN_FRACTION", "0.8", "Share of a call ceiling at which responses are shortened"},
+	{"LLM_BUDGET_MODEL", "", "Model answering calls over a ceiling, e.g. gemini-1.5-flash"},
+	{"LLM_BUDGET_MAX_OUTPUT_TOKENS", "100", "Response cap near and over a call ceiling"},
+
+	// Guardrails, tools and translation
+	{"GUARDRAILS_ENABLED", "true", "Check responses for unsafe advice, medical and legal claims and length"},
+	{"GUARDRAIL_MAX_CHARS", "600", "Longest response spoken, cut at a sentence boundary"},
+	{"LLM_TOOLS_ENABLED", "false", "Let Gemini look up resources, schedule callbacks and text the caller"},
+	{"LLM_STRUCTURED_OUTPUT", "false", "Ask the LLM for structured replies with a response schema"},
+	{"CRISIS_RESOURCES_FILE", "", "JSON list of local crisis resources for lookups"},
+	{"TRANSLATION_ENABLED", "false", "Talk with callers in STT_LANGUAGE_CODE while the LLM works in the pivot language; responses are not streamed"},
+	{"TRANSLATION_PIVOT_LANGUAGE", "en-US", "Language the LLM works in with TRANSLATION_ENABLED"},
+
+	// Call audio and turn-taking
+	{"TURN_FINAL_GRACE_MS", "700", "Wait after a final transcript before answering, unless the STT signals end of speech"},
+	{"RECORD_CALLER_AUDIO", "false", "Offer to record the caller's audio to AUDIO_OUTPUT_DIR; only callers who press 1 are recorded"},
+	{"RECORDING_CONSENT_NOTICE", "To help us improve this service, we would like to record your side of this call. Press 1 to allow recording, or stay on the line to continue without it.", "What callers hear before the recording choice"},
+	{"AUDIO_PREPROCESS", "false", "Remove DC offset, gate background noise and normalize level before STT"},
+	{"AUDIO_TARGET_LEVEL", "3000", "RMS level (16-bit PCM) speech is normalized toward"},
+	{"AUDIO_MAX_GAIN", "8", "Most a quiet caller is amplified"},
+	{"AUDIO_NOISE_GATE", "100", "RMS level below which audio is attenuated as background noise"},
+	{"VAD_ENABLED", "false", "Only stream speech to the STT provider, skipping long silences"},
+	{"VAD_THRESHOLD", "300", "Minimum RMS level (16-bit PCM) counted as speech"},
+	{"VAD_HANGOVER_MS", "800", "Keep streaming this long after speech stops"},
+	{"VAD_PREROLL_MS", "300", "Audio from just before speech onset that is sent with it"},
+	{"FALLBACK_PHRASES_FILE", "", "JSON of language -> failure type -> phrases, overriding the built-ins"},
+	{"FALLBACK_AUDIO_DIR", "fallback_audio", "Pre-synthesized fallback audio; files found here play without a TTS call, missing ones are synthesized at startup"},
+	{"BACKCHANNEL_DELAY_MS", "1500", "Play a short acknowledgement when a response takes longer than this, 0 disables it"},
+
+	// Storage and retention
+	{"MASKED_TERMS_FILE", "", "Terms to mask in stored transcripts, one per line (# for comments)"},
+	{"CALL_RETENTION_MINUTES", "1440", "Drop an ended call's conversation and channels from memory this long after it ends, 0 keeps them"},
+	{"CALL_SWEEP_INTERVAL_SECONDS", "60", "How often ended calls are checked for eviction"},
+	{"REFERRAL_TTL_HOURS", "72", "Drop a partner referral if the caller hasn't called this long after it was registered, 0 keeps it"},
+	{"CALLBACK_RETENTION_HOURS", "168", "Drop a queued callback offer this long after it was queued, 0 keeps it until the queue is full"},
+	{"TRANSCRIPT_ARCHIVE_DIR", "", "Archive evicted calls' session records here, empty archives nothing"},
+	{"TRANSCRIPT_ENCRYPTION_KEY", "", "Base64 AES key (16, 24 or 32 bytes) encrypting archived records"},
+	{"AUDIO_ENCRYPTION_KEY", "", "Base64 AES key (16, 24 or 32 bytes) encrypting saved responses and recordings"},
+	{"STORAGE_BACKEND", "disk", "Where session records and caller profiles are kept: disk, firestore or sql"},
+	{"FIRESTORE_DATABASE", "(default)", "Firestore database of GOOGLE_PROJECT_ID, with STORAGE_BACKEND=firestore"},
+	{"FIRESTORE_COLLECTION_PREFIX", "", "Prepended to the conversations and callers collections, e.g. staging_"},
+	{"DATABASE_DRIVER", "postgres", "database/sql driver of SQL storage backends"},
+	{"DATABASE_URL", "", "SQL database migrated at startup, where STORAGE_BACKEND=sql keeps records; empty for none"},
+
+	// Call events and exports
+	{"WEBHOOK_URLS", "", "Comma-separated URLs call events are POSTed to"},
+	{"WEBHOOK_SECRET", "", "Signs every event, required with WEBHOOK_URLS"},
+	{"WEBHOOK_MAX_ATTEMPTS", "5", "Deliveries failing with a network error, 429 or 5xx are retried with backoff"},
+	{"WEBHOOK_TIMEOUT_MS", "5000", "How long each delivery attempt can take"},
+	{"PUBSUB_TOPIC", "", "Also publish call events to this Pub/Sub topic, a name in GOOGLE_PROJECT_ID or projects/<project>/topics/<name>"},
+	{"PUBSUB_MAX_ATTEMPTS", "5", "Attempts at each publish, retried with backoff"},
+	{"SLO_GENERATION_MS", "0", "Latency SLO of LLM responses, 0 for none"},
+	{"SLO_SYNTHESIS_MS", "0", "Latency SLO of a response's first audio, 0 for none"},
+	{"SLO_OBJECTIVE", "0.95", "Share of turns that should stay within the SLOs"},
+	{"SLO_WINDOW_MINUTES", "60", "Rolling window SLO compliance is tracked over"},
+	{"SLO_BURN_RATE_ALERT", "2", "Alert when the error budget burns this many times faster than sustainable"},
+	{"SLO_SLACK_WEBHOOK_URL", "", "Slack incoming webhook SLO alerts are posted to, besides slo.alert events"},
+	{"AUDIT_LOG_FILE", "audit.jsonl", "Append-only file of administrative and operator actions; empty keeps them in memory only"},
+	{"BIGQUERY_TABLE", "", "Stream ended calls into this table, as dataset.table of GOOGLE_PROJECT_ID; empty exports nothing"},
+	{"BIGQUERY_TRANSCRIPTS", "none", "Transcripts in exported rows: none, redacted (emails, phone numbers and names removed) or full"},
+	{"BIGQUERY_BATCH_SIZE", "50", "Rows per insert"},
+	{"BIGQUERY_FLUSH_SECONDS", "10", "Partial batches are inserted this often"},
+	{"BIGQUERY_MAX_ATTEMPTS", "5", "Attempts at each insert, retried with backoff"},
+
+	// Phrase sets, prompts and personas
+	{"PHRASE_SETS_FILE", "", "JSON of language -> persona -> {\"boost\", \"phrases\"} to bias recognition"},
+	{"PHRASE_SETS_RELOAD_SECONDS", "30", "How often the file is checked for changes"},
+	{"PROMPTS_DIR", "prompts", "Templates of the therapist's system prompt, one <persona>.tmpl each"},
+	{"PROMPTS_RELOAD_SECONDS", "30", "How often the templates are checked for changes"},
+	{"PERSONA_NAME", "", "Name the therapist goes by, available to templates as {{.PersonaName}}"},
+	{"PERSONAS_FILE", "", "JSON array of personas callers can talk with"},
+	{"TENANTS_FILE", "", "JSON array of tenants served from this process, each with its Twilio account and numbers"},
+
+	// Speech providers
+	{"DEEPGRAM_API_KEY", "", "Required when STT_PROVIDER=deepgram"},
+	{"DEEPGRAM_MODEL", "nova-2-phonecall", "Deepgram model"},
+	{"DEEPGRAM_URL", "wss://api.deepgram.com/v1/listen", "Deepgram streaming endpoint"},
+	{"WHISPER_API_KEY", "", "Whisper API key, defaults to OPENAI_API_KEY; not needed for a local whisper.cpp server"},
+	{"WHISPER_MODEL", "whisper-1", "Whisper model"},
+	{"WHISPER_URL", "https://api.openai.com/v1/audio/transcriptions", "Whisper transcription endpoint, e.g. a local whisper.cpp server"},
+	{"WHISPER_SEGMENT_SECONDS", "8", "Longest segment sent per request; shorter segments are cut at pauses"},
+	{"ASSEMBLYAI_API_KEY", "", "Required when STT_PROVIDER=assemblyai"},
+	{"ASSEMBLYAI_URL", "wss://streaming.assemblyai.com/v3/ws", "AssemblyAI streaming endpoint"},
+	{"ASSEMBLYAI_REST_URL", "https://api.assemblyai.com", "AssemblyAI REST API"},
+	{"AZURE_SPEECH_KEY", "", "Required when STT_PROVIDER or TTS_PROVIDER is azure"},
+	{"AZURE_SPEECH_REGION", "", "Azure Speech region, e.g. westeurope"},
+	{"AZURE_TTS_VOICE", "en-US-JennyNeural", "Azure neural voice"},
+	{"AZURE_TTS_STYLE", "", "Neural voice speaking style, e.g. empathetic; not every voice has every style"},
+
+	// LLM providers
+	{"GEMINI_MODEL", "gemini-1.5-pro", "Gemini model"},
+	{"GEMINI_API_KEY", "", "Google AI Studio API key, with GEMINI_BACKEND=studio"},
+	{"GEMINI_BACKEND", "studio", "studio (API key) or vertex (service account)"},
+	{"VERTEX_PROJECT_ID", "", "Project of the Vertex AI endpoint, defaults to GOOGLE_PROJECT_ID"},
+	{"VERTEX_LOCATION", "us-central1", "Region of the Vertex AI endpoint"},
+	{"VERTEX_REQUEST_TYPE", "", "Provisioned throughput: dedicated only uses it, shared skips it, empty uses it first"},
+	{"ANTHROPIC_API_KEY", "", "Required when LLM_PROVIDER=claude"},
+	{"ANTHROPIC_MODEL", "claude-3-5-sonnet-latest", "Claude model"},
+	{"ANTHROPIC_MAX_TOKENS", "1024", "Longest response; keep it short enough to speak"},
+	{"ANTHROPIC_URL", "https://api.anthropic.com/v1/messages", "Anthropic Messages API endpoint"},
+	{"OLLAMA_URL", "http://localhost:11434/v1/chat/completions", "Any OpenAI-compatible chat endpoint"},
+	{"OLLAMA_MODEL", "llama3.1", "Ollama model"},
+	{"OLLAMA_API_KEY", "", "Only for servers behind an authenticating proxy"},
+	{"OPENAI_API_KEY", "", "Required when TTS_PROVIDER=openai or LLM_PROVIDER=openai"},
+	{"OPENAI_MODEL", "gpt-4o-mini", "Chat model when LLM_PROVIDER=openai, which also needs OPENAI_API_KEY"},
+	{"OPENAI_CHAT_URL", "https://api.openai.com/v1/chat/completions", "OpenAI chat completions endpoint"},
+	{"OPENAI_TTS_MODEL", "tts-1", "OpenAI speech model"},
+	{"OPENAI_TTS_VOICE", "alloy", "OpenAI voice"},
+	{"OPENAI_TTS_URL", "https://api.openai.com/v1/audio/speech", "OpenAI speech endpoint"},
+
+	// Amazon Polly
+	{"AWS_REGION", "", "AWS region of Polly, defaults to AWS_DEFAULT_REGION; required with TTS_PROVIDER=polly"},
+	{"AWS_ACCESS_KEY_ID", "", "AWS access key for Polly"},
+	{"AWS_SECRET_ACCESS_KEY", "", "AWS secret key for Polly"},
+	{"AWS_SESSION_TOKEN", "", "AWS session token, for temporary credentials"},
+	{"POLLY_VOICE", "Joanna", "Polly voice"},
+	{"POLLY_ENGINE", "neural", "neural or standard"},
+}
+
+// FlagName is the command-line flag of a variable: lower case with dashes, e.g.
+// stt-provider for STT_PROVIDER
+func FlagName(variable string) string {
+	return strings.ToLower(strings.ReplaceAll(variable, "_", "-"))
+}
+
+// flagVariables are the variables set on the command line
+var flagVariables sync.Map
+
+// envFlag sets its variable when given on the command line
+type envFlag string
+
+func (f envFlag) String() string { return "" }
+
+func (f envFlag) Set(value string) error {
+	flagVariables.Store(string(f), value)
+	return os.Setenv(string(f), value)
+}
+
+// boolEnvFlag is an envFlag that can be given as a switch, -vad-enabled for true
+type boolEnvFlag struct{ envFlag }
+
+func (f boolEnvFlag) IsBoolFlag() bool { return true }
+
+// RegisterFlags defines a flag for every setting on the flag set. A flag given on the
+// command line sets its variable, so it overrides the environment and the config file.
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, setting := range Settings {
+		var value flag.Value = envFlag(setting.Name)
+		if setting.Default == "true" || setting.Default == "false" {
+			value = boolEnvFlag{envFlag(setting.Name)}
+		}
+		name := FlagName(setting.Name)
+		fs.Var(value, name, setting.Usage+" (env "+setting.Name+")")
+		fs.Lookup(name).DefValue = setting.Default
+	}
+}
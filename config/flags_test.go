@@ -0,0 +1,52 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestFlagsOverrideTheEnvironment(t *testing.T) {
+	t.Setenv("STT_PROVIDER", "google")
+	t.Setenv("VAD_ENABLED", "")
+	t.Setenv("CALL_RETENTION_MINUTES", "")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"-stt-provider", "deepgram", "-vad-enabled", "-call-retention-minutes=60"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	cfg := Load()
+	if cfg.STTProvider != "deepgram" || !cfg.VADEnabled || cfg.CallRetentionMinutes != 60 {
+		t.Errorf("Expected the flags to be loaded, got %q, %v, %d", cfg.STTProvider, cfg.VADEnabled, cfg.CallRetentionMinutes)
+	}
+	if f := fs.Lookup("tts-provider"); f == nil || f.DefValue != "google" {
+		t.Errorf("Expected the default documented, got %+v", f)
+	}
+}
+
+func TestEverySettingHasAFlag(t *testing.T) {
+	src, err := os.ReadFile("config.go")
+	if err != nil {
+		t.Fatalf("Failed to read config.go: %v", err)
+	}
+	documented := make(map[string]bool)
+	for _, setting := range Settings {
+		if documented[setting.Name] {
+			t.Errorf("%s is listed twice", setting.Name)
+		}
+		documented[setting.Name] = true
+	}
+
+	// AWS_DEFAULT_REGION is only the fallback of AWS_REGION
+	read := regexp.MustCompile(`(?:getEnv\w*|os\.Getenv)\("([A-Z0-9_]+)"`)
+	for _, match := range read.FindAllStringSubmatch(string(src), -1) {
+		if name := match[1]; !documented[name] && name != "AWS_DEFAULT_REGION" {
+			t.Errorf("%s has no flag, add it to Settings", name)
+		}
+	}
+}
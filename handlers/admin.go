@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// ConfigResponse is the configuration the running instance loaded, credentials masked
+type ConfigResponse struct {
+	ConfigFile string                    `json:"configFile,omitempty"`
+	Settings   []config.EffectiveSetting `json:"settings"`
+}
+
+// GetConfig reports every setting's effective value and where it came from: a flag, the
+// environment, the config file, Secret Manager or the default
+func GetConfig() http.HandlerFunc {
+	log := logger.Component("AdminHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := ConfigResponse{ConfigFile: config.LoadedFile(), Settings: config.Effective()}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// ReloadConfig reloads the settings that can change without a restart, as SIGHUP does,
+// and reports what was picked up. Calls in progress carry on.
+func ReloadConfig(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("AdminHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if svc.Reloader == nil {
+			http.Error(w, "Reloading is not available", http.StatusServiceUnavailable)
+			return
+		}
+		report, err := svc.Reloader.Reload()
+		if err != nil {
+			http.Error(w, "Failed to reload the config file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// LogLevelRequest changes the log level, of every component or of some. A component set
+// to "" or "default" logs at the overall level again.
+type LogLevelRequest struct {
+	Level      string            `json:"level,omitempty"`
+	Components map[string]string `json:"components,omitempty"` // Component to level, e.g. "TurnEngine": "DEBUG"
+}
+
+// LogLevelResponse is the overall log level and the components logging at another one
+type LogLevelResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+// logLevels reports the default logger's levels
+func logLevels() LogLevelResponse {
+	response := LogLevelResponse{
+		Level:      logger.GetDefaultLogger().Level().String(),
+		Components: make(map[string]string),
+	}
+	for component, level := range logger.ComponentLevels() {
+		response.Components[component] = level.String()
+	}
+	return response
+}
+
+// GetLogLevel reports the log level and the components' overrides of it
+func GetLogLevel() http.HandlerFunc {
+	log := logger.Component("AdminHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(logLevels()); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// SetLogLevel changes the log level, or a component's, in place: calls in progress carry
+// on and pick up the new level. The change lasts until the next restart or reload.
+func SetLogLevel() http.HandlerFunc {
+	log := logger.Component("AdminHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LogLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Level == "" && len(req.Components) == 0 {
+			http.Error(w, "A level or component levels are required", http.StatusBadRequest)
+			return
+		}
+
+		// Check every level before changing any
+		level, ok := logger.ParseLevel(req.Level)
+		if req.Level != "" && !ok {
+			http.Error(w, "Unknown log level "+req.Level, http.StatusBadRequest)
+			return
+		}
+		components := make(map[string]logger.Level, len(req.Components))
+		for component, name := range req.Components {
+			if component == "" {
+				http.Error(w, "Component names can't be empty", http.StatusBadRequest)
+				return
+			}
+			if name == "" || strings.EqualFold(name, "default") {
+				continue
+			}
+			componentLevel, ok := logger.ParseLevel(name)
+			if !ok {
+				http.Error(w, "Unknown log level "+name+" for "+component, http.StatusBadRequest)
+				return
+			}
+			components[component] = componentLevel
+		}
+
+		if req.Level != "" {
+			logger.SetLevel(level)
+			log.Warn("Log level set to %s", level)
+		}
+		for component := range req.Components {
+			if componentLevel, ok := components[component]; ok {
+				logger.SetComponentLevel(component, componentLevel)
+				log.Warn("Log level of %s set to %s", component, componentLevel)
+			} else {
+				logger.ClearComponentLevel(component)
+				log.Warn("Log level of %s reset to the default", component)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(logLevels()); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// AuditLogResponse is the audit entries matching a query, newest first
+type AuditLogResponse struct {
+	Entries []services.AuditEntry `json:"entries"`
+}
+
+// GetAuditLog lists audit entries by ?actor=, ?action=, ?target=, ?callSid=, ?from=, ?to=
+// and ?limit=
+func GetAuditLog(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("AdminHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		filter := services.AuditFilter{
+			Actor:   params.Get("actor"),
+			Action:  params.Get("action"),
+			Target:  params.Get("target"),
+			CallSID: params.Get("callSid"),
+		}
+		var err error
+		if filter.From, err = parseListTime(params.Get("from"), false); err != nil {
+			http.Error(w, "Invalid from: use an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		if filter.To, err = parseListTime(params.Get("to"), true); err != nil {
+			http.Error(w, "Invalid to: use an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		if limit := params.Get("limit"); limit != "" {
+			if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+				http.Error(w, "Invalid limit: use a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+
+		response := AuditLogResponse{Entries: svc.Audit.Query(filter)}
+		if response.Entries == nil {
+			response.Entries = []services.AuditEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
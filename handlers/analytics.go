@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// defaultAnalyticsDays is the range reported when ?from= isn't given
+const defaultAnalyticsDays = 30
+
+// GetAnalytics handles the GET /analytics endpoint. ?from= and ?to= take RFC 3339 times or
+// dates, a ?to= date including the whole day, and default to the last 30 days; ?interval=
+// buckets calls by day or week. Calls evicted from memory are counted from the storage of
+// the tenant named in the request.
+func GetAnalytics(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("AnalyticsHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		query := services.AnalyticsQuery{Interval: params.Get("interval")}
+		var err error
+		if query.From, err = parseListTime(params.Get("from"), false); err != nil {
+			http.Error(w, "Invalid from: use an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		if query.To, err = parseListTime(params.Get("to"), true); err != nil {
+			http.Error(w, "Invalid to: use an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		switch query.Interval {
+		case "":
+			query.Interval = services.AnalyticsDaily
+		case services.AnalyticsDaily, services.AnalyticsWeekly:
+		default:
+			http.Error(w, "Invalid interval: use day or week", http.StatusBadRequest)
+			return
+		}
+		if query.To.IsZero() {
+			query.To = time.Now()
+		}
+		if query.From.IsZero() {
+			query.From = query.To.UTC().Truncate(24*time.Hour).AddDate(0, 0, -defaultAnalyticsDays+1)
+		}
+		if !query.From.Before(query.To) {
+			http.Error(w, "Invalid range: from must be before to", http.StatusBadRequest)
+			return
+		}
+		if query.To.Sub(query.From) > 366*24*time.Hour {
+			http.Error(w, "Invalid range: report on up to a year at a time", http.StatusBadRequest)
+			return
+		}
+
+		store, ok := tenantStore(svc, w, r)
+		if !ok {
+			return
+		}
+		report, err := svc.Analytics.Report(query, store)
+		if err != nil {
+			log.Error("Failed to read archived calls for analytics: %v", err)
+			http.Error(w, "Failed to read archived conversations", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// APIVersion is the version of the HTTP API served under /api/v1
+const APIVersion = "1.0.0"
+
+// Route describes one API endpoint; the OpenAPI document and validation are generated from it
+type Route struct {
+	Method  string
+	Path    string // Relative to the API prefix, with {name} path parameters
+	Summary string
+	Tag     string
+	// Request is a zero value of the JSON request body type, nil when there is no body
+	Request interface{}
+	// Response is a zero value of the JSON response body type, nil for non-JSON responses
+	Response interface{}
+	// Status is the success status code, 200 when unset
+	Status int
+	// Produces is the content type of non-JSON responses, e.g. audio downloads, or several
+	// separated by commas
+	Produces string
+	// Admin routes are for operators: they need the admin token as a bearer token
+	Admin bool
+	// Public routes, e.g. the health check, need no API key when the API requires one
+	Public bool
+	// Partner routes, e.g. referrals, need one of the PartnerKeys instead of an API key
+	Partner bool
+	// Audit is the action requests are recorded as in the audit log, e.g. transcript.read;
+	// empty leaves them out
+	Audit   string
+	Handler http.HandlerFunc
+}
+
+// API is a versioned group of routes that documents and validates itself
+type API struct {
+	prefix string
+	routes []Route
+	mux    *http.ServeMux
+	// AdminToken is the bearer token of admin routes, which are off when it's empty
+	AdminToken string
+	// RequireAuth makes every route that isn't Public need one of the Keys, or the admin
+	// token; without either set, those routes are unavailable
+	RequireAuth bool
+	// Keys are the API keys by the name of who holds them, which is who the audit log
+	// records as making their requests
+	Keys map[string]string
+	// PartnerKeys are the keys of partner routes, by who holds them, which are taken
+	// nowhere else; API keys aren't taken on partner routes
+	PartnerKeys map[string]string
+	// Audit records the requests of routes with an audit action; nil records nothing
+	Audit *services.AuditLog
+	// Limiter limits requests per client IP, taken from X-Forwarded-For with TrustProxy;
+	// nil limits nothing
+	Limiter    *services.RateLimiter
+	TrustProxy bool
+	log        *logger.Logger
+}
+
+// NewAPI creates an API whose routes are registered on the mux under prefix
+func NewAPI(mux *http.ServeMux, prefix string) *API {
+	return &API{
+		prefix: strings.TrimSuffix(prefix, "/"),
+		mux:    mux,
+		log:    logger.Component("API"),
+	}
+}
+
+// Handle registers a route behind the validation middleware
+func (a *API) Handle(route Route) {
+	if route.Status == 0 {
+		route.Status = http.StatusOK
+	}
+	a.routes = append(a.routes, route)
+	handler := a.validate(route)
+	if route.Admin {
+		handler = a.requireAdmin(handler)
+	} else if !route.Public {
+		handler = a.requireKey(route.Partner, handler)
+	}
+	if route.Audit != "" {
+		handler = a.audit(route, handler)
+	}
+	handler = a.limit(handler)
+	a.mux.HandleFunc(route.Method+" "+a.prefix+route.Path, handler)
+}
+
+// requireAdmin lets through requests carrying the admin token; without a token set,
+// admin routes are unavailable
+func (a *API) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.AdminToken == "" {
+			http.Error(w, "Admin endpoints are disabled, set ADMIN_TOKEN to enable them", http.StatusForbidden)
+			return
+		}
+		if !a.isAdmin(r) {
+			a.log.Warn("Rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limit refuses requests over the client's rate limit before anything else is done with
+// them, so a flood doesn't fill the audit log either
+func (a *API) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := a.Limiter.Allow(clientIP(r, a.TrustProxy)); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether the request carries the admin token
+func (a *API) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && a.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.AdminToken)) == 1
+}
+
+// requireKey lets through requests carrying an API key, or a partner key on partner
+// routes, or the admin token, as who holds it, when the API requires them
+func (a *API) requireKey(partner bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.RequireAuth {
+			next(w, r)
+			return
+		}
+		keys, disabled := a.Keys, "The API is disabled, set API_KEYS or ADMIN_TOKEN to enable it"
+		if partner {
+			keys, disabled = a.PartnerKeys, "Partner endpoints are disabled, set PARTNER_KEYS or ADMIN_TOKEN to enable them"
+		}
+		if a.AdminToken == "" && len(keys) == 0 {
+			http.Error(w, disabled, http.StatusForbidden)
+			return
+		}
+		actor := a.identify(r, keys)
+		if actor == "" {
+			a.log.Warn("Rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(services.WithActor(r.Context(), actor)))
+	}
+}
+
+// identify returns who holds the admin token or one of the keys the request carries, as a
+// bearer token or an X-API-Key header; empty when it carries neither
+func (a *API) identify(r *http.Request, keys map[string]string) string {
+	if a.isAdmin(r) {
+		return "admin"
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.Header.Get("X-API-Key")
+	}
+	if token == "" {
+		return ""
+	}
+	for name, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// ParseAPIKeys parses API keys given as name=key, e.g. "dashboard=k3y", into keys by name
+func ParseAPIKeys(entries []string) (map[string]string, error) {
+	keys := make(map[string]string, len(entries))
+	for i, entry := range entries {
+		name, key, ok := strings.Cut(entry, "=")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			// Not quoted, it may be a key
+			return nil, fmt.Errorf("entry %d is not name=key", i+1)
+		}
+		if _, ok := keys[name]; ok {
+			return nil, fmt.Errorf("%s has more than one key", name)
+		}
+		if name == "admin" || name == "anonymous" {
+			return nil, fmt.Errorf("%s is reserved", name)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// audit records the route's requests in the audit log once they're handled, including
+// those that were denied
+func (a *API) audit(route Route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		a.Audit.Record(services.AuditEntry{
+			Actor:   a.actor(r),
+			Action:  route.Audit,
+			Target:  strings.TrimPrefix(r.URL.Path, a.prefix),
+			CallSID: r.PathValue("callSid"),
+			Status:  recorder.status,
+			Details: map[string]string{"method": r.Method, "remoteAddr": r.RemoteAddr},
+		})
+	}
+}
+
+// actor is who made the request: the actor set on its context, who holds the API key or
+// admin token it carries, anonymous otherwise
+func (a *API) actor(r *http.Request) string {
+	if actor := services.ActorFromContext(r.Context()); actor != "" {
+		return actor
+	}
+	if actor := a.identify(r, a.Keys); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// statusRecorder passes a response through while keeping its status
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// validationError is the body returned for requests that don't match the contract
+type validationError struct {
+	Error   string   `json:"error"`
+	Details []string `json:"details,omitempty"`
+}
+
+// writeValidationError rejects a request with a JSON error body
+func writeValidationError(w http.ResponseWriter, status int, message string, details []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(validationError{Error: message, Details: details})
+}
+
+// validate checks request bodies against the route's schema before calling the handler,
+// and logs JSON responses that drift from the documented schema
+func (a *API) validate(route Route) http.HandlerFunc {
+	var requestSchema, responseSchema *Schema
+	if route.Request != nil {
+		requestSchema = SchemaFor(route.Request)
+	}
+	if route.Response != nil {
+		responseSchema = SchemaFor(route.Response)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestSchema != nil {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				writeValidationError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json", nil)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if err != nil {
+				writeValidationError(w, http.StatusRequestEntityTooLarge, "Request body too large", nil)
+				return
+			}
+
+			var decoded interface{}
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				writeValidationError(w, http.StatusBadRequest, "Invalid JSON body", []string{err.Error()})
+				return
+			}
+			if problems := requestSchema.Validate(decoded); len(problems) > 0 {
+				a.log.Warn("Rejected %s %s: %s", r.Method, r.URL.Path, strings.Join(problems, "; "))
+				writeValidationError(w, http.StatusBadRequest, "Request does not match the API schema", problems)
+				return
+			}
+
+			// Let the handler decode the body again
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if responseSchema == nil {
+			route.Handler(w, r)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		route.Handler(recorder, r)
+
+		if recorder.status != route.Status || recorder.body.Len() == 0 {
+			return
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(recorder.body.Bytes(), &decoded); err != nil {
+			a.log.Warn("Response for %s %s is not valid JSON: %v", r.Method, r.URL.Path, err)
+			return
+		}
+		if problems := responseSchema.Validate(decoded); len(problems) > 0 {
+			a.log.Warn("Response for %s %s does not match the API schema: %s",
+				r.Method, r.URL.Path, strings.Join(problems, "; "))
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy for validation
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Spec generates the OpenAPI 3 document for the registered routes
+func (a *API) Spec() map[string]interface{} {
+	paths := make(map[string]map[string]interface{})
+
+	for _, route := range a.routes {
+		operation := map[string]interface{}{
+			"summary": route.Summary,
+			"tags":    []string{route.Tag},
+		}
+
+		var parameters []map[string]interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   &Schema{Type: "string"},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": SchemaFor(route.Request)},
+				},
+			}
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(route.Status)}
+		switch {
+		case route.Response != nil:
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": SchemaFor(route.Response)},
+			}
+		case route.Produces != "":
+			content := map[string]interface{}{}
+			for _, contentType := range strings.Split(route.Produces, ",") {
+				content[strings.TrimSpace(contentType)] = map[string]interface{}{"schema": &Schema{Type: "string", Format: "binary"}}
+			}
+			success["content"] = content
+		}
+		responses := map[string]interface{}{strconv.Itoa(route.Status): success}
+		if route.Request != nil {
+			responses["400"] = map[string]interface{}{
+				"description": "Request does not match the API schema",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": SchemaFor(validationError{})},
+				},
+			}
+		}
+		if route.Admin {
+			operation["security"] = []map[string][]string{{"adminToken": {}}}
+			responses["401"] = map[string]interface{}{"description": "Missing or wrong admin token"}
+		} else if a.RequireAuth && route.Partner {
+			operation["security"] = []map[string][]string{{"partnerKey": {}}, {"adminToken": {}}}
+			responses["401"] = map[string]interface{}{"description": "Missing or wrong partner key"}
+		} else if a.RequireAuth && !route.Public {
+			operation["security"] = []map[string][]string{{"apiKey": {}}, {"bearerKey": {}}}
+			responses["401"] = map[string]interface{}{"description": "Missing or wrong API key"}
+		}
+		if a.Limiter != nil {
+			responses["429"] = map[string]interface{}{"description": "Too many requests, retry after the Retry-After seconds"}
+		}
+		operation["responses"] = responses
+
+		path := a.prefix + route.Path
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Call Me Help API",
+			"version": APIVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearerKey":  map[string]interface{}{"type": "http", "scheme": "bearer", "description": "An API key, or the admin token, as a bearer token"},
+				"partnerKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "A partner key, also taken as a bearer token"},
+			},
+		},
+	}
+}
+
+// ServeSpec handles the GET openapi.json endpoint
+func (a *API) ServeSpec() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.Spec()); err != nil {
+			a.log.Error("Error encoding OpenAPI document: %v", err)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -13,22 +16,29 @@ import (
 
 	"github.com/ghophp/call-me-help/config"
 	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
 )
 
 // AudioFile represents metadata about a saved audio file
 type AudioFile struct {
-	Filename    string    `json:"filename"`
-	CallSID     string    `json:"callSid"`
-	Timestamp   time.Time `json:"timestamp"`
-	Text        string    `json:"text"`
-	SizeBytes   int64     `json:"sizeBytes"`
-	DownloadURL string    `json:"downloadUrl"`
+	Filename  string    `json:"filename"`
+	CallSID   string    `json:"callSid"`
+	Timestamp time.Time `json:"timestamp"`
+	Text      string    `json:"text"`
+	SizeBytes int64     `json:"sizeBytes"`
+	// DownloadURL is signed and stops working after AUDIO_URL_TTL_SECONDS
+	DownloadURL string `json:"downloadUrl"`
 }
 
-// ListAudioFiles handles the GET /audio endpoint to list all saved audio files
-func ListAudioFiles() http.HandlerFunc {
+// audioDownloadPath is the API path of a saved audio file, which its links are signed for
+func audioDownloadPath(filename string) string {
+	return "/audio/download/" + filename
+}
+
+// ListAudioFiles handles the GET /audio endpoint to list all saved audio files, each with
+// a signed, expiring download link
+func ListAudioFiles(svc *services.ServiceContainer, cfg *config.Config) http.HandlerFunc {
 	log := logger.Component("AudioHandler")
-	cfg := config.Load()
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Info("Listing audio files")
@@ -58,15 +68,16 @@ func ListAudioFiles() http.HandlerFunc {
 				return nil
 			}
 
-			// Skip files that don't have .raw extension
-			if !strings.HasSuffix(info.Name(), ".raw") {
+			// Skip files that aren't saved audio
+			ext := filepath.Ext(info.Name())
+			if ext != ".raw" && ext != ".wav" {
 				return nil
 			}
 
 			// Parse filename to extract metadata
-			// Format is: {callSID}_{timestamp}_{text}.raw
+			// Format is: {callSID}_{timestamp}_{text}.wav, or .raw for headerless audio
 			filename := info.Name()
-			parts := strings.SplitN(strings.TrimSuffix(filename, ".raw"), "_", 3)
+			parts := strings.SplitN(strings.TrimSuffix(filename, ext), "_", 3)
 
 			if len(parts) < 3 {
 				log.Warn("Skipping file with invalid format: %s", filename)
@@ -86,13 +97,9 @@ func ListAudioFiles() http.HandlerFunc {
 			// Get the text part
 			text := parts[2]
 
-			// Create download URL
-			host := r.Host
-			scheme := "http"
-			if r.TLS != nil {
-				scheme = "https"
-			}
-			downloadURL := fmt.Sprintf("%s://%s/audio/download/%s", scheme, host, filename)
+			// Create a download URL that expires
+			signed := svc.AudioURLs.Sign(audioDownloadPath(filename))
+			downloadURL := requestBaseURL(r, cfg) + APIPrefix + audioDownloadPath(url.PathEscape(filename)) + "?" + signed.Encode()
 
 			// Create file info structure
 			fileInfo := AudioFile{
@@ -131,10 +138,11 @@ func ListAudioFiles() http.HandlerFunc {
 	}
 }
 
-// DownloadAudioFile handles the GET /audio/download/{filename} endpoint to download a specific audio file
-func DownloadAudioFile() http.HandlerFunc {
+// DownloadAudioFile handles the GET /audio/download/{filename} endpoint to download a specific audio file.
+// The link must be signed by the list endpoint and not expired.
+// Headerless .raw files are sent as WAV with ?format=wav, assuming Twilio's 8kHz μ-law.
+func DownloadAudioFile(svc *services.ServiceContainer, cfg *config.Config) http.HandlerFunc {
 	log := logger.Component("AudioHandler")
-	cfg := config.Load()
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract filename from URL path
@@ -150,6 +158,12 @@ func DownloadAudioFile() http.HandlerFunc {
 			return
 		}
 
+		if err := svc.AudioURLs.Verify(audioDownloadPath(filename), r.URL.Query()); err != nil {
+			log.Warn("Refused download of %s from %s: %v", filename, r.RemoteAddr, err)
+			http.Error(w, "Forbidden: "+err.Error()+", list the audio files again for a new link", http.StatusForbidden)
+			return
+		}
+
 		// Construct file path
 		filePath := filepath.Join(cfg.AudioOutputDirectory, filename)
 
@@ -165,23 +179,37 @@ func DownloadAudioFile() http.HandlerFunc {
 			return
 		}
 
-		// Open and serve the file
-		file, err := os.Open(filePath)
-		if err != nil {
-			log.Error("Error opening file: %v", err)
+		// Read the file, decrypting it when it was saved encrypted
+		audio, err := svc.AudioStore.ReadAudioFile(filename)
+		if errors.Is(err, services.ErrSealedStreamTruncated) {
+			log.Warn("Serving audio file %s up to where it was cut short", filename)
+		} else if err != nil {
+			log.Error("Error reading file: %v", err)
 			http.Error(w, "Error opening file", http.StatusInternalServerError)
 			return
 		}
-		defer file.Close()
+
+		// Wrap headerless audio so it plays in standard players
+		if strings.HasSuffix(filename, ".raw") && r.URL.Query().Get("format") == "wav" {
+			wavName := strings.TrimSuffix(filename, ".raw") + ".wav"
+			w.Header().Set("Content-Type", "audio/wav")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", wavName))
+			http.ServeContent(w, r, wavName, fileInfo.ModTime(), bytes.NewReader(services.EncodeWAV(audio, services.DefaultAudioFormat())))
+			log.Info("Successfully served audio file %s as WAV", filename)
+			return
+		}
 
 		// Set appropriate headers
-		w.Header().Set("Content-Type", "audio/basic") // MIME type for μ-law audio
+		contentType := "audio/basic" // MIME type for μ-law audio
+		if strings.HasSuffix(filename, ".wav") {
+			contentType = "audio/wav"
+		}
+		w.Header().Set("Content-Type", contentType)
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
 
-		// Stream the file to the response
-		http.ServeContent(w, r, filename, fileInfo.ModTime(), file)
+		// Serve the audio, with range requests
+		http.ServeContent(w, r, filename, fileInfo.ModTime(), bytes.NewReader(audio))
 
-		log.Info("Successfully served audio file: %s (%d bytes)", filename, fileInfo.Size())
+		log.Info("Successfully served audio file: %s (%d bytes)", filename, len(audio))
 	}
 }
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// CallerTimelineResponse is a caller's chronological history
+type CallerTimelineResponse struct {
+	CallerHash string                   `json:"callerHash"`
+	Entries    []services.TimelineEntry `json:"entries"`
+}
+
+// CallerTimeline handles the GET /callers/{hash}/timeline endpoint
+func CallerTimeline(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("CallerHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callerHash := r.PathValue("hash")
+		if callerHash == "" {
+			http.Error(w, "Missing caller hash", http.StatusBadRequest)
+			return
+		}
+
+		store, ok := tenantStore(svc, w, r)
+		if !ok {
+			return
+		}
+		timeline, err := services.CallerTimeline(svc, store, callerHash)
+		if err != nil {
+			log.Error("Failed to read the archived calls of caller %s: %v", callerHash, err)
+			http.Error(w, "Failed to read archived conversations", http.StatusInternalServerError)
+			return
+		}
+		if len(timeline) == 0 {
+			http.Error(w, "Caller not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(CallerTimelineResponse{
+			CallerHash: callerHash,
+			Entries:    timeline,
+		}); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// GetCallerProfile handles the GET /callers/{hash}/profile endpoint
+func GetCallerProfile(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("CallerHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, ok := svc.Conversation.CallerProfile(r.PathValue("hash"))
+		if !ok {
+			http.Error(w, "Caller not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(profile.Snapshot()); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// GetCallerMood handles the GET /callers/{hash}/mood endpoint, returning the mood of each
+// of the caller's calls and which way it last moved
+func GetCallerMood(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("CallerHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, ok := svc.Conversation.CallerProfile(r.PathValue("hash"))
+		if !ok {
+			http.Error(w, "Caller not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(profile.MoodTrend()); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// VoiceRequest selects one of the configured voices for a call by its label
+type VoiceRequest struct {
+	Voice string `json:"voice" validate:"required"`
+}
+
+// SetCallVoice handles the PUT /calls/{callSid}/voice endpoint, switching the voice
+// the rest of the call's responses are spoken in
+func SetCallVoice(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("CallHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		channels, ok := svc.ChannelManager.GetChannels(callSID)
+		if !ok {
+			http.Error(w, "Call not found", http.StatusNotFound)
+			return
+		}
+
+		var req VoiceRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 4*1024)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("Invalid voice payload: %v", err)
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		option, ok := services.FindVoiceOption(svc.Voices.Options(), req.Voice)
+		if !ok {
+			http.Error(w, "Unknown voice", http.StatusBadRequest)
+			return
+		}
+		channels.SetVoice(option.Voice)
+		log.Info("Call %s switched to the %s voice (%s)", callSID, option.Label, option.Voice)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(option); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// PersonaRequest selects one of the configured personas for a call by its name
+type PersonaRequest struct {
+	Persona string `json:"persona" validate:"required"`
+}
+
+// SetCallPersona handles the PUT /calls/{callSid}/persona endpoint, handing the rest of
+// the call to another persona, with its prompt, voice and pace
+func SetCallPersona(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("CallHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		channels, ok := svc.ChannelManager.GetChannels(callSID)
+		if !ok {
+			http.Error(w, "Call not found", http.StatusNotFound)
+			return
+		}
+
+		var req PersonaRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 4*1024)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("Invalid persona payload: %v", err)
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		persona, ok := svc.Personas.Lookup(req.Persona)
+		if !ok {
+			http.Error(w, "Unknown persona", http.StatusBadRequest)
+			return
+		}
+		selectPersona(svc, channels, persona)
+		log.Info("Call %s switched to the %s persona", callSID, persona.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(persona); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// CallStats is what a live or ended call has used so far and how its pipeline is doing
+type CallStats struct {
+	CallSID  string                 `json:"callSid"`
+	Usage    services.CallUsage     `json:"usage"`
+	Pipeline services.PipelineStats `json:"pipeline"`
+}
+
+// GetCallStats handles the GET /calls/{callSid}/stats endpoint, returning the call's LLM
+// token usage and cost, turns, average stage latencies, audio sent each way, drops and
+// what its pipeline is doing
+func GetCallStats(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("CallHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		conv, ok := svc.Conversation.GetConversation(callSID)
+		if !ok {
+			http.Error(w, "Call not found", http.StatusNotFound)
+			return
+		}
+
+		channels, _ := svc.ChannelManager.GetChannels(callSID)
+		stats := CallStats{CallSID: callSID, Usage: conv.Usage(), Pipeline: services.CallPipelineStats(conv, channels)}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
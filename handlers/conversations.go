@@ -0,0 +1,641 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// TranscriptWord is a recognized word and when it was spoken, in milliseconds
+// from the start of the call's recognized audio
+type TranscriptWord struct {
+	Word    string `json:"word"`
+	StartMs int64  `json:"startMs"`
+	EndMs   int64  `json:"endMs"`
+}
+
+// TranscriptMessage is one message of a conversation transcript
+type TranscriptMessage struct {
+	Role    string           `json:"role"`
+	Content string           `json:"content"`
+	Words   []TranscriptWord `json:"words,omitempty"`
+	// Original and Language are set on translated calls: the caller's own words, or
+	// what was spoken to them
+	Original string `json:"original,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Sentiment is the tone of what the caller said
+	Sentiment *services.Sentiment `json:"sentiment,omitempty"`
+	// Model is the LLM model that produced a therapist message
+	Model     string    `json:"model,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Source and Confidence are how a caller message was recognized
+	Source     string  `json:"source,omitempty"`
+	Confidence float32 `json:"confidence,omitempty"`
+	// GenerationMs and SynthesisMs are how long a therapist message took the LLM to write
+	// and TTS to start speaking
+	GenerationMs int64 `json:"generationMs,omitempty"`
+	SynthesisMs  int64 `json:"synthesisMs,omitempty"`
+}
+
+// TranscriptResponse is a call's conversation transcript
+type TranscriptResponse struct {
+	CallSID string `json:"callSid"`
+	// Persona is the therapist persona the caller talked to, empty for the default
+	Persona  string              `json:"persona,omitempty"`
+	Messages []TranscriptMessage `json:"messages"`
+}
+
+// ConversationTranscript handles the GET /conversations/{callSid}/transcript endpoint
+func ConversationTranscript(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		var response TranscriptResponse
+		if conv, ok := svc.Conversation.GetConversation(callSID); ok {
+			response = TranscriptResponse{CallSID: callSID, Persona: conv.CurrentPersona(), Messages: transcriptMessages(conv)}
+		} else if record, ok := archivedRecord(svc, w, r, callSID); ok {
+			response = TranscriptResponse{CallSID: callSID, Persona: record.Persona, Messages: archivedMessages(record)}
+		} else {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// transcriptMessages returns the conversation's messages as they appear in transcripts
+func transcriptMessages(conv *services.Conversation) []TranscriptMessage {
+	messages := []TranscriptMessage{}
+	for _, msg := range conv.Transcript() {
+		message := TranscriptMessage{
+			Role:         msg.Role,
+			Content:      msg.Content,
+			Original:     msg.Original,
+			Language:     msg.Language,
+			Sentiment:    msg.Sentiment,
+			Model:        msg.Model,
+			CreatedAt:    msg.CreatedAt,
+			Source:       msg.Source,
+			Confidence:   msg.Confidence,
+			GenerationMs: msg.GenerationLatency.Milliseconds(),
+			SynthesisMs:  msg.SynthesisLatency.Milliseconds(),
+		}
+		for _, word := range msg.Words {
+			message.Words = append(message.Words, TranscriptWord{
+				Word:    word.Word,
+				StartMs: word.Start.Milliseconds(),
+				EndMs:   word.End.Milliseconds(),
+			})
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// TenantHeader names the tenant whose records an API request reads
+const TenantHeader = "X-Tenant"
+
+// archivedRecord reads the session record of a call evicted from memory from the
+// store, which decrypts it, under the storage of the tenant named in the request. It
+// writes the error response and returns false when the call isn't archived or its record
+// can't be read.
+func archivedRecord(svc *services.ServiceContainer, w http.ResponseWriter, r *http.Request, callSID string) (services.TranscriptExport, bool) {
+	store, ok := tenantStore(svc, w, r)
+	if !ok {
+		return services.TranscriptExport{}, false
+	}
+	if store == nil {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return services.TranscriptExport{}, false
+	}
+	record, ok, err := store.Load(callSID)
+	if err != nil {
+		logger.Component("ConversationHandler").Error("Failed to read archived call %s: %v", callSID, err)
+		http.Error(w, "Failed to read archived conversation", http.StatusInternalServerError)
+		return record, false
+	}
+	if !ok {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+	}
+	return record, ok
+}
+
+// tenantStore returns the store of the tenant named in the request, nil when calls aren't
+// archived. It writes the error response and returns false when the tenant is unknown.
+func tenantStore(svc *services.ServiceContainer, w http.ResponseWriter, r *http.Request) (services.TranscriptStore, bool) {
+	tenant := r.Header.Get(TenantHeader)
+	if _, ok := svc.Tenants.Lookup(tenant); tenant != "" && !ok {
+		http.Error(w, "Unknown tenant", http.StatusBadRequest)
+		return nil, false
+	}
+	if svc.Transcripts == nil {
+		return nil, true
+	}
+	return svc.Tenants.Store(svc.Transcripts, tenant), true
+}
+
+// archivedMessages returns an archived call's messages as they appear in transcripts
+func archivedMessages(record services.TranscriptExport) []TranscriptMessage {
+	messages := []TranscriptMessage{}
+	for _, msg := range record.Messages {
+		role := "therapist"
+		if msg.Speaker == "Caller" {
+			role = "user"
+		}
+		messages = append(messages, TranscriptMessage{
+			Role:         role,
+			Content:      msg.Text,
+			Original:     msg.Original,
+			Language:     msg.Language,
+			Model:        msg.Model,
+			CreatedAt:    msg.Time,
+			Source:       msg.Source,
+			Confidence:   msg.Confidence,
+			GenerationMs: msg.GenerationMs,
+			SynthesisMs:  msg.SynthesisMs,
+		})
+	}
+	return messages
+}
+
+// Page sizes of the conversation list
+const (
+	defaultConversationPage = 20
+	maxConversationPage     = 100
+)
+
+// ConversationInfo describes a conversation without its messages
+type ConversationInfo struct {
+	CallSID string `json:"callSid"`
+	// CallerHash links the caller's conversations, empty when the number was withheld
+	CallerHash   string    `json:"callerHash,omitempty"`
+	Persona      string    `json:"persona,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+	MessageCount int       `json:"messageCount"`
+	RiskFlags    []string  `json:"riskFlags,omitempty"`
+	// Tags and Metadata are added for triage, by hand or from how the call was classified
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Ended is set once the call has ended and its summary was written
+	Ended bool `json:"ended"`
+}
+
+// newConversationInfo describes the conversation
+func newConversationInfo(conv *services.Conversation) ConversationInfo {
+	return ConversationInfo{
+		CallSID:      conv.ID,
+		CallerHash:   conv.CallerHash,
+		Persona:      conv.CurrentPersona(),
+		StartedAt:    conv.CreatedAt,
+		MessageCount: conv.MessageCount(),
+		RiskFlags:    conv.RiskFlags(),
+		Tags:         conv.Tags(),
+		Metadata:     conv.Metadata(),
+		Ended:        conv.CallSummary() != nil,
+	}
+}
+
+// archivedConversationInfo describes an archived call from its session record
+func archivedConversationInfo(record services.TranscriptExport) ConversationInfo {
+	return ConversationInfo{
+		CallSID:      record.CallSID,
+		CallerHash:   record.CallerHash,
+		Persona:      record.Persona,
+		StartedAt:    record.StartedAt,
+		MessageCount: len(record.Messages),
+		RiskFlags:    record.RiskFlags,
+		Tags:         record.Tags,
+		Metadata:     record.Metadata,
+		Ended:        record.Summary != nil,
+	}
+}
+
+// ConversationList is a page of conversations, newest first
+type ConversationList struct {
+	Conversations []ConversationInfo `json:"conversations"`
+	// Total is how many conversations match the filters across all pages
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// ListConversations handles the GET /conversations endpoint. It pages with ?limit= and
+// ?offset=, and filters with ?caller=, a caller hash or phone number, ?from= and ?to=,
+// RFC 3339 times or dates, and ?tag=, repeated for conversations with every tag; a ?to=
+// date includes the whole day. Calls evicted from memory are listed from the store of the
+// tenant named in the request.
+func ListConversations(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		query := services.ConversationQuery{Limit: defaultConversationPage}
+		var err error
+		if caller := params.Get("caller"); caller != "" {
+			query.CallerHash = caller
+			if !callerHashPattern.MatchString(caller) {
+				query.CallerHash = services.HashPhoneNumber(caller)
+			}
+		}
+		for _, tag := range params["tag"] {
+			normalized, ok := services.NormalizeTag(tag)
+			if !ok {
+				http.Error(w, fmt.Sprintf("Invalid tag %q", tag), http.StatusBadRequest)
+				return
+			}
+			query.Tags = append(query.Tags, normalized)
+		}
+		if query.From, err = parseListTime(params.Get("from"), false); err != nil {
+			http.Error(w, "Invalid from: use an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		if query.To, err = parseListTime(params.Get("to"), true); err != nil {
+			http.Error(w, "Invalid to: use an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		if limit := params.Get("limit"); limit != "" {
+			if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 || query.Limit > maxConversationPage {
+				http.Error(w, fmt.Sprintf("Invalid limit: use 1 to %d", maxConversationPage), http.StatusBadRequest)
+				return
+			}
+		}
+		if offset := params.Get("offset"); offset != "" {
+			if query.Offset, err = strconv.Atoi(offset); err != nil || query.Offset < 0 {
+				http.Error(w, "Invalid offset", http.StatusBadRequest)
+				return
+			}
+		}
+
+		store, ok := tenantStore(svc, w, r)
+		if !ok {
+			return
+		}
+		infos, err := listConversations(svc, store, query)
+		if err != nil {
+			log.Error("Failed to list archived conversations: %v", err)
+			http.Error(w, "Failed to list archived conversations", http.StatusInternalServerError)
+			return
+		}
+		response := ConversationList{Conversations: []ConversationInfo{}, Total: len(infos), Offset: query.Offset, Limit: query.Limit}
+		infos = infos[min(query.Offset, len(infos)):]
+		response.Conversations = append(response.Conversations, infos[:min(query.Limit, len(infos))]...)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// listConversations returns every conversation matching the query's filters, newest
+// first: those held in memory, and those only the store still has a record of
+func listConversations(svc *services.ServiceContainer, store services.TranscriptStore, query services.ConversationQuery) ([]ConversationInfo, error) {
+	query.Offset, query.Limit = 0, 0
+	convs, _ := svc.Conversation.ListConversations(query)
+	infos := make([]ConversationInfo, 0, len(convs))
+	for _, conv := range convs {
+		infos = append(infos, newConversationInfo(conv))
+	}
+	if store != nil {
+		records, err := store.List(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			// A call still in memory is listed as it is now, not as it was archived
+			if _, held := svc.Conversation.GetConversation(record.CallSID); !held {
+				infos = append(infos, archivedConversationInfo(record))
+			}
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].StartedAt.Equal(infos[j].StartedAt) {
+			return infos[i].StartedAt.After(infos[j].StartedAt)
+		}
+		return infos[i].CallSID < infos[j].CallSID
+	})
+	return infos, nil
+}
+
+// callerHashPattern matches a HashPhoneNumber hash
+var callerHashPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// parseListTime parses an RFC 3339 time or a date; an end date is taken as the end of the day
+func parseListTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// ConversationDetail is a conversation with its full message history
+type ConversationDetail struct {
+	Conversation ConversationInfo    `json:"conversation"`
+	Messages     []TranscriptMessage `json:"messages"`
+	// Usage is the call's LLM usage, only known while the call is held in memory
+	Usage *services.CallUsage `json:"usage,omitempty"`
+	// Summary and Notes are written once the call has ended
+	Summary *services.CallSummary  `json:"summary,omitempty"`
+	Notes   *services.SessionNotes `json:"notes,omitempty"`
+}
+
+// GetConversation handles the GET /conversations/{callSid} endpoint
+func GetConversation(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		var response ConversationDetail
+		if conv, ok := svc.Conversation.GetConversation(callSID); ok {
+			usage := conv.Usage()
+			response = ConversationDetail{
+				Conversation: newConversationInfo(conv),
+				Messages:     transcriptMessages(conv),
+				Usage:        &usage,
+				Summary:      conv.CallSummary(),
+			}
+		} else if record, ok := archivedRecord(svc, w, r, callSID); ok {
+			response = ConversationDetail{
+				Conversation: ConversationInfo{
+					CallSID:      record.CallSID,
+					CallerHash:   record.CallerHash,
+					Persona:      record.Persona,
+					StartedAt:    record.StartedAt,
+					MessageCount: len(record.Messages),
+					RiskFlags:    record.RiskFlags,
+					Tags:         record.Tags,
+					Metadata:     record.Metadata,
+					Ended:        record.Summary != nil,
+				},
+				Messages: archivedMessages(record),
+				Summary:  record.Summary,
+			}
+		} else {
+			return
+		}
+		response.Notes, _ = svc.SessionNotes.Notes(callSID)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// ExportConversation handles the GET /conversations/{callSid}/export endpoint, which
+// downloads the call's session record as ?format=json (the default), txt or pdf
+func ExportConversation(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		var record services.TranscriptExport
+		if conv, ok := svc.Conversation.GetConversation(callSID); ok {
+			record = services.NewTranscriptExport(conv)
+		} else if record, ok = archivedRecord(svc, w, r, callSID); ok {
+			record.ExportedAt = time.Now()
+		} else {
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = services.ExportJSON
+		}
+
+		data, contentType, err := record.Render(format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Info("Exporting call %s as %s", callSID, format)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, callSID, format))
+		if _, err := w.Write(data); err != nil {
+			log.Error("Error writing export: %v", err)
+		}
+	}
+}
+
+// SentimentResponse is a call's emotional trajectory
+type SentimentResponse struct {
+	CallSID string                    `json:"callSid"`
+	Points  []services.SentimentPoint `json:"points"`
+	// Average is the mean sentiment score of the caller's messages
+	Average float64 `json:"average"`
+	// Change is how much the latest score moved from the first one
+	Change float64 `json:"change"`
+}
+
+// ConversationSentiment handles the GET /conversations/{callSid}/sentiment endpoint
+func ConversationSentiment(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		conv, ok := svc.Conversation.GetConversation(callSID)
+		if !ok {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+
+		response := SentimentResponse{CallSID: callSID, Points: conv.SentimentTrajectory()}
+		if response.Points == nil {
+			response.Points = []services.SentimentPoint{}
+		}
+		if n := len(response.Points); n > 0 {
+			var total float64
+			for _, point := range response.Points {
+				total += point.Score
+			}
+			response.Average = math.Round(total/float64(n)*100) / 100
+			response.Change = math.Round((response.Points[n-1].Score-response.Points[0].Score)*100) / 100
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// ConversationSummary handles the GET /conversations/{callSid}/summary endpoint, which
+// returns the summary written once the call has ended
+func ConversationSummary(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		var summary *services.CallSummary
+		if conv, ok := svc.Conversation.GetConversation(callSID); ok {
+			summary = conv.CallSummary()
+		} else if record, ok := archivedRecord(svc, w, r, callSID); ok {
+			summary = record.Summary
+		} else {
+			return
+		}
+		if summary == nil {
+			http.Error(w, "Summary not available yet", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// ConversationNotes handles the GET /conversations/{callSid}/notes endpoint, which returns
+// the clinician session notes written once the call has ended
+func ConversationNotes(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		notes, ok := svc.SessionNotes.Notes(r.PathValue("callSid"))
+		if !ok {
+			http.Error(w, "Notes not available", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(notes); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// TagsRequest is the body of POST /conversations/{callSid}/tags
+type TagsRequest struct {
+	Tags []string `json:"tags" validate:"required"`
+}
+
+// MetadataRequest is the body of PATCH /conversations/{callSid}/metadata; entries with
+// an empty value are removed
+type MetadataRequest struct {
+	Metadata map[string]string `json:"metadata" validate:"required"`
+}
+
+// ConversationTags are a conversation's tags and metadata
+type ConversationTags struct {
+	CallSID  string            `json:"callSid"`
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// newConversationTags returns the conversation's tags and metadata
+func newConversationTags(conv *services.Conversation) ConversationTags {
+	response := ConversationTags{CallSID: conv.ID, Tags: conv.Tags(), Metadata: conv.Metadata()}
+	if response.Tags == nil {
+		response.Tags = []string{}
+	}
+	if response.Metadata == nil {
+		response.Metadata = map[string]string{}
+	}
+	return response
+}
+
+// AddConversationTags handles the POST /conversations/{callSid}/tags endpoint
+func AddConversationTags(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		conv, ok := svc.Conversation.GetConversation(callSID)
+		if !ok {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+
+		var req TagsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := conv.AddTags(req.Tags...); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Info("Tagged call %s with %v", callSID, req.Tags)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(newConversationTags(conv)); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// RemoveConversationTag handles the DELETE /conversations/{callSid}/tags/{tag} endpoint
+func RemoveConversationTag(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		conv, ok := svc.Conversation.GetConversation(callSID)
+		if !ok {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		if !conv.RemoveTag(r.PathValue("tag")) {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		log.Info("Removed tag %s from call %s", r.PathValue("tag"), callSID)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(newConversationTags(conv)); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// UpdateConversationMetadata handles the PATCH /conversations/{callSid}/metadata endpoint
+func UpdateConversationMetadata(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ConversationHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		callSID := r.PathValue("callSid")
+		conv, ok := svc.Conversation.GetConversation(callSID)
+		if !ok {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+
+		var req MetadataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := conv.UpdateMetadata(req.Metadata); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Info("Updated %d metadata entries of call %s", len(req.Metadata), callSID)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(newConversationTags(conv)); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ghophp/call-me-help/services"
+)
+
+func TestListConversationsIncludesArchivedCalls(t *testing.T) {
+	svc := &services.ServiceContainer{
+		Conversation: services.NewConversationService(),
+		Transcripts:  services.NewTranscriptArchive(t.TempDir(), nil),
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, callSID := range []string{"CA1", "CA2", "CA3"} {
+		conv := svc.Conversation.GetOrCreateConversation(callSID)
+		conv.CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		conv.AddUserMessage("hello")
+		// CA1 was archived and evicted from memory, CA2 archived and still held, CA3 only held
+		if callSID != "CA3" {
+			if err := svc.Transcripts.Save(conv); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if callSID == "CA1" {
+			svc.Conversation.RemoveConversation(callSID)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	ListConversations(svc)(rec, httptest.NewRequest("GET", "/api/v1/conversations?limit=2&offset=1", nil))
+	var list ConversationList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("Expected a conversation list, got %d: %v", rec.Code, err)
+	}
+	if list.Total != 3 || len(list.Conversations) != 2 || list.Conversations[0].CallSID != "CA2" || list.Conversations[1].CallSID != "CA1" {
+		t.Errorf("Expected the second page of the held and archived calls, newest first, got %+v", list)
+	}
+	if archived := list.Conversations[1]; archived.MessageCount != 1 || !archived.StartedAt.Equal(start) {
+		t.Errorf("Expected the archived call described from its record, got %+v", archived)
+	}
+}
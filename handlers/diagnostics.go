@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// PipelineDiagnosticsResponse is where the goroutines are and how full each call's
+// channels are
+type PipelineDiagnosticsResponse struct {
+	Goroutines      int                       `json:"goroutines"`
+	GoroutineGroups []services.GoroutineGroup `json:"goroutineGroups"`
+	Calls           []services.CallOccupancy  `json:"calls"`
+}
+
+// GetPipelineDiagnostics reports the goroutines, grouped by where they are blocked, and
+// the occupancy of every call's channels, to track down goroutine leaks in the pipeline
+func GetPipelineDiagnostics(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("AdminHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := PipelineDiagnosticsResponse{
+			Goroutines:      runtime.NumGoroutine(),
+			GoroutineGroups: services.GoroutineGroups(),
+			Calls:           svc.ChannelManager.Occupancy(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
+
+// maxProfileSeconds is the longest a CPU profile or execution trace can record
+const maxProfileSeconds = 300
+
+// GetProfile serves the runtime's profiles by name, in the format net/http/pprof serves
+// them: the goroutine, heap, allocs, block, mutex and threadcreate profiles, in text with
+// ?debug=1, a CPU profile or an execution trace over ?seconds, and the command line. It
+// reads them from runtime/pprof itself, as importing net/http/pprof would also serve them
+// unauthenticated on http.DefaultServeMux.
+func GetProfile() http.HandlerFunc {
+	log := logger.Component("AdminHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch name := r.PathValue("profile"); name {
+		case "cmdline":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+		case "profile", "trace":
+			seconds, ok := profileSeconds(r, name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("Invalid seconds: use 1 to %d", maxProfileSeconds), http.StatusBadRequest)
+				return
+			}
+			start, stop := pprof.StartCPUProfile, pprof.StopCPUProfile
+			if name == "trace" {
+				start, stop = trace.Start, trace.Stop
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+			if err := start(w); err != nil {
+				log.Warn("Could not start the %s: %v", name, err)
+				w.Header().Del("Content-Disposition")
+				http.Error(w, "Could not start recording, one may already be running: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// Stop early when the client gives up
+			select {
+			case <-time.After(seconds):
+			case <-r.Context().Done():
+			}
+			stop()
+		default:
+			profile := pprof.Lookup(name)
+			if profile == nil {
+				http.Error(w, "Unknown profile "+name, http.StatusNotFound)
+				return
+			}
+			debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+			if name == "heap" && r.URL.Query().Get("gc") != "" {
+				runtime.GC()
+			}
+			if debug != 0 {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			} else {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+			}
+			if err := profile.WriteTo(w, debug); err != nil {
+				log.Error("Error writing the %s profile: %v", name, err)
+			}
+		}
+	}
+}
+
+// profileSeconds is how long the request asks a CPU profile or trace to record: ?seconds,
+// 30 for a CPU profile and 1 for a trace by default; ok is false when it is out of range
+func profileSeconds(r *http.Request, name string) (time.Duration, bool) {
+	seconds := 30
+	if name == "trace" {
+		seconds = 1
+	}
+	if value := r.URL.Query().Get("seconds"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxProfileSeconds {
+			return 0, false
+		}
+		seconds = parsed
+	}
+	return time.Duration(seconds) * time.Second, true
+}
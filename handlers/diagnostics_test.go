@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetProfileServesRuntimeProfiles(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pprof/{profile}", GetProfile())
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/pprof/goroutine?debug=1"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine profile:") {
+		t.Errorf("Expected the goroutine profile in text, got %d: %.100s", rec.Code, rec.Body)
+	}
+	if rec := get("/pprof/heap"); rec.Code != http.StatusOK || rec.Body.Len() == 0 || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("Expected the heap profile for go tool pprof, got %d", rec.Code)
+	}
+	if rec := get("/pprof/nothing"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown profile not found, got %d", rec.Code)
+	}
+	if rec := get("/pprof/profile?seconds=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a CPU profile of no time refused, got %d", rec.Code)
+	}
+
+	// Nothing is served on the default mux, where net/http/pprof would register itself
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)); pattern != "" {
+		t.Errorf("Expected no profiles on the default mux, got %q", pattern)
+	}
+}
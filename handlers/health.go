@@ -3,16 +3,65 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/ghophp/call-me-help/services"
 )
 
-// HealthCheck is a simple health check endpoint
-func HealthCheck(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
-		"status": "ok",
-		"time":   time.Now().Format(time.RFC3339),
-	}
+// HealthResponse is the body of the health check
+type HealthResponse struct {
+	Status string `json:"status"`
+	Time   string `json:"time"`
+	// Calls flagged with inbound audio that didn't match the negotiated format
+	AudioIssues map[services.AudioIssue]int `json:"audioIssues"`
+	LLMThrottle *services.LLMThrottleStats  `json:"llmThrottle,omitempty"`
+	// CallState is how much call state is held in memory
+	CallState services.CallStateStats `json:"callState"`
+	// Synthetic are the synthetic turns run through the providers, when they are
+	Synthetic *services.SyntheticStats `json:"synthetic,omitempty"`
+	// SLOs are the turns' compliance with their latency SLOs, when set
+	SLOs []services.SLOStatus `json:"slos,omitempty"`
+	// RateLimited is how many requests and calls the rate limits refused
+	RateLimited services.RateLimitStats `json:"rateLimited"`
+	// Dependencies are probed when asked for with ?deep=true
+	Dependencies []services.DependencyStatus `json:"dependencies,omitempty"`
+}
 
-	json.NewEncoder(w).Encode(response)
+// HealthCheck is a simple health check endpoint, degraded when the last synthetic turn
+// failed. With ?deep=true it also probes the providers, Twilio and storage, and answers
+// 503 when one of them, or the synthetic turn, is failing.
+func HealthCheck(svc *services.ServiceContainer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := HealthResponse{
+			Status:      "ok",
+			Time:        time.Now().Format(time.RFC3339),
+			AudioIssues: services.AudioIssueCounts(),
+			CallState:   services.CallState(svc),
+			RateLimited: svc.RateLimits.Stats(),
+		}
+		if svc.LLMThrottle != nil {
+			stats := svc.LLMThrottle.Stats()
+			response.LLMThrottle = &stats
+		}
+		response.SLOs = svc.SLOs.Status()
+		healthy := true
+		if response.Synthetic = svc.Synthetic.Stats(); response.Synthetic != nil && response.Synthetic.Last != nil {
+			healthy = response.Synthetic.Last.Healthy
+		}
+		if !healthy {
+			response.Status = "degraded"
+		}
+		if deep, _ := strconv.ParseBool(r.URL.Query().Get("deep")); deep {
+			dependencies, probed := svc.Health.Check(r.Context())
+			response.Dependencies = dependencies
+			if !healthy || !probed {
+				response.Status = "degraded"
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}
 }
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/services"
+)
+
+type testPayload struct {
+	Name     string            `json:"name" validate:"required"`
+	Count    int               `json:"count"`
+	Tags     []string          `json:"tags,omitempty"`
+	Seen     *time.Time        `json:"seen,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	internal string
+}
+
+func TestSchemaForStruct(t *testing.T) {
+	schema := SchemaFor(testPayload{})
+
+	if schema.Type != "object" || len(schema.Properties) != 5 {
+		t.Fatalf("Expected an object with 5 properties, got %+v", schema)
+	}
+	if len(schema.Required) != 1 || schema.Required[0] != "name" {
+		t.Errorf("Expected name to be required, got %v", schema.Required)
+	}
+	if seen := schema.Properties["seen"]; seen.Format != "date-time" || !seen.Nullable {
+		t.Errorf("Expected a nullable date-time, got %+v", seen)
+	}
+	if tags := schema.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" {
+		t.Errorf("Expected an array of strings, got %+v", tags)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := SchemaFor(testPayload{})
+
+	tests := []struct {
+		body     string
+		problems []string
+	}{
+		{`{"name":"a","count":2,"tags":["x"],"seen":"2024-01-02T03:04:05Z"}`, nil},
+		{`{"count":2}`, []string{"body.name is required"}},
+		{`{"name":"a","count":1.5}`, []string{"body.count must be an integer"}},
+		{`{"name":"a","tags":[1]}`, []string{"body.tags[0] must be a string"}},
+		{`{"name":"a","seen":"yesterday"}`, []string{"body.seen must be an RFC 3339 date-time"}},
+		{`[]`, []string{"body must be an object"}},
+	}
+
+	for _, tt := range tests {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(tt.body), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		problems := schema.Validate(decoded)
+		if strings.Join(problems, "|") != strings.Join(tt.problems, "|") {
+			t.Errorf("Validate(%s): expected %v, got %v", tt.body, tt.problems, problems)
+		}
+	}
+}
+
+func TestAPIRejectsInvalidRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	api := NewAPI(mux, "/api/v1")
+	called := false
+	api.Handle(Route{
+		Method:  http.MethodPost,
+		Path:    "/things",
+		Request: testPayload{},
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			var payload testPayload
+			json.NewDecoder(r.Body).Decode(&payload)
+			called = payload.Name == "ok"
+		},
+	})
+
+	post := func(contentType, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/things", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("text/plain", `{"name":"ok"}`); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for non-JSON content, got %d", code)
+	}
+	if code := post("application/json", `{"count":1}`); code != http.StatusBadRequest || called {
+		t.Errorf("Expected 400 without calling the handler, got %d (called=%t)", code, called)
+	}
+	if code := post("application/json; charset=utf-8", `{"name":"ok"}`); code != http.StatusOK || !called {
+		t.Errorf("Expected the handler to receive the body, got %d (called=%t)", code, called)
+	}
+
+	paths := api.Spec()["paths"].(map[string]map[string]interface{})
+	if _, ok := paths["/api/v1/things"]["post"]; !ok {
+		t.Errorf("Expected the route in the OpenAPI document, got %v", paths)
+	}
+}
+
+func TestAdminRoutesNeedTheToken(t *testing.T) {
+	mux := http.NewServeMux()
+	api := NewAPI(mux, "/api/v1")
+	api.Handle(Route{
+		Method:  http.MethodGet,
+		Path:    "/admin/things",
+		Admin:   true,
+		Handler: func(w http.ResponseWriter, r *http.Request) {},
+	})
+
+	get := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/things", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("Bearer anything"); code != http.StatusForbidden {
+		t.Errorf("Expected admin routes off without a token set, got %d", code)
+	}
+	api.AdminToken = "s3cret"
+	for authorization, want := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"Bearer wrong":   http.StatusUnauthorized,
+		"s3cret":         http.StatusUnauthorized,
+		"Bearer s3cret":  http.StatusOK,
+		"Basic s3cret":   http.StatusUnauthorized,
+		"Bearer s3cret ": http.StatusUnauthorized,
+	} {
+		if code := get(authorization); code != want {
+			t.Errorf("Expected %d for %q, got %d", want, authorization, code)
+		}
+	}
+
+	operation := api.Spec()["paths"].(map[string]map[string]interface{})["/api/v1/admin/things"]["get"].(map[string]interface{})
+	if _, ok := operation["security"]; !ok {
+		t.Errorf("Expected the admin route documented as needing the token, got %v", operation)
+	}
+}
+
+func TestConversationRoutesNeedTheAdminToken(t *testing.T) {
+	mux := http.NewServeMux()
+	api := RegisterAPI(mux, &services.ServiceContainer{}, &config.Config{})
+	api.RequireAuth = true
+	api.AdminToken = "s3cret"
+	api.Keys = map[string]string{"partner": "p4rtner"}
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/v1/conversations"},
+		{http.MethodGet, "/api/v1/conversations/CA1/transcript"},
+		{http.MethodGet, "/api/v1/conversations/CA1/notes"},
+		{http.MethodPost, "/api/v1/conversations/CA1/tags"},
+		{http.MethodPatch, "/api/v1/conversations/CA1/metadata"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{}`))
+		req.Header.Set("X-API-Key", "p4rtner")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s %s refused to an API key, got %d", route.method, route.path, rec.Code)
+		}
+	}
+}
+
+func TestAPIRoutesNeedAKey(t *testing.T) {
+	mux := http.NewServeMux()
+	api := NewAPI(mux, "/api/v1")
+	api.RequireAuth = true
+	var actor string
+	api.Handle(Route{
+		Method: http.MethodGet,
+		Path:   "/things",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			actor = services.ActorFromContext(r.Context())
+		},
+	})
+	api.Handle(Route{
+		Method:  http.MethodGet,
+		Path:    "/health",
+		Public:  true,
+		Handler: func(w http.ResponseWriter, r *http.Request) {},
+	})
+
+	get := func(path, header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("/api/v1/things", "", ""); code != http.StatusForbidden {
+		t.Errorf("Expected the API off without keys, got %d", code)
+	}
+	keys, err := ParseAPIKeys([]string{"dashboard=k3y", " clinic = c1inic "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Keys = keys
+	api.AdminToken = "s3cret"
+	for _, tt := range []struct {
+		header, value string
+		want          int
+		actor         string
+	}{
+		{"", "", http.StatusUnauthorized, ""},
+		{"X-API-Key", "wrong", http.StatusUnauthorized, ""},
+		{"X-API-Key", "c1inic", http.StatusOK, "clinic"},
+		{"Authorization", "Bearer k3y", http.StatusOK, "dashboard"},
+		{"Authorization", "Bearer s3cret", http.StatusOK, "admin"},
+	} {
+		actor = ""
+		if code := get("/api/v1/things", tt.header, tt.value); code != tt.want || actor != tt.actor {
+			t.Errorf("Expected %d as %q for %s %q, got %d as %q", tt.want, tt.actor, tt.header, tt.value, code, actor)
+		}
+	}
+	if code := get("/api/v1/health", "", ""); code != http.StatusOK {
+		t.Errorf("Expected public routes open, got %d", code)
+	}
+
+	for _, entries := range [][]string{{"k3y"}, {"a=1", "a=2"}, {"admin=1"}} {
+		if _, err := ParseAPIKeys(entries); err == nil || strings.Contains(err.Error(), "k3y") {
+			t.Errorf("Expected %v rejected without the key in the error, got %v", entries, err)
+		}
+	}
+}
+
+func TestAPIRateLimitsEachClient(t *testing.T) {
+	mux := http.NewServeMux()
+	api := NewAPI(mux, "/api/v1")
+	api.Limiter = services.NewRateLimiter("API requests", 1, time.Hour, 1)
+	api.TrustProxy = true
+	api.Handle(Route{Method: http.MethodGet, Path: "/things", Handler: func(w http.ResponseWriter, r *http.Request) {}})
+
+	get := func(forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/things", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("203.0.113.9, 198.51.100.1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the first request allowed, got %d", rec.Code)
+	}
+	// A client can't dodge the limit with an address of its own in front of the proxy's
+	if rec := get("192.0.2.77, 198.51.100.1"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("Expected 429 retrying after an hour, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get("198.51.100.2"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another client allowed, got %d", rec.Code)
+	}
+}
+
+func TestCallerRoutesNeedTheAdminToken(t *testing.T) {
+	mux := http.NewServeMux()
+	api := RegisterAPI(mux, &services.ServiceContainer{}, &config.Config{})
+	api.RequireAuth = true
+	api.AdminToken = "s3cret"
+	api.Keys = map[string]string{"partner": "p4rtner"}
+
+	for _, path := range []string{
+		"/api/v1/callbacks",
+		"/api/v1/callers/abc123/timeline",
+		"/api/v1/callers/abc123/profile",
+		"/api/v1/callers/abc123/mood",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-API-Key", "p4rtner")
+		req.Header.Set(TenantHeader, "city-clinic")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected GET %s refused to an API key, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestPartnerRoutesTakeOnlyPartnerKeys(t *testing.T) {
+	mux := http.NewServeMux()
+	api := NewAPI(mux, "/api/v1")
+	api.RequireAuth = true
+	var actor string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		actor = services.ActorFromContext(r.Context())
+	}
+	api.Handle(Route{Method: http.MethodPost, Path: "/referrals", Partner: true, Handler: handler})
+	api.Handle(Route{Method: http.MethodGet, Path: "/analytics", Handler: handler})
+
+	call := func(method, path, key string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := call(http.MethodPost, "/api/v1/referrals", "k3y"); code != http.StatusForbidden {
+		t.Errorf("Expected referrals off without partner keys, got %d", code)
+	}
+
+	api.Keys = map[string]string{"dashboard": "k3y"}
+	api.PartnerKeys = map[string]string{"city-clinic": "c1inic"}
+	if code := call(http.MethodPost, "/api/v1/referrals", "k3y"); code != http.StatusUnauthorized {
+		t.Errorf("Expected an API key refused on a partner route, got %d", code)
+	}
+	actor = ""
+	if code := call(http.MethodPost, "/api/v1/referrals", "c1inic"); code != http.StatusOK || actor != "city-clinic" {
+		t.Errorf("Expected the partner key taken as city-clinic, got %d as %q", code, actor)
+	}
+	if code := call(http.MethodGet, "/api/v1/analytics", "c1inic"); code != http.StatusUnauthorized {
+		t.Errorf("Expected a partner key refused elsewhere, got %d", code)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// clientIP is the address a request came from. Behind a trusted proxy it is the last
+// X-Forwarded-For address, the one the proxy added, since the ones before it are whatever
+// the client sent; otherwise it is the connection's.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setRetryAfter tells the client how many whole seconds to wait
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// tooManyRequests refuses an API request over the rate limit
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	setRetryAfter(w, wait)
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+// refuseCall answers a Twilio webhook over the rate limit with TwiML reading the message
+// and hanging up. It answers 200 since Twilio tells the caller "an application error has
+// occurred" on any other status.
+func refuseCall(w http.ResponseWriter, svc *services.ServiceContainer, message string, wait time.Duration) {
+	setRetryAfter(w, wait)
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(svc.Twilio.GenerateHangupTwiML(message)))
+}
+
+// LimitCallWebhook limits the webhook of incoming calls like LimitWebhook, refusing a
+// call over the limit with TwiML that tells the caller and hangs up
+func LimitCallWebhook(svc *services.ServiceContainer, cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return limitWebhook(svc, cfg, next, func(w http.ResponseWriter, wait time.Duration) {
+		refuseCall(w, svc, cfg.RateLimitMessage, wait)
+	})
+}
+
+// LimitWebhook limits a Twilio callback made during or after a call, answering 429 over
+// the limit. Put it behind ValidateTwilioSignature: the requests are then known to be
+// Twilio's, and are limited per Twilio account, which stops webhook storms without one
+// account's traffic holding up another's. Without signature checks they are limited per
+// client IP.
+func LimitWebhook(svc *services.ServiceContainer, cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return limitWebhook(svc, cfg, next, tooManyRequests)
+}
+
+// limitWebhook refuses webhooks over the limit with refuse
+func limitWebhook(svc *services.ServiceContainer, cfg *config.Config, next http.HandlerFunc, refuse func(http.ResponseWriter, time.Duration)) http.HandlerFunc {
+	log := logger.Component("TwilioWebhook")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientIP(r, cfg.TrustProxy)
+		if account := r.PostFormValue("AccountSid"); cfg.TwilioValidateSignature && account != "" {
+			client = account
+		}
+		if ok, wait := svc.RateLimits.Webhooks.Allow(client); !ok {
+			log.Debug("Refused %s %s over the webhook rate limit", r.Method, r.URL.Path)
+			refuse(w, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowCaller takes a token of the caller's number. Callers who withhold their number
+// aren't limited by number: in one shared bucket, a burst of them would lock out every
+// genuine caller who withholds theirs. The webhook limit still caps how fast they come in.
+func allowCaller(svc *services.ServiceContainer, from string) (bool, time.Duration) {
+	if services.WithheldNumber(from) {
+		return true, 0
+	}
+	return svc.RateLimits.Callers.Allow(services.HashPhoneNumber(from))
+}
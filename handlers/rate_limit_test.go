@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/services"
+)
+
+func TestLimitWebhookRefusesCallbacksWith429(t *testing.T) {
+	svc := &services.ServiceContainer{
+		Twilio:     &services.TwilioService{},
+		RateLimits: services.RateLimits{Webhooks: services.NewRateLimiter("Twilio webhooks", 1, time.Minute, 1)},
+	}
+	cfg := &config.Config{TwilioValidateSignature: true}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	post := func(handler http.HandlerFunc, account string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/twilio/consent", strings.NewReader(url.Values{"AccountSid": {account}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	callback := LimitWebhook(svc, cfg, ok)
+	if rec := post(callback, "AC1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the first callback through, got %d", rec.Code)
+	}
+	if rec := post(callback, "AC1"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a callback over the limit refused with 429, got %d", rec.Code)
+	}
+	// Each Twilio account has its own bucket, wherever its requests come from
+	if rec := post(callback, "AC2"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another account's callback through, got %d", rec.Code)
+	}
+
+	rec := post(LimitCallWebhook(svc, cfg, ok), "AC1")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<Hangup") {
+		t.Errorf("Expected a call over the limit answered with hangup TwiML, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAllowCallerDoesNotLimitWithheldNumbers(t *testing.T) {
+	svc := &services.ServiceContainer{
+		RateLimits: services.RateLimits{Callers: services.NewRateLimiter("calls", 1, time.Hour, 1)},
+	}
+	for i := 0; i < 10; i++ {
+		allowCaller(svc, "+266696687")
+	}
+	if ok, _ := allowCaller(svc, "anonymous"); !ok {
+		t.Error("Expected a withheld caller through after a burst of withheld calls")
+	}
+	if ok, _ := allowCaller(svc, "+15551234567"); !ok {
+		t.Fatal("Expected the first call of a number through")
+	}
+	if ok, _ := allowCaller(svc, "+15551234567"); ok {
+		t.Error("Expected a number calling again over its limit refused")
+	}
+}
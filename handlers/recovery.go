@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ghophp/call-me-help/services"
+)
+
+// RecoverPanics answers 500 to requests whose handler panics, instead of dropping the
+// connection, and logs and reports the panic. A nil reporter only logs it.
+func RecoverPanics(reporter *services.ErrorReporter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value == http.ErrAbortHandler {
+				panic(value) // Deliberately aborted, the server handles it
+			}
+			ctx := r.Context()
+			if callSID := r.URL.Query().Get("CallSid"); callSID != "" {
+				ctx = services.WithCallSID(ctx, callSID)
+			}
+			reporter.ReportPanic(ctx, value)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
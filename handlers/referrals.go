@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// ReferralRequest is the body of a referral submitted by a partner organization
+type ReferralRequest struct {
+	Organization      string `json:"organization" validate:"required"`
+	PhoneNumber       string `json:"phoneNumber" validate:"required"`
+	Reason            string `json:"reason" validate:"required"`
+	PreferredLanguage string `json:"preferredLanguage,omitempty"`
+	CallbackURL       string `json:"callbackUrl,omitempty"`
+}
+
+// CreateReferral handles the POST /referrals endpoint used by partner organizations
+// to pre-register a caller before they dial in
+func CreateReferral(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("ReferralHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ReferralRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 64*1024)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("Invalid referral payload: %v", err)
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		referral, err := svc.Referrals.Register(services.Referral{
+			Organization:      req.Organization,
+			PhoneNumber:       req.PhoneNumber,
+			Reason:            req.Reason,
+			PreferredLanguage: req.PreferredLanguage,
+			CallbackURL:       req.CallbackURL,
+		})
+		if err != nil {
+			log.Warn("Rejected referral: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(referral); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// APIPrefix is where the versioned HTTP API is served
+const APIPrefix = "/api/v1"
+
+// RegisterAPI registers the versioned API routes and their OpenAPI document on the mux.
+// Twilio webhooks and the media stream stay outside the API since they're configured in Twilio.
+func RegisterAPI(mux *http.ServeMux, svc *services.ServiceContainer, cfg *config.Config) *API {
+	api := NewAPI(mux, APIPrefix)
+	api.AdminToken = cfg.AdminToken
+	api.RequireAuth = cfg.APIAuth
+	api.Limiter = svc.RateLimits.API
+	api.TrustProxy = cfg.TrustProxy
+	api.Audit = svc.Audit
+
+	api.Handle(Route{
+		Method:   http.MethodPost,
+		Path:     "/referrals",
+		Summary:  "Pre-register a caller referred by a partner organization",
+		Tag:      "referrals",
+		Request:  ReferralRequest{},
+		Response: services.Referral{},
+		Status:   http.StatusCreated,
+		Partner:  true,
+		Handler:  CreateReferral(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/callbacks",
+		Summary:  "List callback offers queued from the voicemail line",
+		Tag:      "voicemail",
+		Response: []services.CallbackOffer{},
+		Admin:    true,
+		Handler:  ListCallbacks(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/callers/{hash}/timeline",
+		Summary:  "Get everything known about a caller in chronological order",
+		Tag:      "callers",
+		Response: CallerTimelineResponse{},
+		Admin:    true,
+		Audit:    services.AuditCallerRead,
+		Handler:  CallerTimeline(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/callers/{hash}/profile",
+		Summary:  "Get a caller's calls, preferences and risk history across calls",
+		Tag:      "callers",
+		Response: services.CallerProfileSnapshot{},
+		Admin:    true,
+		Audit:    services.AuditCallerRead,
+		Handler:  GetCallerProfile(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/callers/{hash}/mood",
+		Summary:  "Get how a caller's mood moved across their calls",
+		Tag:      "callers",
+		Response: services.MoodTrend{},
+		Admin:    true,
+		Audit:    services.AuditCallerRead,
+		Handler:  GetCallerMood(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/conversations",
+		Summary:  "List conversations newest first, by caller, start date and tag with ?caller=, ?from=, ?to= and ?tag=",
+		Tag:      "conversations",
+		Response: ConversationList{},
+		Admin:    true,
+		Handler:  ListConversations(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/conversations/{callSid}",
+		Summary:  "Get a conversation's full message history and call metadata",
+		Tag:      "conversations",
+		Response: ConversationDetail{},
+		Admin:    true,
+		Audit:    services.AuditConversationRead,
+		Handler:  GetConversation(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/conversations/{callSid}/transcript",
+		Summary:  "Get a call's transcript with word timings for aligning it with audio",
+		Tag:      "conversations",
+		Response: TranscriptResponse{},
+		Admin:    true,
+		Audit:    services.AuditTranscriptRead,
+		Handler:  ConversationTranscript(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/conversations/{callSid}/export",
+		Summary:  "Download a call's session record with ?format=json, txt or pdf",
+		Tag:      "conversations",
+		Produces: "application/json, text/plain, application/pdf",
+		Admin:    true,
+		Audit:    services.AuditTranscriptExport,
+		Handler:  ExportConversation(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/conversations/{callSid}/sentiment",
+		Summary:  "Get how the caller's sentiment and emotions changed over a call",
+		Tag:      "conversations",
+		Response: SentimentResponse{},
+		Admin:    true,
+		Handler:  ConversationSentiment(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/conversations/{callSid}/summary",
+		Summary:  "Get the summary written once a call has ended",
+		Tag:      "conversations",
+		Response: services.CallSummary{},
+		Admin:    true,
+		Audit:    services.AuditSummaryRead,
+		Handler:  ConversationSummary(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/conversations/{callSid}/notes",
+		Summary:  "Get the SOAP session notes written for clinicians once a call has ended",
+		Tag:      "conversations",
+		Response: services.SessionNotes{},
+		Admin:    true,
+		Audit:    services.AuditNotesRead,
+		Handler:  ConversationNotes(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodPost,
+		Path:     "/conversations/{callSid}/tags",
+		Summary:  "Tag a conversation for triage, e.g. follow-up-needed",
+		Tag:      "conversations",
+		Request:  TagsRequest{},
+		Response: ConversationTags{},
+		Admin:    true,
+		Audit:    services.AuditConversationTag,
+		Handler:  AddConversationTags(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodDelete,
+		Path:     "/conversations/{callSid}/tags/{tag}",
+		Summary:  "Remove a tag from a conversation",
+		Tag:      "conversations",
+		Response: ConversationTags{},
+		Admin:    true,
+		Audit:    services.AuditConversationUntag,
+		Handler:  RemoveConversationTag(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodPatch,
+		Path:     "/conversations/{callSid}/metadata",
+		Summary:  "Set or remove metadata entries of a conversation; empty values remove them",
+		Tag:      "conversations",
+		Request:  MetadataRequest{},
+		Response: ConversationTags{},
+		Admin:    true,
+		Audit:    services.AuditConversationUpdate,
+		Handler:  UpdateConversationMetadata(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodPut,
+		Path:     "/calls/{callSid}/voice",
+		Summary:  "Choose the voice a live call's responses are spoken in",
+		Tag:      "calls",
+		Request:  VoiceRequest{},
+		Response: services.VoiceOption{},
+		Audit:    services.AuditCallUpdate,
+		Handler:  SetCallVoice(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodPut,
+		Path:     "/calls/{callSid}/persona",
+		Summary:  "Choose the therapist persona a live call talks with",
+		Tag:      "calls",
+		Request:  PersonaRequest{},
+		Response: services.Persona{},
+		Audit:    services.AuditCallUpdate,
+		Handler:  SetCallPersona(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/calls/{callSid}/stats",
+		Summary:  "Get a live or recently ended call's LLM usage, turns, stage latencies, audio bytes, drops and pipeline state",
+		Tag:      "calls",
+		Response: CallStats{},
+		Handler:  GetCallStats(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/analytics",
+		Summary:  "Get call volume, duration, turns, top topics and escalation rate over ?from= to ?to=, by ?interval=day or week",
+		Tag:      "analytics",
+		Response: services.AnalyticsReport{},
+		Handler:  GetAnalytics(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/audio",
+		Summary:  "List saved response audio files",
+		Tag:      "audio",
+		Response: []AudioFile{},
+		Audit:    services.AuditAudioList,
+		Handler:  ListAudioFiles(svc, cfg),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/audio/download/{filename}",
+		Summary:  "Download a saved response audio file with a signed link from the list, as WAV with ?format=wav",
+		Tag:      "audio",
+		Produces: "audio/wav",
+		Public:   true, // The link's signature stands in for an API key
+		Audit:    services.AuditAudioDownload,
+		Handler:  DownloadAudioFile(svc, cfg),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/health",
+		Summary:  "Service health and call quality counters; ?deep=true probes the dependencies",
+		Tag:      "health",
+		Public:   true,
+		Response: HealthResponse{},
+		Handler:  HealthCheck(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodPost,
+		Path:     "/admin/reload",
+		Summary:  "Reload prompts, phrase sets, personas, voices and the log level without dropping calls",
+		Tag:      "admin",
+		Response: services.ReloadReport{},
+		Admin:    true,
+		Audit:    services.AuditConfigReload,
+		Handler:  ReloadConfig(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/admin/config",
+		Summary:  "Get the configuration this instance loaded, with where each setting came from and credentials masked",
+		Tag:      "admin",
+		Response: ConfigResponse{},
+		Admin:    true,
+		Audit:    services.AuditConfigRead,
+		Handler:  GetConfig(),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/admin/loglevel",
+		Summary:  "Get the log level and the components logging at another one",
+		Tag:      "admin",
+		Response: LogLevelResponse{},
+		Admin:    true,
+		Handler:  GetLogLevel(),
+	})
+	api.Handle(Route{
+		Method:   http.MethodPut,
+		Path:     "/admin/loglevel",
+		Summary:  "Change the log level, or a component's, without a restart",
+		Tag:      "admin",
+		Request:  LogLevelRequest{},
+		Response: LogLevelResponse{},
+		Admin:    true,
+		Audit:    services.AuditConfigLogLevel,
+		Handler:  SetLogLevel(),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/admin/diagnostics/pipeline",
+		Summary:  "Get the goroutines grouped by where they are blocked and how full each call's channels are",
+		Tag:      "admin",
+		Response: PipelineDiagnosticsResponse{},
+		Admin:    true,
+		Audit:    services.AuditDiagnosticsRead,
+		Handler:  GetPipelineDiagnostics(svc),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/admin/diagnostics/pprof/{profile}",
+		Summary:  "Get a runtime profile for go tool pprof, e.g. goroutine, heap or profile for CPU",
+		Tag:      "admin",
+		Produces: "application/octet-stream, text/plain",
+		Admin:    true,
+		Audit:    services.AuditDiagnosticsRead,
+		Handler:  GetProfile(),
+	})
+	api.Handle(Route{
+		Method:   http.MethodGet,
+		Path:     "/admin/audit",
+		Summary:  "List audit entries newest first, by ?actor=, ?action=, ?target=, ?callSid=, ?from=, ?to= and ?limit=",
+		Tag:      "admin",
+		Response: AuditLogResponse{},
+		Admin:    true,
+		Audit:    services.AuditLogRead,
+		Handler:  GetAuditLog(svc),
+	})
+
+	mux.HandleFunc("GET "+APIPrefix+"/openapi.json", api.ServeSpec())
+	return api
+}
@@ -1,10 +1,11 @@
 package handlers
 
 import (
-	"log"
 	"net/http"
 	"strings"
 
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
 	"github.com/ghophp/call-me-help/services"
 )
 
@@ -21,66 +22,325 @@ type TwilioStreamEvent struct {
 }
 
 // HandleIncomingCall handles an incoming call webhook from Twilio
-func HandleIncomingCall(svc *services.ServiceContainer) http.HandlerFunc {
+func HandleIncomingCall(svc *services.ServiceContainer, cfg *config.Config) http.HandlerFunc {
+	log := logger.Component("TwilioWebhook")
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Received call webhook from Twilio. URL: %s, Method: %s", r.URL.String(), r.Method)
+		log.Info("Received call webhook from Twilio. URL: %s, Method: %s", r.URL.String(), r.Method)
 
 		// Log all headers
-		log.Printf("Request headers: %v", r.Header)
+		log.Debug("Request headers: %v", r.Header)
 
 		if err := r.ParseForm(); err != nil {
-			log.Printf("Error parsing form: %v", err)
+			log.Error("Error parsing form: %v", err)
 			http.Error(w, "Could not parse form", http.StatusBadRequest)
 			return
 		}
 
 		// Log all form fields
-		log.Printf("Form data: %v", r.Form)
+		log.Debug("Form data: %v", r.Form)
 
 		// Get call information
 		callSID := r.FormValue("CallSid")
 		if callSID == "" {
-			log.Printf("Missing CallSid in request")
+			log.Warn("Missing CallSid in request")
 			http.Error(w, "Missing CallSid", http.StatusBadRequest)
 			return
 		}
 
-		log.Printf("Call received with SID: %s", callSID)
+		log := log.WithCall(callSID)
+		log.Info("Call received with SID: %s", callSID)
+
+		// Blocked callers are refused before any call state is created
+		if ok, reason := svc.Callers.Screen(r.FormValue("From")); !ok {
+			log.Warn("Refusing call %s from caller %s, %s", callSID, services.HashPhoneNumber(r.FormValue("From")), reason)
+			svc.Audit.RecordSystem(services.AuditCallBlock, callSID, map[string]string{
+				"caller": services.HashPhoneNumber(r.FormValue("From")),
+				"reason": reason,
+			})
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(svc.Twilio.GenerateHangupTwiML(cfg.CallerBlockedMessage)))
+			return
+		}
+
+		// A number calling again and again is refused before any call state is created
+		if ok, wait := allowCaller(svc, r.FormValue("From")); !ok {
+			log.Warn("Refusing call %s, its number is over the caller rate limit", callSID)
+			refuseCall(w, svc, cfg.RateLimitMessage, wait)
+			return
+		}
+
+		// The message-only line records a voicemail instead of starting a live session
+		if cfg.VoicemailPhoneNumber != "" && r.FormValue("To") == cfg.VoicemailPhoneNumber {
+			log.Info("Call %s reached the voicemail line", callSID)
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(svc.Twilio.GenerateVoicemailTwiML(requestBaseURL(r, cfg) + "/twilio/voicemail")))
+			return
+		}
 
 		// Create channels for this call
-		log.Printf("Creating channels for call %s", callSID)
-		svc.ChannelManager.CreateChannels(callSID)
-
-		// Get the callback URL for the media stream
-		// For Ngrok, we need to use the host as provided in the request
-		// and use wss:// (WebSocket Secure) scheme
-		host := r.Host
-
-		// Check if it's an ngrok URL and use the proper scheme
-		var wsScheme string
-		if strings.Contains(host, "ngrok") {
-			// For ngrok, we need to use wss directly
-			wsScheme = "wss"
-		} else {
-			// For non-ngrok, infer from the request
-			wsScheme = "ws"
-			if r.TLS != nil {
-				wsScheme = "wss"
+		log.Info("Creating channels for call %s", callSID)
+		channels := svc.ChannelManager.CreateChannels(callSID)
+		log = log.WithCorrelation(channels.CorrelationID)
+		channels.CallerNumber = r.FormValue("From")
+		conversation := svc.Conversation.GetOrCreateConversation(callSID)
+
+		// Calls to a tenant's numbers, or through its Twilio account, belong to it and are
+		// answered by its persona
+		if tenant, ok := svc.Tenants.ForCall(r.FormValue("To"), r.FormValue("AccountSid")); ok {
+			conversation.SetTenant(tenant.Name)
+			if persona, ok := svc.Personas.Lookup(tenant.Persona); ok {
+				selectPersona(svc, channels, persona)
 			}
+			log.Info("Call %s belongs to tenant %s", callSID, tenant.Name)
 		}
 
-		// Don't include callSid in URL - it will be passed in Stream parameters
-		callbackURL := wsScheme + "://" + host + "/ws"
-		log.Printf("WebSocket callback URL: %s", callbackURL)
+		// A number dedicated to a persona answers as it, without the persona menu
+		if persona, ok := svc.Personas.ForNumber(r.FormValue("To")); ok {
+			selectPersona(svc, channels, persona)
+			log.Info("Call %s dialed the number of the %s persona", callSID, persona.Name)
+		}
 
-		// Generate TwiML response with the stream URL
-		twiml := svc.Twilio.GenerateTwiML(callbackURL)
-		log.Printf("Generated TwiML: %s", twiml)
+		// A returning caller picks up where they left off: the LLM hears about their earlier
+		// calls, and the persona, voice and language they used are restored
+		if channels.CallerNumber != "" {
+			profile := svc.Conversation.AttachCaller(conversation, services.HashPhoneNumber(channels.CallerNumber))
+			if persona, ok := profile.ApplyPreferences(channels, svc.Personas); ok {
+				conversation.SetPersona(persona.Name)
+				log.Info("Call %s is back with the %s persona", callSID, persona.Name)
+			}
+			if note := profile.PromptContext(callSID, conversation.CreatedAt); note != "" {
+				conversation.AddContext(note)
+			}
+		}
 
-		w.Header().Set("Content-Type", "text/xml")
-		w.Write([]byte(twiml))
+		// Load pre-call context if a partner organization referred this caller
+		if referral, ok := svc.Referrals.Claim(channels.CallerNumber, callSID); ok {
+			log.Info("Loading referral %s context for call %s", referral.ID, callSID)
+			conversation.AddContext(referral.PromptContext())
+		}
+
+		// Ask before recording; the stream starts once the caller has answered
+		if cfg.RecordCallerAudio {
+			log.Info("Asking call %s for consent to record caller audio", callSID)
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(svc.Twilio.GenerateConsentTwiML(cfg.RecordingConsentNotice, requestBaseURL(r, cfg)+"/twilio/consent")))
+			return
+		}
+
+		continueCallSetup(w, r, svc, cfg)
 
 		// Log the start of a new call
-		log.Printf("New call started: %s", callSID)
+		log.Info("New call started: %s", callSID)
+	}
+}
+
+// HandleRecordingConsent handles the caller's answer to the recording notice and starts the media stream
+func HandleRecordingConsent(svc *services.ServiceContainer, cfg *config.Config) http.HandlerFunc {
+	log := logger.Component("TwilioWebhook")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Error("Error parsing form: %v", err)
+			http.Error(w, "Could not parse form", http.StatusBadRequest)
+			return
+		}
+
+		callSID := r.FormValue("CallSid")
+		log := log.WithCall(callSID)
+		channels, ok := svc.ChannelManager.GetChannels(callSID)
+		if !ok {
+			log.Warn("Consent received for unknown call %s", callSID)
+			http.Error(w, "Unknown call", http.StatusNotFound)
+			return
+		}
+		log = log.WithCorrelation(channels.CorrelationID)
+
+		// Only an explicit keypress counts as consent; a timeout means no
+		consent := r.FormValue("Digits") == "1"
+		channels.SetRecordingConsent(consent)
+		log.Info("Call %s recording consent: %t", callSID, consent)
+
+		continueCallSetup(w, r, svc, cfg)
+		log.Info("New call started: %s", callSID)
+	}
+}
+
+// HandlePersonaSelection handles the caller's pick from the persona menu and continues to
+// the voice menu or the media stream
+func HandlePersonaSelection(svc *services.ServiceContainer, cfg *config.Config) http.HandlerFunc {
+	log := logger.Component("TwilioWebhook")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Error("Error parsing form: %v", err)
+			http.Error(w, "Could not parse form", http.StatusBadRequest)
+			return
+		}
+
+		callSID := r.FormValue("CallSid")
+		log := log.WithCall(callSID)
+		channels, ok := svc.ChannelManager.GetChannels(callSID)
+		if !ok {
+			log.Warn("Persona selection received for unknown call %s", callSID)
+			http.Error(w, "Unknown call", http.StatusNotFound)
+			return
+		}
+		log = log.WithCorrelation(channels.CorrelationID)
+
+		// No keypress, or one that isn't on the menu, talks with the first persona as the menu says
+		persona, ok := svc.Personas.ForDigit(r.FormValue("Digits"))
+		if personas := svc.Personas.All(); !ok && len(personas) > 0 {
+			persona = personas[0]
+		}
+		selectPersona(svc, channels, persona)
+		log.Info("Call %s is talking with the %s persona", callSID, persona.Name)
+
+		continueWithVoice(w, r, svc, cfg, channels)
+	}
+}
+
+// HandleVoiceSelection handles the caller's pick from the voice menu and starts the media stream
+func HandleVoiceSelection(svc *services.ServiceContainer, cfg *config.Config) http.HandlerFunc {
+	log := logger.Component("TwilioWebhook")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Error("Error parsing form: %v", err)
+			http.Error(w, "Could not parse form", http.StatusBadRequest)
+			return
+		}
+
+		callSID := r.FormValue("CallSid")
+		log := log.WithCall(callSID)
+		channels, ok := svc.ChannelManager.GetChannels(callSID)
+		if !ok {
+			log.Warn("Voice selection received for unknown call %s", callSID)
+			http.Error(w, "Unknown call", http.StatusNotFound)
+			return
+		}
+		log = log.WithCorrelation(channels.CorrelationID)
+
+		// No keypress, or one that isn't on the menu, keeps the default voice
+		if option, ok := services.VoiceOptionForDigit(svc.Voices.Options(), r.FormValue("Digits")); ok {
+			channels.SetVoice(option.Voice)
+			log.Info("Call %s chose the %s voice (%s)", callSID, option.Label, option.Voice)
+		}
+
+		writeStreamTwiML(w, r, svc, cfg)
+		log.Info("New call started: %s", callSID)
+	}
+}
+
+// continueCallSetup offers the persona menu when the caller hasn't reached a persona yet and
+// there are several, and otherwise continues to the voice menu
+func continueCallSetup(w http.ResponseWriter, r *http.Request, svc *services.ServiceContainer, cfg *config.Config) {
+	channels, ok := svc.ChannelManager.GetChannels(r.FormValue("CallSid"))
+	if ok && channels.Persona().Name == "" && svc.Personas.OffersMenu() {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(svc.Twilio.GeneratePersonaMenuTwiML(svc.Personas.All(), requestBaseURL(r, cfg)+"/twilio/persona")))
+		return
+	}
+	continueWithVoice(w, r, svc, cfg, channels)
+}
+
+// continueWithVoice offers the voice menu when there are voices to choose from and neither
+// the persona nor the caller's last call brings one, and otherwise connects the call to the
+// media stream
+func continueWithVoice(w http.ResponseWriter, r *http.Request, svc *services.ServiceContainer, cfg *config.Config, channels *services.ChannelData) {
+	if voices := svc.Voices.Options(); len(voices) > 0 && (channels == nil || channels.Voice() == "") {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(svc.Twilio.GenerateVoiceMenuTwiML(voices, requestBaseURL(r, cfg)+"/twilio/voice")))
+		return
+	}
+	writeStreamTwiML(w, r, svc, cfg)
+}
+
+// selectPersona puts the call in the persona's hands for the rest of the call
+func selectPersona(svc *services.ServiceContainer, channels *services.ChannelData, persona services.Persona) {
+	channels.SetPersona(persona)
+	svc.Conversation.GetOrCreateConversation(channels.CallSID).SetPersona(persona.Name)
+}
+
+// writeStreamTwiML responds with TwiML connecting the call to the media stream websocket
+func writeStreamTwiML(w http.ResponseWriter, r *http.Request, svc *services.ServiceContainer, cfg *config.Config) {
+	// Get the callback URL for the media stream
+	// For Ngrok, we need to use the host as provided in the request
+	// and use wss:// (WebSocket Secure) scheme
+	host := r.Host
+	log := logger.Component("TwilioWebhook").WithCall(r.FormValue("CallSid"))
+
+	// Check if it's an ngrok URL and use the proper scheme
+	var wsScheme string
+	if strings.Contains(host, "ngrok") {
+		// For ngrok, we need to use wss directly
+		wsScheme = "wss"
+	} else {
+		// For non-ngrok, infer from the request
+		wsScheme = "ws"
+		if r.TLS != nil {
+			wsScheme = "wss"
+		}
+	}
+
+	// Don't include callSid in URL - it will be passed in Stream parameters
+	callbackURL := wsScheme + "://" + host + "/ws"
+	if cfg.PublicBaseURL != "" {
+		callbackURL = "ws" + strings.TrimPrefix(requestBaseURL(r, cfg), "http") + "/ws"
+	}
+	log.Debug("WebSocket callback URL: %s", callbackURL)
+
+	// Generate TwiML response with the stream URL
+	twiml := svc.Twilio.GenerateTwiML(callbackURL)
+	log.Debug("Generated TwiML: %s", twiml)
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(twiml))
+}
+
+// ValidateTwilioSignature refuses webhooks without a valid X-Twilio-Signature, made with the
+// auth token of the account the request names: a tenant's own or the configured one
+func ValidateTwilioSignature(svc *services.ServiceContainer, cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	if !cfg.TwilioValidateSignature {
+		return next
+	}
+	log := logger.Component("TwilioWebhook")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Error("Error parsing form: %v", err)
+			http.Error(w, "Could not parse form", http.StatusBadRequest)
+			return
+		}
+		params := make(map[string]string, len(r.PostForm))
+		for key := range r.PostForm {
+			params[key] = r.PostForm.Get(key)
+		}
+
+		if !webhookTwilio(svc, r).ValidateRequest(requestBaseURL(r, cfg)+r.URL.RequestURI(), params, r.Header.Get("X-Twilio-Signature")) {
+			log.Warn("Refused %s %s, its Twilio signature is missing or wrong", r.Method, r.URL.Path)
+			http.Error(w, "Invalid Twilio signature", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// webhookTwilio returns the Twilio service on the account a parsed webhook came from: a
+// tenant's subaccount named by its AccountSid, or the configured account
+func webhookTwilio(svc *services.ServiceContainer, r *http.Request) *services.TwilioService {
+	if tenant, ok := svc.Tenants.ForCall("", r.PostForm.Get("AccountSid")); ok {
+		return svc.Tenants.Twilio(tenant.Name, svc.Twilio)
+	}
+	return svc.Twilio
+}
+
+// requestBaseURL returns the public http(s) base URL the request was addressed to, the
+// configured one when it is set
+func requestBaseURL(r *http.Request, cfg *config.Config) string {
+	if base := cfg.PublicBaseURL; base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || strings.Contains(r.Host, "ngrok") || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
 	}
+	return scheme + "://" + r.Host
 }
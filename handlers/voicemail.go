@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/ghophp/call-me-help/services"
+)
+
+// HandleVoicemailRecording handles the <Record> action callback from the voicemail line
+func HandleVoicemailRecording(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("VoicemailHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Error("Error parsing form: %v", err)
+			http.Error(w, "Could not parse form", http.StatusBadRequest)
+			return
+		}
+
+		callSID := r.FormValue("CallSid")
+		from := r.FormValue("From")
+		recordingURL := r.FormValue("RecordingUrl")
+		if callSID == "" || recordingURL == "" {
+			log.Warn("Voicemail callback missing CallSid or RecordingUrl")
+			http.Error(w, "Missing CallSid or RecordingUrl", http.StatusBadRequest)
+			return
+		}
+
+		// The recording is fetched with the credentials of the account the call came in on,
+		// a tenant's subaccount or the configured one, so it must be that account's
+		twilio := webhookTwilio(svc, r)
+		if err := twilio.CheckRecordingURL(recordingURL); err != nil {
+			log.Warn("Refused voicemail callback for call %s: %v", callSID, err)
+			http.Error(w, "Invalid RecordingUrl", http.StatusBadRequest)
+			return
+		}
+
+		log.Info("Voicemail recorded for call %s", callSID)
+
+		// Transcription and the SMS reply take longer than Twilio waits for TwiML
+		go func() {
+			ctx, cancel := context.WithTimeout(services.WithCallSID(context.Background(), callSID), 2*time.Minute)
+			defer cancel()
+			defer svc.Errors.Recover(ctx)
+			if err := svc.Voicemail.ProcessRecording(ctx, twilio, callSID, from, recordingURL); err != nil {
+				log.Error("Error processing voicemail for call %s: %v", callSID, err)
+			}
+		}()
+
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(svc.Twilio.GenerateHangupTwiML("Thank you. We received your message and will text you shortly. Take care.")))
+	}
+}
+
+// ListCallbacks handles the GET /callbacks endpoint listing queued callback offers
+func ListCallbacks(svc *services.ServiceContainer) http.HandlerFunc {
+	log := logger.Component("VoicemailHandler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(svc.Voicemail.Callbacks()); err != nil {
+			log.Error("Error encoding response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ghophp/call-me-help/services"
+)
+
+func TestVoicemailRecordingsAreCheckedAgainstTheCallsAccount(t *testing.T) {
+	t.Setenv("TWILIO_ACCOUNT_SID", "ACmain")
+	t.Setenv("CLINIC_TWILIO_TOKEN", "clinic-token")
+	tenants, err := services.NewTenantRegistry([]byte(`[{"name": "clinic", "twilioAccountSid": "ACclinic", "twilioAuthTokenEnv": "CLINIC_TWILIO_TOKEN"}]`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &services.ServiceContainer{Twilio: services.NewTwilioService(), Tenants: tenants}
+	webhook := func(account, recordingURL string) *http.Request {
+		form := url.Values{"CallSid": {"CA1"}, "AccountSid": {account}, "RecordingUrl": {recordingURL}}
+		req := httptest.NewRequest(http.MethodPost, "/twilio/voicemail", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.ParseForm()
+		return req
+	}
+	recording := func(account string) string {
+		return "https://api.twilio.com/2010-04-01/Accounts/" + account + "/Recordings/RE1"
+	}
+
+	if err := webhookTwilio(svc, webhook("ACclinic", recording("ACclinic"))).CheckRecordingURL(recording("ACclinic")); err != nil {
+		t.Errorf("Expected a tenant's recording allowed on its subaccount's webhook, got %v", err)
+	}
+	if err := webhookTwilio(svc, webhook("ACmain", recording("ACmain"))).CheckRecordingURL(recording("ACmain")); err != nil {
+		t.Errorf("Expected the configured account's recording allowed, got %v", err)
+	}
+
+	// Another account's recording would be fetched with the wrong credentials
+	for _, accounts := range [][2]string{{"ACclinic", "ACmain"}, {"ACmain", "ACclinic"}} {
+		rec := httptest.NewRecorder()
+		HandleVoicemailRecording(svc)(rec, webhook(accounts[0], recording(accounts[1])))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s's recording refused on a webhook of %s, got %d", accounts[1], accounts[0], rec.Code)
+		}
+	}
+}
@@ -6,11 +6,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
-	"cloud.google.com/go/speech/apiv1/speechpb"
+	"github.com/ghophp/call-me-help/config"
 	"github.com/ghophp/call-me-help/logger"
 	"github.com/ghophp/call-me-help/services"
 	"github.com/gorilla/websocket"
@@ -31,10 +30,20 @@ type TwilioWSEvent struct {
 	Event          string       `json:"event"`
 	SequenceNumber string       `json:"sequenceNumber"`
 	StreamSid      string       `json:"streamSid"`
+	Start          *TwilioStart `json:"start,omitempty"`
 	Media          *TwilioMedia `json:"media,omitempty"`
 	Stop           *TwilioStop  `json:"stop,omitempty"`
 }
 
+// TwilioStart represents the start event data, including the negotiated media format
+type TwilioStart struct {
+	AccountSid  string                `json:"accountSid"`
+	CallSid     string                `json:"callSid"`
+	StreamSid   string                `json:"streamSid"`
+	Tracks      []string              `json:"tracks"`
+	MediaFormat *services.AudioFormat `json:"mediaFormat,omitempty"`
+}
+
 // TwilioMedia represents media data in a Twilio WebSocket event
 type TwilioMedia struct {
 	Track     string `json:"track"`
@@ -49,67 +58,10 @@ type TwilioStop struct {
 	CallSid    string `json:"callSid"`
 }
 
-// TranscriptionBuffer collects and normalizes transcriptions
-type TranscriptionBuffer struct {
-	LastActivity    time.Time
-	Transcriptions  []string
-	LastTranscript  string
-	ProcessingSince time.Time
-	IsProcessing    bool
-}
-
-// NewTranscriptionBuffer creates a new transcription buffer
-func NewTranscriptionBuffer() *TranscriptionBuffer {
-	return &TranscriptionBuffer{
-		LastActivity:   time.Now(),
-		Transcriptions: make([]string, 0),
-	}
-}
-
-// AddTranscription adds a transcription to the buffer
-func (tb *TranscriptionBuffer) AddTranscription(transcription string) {
-	tb.LastActivity = time.Now()
-	tb.Transcriptions = append(tb.Transcriptions, transcription)
-	tb.LastTranscript = transcription
-}
-
-// ShouldProcess determines if the buffer should be processed based on silence duration
-func (tb *TranscriptionBuffer) ShouldProcess(silenceDuration time.Duration) bool {
-	return !tb.IsProcessing &&
-		len(tb.Transcriptions) > 0 &&
-		time.Since(tb.LastActivity) > silenceDuration
-}
-
-// StartProcessing marks the buffer as being processed
-func (tb *TranscriptionBuffer) StartProcessing() {
-	tb.ProcessingSince = time.Now()
-	tb.IsProcessing = true
-}
-
-// FinishProcessing resets the buffer after processing
-func (tb *TranscriptionBuffer) FinishProcessing() {
-	tb.Transcriptions = make([]string, 0)
-	tb.IsProcessing = false
-}
-
-// NormalizeTranscriptions processes the transcriptions to find the most complete one
-func (tb *TranscriptionBuffer) NormalizeTranscriptions() string {
-	if len(tb.Transcriptions) == 0 {
-		return ""
-	}
-
-	// Use the last transcription, which is likely the most complete
-	finalTranscription := tb.Transcriptions[len(tb.Transcriptions)-1]
-
-	// Clean up extra spaces
-	finalTranscription = strings.TrimSpace(finalTranscription)
-
-	return finalTranscription
-}
-
 // HandleWebSocket handles WebSocket connections for streaming audio
 func HandleWebSocket(svc *services.ServiceContainer) http.HandlerFunc {
 	log := logger.Component("WebSocket")
+	cfg := config.Load()
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Info("WebSocket connection request received: %s", r.URL.String())
@@ -122,6 +74,7 @@ func HandleWebSocket(svc *services.ServiceContainer) http.HandlerFunc {
 			http.Error(w, "Missing CallSid parameter", http.StatusBadRequest)
 			return
 		}
+		log := log.WithCall(callSID)
 
 		// Store stream SID for later use
 		streamSID := "STREAM_" + callSID
@@ -181,6 +134,7 @@ func HandleWebSocket(svc *services.ServiceContainer) http.HandlerFunc {
 			log.Info("No channels found for call %s, creating new channels", callSID)
 			channels = svc.ChannelManager.CreateChannels(callSID)
 		}
+		log = log.WithCorrelation(channels.CorrelationID)
 
 		// Send a simple welcome message
 		go func() {
@@ -206,22 +160,18 @@ func HandleWebSocket(svc *services.ServiceContainer) http.HandlerFunc {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 		ctx = context.WithValue(ctx, "streamSID", streamSID)
+		ctx = services.WithCallSID(ctx, callSID)
+		ctx = services.WithCorrelationID(ctx, channels.CorrelationID)
 
-		// Start processing audio for this call
-		log.Info("Starting audio processing for call %s", callSID)
-		stream, err := svc.ChannelManager.StartAudioProcessing(ctx, callSID, svc.SpeechToText)
-		if err != nil {
-			log.Error("Error starting audio processing for call %s: %v", callSID, err)
-			return
-		}
-
-		// Process transcriptions and generate responses
-		log.Info("Starting transcription processing for call %s", callSID)
-		go processTranscriptionsAndResponses(ctx, channels, conversation, svc, log)
+		// Speech recognition is started once the start event tells us the media format
+		audioStarted := false
 
 		// Send audio responses back to the client
 		log.Info("Starting audio response sender for call %s", callSID)
-		go sendAudioResponses(conn, channels, &streamSID, &streamMutex, log)
+		go func() {
+			defer svc.Errors.Recover(ctx)
+			sendAudioResponses(conn, channels, &streamSID, &streamMutex, log)
+		}()
 
 		// Add a ping handler
 		conn.SetPingHandler(func(data string) error {
@@ -306,6 +256,10 @@ func HandleWebSocket(svc *services.ServiceContainer) http.HandlerFunc {
 						log.Warn("Media event with no media data for call %s", callSID)
 						continue
 					}
+					if !audioStarted {
+						log.Warn("Media received before start event for call %s, dropping", callSID)
+						continue
+					}
 
 					// Decode base64 payload to binary
 					decodedPayload, err := base64.StdEncoding.DecodeString(event.Media.Payload)
@@ -316,25 +270,106 @@ func HandleWebSocket(svc *services.ServiceContainer) http.HandlerFunc {
 
 					log.Debug("Decoded %d bytes of audio data from track: %s", len(decodedPayload), event.Media.Track)
 
-					// Send to speech recognition
-					err = stream.Send(&speechpb.StreamingRecognizeRequest{
-						StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
-							AudioContent: decodedPayload,
-						},
+					// Queue for in-order delivery to speech recognition
+					timestamp, _ := strconv.Atoi(event.Media.Timestamp)
+					chunk, _ := strconv.Atoi(event.Media.Chunk)
+					channels.AppendMediaFrame(log, services.MediaFrame{
+						Chunk:     chunk,
+						Timestamp: timestamp,
+						Payload:   decodedPayload,
 					})
 
-					if err != nil {
-						log.Error("Error sending audio to speech recognition: %v", err)
-					} else {
-						log.Debug("Sent %d bytes to speech recognition", len(decodedPayload))
-					}
-
 				case "start":
 					log.Info("Stream started: %s for call %s", event.StreamSid, callSID)
 
 					// Update the StreamSid with the actual one from Twilio
 					updateStreamSID(event.StreamSid)
 
+					// Negotiate the audio format from the start event
+					format := services.DefaultAudioFormat()
+					if event.Start != nil && event.Start.MediaFormat != nil {
+						format = event.Start.MediaFormat.Normalize()
+					} else {
+						log.Warn("Start event without mediaFormat for call %s, assuming %s/%d", callSID, format.Encoding, format.SampleRate)
+					}
+					channels.SetAudioFormat(format)
+					log.Info("Media format for call %s: %s, %d Hz, %d channel(s)",
+						callSID, format.Encoding, format.SampleRate, format.Channels)
+
+					if !audioStarted {
+						// Record the caller's audio for review if they agreed to it
+						if channels.RecordingConsent() {
+							if recording, err := svc.AudioStore.CreateInboundRecording(callSID); err != nil {
+								log.Error("Error starting inbound recording for call %s: %v", callSID, err)
+							} else {
+								channels.SetRecorder(recording)
+							}
+						}
+
+						// Start processing audio for this call
+						log.Info("Starting audio processing for call %s", callSID)
+						_, err = svc.ChannelManager.StartAudioProcessing(ctx, callSID, svc.SpeechToText)
+						if err != nil {
+							log.Error("Error starting audio processing for call %s: %v", callSID, err)
+							return
+						}
+						audioStarted = true
+						channels.SetPipelineState(services.PipelineListening)
+
+						// Process transcriptions and generate responses
+						log.Info("Starting transcription processing for call %s", callSID)
+						engine := services.NewTurnEngine(channels, conversation, svc.Generator, svc.TextToSpeech)
+						engine.AudioSaver = svc.AudioStore
+						engine.Fallbacks = svc.Fallbacks
+						engine.Masker = svc.Masker
+						engine.History = svc.History
+						engine.Budget = svc.Budget
+						engine.Tools = svc.Tools
+						engine.Guardrail = svc.Guardrail
+						engine.Actions = svc.Actions
+						engine.Caller = svc.Conversation.PromptCaller(conversation)
+						// A tenant's calls are controlled through its own Twilio account
+						tenant, _ := svc.Tenants.Lookup(conversation.CurrentTenant())
+						if twilio := svc.Tenants.Twilio(tenant.Name, svc.Twilio); twilio != nil {
+							engine.Calls = twilio
+							engine.TransferNumber = cfg.TransferPhoneNumber
+							if tenant.TransferPhoneNumber != "" {
+								engine.TransferNumber = tenant.TransferPhoneNumber
+							}
+						}
+						if referral, ok := svc.Referrals.Active(callSID); ok {
+							engine.Caller.ReferredBy = referral.Organization
+						}
+						engine.Translator = svc.Translator
+						engine.Language = cfg.STTLanguageCode
+						engine.LanguageVoices = svc.Voices.Languages()
+						engine.PivotLanguage = cfg.TranslationPivotLanguage
+						engine.FinalGrace = time.Duration(cfg.TurnFinalGraceMs) * time.Millisecond
+						engine.MinConfidence = float32(cfg.STTMinConfidence)
+						engine.MinStability = float32(cfg.STTMinStability)
+						engine.SynthesisWorkers = cfg.TTSParallelism
+						engine.BackchannelDelay = time.Duration(cfg.BackchannelDelayMs) * time.Millisecond
+						engine.Events = svc.Events
+						engine.Errors = svc.Errors
+						engine.SLOs = svc.SLOs
+						engine.Audit = svc.Audit
+						go func() {
+							defer svc.Errors.Recover(ctx)
+							engine.Run(ctx)
+						}()
+
+						svc.Events.Publish(services.EventCallStarted, callSID, services.CallStartedEvent{
+							CallerHash: conversation.CallerHash,
+							Persona:    channels.Persona().Name,
+							Language:   channels.Language(),
+						})
+						if len(svc.Events) > 0 {
+							conversation.OnRiskFlagged(func(flag, source string) {
+								svc.Events.Publish(services.EventRiskFlagged, callSID, services.RiskEventData{Flag: flag, Source: source})
+							})
+						}
+					}
+
 					// Send a welcome message
 					welcomeMsg := "Connection established. I'm listening."
 					select {
@@ -369,148 +404,39 @@ func HandleWebSocket(svc *services.ServiceContainer) http.HandlerFunc {
 			}
 		}
 
-		log.Info("WebSocket connection closed for call %s", callSID)
-	}
-}
-
-// Process transcriptions and generate responses
-func processTranscriptionsAndResponses(
-	ctx context.Context,
-	channels *services.ChannelData,
-	conversation *services.Conversation,
-	svc *services.ServiceContainer,
-	log *logger.Logger,
-) {
-	log.Info("Transcription processor started for call %s", channels.CallSID)
-
-	// Add a ticker to periodically check if we're receiving transcriptions
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-
-	// Create a transcription buffer
-	buffer := NewTranscriptionBuffer()
-
-	// Configure silence detection
-	silenceDuration := 2 * time.Second
-	log.Info("Silence detection configured for %v", silenceDuration)
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info("Transcription processor context done for call %s", channels.CallSID)
-			return
-		case <-ticker.C:
-			// Check if we should process the buffer
-			if buffer.ShouldProcess(silenceDuration) {
-				silenceTime := time.Since(buffer.LastActivity)
-				log.Info("Detected %v silence, processing transcriptions for call %s", silenceTime, channels.CallSID)
-
-				// Mark as processing to avoid concurrent processing
-				buffer.StartProcessing()
-
-				// Normalize transcriptions
-				normalized := buffer.NormalizeTranscriptions()
-				log.Info("Normalized transcription for call %s: %q", channels.CallSID, normalized)
-
-				if normalized != "" {
-					// Process the normalized transcription
-					processTranscription(ctx, normalized, channels, conversation, svc, log)
-				}
-
-				// Reset buffer
-				buffer.FinishProcessing()
-			}
-
-			// Periodically log status
-			if time.Since(buffer.LastActivity) > 10*time.Second && len(buffer.Transcriptions) > 0 {
-				log.Debug("Transcription buffer status: %d items, last activity %v ago",
-					len(buffer.Transcriptions), time.Since(buffer.LastActivity))
-			}
-
-		case transcription := <-channels.TranscriptionChan:
-			if transcription == "" {
-				log.Debug("Empty transcription received for call %s, ignoring", channels.CallSID)
-				continue
-			}
-
-			log.Debug("Transcription received for call %s: %q", channels.CallSID, transcription)
-			buffer.AddTranscription(transcription)
+		conversation.End()
+		if audioStarted {
+			svc.Events.Publish(services.EventCallEnded, callSID, services.CallEndedEvent{
+				DurationSeconds: conversation.EndedAt().Sub(conversation.CreatedAt).Seconds(),
+				MessageCount:    conversation.MessageCount(),
+				RiskFlags:       conversation.RiskFlags(),
+			})
+		}
+		profile := conversation.Profile()
+		if profile != nil {
+			profile.RememberPreferences(channels)
 		}
-	}
-}
-
-// Process a single normalized transcription
-func processTranscription(
-	ctx context.Context,
-	transcription string,
-	channels *services.ChannelData,
-	conversation *services.Conversation,
-	svc *services.ServiceContainer,
-	log *logger.Logger,
-) {
-	// Add user message to conversation
-	conversation.AddUserMessage(transcription)
-	log.Info("Added user message to conversation for call %s: %q", channels.CallSID, transcription)
-
-	// Get conversation history
-	history := conversation.GetFormattedHistory()
-	historyLength := len(history)
-	log.Debug("Retrieved conversation history for call %s, %d messages", channels.CallSID, historyLength)
-
-	// Generate AI response using Gemini
-	log.Info("Generating AI response for call %s", channels.CallSID)
-	startTime := time.Now()
-	response, err := svc.Gemini.GenerateResponse(ctx, transcription, history)
-	elapsed := time.Since(startTime)
-
-	if err != nil {
-		log.Error("Error generating response for call %s: %v (after %v)", channels.CallSID, err, elapsed)
-		// Send a fallback response in case of error
-		response = "I'm sorry, I'm having trouble understanding right now. Could you please repeat that?"
-	} else {
-		log.Info("AI response generated for call %s in %v", channels.CallSID, elapsed)
-	}
-
-	// Add AI response to conversation
-	conversation.AddTherapistMessage(response)
-	log.Info("Added therapist response to conversation for call %s", channels.CallSID)
-
-	// Send the response text to the channel
-	log.Debug("Sending text response to channel for call %s", channels.CallSID)
-	select {
-	case channels.ResponseTextChan <- response:
-		log.Debug("Text response sent to channel for call %s", channels.CallSID)
-	default:
-		log.Warn("ResponseTextChan is full for call %s, dropping message", channels.CallSID)
-	}
-
-	// Convert response to speech
-	log.Info("Converting response to speech for call %s", channels.CallSID)
-	startTime = time.Now()
-	audioData, err := svc.TextToSpeech.SynthesizeSpeech(ctx, response)
-	elapsed = time.Since(startTime)
-
-	if err != nil {
-		log.Error("Error synthesizing speech for call %s: %v (after %v)", channels.CallSID, err, elapsed)
-		return
-	}
 
-	log.Info("Text-to-speech conversion completed for call %s in %v, %d bytes",
-		channels.CallSID, elapsed, len(audioData))
+		// Let a referring organization know the session has ended
+		svc.Referrals.Complete(callSID)
 
-	// Save the TTS-generated audio to a file
-	if err := svc.TextToSpeech.SaveAudioToFile(channels.CallSID, response, audioData); err != nil {
-		log.Error("Error saving TTS audio to file for call %s: %v", channels.CallSID, err)
-		// Continue even if saving fails - this is a non-critical operation
-	}
+		// Summarize the call for follow-up and write notes for clinicians; they outlive the
+		// connection. The caller's profile is saved again with the summary, and the call is
+		// exported once summarized.
+		go func() {
+			defer svc.Errors.Recover(ctx)
+			svc.Conversation.SaveProfile(profile)
+			if summary, _ := svc.CallSummarizer.Summarize(context.Background(), conversation); summary != nil {
+				svc.Conversation.SaveProfile(profile)
+			}
+			svc.CallExport.Export(conversation)
+		}()
+		go func() {
+			defer svc.Errors.Recover(ctx)
+			svc.SessionNotes.Write(context.Background(), conversation)
+		}()
 
-	// Send the audio to the channel FOR the sendAudioResponses goroutine to handle
-	log.Info("Sending audio response to channel for call %s", channels.CallSID)
-	select {
-	case channels.ResponseAudioChan <- audioData:
-		log.Debug("Audio response sent to channel for call %s", channels.CallSID)
-	default:
-		log.Warn("ResponseAudioChan is full for call %s, dropping audio", channels.CallSID)
+		log.Info("WebSocket connection closed for call %s", callSID)
 	}
 }
 
@@ -519,9 +445,6 @@ func processTranscription(
 func sendAudioResponses(conn *websocket.Conn, channels *services.ChannelData, streamSID *string, streamMutex *sync.Mutex, log *logger.Logger) {
 	log.Info("Audio response sender started for call %s", channels.CallSID)
 
-	// Maximum chunk size to avoid large packets - keep under 16KB
-	const maxChunkSize = 3200 // 400ms of 8kHz audio (μ-law is 8000 samples/sec at 8-bit)
-
 	// Send media message in Twilio format
 	sendMediaMessage := func(data []byte) error {
 		// Get the current streamSID (could have been updated)
@@ -555,7 +478,11 @@ func sendAudioResponses(conn *websocket.Conn, channels *services.ChannelData, st
 
 		// Send the message
 		log.Info("Sending audio chunk of %d bytes", len(data))
-		return conn.WriteMessage(websocket.TextMessage, jsonBytes)
+		if err := conn.WriteMessage(websocket.TextMessage, jsonBytes); err != nil {
+			return err
+		}
+		channels.CountAudioOut(len(data))
+		return nil
 	}
 
 	for {
@@ -568,6 +495,10 @@ func sendAudioResponses(conn *websocket.Conn, channels *services.ChannelData, st
 
 			log.Info("Sending audio data via WebSocket for call %s: %d bytes", channels.CallSID, len(audioData))
 
+			// Maximum chunk size to avoid large packets - keep under 16KB
+			// 400ms of audio in the call's negotiated format (3200 bytes for 8kHz μ-law)
+			maxChunkSize := channels.GetAudioFormat().BytesPerSecond() * 2 / 5
+
 			// For large audio files, break them into smaller chunks
 			if len(audioData) > maxChunkSize {
 				log.Debug("Breaking audio into chunks for call %s, total size: %d bytes",
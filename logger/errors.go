@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorEvent is a record logged at ERROR, as passed to the hook set with OnError
+type ErrorEvent struct {
+	Time      time.Time
+	Message   string
+	Component string
+	Source    string            // file:line of the call that logged it, when known
+	Fields    map[string]string // The record's fields, e.g. callSid and correlationId
+}
+
+// errorHook is called with every record at ERROR, see OnError
+var errorHook atomic.Pointer[func(ErrorEvent)]
+
+// OnError has fn called with every record logged at ERROR by any logger, with personal
+// details already masked, e.g. to send them to an error tracker. fn must not block and
+// must not log at ERROR itself; nil removes the hook.
+func OnError(fn func(ErrorEvent)) {
+	if fn == nil {
+		errorHook.Store(nil)
+		return
+	}
+	errorHook.Store(&fn)
+}
+
+// hookHandler passes records at ERROR to the error hook, with the fields added to it
+type hookHandler struct {
+	slog.Handler
+	attrs []slog.Attr
+	group string
+}
+
+// Handle calls the error hook for records at ERROR and passes every record on
+func (h hookHandler) Handle(ctx context.Context, record slog.Record) error {
+	if hook := errorHook.Load(); hook != nil && record.Level >= slog.LevelError {
+		event := ErrorEvent{Time: record.Time, Message: record.Message, Fields: make(map[string]string)}
+		if record.PC != 0 {
+			frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+			event.Source = fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+		}
+		add := func(a slog.Attr) bool {
+			if a.Key == ComponentKey {
+				event.Component = a.Value.String()
+			} else {
+				event.Fields[a.Key] = a.Value.String()
+			}
+			return true
+		}
+		for _, a := range h.attrs {
+			add(a)
+		}
+		record.Attrs(add)
+		(*hook)(event)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs keeps the attributes for the events
+func (h hookHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	kept := append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + a.Key
+		}
+		kept = append(kept, a)
+	}
+	return hookHandler{Handler: h.Handler.WithAttrs(attrs), attrs: kept, group: h.group}
+}
+
+// WithGroup qualifies the keys of attributes added from now on
+func (h hookHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return hookHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs, group: h.group + name + "."}
+}
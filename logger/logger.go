@@ -1,11 +1,15 @@
 package logger
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Level defines the logging level
@@ -29,66 +33,256 @@ var levelNames = map[Level]string{
 	ERROR: "ERROR",
 }
 
-// Logger handles logging with different levels
+// slogLevels are the slog levels the logging levels map to
+var slogLevels = map[Level]slog.Level{
+	DEBUG: slog.LevelDebug,
+	INFO:  slog.LevelInfo,
+	WARN:  slog.LevelWarn,
+	ERROR: slog.LevelError,
+}
+
+// String returns the level's name, e.g. INFO
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name, ignoring case
+func ParseLevel(name string) (Level, bool) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			return level, true
+		}
+	}
+	return INFO, false
+}
+
+// levelOf maps a slog level back to the logging level at or below it
+func levelOf(level slog.Level) Level {
+	switch {
+	case level >= slog.LevelError:
+		return ERROR
+	case level >= slog.LevelWarn:
+		return WARN
+	case level >= slog.LevelInfo:
+		return INFO
+	}
+	return DEBUG
+}
+
+// Format is how log records are written
+type Format string
+
+const (
+	// TextFormat writes a line per record: [LEVEL][Component] message key=value...
+	TextFormat Format = "text"
+	// JSONFormat writes a JSON object per record, with the severity, message and source
+	// location under the keys Cloud Logging reads
+	JSONFormat Format = "json"
+)
+
+// ParseFormat parses a format name, ignoring case
+func ParseFormat(name string) (Format, bool) {
+	switch Format(strings.ToLower(strings.TrimSpace(name))) {
+	case TextFormat, "":
+		return TextFormat, true
+	case JSONFormat:
+		return JSONFormat, true
+	}
+	return TextFormat, false
+}
+
+// The keys of the fields records carry
+const (
+	ComponentKey     = "component"
+	CallSIDKey       = "callSid"
+	CorrelationIDKey = "correlationId"
+	TurnKey          = "turn"
+)
+
+// Logger handles logging with different levels. Messages are printf formatted, and
+// records carry the logger's fields: its component, and the call and turn when set.
 type Logger struct {
-	level     Level
-	mu        sync.Mutex
-	logger    *log.Logger
+	levels    *levels      // Shared with the logger's components
+	root      slog.Handler // Without the logger's fields
+	logger    *slog.Logger
 	component string
 }
 
+// levels is the level of a logger and its components, and the components' overrides of it
+type levels struct {
+	base       slog.LevelVar
+	mu         sync.RWMutex
+	components map[string]Level
+}
+
+// of returns the level records of the component are logged at
+func (v *levels) of(component string) Level {
+	if component != "" {
+		v.mu.RLock()
+		level, ok := v.components[component]
+		v.mu.RUnlock()
+		if ok {
+			return level
+		}
+	}
+	return levelOf(v.base.Level())
+}
+
+// Level is the lowest level any component logs at, which handlers let through; the
+// loggers filter by their component's level
+func (v *levels) Level() slog.Level {
+	lowest := v.base.Level()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, level := range v.components {
+		lowest = min(lowest, slogLevels[level])
+	}
+	return lowest
+}
+
 var (
 	defaultLogger *Logger
 	once          sync.Once
 )
 
-// Initialize initializes the default logger with the specified level
+// Initialize initializes the default logger with the specified level, writing text
 func Initialize(level Level) {
+	InitializeFormat(level, TextFormat)
+}
+
+// InitializeFormat initializes the default logger with the specified level and format.
+// Records of the standard log package and of slog's default logger go through it too.
+func InitializeFormat(level Level, format Format) {
 	once.Do(func() {
-		defaultLogger = NewLogger(os.Stdout, level, "")
-		log.SetOutput(io.Discard) // Redirect standard logger to discard
+		defaultLogger = New(os.Stdout, level, format, "")
+		slog.SetDefault(defaultLogger.logger)
 	})
 }
 
-// SetLevel sets the logging level for the default logger
+// SetLevel sets the logging level for the default logger and its components
 func SetLevel(level Level) {
 	if defaultLogger != nil {
 		defaultLogger.SetLevel(level)
 	}
 }
 
-// NewLogger creates a new logger with the specified writer and level
+// SetComponentLevel overrides the logging level of the default logger's component
+func SetComponentLevel(component string, level Level) {
+	GetDefaultLogger().SetComponentLevel(component, level)
+}
+
+// ClearComponentLevel removes the override of the component's level, so it logs at the
+// default logger's level again
+func ClearComponentLevel(component string) {
+	GetDefaultLogger().ClearComponentLevel(component)
+}
+
+// ComponentLevels returns the overrides of the default logger's component levels
+func ComponentLevels() map[string]Level {
+	return GetDefaultLogger().ComponentLevels()
+}
+
+// NewLogger creates a new text logger with the specified writer and level
 func NewLogger(out io.Writer, level Level, component string) *Logger {
-	return &Logger{
-		level:     level,
-		logger:    log.New(out, "", log.LstdFlags|log.Lshortfile),
-		component: component,
+	return New(out, level, TextFormat, component)
+}
+
+// New creates a new logger with the specified writer, level and format
+func New(out io.Writer, level Level, format Format, component string) *Logger {
+	shared := &levels{components: make(map[string]Level)}
+	shared.base.Set(slogLevels[level])
+
+	var root slog.Handler
+	if format == JSONFormat {
+		root = slog.NewJSONHandler(out, &slog.HandlerOptions{
+			AddSource:   true,
+			Level:       shared,
+			ReplaceAttr: cloudLoggingAttr,
+		})
+	} else {
+		root = &textHandler{out: out, mu: new(sync.Mutex), level: shared}
+	}
+
+	root = redactingHandler{hookHandler{Handler: root}}
+
+	l := &Logger{levels: shared, root: root, logger: slog.New(root)}
+	if component != "" {
+		return l.Component(component)
+	}
+	return l
+}
+
+// cloudLoggingAttr renames the level, message and source of JSON records to the keys
+// Cloud Logging reads them from
+func cloudLoggingAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.LevelKey:
+		severity := levelOf(a.Value.Any().(slog.Level)).String()
+		if severity == "WARN" {
+			severity = "WARNING"
+		}
+		return slog.String("severity", severity)
+	case slog.MessageKey:
+		a.Key = "message"
+	case slog.SourceKey:
+		a.Key = "logging.googleapis.com/sourceLocation"
 	}
+	return a
 }
 
-// SetLevel sets the logging level for this logger
+// SetLevel sets the logging level for this logger and its components without an
+// override of their own
 func (l *Logger) SetLevel(level Level) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.level = level
+	l.levels.base.Set(slogLevels[level])
+}
+
+// Level returns the logging level, the component's override when it has one
+func (l *Logger) Level() Level {
+	return l.levels.of(l.component)
+}
+
+// SetComponentLevel overrides the logging level of the component, e.g. to debug one
+// service during an incident without flooding the logs
+func (l *Logger) SetComponentLevel(component string, level Level) {
+	l.levels.mu.Lock()
+	defer l.levels.mu.Unlock()
+	l.levels.components[component] = level
+}
+
+// ClearComponentLevel removes the override of the component's level
+func (l *Logger) ClearComponentLevel(component string) {
+	l.levels.mu.Lock()
+	defer l.levels.mu.Unlock()
+	delete(l.levels.components, component)
+}
+
+// ComponentLevels returns the overrides of component levels
+func (l *Logger) ComponentLevels() map[string]Level {
+	l.levels.mu.RLock()
+	defer l.levels.mu.RUnlock()
+	overrides := make(map[string]Level, len(l.levels.components))
+	for component, level := range l.levels.components {
+		overrides[component] = level
+	}
+	return overrides
 }
 
 // log logs a message at the specified level
 func (l *Logger) log(level Level, format string, v ...interface{}) {
-	if level < l.level {
+	ctx := context.Background()
+	current := l.Level()
+	if level < current || !l.logger.Enabled(ctx, slogLevels[level]) {
 		return
 	}
+	v = transcriptArgs(current == DEBUG && transcripts.Load(), v)
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	prefix := fmt.Sprintf("[%s]", levelNames[level])
-	if l.component != "" {
-		prefix = fmt.Sprintf("%s[%s]", prefix, l.component)
-	}
-
-	msg := fmt.Sprintf(format, v...)
-	l.logger.Output(3, fmt.Sprintf("%s %s", prefix, msg))
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // Skip Callers, log and the level's method
+	record := slog.NewRecord(time.Now(), slogLevels[level], fmt.Sprintf(format, v...), pcs[0])
+	l.logger.Handler().Handle(ctx, record)
 }
 
 // Debug logs a debug message
@@ -111,15 +305,49 @@ func (l *Logger) Error(format string, v ...interface{}) {
 	l.log(ERROR, format, v...)
 }
 
-// Component returns a new logger with the specified component name
+// Component returns a new logger with the specified component name, sharing this
+// logger's level. It has none of this logger's other fields.
 func (l *Logger) Component(name string) *Logger {
 	return &Logger{
-		level:     l.level,
-		logger:    l.logger,
+		levels:    l.levels,
+		root:      l.root,
+		logger:    slog.New(l.root).With(ComponentKey, name),
 		component: name,
 	}
 }
 
+// With returns a logger adding the key-value pairs to every record, e.g.
+// With("streamSid", sid)
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{levels: l.levels, root: l.root, logger: l.logger.With(args...), component: l.component}
+}
+
+// WithCall returns a logger adding the call's SID to every record
+func (l *Logger) WithCall(callSID string) *Logger {
+	if callSID == "" {
+		return l
+	}
+	return l.With(CallSIDKey, callSID)
+}
+
+// WithCorrelation returns a logger adding the call's correlation ID to every record
+func (l *Logger) WithCorrelation(id string) *Logger {
+	if id == "" {
+		return l
+	}
+	return l.With(CorrelationIDKey, id)
+}
+
+// WithTurn returns a logger adding the number of the call's turn to every record
+func (l *Logger) WithTurn(turn int) *Logger {
+	return l.With(TurnKey, turn)
+}
+
+// Slog returns the logger as a slog.Logger, for code logging with slog's key-value API
+func (l *Logger) Slog() *slog.Logger {
+	return l.logger
+}
+
 // GetDefaultLogger returns the default logger
 func GetDefaultLogger() *Logger {
 	if defaultLogger == nil {
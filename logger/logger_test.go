@@ -2,6 +2,7 @@ package logger
 
 import (
 	"bytes"
+	"encoding/json"
 	"strings"
 	"testing"
 )
@@ -92,15 +93,138 @@ func TestDefaultLogger(t *testing.T) {
 	logger := GetDefaultLogger()
 
 	// Check level
-	if logger.level != INFO {
-		t.Errorf("Expected default logger level to be INFO, got %v", logger.level)
+	if logger.Level() != INFO {
+		t.Errorf("Expected default logger level to be INFO, got %v", logger.Level())
 	}
 
 	// Change level
 	SetLevel(ERROR)
 
 	// Check new level
-	if logger.level != ERROR {
-		t.Errorf("Expected default logger level to be ERROR, got %v", logger.level)
+	if logger.Level() != ERROR {
+		t.Errorf("Expected default logger level to be ERROR, got %v", logger.Level())
+	}
+}
+
+func TestComponentsShareTheLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	parent := NewLogger(buf, INFO, "")
+	component := parent.Component("Calls")
+
+	parent.SetLevel(DEBUG)
+	component.Debug("Now visible")
+	if !strings.Contains(buf.String(), "[DEBUG][Calls] Now visible") {
+		t.Errorf("Expected a level change to reach components, got %q", buf.String())
+	}
+
+	if level, ok := ParseLevel("warn"); !ok || level != WARN || level.String() != "WARN" {
+		t.Errorf("Expected warn parsed, got %v, %v", level, ok)
+	}
+	if _, ok := ParseLevel("loud"); ok {
+		t.Error("Expected an unknown level rejected")
+	}
+}
+
+func TestJSONFormatCarriesFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := New(buf, INFO, JSONFormat, "").Component("TurnEngine").WithCall("CA123").WithTurn(2)
+	log.Warn("Slow response: %dms", 900)
+	log.Debug("Filtered")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["severity"] != "WARNING" || record["message"] != "Slow response: 900ms" {
+		t.Errorf("Expected the severity and message, got %v", record)
+	}
+	if record[ComponentKey] != "TurnEngine" || record[CallSIDKey] != "CA123" || record[TurnKey] != float64(2) {
+		t.Errorf("Expected the component, call and turn fields, got %v", record)
+	}
+	if _, ok := record["logging.googleapis.com/sourceLocation"]; !ok {
+		t.Errorf("Expected the source location, got %v", record)
+	}
+}
+
+func TestTextFormatAppendsFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := NewLogger(buf, INFO, "WebSocket").WithCall("CA123").With("stream", "MZ 1")
+	log.Info("Connected")
+
+	if !strings.Contains(buf.String(), `[INFO][WebSocket] Connected callSid=CA123 stream="MZ 1"`) {
+		t.Errorf("Expected the fields after the message, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "logger_test.go:") {
+		t.Errorf("Expected the caller's file, got %q", buf.String())
+	}
+	if format, ok := ParseFormat("JSON"); !ok || format != JSONFormat {
+		t.Errorf("Expected json parsed, got %v, %v", format, ok)
+	}
+}
+
+func TestComponentLevelOverrides(t *testing.T) {
+	buf := new(bytes.Buffer)
+	parent := New(buf, WARN, JSONFormat, "")
+	engine := parent.Component("TurnEngine").WithCall("CA123")
+	other := parent.Component("WebSocket")
+
+	parent.SetComponentLevel("TurnEngine", DEBUG)
+	engine.Debug("Engine detail")
+	other.Info("Socket detail")
+	if !strings.Contains(buf.String(), "Engine detail") || strings.Contains(buf.String(), "Socket detail") {
+		t.Errorf("Expected only the overridden component at DEBUG, got %q", buf.String())
+	}
+	if engine.Level() != DEBUG || other.Level() != WARN {
+		t.Errorf("Expected DEBUG and WARN, got %v and %v", engine.Level(), other.Level())
+	}
+
+	parent.ClearComponentLevel("TurnEngine")
+	buf.Reset()
+	engine.Info("Back to the default")
+	if buf.Len() != 0 || len(parent.ComponentLevels()) != 0 {
+		t.Errorf("Expected the override removed, got %q", buf.String())
+	}
+}
+
+func TestPIIAndTranscriptsAreMasked(t *testing.T) {
+	t.Cleanup(func() { SetTranscriptLogging(false) })
+	buf := new(bytes.Buffer)
+	log := NewLogger(buf, DEBUG, "TurnEngine").WithCall("CA0123456789abcdef")
+	log.Info("Caller +15551234567 (jo@example.com) said %q", Transcript("my number is 555-123-4567"))
+
+	output := buf.String()
+	if strings.Contains(output, "15551234567") || strings.Contains(output, "jo@example.com") || strings.Contains(output, "my number") {
+		t.Errorf("Expected the caller's details masked, got %q", output)
+	}
+	if !strings.Contains(output, "Caller [phone] ([email]) said [25 chars redacted]") || !strings.Contains(output, "callSid=CA0123456789abcdef") {
+		t.Errorf("Expected placeholders and the call SID kept, got %q", output)
+	}
+
+	// Full transcripts need the opt-in and a logger at DEBUG
+	SetTranscriptLogging(true)
+	buf.Reset()
+	log.Debug("Heard %q", Transcript("I feel better"))
+	NewLogger(buf, INFO, "").Info("Heard %q", Transcript("I feel worse"))
+	if !strings.Contains(buf.String(), `Heard "I feel better"`) || strings.Contains(buf.String(), "worse") {
+		t.Errorf("Expected the transcript only at DEBUG, got %q", buf.String())
+	}
+}
+
+func TestMaskPII(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"my number is 555-123-4567", "my number is [phone]"},
+		{"it's (555) 123-4567 or 5551234567", "it's [phone] or [phone]"},
+		{"call +44 20 7946 0958 tomorrow", "call [phone] tomorrow"},
+		{"from +15551234567", "from [phone]"},
+		{"write to jo.doe+help@mail.example.org", "write to [email]"},
+		{"Hi, my name is Jo Smith and I can't sleep", "Hi, my name is [name] and I can't sleep"},
+		{"I'm Maria. This is hard", "I'm [name]. This is hard"},
+		{"I'm 34 and slept 3 hours on 12/03", "I'm 34 and slept 3 hours on 12/03"},
+		{"I'm tired, this is call CA0123456789abcdef0123456789abcdef of 1048576 bytes", "I'm tired, this is call CA0123456789abcdef0123456789abcdef of 1048576 bytes"},
+	}
+	for _, tt := range tests {
+		if got := MaskPII(tt.text); got != tt.want {
+			t.Errorf("MaskPII(%q) = %q, want %q", tt.text, got, tt.want)
+		}
 	}
 }
@@ -0,0 +1,115 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync/atomic"
+)
+
+// Patterns of personal details masked in every record and in text shared outside the service
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// Phone numbers after a +, as Twilio sends them, with or without separators; North
+	// American numbers written with separators; and bare runs of 10 to 15 digits, as
+	// speech recognition writes a number read out. Shorter runs are left alone, they are
+	// more often sizes, counts or dates, and so are digits within SIDs.
+	phonePattern = regexp.MustCompile(`\+\d(?:[\s.()-]*\d){6,14}\b|(?:\(\d{3}\)\s?|\b\d{3}[\s.-])\d{3}[\s.-]\d{4}\b|\b\d{10,15}\b`)
+	// Names as callers give them: a capitalized name, up to two words, after "my name is",
+	// "call me", "this is", "I'm" and the like. The cue is kept so the text still reads.
+	namePattern = regexp.MustCompile(`\b((?i:my name is|my name's|call me|i'm called|i am called|this is|i'm|i am)\s+)[A-Z][a-z'-]+(?:\s+[A-Z][a-z'-]+)?\b`)
+)
+
+// transcripts tells whether full transcripts may be logged, see SetTranscriptLogging
+var transcripts atomic.Bool
+
+// SetTranscriptLogging allows what callers said and what was answered to be logged in
+// full by loggers at DEBUG. Otherwise, and at other levels, only its length is.
+func SetTranscriptLogging(enabled bool) {
+	transcripts.Store(enabled)
+}
+
+// transcriptText is conversation content passed to a logger, see Transcript
+type transcriptText string
+
+// Transcript marks conversation content, e.g. a caller utterance or a response, in a
+// logger's arguments, so it is masked unless transcript logging is enabled
+func Transcript(text string) interface{} {
+	return transcriptText(text)
+}
+
+// redactedText stands in for conversation content that may not be logged
+type redactedText int
+
+// Format writes the placeholder whatever the verb, so %q doesn't quote it
+func (r redactedText) Format(f fmt.State, _ rune) {
+	fmt.Fprintf(f, "[%d chars redacted]", int(r))
+}
+
+// transcriptArgs replaces the conversation content in the arguments with its length
+// unless it may be logged in full
+func transcriptArgs(show bool, v []interface{}) []interface{} {
+	args, copied := v, false
+	for i, arg := range v {
+		text, ok := arg.(transcriptText)
+		if !ok {
+			continue
+		}
+		if !copied {
+			args, copied = append([]interface{}{}, v...), true // Leave the caller's slice alone
+		}
+		if show {
+			args[i] = string(text)
+		} else {
+			args[i] = redactedText(len(text))
+		}
+	}
+	return args
+}
+
+// MaskPII masks email addresses, phone numbers and the names people give in the text, in
+// log records and wherever else text leaves the service, e.g. redacted exports
+func MaskPII(text string) string {
+	text = emailPattern.ReplaceAllString(text, "[email]")
+	text = phonePattern.ReplaceAllString(text, "[phone]")
+	return namePattern.ReplaceAllString(text, "${1}[name]")
+}
+
+// redactingHandler masks personal details in the messages and string fields of records,
+// including those of the standard log package and slog's default logger
+type redactingHandler struct {
+	slog.Handler
+}
+
+// Handle masks the record and passes it on
+func (h redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	masked := slog.NewRecord(record.Time, record.Level, MaskPII(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(maskAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, masked)
+}
+
+// WithAttrs masks the attributes and keeps masking records
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		masked[i] = maskAttr(a)
+	}
+	return redactingHandler{h.Handler.WithAttrs(masked)}
+}
+
+// WithGroup keeps masking records
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+// maskAttr masks a string attribute's value
+func maskAttr(a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(MaskPII(a.Value.String()))
+	}
+	return a
+}
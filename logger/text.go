@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// textHandler writes records as the logger always has, one line each:
+// 2006/01/02 15:04:05 file.go:42: [INFO][Component] message key=value...
+type textHandler struct {
+	out   io.Writer
+	mu    *sync.Mutex // Shared by the handlers derived from this one
+	level slog.Leveler
+
+	component string
+	attrs     string // Preformatted " key=value" pairs
+	group     string // Prefix of the keys of attributes added from now on
+}
+
+// Enabled tells whether records at the level are written
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes the record
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	line.WriteString(record.Time.Format("2006/01/02 15:04:05 "))
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		fmt.Fprintf(&line, "%s:%d: ", filepath.Base(frame.File), frame.Line)
+	}
+
+	component := h.component
+	var attrs strings.Builder
+	attrs.WriteString(h.attrs)
+	record.Attrs(func(a slog.Attr) bool {
+		if a.Key == ComponentKey && h.group == "" {
+			component = a.Value.String()
+			return true
+		}
+		appendAttr(&attrs, h.group, a)
+		return true
+	})
+
+	fmt.Fprintf(&line, "[%s]", levelOf(record.Level))
+	if component != "" {
+		fmt.Fprintf(&line, "[%s]", component)
+	}
+	line.WriteString(" ")
+	line.WriteString(record.Message)
+	line.WriteString(attrs.String())
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, line.String())
+	return err
+}
+
+// WithAttrs returns a handler adding the attributes to every record
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	var formatted strings.Builder
+	formatted.WriteString(h.attrs)
+	for _, a := range attrs {
+		if a.Key == ComponentKey && h.group == "" {
+			derived.component = a.Value.String()
+			continue
+		}
+		appendAttr(&formatted, h.group, a)
+	}
+	derived.attrs = formatted.String()
+	return &derived
+}
+
+// WithGroup returns a handler qualifying the keys of attributes added from now on
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	derived := *h
+	derived.group = h.group + name + "."
+	return &derived
+}
+
+// appendAttr writes the attribute as " key=value", quoting values with spaces
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range a.Value.Group() {
+			appendAttr(b, prefix, member)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", group, a.Key, value)
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,59 +26,310 @@ func main() {
 		println("Warning: .env file not found")
 	}
 
-	// Load configuration
+	// Parse command-line flags: one per setting, each overriding its environment variable
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file; flags and environment variables override it")
+	migrateOnly := flag.Bool("migrate-only", false, "migrate the database schema and exit")
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	// Load configuration: flags, then environment variables, then the config file, then defaults
+	if *configFile != "" {
+		if err := config.LoadFile(*configFile); err != nil {
+			// The logger isn't set up until the file is loaded
+			fmt.Fprintln(os.Stderr, "Error loading config file:", err)
+			os.Exit(1)
+		}
+	}
 	cfg := config.Load()
 
 	// Initialize logger with configured level
-	logLevel := logger.INFO
-	switch cfg.LogLevel {
-	case "DEBUG":
-		logLevel = logger.DEBUG
-	case "INFO":
-		logLevel = logger.INFO
-	case "WARN":
-		logLevel = logger.WARN
-	case "ERROR":
-		logLevel = logger.ERROR
-	}
-	logger.Initialize(logLevel)
+	logLevel, _ := logger.ParseLevel(cfg.LogLevel)
+	logFormat, formatOK := logger.ParseFormat(cfg.LogFormat)
+	logger.InitializeFormat(logLevel, logFormat)
 	log := logger.GetDefaultLogger()
 	log.Info("Starting Call-Me-Help application...")
 	log.Info("Log level set to %s", cfg.LogLevel)
+	if !formatOK {
+		log.Warn("Unknown log format %q, writing text", cfg.LogFormat)
+	}
+	logger.SetTranscriptLogging(cfg.LogTranscripts)
+	if cfg.LogTranscripts {
+		log.Warn("Transcripts are logged in full by loggers at DEBUG")
+	}
 
-	// Parse command-line flags
-	port := flag.String("port", cfg.Port, "server port")
-	flag.Parse()
+	if *configFile != "" {
+		log.Info("Loaded config file %s", *configFile)
+	}
+	ctx := context.Background()
+
+	// Swap Secret Manager references (sm://...) in the environment for the secrets, and
+	// fetch those on their latest version again to pick up rotations
+	secrets, err := services.NewSecretResolver(ctx, cfg.GoogleProjectID, time.Duration(cfg.SecretsRefreshSeconds)*time.Second)
+	if err != nil {
+		log.Error("Failed to create Secret Manager client: %v", err)
+		os.Exit(1)
+	}
+	if err := secrets.ResolveEnv(ctx); err != nil {
+		log.Error("Failed to resolve secrets: %v", err)
+		os.Exit(1)
+	}
+	if secrets != nil {
+		cfg = config.Load()
+	}
+	go secrets.Run(ctx)
+
+	// Key the hashes callers are known by, so they can't be reversed by trying numbers
+	services.SetCallerHashKey(cfg.CallerHashSecret)
+	if cfg.CallerHashSecret == "" {
+		log.Warn("CALLER_HASH_SECRET is not set, caller hashes are unkeyed and can be reversed by trying numbers")
+	}
+
+	// Report errors and recovered panics to the error tracker
+	errorReporter, err := services.NewErrorReporter(cfg.SentryDSN, cfg.SentryEnvironment, cfg.SentryRelease)
+	if err != nil {
+		log.Error("Invalid error reporting configuration: %v", err)
+		os.Exit(1)
+	}
+	if errorReporter != nil {
+		logger.OnError(errorReporter.CaptureLog)
+		log.Info("Reporting errors to the error tracker (environment %s)", cfg.SentryEnvironment)
+	}
+	go errorReporter.Run(ctx)
+
+	// API keys, by who holds them, of the versioned API
+	apiKeys, err := handlers.ParseAPIKeys(cfg.APIKeys)
+	if err != nil {
+		log.Error("Invalid API_KEYS: %v", err)
+		os.Exit(1)
+	}
+	partnerKeys, err := handlers.ParseAPIKeys(cfg.PartnerKeys)
+	if err != nil {
+		log.Error("Invalid PARTNER_KEYS: %v", err)
+		os.Exit(1)
+	}
+	for name := range partnerKeys {
+		if _, ok := apiKeys[name]; ok {
+			// The audit log couldn't tell the two keys apart
+			log.Error("Invalid PARTNER_KEYS: %s also holds an API key", name)
+			os.Exit(1)
+		}
+	}
+	if cfg.APIAuth && cfg.AdminToken == "" && len(apiKeys) == 0 {
+		log.Warn("API_AUTH is on without API_KEYS or ADMIN_TOKEN, the API answers 403 except /health")
+	}
 
 	log.Info("Initializing services...")
 
-	// Initialize services
-	ctx := context.Background()
+	// Bring the SQL database's schema up to date before anything uses it
+	var db *sql.DB
+	if cfg.DatabaseURL != "" {
+		db, err = sql.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+		if err != nil {
+			log.Error("Failed to open the %s database: %v", cfg.DatabaseDriver, err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		if _, err := services.Migrate(ctx, db); err != nil {
+			log.Error("Failed to migrate the database: %v", err)
+			os.Exit(1)
+		}
+	} else if *migrateOnly {
+		log.Error("DATABASE_URL is required to migrate the database")
+		os.Exit(1)
+	}
+	if *migrateOnly {
+		return
+	}
+
+	// Load speech adaptation phrase sets and pick up edits while running
+	log.Info("Loading speech adaptation phrase sets...")
+	phraseSets, err := services.LoadPhraseSetStore(cfg.PhraseSetsFile)
+	if err != nil {
+		log.Error("Failed to load phrase sets: %v", err)
+		os.Exit(1)
+	}
+	go phraseSets.Watch(ctx, time.Duration(cfg.PhraseSetsReloadSeconds)*time.Second)
 
 	// Initialize Google Cloud clients
-	log.Info("Initializing Speech-to-Text service...")
-	speechClient, err := services.NewSpeechToTextService(ctx)
+	log.Info("Initializing Speech-to-Text service (%s)...", cfg.STTProvider)
+	speechClient, err := services.NewSpeechRecognizer(ctx, cfg, phraseSets)
 	if err != nil {
 		log.Error("Failed to create Speech-to-Text client: %v", err)
 		os.Exit(1)
 	}
 	defer speechClient.Close()
 
-	log.Info("Initializing Text-to-Speech service...")
-	ttsClient, err := services.NewTextToSpeechService(ctx)
+	log.Info("Initializing Text-to-Speech service (%s)...", cfg.TTSProvider)
+	ttsClient, err := services.NewTextToSpeechProvider(ctx, cfg)
 	if err != nil {
 		log.Error("Failed to create Text-to-Speech client: %v", err)
 		os.Exit(1)
 	}
 	defer ttsClient.Close()
 
-	log.Info("Initializing Gemini service...")
-	geminiClient, err := services.NewGeminiService(ctx)
+	// Load the system prompt templates and pick up edits while running
+	log.Info("Loading system prompt templates...")
+	prompts, err := services.LoadPromptStore(cfg.PromptsDir)
+	if err != nil {
+		log.Error("Failed to load system prompt templates: %v", err)
+		os.Exit(1)
+	}
+	go prompts.Watch(ctx, time.Duration(cfg.PromptsReloadSeconds)*time.Second)
+
+	log.Info("Initializing LLM service (%s)...", cfg.LLMProvider)
+	generation, err := services.LoadGenerationSettings(cfg)
+	if err != nil {
+		log.Error("Failed to load generation params: %v", err)
+		os.Exit(1)
+	}
+	llmClient, err := services.NewLLMProvider(ctx, cfg, prompts, generation)
+	if err != nil {
+		log.Error("Failed to create LLM client: %v", err)
+		os.Exit(1)
+	}
+	defer llmClient.Close()
+
+	// Retry on the fallback model when the primary fails or is too slow
+	var generator services.ResponseGenerator = llmClient
+	if cfg.LLMFallbackModel != "" {
+		generator = services.NewFallbackGenerator(generator, cfg.LLMFallbackModel, time.Duration(cfg.LLMLatencyBudgetMs)*time.Millisecond)
+	}
+
+	// Throttle LLM requests fairly across calls when a rate limit is configured
+	var llmThrottle *services.LLMThrottle
+	if cfg.LLMRateLimit > 0 {
+		llmThrottle = services.NewLLMThrottle(cfg.LLMRateLimit, cfg.LLMBurst)
+		generator = services.NewThrottledGenerator(generator, llmThrottle)
+	}
+
+	// Keep long calls' prompts within budget, summarizing older turns with the same LLM
+	var history *services.HistoryBudget
+	if cfg.LLMHistoryTokens > 0 {
+		var summarizer services.ResponseGenerator
+		if cfg.LLMHistorySummarize {
+			summarizer = generator
+		}
+		history = services.NewHistoryBudget(cfg.LLMHistoryTokens, cfg.LLMHistoryKeep, summarizer)
+	}
+
+	// Hold calls to their LLM budget; usage is tracked even without one
+	budget := services.NewTokenBudget(cfg.LLMCallMaxTokens, cfg.LLMCallMaxCost)
+	if budget != nil {
+		budget.PromptPrice = cfg.LLMPromptPrice
+		budget.ResponsePrice = cfg.LLMResponsePrice
+		if cfg.LLMBudgetWarnFraction > 0 && cfg.LLMBudgetWarnFraction < 1 {
+			budget.WarnFraction = cfg.LLMBudgetWarnFraction
+		}
+		budget.Model = cfg.LLMBudgetModel
+		budget.MaxOutputTokens = cfg.LLMBudgetMaxOutputTokens
+	}
+
+	// Load fallback phrases and prepare their audio for Twilio's default format, from disk when cached
+	log.Info("Loading fallback phrases...")
+	fallbacks, err := services.LoadFallbackLibrary(cfg.FallbackPhrasesFile, cfg.STTLanguageCode)
+	if err != nil {
+		log.Error("Failed to load fallback phrases: %v", err)
+		os.Exit(1)
+	}
+	if err := fallbacks.SetAudioDir(cfg.FallbackAudioDir); err != nil {
+		log.Error("Failed to create fallback audio directory: %v", err)
+		os.Exit(1)
+	}
+	go func() {
+		if err := fallbacks.Presynthesize(ctx, ttsClient, services.DefaultAudioFormat()); err != nil {
+			log.Warn("Fallback phrases will be synthesized on demand: %v", err)
+		}
+	}()
+
+	// Translate between the caller's language and the LLM's in translation mode
+	var translator services.Translator
+	if cfg.TranslationEnabled {
+		log.Info("Initializing Translation service (%s <-> %s)...", cfg.STTLanguageCode, cfg.TranslationPivotLanguage)
+		translationService, err := services.NewTranslationService(ctx)
+		if err != nil {
+			log.Error("Failed to create Translation client: %v", err)
+			os.Exit(1)
+		}
+		translator = translationService
+	}
+
+	// Voices callers can choose for their call
+	voices, err := services.ParseVoiceOptions(cfg.TTSVoiceOptions)
+	if err != nil {
+		log.Error("Failed to parse TTS_VOICE_OPTIONS: %v", err)
+		os.Exit(1)
+	}
+
+	// Voices callers detected speaking another language are answered in
+	languageVoices, err := services.ParseLanguageVoices(cfg.TTSLanguageVoices)
+	if err != nil {
+		log.Error("Failed to parse TTS_LANGUAGE_VOICES: %v", err)
+		os.Exit(1)
+	}
+
+	// Load the therapist personas callers can talk with, from their file or the config file
+	personas, err := services.LoadPersonaRegistry(cfg.PersonasFile)
+	if cfg.PersonasFile == "" && len(cfg.Personas) > 0 {
+		personas, err = services.NewPersonaRegistry(cfg.Personas, "the config file")
+	}
+	if err != nil {
+		log.Error("Failed to load personas: %v", err)
+		os.Exit(1)
+	}
+	if personas == nil {
+		personas = &services.PersonaRegistry{} // So a reload can add some
+	}
+
+	// Load the tenants served from this process, from their file or the config file
+	tenants, err := services.LoadTenantRegistry(cfg.TenantsFile)
+	if cfg.TenantsFile == "" && len(cfg.Tenants) > 0 {
+		tenants, err = services.NewTenantRegistry(cfg.Tenants, "the config file")
+	}
+	if err != nil {
+		log.Error("Failed to load tenants: %v", err)
+		os.Exit(1)
+	}
+	for _, tenant := range tenants.All() {
+		if _, ok := personas.Lookup(tenant.Persona); tenant.Persona != "" && !ok {
+			log.Error("Tenant %s answers as persona %q, which isn't defined", tenant.Name, tenant.Persona)
+			os.Exit(1)
+		}
+	}
+	voiceCatalog := services.NewVoiceCatalog(voices, languageVoices)
+
+	// Record administrative and operator actions in the append-only audit log
+	audit, err := services.OpenAuditLog(cfg.AuditLogFile)
+	if err != nil {
+		log.Error("Failed to open audit log: %v", err)
+		os.Exit(1)
+	}
+	defer audit.Close()
+
+	// Screen callers against the allow and deny lists
+	callers, err := services.NewCallerScreen(cfg.CallerAllowlist, cfg.CallerDenylist)
+	if err != nil {
+		log.Error("Invalid caller lists: %v", err)
+		os.Exit(1)
+	}
+
+	// Reload what can change while calls are live on SIGHUP or POST /api/v1/admin/reload
+	reloader := services.NewConfigReloader(*configFile, prompts, phraseSets, personas, voiceCatalog, callers)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			log.Info("Received SIGHUP, reloading configuration")
+			reloader.Reload()
+			audit.Record(services.AuditEntry{Actor: services.AuditActorSignal, Action: services.AuditConfigReload, Target: "SIGHUP"})
+		}
+	}()
+
+	// Load the sensitive terms masked in stored transcripts
+	masker, err := services.LoadTermMasker(cfg.MaskedTermsFile)
 	if err != nil {
-		log.Error("Failed to create Gemini client: %v", err)
+		log.Error("Failed to load masked terms: %v", err)
 		os.Exit(1)
 	}
-	defer geminiClient.Close()
 
 	// Initialize conversation service for context management
 	log.Info("Initializing Conversation service...")
@@ -85,45 +338,272 @@ func main() {
 	// Initialize channel manager
 	log.Info("Initializing Channel Manager...")
 	channelManager := services.NewChannelManager()
+	channelManager.Errors = errorReporter
+
+	// Archive the session records of ended calls, encrypted when a key is set
+	transcriptCipher, err := services.NewContentCipher(cfg.TranscriptEncryptionKey)
+	if err != nil {
+		log.Error("Invalid TRANSCRIPT_ENCRYPTION_KEY: %v", err)
+		os.Exit(1)
+	}
+	var transcripts services.TranscriptStore
+	var profiles services.ProfileStore
+	var callbacks services.CallbackStore
+	switch cfg.StorageBackend {
+	case services.StorageFirestore:
+		store, err := services.NewFirestoreStore(ctx, cfg.GoogleProjectID, cfg.FirestoreDatabase, cfg.FirestoreCollectionPrefix, transcriptCipher)
+		if err != nil {
+			log.Error("Failed to initialize Firestore storage: %v", err)
+			os.Exit(1)
+		}
+		transcripts, profiles, callbacks = store, store, store
+	case services.StorageSQL:
+		if db == nil {
+			log.Error("DATABASE_URL is required for the sql storage backend")
+			os.Exit(1)
+		}
+		store := services.NewSQLStore(db, cfg.DatabaseDriver, transcriptCipher)
+		transcripts, profiles, callbacks = store, store, store
+	case services.StorageDisk:
+		if archive := services.NewTranscriptArchive(cfg.TranscriptArchiveDir, transcriptCipher); archive != nil {
+			transcripts, profiles, callbacks = archive, archive, archive
+		} else if transcriptCipher != nil {
+			log.Warn("TRANSCRIPT_ENCRYPTION_KEY is set but TRANSCRIPT_ARCHIVE_DIR isn't, nothing is archived")
+		}
+	default:
+		log.Error("Invalid STORAGE_BACKEND %q, use disk, firestore or sql", cfg.StorageBackend)
+		os.Exit(1)
+	}
+	if profiles != nil {
+		conversationService.SetProfileStore(profiles)
+	}
+
+	// Evict ended calls from memory once they're past the retention, archiving them first
+	janitor := services.NewCallJanitor(conversationService, channelManager, time.Duration(cfg.CallRetentionMinutes)*time.Minute)
+	if janitor != nil && transcripts != nil {
+		// Each tenant's records are kept under its storage prefix
+		janitor.Persist = func(conv *services.Conversation) error {
+			return tenants.Store(transcripts, conv.CurrentTenant()).Save(conv)
+		}
+	}
+	if janitor != nil {
+		janitor.Audit = audit
+	}
+	go janitor.Run(ctx, time.Duration(cfg.CallSweepIntervalSeconds)*time.Second)
+
+	// Publish call lifecycle events to the configured webhooks
+	webhooks, err := services.NewWebhookPublisher(cfg.WebhookURLs, cfg.WebhookSecret, cfg.WebhookMaxAttempts, time.Duration(cfg.WebhookTimeoutMs)*time.Millisecond)
+	if err != nil {
+		log.Error("Invalid webhook configuration: %v", err)
+		os.Exit(1)
+	}
+	go webhooks.Run(ctx)
+
+	// Publish them to Pub/Sub for real-time consumers too
+	pubSub, err := services.NewPubSubPublisher(ctx, cfg.GoogleProjectID, cfg.PubSubTopic, cfg.PubSubMaxAttempts)
+	if err != nil {
+		log.Error("Invalid Pub/Sub configuration: %v", err)
+		os.Exit(1)
+	}
+	go pubSub.Run(ctx)
+
+	var events services.EventPublishers
+	if webhooks != nil {
+		events = append(events, webhooks)
+	}
+	if pubSub != nil {
+		events = append(events, pubSub)
+	}
+
+	// Track the turns' latency SLOs and alert when they burn their error budget too fast
+	slos := services.NewSLOTracker(map[string]time.Duration{
+		services.StageGeneration: time.Duration(cfg.SLOGenerationMs) * time.Millisecond,
+		services.StageSynthesis:  time.Duration(cfg.SLOSynthesisMs) * time.Millisecond,
+	}, cfg.SLOObjective, time.Duration(cfg.SLOWindowMinutes)*time.Minute, cfg.SLOBurnRateAlert)
+	if slos != nil {
+		slos.Events = events
+		slos.Slack = cfg.SLOSlackWebhookURL
+	}
+	go slos.Run(ctx, time.Minute)
+
+	// Rate limit the API and the Twilio webhooks per client IP, and calls per caller number
+	rateLimits := services.RateLimits{
+		API:      services.NewRateLimiter("API requests", cfg.RateLimitAPIPerMinute, time.Minute, cfg.RateLimitAPIBurst),
+		Webhooks: services.NewRateLimiter("Twilio webhooks", cfg.RateLimitWebhookPerMinute, time.Minute, cfg.RateLimitWebhookBurst),
+		Callers:  services.NewRateLimiter("calls", cfg.RateLimitCallsPerHour, time.Hour, cfg.RateLimitCallsBurst),
+	}
+	go rateLimits.API.Run(ctx, time.Minute)
+	go rateLimits.Webhooks.Run(ctx, time.Minute)
+	go rateLimits.Callers.Run(ctx, time.Minute)
+
+	// Stream ended calls into BigQuery for analysis
+	callExporter, err := services.NewCallExporter(ctx, cfg.GoogleProjectID, cfg.BigQueryTable, cfg.BigQueryTranscripts,
+		cfg.BigQueryBatchSize, time.Duration(cfg.BigQueryFlushSeconds)*time.Second, cfg.BigQueryMaxAttempts)
+	if err != nil {
+		log.Error("Invalid BigQuery export configuration: %v", err)
+		os.Exit(1)
+	}
+	go callExporter.Run(ctx)
+
+	// Initialize referral service for partner pre-registrations
+	log.Info("Initializing Referral service...")
+	referralService := services.NewReferralService(time.Duration(cfg.ReferralTTLHours) * time.Hour)
 
 	// Initialize Twilio client
 	log.Info("Initializing Twilio service...")
 	twilioClient := services.NewTwilioService()
+	secrets.OnRotate("TWILIO_AUTH_TOKEN", twilioClient.SetAuthToken)
+	if gemini, ok := llmClient.(*services.GeminiService); ok {
+		secrets.OnRotate("GEMINI_API_KEY", func(apiKey string) { gemini.SetAPIKey(ctx, apiKey) })
+	}
+
+	// Initialize voicemail service for the message-only line
+	log.Info("Initializing Voicemail service...")
+	voicemailService := services.NewVoicemailService(speechClient, generator, masker, time.Duration(cfg.CallbackRetentionHours)*time.Hour)
+	if callbacks != nil {
+		if err := voicemailService.SetCallbackStore(callbacks); err != nil {
+			log.Error("Failed to restore the callback queue: %v", err)
+		}
+	}
+
+	// Let the LLM act for callers: look up resources, schedule callbacks and text resources
+	var tools *services.ToolDispatcher
+	if cfg.LLMToolsEnabled {
+		if _, ok := llmClient.(services.ToolCallingGenerator); !ok {
+			log.Warn("LLM_TOOLS_ENABLED is set but the %s provider can't call tools, ignoring it", cfg.LLMProvider)
+		} else {
+			resources, err := services.LoadCrisisResources(cfg.CrisisResourcesFile)
+			if err != nil {
+				log.Error("Failed to load crisis resources: %v", err)
+				os.Exit(1)
+			}
+			tools = services.NewCallTools(resources, twilioClient, voicemailService)
+		}
+	}
+
+	// Have the LLM reply with speech and actions; tool calling takes precedence
+	var actions *services.ActionDispatcher
+	if cfg.LLMStructuredOutput {
+		if tools != nil {
+			log.Warn("LLM_STRUCTURED_OUTPUT is ignored while LLM tools are enabled")
+		} else {
+			actions = services.NewActionDispatcher(twilioClient)
+		}
+	}
+
+	// Check responses for unsafe advice, claims and length before they are spoken
+	var guardrail *services.ResponseGuardrail
+	if cfg.GuardrailsEnabled {
+		guardrail = services.NewResponseGuardrail(cfg.GuardrailMaxChars)
+	}
+
+	// Summarize calls for follow-up once they end
+	var callSummarizer *services.CallSummarizer
+	if cfg.CallSummaryEnabled {
+		callSummarizer = services.NewCallSummarizer(generator)
+	}
+
+	// Write clinician session notes on calls once they end
+	var sessionNotes *services.SessionNoteWriter
+	if cfg.SessionNotesEnabled {
+		sessionNotes = services.NewSessionNoteWriter(generator)
+	}
+
+	// Save responses and recordings, encrypted when a key is set
+	audioCipher, err := services.NewContentCipher(cfg.AudioEncryptionKey)
+	if err != nil {
+		log.Error("Invalid AUDIO_ENCRYPTION_KEY: %v", err)
+		os.Exit(1)
+	}
+	audioStore := services.NewAudioFileStore(cfg.AudioOutputDirectory, cfg.AudioFileType, audioCipher)
+
+	// Probe the dependencies calls need when a deep health check is asked for
+	health := services.NewHealthChecker(time.Duration(cfg.HealthProbeTimeoutMs)*time.Millisecond, time.Duration(cfg.HealthProbeCacheSeconds)*time.Second)
+	health.Add("speechToText", speechClient)
+	health.Add("textToSpeech", ttsClient)
+	health.Add("llm", llmClient)
+	health.Add("twilio", twilioClient)
+	health.Add("audioStorage", audioStore)
+
+	// Run synthetic turns through the providers to catch outages before callers do
+	synthetic := services.NewSyntheticMonitor(speechClient, llmClient, ttsClient, cfg.SyntheticMonitorPhrase, cfg.SyntheticMonitorAudioFile,
+		time.Duration(cfg.SyntheticMonitorIntervalSeconds)*time.Second, time.Duration(cfg.SyntheticMonitorTimeoutSeconds)*time.Second)
+	go synthetic.Run(ctx)
 
 	// Create service container
 	log.Info("Creating service container...")
 	serviceContainer := &services.ServiceContainer{
 		SpeechToText:   speechClient,
 		TextToSpeech:   ttsClient,
-		Gemini:         geminiClient,
+		AudioStore:     audioStore,
+		LLM:            llmClient,
+		Generator:      generator,
+		LLMThrottle:    llmThrottle,
+		History:        history,
+		Budget:         budget,
+		Tools:          tools,
+		Guardrail:      guardrail,
+		Actions:        actions,
+		CallSummarizer: callSummarizer,
+		SessionNotes:   sessionNotes,
+		Analytics:      services.NewCallAnalytics(conversationService),
 		Twilio:         twilioClient,
 		Conversation:   conversationService,
 		ChannelManager: channelManager,
+		Janitor:        janitor,
+		Transcripts:    transcripts,
+		Events:         events,
+		CallExport:     callExporter,
+		Referrals:      referralService,
+		Voicemail:      voicemailService,
+		Fallbacks:      fallbacks,
+		Masker:         masker,
+		Translator:     translator,
+		Voices:         voiceCatalog,
+		Reloader:       reloader,
+		Errors:         errorReporter,
+		Personas:       personas,
+		Tenants:        tenants,
+		Health:         health,
+		Synthetic:      synthetic,
+		SLOs:           slos,
+		Audit:          audit,
+		RateLimits:     rateLimits,
+		Callers:        callers,
+		AudioURLs:      services.NewURLSigner(cfg.AudioURLSecret, time.Duration(cfg.AudioURLTTLSeconds)*time.Second),
 	}
 
 	// Setup HTTP handlers
 	log.Info("Setting up HTTP handlers...")
+	if !cfg.TwilioValidateSignature {
+		log.Warn("TWILIO_VALIDATE_SIGNATURE is off, Twilio webhooks are accepted without a signature")
+	}
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /twilio/call", handlers.HandleIncomingCall(serviceContainer))
+	mux.HandleFunc("POST /twilio/call", handlers.ValidateTwilioSignature(serviceContainer, cfg, handlers.LimitCallWebhook(serviceContainer, cfg, handlers.HandleIncomingCall(serviceContainer, cfg))))
+	mux.HandleFunc("POST /twilio/consent", handlers.ValidateTwilioSignature(serviceContainer, cfg, handlers.LimitWebhook(serviceContainer, cfg, handlers.HandleRecordingConsent(serviceContainer, cfg))))
+	mux.HandleFunc("POST /twilio/persona", handlers.ValidateTwilioSignature(serviceContainer, cfg, handlers.LimitWebhook(serviceContainer, cfg, handlers.HandlePersonaSelection(serviceContainer, cfg))))
+	mux.HandleFunc("POST /twilio/voice", handlers.ValidateTwilioSignature(serviceContainer, cfg, handlers.LimitWebhook(serviceContainer, cfg, handlers.HandleVoiceSelection(serviceContainer, cfg))))
+	mux.HandleFunc("POST /twilio/voicemail", handlers.ValidateTwilioSignature(serviceContainer, cfg, handlers.LimitWebhook(serviceContainer, cfg, handlers.HandleVoicemailRecording(serviceContainer))))
 	mux.HandleFunc("GET /ws", handlers.HandleWebSocket(serviceContainer))
 
-	// Audio file handling endpoints
-	mux.HandleFunc("GET /audio", handlers.ListAudioFiles())
-	mux.HandleFunc("GET /audio/download/{filename}", handlers.DownloadAudioFile())
+	// Versioned API with its OpenAPI document at /api/v1/openapi.json
+	api := handlers.RegisterAPI(mux, serviceContainer, cfg)
+	api.Keys = apiKeys
+	api.PartnerKeys = partnerKeys
 
-	// Health check endpoint
-	mux.HandleFunc("GET /health", handlers.HealthCheck)
+	// Unversioned health check for load balancers
+	mux.HandleFunc("GET /health", handlers.HealthCheck(serviceContainer))
 
 	// Create the HTTP server
 	server := &http.Server{
-		Addr:    ":" + *port,
-		Handler: mux,
+		Addr:    ":" + cfg.Port,
+		Handler: handlers.RecoverPanics(errorReporter, mux),
 	}
 
 	// Start the server in a goroutine
 	go func() {
-		log.Info("Server starting on port %s", *port)
+		log.Info("Server starting on port %s", cfg.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Error("Server error: %v", err)
 			os.Exit(1)
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Actions the LLM can ask for alongside its speech in structured output mode
+const (
+	AgentSendResourcesSMS = "send_resources_sms"
+	AgentFlagRisk         = "flag_risk"
+	AgentEndCall          = "end_call"
+)
+
+// agentReplyInstructions describes the structured reply to the LLM. Gemini is also held
+// to agentReplySchema; other providers only have these instructions to go on.
+var agentReplyInstructions = `Answer with only a JSON object, without a code block: {"speech": what you say to the caller, "actions": a list of actions, usually empty}. ` +
+	`Actions are objects with a "type": ` +
+	`"send_resources_sms" with a "message" listing resources and their phone numbers, only when the caller agrees to a text; ` +
+	`"flag_risk" with a "risk" from ` + strings.Join(riskFlags, ", ") + ` and a short "reason", whenever the caller mentions one; ` +
+	`"end_call", only after saying goodbye to a caller who wants to hang up.`
+
+// geminiSchema is a Gemini response schema, a subset of OpenAPI
+type geminiSchema struct {
+	Type        string                   `json:"type"`
+	Description string                   `json:"description,omitempty"`
+	Enum        []string                 `json:"enum,omitempty"`
+	Properties  map[string]*geminiSchema `json:"properties,omitempty"`
+	Items       *geminiSchema            `json:"items,omitempty"`
+	Required    []string                 `json:"required,omitempty"`
+}
+
+// agentReplySchema is the structure Gemini answers with in structured output mode
+var agentReplySchema = &geminiSchema{
+	Type: "OBJECT",
+	Properties: map[string]*geminiSchema{
+		"speech": {Type: "STRING", Description: "What to say to the caller"},
+		"actions": {Type: "ARRAY", Items: &geminiSchema{
+			Type: "OBJECT",
+			Properties: map[string]*geminiSchema{
+				"type":    {Type: "STRING", Enum: []string{AgentSendResourcesSMS, AgentFlagRisk, AgentEndCall}},
+				"message": {Type: "STRING", Description: "For send_resources_sms: the resources to text, with their phone numbers"},
+				"risk":    {Type: "STRING", Enum: riskFlags, Description: "For flag_risk: the risk the caller mentioned"},
+				"reason":  {Type: "STRING", Description: "For flag_risk: what the caller said, in a few words"},
+			},
+			Required: []string{"type"},
+		}},
+	},
+	Required: []string{"speech", "actions"},
+}
+
+// agentReplyContextKey is the context key type for asking for a structured reply
+type agentReplyContextKey struct{}
+
+// WithAgentReply returns a context asking the LLM provider for a structured reply of
+// speech and actions, held to agentReplySchema where the provider supports it
+func WithAgentReply(ctx context.Context) context.Context {
+	return context.WithValue(ctx, agentReplyContextKey{}, true)
+}
+
+// agentReplyFromContext reports whether WithAgentReply asked for a structured reply
+func agentReplyFromContext(ctx context.Context) bool {
+	structured, _ := ctx.Value(agentReplyContextKey{}).(bool)
+	return structured
+}
+
+// AgentAction is something the LLM asked to do for the caller
+type AgentAction struct {
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+	Risk    string `json:"risk,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// AgentReply is a structured LLM reply: the speech for TTS and the actions to take
+type AgentReply struct {
+	Speech  string        `json:"speech"`
+	Actions []AgentAction `json:"actions"`
+}
+
+// ParseAgentReply reads a structured reply, which may still be wrapped in a code block. A
+// response that isn't one, e.g. from a provider that ignored the format, is all speech.
+func ParseAgentReply(response string) AgentReply {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start >= 0 && end > start {
+		var reply AgentReply
+		if err := json.Unmarshal([]byte(response[start:end+1]), &reply); err == nil && strings.TrimSpace(reply.Speech) != "" {
+			reply.Speech = strings.TrimSpace(reply.Speech)
+			return reply
+		}
+	}
+	return AgentReply{Speech: response}
+}
+
+// ActionDispatcher runs the actions of structured replies
+type ActionDispatcher struct {
+	sms SMSSender
+	log *logger.Logger
+}
+
+// NewActionDispatcher creates a dispatcher texting resources with the sender
+func NewActionDispatcher(sms SMSSender) *ActionDispatcher {
+	return &ActionDispatcher{sms: sms, log: logger.Component("ActionDispatcher")}
+}
+
+// Dispatch runs a reply's actions for the call: risks are flagged on the conversation and
+// resources texted to the caller in the background, so the caller isn't kept waiting. It
+// reports whether the LLM asked to end the call. Unknown actions are logged and skipped.
+func (d *ActionDispatcher) Dispatch(conv *Conversation, callerNumber string, actions []AgentAction) (endCall bool) {
+	if d == nil {
+		return false
+	}
+	for _, action := range actions {
+		switch action.Type {
+		case AgentFlagRisk:
+			if flag := normalizeRiskFlag(action.Risk); flag != "" {
+				conv.FlagRisk(flag)
+				d.log.Warn("Risk %s flagged on call %s: %s", flag, conv.ID, action.Reason)
+			} else {
+				d.log.Warn("Unknown risk %q flagged on call %s", action.Risk, conv.ID)
+			}
+		case AgentSendResourcesSMS:
+			message := strings.TrimSpace(action.Message)
+			if callerNumber == "" || message == "" || d.sms == nil {
+				d.log.Warn("Cannot text resources on call %s without a caller number and a message", conv.ID)
+				continue
+			}
+			if runes := []rune(message); len(runes) > maxToolSMSLength {
+				message = string(runes[:maxToolSMSLength])
+			}
+			// Every text carries the crisis contacts, whatever the model wrote
+			go func() {
+				if err := d.sms.SendMessage(callerNumber, message+"\n"+voicemailResources); err != nil {
+					d.log.Error("Failed to text resources on call %s: %v", conv.ID, err)
+				}
+			}()
+		case AgentEndCall:
+			endCall = true
+		default:
+			d.log.Warn("Skipping unknown action %q on call %s", action.Type, conv.ID)
+		}
+	}
+	return endCall
+}
+
+// normalizeRiskFlag returns the known risk flag the LLM meant, e.g. self_harm for
+// "Self harm", or "" when there is none
+func normalizeRiskFlag(risk string) string {
+	risk = strings.ReplaceAll(strings.TrimSpace(risk), " ", "_")
+	for _, flag := range riskFlags {
+		if strings.EqualFold(risk, flag) {
+			return flag
+		}
+	}
+	return ""
+}
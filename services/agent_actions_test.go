@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAgentReply(t *testing.T) {
+	reply := ParseAgentReply("```json\n" + `{"speech": " I'm glad you called. ", "actions": [{"type": "flag_risk", "risk": "self_harm", "reason": "cutting"}]}` + "\n```")
+	want := AgentReply{Speech: "I'm glad you called.", Actions: []AgentAction{{Type: AgentFlagRisk, Risk: RiskSelfHarm, Reason: "cutting"}}}
+	if !reflect.DeepEqual(reply, want) {
+		t.Errorf("Expected %+v, got %+v", want, reply)
+	}
+
+	for _, response := range []string{"That sounds hard.", `{"actions": [{"type": "end_call"}]}`, "Use {braces} loosely."} {
+		if reply := ParseAgentReply(response); reply.Speech != response || reply.Actions != nil {
+			t.Errorf("Expected %q spoken as it is, got %+v", response, reply)
+		}
+	}
+}
+
+// smsChannel is an SMSSender passing the texts it sends to a channel
+type smsChannel chan string
+
+func (s smsChannel) SendMessage(to, message string) error {
+	s <- to + ": " + message
+	return nil
+}
+
+func TestActionDispatcher(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("test-call")
+	sms := make(smsChannel, 1)
+	dispatcher := NewActionDispatcher(sms)
+
+	endCall := dispatcher.Dispatch(conv, "+15550100", []AgentAction{
+		{Type: AgentFlagRisk, Risk: "Substance use"},
+		{Type: AgentFlagRisk, Risk: "substance_use"},
+		{Type: AgentFlagRisk, Risk: "boredom"},
+		{Type: AgentSendResourcesSMS, Message: "SAMHSA: 1-800-662-4357"},
+		{Type: "order_pizza"},
+	})
+	if endCall {
+		t.Error("Expected the call to go on")
+	}
+	if flags := conv.RiskFlags(); !reflect.DeepEqual(flags, []string{RiskSubstanceUse}) {
+		t.Errorf("Expected the known risk flagged once, got %q", flags)
+	}
+	select {
+	case text := <-sms:
+		if !strings.HasPrefix(text, "+15550100: SAMHSA: 1-800-662-4357\n") || !strings.Contains(text, "988") {
+			t.Errorf("Expected the resources with the crisis contacts, got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the resources to be texted")
+	}
+
+	if !dispatcher.Dispatch(conv, "", []AgentAction{{Type: AgentSendResourcesSMS, Message: "988"}, {Type: AgentEndCall}}) {
+		t.Error("Expected end_call to end the call")
+	}
+	var none *ActionDispatcher
+	if none.Dispatch(conv, "", []AgentAction{{Type: AgentEndCall}}) {
+		t.Error("Expected a nil dispatcher to do nothing")
+	}
+}
+
+// agentGenerator is a ResponseGenerator answering structured requests with a reply
+type agentGenerator struct {
+	reply      string
+	structured bool
+	history    []string
+}
+
+func (a *agentGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	a.structured, a.history = agentReplyFromContext(ctx), conversationHistory
+	return a.reply, nil
+}
+
+func TestTurnEngineSpeaksAndActsOnStructuredReplies(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &agentGenerator{reply: `{"speech": "Thank you for trusting me with this. Take care.", "actions": [{"type": "flag_risk", "risk": "abuse"}, {"type": "end_call"}]}`}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+	engine.Actions = NewActionDispatcher(nil)
+
+	turn := engine.ProcessTranscription(context.Background(), "He hit me again, but I'll be fine")
+	if !generator.structured || generator.history[len(generator.history)-1] != "Context: "+agentReplyInstructions {
+		t.Errorf("Expected a structured reply asked for, got %q", generator.history)
+	}
+	if turn.Response != "Thank you for trusting me with this. Take care." || turn.Action != ActionEnd {
+		t.Errorf("Expected only the speech spoken and the call ended, got %+v", turn)
+	}
+	if flags := conversation.RiskFlags(); !reflect.DeepEqual(flags, []string{RiskAbuse}) {
+		t.Errorf("Expected abuse flagged, got %q", flags)
+	}
+}
+
+func TestGeminiRequestHoldsStructuredRepliesToTheSchema(t *testing.T) {
+	var settings *GenerationSettings
+	body, err := geminiRequestBody(nil, nil, settings.For(WithAgentReply(context.Background())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request struct {
+		GenerationConfig struct {
+			ResponseMimeType string        `json:"responseMimeType"`
+			ResponseSchema   *geminiSchema `json:"responseSchema"`
+		} `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.GenerationConfig.ResponseMimeType != "application/json" || !reflect.DeepEqual(request.GenerationConfig.ResponseSchema, agentReplySchema) {
+		t.Errorf("Expected the agent reply schema, got %s", body)
+	}
+
+	body, _ = geminiRequestBody(nil, nil, settings.For(context.Background()))
+	if strings.Contains(string(body), "responseSchema") {
+		t.Errorf("Expected no schema for plain replies, got %s", body)
+	}
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Analytics bucket sizes
+const (
+	AnalyticsDaily  = "day"
+	AnalyticsWeekly = "week"
+)
+
+// maxTopTopics is how many of the most discussed topics a report lists
+const maxTopTopics = 10
+
+// CallRecord is what analytics count of an ended call
+type CallRecord struct {
+	CallSID   string
+	StartedAt time.Time
+	Duration  time.Duration
+	Turns     int      // Times the caller spoke
+	Topics    []string // From the call's summary, once written
+	Escalated bool     // The caller was put through to a person
+}
+
+// CallAnalytics aggregates ended calls over time, from the conversations held in memory
+// and the session records stored of those the janitor evicted, so calls still count after
+// a restart. Without a transcript store, evicted calls aren't counted.
+type CallAnalytics struct {
+	conversations *ConversationService
+}
+
+// AnalyticsQuery selects the calls a report covers and how they are bucketed
+type AnalyticsQuery struct {
+	From     time.Time // Calls started at or after From
+	To       time.Time // Calls started before To
+	Interval string    // AnalyticsDaily or AnalyticsWeekly
+}
+
+// AnalyticsBucket aggregates the calls that started in a period
+type AnalyticsBucket struct {
+	Start                  time.Time `json:"start"`
+	Calls                  int       `json:"calls"`
+	AverageDurationSeconds float64   `json:"averageDurationSeconds"`
+	TurnsPerCall           float64   `json:"turnsPerCall"`
+	EscalationRate         float64   `json:"escalationRate"` // Share of calls put through to a person
+}
+
+// TopicCount is how many calls discussed a topic
+type TopicCount struct {
+	Topic string `json:"topic"`
+	Calls int    `json:"calls"`
+}
+
+// AnalyticsReport aggregates the calls in a date range, in total and per period
+type AnalyticsReport struct {
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Interval  string            `json:"interval"`
+	Totals    AnalyticsBucket   `json:"totals"`
+	Buckets   []AnalyticsBucket `json:"buckets"` // Every period of the range, oldest first
+	TopTopics []TopicCount      `json:"topTopics"`
+}
+
+// NewCallAnalytics creates an analytics service counting the service's conversations
+func NewCallAnalytics(conversations *ConversationService) *CallAnalytics {
+	return &CallAnalytics{conversations: conversations}
+}
+
+// newCallRecord is what analytics count of an ended conversation
+func newCallRecord(conv *Conversation) CallRecord {
+	record := CallRecord{
+		CallSID:   conv.ID,
+		StartedAt: conv.CreatedAt,
+		Duration:  conv.EndedAt().Sub(conv.CreatedAt),
+		Escalated: conv.HasTags(TagEscalated),
+	}
+	for _, msg := range conv.Transcript() {
+		if msg.Role == "user" {
+			record.Turns++
+		}
+	}
+	record.Topics = summaryTopics(conv.CallSummary())
+	return record
+}
+
+// storedCallRecord is what analytics count of a call from its stored session record
+func storedCallRecord(export TranscriptExport) CallRecord {
+	record := CallRecord{
+		CallSID:   export.CallSID,
+		StartedAt: export.StartedAt,
+		Escalated: slices.Contains(export.Tags, TagEscalated),
+		Topics:    summaryTopics(export.Summary),
+	}
+	if export.EndedAt != nil {
+		record.Duration = export.EndedAt.Sub(export.StartedAt)
+	}
+	for _, msg := range export.Messages {
+		if msg.Speaker == "Caller" {
+			record.Turns++
+		}
+	}
+	return record
+}
+
+// summaryTopics are the distinct topics of a call's summary, in lower case, none until
+// it is written
+func summaryTopics(summary *CallSummary) []string {
+	if summary == nil {
+		return nil
+	}
+	var topics []string
+	for _, topic := range summary.Topics {
+		if topic = strings.ToLower(strings.TrimSpace(topic)); topic != "" && !slices.Contains(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// Report aggregates the ended calls in the query's range, which must be set, reading
+// those evicted from memory from store, nil when calls aren't archived; a nil service
+// reports no calls
+func (a *CallAnalytics) Report(query AnalyticsQuery, store TranscriptStore) (AnalyticsReport, error) {
+	if query.Interval != AnalyticsWeekly {
+		query.Interval = AnalyticsDaily
+	}
+	report := AnalyticsReport{From: query.From, To: query.To, Interval: query.Interval, Buckets: []AnalyticsBucket{}, TopTopics: []TopicCount{}}
+
+	// Buckets start at midnight UTC, on Mondays for weeks
+	bucketStart := func(t time.Time) time.Time {
+		day := t.UTC().Truncate(24 * time.Hour)
+		if query.Interval == AnalyticsWeekly {
+			day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		}
+		return day
+	}
+	next := func(t time.Time) time.Time {
+		if query.Interval == AnalyticsWeekly {
+			return t.AddDate(0, 0, 7)
+		}
+		return t.AddDate(0, 0, 1)
+	}
+
+	var records []CallRecord
+	if a != nil {
+		calls := ConversationQuery{From: query.From, To: query.To}
+		convs, _ := a.conversations.ListConversations(calls)
+		for _, conv := range convs {
+			if !conv.EndedAt().IsZero() {
+				records = append(records, newCallRecord(conv))
+			}
+		}
+		if store != nil {
+			stored, err := store.List(calls)
+			if err != nil {
+				return report, err
+			}
+			for _, export := range stored {
+				// A call still in memory was counted as it is now
+				if _, held := a.conversations.GetConversation(export.CallSID); !held && export.EndedAt != nil {
+					records = append(records, storedCallRecord(export))
+				}
+			}
+		}
+	}
+
+	byBucket := make(map[time.Time][]CallRecord)
+	topics := make(map[string]int)
+	for _, record := range records {
+		start := bucketStart(record.StartedAt)
+		byBucket[start] = append(byBucket[start], record)
+		for _, topic := range record.Topics {
+			topics[topic]++
+		}
+	}
+	for start := bucketStart(query.From); start.Before(query.To); start = next(start) {
+		report.Buckets = append(report.Buckets, aggregateCalls(start, byBucket[start]))
+	}
+	report.Totals = aggregateCalls(query.From, records)
+
+	for topic, calls := range topics {
+		report.TopTopics = append(report.TopTopics, TopicCount{Topic: topic, Calls: calls})
+	}
+	slices.SortFunc(report.TopTopics, func(a, b TopicCount) int {
+		if a.Calls != b.Calls {
+			return b.Calls - a.Calls
+		}
+		return strings.Compare(a.Topic, b.Topic)
+	})
+	if len(report.TopTopics) > maxTopTopics {
+		report.TopTopics = report.TopTopics[:maxTopTopics]
+	}
+	return report, nil
+}
+
+// aggregateCalls sums up the calls of a period
+func aggregateCalls(start time.Time, records []CallRecord) AnalyticsBucket {
+	bucket := AnalyticsBucket{Start: start, Calls: len(records)}
+	if len(records) == 0 {
+		return bucket
+	}
+	var duration time.Duration
+	var turns, escalated int
+	for _, record := range records {
+		duration += record.Duration
+		turns += record.Turns
+		if record.Escalated {
+			escalated++
+		}
+	}
+	calls := float64(len(records))
+	bucket.AverageDurationSeconds = math.Round(duration.Seconds()/calls*10) / 10
+	bucket.TurnsPerCall = math.Round(float64(turns)/calls*10) / 10
+	bucket.EscalationRate = math.Round(float64(escalated)/calls*1000) / 1000
+	return bucket
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallAnalyticsReport(t *testing.T) {
+	monday := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	service := NewConversationService()
+	analytics := NewCallAnalytics(service)
+	archive := NewTranscriptArchive(t.TempDir(), nil)
+	for _, call := range []struct {
+		id        string
+		start     time.Time
+		minutes   int
+		turns     int
+		topics    []string
+		escalated bool
+	}{
+		{"mon-1", monday.Add(9 * time.Hour), 10, 4, []string{"Job loss", "sleep"}, false},
+		{"mon-2", monday.Add(20 * time.Hour), 20, 6, []string{"sleep"}, true},
+		{"wed", monday.AddDate(0, 0, 2).Add(12 * time.Hour), 6, 2, nil, false},
+		{"next-week", monday.AddDate(0, 0, 7).Add(12 * time.Hour), 30, 10, []string{"grief"}, false},
+		{"too-late", monday.AddDate(0, 0, 14), 5, 1, []string{"ignored"}, false},
+	} {
+		conv := service.GetOrCreateConversation(call.id)
+		conv.CreatedAt = call.start
+		for range call.turns {
+			conv.AddUserMessage("...")
+		}
+		if call.escalated {
+			conv.AddTags(TagEscalated)
+		}
+		conv.End()
+		conv.endedAt = call.start.Add(time.Duration(call.minutes) * time.Minute)
+		conv.SetCallSummary(&CallSummary{Topics: call.topics})
+		// Calls evicted from memory are counted from their stored records
+		if call.id == "mon-2" || call.id == "next-week" {
+			if err := archive.Save(conv); err != nil {
+				t.Fatal(err)
+			}
+			service.RemoveConversation(call.id)
+		}
+	}
+	// Calls still live aren't counted
+	service.GetOrCreateConversation("live").CreatedAt = monday.Add(10 * time.Hour)
+
+	daily, err := analytics.Report(AnalyticsQuery{From: monday, To: monday.AddDate(0, 0, 3)}, archive)
+	if err != nil {
+		t.Fatalf("Expected a report, got %v", err)
+	}
+	if len(daily.Buckets) != 3 || daily.Interval != AnalyticsDaily {
+		t.Fatalf("Expected a bucket a day, got %+v", daily.Buckets)
+	}
+	want := AnalyticsBucket{Start: monday, Calls: 2, AverageDurationSeconds: 900, TurnsPerCall: 5, EscalationRate: 0.5}
+	if daily.Buckets[0] != want || daily.Buckets[1].Calls != 0 || daily.Buckets[2].Calls != 1 {
+		t.Errorf("Unexpected daily buckets: %+v", daily.Buckets)
+	}
+	if daily.Totals.Calls != 3 || daily.Totals.TurnsPerCall != 4 || daily.Totals.EscalationRate != 0.333 {
+		t.Errorf("Unexpected totals: %+v", daily.Totals)
+	}
+	if len(daily.TopTopics) != 2 || daily.TopTopics[0] != (TopicCount{"sleep", 2}) || daily.TopTopics[1] != (TopicCount{"job loss", 1}) {
+		t.Errorf("Expected topics counted per call, most discussed first, got %+v", daily.TopTopics)
+	}
+
+	// Weeks start on Monday, whatever day the range starts
+	weekly, _ := analytics.Report(AnalyticsQuery{From: monday.AddDate(0, 0, 2), To: monday.AddDate(0, 0, 14), Interval: AnalyticsWeekly}, archive)
+	if len(weekly.Buckets) != 2 || !weekly.Buckets[0].Start.Equal(monday) || weekly.Buckets[0].Calls != 1 || weekly.Buckets[1].Calls != 1 {
+		t.Errorf("Unexpected weekly buckets: %+v", weekly.Buckets)
+	}
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/gorilla/websocket"
+)
+
+// AssemblyAI rejects chunks shorter than 50ms, so small Twilio frames are batched
+const assemblyAIMinChunk = 100 * time.Millisecond
+
+// AssemblyAIService implements SpeechRecognizer over AssemblyAI's real-time streaming API
+type AssemblyAIService struct {
+	config *config.Config
+	client *http.Client
+	log    *logger.Logger
+}
+
+// assemblyAIMessage is the subset of an AssemblyAI streaming message we use
+type assemblyAIMessage struct {
+	Type            string `json:"type"`
+	Transcript      string `json:"transcript"`
+	EndOfTurn       bool   `json:"end_of_turn"`
+	TurnIsFormatted bool   `json:"turn_is_formatted"`
+	Words           []struct {
+		Text       string  `json:"text"`
+		Start      int64   `json:"start"` // Milliseconds from the start of the stream
+		End        int64   `json:"end"`
+		Confidence float32 `json:"confidence"`
+	} `json:"words"`
+	Error string `json:"error"`
+}
+
+// NewAssemblyAIService creates a new AssemblyAI speech-to-text service
+func NewAssemblyAIService(cfg *config.Config) (*AssemblyAIService, error) {
+	log := logger.Component("AssemblyAI")
+	log.Info("Creating new AssemblyAI service: %s", cfg.AssemblyAIURL)
+
+	if cfg.AssemblyAIAPIKey == "" {
+		log.Error("ASSEMBLYAI_API_KEY environment variable not set")
+		return nil, errors.New("ASSEMBLYAI_API_KEY is required for the assemblyai STT provider")
+	}
+
+	return &AssemblyAIService{
+		config: cfg,
+		client: &http.Client{Timeout: 60 * time.Second},
+		log:    log,
+	}, nil
+}
+
+// Close is a no-op; AssemblyAI connections are per stream
+func (a *AssemblyAIService) Close() error {
+	a.log.Info("Closing AssemblyAI service")
+	return nil
+}
+
+// StartStream opens an AssemblyAI real-time transcription websocket for the call
+func (a *AssemblyAIService) StartStream(ctx context.Context, format AudioFormat) (RecognitionStream, error) {
+	log := CallLogger(ctx, a.log)
+	format = format.Normalize()
+	log.Info("Starting AssemblyAI stream (%s, %d Hz)", format.Encoding, format.SampleRate)
+
+	// AssemblyAI takes μ-law or little-endian PCM; anything else is transcoded
+	encoding := "pcm_s16le"
+	if format.Encoding == EncodingMulaw {
+		encoding = "pcm_mulaw"
+	}
+
+	params := url.Values{}
+	params.Set("sample_rate", strconv.Itoa(format.SampleRate))
+	params.Set("encoding", encoding)
+	params.Set("format_turns", strconv.FormatBool(a.config.STTAutomaticPunctuation))
+
+	header := http.Header{}
+	header.Set("Authorization", a.config.AssemblyAIAPIKey)
+	correlateHeader(ctx, header)
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, a.config.AssemblyAIURL+"?"+params.Encode(), header)
+	if err != nil {
+		log.Error("Failed to connect to AssemblyAI: %v", err)
+		return nil, err
+	}
+
+	stream := &assemblyAIStream{
+		conn:        conn,
+		format:      format,
+		transcode:   encoding == "pcm_s16le",
+		minChunk:    int(assemblyAIMinChunk.Seconds() * float64(format.BytesPerSecond())),
+		formatTurns: a.config.STTAutomaticPunctuation,
+		interim:     a.config.STTInterimResults,
+		transcripts: make(chan Transcript, 1024),
+		log:         log,
+	}
+	go stream.listen()
+
+	// Close the websocket when the call ends
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	return stream, nil
+}
+
+// TranscribeRecording uploads a complete WAV recording and polls for the transcript
+func (a *AssemblyAIService) TranscribeRecording(ctx context.Context, wav []byte) (string, error) {
+	log := CallLogger(ctx, a.log)
+	log.Info("Transcribing %d bytes of recorded audio", len(wav))
+
+	var upload struct {
+		UploadURL string `json:"upload_url"`
+	}
+	if err := a.call(ctx, http.MethodPost, "/v2/upload", bytes.NewReader(wav), &upload); err != nil {
+		return "", err
+	}
+
+	request, _ := json.Marshal(map[string]string{
+		"audio_url":     upload.UploadURL,
+		"language_code": strings.ReplaceAll(strings.ToLower(a.config.STTLanguageCode), "-", "_"),
+	})
+	var job struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Text   string `json:"text"`
+		Error  string `json:"error"`
+	}
+	if err := a.call(ctx, http.MethodPost, "/v2/transcript", bytes.NewReader(request), &job); err != nil {
+		return "", err
+	}
+
+	for job.Status != "completed" {
+		if job.Status == "error" {
+			log.Error("AssemblyAI transcription %s failed: %s", job.ID, job.Error)
+			return "", fmt.Errorf("assemblyai: %s", job.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Second):
+		}
+
+		if err := a.call(ctx, http.MethodGet, "/v2/transcript/"+job.ID, nil, &job); err != nil {
+			return "", err
+		}
+	}
+
+	return job.Text, nil
+}
+
+// call makes a request to AssemblyAI's REST API and decodes the JSON response
+func (a *AssemblyAIService) call(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	log := CallLogger(ctx, a.log)
+	req, err := http.NewRequestWithContext(ctx, method, a.config.AssemblyAIRESTURL+path, body)
+	if err != nil {
+		return err
+	}
+	correlate(req)
+	req.Header.Set("Authorization", a.config.AssemblyAIAPIKey)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Error("Error calling AssemblyAI: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("AssemblyAI returned status %d: %s", resp.StatusCode, msg)
+		return fmt.Errorf("assemblyai: unexpected status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// assemblyAIStream is a live AssemblyAI transcription session
+type assemblyAIStream struct {
+	conn        *websocket.Conn
+	format      AudioFormat
+	transcode   bool
+	minChunk    int
+	pending     []byte
+	formatTurns bool
+	interim     bool
+	transcripts chan Transcript
+	writeMu     sync.Mutex
+	closeOnce   sync.Once
+	log         *logger.Logger
+}
+
+func (s *assemblyAIStream) SendAudio(audio []byte) error {
+	if s.transcode {
+		audio = PCM16LE(DecodeSamples(audio, s.format))
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.pending = append(s.pending, audio...)
+	if len(s.pending) < s.minChunk {
+		return nil
+	}
+
+	chunk := s.pending
+	s.pending = nil
+	return s.conn.WriteMessage(websocket.BinaryMessage, chunk)
+}
+
+func (s *assemblyAIStream) Transcripts() <-chan Transcript {
+	return s.transcripts
+}
+
+// Close flushes pending audio and asks AssemblyAI to end the session; the reader closes the connection
+func (s *assemblyAIStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		if len(s.pending) > 0 {
+			s.conn.WriteMessage(websocket.BinaryMessage, s.pending)
+			s.pending = nil
+		}
+		err = s.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"Terminate"}`))
+	})
+	return err
+}
+
+// listen reads AssemblyAI turn events until the session terminates
+func (s *assemblyAIStream) listen() {
+	defer func() {
+		s.log.Info("Closing AssemblyAI transcription channel")
+		close(s.transcripts)
+		s.conn.Close()
+	}()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				s.log.Info("AssemblyAI stream closed")
+			} else {
+				s.log.Error("Error receiving from AssemblyAI: %v", err)
+			}
+			return
+		}
+
+		var msg assemblyAIMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.log.Warn("Ignoring unparseable AssemblyAI message: %v", err)
+			continue
+		}
+
+		switch msg.Type {
+		case "Begin":
+			s.log.Info("AssemblyAI session started")
+			continue
+		case "Termination":
+			s.log.Info("AssemblyAI session terminated")
+			return
+		case "Turn":
+		default:
+			if msg.Error != "" {
+				s.log.Error("AssemblyAI error: %s", msg.Error)
+			}
+			continue
+		}
+
+		if msg.Transcript == "" {
+			continue
+		}
+		// With formatting on, the end of turn arrives twice; only the formatted one is final
+		final := msg.EndOfTurn && (!s.formatTurns || msg.TurnIsFormatted)
+		if !final && (!s.interim || msg.EndOfTurn) {
+			continue
+		}
+
+		// Turns carry no overall confidence, so average the words'
+		var words []WordTiming
+		var confidence float32
+		for _, word := range msg.Words {
+			words = append(words, WordTiming{
+				Word:  word.Text,
+				Start: time.Duration(word.Start) * time.Millisecond,
+				End:   time.Duration(word.End) * time.Millisecond,
+			})
+			confidence += word.Confidence
+		}
+		if len(msg.Words) > 0 {
+			confidence /= float32(len(msg.Words))
+		}
+
+		s.log.Info("Transcription (final=%t): %s", final, logger.Transcript(msg.Transcript))
+		s.transcripts <- Transcript{
+			Text:        msg.Transcript,
+			IsFinal:     final,
+			Confidence:  confidence,
+			EndOfSpeech: final,
+			Words:       words,
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// AudioIssue identifies a way inbound audio fails to match the negotiated format
+type AudioIssue string
+
+const (
+	// AudioIssueSilence means every frame so far has been digital silence
+	AudioIssueSilence AudioIssue = "silence_only"
+	// AudioIssueFrameSize means frames are not the 20ms the format implies, suggesting a sample-rate mismatch
+	AudioIssueFrameSize AudioIssue = "frame_size_mismatch"
+	// AudioIssueClipping means most samples sit at full scale, typical of decoding the wrong codec
+	AudioIssueClipping AudioIssue = "clipping"
+	// AudioIssueContainer means frames carry a file header (WAV/Ogg/MP3) instead of raw samples
+	AudioIssueContainer AudioIssue = "container_header"
+)
+
+// Thresholds for the audio format heuristics
+const (
+	diagnosticsFrameMs         = 20
+	diagnosticsMinFrames       = 50  // 1s of audio before judging frame sizes and clipping
+	diagnosticsSilenceFrames   = 500 // 10s of audio before calling a stream silence-only
+	diagnosticsSilenceLevel    = 16  // Peak sample magnitude treated as digital silence
+	diagnosticsClippingLevel   = 32000
+	diagnosticsClippedFraction = 0.2
+)
+
+// AudioFormatError reports a specific mismatch between inbound audio and the negotiated format
+type AudioFormatError struct {
+	CallSID string
+	Issue   AudioIssue
+	Detail  string
+}
+
+func (e *AudioFormatError) Error() string {
+	return fmt.Sprintf("audio format mismatch on call %s (%s): %s", e.CallSID, e.Issue, e.Detail)
+}
+
+var (
+	audioIssueCounts = make(map[AudioIssue]int)
+	audioIssueMutex  sync.Mutex
+)
+
+// AudioIssueCounts returns how many calls have been flagged with each audio issue
+func AudioIssueCounts() map[AudioIssue]int {
+	audioIssueMutex.Lock()
+	defer audioIssueMutex.Unlock()
+
+	counts := make(map[AudioIssue]int, len(audioIssueCounts))
+	for issue, count := range audioIssueCounts {
+		counts[issue] = count
+	}
+	return counts
+}
+
+// AudioDiagnosticsSnapshot is a point-in-time view of a call's inbound audio health
+type AudioDiagnosticsSnapshot struct {
+	Format             AudioFormat  `json:"format"`
+	Frames             int          `json:"frames"`
+	Bytes              int64        `json:"bytes"`
+	SilentFrames       int          `json:"silentFrames"`
+	ClippedFrames      int          `json:"clippedFrames"`
+	SizeMismatchFrames int          `json:"sizeMismatchFrames"`
+	Issues             []AudioIssue `json:"issues"`
+}
+
+// AudioDiagnostics checks that inbound audio for a call actually looks like the negotiated format
+type AudioDiagnostics struct {
+	callSID            string
+	format             AudioFormat
+	frames             int
+	bytes              int64
+	silentFrames       int
+	clippedFrames      int
+	sizeMismatchFrames int
+	flagged            map[AudioIssue]bool
+	issues             []AudioIssue
+	mu                 sync.Mutex
+}
+
+// NewAudioDiagnostics creates diagnostics for a call's inbound audio
+func NewAudioDiagnostics(callSID string, format AudioFormat) *AudioDiagnostics {
+	return &AudioDiagnostics{
+		callSID: callSID,
+		format:  format.Normalize(),
+		flagged: make(map[AudioIssue]bool),
+	}
+}
+
+// Inspect analyzes one inbound frame and returns an error the first time an issue is detected
+func (d *AudioDiagnostics) Inspect(payload []byte) *AudioFormatError {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.frames++
+	d.bytes += int64(len(payload))
+
+	if hasContainerHeader(payload) {
+		return d.flag(AudioIssueContainer, "frame starts with a file header, expected raw samples")
+	}
+
+	expectedSize := d.format.BytesPerSecond() * diagnosticsFrameMs / 1000
+	if len(payload) != expectedSize {
+		d.sizeMismatchFrames++
+	}
+
+	samples := DecodeSamples(payload, d.format)
+	peak, clipped := 0, 0
+	for _, s := range samples {
+		magnitude := int(s)
+		if magnitude < 0 {
+			magnitude = -magnitude
+		}
+		if magnitude > peak {
+			peak = magnitude
+		}
+		if magnitude >= diagnosticsClippingLevel {
+			clipped++
+		}
+	}
+	if peak <= diagnosticsSilenceLevel {
+		d.silentFrames++
+	}
+	if len(samples) > 0 && float64(clipped)/float64(len(samples)) > diagnosticsClippedFraction {
+		d.clippedFrames++
+	}
+
+	if d.frames < diagnosticsMinFrames {
+		return nil
+	}
+
+	if d.sizeMismatchFrames*2 > d.frames {
+		return d.flag(AudioIssueFrameSize, fmt.Sprintf("%d of %d frames are not %d bytes (last %d), check the sample rate",
+			d.sizeMismatchFrames, d.frames, expectedSize, len(payload)))
+	}
+	if d.clippedFrames*2 > d.frames {
+		return d.flag(AudioIssueClipping, fmt.Sprintf("%d of %d frames are mostly full-scale samples, check the codec",
+			d.clippedFrames, d.frames))
+	}
+	if d.frames >= diagnosticsSilenceFrames && d.silentFrames == d.frames {
+		return d.flag(AudioIssueSilence, fmt.Sprintf("all %d frames are digital silence", d.frames))
+	}
+	return nil
+}
+
+// Snapshot returns the current counters and detected issues
+func (d *AudioDiagnostics) Snapshot() AudioDiagnosticsSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	issues := make([]AudioIssue, len(d.issues))
+	copy(issues, d.issues)
+	return AudioDiagnosticsSnapshot{
+		Format:             d.format,
+		Frames:             d.frames,
+		Bytes:              d.bytes,
+		SilentFrames:       d.silentFrames,
+		ClippedFrames:      d.clippedFrames,
+		SizeMismatchFrames: d.sizeMismatchFrames,
+		Issues:             issues,
+	}
+}
+
+// flag records an issue once per call; the caller must hold the lock
+func (d *AudioDiagnostics) flag(issue AudioIssue, detail string) *AudioFormatError {
+	if d.flagged[issue] {
+		return nil
+	}
+	d.flagged[issue] = true
+	d.issues = append(d.issues, issue)
+
+	audioIssueMutex.Lock()
+	audioIssueCounts[issue]++
+	audioIssueMutex.Unlock()
+
+	return &AudioFormatError{CallSID: d.callSID, Issue: issue, Detail: detail}
+}
+
+// hasContainerHeader reports whether the payload starts with a common audio file signature
+func hasContainerHeader(payload []byte) bool {
+	for _, magic := range [][]byte{[]byte("RIFF"), []byte("OggS"), []byte("ID3"), []byte("fLaC")} {
+		if bytes.HasPrefix(payload, magic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestMulawRoundTrip(t *testing.T) {
+	for _, sample := range []int16{0, 100, -100, 1000, -1000, 8000, -8000, 32000, -32000} {
+		decoded := MulawDecode(MulawEncode(sample))
+		diff := int(decoded) - int(sample)
+		if diff < 0 {
+			diff = -diff
+		}
+		// μ-law quantization error grows with magnitude, stay within ~1/16th
+		if diff > int(abs16(sample))/16+8 {
+			t.Errorf("μ-law round trip of %d gave %d", sample, decoded)
+		}
+	}
+}
+
+func TestAlawRoundTrip(t *testing.T) {
+	for _, sample := range []int16{0, 100, -100, 1000, -1000, 8000, -8000, 32000, -32000} {
+		decoded := AlawDecode(AlawEncode(sample))
+		diff := int(decoded) - int(sample)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > int(abs16(sample))/16+16 {
+			t.Errorf("A-law round trip of %d gave %d", sample, decoded)
+		}
+	}
+}
+
+func abs16(s int16) int32 {
+	if s < 0 {
+		return -int32(s)
+	}
+	return int32(s)
+}
+
+func TestAudioDiagnosticsFlagsSilenceOnly(t *testing.T) {
+	diag := NewAudioDiagnostics("test-call", DefaultAudioFormat())
+	silence := make([]byte, 160)
+	for i := range silence {
+		silence[i] = 0xFF // μ-law zero
+	}
+
+	var flagged *AudioFormatError
+	for i := 0; i < diagnosticsSilenceFrames; i++ {
+		if err := diag.Inspect(silence); err != nil {
+			flagged = err
+		}
+	}
+	if flagged == nil || flagged.Issue != AudioIssueSilence {
+		t.Fatalf("Expected silence_only issue, got %v", flagged)
+	}
+	if diag.Inspect(silence) != nil {
+		t.Error("Expected an issue to be reported only once per call")
+	}
+}
+
+func TestAudioDiagnosticsFlagsFrameSizeMismatch(t *testing.T) {
+	diag := NewAudioDiagnostics("test-call", DefaultAudioFormat())
+	frame := make([]byte, 320) // 20ms at 16kHz
+
+	var flagged *AudioFormatError
+	for i := 0; i < diagnosticsMinFrames; i++ {
+		if err := diag.Inspect(frame); err != nil {
+			flagged = err
+		}
+	}
+	if flagged == nil || flagged.Issue != AudioIssueFrameSize {
+		t.Fatalf("Expected frame_size_mismatch issue, got %v", flagged)
+	}
+}
+
+func TestAudioDiagnosticsFlagsContainerHeader(t *testing.T) {
+	diag := NewAudioDiagnostics("test-call", DefaultAudioFormat())
+	err := diag.Inspect(append([]byte("RIFF"), make([]byte, 156)...))
+	if err == nil || err.Issue != AudioIssueContainer {
+		t.Fatalf("Expected container_header issue, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"strings"
+
+	"cloud.google.com/go/speech/apiv2/speechpb"
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+// Audio encodings advertised by Twilio in the mediaFormat of the start event
+const (
+	EncodingMulaw  = "audio/x-mulaw"
+	EncodingAlaw   = "audio/x-alaw"
+	EncodingLinear = "audio/l16"
+)
+
+// AudioFormat describes the encoding of the audio exchanged with Twilio for a call
+type AudioFormat struct {
+	Encoding   string `json:"encoding"`
+	SampleRate int    `json:"sampleRate"`
+	Channels   int    `json:"channels"`
+}
+
+// DefaultAudioFormat returns the format Twilio media streams use unless told otherwise
+func DefaultAudioFormat() AudioFormat {
+	return AudioFormat{
+		Encoding:   EncodingMulaw,
+		SampleRate: 8000,
+		Channels:   1,
+	}
+}
+
+// Normalize fills in missing fields with the defaults and lowercases the encoding
+func (f AudioFormat) Normalize() AudioFormat {
+	def := DefaultAudioFormat()
+	f.Encoding = strings.ToLower(strings.TrimSpace(f.Encoding))
+	if f.Encoding == "" {
+		f.Encoding = def.Encoding
+	}
+	if f.SampleRate <= 0 {
+		f.SampleRate = def.SampleRate
+	}
+	if f.Channels <= 0 {
+		f.Channels = def.Channels
+	}
+	return f
+}
+
+// STTEncoding maps the format to the Speech-to-Text recognition encoding
+func (f AudioFormat) STTEncoding() speechpb.ExplicitDecodingConfig_AudioEncoding {
+	switch f.Normalize().Encoding {
+	case EncodingLinear:
+		return speechpb.ExplicitDecodingConfig_LINEAR16
+	case EncodingAlaw:
+		return speechpb.ExplicitDecodingConfig_ALAW
+	default:
+		return speechpb.ExplicitDecodingConfig_MULAW
+	}
+}
+
+// TTSEncoding maps the format to the Text-to-Speech output encoding
+func (f AudioFormat) TTSEncoding() texttospeechpb.AudioEncoding {
+	switch f.Normalize().Encoding {
+	case EncodingLinear:
+		return texttospeechpb.AudioEncoding_LINEAR16
+	case EncodingAlaw:
+		return texttospeechpb.AudioEncoding_ALAW
+	default:
+		return texttospeechpb.AudioEncoding_MULAW
+	}
+}
+
+// BytesPerSecond returns the data rate of the format, used to size outbound chunks
+func (f AudioFormat) BytesPerSecond() int {
+	f = f.Normalize()
+	bytesPerSample := 1
+	if f.Encoding == EncodingLinear {
+		bytesPerSample = 2
+	}
+	return f.SampleRate * f.Channels * bytesPerSample
+}
@@ -0,0 +1,116 @@
+package services
+
+import "math"
+
+// PreprocessConfig tunes the clean-up applied to caller audio before speech recognition
+type PreprocessConfig struct {
+	Enabled bool
+	// TargetLevel is the RMS level (16-bit PCM) speech is normalized toward
+	TargetLevel float64
+	// MaxGain caps how much quiet callers are amplified
+	MaxGain float64
+	// GateThreshold is the RMS level below which a frame is treated as background noise
+	GateThreshold float64
+}
+
+// DefaultPreprocessConfig returns settings suited to 8kHz telephony audio
+func DefaultPreprocessConfig() PreprocessConfig {
+	return PreprocessConfig{
+		TargetLevel:   3000,
+		MaxGain:       8,
+		GateThreshold: 100,
+	}
+}
+
+const (
+	// dcPole is the pole of the DC-blocking filter, a cutoff around 6Hz at 8kHz
+	dcPole = 0.995
+	// minPreprocessGain limits how much loud callers are turned down
+	minPreprocessGain = 0.25
+	// agcRate is how far the gain moves toward its target each speech frame
+	agcRate = 0.1
+	// gateFloor is the gain applied to frames the noise gate closes on
+	gateFloor = 0.1
+	// gateRelease is how much of the gate's gain is kept per frame while it closes
+	gateRelease = 0.7
+)
+
+// AudioPreprocessor removes DC offset, gates background noise and normalizes the level of
+// a call's inbound audio. It keeps filter state across frames, so use one per call.
+type AudioPreprocessor struct {
+	config PreprocessConfig
+	format AudioFormat
+
+	prevIn, prevOut float64 // DC filter state
+	gain            float64 // Automatic gain, adapted on speech frames
+	gate            float64 // Noise gate gain at the end of the last frame
+}
+
+// NewAudioPreprocessor creates a preprocessor for audio in the given format
+func NewAudioPreprocessor(config PreprocessConfig, format AudioFormat) *AudioPreprocessor {
+	return &AudioPreprocessor{
+		config: config,
+		format: format.Normalize(),
+		gain:   1,
+		gate:   1,
+	}
+}
+
+// Process returns the cleaned-up frame in the same format
+func (p *AudioPreprocessor) Process(payload []byte) []byte {
+	samples := DecodeSamples(payload, p.format)
+	if len(samples) == 0 {
+		return payload
+	}
+
+	// Remove DC offset with a one-pole high-pass filter
+	filtered := make([]float64, len(samples))
+	var sum float64
+	for i, s := range samples {
+		x := float64(s)
+		y := x - p.prevIn + dcPole*p.prevOut
+		p.prevIn, p.prevOut = x, y
+		filtered[i] = y
+		sum += y * y
+	}
+	rms := math.Sqrt(sum / float64(len(filtered)))
+
+	// The gate opens at once on speech and closes gradually, so word endings aren't cut
+	gateTarget := gateFloor
+	if rms >= p.config.GateThreshold {
+		gateTarget = 1
+		// Only speech moves the gain, otherwise it would amplify the background
+		desired := math.Max(minPreprocessGain, math.Min(p.config.MaxGain, p.config.TargetLevel/math.Max(rms, 1)))
+		p.gain += (desired - p.gain) * agcRate
+	}
+	gateEnd := gateTarget
+	if gateTarget < p.gate {
+		gateEnd = math.Max(gateTarget, p.gate*gateRelease)
+	}
+
+	// Ramp the gate across the frame to avoid clicks
+	out := make([]int16, len(filtered))
+	for i, y := range filtered {
+		gate := p.gate + (gateEnd-p.gate)*float64(i+1)/float64(len(filtered))
+		out[i] = clampSample(y * gate * p.gain)
+	}
+	p.gate = gateEnd
+
+	return EncodeSamples(out, p.format)
+}
+
+// Gain returns the current automatic gain
+func (p *AudioPreprocessor) Gain() float64 {
+	return p.gain
+}
+
+// clampSample rounds and limits a sample to the 16-bit range
+func clampSample(v float64) int16 {
+	switch {
+	case v > math.MaxInt16:
+		return math.MaxInt16
+	case v < math.MinInt16:
+		return math.MinInt16
+	}
+	return int16(math.Round(v))
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+var linear8k = AudioFormat{Encoding: EncodingLinear, SampleRate: 8000, Channels: 1}
+
+// sineFrame is a 20ms frame of a 400Hz tone at the given amplitude plus a DC offset
+func sineFrame(amplitude, offset float64, start int) []byte {
+	samples := make([]int16, 160)
+	for i := range samples {
+		samples[i] = int16(offset + amplitude*math.Sin(2*math.Pi*400*float64(start+i)/8000))
+	}
+	return EncodeSamples(samples, linear8k)
+}
+
+func frameRMS(payload []byte) float64 {
+	samples := DecodeSamples(payload, linear8k)
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func frameMean(payload []byte) float64 {
+	samples := DecodeSamples(payload, linear8k)
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s)
+	}
+	return sum / float64(len(samples))
+}
+
+func TestPreprocessorRemovesDCOffset(t *testing.T) {
+	p := NewAudioPreprocessor(DefaultPreprocessConfig(), linear8k)
+
+	var out []byte
+	for n := 0; n < 200; n++ {
+		out = p.Process(sineFrame(3000, 2000, n*160))
+	}
+	if mean := frameMean(out); math.Abs(mean) > 100 {
+		t.Errorf("Expected the DC offset to be removed, mean is %.0f", mean)
+	}
+}
+
+func TestPreprocessorAmplifiesQuietSpeech(t *testing.T) {
+	p := NewAudioPreprocessor(DefaultPreprocessConfig(), linear8k)
+
+	var out []byte
+	for n := 0; n < 100; n++ {
+		out = p.Process(sineFrame(700, 0, n*160))
+	}
+	// 700 peak is about 500 RMS; normalizing toward 3000 needs a gain of about 6
+	if rms := frameRMS(out); rms < 2500 || rms > 3500 {
+		t.Errorf("Expected quiet speech normalized near the target level, got RMS %.0f (gain %.2f)", rms, p.Gain())
+	}
+}
+
+func TestPreprocessorGatesBackgroundNoise(t *testing.T) {
+	p := NewAudioPreprocessor(DefaultPreprocessConfig(), linear8k)
+
+	var out []byte
+	for n := 0; n < 20; n++ {
+		out = p.Process(sineFrame(80, 0, n*160))
+	}
+	if rms := frameRMS(out); rms > 10 {
+		t.Errorf("Expected noise below the gate to be attenuated, got RMS %.0f", rms)
+	}
+	if p.Gain() != 1 {
+		t.Errorf("Expected noise not to change the gain, got %.2f", p.Gain())
+	}
+}
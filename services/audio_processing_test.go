@@ -6,7 +6,7 @@ import (
 	"testing"
 	"time"
 
-	"cloud.google.com/go/speech/apiv1/speechpb"
+	"cloud.google.com/go/speech/apiv2/speechpb"
 	"github.com/ghophp/call-me-help/logger"
 	"github.com/joho/godotenv"
 )
@@ -35,7 +35,7 @@ func TestCompleteAudioProcessingFlow(t *testing.T) {
 	defer stt.Close()
 
 	t.Log("Initializing Gemini service...")
-	gemini, err := NewGeminiService(ctx)
+	gemini, err := NewGeminiService(ctx, nil, nil)
 	if err != nil {
 		t.Fatalf("Failed to create Gemini service: %v", err)
 	}
@@ -85,7 +85,7 @@ func TestCompleteAudioProcessingFlow(t *testing.T) {
 	// Convert the response to speech
 	t.Log("Converting response to speech...")
 	startTime = time.Now()
-	audioData, err := tts.SynthesizeSpeech(ctx, response)
+	audioData, err := tts.SynthesizeSpeech(ctx, response, DefaultAudioFormat())
 	elapsed = time.Since(startTime)
 
 	if err != nil {
@@ -102,7 +102,7 @@ func TestCompleteAudioProcessingFlow(t *testing.T) {
 	t.Log("Testing STT streaming recognition...")
 
 	// Start speech recognition
-	transcriptionChan, stream, err := stt.StreamingRecognize(ctx)
+	transcriptionChan, stream, err := stt.StreamingRecognize(ctx, DefaultAudioFormat())
 	if err != nil {
 		t.Fatalf("Failed to start streaming recognition: %v", err)
 	}
@@ -110,7 +110,7 @@ func TestCompleteAudioProcessingFlow(t *testing.T) {
 	// Start a goroutine to collect transcriptions
 	go func() {
 		for transcript := range transcriptionChan {
-			t.Logf("Received transcription: %q", transcript)
+			t.Logf("Received transcription: %q", transcript.Text)
 		}
 	}()
 
@@ -118,8 +118,8 @@ func TestCompleteAudioProcessingFlow(t *testing.T) {
 	// This is a loopback test: TTS -> STT
 	t.Log("Sending synthesized audio back to STT...")
 	stream.Send(&speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
-			AudioContent: audioData,
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_Audio{
+			Audio: audioData,
 		},
 	})
 
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// AudioFileStore saves synthesized responses and recordings to the audio output
+// directory, the default audio store
+type AudioFileStore struct {
+	dir      string
+	fileType string         // wav, or raw for the headerless call audio
+	cipher   *ContentCipher // nil writes files in the clear
+	log      *logger.Logger
+}
+
+// NewAudioFileStore creates a store writing files of the given type ("wav" or "raw") to dir,
+// encrypted with the cipher when there is one
+func NewAudioFileStore(dir, fileType string, cipher *ContentCipher) *AudioFileStore {
+	if fileType != "raw" {
+		fileType = "wav"
+	}
+	return &AudioFileStore{
+		dir:      dir,
+		fileType: fileType,
+		cipher:   cipher,
+		log:      logger.Component("AudioStore"),
+	}
+}
+
+// Probe writes and removes a file in the audio output directory
+func (s *AudioFileStore) Probe(ctx context.Context) error {
+	f, err := os.CreateTemp(s.dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// SaveAudioToFile saves audio content in the call's format to a file
+func (s *AudioFileStore) SaveAudioToFile(callSID string, text string, audioData []byte, format AudioFormat) error {
+	// Use the configured output directory
+	outputDir := s.dir
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		s.log.Error("Failed to create output directory: %v", err)
+		return err
+	}
+
+	// Create a unique filename based on call SID and timestamp
+	timestamp := time.Now().Format("20060102-150405.000")
+	sanitizedText := sanitizeFilename(text)
+	if len(sanitizedText) > 30 {
+		sanitizedText = sanitizedText[:30] // Limit text length in filename
+	}
+
+	filename := fmt.Sprintf("%s/%s_%s_%s.%s", outputDir, callSID, timestamp, sanitizedText, s.fileType)
+	if s.fileType == "wav" {
+		audioData = EncodeWAV(audioData, format)
+	}
+	// Bound to its name, so an encrypted file can't be passed off as another call's
+	perm := os.FileMode(0644)
+	if s.cipher != nil {
+		sealed, err := s.cipher.Seal(audioData, []byte(filepath.Base(filename)))
+		if err != nil {
+			s.log.Error("Failed to encrypt audio: %v", err)
+			return err
+		}
+		audioData, perm = sealed, 0600
+	}
+
+	// Save the audio data to file
+	s.log.Info("Saving %d bytes of audio to file: %s", len(audioData), filename)
+	if err := os.WriteFile(filename, audioData, perm); err != nil {
+		s.log.Error("Failed to save audio to file: %v", err)
+		return err
+	}
+
+	s.log.Info("Successfully saved audio to file: %s", filename)
+	return nil
+}
+
+// CreateInboundRecording creates the file a call's raw inbound caller audio is recorded to
+func (s *AudioFileStore) CreateInboundRecording(callSID string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		s.log.Error("Failed to create output directory: %v", err)
+		return nil, err
+	}
+
+	timestamp := time.Now().Format("20060102-150405.000")
+	filename := fmt.Sprintf("%s/%s_%s_inbound.raw", s.dir, sanitizeFilename(callSID), timestamp)
+	file, err := os.Create(filename)
+	if err != nil {
+		s.log.Error("Failed to create inbound recording: %v", err)
+		return nil, err
+	}
+
+	s.log.Info("Recording inbound audio for call %s to %s", callSID, filename)
+	if s.cipher != nil {
+		return s.cipher.SealWriter(file, []byte(filepath.Base(filename))), nil
+	}
+	return file, nil
+}
+
+// ReadAudioFile reads a saved file, decrypted when it was encrypted. A recording cut short,
+// e.g. by a crash, is returned up to where it was cut along with ErrSealedStreamTruncated.
+func (s *AudioFileStore) ReadAudioFile(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, filepath.Base(filename)))
+	if err != nil {
+		return nil, err
+	}
+	return s.cipher.Open(data, []byte(filepath.Base(filename)))
+}
+
+// sanitizeFilename removes special characters from a string to make it safe for use in a filename
+func sanitizeFilename(input string) string {
+	// Replace spaces with underscores
+	result := strings.ReplaceAll(input, " ", "_")
+
+	// Remove non-alphanumeric characters
+	reg := regexp.MustCompile("[^a-zA-Z0-9_]")
+	result = reg.ReplaceAllString(result, "")
+
+	return result
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAudioFilesAreEncryptedAtRest(t *testing.T) {
+	dir := t.TempDir()
+	store := NewAudioFileStore(dir, "raw", testCipher(t, 1))
+	audio := bytes.Repeat([]byte{0x7f, 0x12}, 400)
+	if err := store.SaveAudioToFile("CA123", "Hello there", audio, DefaultAudioFormat()); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "CA123_*.raw"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one saved file, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !IsSealed(data) || bytes.Contains(data, audio[:64]) {
+		t.Error("Expected the file encrypted")
+	}
+	read, err := store.ReadAudioFile(filepath.Base(files[0]))
+	if err != nil || !bytes.Equal(read, audio) {
+		t.Errorf("Expected the audio decrypted, got %d bytes, %v", len(read), err)
+	}
+
+	// A file renamed as another call's doesn't decrypt
+	os.Rename(files[0], filepath.Join(dir, "CA999_x.raw"))
+	if _, err := store.ReadAudioFile("CA999_x.raw"); err == nil {
+		t.Error("Expected a renamed file refused")
+	}
+}
+
+func TestInboundRecordingsAreSealedInSegments(t *testing.T) {
+	dir := t.TempDir()
+	store := NewAudioFileStore(dir, "raw", testCipher(t, 2))
+	recording, err := store.CreateInboundRecording("CA123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio := bytes.Repeat([]byte("0123456789abcdef"), sealedSegmentSize/8) // Two segments
+	for chunk := audio; len(chunk) > 0; chunk = chunk[min(160, len(chunk)):] {
+		recording.Write(chunk[:min(160, len(chunk))])
+	}
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "CA123_*_inbound.raw"))
+	name := filepath.Base(files[0])
+	read, err := store.ReadAudioFile(name)
+	if err != nil || !bytes.Equal(read, audio) {
+		t.Fatalf("Expected the recording decrypted, got %d bytes, %v", len(read), err)
+	}
+
+	// Cut after the first segment, the recording reads up to there and is reported cut short
+	data, _ := os.ReadFile(files[0])
+	first := len(sealedStreamPrefix) + 4 + int(binary.BigEndian.Uint32(data[len(sealedStreamPrefix):]))
+	os.WriteFile(files[0], data[:first], 0o600)
+	read, err = store.ReadAudioFile(name)
+	if !errors.Is(err, ErrSealedStreamTruncated) || !bytes.Equal(read, audio[:sealedSegmentSize]) {
+		t.Errorf("Expected the first segment and the stream reported truncated, got %d bytes, %v", len(read), err)
+	}
+}
+
+func TestAudioFilesWithoutAKeyStayReadable(t *testing.T) {
+	dir := t.TempDir()
+	NewAudioFileStore(dir, "raw", nil).SaveAudioToFile("CA123", "Hi", []byte("plain"), DefaultAudioFormat())
+	files, _ := filepath.Glob(filepath.Join(dir, "*.raw"))
+
+	// Turning encryption on later still reads files saved before
+	read, err := NewAudioFileStore(dir, "raw", testCipher(t, 3)).ReadAudioFile(filepath.Base(files[0]))
+	if err != nil || string(read) != "plain" {
+		t.Errorf("Expected the plain file read as is, got %q, %v", read, err)
+	}
+}
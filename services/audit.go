@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Audit actions
+const (
+	AuditConversationRead   = "conversation.read"
+	AuditConversationUpdate = "conversation.update"
+	AuditConversationTag    = "conversation.tag"
+	AuditConversationUntag  = "conversation.untag"
+	AuditConversationEvict  = "conversation.evict"
+	AuditTranscriptRead     = "transcript.read"
+	AuditTranscriptExport   = "transcript.export"
+	AuditNotesRead          = "notes.read"
+	AuditSummaryRead        = "summary.read"
+	AuditAudioList          = "audio.list"
+	AuditAudioDownload      = "audio.download"
+	AuditCallerRead         = "caller.read"
+	AuditCallUpdate         = "call.update"
+	AuditCallTransfer       = "call.transfer"
+	AuditCallEnd            = "call.end"
+	AuditCallBlock          = "call.block"
+	AuditConfigRead         = "config.read"
+	AuditConfigReload       = "config.reload"
+	AuditConfigLogLevel     = "config.loglevel"
+	AuditDiagnosticsRead    = "diagnostics.read"
+	AuditLogRead            = "audit.read"
+)
+
+// Actors of actions nobody made through the API
+const (
+	AuditActorSystem = "system" // The service itself, e.g. transferring a call the caller asked to
+	AuditActorSignal = "signal" // Whoever sent the process a signal
+)
+
+// auditMaxLimit caps how many entries a query returns
+const auditMaxLimit = 1000
+
+// AuditEntry is an administrative or operator action: who did what to which target, and when
+type AuditEntry struct {
+	Seq     int64             `json:"seq"`
+	Time    time.Time         `json:"time"`
+	Actor   string            `json:"actor"`
+	Action  string            `json:"action"`
+	Target  string            `json:"target"` // e.g. the path of the conversation read
+	CallSID string            `json:"callSid,omitempty"`
+	Status  int               `json:"status,omitempty"` // The HTTP status of API requests; denied attempts are kept too
+	Details map[string]string `json:"details,omitempty"`
+	// Hash is the SHA-256 of the previous entry's hash and this entry, so an entry changed
+	// or removed from the file breaks the chain
+	Hash string `json:"hash"`
+}
+
+// AuditFilter selects audit entries; empty fields match every entry
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	CallSID string
+	From    time.Time
+	To      time.Time
+	Limit   int // 100 when unset, at most 1000
+}
+
+// matches reports whether the entry is selected by the filter
+func (f AuditFilter) matches(e AuditEntry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.CallSID == "" || e.CallSID == f.CallSID) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+// AuditLog is an append-only record of administrative and operator actions. Entries are
+// appended to a JSON lines file and hash-chained, and nothing removes them.
+type AuditLog struct {
+	mu       sync.Mutex
+	file     *os.File // nil keeps entries in memory only
+	entries  []AuditEntry
+	lastHash string
+	now      func() time.Time
+	log      *logger.Logger
+}
+
+// OpenAuditLog opens the audit log appended to path, loading the entries already in it; an
+// empty path keeps entries in memory only. A broken hash chain is reported, not refused, so
+// tampering can't stop calls from being taken.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{now: time.Now, log: logger.Component("Audit")}
+	if path == "" {
+		a.log.Warn("AUDIT_LOG_FILE is not set, audit entries are kept in memory only")
+		return a, nil
+	}
+	if err := a.load(path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	a.file = file
+	a.log.Info("Appending audit entries to %s, %d recorded so far", path, len(a.entries))
+	return a, nil
+}
+
+// load reads the entries already in the file and checks their chain
+func (a *AuditLog) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("reading audit log line %d: %w", line, err)
+		}
+		if entry.Hash != chainHash(a.lastHash, entry) {
+			a.log.Error("Audit log %s chain is broken at entry %d, it was changed after being written", path, entry.Seq)
+		}
+		a.entries = append(a.entries, entry)
+		a.lastHash = entry.Hash
+	}
+	return scanner.Err()
+}
+
+// chainHash hashes the entry, without its hash, after the previous entry's hash
+func chainHash(prev string, entry AuditEntry) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(append([]byte(prev), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Record appends an entry; a nil log records nothing. Failing to write it is logged as an
+// error, the entry is still kept in memory.
+func (a *AuditLog) Record(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if entry.Time.IsZero() {
+		entry.Time = a.now().UTC()
+	}
+	entry.Seq = int64(len(a.entries)) + 1
+	entry.Hash = chainHash(a.lastHash, entry)
+	a.entries = append(a.entries, entry)
+	a.lastHash = entry.Hash
+
+	if a.file == nil {
+		return
+	}
+	data, _ := json.Marshal(entry)
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		a.log.Error("Failed to write audit entry %d (%s %s by %s): %v", entry.Seq, entry.Action, entry.Target, entry.Actor, err)
+	}
+}
+
+// RecordSystem records an action the service took on a call by itself
+func (a *AuditLog) RecordSystem(action, callSID string, details map[string]string) {
+	a.Record(AuditEntry{Actor: AuditActorSystem, Action: action, Target: callSID, CallSID: callSID, Details: details})
+}
+
+// Query returns the entries matching the filter, newest first; nil for a nil log
+func (a *AuditLog) Query(filter AuditFilter) []AuditEntry {
+	if a == nil {
+		return nil
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	limit = min(limit, auditMaxLimit)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	matched := []AuditEntry{}
+	for i := len(a.entries) - 1; i >= 0 && len(matched) < limit; i-- {
+		if filter.matches(a.entries[i]) {
+			matched = append(matched, a.entries[i])
+		}
+	}
+	return matched
+}
+
+// Close closes the audit log file
+func (a *AuditLog) Close() error {
+	if a == nil || a.file == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+type auditActorKey struct{}
+
+// WithActor returns a context carrying who is acting, for the audit log
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// ActorFromContext returns who is acting, empty when unknown
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	return actor
+}
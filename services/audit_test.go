@@ -0,0 +1,71 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogIsAppendedAndReloaded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Record(AuditEntry{Actor: "admin", Action: AuditTranscriptRead, Target: "/conversations/CA1/transcript", CallSID: "CA1", Status: 200})
+	audit.RecordSystem(AuditCallTransfer, "CA1", map[string]string{"to": "+15550100"})
+	audit.Record(AuditEntry{Actor: "anonymous", Action: AuditConfigRead, Target: "/admin/config", Status: 401})
+	audit.Close()
+
+	reopened, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	reopened.Record(AuditEntry{Actor: "admin", Action: AuditNotesRead, Target: "/conversations/CA2/notes", CallSID: "CA2"})
+
+	entries := reopened.Query(AuditFilter{})
+	if len(entries) != 4 || entries[0].Seq != 4 || entries[3].Actor != "admin" {
+		t.Fatalf("Expected the four entries newest first, got %+v", entries)
+	}
+	if entries[0].Hash != chainHash(entries[1].Hash, entries[0]) {
+		t.Error("Expected the new entry chained to the ones loaded")
+	}
+	if calls := reopened.Query(AuditFilter{CallSID: "CA1"}); len(calls) != 2 || calls[0].Actor != AuditActorSystem {
+		t.Errorf("Expected the call's two entries, got %+v", calls)
+	}
+	if denied := reopened.Query(AuditFilter{Action: AuditConfigRead, Limit: 5}); len(denied) != 1 || denied[0].Status != 401 {
+		t.Errorf("Expected the denied config read, got %+v", denied)
+	}
+}
+
+func TestAuditLogQueryByTime(t *testing.T) {
+	audit, _ := OpenAuditLog("")
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	audit.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		audit.Record(AuditEntry{Actor: "admin", Action: AuditConversationRead, Target: "/conversations/CA1"})
+		now = now.Add(time.Hour)
+	}
+
+	entries := audit.Query(AuditFilter{From: now.Add(-2 * time.Hour), To: now.Add(-time.Hour)})
+	if len(entries) != 1 || entries[0].Seq != 2 {
+		t.Errorf("Expected the entry recorded in the hour, got %+v", entries)
+	}
+	if entries := audit.Query(AuditFilter{Limit: 2}); len(entries) != 2 || entries[1].Seq != 2 {
+		t.Errorf("Expected the two newest entries, got %+v", entries)
+	}
+	if (*AuditLog)(nil).Query(AuditFilter{}) != nil {
+		t.Error("Expected nothing from no audit log")
+	}
+}
+
+func TestAuditLogRejectsACorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	os.WriteFile(path, []byte("not json\n"), 0o600)
+	if _, err := OpenAuditLog(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected the corrupt line reported, got %v", err)
+	}
+}
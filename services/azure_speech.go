@@ -0,0 +1,322 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/gorilla/websocket"
+)
+
+// AzureSpeechService implements SpeechRecognizer over Azure Cognitive Services Speech,
+// speaking the same websocket protocol as the Speech SDK
+type AzureSpeechService struct {
+	config *config.Config
+	client *http.Client
+	log    *logger.Logger
+}
+
+// azurePhrase is the subset of an Azure hypothesis or phrase message we use
+type azurePhrase struct {
+	RecognitionStatus string `json:"RecognitionStatus"`
+	Text              string `json:"Text"`
+	DisplayText       string `json:"DisplayText"`
+	NBest             []struct {
+		Confidence float32 `json:"Confidence"`
+		Display    string  `json:"Display"`
+		Words      []struct {
+			Word     string `json:"Word"`
+			Offset   int64  `json:"Offset"` // 100ns ticks from the start of the stream
+			Duration int64  `json:"Duration"`
+		} `json:"Words"`
+	} `json:"NBest"`
+}
+
+// azureTick is the unit of Azure offsets and durations
+const azureTick = 100 * time.Nanosecond
+
+// NewAzureSpeechService creates a new Azure speech-to-text service
+func NewAzureSpeechService(cfg *config.Config) (*AzureSpeechService, error) {
+	log := logger.Component("AzureSpeech")
+	log.Info("Creating new Azure Speech service in region %s", cfg.AzureSpeechRegion)
+
+	if cfg.AzureSpeechKey == "" || cfg.AzureSpeechRegion == "" {
+		log.Error("AZURE_SPEECH_KEY or AZURE_SPEECH_REGION environment variable not set")
+		return nil, errors.New("AZURE_SPEECH_KEY and AZURE_SPEECH_REGION are required for the azure STT provider")
+	}
+
+	return &AzureSpeechService{
+		config: cfg,
+		client: &http.Client{Timeout: 60 * time.Second},
+		log:    log,
+	}, nil
+}
+
+// Close is a no-op; Azure connections are per stream
+func (a *AzureSpeechService) Close() error {
+	a.log.Info("Closing Azure Speech service")
+	return nil
+}
+
+// recognitionURL is the conversation-mode recognition endpoint for the scheme
+func (a *AzureSpeechService) recognitionURL(scheme string) string {
+	params := url.Values{}
+	params.Set("language", a.config.STTLanguageCode)
+	params.Set("format", "detailed")
+	params.Set("wordLevelTimestamps", "true")
+	if a.config.STTProfanityFilter {
+		params.Set("profanity", "masked")
+	} else {
+		params.Set("profanity", "raw")
+	}
+	return fmt.Sprintf("%s://%s.stt.speech.microsoft.com/speech/recognition/conversation/cognitiveservices/v1?%s",
+		scheme, a.config.AzureSpeechRegion, params.Encode())
+}
+
+// StartStream opens an Azure recognition websocket for the call
+func (a *AzureSpeechService) StartStream(ctx context.Context, format AudioFormat) (RecognitionStream, error) {
+	log := CallLogger(ctx, a.log)
+	format = format.Normalize()
+	log.Info("Starting Azure stream (%s, %d Hz)", format.Encoding, format.SampleRate)
+
+	connectionID := azureID()
+	header := http.Header{}
+	header.Set("Ocp-Apim-Subscription-Key", a.config.AzureSpeechKey)
+	header.Set("X-ConnectionId", connectionID)
+	correlateHeader(ctx, header)
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, a.recognitionURL("wss"), header)
+	if err != nil {
+		log.Error("Failed to connect to Azure Speech: %v", err)
+		return nil, err
+	}
+
+	stream := &azureStream{
+		conn:        conn,
+		format:      format,
+		requestID:   azureID(),
+		interim:     a.config.STTInterimResults,
+		transcripts: make(chan Transcript, 1024),
+		log:         log,
+	}
+
+	if err := stream.start(); err != nil {
+		log.Error("Failed to start Azure recognition: %v", err)
+		conn.Close()
+		return nil, err
+	}
+	go stream.listen()
+
+	// Close the websocket when the call ends
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	return stream, nil
+}
+
+// TranscribeRecording transcribes a WAV recording with Azure's short-audio REST API (up to 60 seconds)
+func (a *AzureSpeechService) TranscribeRecording(ctx context.Context, wav []byte) (string, error) {
+	log := CallLogger(ctx, a.log)
+	log.Info("Transcribing %d bytes of recorded audio", len(wav))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.recognitionURL("https"), bytes.NewReader(wav))
+	if err != nil {
+		return "", err
+	}
+	correlate(req)
+	req.Header.Set("Ocp-Apim-Subscription-Key", a.config.AzureSpeechKey)
+	req.Header.Set("Content-Type", "audio/wav; codecs=audio/pcm; samplerate=8000")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Error("Error calling Azure Speech: %v", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Azure Speech returned status %d: %s", resp.StatusCode, body)
+		return "", fmt.Errorf("azure speech: unexpected status %d", resp.StatusCode)
+	}
+
+	var result azurePhrase
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.RecognitionStatus != "Success" {
+		log.Warn("Azure Speech recognition status: %s", result.RecognitionStatus)
+		return "", nil
+	}
+	if len(result.NBest) > 0 {
+		return result.NBest[0].Display, nil
+	}
+	return result.DisplayText, nil
+}
+
+// azureStream is a live Azure recognition session
+type azureStream struct {
+	conn        *websocket.Conn
+	format      AudioFormat
+	requestID   string
+	interim     bool
+	transcripts chan Transcript
+	writeMu     sync.Mutex
+	closeOnce   sync.Once
+	log         *logger.Logger
+}
+
+// start sends the speech config and the WAV header that opens the audio stream
+func (s *azureStream) start() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	config := `{"context":{"system":{"name":"call-me-help","version":"1.0.0"},"os":{"platform":"Linux","name":"Linux","version":""}}}`
+	message := "Path: speech.config\r\n" +
+		"X-RequestId: " + s.requestID + "\r\n" +
+		"X-Timestamp: " + time.Now().UTC().Format(time.RFC3339Nano) + "\r\n" +
+		"Content-Type: application/json; charset=utf-8\r\n\r\n" + config
+	if err := s.conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		return err
+	}
+
+	// Azure takes 16-bit PCM; the header announces it at the call's sample rate
+	return s.writeAudio(wavHeader(wavFormatPCM, s.format.SampleRate, 1, 16, 0))
+}
+
+// writeAudio frames audio in an Azure binary message; the caller must hold writeMu
+func (s *azureStream) writeAudio(audio []byte) error {
+	headers := "Path: audio\r\n" +
+		"X-RequestId: " + s.requestID + "\r\n" +
+		"X-Timestamp: " + time.Now().UTC().Format(time.RFC3339Nano) + "\r\n" +
+		"Content-Type: audio/x-wav\r\n"
+
+	message := make([]byte, 2, 2+len(headers)+len(audio))
+	binary.BigEndian.PutUint16(message, uint16(len(headers)))
+	message = append(message, headers...)
+	message = append(message, audio...)
+	return s.conn.WriteMessage(websocket.BinaryMessage, message)
+}
+
+func (s *azureStream) SendAudio(audio []byte) error {
+	pcm := PCM16LE(DecodeSamples(audio, s.format))
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.writeAudio(pcm)
+}
+
+func (s *azureStream) Transcripts() <-chan Transcript {
+	return s.transcripts
+}
+
+// Close sends an empty audio message to end the turn; the reader closes the connection on turn.end
+func (s *azureStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		err = s.writeAudio(nil)
+	})
+	return err
+}
+
+// listen reads Azure recognition events until the turn ends
+func (s *azureStream) listen() {
+	defer func() {
+		s.log.Info("Closing Azure transcription channel")
+		close(s.transcripts)
+		s.conn.Close()
+	}()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				s.log.Info("Azure stream closed")
+			} else {
+				s.log.Error("Error receiving from Azure Speech: %v", err)
+			}
+			return
+		}
+
+		// Text messages are HTTP-style headers, a blank line, then a JSON body
+		head, body, found := strings.Cut(string(data), "\r\n\r\n")
+		if !found {
+			continue
+		}
+		path := ""
+		for _, line := range strings.Split(head, "\r\n") {
+			if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Path") {
+				path = strings.TrimSpace(value)
+			}
+		}
+
+		var phrase azurePhrase
+		switch path {
+		case "turn.end":
+			s.log.Info("Azure recognition turn ended")
+			return
+		case "speech.hypothesis":
+			if !s.interim {
+				continue
+			}
+			if err := json.Unmarshal([]byte(body), &phrase); err != nil || phrase.Text == "" {
+				continue
+			}
+			s.log.Info("Transcription (final=false): %s", logger.Transcript(phrase.Text))
+			s.transcripts <- Transcript{Text: phrase.Text}
+		case "speech.phrase":
+			if err := json.Unmarshal([]byte(body), &phrase); err != nil {
+				s.log.Warn("Ignoring unparseable Azure phrase: %v", err)
+				continue
+			}
+			if phrase.RecognitionStatus != "Success" {
+				s.log.Debug("Azure phrase status: %s", phrase.RecognitionStatus)
+				continue
+			}
+
+			// Azure ends a phrase when the caller pauses, so every phrase is an end of speech
+			transcript := Transcript{Text: phrase.DisplayText, IsFinal: true, EndOfSpeech: true}
+			if len(phrase.NBest) > 0 {
+				transcript.Text = phrase.NBest[0].Display
+				transcript.Confidence = phrase.NBest[0].Confidence
+				for _, word := range phrase.NBest[0].Words {
+					start := time.Duration(word.Offset) * azureTick
+					transcript.Words = append(transcript.Words, WordTiming{
+						Word:  word.Word,
+						Start: start,
+						End:   start + time.Duration(word.Duration)*azureTick,
+					})
+				}
+			}
+			if transcript.Text == "" {
+				continue
+			}
+			s.log.Info("Transcription (final=true): %s", logger.Transcript(transcript.Text))
+			s.transcripts <- transcript
+		}
+	}
+}
+
+// azureID generates the dashless UUID-style IDs Azure expects for connections and requests
+func azureID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
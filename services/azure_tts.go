@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// AzureTTSService implements SpeechSynthesizer over Azure neural voices
+type AzureTTSService struct {
+	config   *config.Config
+	client   *http.Client
+	endpoint string
+	log      *logger.Logger
+}
+
+// NewAzureTTSService creates a new Azure text-to-speech service
+func NewAzureTTSService(cfg *config.Config) (*AzureTTSService, error) {
+	log := logger.Component("AzureTTS")
+	log.Info("Creating new Azure TTS service with voice %s", cfg.AzureTTSVoice)
+
+	if cfg.AzureSpeechKey == "" || cfg.AzureSpeechRegion == "" {
+		log.Error("AZURE_SPEECH_KEY or AZURE_SPEECH_REGION environment variable not set")
+		return nil, errors.New("AZURE_SPEECH_KEY and AZURE_SPEECH_REGION are required for the azure TTS provider")
+	}
+
+	return &AzureTTSService{
+		config:   cfg,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		endpoint: fmt.Sprintf("https://%s.tts.speech.microsoft.com/cognitiveservices/v1", cfg.AzureSpeechRegion),
+		log:      log,
+	}, nil
+}
+
+// Close is a no-op; Azure TTS requests are stateless
+func (a *AzureTTSService) Close() error {
+	a.log.Info("Closing Azure TTS service")
+	return nil
+}
+
+// azureOutputFormat picks the Azure output format for the call, the telephony μ-law and
+// A-law formats for Twilio's 8kHz streams; ok is false when the audio must be converted
+// from 16-bit PCM
+func azureOutputFormat(format AudioFormat) (name string, ok bool) {
+	if format.SampleRate == 8000 {
+		switch format.Encoding {
+		case EncodingMulaw:
+			return "raw-8khz-8bit-mono-mulaw", true
+		case EncodingAlaw:
+			return "raw-8khz-8bit-mono-alaw", true
+		}
+	}
+	return fmt.Sprintf("raw-%dkhz-16bit-mono-pcm", format.SampleRate/1000), false
+}
+
+// SynthesizeSpeech converts text to audio in the given output format
+func (a *AzureTTSService) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	log := CallLogger(ctx, a.log)
+	startTime := time.Now()
+	format = format.Normalize()
+	log.Info("Synthesizing speech for text (%d chars): %q", len(text), logger.Transcript(text))
+
+	switch format.SampleRate {
+	case 8000, 16000, 24000, 48000:
+	default:
+		return nil, fmt.Errorf("azure tts: unsupported sample rate %d", format.SampleRate)
+	}
+	outputFormat, native := azureOutputFormat(format)
+
+	var body string
+	if a.config.TTSSSML {
+		body = ssmlBody(text, ProsodyFromContext(ctx))
+	} else {
+		var escaped strings.Builder
+		xml.EscapeText(&escaped, []byte(text))
+		body = escaped.String()
+	}
+	// Voice names start with their locale, e.g. en-US-JennyNeural
+	voice := chosenVoice(ctx, a.config.AzureTTSVoice)
+	language := voiceLocale(voice)
+	if language == "" {
+		language = a.config.STTLanguageCode
+	}
+	// Rate and pitch are relative to the voice's defaults
+	if rate, pitch := speakingRate(ctx, a.config.TTSSpeakingRate), a.config.TTSPitch; rate != 1 || pitch != 0 {
+		body = fmt.Sprintf(`<prosody rate="%+.0f%%" pitch="%+.1fst">%s</prosody>`, (rate-1)*100, pitch, body)
+	}
+
+	// Neural voices can speak in a style, e.g. empathetic or gentle
+	if style := a.config.AzureTTSStyle; style != "" {
+		body = fmt.Sprintf(`<mstts:express-as style="%s">%s</mstts:express-as>`, style, body)
+	}
+	ssml := fmt.Sprintf(`<speak version="1.0" xmlns="http://www.w3.org/2001/10/synthesis" xmlns:mstts="https://www.w3.org/2001/mstts" xml:lang="%s"><voice name="%s">%s</voice></speak>`,
+		language, voice, body)
+
+	ttsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ttsCtx, http.MethodPost, a.endpoint, strings.NewReader(ssml))
+	if err != nil {
+		return nil, err
+	}
+	correlate(req)
+	req.Header.Set("Ocp-Apim-Subscription-Key", a.config.AzureSpeechKey)
+	req.Header.Set("Content-Type", "application/ssml+xml")
+	req.Header.Set("X-Microsoft-OutputFormat", outputFormat)
+	req.Header.Set("User-Agent", "call-me-help")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Error("Azure TTS error after %v: %v", time.Since(startTime), err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Azure TTS returned status %d: %s", resp.StatusCode, body)
+		return nil, fmt.Errorf("azure tts: unexpected status %d", resp.StatusCode)
+	}
+
+	audio, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if !native {
+		// Azure returns little-endian PCM; re-encode for the call
+		samples := make([]int16, len(audio)/2)
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(audio[2*i:]))
+		}
+		audio = EncodeSamples(samples, format)
+	}
+
+	log.Info("Successfully synthesized %d bytes of audio in %v", len(audio), time.Since(startTime))
+	return audio, nil
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+func TestAzureTTSRequestsTelephonyFormatInStyle(t *testing.T) {
+	var ssml, outputFormat string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ssml, outputFormat = string(body), r.Header.Get("X-Microsoft-OutputFormat")
+		w.Write([]byte{0xff, 0x7f})
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{AzureSpeechKey: "key", AzureSpeechRegion: "westeurope",
+		AzureTTSVoice: "es-ES-ElviraNeural", AzureTTSStyle: "empathetic"}
+	azure, err := NewAzureTTSService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	azure.endpoint = server.URL
+
+	audio, err := azure.SynthesizeSpeech(context.Background(), "Estoy aquí & contigo", DefaultAudioFormat())
+	if err != nil {
+		t.Fatalf("Failed to synthesize: %v", err)
+	}
+	if outputFormat != "raw-8khz-8bit-mono-mulaw" {
+		t.Errorf("Expected the telephony μ-law format, got %q", outputFormat)
+	}
+	if string(audio) != "\xff\x7f" {
+		t.Errorf("Expected native μ-law passed through, got %v", audio)
+	}
+	for _, want := range []string{`xml:lang="es-ES"`, `<voice name="es-ES-ElviraNeural">`,
+		`<mstts:express-as style="empathetic">Estoy aquí &amp; contigo</mstts:express-as>`} {
+		if !strings.Contains(ssml, want) {
+			t.Errorf("Expected the SSML to contain %s, got %s", want, ssml)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	"golang.org/x/oauth2/google"
+)
+
+// bigQueryScope is the OAuth scope for streaming rows into BigQuery
+const bigQueryScope = "https://www.googleapis.com/auth/bigquery.insertdata"
+
+// How much of the transcript exported call rows carry
+const (
+	ExportTranscriptNone     = "none"
+	ExportTranscriptRedacted = "redacted" // Emails and phone numbers replaced
+	ExportTranscriptFull     = "full"
+)
+
+// callExportQueueSize is how many ended calls can wait to be exported
+const callExportQueueSize = 1000
+
+// CallExportRow is the BigQuery row of an ended call. Transcripts hold the stored
+// messages, in which sensitive terms are already masked.
+type CallExportRow struct {
+	CallSID         string              `json:"call_sid"`
+	CallerHash      string              `json:"caller_hash,omitempty"`
+	Persona         string              `json:"persona,omitempty"`
+	Tenant          string              `json:"tenant,omitempty"`
+	StartedAt       time.Time           `json:"started_at"`
+	EndedAt         *time.Time          `json:"ended_at,omitempty"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	Turns           int                 `json:"turns"` // Times the caller spoke
+	MessageCount    int                 `json:"message_count"`
+	RiskFlags       []string            `json:"risk_flags"`
+	Tags            []string            `json:"tags"`
+	Escalated       bool                `json:"escalated"`
+	Summary         string              `json:"summary,omitempty"`
+	Topics          []string            `json:"topics"`
+	FollowUps       []string            `json:"follow_ups"`
+	MoodScore       *float64            `json:"mood_score,omitempty"`
+	Transcript      []CallExportMessage `json:"transcript"`
+	ExportedAt      time.Time           `json:"exported_at"`
+}
+
+// CallExportMessage is one message of an exported transcript
+type CallExportMessage struct {
+	Time    time.Time `json:"time"`
+	Speaker string    `json:"speaker"` // Caller or Therapist
+	Text    string    `json:"text"`
+}
+
+// CallExporter streams the records of ended calls into a BigQuery table in the
+// background, in batches, retrying failed inserts with backoff
+type CallExporter struct {
+	transcripts   string // ExportTranscriptNone, Redacted or Full
+	batchSize     int
+	flushInterval time.Duration
+	maxAttempts   int
+	// Backoff is the wait before the first retry, doubled for each further one
+	Backoff time.Duration
+
+	queue    chan CallExportRow
+	client   *http.Client
+	endpoint string // The table's insertAll method
+	log      *logger.Logger
+}
+
+// NewCallExporter creates an exporter into the table, given as dataset.table, of the
+// project; it returns nil, which exports nothing, when no table is set
+func NewCallExporter(ctx context.Context, projectID, table, transcripts string, batchSize int, flushInterval time.Duration, maxAttempts int) (*CallExporter, error) {
+	if table == "" {
+		return nil, nil
+	}
+	if projectID == "" {
+		return nil, errors.New("GOOGLE_PROJECT_ID is required to export calls to BigQuery")
+	}
+	dataset, name, _ := strings.Cut(table, ".")
+	if dataset == "" || name == "" {
+		return nil, fmt.Errorf("BigQuery table %q must be given as dataset.table", table)
+	}
+	switch transcripts {
+	case ExportTranscriptNone, ExportTranscriptRedacted, ExportTranscriptFull:
+	default:
+		return nil, fmt.Errorf("invalid transcript export %q, use none, redacted or full", transcripts)
+	}
+
+	client, err := google.DefaultClient(ctx, bigQueryScope)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 30 * time.Second
+
+	return newCallExporter(client, fmt.Sprintf("https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll", projectID, dataset, name),
+		transcripts, batchSize, flushInterval, maxAttempts), nil
+}
+
+// newCallExporter creates an exporter inserting at the endpoint
+func newCallExporter(client *http.Client, endpoint, transcripts string, batchSize int, flushInterval time.Duration, maxAttempts int) *CallExporter {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &CallExporter{
+		transcripts:   transcripts,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		maxAttempts:   maxAttempts,
+		Backoff:       time.Second,
+		queue:         make(chan CallExportRow, callExportQueueSize),
+		client:        client,
+		endpoint:      endpoint,
+		log:           logger.Component("CallExport"),
+	}
+}
+
+// Export queues the record of an ended call; it is dropped when the queue is full. A
+// nil exporter exports nothing.
+func (e *CallExporter) Export(conv *Conversation) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- e.row(conv):
+	default:
+		e.log.Warn("Export queue full, dropping call %s", conv.ID)
+	}
+}
+
+// row builds the export row of the conversation
+func (e *CallExporter) row(conv *Conversation) CallExportRow {
+	record := NewTranscriptExport(conv)
+	row := CallExportRow{
+		CallSID:      record.CallSID,
+		CallerHash:   record.CallerHash,
+		Persona:      record.Persona,
+		Tenant:       record.Tenant,
+		StartedAt:    record.StartedAt.UTC(),
+		MessageCount: len(record.Messages),
+		RiskFlags:    append([]string{}, record.RiskFlags...),
+		Tags:         append([]string{}, record.Tags...),
+		Escalated:    conv.HasTags(TagEscalated),
+		Topics:       []string{},
+		FollowUps:    []string{},
+		Transcript:   []CallExportMessage{},
+		ExportedAt:   time.Now().UTC(),
+	}
+	if ended := conv.EndedAt(); !ended.IsZero() {
+		ended = ended.UTC()
+		row.EndedAt = &ended
+		row.DurationSeconds = ended.Sub(row.StartedAt).Seconds()
+	}
+	if summary := record.Summary; summary != nil {
+		row.Summary = summary.Overview
+		row.Topics = append(row.Topics, summary.Topics...)
+		row.FollowUps = append(row.FollowUps, summary.FollowUps...)
+	}
+	if score, _, ok := callMood(conv.SentimentTrajectory()); ok {
+		row.MoodScore = &score
+	}
+	for _, msg := range record.Messages {
+		if msg.Speaker == "Caller" {
+			row.Turns++
+		}
+		switch e.transcripts {
+		case ExportTranscriptFull:
+			row.Transcript = append(row.Transcript, CallExportMessage{Time: msg.Time.UTC(), Speaker: msg.Speaker, Text: msg.Text})
+		case ExportTranscriptRedacted:
+			row.Transcript = append(row.Transcript, CallExportMessage{Time: msg.Time.UTC(), Speaker: msg.Speaker, Text: logger.MaskPII(msg.Text)})
+		}
+	}
+	return row
+}
+
+// Run inserts queued rows until the context is cancelled, in batches of up to the batch
+// size, flushing partial batches every flush interval and on shutdown
+func (e *CallExporter) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	e.log.Info("Exporting ended calls to BigQuery in batches of %d (transcripts: %s)", e.batchSize, e.transcripts)
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	var batch []CallExportRow
+	for {
+		select {
+		case <-ctx.Done():
+			// Rows still queued are drained into a last insert, which gets a moment to finish
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			e.flush(flushCtx, batch)
+			cancel()
+			return
+		case row := <-e.queue:
+			if batch = append(batch, row); len(batch) >= e.batchSize {
+				e.flush(ctx, batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.flush(ctx, batch)
+			batch = nil
+		}
+	}
+}
+
+// flush inserts a batch until it is accepted, it is rejected outright or the attempts run
+// out. Network errors, 429s and 5xx responses are retried.
+func (e *CallExporter) flush(ctx context.Context, batch []CallExportRow) {
+	if len(batch) == 0 {
+		return
+	}
+	wait := e.Backoff
+	for attempt := 1; ; attempt++ {
+		err := e.insert(ctx, batch)
+		if err == nil {
+			e.log.Debug("Exported %d call(s)", len(batch))
+			return
+		}
+		var status statusError
+		if errors.As(err, &status) && !status.retryable() {
+			e.log.Error("BigQuery rejected %d call(s): %v", len(batch), err)
+			return
+		}
+		if attempt >= e.maxAttempts {
+			e.log.Error("Giving up exporting %d call(s) after %d attempt(s): %v", len(batch), attempt, err)
+			return
+		}
+		e.log.Warn("Exporting %d call(s) failed, retrying in %v: %v", len(batch), wait, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// insert makes one insertAll request. Rows carry their call SID as insert ID, so
+// BigQuery drops the duplicates a retry may send.
+func (e *CallExporter) insert(ctx context.Context, batch []CallExportRow) error {
+	type insertRow struct {
+		InsertID string        `json:"insertId"`
+		JSON     CallExportRow `json:"json"`
+	}
+	rows := make([]insertRow, 0, len(batch))
+	for _, row := range batch {
+		rows = append(rows, insertRow{InsertID: row.CallSID, JSON: row})
+	}
+	// Valid rows go in even when others in the batch don't fit the table
+	body, err := json.Marshal(map[string]any{"rows": rows, "skipInvalidRows": true})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		e.log.Error("BigQuery API returned status %d: %s", resp.StatusCode, msg)
+		return statusError(resp.StatusCode)
+	}
+
+	// Rows that don't fit the table are reported one by one and not retried
+	var result struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+		return err
+	}
+	for _, rowErr := range result.InsertErrors {
+		if rowErr.Index < 0 || rowErr.Index >= len(batch) {
+			continue
+		}
+		for _, detail := range rowErr.Errors {
+			e.log.Error("BigQuery rejected call %s: %s: %s", batch[rowErr.Index].CallSID, detail.Reason, detail.Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// insertRequest is the body of an insertAll request
+type insertRequest struct {
+	Rows []struct {
+		InsertID string        `json:"insertId"`
+		JSON     CallExportRow `json:"json"`
+	} `json:"rows"`
+}
+
+func TestCallExporterBatchesRows(t *testing.T) {
+	requests := make(chan insertRequest, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request insertRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		requests <- request
+		w.Write([]byte(`{"kind": "bigquery#tableDataInsertAllResponse"}`))
+	}))
+	defer server.Close()
+
+	exporter := newCallExporter(server.Client(), server.URL, ExportTranscriptRedacted, 2, time.Hour, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(ctx)
+		close(done)
+	}()
+
+	conv := exportConversation()
+	conv.AddUserMessage("Call me back on +1 (555) 123-4567 or at sam@example.com")
+	conv.End()
+	exporter.Export(conv)
+	exporter.Export(NewConversationService().GetOrCreateConversation("CA456"))
+	exporter.Export(NewConversationService().GetOrCreateConversation("CA789"))
+
+	// A full batch is inserted right away
+	select {
+	case request := <-requests:
+		if len(request.Rows) != 2 || request.Rows[0].InsertID != "CA123" || request.Rows[1].InsertID != "CA456" {
+			t.Fatalf("Expected the first two calls in a batch, got %+v", request.Rows)
+		}
+		row := request.Rows[0].JSON
+		if row.Turns != 2 || row.MessageCount != 3 || row.EndedAt == nil || len(row.RiskFlags) != 1 || row.MoodScore != nil {
+			t.Errorf("Expected the call's figures in the row, got %+v", row)
+		}
+		if len(row.Transcript) != 3 || row.Transcript[2].Text != "Call me back on [phone] or at [email]" {
+			t.Errorf("Expected a redacted transcript, got %+v", row.Transcript)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a batch inserted")
+	}
+
+	// The rest is flushed on shutdown
+	cancel()
+	<-done
+	select {
+	case request := <-requests:
+		if len(request.Rows) != 1 || request.Rows[0].InsertID != "CA789" {
+			t.Errorf("Expected the last call flushed, got %+v", request.Rows)
+		}
+	default:
+		t.Error("Expected the partial batch flushed on shutdown")
+	}
+}
+
+func TestCallExporterRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int32
+	}{
+		{"server errors are retried", []int{503, 200}, 2},
+		{"rejections are not", []int{400, 200}, 1},
+		{"attempts run out", []int{500, 500, 500, 500}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[attempts.Add(1)-1])
+			}))
+			defer server.Close()
+
+			exporter := newCallExporter(server.Client(), server.URL, ExportTranscriptNone, 1, time.Hour, 3)
+			exporter.Backoff = time.Millisecond
+			exporter.flush(context.Background(), []CallExportRow{exporter.row(exportConversation())})
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("Expected %d attempt(s), got %d", tt.attempts, got)
+			}
+		})
+	}
+}
+
+func TestCallExporterLeavesTranscriptsOut(t *testing.T) {
+	exporter := newCallExporter(http.DefaultClient, "", ExportTranscriptNone, 1, time.Hour, 1)
+	if row := exporter.row(exportConversation()); len(row.Transcript) != 0 || row.Turns != 1 {
+		t.Errorf("Expected no transcript by default, got %+v", row)
+	}
+}
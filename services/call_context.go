@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/ghophp/call-me-help/logger"
+	"google.golang.org/grpc/metadata"
+)
+
+// CorrelationHeader carries a call's correlation ID on the requests made for it, and
+// correlationMetadata on its gRPC calls, so providers' logs can be matched with ours
+const (
+	CorrelationHeader   = "X-Correlation-ID"
+	correlationMetadata = "x-correlation-id"
+)
+
+// callContextKey is the context key type for call-scoped values
+type callContextKey struct{}
+
+// WithCallSID returns a context carrying the call SID the work belongs to
+func WithCallSID(ctx context.Context, callSID string) context.Context {
+	return context.WithValue(ctx, callContextKey{}, callSID)
+}
+
+// CallSIDFromContext returns the call SID stored by WithCallSID, or "" when there is none
+func CallSIDFromContext(ctx context.Context) string {
+	callSID, _ := ctx.Value(callContextKey{}).(string)
+	return callSID
+}
+
+// correlationContextKey is the context key type for the call's correlation ID
+type correlationContextKey struct{}
+
+// NewCorrelationID generates the ID tracing one call through every component's logs and
+// the requests made for it
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationID returns a context carrying the call's correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationContextKey{}, id)
+}
+
+// CorrelationIDFromContext returns the ID stored by WithCorrelationID, or "" when there is none
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationContextKey{}).(string)
+	return id
+}
+
+// CallLogger returns the logger adding the call SID and correlation ID in the context to
+// every record
+func CallLogger(ctx context.Context, log *logger.Logger) *logger.Logger {
+	return log.WithCall(CallSIDFromContext(ctx)).WithCorrelation(CorrelationIDFromContext(ctx))
+}
+
+// correlate sets the correlation header on a request made for a call
+func correlate(req *http.Request) {
+	correlateHeader(req.Context(), req.Header)
+}
+
+// correlateHeader sets the correlation header of the call in the context, e.g. on a
+// websocket handshake
+func correlateHeader(ctx context.Context, header http.Header) {
+	if id := CorrelationIDFromContext(ctx); id != "" {
+		header.Set(CorrelationHeader, id)
+	}
+}
+
+// outgoingCorrelation returns a context sending the call's correlation ID as metadata of
+// the gRPC calls made with it
+func outgoingCorrelation(ctx context.Context) context.Context {
+	if id := CorrelationIDFromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, correlationMetadata, id)
+	}
+	return ctx
+}
+
+// personaContextKey is the context key type for the persona a call uses
+type personaContextKey struct{}
+
+// WithPersona returns a context asking the LLM to answer as the persona, with its prompt
+// template and generation params
+func WithPersona(ctx context.Context, persona string) context.Context {
+	return context.WithValue(ctx, personaContextKey{}, persona)
+}
+
+// PersonaFromContext returns the persona stored by WithPersona, or "" for the default one
+func PersonaFromContext(ctx context.Context) string {
+	persona, _ := ctx.Value(personaContextKey{}).(string)
+	return persona
+}
+
+// personaNameContextKey is the context key type for the name the call's persona goes by
+type personaNameContextKey struct{}
+
+// WithPersonaName returns a context asking the LLM to go by the name instead of the
+// configured one
+func WithPersonaName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, personaNameContextKey{}, name)
+}
+
+// personaName returns the name stored by WithPersonaName, or the configured one
+func personaName(ctx context.Context, configured string) string {
+	if name, _ := ctx.Value(personaNameContextKey{}).(string); name != "" {
+		return name
+	}
+	return configured
+}
+
+// languageContextKey is the context key type for the language the caller speaks
+type languageContextKey struct{}
+
+// WithLanguage returns a context asking the LLM and speech synthesis to use the caller's
+// language instead of the configured one
+func WithLanguage(ctx context.Context, language string) context.Context {
+	return context.WithValue(ctx, languageContextKey{}, language)
+}
+
+// LanguageFromContext returns the language stored by WithLanguage, or "" for the configured one
+func LanguageFromContext(ctx context.Context) string {
+	language, _ := ctx.Value(languageContextKey{}).(string)
+	return language
+}
+
+// voiceContextKey is the context key type for the call's chosen voice
+type voiceContextKey struct{}
+
+// WithVoice returns a context asking speech synthesis to use the voice instead of the
+// configured one
+func WithVoice(ctx context.Context, voice string) context.Context {
+	return context.WithValue(ctx, voiceContextKey{}, voice)
+}
+
+// VoiceFromContext returns the voice stored by WithVoice, or "" for the configured voice
+func VoiceFromContext(ctx context.Context) string {
+	voice, _ := ctx.Value(voiceContextKey{}).(string)
+	return voice
+}
+
+// speakingRateContextKey is the context key type for the call's speaking rate factor
+type speakingRateContextKey struct{}
+
+// WithSpeakingRate returns a context asking speech synthesis to scale the configured
+// speaking rate by factor, e.g. 0.85 for a caller who asked us to slow down
+func WithSpeakingRate(ctx context.Context, factor float64) context.Context {
+	return context.WithValue(ctx, speakingRateContextKey{}, factor)
+}
+
+// speakingRate returns the configured rate scaled by the factor stored by WithSpeakingRate
+func speakingRate(ctx context.Context, configured float64) float64 {
+	if configured <= 0 {
+		configured = 1
+	}
+	if factor, ok := ctx.Value(speakingRateContextKey{}).(float64); ok && factor > 0 {
+		return configured * factor
+	}
+	return configured
+}
+
+// callerNumberContextKey is the context key type for the caller's phone number
+type callerNumberContextKey struct{}
+
+// WithCallerNumber returns a context carrying the caller's phone number, for actions that
+// reach them after the call, like a text message or a callback
+func WithCallerNumber(ctx context.Context, number string) context.Context {
+	return context.WithValue(ctx, callerNumberContextKey{}, number)
+}
+
+// CallerNumberFromContext returns the number stored by WithCallerNumber, or "" when there is none
+func CallerNumberFromContext(ctx context.Context) string {
+	number, _ := ctx.Value(callerNumberContextKey{}).(string)
+	return number
+}
+
+// recognitionContextKey is the context key type for the call's recognition hints
+type recognitionContextKey struct{}
+
+// WithRecognitionHints returns a context telling speech recognition the call's active
+// language and persona, asked each time a stream opens so that a language detected
+// mid-call applies from the next stream on
+func WithRecognitionHints(ctx context.Context, hints func() (language, persona string)) context.Context {
+	return context.WithValue(ctx, recognitionContextKey{}, hints)
+}
+
+// recognitionHints returns the language and persona from WithRecognitionHints, or the
+// configured language and the default persona
+func recognitionHints(ctx context.Context, configured string) (language, persona string) {
+	if hints, ok := ctx.Value(recognitionContextKey{}).(func() (string, string)); ok {
+		language, persona = hints()
+	}
+	if language == "" {
+		language = configured
+	}
+	if persona == "" {
+		persona = DefaultPersona
+	}
+	return language, persona
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// CallJanitor evicts the in-memory state of calls that ended longer than the retention
+// ago, so the conversation and channel maps don't grow for as long as the process runs
+type CallJanitor struct {
+	conversations *ConversationService
+	channels      *ChannelManager
+	retention     time.Duration
+	// Persist saves a conversation before it is evicted; a conversation it fails to save is
+	// kept and tried again on the next sweep. Nil evicts without saving.
+	Persist func(conv *Conversation) error
+	// Audit, when set, records the conversations evicted
+	Audit *AuditLog
+
+	evicted         atomic.Int64
+	persistFailures atomic.Int64
+	log             *logger.Logger
+}
+
+// CallStateStats is how much call state is held in memory
+type CallStateStats struct {
+	Conversations int `json:"conversations"`
+	Channels      int `json:"channels"`
+	// Evicted counts the ended calls the janitor dropped from memory since startup
+	Evicted         int64 `json:"evicted"`
+	PersistFailures int64 `json:"persistFailures"`
+}
+
+// NewCallJanitor creates a janitor keeping ended calls for the retention; it returns nil,
+// which keeps every call, when the retention isn't positive
+func NewCallJanitor(conversations *ConversationService, channels *ChannelManager, retention time.Duration) *CallJanitor {
+	if retention <= 0 {
+		return nil
+	}
+	return &CallJanitor{
+		conversations: conversations,
+		channels:      channels,
+		retention:     retention,
+		log:           logger.Component("CallJanitor"),
+	}
+}
+
+// Run sweeps at the interval until the context is cancelled
+func (j *CallJanitor) Run(ctx context.Context, interval time.Duration) {
+	if j == nil || interval <= 0 {
+		return
+	}
+	j.log.Info("Evicting calls %v after they end, checking every %v", j.retention, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			j.Sweep(now)
+		}
+	}
+}
+
+// Sweep evicts the calls that ended before now less the retention, along with channels as
+// old whose conversation is gone or ended, e.g. of calls whose media stream never connected.
+// It returns how many conversations were evicted.
+func (j *CallJanitor) Sweep(now time.Time) int {
+	cutoff := now.Add(-j.retention)
+	evicted := 0
+	for _, conv := range j.conversations.EndedBefore(cutoff) {
+		if j.Persist != nil {
+			if err := j.Persist(conv); err != nil {
+				j.persistFailures.Add(1)
+				j.log.Error("Keeping call %s in memory, failed to persist it: %v", conv.ID, err)
+				continue
+			}
+		}
+		j.conversations.RemoveConversation(conv.ID)
+		j.channels.RemoveChannels(conv.ID)
+		j.Audit.RecordSystem(AuditConversationEvict, conv.ID, nil)
+		evicted++
+	}
+
+	for _, callSID := range j.channels.CreatedBefore(cutoff) {
+		if conv, ok := j.conversations.GetConversation(callSID); !ok || !conv.EndedAt().IsZero() && conv.EndedAt().Before(cutoff) {
+			j.channels.RemoveChannels(callSID)
+		}
+	}
+
+	if evicted > 0 {
+		j.evicted.Add(int64(evicted))
+		j.log.Info("Evicted %d ended call(s), %d conversation(s) and %d call channel(s) remain",
+			evicted, j.conversations.Count(), j.channels.Count())
+	}
+	return evicted
+}
+
+// CallState returns how much call state the services hold in memory
+func CallState(svc *ServiceContainer) CallStateStats {
+	stats := CallStateStats{
+		Conversations: svc.Conversation.Count(),
+		Channels:      svc.ChannelManager.Count(),
+	}
+	if j := svc.Janitor; j != nil {
+		stats.Evicted = j.evicted.Load()
+		stats.PersistFailures = j.persistFailures.Load()
+	}
+	return stats
+}
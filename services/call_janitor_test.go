@@ -0,0 +1,58 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallJanitorEvictsEndedCalls(t *testing.T) {
+	conversations := NewConversationService()
+	channels := NewChannelManager()
+	for _, id := range []string{"live", "ended", "recent", "unsaved"} {
+		conversations.GetOrCreateConversation(id)
+		channels.CreateChannels(id)
+	}
+	channels.CreateChannels("orphan")
+	for _, id := range []string{"ended", "unsaved", "recent"} {
+		conv, _ := conversations.GetConversation(id)
+		conv.End()
+	}
+
+	janitor := NewCallJanitor(conversations, channels, time.Hour)
+	var persisted []string
+	janitor.Persist = func(conv *Conversation) error {
+		if conv.ID == "unsaved" {
+			return errors.New("store unavailable")
+		}
+		persisted = append(persisted, conv.ID)
+		return nil
+	}
+
+	// Nothing is old enough yet
+	if evicted := janitor.Sweep(time.Now()); evicted != 0 || conversations.Count() != 4 || channels.Count() != 5 {
+		t.Fatalf("Expected nothing evicted, got %d, %d conversations and %d channels", evicted, conversations.Count(), channels.Count())
+	}
+
+	recent, _ := conversations.GetConversation("recent")
+	recent.endedAt = time.Now().Add(2 * time.Hour)
+	if evicted := janitor.Sweep(time.Now().Add(90 * time.Minute)); evicted != 1 || len(persisted) != 1 || persisted[0] != "ended" {
+		t.Fatalf("Expected only the ended call evicted after persisting, got %d, %q", evicted, persisted)
+	}
+	for _, id := range []string{"live", "recent", "unsaved"} {
+		if _, ok := conversations.GetConversation(id); !ok {
+			t.Errorf("Expected %s kept", id)
+		}
+	}
+	if _, ok := channels.channels["live"]; !ok || len(channels.channels) != 2 {
+		t.Errorf("Expected only the live and recent calls' channels kept, got %d", len(channels.channels))
+	}
+
+	stats := CallState(&ServiceContainer{Conversation: conversations, ChannelManager: channels, Janitor: janitor})
+	if stats != (CallStateStats{Conversations: 3, Channels: 2, Evicted: 1, PersistFailures: 1}) {
+		t.Errorf("Unexpected call state: %+v", stats)
+	}
+	if NewCallJanitor(conversations, channels, 0) != nil {
+		t.Error("Expected no janitor without a retention")
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Pipeline states of a call
+const (
+	PipelineConnecting = "connecting" // Waiting for the media stream
+	PipelineListening  = "listening"  // Waiting for the caller to finish a turn
+	PipelineThinking   = "thinking"   // Generating the response to a turn
+	PipelineSpeaking   = "speaking"   // Sending the response's audio
+	PipelineEnded      = "ended"
+)
+
+// pipelineCounters track a call's pipeline as it runs; the zero value is a call connecting
+type pipelineCounters struct {
+	state              atomic.Value // string
+	audioOutBytes      atomic.Int64
+	droppedTranscripts atomic.Int64
+	droppedResponses   atomic.Int64
+}
+
+// SetPipelineState records what the call's pipeline is doing, one of the Pipeline states
+func (cd *ChannelData) SetPipelineState(state string) {
+	cd.counters.state.Store(state)
+}
+
+// PipelineState returns what the call's pipeline is doing
+func (cd *ChannelData) PipelineState() string {
+	if state, ok := cd.counters.state.Load().(string); ok {
+		return state
+	}
+	return PipelineConnecting
+}
+
+// CountAudioOut records audio sent to the caller
+func (cd *ChannelData) CountAudioOut(bytes int) {
+	cd.counters.audioOutBytes.Add(int64(bytes))
+}
+
+// CallLatencies are the average time the stages of the call's turns took, in
+// milliseconds; a stage with nothing measured yet is 0
+type CallLatencies struct {
+	GenerationMs float64 `json:"generationMs"` // LLM writing the response
+	SynthesisMs  float64 `json:"synthesisMs"`  // TTS producing the response's first audio
+}
+
+// CallDrops count what the call lost along the pipeline
+type CallDrops struct {
+	LateFrames     int   `json:"lateFrames"`     // Inbound audio too late for the jitter buffer
+	Transcriptions int64 `json:"transcriptions"` // Recognition results with no room in the queue
+	Responses      int64 `json:"responses"`      // Response text or audio with no room in the queue
+}
+
+// PipelineStats is how a live or recently ended call's pipeline is doing
+type PipelineStats struct {
+	State         string        `json:"state"`
+	Turns         int           `json:"turns"`
+	Latencies     CallLatencies `json:"latencies"`
+	AudioBytesIn  int64         `json:"audioBytesIn"`
+	AudioBytesOut int64         `json:"audioBytesOut"`
+	Drops         CallDrops     `json:"drops"`
+}
+
+// CallPipelineStats reports the call's pipeline from its conversation and, while they are
+// kept, its channels
+func CallPipelineStats(conv *Conversation, channels *ChannelData) PipelineStats {
+	stats := PipelineStats{State: PipelineConnecting}
+	var generation, synthesis time.Duration
+	var generated, synthesized int
+	for _, message := range conv.Transcript() {
+		switch {
+		case message.Role == "user":
+			stats.Turns++
+		case message.GenerationLatency > 0:
+			generation += message.GenerationLatency
+			generated++
+		}
+		if message.SynthesisLatency > 0 {
+			synthesis += message.SynthesisLatency
+			synthesized++
+		}
+	}
+	stats.Latencies.GenerationMs = averageMs(generation, generated)
+	stats.Latencies.SynthesisMs = averageMs(synthesis, synthesized)
+
+	if channels != nil {
+		stats.State = channels.PipelineState()
+		if diagnostics := channels.AudioDiagnostics(); diagnostics != nil {
+			stats.AudioBytesIn = diagnostics.Snapshot().Bytes
+		}
+		stats.AudioBytesOut = channels.counters.audioOutBytes.Load()
+		stats.Drops.Transcriptions = channels.counters.droppedTranscripts.Load()
+		stats.Drops.Responses = channels.counters.droppedResponses.Load()
+		if channels.jitterBuffer != nil {
+			stats.Drops.LateFrames = channels.jitterBuffer.DroppedFrames()
+		}
+	}
+	if !conv.EndedAt().IsZero() {
+		stats.State = PipelineEnded
+	}
+	return stats
+}
+
+// averageMs is the average of n durations totalling total, in milliseconds
+func averageMs(total time.Duration, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return float64(total.Microseconds()) / float64(n) / 1000
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Risk flags an end-of-call summary can raise
+const (
+	RiskSuicidalIdeation = "suicidal_ideation"
+	RiskSelfHarm         = "self_harm"
+	RiskHarmToOthers     = "harm_to_others"
+	RiskAbuse            = "abuse"
+	RiskSubstanceUse     = "substance_use"
+	RiskMedication       = "medication"
+)
+
+// riskFlags are the flags the summarizer may use, in the order they are reported
+var riskFlags = []string{RiskSuicidalIdeation, RiskSelfHarm, RiskHarmToOthers, RiskAbuse, RiskSubstanceUse, RiskMedication}
+
+// callSummaryInstructions asks the model for the summary as JSON instead of a reply
+var callSummaryInstructions = "The phone conversation above has ended. Write a summary of it for the counselor who follows up with the caller. " +
+	"Answer with only a JSON object, without a code block, with these fields: " +
+	`"overview", two or three sentences on what the caller shared and how the call ended; ` +
+	`"topics", up to 5 short key topics; ` +
+	`"riskFlags", the risks the caller mentioned, from ` + strings.Join(riskFlags, ", ") + `, or an empty list; ` +
+	`"followUps", up to 3 suggested follow-ups for the counselor. Do not reply to the caller.`
+
+// CallSummary is what a call was about, written by the LLM once it has ended
+type CallSummary struct {
+	Overview  string    `json:"overview"`
+	Topics    []string  `json:"topics"`
+	RiskFlags []string  `json:"riskFlags"`
+	FollowUps []string  `json:"followUps"`
+	Model     string    `json:"model,omitempty"` // Model that wrote the summary
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CallSummarizer writes the summary of ended calls and stores it with their conversation
+type CallSummarizer struct {
+	generator ResponseGenerator
+	timeout   time.Duration
+	log       *logger.Logger
+}
+
+// NewCallSummarizer creates a summarizer writing with the generator
+func NewCallSummarizer(generator ResponseGenerator) *CallSummarizer {
+	return &CallSummarizer{
+		generator: generator,
+		timeout:   60 * time.Second,
+		log:       logger.Component("CallSummary"),
+	}
+}
+
+// Summarize writes the summary of an ended call and stores it with the conversation. Calls
+// where the caller said nothing aren't summarized; a nil summarizer does nothing.
+func (s *CallSummarizer) Summarize(ctx context.Context, conv *Conversation) (*CallSummary, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	transcript, spoke := callTranscript(conv)
+	if !spoke {
+		s.log.Debug("Not summarizing call %s, the caller said nothing", conv.ID)
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(WithCallSID(ctx, conv.ID), s.timeout)
+	defer cancel()
+	ctx, report := WithGenerationReport(ctx)
+	startTime := time.Now()
+	response, err := s.generator.GenerateResponse(ctx, transcript, []string{"Context: " + callSummaryInstructions})
+	if err != nil {
+		s.log.Error("Failed to summarize call %s after %v: %v", conv.ID, time.Since(startTime), err)
+		return nil, err
+	}
+
+	summary, err := parseCallSummary(response)
+	if err != nil {
+		s.log.Error("Unusable summary of call %s: %v", conv.ID, err)
+		return nil, err
+	}
+	// Risks flagged during the call stand even if the summary missed them
+	for _, flag := range conv.RiskFlags() {
+		if !slices.Contains(summary.RiskFlags, flag) {
+			summary.RiskFlags = append(summary.RiskFlags, flag)
+		}
+	}
+	summary.Model = report.Model
+	summary.CreatedAt = time.Now()
+	conv.SetCallSummary(summary)
+
+	s.log.Info("Summarized call %s in %v: %d topics, risk flags %v", conv.ID, time.Since(startTime), len(summary.Topics), summary.RiskFlags)
+	return summary, nil
+}
+
+// callTranscript writes out the conversation for the LLM to review, reporting whether the
+// caller said anything
+func callTranscript(conv *Conversation) (transcript string, spoke bool) {
+	var b strings.Builder
+	for _, msg := range conv.Transcript() {
+		spoke = spoke || msg.Role == "user"
+		b.WriteString(formatMessage(msg) + "\n")
+	}
+	return b.String(), spoke
+}
+
+// parseCallSummary reads the JSON summary from the model's response, which may still be
+// wrapped in a code block, keeping only the known risk flags
+func parseCallSummary(response string) (*CallSummary, error) {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("no JSON object in the response")
+	}
+
+	var summary CallSummary
+	if err := json.Unmarshal([]byte(response[start:end+1]), &summary); err != nil {
+		return nil, fmt.Errorf("parsing summary: %w", err)
+	}
+	if strings.TrimSpace(summary.Overview) == "" {
+		return nil, errors.New("summary has no overview")
+	}
+
+	flags := summary.RiskFlags
+	summary.RiskFlags = []string{}
+	for _, flag := range riskFlags {
+		for _, raised := range flags {
+			if strings.EqualFold(strings.ReplaceAll(strings.TrimSpace(raised), " ", "_"), flag) {
+				summary.RiskFlags = append(summary.RiskFlags, flag)
+				break
+			}
+		}
+	}
+	if summary.Topics == nil {
+		summary.Topics = []string{}
+	}
+	if summary.FollowUps == nil {
+		summary.FollowUps = []string{}
+	}
+	return &summary, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// summaryGenerator is a ResponseGenerator answering every request with the same text
+type summaryGenerator struct {
+	response string
+	err      error
+	prompt   string
+	history  []string
+}
+
+func (s *summaryGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	s.prompt, s.history = userMessage, conversationHistory
+	reportModel(ctx, "summary-model")
+	return s.response, s.err
+}
+
+func TestCallSummarizerStoresTheSummary(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("test-call")
+	conv.AddUserMessage("I lost my job and I've been drinking every night")
+	conv.AddTherapistMessage("That sounds really hard.")
+
+	generator := &summaryGenerator{response: "```json\n" + `{
+		"overview": "The caller lost their job and is drinking to cope.",
+		"topics": ["job loss", "drinking"],
+		"riskFlags": ["substance use", "made_up_flag"],
+		"followUps": ["Share SAMHSA resources"]
+	}` + "\n```"}
+	summary, err := NewCallSummarizer(generator).Summarize(context.Background(), conv)
+	if err != nil {
+		t.Fatalf("Failed to summarize: %v", err)
+	}
+
+	if !strings.Contains(generator.prompt, "User: I lost my job") || !strings.HasPrefix(generator.history[0], "Context: The phone conversation above has ended") {
+		t.Errorf("Expected the transcript with the summary instructions, got %q and %q", generator.prompt, generator.history)
+	}
+	if len(summary.RiskFlags) != 1 || summary.RiskFlags[0] != RiskSubstanceUse {
+		t.Errorf("Expected only the known risk flag, got %q", summary.RiskFlags)
+	}
+	if len(summary.Topics) != 2 || summary.Model != "summary-model" || summary.CreatedAt.IsZero() {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if conv.CallSummary() != summary {
+		t.Error("Expected the summary to be stored with the conversation")
+	}
+}
+
+func TestCallSummarizerSkipsAndFails(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("test-call")
+	conv.AddTherapistMessage("Hello, how are you feeling today?")
+	generator := &summaryGenerator{response: `{"overview": "Nothing."}`}
+
+	if summary, err := NewCallSummarizer(generator).Summarize(context.Background(), conv); summary != nil || err != nil || generator.prompt != "" {
+		t.Errorf("Expected a call where the caller said nothing to be skipped, got %+v, %v", summary, err)
+	}
+
+	conv.AddUserMessage("hi")
+	for _, generator := range []*summaryGenerator{
+		{err: errors.New("quota exceeded")},
+		{response: "The caller said hi."},
+		{response: `{"topics": ["greeting"]}`},
+	} {
+		if _, err := NewCallSummarizer(generator).Summarize(context.Background(), conv); err == nil {
+			t.Errorf("Expected an error for %+v", generator)
+		}
+	}
+	if conv.CallSummary() != nil {
+		t.Error("Expected no summary stored after failures")
+	}
+
+	var none *CallSummarizer
+	if summary, err := none.Summarize(context.Background(), conv); summary != nil || err != nil {
+		t.Error("Expected a nil summarizer to do nothing")
+	}
+}
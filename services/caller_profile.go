@@ -0,0 +1,222 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CallerProfile is what is known about a caller across their calls: the calls themselves,
+// the choices they made and the risks flagged on them. Profiles are keyed by
+// HashPhoneNumber, so the number itself is never kept, and outlive the conversations the
+// janitor evicts.
+type CallerProfile struct {
+	CallerHash  string
+	calls       []ProfileCall
+	preferences CallerPreferences
+	risks       []RiskEvent
+	moods       []CallMood // Oldest call first
+	lastSummary string     // Overview of the latest summarized call
+	mu          sync.Mutex
+}
+
+// ProfileCall is one of a caller's calls
+type ProfileCall struct {
+	CallSID   string    `json:"callSid"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// CallerPreferences are the choices a caller made, carried into their next calls
+type CallerPreferences struct {
+	Persona      string  `json:"persona,omitempty"`
+	Voice        string  `json:"voice,omitempty"`
+	Language     string  `json:"language,omitempty"`     // Language they were detected speaking
+	SpeakingRate float64 `json:"speakingRate,omitempty"` // Factor on the configured rate, 0 for unchanged
+}
+
+// RiskEvent is a risk flagged on one of the caller's calls
+type RiskEvent struct {
+	CallSID   string    `json:"callSid"`
+	Flag      string    `json:"flag"`
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+// CallerProfileSnapshot is a copy of a profile that can be shared, encoded and stored
+type CallerProfileSnapshot struct {
+	CallerHash  string            `json:"callerHash"`
+	Calls       []ProfileCall     `json:"calls"`
+	Preferences CallerPreferences `json:"preferences"`
+	RiskHistory []RiskEvent       `json:"riskHistory"`
+	Moods       []CallMood        `json:"moods"`
+	LastSummary string            `json:"lastSummary,omitempty"`
+}
+
+// RestoreCallerProfile rebuilds a profile from a snapshot, e.g. one loaded from a store
+func RestoreCallerProfile(snapshot CallerProfileSnapshot) *CallerProfile {
+	return &CallerProfile{
+		CallerHash:  snapshot.CallerHash,
+		calls:       snapshot.Calls,
+		preferences: snapshot.Preferences,
+		risks:       snapshot.RiskHistory,
+		moods:       snapshot.Moods,
+		lastSummary: snapshot.LastSummary,
+	}
+}
+
+// Snapshot returns a copy of the profile
+func (p *CallerProfile) Snapshot() CallerProfileSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return CallerProfileSnapshot{
+		CallerHash:  p.CallerHash,
+		Calls:       append([]ProfileCall{}, p.calls...),
+		Preferences: p.preferences,
+		RiskHistory: append([]RiskEvent{}, p.risks...),
+		Moods:       append([]CallMood{}, p.moods...),
+		LastSummary: p.lastSummary,
+	}
+}
+
+// Preferences returns the choices the caller made on earlier calls
+func (p *CallerProfile) Preferences() CallerPreferences {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.preferences
+}
+
+// RememberPreferences keeps the persona, voice, language and pace a call ended with for
+// the caller's next calls; what the call left unset keeps its earlier value
+func (p *CallerProfile) RememberPreferences(channels *ChannelData) {
+	persona, voice, language, rate := channels.Persona().Name, channels.Voice(), channels.Language(), channels.SpeakingRate()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if persona != "" {
+		p.preferences.Persona = persona
+	}
+	if voice != "" {
+		p.preferences.Voice = voice
+	}
+	if language != "" {
+		p.preferences.Language = language
+	}
+	if rate != 1 {
+		p.preferences.SpeakingRate = rate
+	}
+}
+
+// ApplyPreferences sets up a new call the way the caller left their last one, returning
+// the persona it restored. A persona already answering the call, e.g. for a dedicated
+// number, is kept, along with its own voice and pace.
+func (p *CallerProfile) ApplyPreferences(channels *ChannelData, personas *PersonaRegistry) (Persona, bool) {
+	prefs := p.Preferences()
+	persona, restored := Persona{}, false
+	if channels.Persona().Name == "" && prefs.Persona != "" {
+		persona, restored = personas.Lookup(prefs.Persona)
+		if restored {
+			channels.SetPersona(persona)
+		}
+	}
+	if prefs.Voice != "" && channels.Persona().Voice == "" {
+		channels.SetVoice(prefs.Voice)
+	}
+	if prefs.Language != "" {
+		channels.SetLanguage(prefs.Language)
+	}
+	if prefs.SpeakingRate > 0 && channels.Persona().SpeakingRate == 0 {
+		channels.SetSpeakingRate(prefs.SpeakingRate)
+	}
+	return persona, restored
+}
+
+// addCall records one of the caller's calls, once
+func (p *CallerProfile) addCall(callSID string, startedAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, call := range p.calls {
+		if call.CallSID == callSID {
+			return
+		}
+	}
+	p.calls = append(p.calls, ProfileCall{CallSID: callSID, StartedAt: startedAt})
+	slices.SortStableFunc(p.calls, func(a, b ProfileCall) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+}
+
+// addRisks records the risks flagged on a call, each once per call
+func (p *CallerProfile) addRisks(callSID string, flags ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, flag := range flags {
+		known := slices.ContainsFunc(p.risks, func(r RiskEvent) bool {
+			return r.CallSID == callSID && r.Flag == flag
+		})
+		if !known {
+			p.risks = append(p.risks, RiskEvent{CallSID: callSID, Flag: flag, FlaggedAt: time.Now()})
+		}
+	}
+}
+
+// setLastSummary keeps the overview of the caller's latest summarized call
+func (p *CallerProfile) setLastSummary(overview string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastSummary = overview
+}
+
+// PromptCaller summarizes the caller's calls before the one starting at the time
+func (p *CallerProfile) PromptCaller(callSID string, startedAt time.Time) PromptCaller {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var caller PromptCaller
+	for _, call := range p.calls {
+		if call.CallSID == callSID || !call.StartedAt.Before(startedAt) {
+			continue
+		}
+		caller.PreviousCalls++
+		caller.LastCall = call.StartedAt
+	}
+	for _, risk := range p.risks {
+		if risk.CallSID != callSID && !slices.Contains(caller.PastRisks, risk.Flag) {
+			caller.PastRisks = append(caller.PastRisks, risk.Flag)
+		}
+	}
+	caller.LastMood = p.lastMood(callSID, startedAt)
+	return caller
+}
+
+// PromptContext describes the caller's earlier calls for the LLM, so a returning caller
+// doesn't have to start over; it is empty on a first call
+func (p *CallerProfile) PromptContext(callSID string, startedAt time.Time) string {
+	caller := p.PromptCaller(callSID, startedAt)
+	if !caller.Returning() {
+		return ""
+	}
+
+	p.mu.Lock()
+	lastSummary := p.lastSummary
+	p.mu.Unlock()
+
+	context := fmt.Sprintf("The caller has called %d time(s) before, most recently on %s.", caller.PreviousCalls, caller.LastCall.Format("January 2"))
+	if lastSummary != "" {
+		context += " Summary of their last call: " + lastSummary
+	}
+	if mood := caller.LastMood; mood != nil {
+		context += fmt.Sprintf(" On their last call on %s they sounded %s (sentiment %.2f from -1 to 1).",
+			mood.StartedAt.Format("January 2"), emotionDescription(mood.Emotion, mood.Score), mood.Score)
+	}
+	if len(caller.PastRisks) > 0 {
+		context += fmt.Sprintf(" Risks flagged on earlier calls: %s; check in gently on how they are doing.", strings.Join(caller.PastRisks, ", "))
+	}
+	return context
+}
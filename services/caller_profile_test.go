@@ -0,0 +1,68 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallerProfileLinksCalls(t *testing.T) {
+	service := NewConversationService()
+	first := service.GetOrCreateConversation("first")
+	first.CreatedAt = time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	profile := service.AttachCaller(first, "hash")
+	first.FlagRisk(RiskSubstanceUse)
+	first.SetCallSummary(&CallSummary{Overview: "Talked about drinking to sleep.", RiskFlags: []string{RiskSubstanceUse, RiskSelfHarm}})
+
+	second := service.GetOrCreateConversation("second")
+	if again := service.AttachCaller(second, "hash"); again != profile {
+		t.Fatal("Expected the caller's calls to share a profile")
+	}
+
+	caller := service.PromptCaller(second)
+	if caller.PreviousCalls != 1 || !caller.LastCall.Equal(first.CreatedAt) || !reflect.DeepEqual(caller.PastRisks, []string{RiskSubstanceUse, RiskSelfHarm}) {
+		t.Errorf("Unexpected caller: %+v", caller)
+	}
+	note := profile.PromptContext(second.ID, second.CreatedAt)
+	for _, want := range []string{"called 1 time(s) before, most recently on March 1", "Talked about drinking to sleep.", "substance_use, self_harm"} {
+		if !strings.Contains(note, want) {
+			t.Errorf("Expected %q in the context, got %q", want, note)
+		}
+	}
+	if note := profile.PromptContext(first.ID, first.CreatedAt); note != "" {
+		t.Errorf("Expected no context on a first call, got %q", note)
+	}
+
+	snapshot := profile.Snapshot()
+	if len(snapshot.Calls) != 2 || snapshot.Calls[0].CallSID != "first" || len(snapshot.RiskHistory) != 2 {
+		t.Errorf("Unexpected profile: %+v", snapshot)
+	}
+}
+
+func TestCallerProfileCarriesPreferences(t *testing.T) {
+	personas := &PersonaRegistry{personas: []Persona{{Name: "calm"}, {Name: "direct", Voice: "en-US-Neural2-D"}}}
+	profile := &CallerProfile{CallerHash: "hash"}
+
+	ended := NewChannelManager().CreateChannels("first")
+	ended.SetPersona(personas.All()[0])
+	ended.SetVoice("en-US-Neural2-F")
+	ended.SetLanguage("es-US")
+	ended.SetSpeakingRate(0.85)
+	profile.RememberPreferences(ended)
+
+	next := NewChannelManager().CreateChannels("second")
+	if persona, ok := profile.ApplyPreferences(next, personas); !ok || persona.Name != "calm" {
+		t.Errorf("Expected the calm persona restored, got %+v", persona)
+	}
+	if next.Voice() != "en-US-Neural2-F" || next.Language() != "es-US" || next.SpeakingRate() != 0.85 {
+		t.Errorf("Expected the voice, language and pace restored, got %q %q %v", next.Voice(), next.Language(), next.SpeakingRate())
+	}
+
+	// A dedicated number's persona keeps its own voice
+	dedicated := NewChannelManager().CreateChannels("third")
+	dedicated.SetPersona(personas.All()[1])
+	if _, ok := profile.ApplyPreferences(dedicated, personas); ok || dedicated.Persona().Name != "direct" || dedicated.Voice() != "en-US-Neural2-D" {
+		t.Errorf("Expected the dedicated persona and its voice kept, got %q %q", dedicated.Persona().Name, dedicated.Voice())
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// CallerWithheld is the list entry matching callers who withhold their number
+const CallerWithheld = "withheld"
+
+// withheldNumbers are what Twilio sends as From for callers without a number, spelled on a
+// keypad: anonymous, unknown, unavailable, blocked and restricted
+var withheldNumbers = map[string]bool{
+	"+266696687":   true,
+	"+8656696":     true,
+	"+86282452253": true,
+	"+2562533":     true,
+	"+7378742833":  true,
+}
+
+// WithheldNumber reports whether a call's From is no number at all
+func WithheldNumber(from string) bool {
+	return !strings.HasPrefix(from, "+") || withheldNumbers[from]
+}
+
+// callerHashPattern matches a HashPhoneNumber hash, as the API shows callers
+var callerHashPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// Why a caller was screened out
+const (
+	ScreenDenied     = "denylist"
+	ScreenNotAllowed = "not on the allowlist"
+)
+
+// callerList is numbers, caller hashes and patterns of numbers, e.g. +1900*
+type callerList []string
+
+// parseCallerList normalizes the entries and checks the patterns
+func parseCallerList(entries []string) (callerList, error) {
+	var list callerList
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		raw := entry
+		switch {
+		case entry == CallerWithheld || callerHashPattern.MatchString(entry):
+		case strings.ContainsAny(entry, "*?["):
+			if _, err := path.Match(entry, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", entry, err)
+			}
+		default:
+			if entry = normalizePhoneNumber(entry); entry == "" {
+				return nil, fmt.Errorf("%q is not a number, caller hash or pattern", raw)
+			}
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// matches reports whether the caller is on the list
+func (l callerList) matches(from string) bool {
+	withheld := WithheldNumber(from)
+	number := normalizePhoneNumber(from)
+	hash := HashPhoneNumber(from)
+	for _, entry := range l {
+		switch {
+		case entry == CallerWithheld:
+			if withheld {
+				return true
+			}
+		case withheld:
+		case entry == number || entry == hash:
+			return true
+		default:
+			if ok, _ := path.Match(entry, number); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CallerScreen decides which numbers may call. A number on the denylist is refused; with an
+// allowlist, so is every number not on it. Entries are numbers, caller hashes as the API
+// shows them, patterns such as +1900* and "withheld" for callers without a number.
+type CallerScreen struct {
+	mu    sync.RWMutex
+	allow callerList
+	deny  callerList
+}
+
+// NewCallerScreen creates a screen of the lists, letting everyone through when both are empty
+func NewCallerScreen(allow, deny []string) (*CallerScreen, error) {
+	allowList, err := parseCallerList(allow)
+	if err != nil {
+		return nil, fmt.Errorf("caller allowlist: %w", err)
+	}
+	denyList, err := parseCallerList(deny)
+	if err != nil {
+		return nil, fmt.Errorf("caller denylist: %w", err)
+	}
+	return &CallerScreen{allow: allowList, deny: denyList}, nil
+}
+
+// Screen reports whether the caller may call, and why not when they may not
+func (s *CallerScreen) Screen(from string) (bool, string) {
+	if s == nil {
+		return true, ""
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.deny.matches(from) {
+		return false, ScreenDenied
+	}
+	if len(s.allow) > 0 && !s.allow.matches(from) {
+		return false, ScreenNotAllowed
+	}
+	return true, ""
+}
+
+// Set replaces the lists with another screen's
+func (s *CallerScreen) Set(other *CallerScreen) {
+	other.mu.RLock()
+	allow, deny := other.allow, other.deny
+	other.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allow, s.deny = allow, deny
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestCallerScreenDenylistAndPatterns(t *testing.T) {
+	screen, err := NewCallerScreen(nil, []string{"+1 (555) 010-0001", "+1900*", HashPhoneNumber("+15550100002"), CallerWithheld})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for from, want := range map[string]bool{
+		"+15550100001": false, // Listed with formatting
+		"+19005550123": false, // Matching the pattern
+		"+15550100002": false, // Listed by its hash
+		"+266696687":   false, // Anonymous
+		"+15550100003": true,
+	} {
+		if ok, reason := screen.Screen(from); ok != want || (!ok && reason != ScreenDenied) {
+			t.Errorf("Screen(%s): expected %t, got %t (%s)", from, want, ok, reason)
+		}
+	}
+}
+
+func TestCallerScreenAllowlist(t *testing.T) {
+	screen, err := NewCallerScreen([]string{"+1555*"}, []string{"+15550100001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := screen.Screen("+15550100002"); !ok {
+		t.Error("Expected a number matching the allowlist through")
+	}
+	if ok, reason := screen.Screen("+15550100001"); ok || reason != ScreenDenied {
+		t.Errorf("Expected the denylist to win over the allowlist, got %t (%s)", ok, reason)
+	}
+	if ok, reason := screen.Screen("+442071234567"); ok || reason != ScreenNotAllowed {
+		t.Errorf("Expected a number off the allowlist refused, got %t (%s)", ok, reason)
+	}
+	if ok, _ := screen.Screen("anonymous"); ok {
+		t.Error("Expected withheld numbers refused unless allowed")
+	}
+
+	screen.Set(&CallerScreen{})
+	if ok, _ := screen.Screen("+442071234567"); !ok {
+		t.Error("Expected everyone through once the lists are emptied")
+	}
+}
+
+func TestCallerScreenRejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{"+1900[", "not a number"} {
+		if _, err := NewCallerScreen(nil, []string{entry}); err == nil {
+			t.Errorf("Expected %q rejected", entry)
+		}
+	}
+	if ok, _ := (*CallerScreen)(nil).Screen("+15550100001"); !ok {
+		t.Error("Expected no screen to let everyone through")
+	}
+}
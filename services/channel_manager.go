@@ -3,39 +3,256 @@ package services
 import (
 	"context"
 	"errors"
+	"io"
 	"sync"
 	"time"
 
-	"cloud.google.com/go/speech/apiv1/speechpb"
+	"github.com/ghophp/call-me-help/config"
 	"github.com/ghophp/call-me-help/logger"
 )
 
 // ChannelData holds the channels for a specific call
 type ChannelData struct {
 	CallSID              string
+	CorrelationID        string // Traces the call through the logs and the requests made for it
+	CallerNumber         string
 	CreatedAt            time.Time
 	AudioInputChan       chan []byte
-	TranscriptionChan    chan string
+	TranscriptionChan    chan Transcript
 	ResponseTextChan     chan string
 	ResponseAudioChan    chan []byte
+	audioFormat          AudioFormat
+	audioFormatMutex     sync.Mutex
+	jitterBuffer         *JitterBuffer
+	diagnostics          *AudioDiagnostics
 	isProcessingAudio    bool
 	processingAudioMutex sync.Mutex
+	recordingConsent     bool
+	recorder             io.WriteCloser // Inbound audio recording, only with the caller's consent
+	recordingMutex       sync.Mutex
+	voice                string  // Voice the caller chose, empty for the configured voice
+	speakingRate         float64 // Factor on the configured speaking rate, 0 for unchanged
+	persona              Persona // Therapist the caller talks to, the zero value for the default
+	language             string  // Language the caller was detected speaking, empty until then
+	voiceMutex           sync.Mutex
+	counters             pipelineCounters // Turn state, audio sent and drops, for the call's stats
 }
 
+// Speaking rate limits for callers asking us to slow down
+const (
+	slowDownStep    = 0.85
+	minSpeakingRate = 0.6
+)
+
+// SetVoice selects the voice responses on this call are spoken in
+func (cd *ChannelData) SetVoice(voice string) {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	cd.voice = voice
+}
+
+// Voice returns the voice chosen for this call, or "" for the configured voice
+func (cd *ChannelData) Voice() string {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	return cd.voice
+}
+
+// SetPersona selects the therapist the caller talks to, switching to its voice and pace
+// when it has them
+func (cd *ChannelData) SetPersona(persona Persona) {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	cd.persona = persona
+	if persona.Voice != "" {
+		cd.voice = persona.Voice
+	}
+	if persona.SpeakingRate > 0 {
+		cd.speakingRate = persona.SpeakingRate
+	}
+}
+
+// Persona returns the therapist the caller talks to, the zero value for the default one
+func (cd *ChannelData) Persona() Persona {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	return cd.persona
+}
+
+// SetLanguage records the language the caller was detected speaking
+func (cd *ChannelData) SetLanguage(language string) {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	cd.language = language
+}
+
+// Language returns the language the caller was detected speaking, or "" when none was
+func (cd *ChannelData) Language() string {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	return cd.language
+}
+
+// SetSpeakingRate sets the factor applied to the configured speaking rate on this call
+func (cd *ChannelData) SetSpeakingRate(factor float64) {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	cd.speakingRate = factor
+}
+
+// SpeakingRate returns the factor applied to the configured speaking rate, 1 when unchanged
+func (cd *ChannelData) SpeakingRate() float64 {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	if cd.speakingRate <= 0 {
+		return 1
+	}
+	return cd.speakingRate
+}
+
+// SlowDown lowers the call's speaking rate a step, down to a floor, and returns the new factor
+func (cd *ChannelData) SlowDown() float64 {
+	cd.voiceMutex.Lock()
+	defer cd.voiceMutex.Unlock()
+	if cd.speakingRate <= 0 {
+		cd.speakingRate = 1
+	}
+	if cd.speakingRate = cd.speakingRate * slowDownStep; cd.speakingRate < minSpeakingRate {
+		cd.speakingRate = minSpeakingRate
+	}
+	return cd.speakingRate
+}
+
+// SetRecordingConsent records whether the caller agreed to have their audio recorded
+func (cd *ChannelData) SetRecordingConsent(consent bool) {
+	cd.recordingMutex.Lock()
+	defer cd.recordingMutex.Unlock()
+	cd.recordingConsent = consent
+}
+
+// RecordingConsent reports whether the caller agreed to have their audio recorded
+func (cd *ChannelData) RecordingConsent() bool {
+	cd.recordingMutex.Lock()
+	defer cd.recordingMutex.Unlock()
+	return cd.recordingConsent
+}
+
+// SetRecorder starts copying the caller's inbound audio to w; it is ignored without consent
+func (cd *ChannelData) SetRecorder(w io.WriteCloser) bool {
+	cd.recordingMutex.Lock()
+	defer cd.recordingMutex.Unlock()
+	if !cd.recordingConsent {
+		w.Close()
+		return false
+	}
+	cd.recorder = w
+	return true
+}
+
+// record appends inbound audio to the recording, if there is one
+func (cd *ChannelData) record(log *logger.Logger, payload []byte) {
+	cd.recordingMutex.Lock()
+	defer cd.recordingMutex.Unlock()
+	if cd.recorder == nil {
+		return
+	}
+	if _, err := cd.recorder.Write(payload); err != nil {
+		log.Error("Error recording inbound audio for call %s, stopping the recording: %v", cd.CallSID, err)
+		cd.recorder.Close()
+		cd.recorder = nil
+	}
+}
+
+// closeRecording finishes the inbound audio recording
+func (cd *ChannelData) closeRecording(log *logger.Logger) {
+	cd.recordingMutex.Lock()
+	defer cd.recordingMutex.Unlock()
+	if cd.recorder == nil {
+		return
+	}
+	if err := cd.recorder.Close(); err != nil {
+		log.Error("Error closing inbound audio recording for call %s: %v", cd.CallSID, err)
+	}
+	cd.recorder = nil
+}
+
+// SetAudioFormat records the media format negotiated for the call
+func (cd *ChannelData) SetAudioFormat(format AudioFormat) {
+	cd.audioFormatMutex.Lock()
+	defer cd.audioFormatMutex.Unlock()
+	cd.audioFormat = format.Normalize()
+	cd.diagnostics = NewAudioDiagnostics(cd.CallSID, cd.audioFormat)
+}
+
+// AudioDiagnostics returns the inbound audio diagnostics for the negotiated format
+func (cd *ChannelData) AudioDiagnostics() *AudioDiagnostics {
+	cd.audioFormatMutex.Lock()
+	defer cd.audioFormatMutex.Unlock()
+	return cd.diagnostics
+}
+
+// GetAudioFormat returns the media format negotiated for the call
+func (cd *ChannelData) GetAudioFormat() AudioFormat {
+	cd.audioFormatMutex.Lock()
+	defer cd.audioFormatMutex.Unlock()
+	return cd.audioFormat
+}
+
+// Jitter buffer tuning for inbound media: Twilio sends 20ms frames
+const (
+	jitterMinDepth     = 40 * time.Millisecond
+	jitterMaxDepth     = 200 * time.Millisecond
+	jitterPollInterval = 20 * time.Millisecond
+)
+
 // ChannelManager manages communication channels for active calls
 type ChannelManager struct {
 	channels map[string]*ChannelData
 	mu       sync.Mutex
-	log      *logger.Logger
+	vad      VADConfig
+	dsp      PreprocessConfig
+	// keepalive is how long the recognizer may go without audio before a silence frame is sent
+	keepalive time.Duration
+	log       *logger.Logger
+	// Errors, when set, reports panics of the goroutines feeding the recognizer, which
+	// are recovered either way
+	Errors *ErrorReporter
 }
 
 // NewChannelManager creates a new channel manager
 func NewChannelManager() *ChannelManager {
 	log := logger.Component("ChannelManager")
 	log.Info("Creating new ChannelManager")
+
+	cfg := config.Load()
+	vad := VADConfig{
+		Enabled:   cfg.VADEnabled,
+		Threshold: cfg.VADThreshold,
+		Hangover:  time.Duration(cfg.VADHangoverMs) * time.Millisecond,
+		PreRoll:   time.Duration(cfg.VADPreRollMs) * time.Millisecond,
+	}
+	if vad.Enabled {
+		log.Info("Voice activity detection enabled (threshold %.0f, hangover %v, pre-roll %v)",
+			vad.Threshold, vad.Hangover, vad.PreRoll)
+	}
+
+	dsp := PreprocessConfig{
+		Enabled:       cfg.AudioPreprocess,
+		TargetLevel:   cfg.AudioTargetLevel,
+		MaxGain:       cfg.AudioMaxGain,
+		GateThreshold: cfg.AudioNoiseGate,
+	}
+	if dsp.Enabled {
+		log.Info("Audio preprocessing enabled (target level %.0f, max gain %.1f, noise gate %.0f)",
+			dsp.TargetLevel, dsp.MaxGain, dsp.GateThreshold)
+	}
+
 	return &ChannelManager{
-		channels: make(map[string]*ChannelData),
-		log:      log,
+		channels:  make(map[string]*ChannelData),
+		vad:       vad,
+		dsp:       dsp,
+		keepalive: time.Duration(cfg.STTKeepaliveMs) * time.Millisecond,
+		log:       log,
 	}
 }
 
@@ -47,11 +264,15 @@ func (cm *ChannelManager) CreateChannels(callSID string) *ChannelData {
 	cm.log.Info("Creating channels for call %s", callSID)
 	channels := &ChannelData{
 		CallSID:           callSID,
+		CorrelationID:     NewCorrelationID(),
 		CreatedAt:         time.Now(),
 		AudioInputChan:    make(chan []byte, 1024),
-		TranscriptionChan: make(chan string, 1024),
+		TranscriptionChan: make(chan Transcript, 1024),
 		ResponseTextChan:  make(chan string, 1024),
-		ResponseAudioChan: make(chan []byte),
+		ResponseAudioChan: make(chan []byte, 64), // Room for a response's sentences while earlier ones play
+		audioFormat:       DefaultAudioFormat(),
+		jitterBuffer:      NewJitterBuffer(jitterMinDepth, jitterMaxDepth),
+		diagnostics:       NewAudioDiagnostics(callSID, DefaultAudioFormat()),
 	}
 
 	cm.channels[callSID] = channels
@@ -83,6 +304,28 @@ func (cm *ChannelManager) RemoveChannels(callSID string) {
 	cm.log.Info("Removed channels for call %s", callSID)
 }
 
+// Count returns how many calls have channels
+func (cm *ChannelManager) Count() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return len(cm.channels)
+}
+
+// CreatedBefore returns the calls whose channels were created before the cutoff
+func (cm *ChannelManager) CreatedBefore(cutoff time.Time) []string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var callSIDs []string
+	for sid, channels := range cm.channels {
+		if channels.CreatedAt.Before(cutoff) {
+			callSIDs = append(callSIDs, sid)
+		}
+	}
+	return callSIDs
+}
+
 // GetMostRecentCallSID returns the SID of the most recently created call
 func (cm *ChannelManager) GetMostRecentCallSID() string {
 	cm.mu.Lock()
@@ -108,66 +351,177 @@ func (cm *ChannelManager) GetMostRecentCallSID() string {
 }
 
 // StartAudioProcessing starts processing audio through speech-to-text
-func (cm *ChannelManager) StartAudioProcessing(ctx context.Context, callSID string, stt *SpeechToTextService) (speechpb.Speech_StreamingRecognizeClient, error) {
-	cm.log.Info("Starting audio processing for call %s", callSID)
+func (cm *ChannelManager) StartAudioProcessing(ctx context.Context, callSID string, stt SpeechRecognizer) (RecognitionStream, error) {
+	log := CallLogger(ctx, cm.log)
+	log.Info("Starting audio processing for call %s", callSID)
 	channels, ok := cm.GetChannels(callSID)
 	if !ok {
-		cm.log.Error("No channels found for call %s, cannot start audio processing", callSID)
+		log.Error("No channels found for call %s, cannot start audio processing", callSID)
 		return nil, errors.New("no channels found for call")
 	}
 
 	// Set processing flag to avoid multiple processors for same call
 	channels.processingAudioMutex.Lock()
 	if channels.isProcessingAudio {
-		cm.log.Warn("Audio processing already in progress for call %s", callSID)
+		log.Warn("Audio processing already in progress for call %s", callSID)
 		channels.processingAudioMutex.Unlock()
 		return nil, errors.New("audio processing already in progress")
 	}
 	channels.isProcessingAudio = true
 	channels.processingAudioMutex.Unlock()
-	cm.log.Debug("Audio processing flag set for call %s", callSID)
+	log.Debug("Audio processing flag set for call %s", callSID)
 
 	// Create a pipe for streaming the audio data
-	cm.log.Debug("Creating pipe for audio streaming for call %s", callSID)
+	log.Debug("Creating pipe for audio streaming for call %s", callSID)
 
 	// Start streaming recognition
-	cm.log.Info("Initiating Speech-to-Text streaming for call %s", callSID)
-	transcriptionChan, stream, err := stt.StreamingRecognize(ctx)
+	log.Info("Initiating Speech-to-Text streaming for call %s", callSID)
+	ctx = WithRecognitionHints(ctx, func() (string, string) {
+		return channels.Language(), channels.Persona().Name
+	})
+	stream, err := stt.StartStream(ctx, channels.GetAudioFormat())
 	if err != nil {
-		cm.log.Error("Error starting streaming recognition for call %s: %v", callSID, err)
+		log.Error("Error starting streaming recognition for call %s: %v", callSID, err)
 		return nil, err
 	}
-	cm.log.Info("Speech-to-Text streaming started for call %s", callSID)
+	log.Info("Speech-to-Text streaming started for call %s", callSID)
+
+	// Feed inbound audio from the jitter buffer to the recognizer
+	go cm.forwardAudio(ctx, channels, stream)
 
 	// Forward transcriptions to the transcription channel
 	go func() {
-		cm.log.Debug("Starting transcription forwarding goroutine for call %s", callSID)
-		defer cm.log.Debug("Transcription forwarding goroutine ended for call %s", callSID)
+		defer cm.Errors.Recover(ctx)
+		log.Debug("Starting transcription forwarding goroutine for call %s", callSID)
+		defer log.Debug("Transcription forwarding goroutine ended for call %s", callSID)
 
 		transcriptionCount := 0
-		for transcription := range transcriptionChan {
+		for transcript := range stream.Transcripts() {
+			transcription := transcript.Text
 			transcriptionCount++
-			cm.log.Debug("Received transcription #%d from Google STT for call %s: %s",
-				transcriptionCount, callSID, transcription)
+			log.Debug("Received transcription #%d from STT for call %s (final=%t, endOfSpeech=%t): %s",
+				transcriptionCount, callSID, transcript.IsFinal, transcript.EndOfSpeech, logger.Transcript(transcription))
 
 			select {
-			case channels.TranscriptionChan <- transcription:
-				cm.log.Debug("Forwarded transcription #%d to channel for call %s",
+			case channels.TranscriptionChan <- transcript:
+				log.Debug("Forwarded transcription #%d to channel for call %s",
 					transcriptionCount, callSID)
 			default:
-				cm.log.Warn("TranscriptionChan full for call %s, dropping transcription: %s",
-					callSID, transcription)
+				channels.counters.droppedTranscripts.Add(1)
+				log.Warn("TranscriptionChan full for call %s, dropping transcription: %s",
+					callSID, logger.Transcript(transcription))
 			}
 		}
 
-		cm.log.Info("Transcription channel closed after %d transcriptions for call %s",
+		log.Info("Transcription channel closed after %d transcriptions for call %s",
 			transcriptionCount, callSID)
 	}()
 
-	cm.log.Info("Audio processing successfully started for call %s", callSID)
+	log.Info("Audio processing successfully started for call %s", callSID)
 	return stream, nil
 }
 
+// forwardAudio drains the call's jitter buffer in timestamp order into the STT stream
+func (cm *ChannelManager) forwardAudio(ctx context.Context, channels *ChannelData, stream RecognitionStream) {
+	defer cm.Errors.Recover(ctx)
+	log := CallLogger(ctx, cm.log)
+	log.Debug("Starting audio forwarding goroutine for call %s", channels.CallSID)
+	defer log.Debug("Audio forwarding goroutine ended for call %s", channels.CallSID)
+
+	ticker := time.NewTicker(jitterPollInterval)
+	defer ticker.Stop()
+
+	// Clean up the caller's audio before it is gated and recognized
+	var dsp *AudioPreprocessor
+	if cm.dsp.Enabled {
+		dsp = NewAudioPreprocessor(cm.dsp, channels.GetAudioFormat())
+	}
+
+	// Only speech segments are streamed when voice activity detection is on
+	var vad *VoiceActivityDetector
+	if cm.vad.Enabled {
+		vad = NewVoiceActivityDetector(cm.vad, channels.GetAudioFormat())
+	}
+
+	// Google ends streams that receive no audio for a while, so quiet stretches get silence frames
+	format := channels.GetAudioFormat()
+	silence := EncodeSamples(make([]int16, format.SampleRate*int(jitterPollInterval/time.Millisecond)/1000), format)
+	lastSent := time.Now()
+	keepalives := 0
+
+	sendPayload := func(payload []byte) {
+		lastSent = time.Now()
+		if err := stream.SendAudio(payload); err != nil {
+			log.Error("Error sending audio to speech recognition for call %s: %v", channels.CallSID, err)
+		}
+	}
+	send := func(frames []MediaFrame) {
+		for _, frame := range frames {
+			// The recording keeps the original audio, to see what the caller actually sounded like
+			channels.record(log, frame.Payload)
+			payload := frame.Payload
+			if dsp != nil {
+				payload = dsp.Process(payload)
+			}
+			if vad == nil {
+				sendPayload(payload)
+				continue
+			}
+			wasSpeaking := vad.Speaking()
+			for _, payload := range vad.Process(payload) {
+				sendPayload(payload)
+			}
+			if speaking := vad.Speaking(); speaking != wasSpeaking {
+				log.Debug("Voice activity for call %s: speaking=%t", channels.CallSID, speaking)
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			send(channels.jitterBuffer.Flush())
+			stream.Close()
+			channels.closeRecording(log)
+			if keepalives > 0 {
+				log.Info("Sent %d keepalive silence frames to speech recognition for call %s", keepalives, channels.CallSID)
+			}
+			diag := channels.AudioDiagnostics().Snapshot()
+			log.Info("Audio forwarding stopped for call %s, %d late frames dropped, %d frames inspected, issues: %v",
+				channels.CallSID, channels.jitterBuffer.DroppedFrames(), diag.Frames, diag.Issues)
+			if vad != nil {
+				passed, dropped := vad.Stats()
+				log.Info("Voice activity detection for call %s streamed %v of audio and skipped %v of silence",
+					channels.CallSID, passed.Round(time.Millisecond), dropped.Round(time.Millisecond))
+			}
+			return
+		case <-ticker.C:
+			send(channels.jitterBuffer.Ready())
+			if cm.keepalive > 0 && time.Since(lastSent) >= cm.keepalive {
+				keepalives++
+				sendPayload(silence)
+			}
+		}
+	}
+}
+
+// AppendMediaFrame queues an inbound media frame for in-order delivery to speech recognition
+func (cd *ChannelData) AppendMediaFrame(log *logger.Logger, frame MediaFrame) {
+	if len(frame.Payload) == 0 {
+		log.Debug("Skipping empty media frame for call %s", cd.CallSID)
+		return
+	}
+
+	// Flag audio that doesn't look like the negotiated format; otherwise it just transcribes as nothing
+	if err := cd.AudioDiagnostics().Inspect(frame.Payload); err != nil {
+		log.Error("%v", err)
+	}
+
+	if !cd.jitterBuffer.Push(frame) {
+		log.Debug("Dropping late media frame %d (ts %d) for call %s", frame.Chunk, frame.Timestamp, cd.CallSID)
+	}
+}
+
 // AppendAudioData adds audio data to the buffer and input channel
 func (cd *ChannelData) AppendAudioData(log *logger.Logger, data []byte) {
 	cd.processingAudioMutex.Lock()
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStream is a RecognitionStream that keeps the audio sent to it
+type recordingStream struct {
+	mu          sync.Mutex
+	chunks      [][]byte
+	transcripts chan Transcript
+}
+
+func (s *recordingStream) SendAudio(audio []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks = append(s.chunks, audio)
+	return nil
+}
+
+func (s *recordingStream) Transcripts() <-chan Transcript { return s.transcripts }
+func (s *recordingStream) Close() error                   { return nil }
+
+func (s *recordingStream) sent() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.chunks...)
+}
+
+func TestForwardAudioSendsKeepaliveSilence(t *testing.T) {
+	cm := NewChannelManager()
+	cm.keepalive = 50 * time.Millisecond
+	channels := cm.CreateChannels("keepalive-call")
+	stream := &recordingStream{transcripts: make(chan Transcript)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cm.forwardAudio(ctx, channels, stream)
+		close(done)
+	}()
+	time.Sleep(220 * time.Millisecond)
+	cancel()
+	<-done
+
+	chunks := stream.sent()
+	if len(chunks) < 2 {
+		t.Fatalf("Expected keepalive frames while the caller was quiet, got %d", len(chunks))
+	}
+	for _, chunk := range chunks {
+		// 20ms of 8kHz μ-law silence
+		if len(chunk) != 160 || MulawDecode(chunk[0]) != 0 {
+			t.Fatalf("Expected 160 bytes of μ-law silence, got %d bytes starting %#x", len(chunk), chunk[0])
+		}
+	}
+}
+
+// bufferCloser is an in-memory recording
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestInboundAudioIsRecordedOnlyWithConsent(t *testing.T) {
+	cm := NewChannelManager()
+	cm.keepalive = 0
+
+	for _, consent := range []bool{false, true} {
+		channels := cm.CreateChannels("recorded-call")
+		channels.SetRecordingConsent(consent)
+		recording := &bufferCloser{}
+		if started := channels.SetRecorder(recording); started != consent {
+			t.Errorf("consent=%t: expected recording started=%t", consent, started)
+		}
+
+		stream := &recordingStream{transcripts: make(chan Transcript)}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			cm.forwardAudio(ctx, channels, stream)
+			close(done)
+		}()
+		channels.AppendMediaFrame(cm.log, MediaFrame{Chunk: 1, Timestamp: 0, Payload: []byte{1, 2, 3}})
+		channels.AppendMediaFrame(cm.log, MediaFrame{Chunk: 2, Timestamp: 20, Payload: []byte{4, 5}})
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		<-done
+
+		want := ""
+		if consent {
+			want = "\x01\x02\x03\x04\x05"
+		}
+		if got := recording.String(); got != want {
+			t.Errorf("consent=%t: expected recording %q, got %q", consent, want, got)
+		}
+		if !recording.closed {
+			t.Errorf("consent=%t: expected the recording to be closed", consent)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// anthropicVersion is the Messages API version requests are made against
+const anthropicVersion = "2023-06-01"
+
+// ClaudeService implements ResponseGenerator over the Anthropic Messages API
+type ClaudeService struct {
+	config  *config.Config
+	client  *http.Client
+	prompts *PromptStore
+	params  *GenerationSettings
+	log     *logger.Logger
+}
+
+// NewClaudeService creates a new Claude response generator whose system prompt is
+// rendered from the store's templates; a nil store uses the built-in prompt
+func NewClaudeService(cfg *config.Config, prompts *PromptStore, generation *GenerationSettings) (*ClaudeService, error) {
+	log := logger.Component("Claude")
+	log.Info("Creating new Claude service with model %s", cfg.AnthropicModel)
+
+	if cfg.AnthropicAPIKey == "" {
+		log.Error("ANTHROPIC_API_KEY environment variable not set")
+		return nil, errors.New("ANTHROPIC_API_KEY is required for the claude LLM provider")
+	}
+
+	return &ClaudeService{
+		config:  cfg,
+		client:  &http.Client{},
+		prompts: prompts,
+		params:  generation,
+		log:     log,
+	}, nil
+}
+
+// Close is a no-op; Messages API requests are stateless
+func (c *ClaudeService) Close() error {
+	c.log.Info("Closing Claude service")
+	return nil
+}
+
+// GenerateResponse generates a therapeutic response based on user input and conversation history
+func (c *ClaudeService) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	log := CallLogger(ctx, c.log)
+	startTime := time.Now()
+	log.Info("Generating response for message: %q", logger.Transcript(userMessage))
+
+	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := c.post(genCtx, userMessage, conversationHistory, false)
+	if err != nil {
+		log.Error("Claude API error after %v: %v", time.Since(startTime), err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var message struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		return "", err
+	}
+
+	var response strings.Builder
+	for _, block := range message.Content {
+		if block.Type == "text" {
+			response.WriteString(block.Text)
+		}
+	}
+	if message.StopReason == "max_tokens" {
+		log.Warn("Claude response was cut off at ANTHROPIC_MAX_TOKENS=%d", c.config.AnthropicMaxTokens)
+	}
+	log.Info("Claude response (%d chars, %v): %q", response.Len(), time.Since(startTime), logger.Transcript(response.String()))
+	return response.String(), nil
+}
+
+// GenerateResponseStream generates a response like GenerateResponse, streaming it from the
+// model and calling onText with each piece of text as it arrives
+func (c *ClaudeService) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	log := CallLogger(ctx, c.log)
+	startTime := time.Now()
+	log.Info("Streaming response for message: %q", logger.Transcript(userMessage))
+
+	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := c.post(genCtx, userMessage, conversationHistory, true)
+	if err != nil {
+		log.Error("Claude API error after %v: %v", time.Since(startTime), err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// The stream is server-sent events; text arrives in content_block_delta events and
+	// failures partway through arrive as an error event
+	var response strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var event struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return response.String(), fmt.Errorf("claude: bad stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "content_block_delta":
+			if event.Delta.Type != "text_delta" || event.Delta.Text == "" {
+				continue
+			}
+			if response.Len() == 0 {
+				log.Debug("First Claude chunk received in %v", time.Since(startTime))
+			}
+			response.WriteString(event.Delta.Text)
+			onText(event.Delta.Text)
+		case "error":
+			log.Error("Claude stream error after %v: %s: %s", time.Since(startTime), event.Error.Type, event.Error.Message)
+			return response.String(), fmt.Errorf("claude: %s: %s", event.Error.Type, event.Error.Message)
+		case "message_stop":
+			log.Info("Claude streamed response (%d chars, %v): %q", response.Len(), time.Since(startTime), logger.Transcript(response.String()))
+			return response.String(), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error("Claude streaming error after %v: %v", time.Since(startTime), err)
+		return response.String(), err
+	}
+	return response.String(), errors.New("claude: stream ended without message_stop")
+}
+
+// post sends the Messages API request and checks its status
+func (c *ClaudeService) post(ctx context.Context, userMessage string, conversationHistory []string, stream bool) (*http.Response, error) {
+	log := CallLogger(ctx, c.log)
+	system, messages := chatMessages(systemPrompt(ctx, c.prompts, c.config), userMessage, conversationHistory)
+	request := map[string]interface{}{
+		"model":      c.config.AnthropicModel,
+		"max_tokens": c.config.AnthropicMaxTokens,
+		"system":     system,
+		"messages":   alternateMessages(messages),
+		"stream":     stream,
+	}
+	params := c.params.For(ctx)
+	if params.Model != "" {
+		request["model"] = params.Model
+	}
+	reportModel(ctx, request["model"].(string))
+	reportPromptTokens(ctx, chatTokens(system, messages))
+	if params.MaxOutputTokens > 0 {
+		request["max_tokens"] = params.MaxOutputTokens
+	}
+	if params.Temperature != nil {
+		// Claude's temperature only goes up to 1
+		request["temperature"] = min(*params.Temperature, 1)
+	}
+	if params.TopP != nil {
+		request["top_p"] = *params.TopP
+	}
+	if params.TopK > 0 {
+		request["top_k"] = params.TopK
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Built request with %d conversation history messages", len(conversationHistory))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.AnthropicURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", c.config.AnthropicAPIKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Claude returned status %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("claude: unexpected status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// alternateMessages merges consecutive messages of the same role, since the Messages API
+// expects user and assistant turns to alternate
+func alternateMessages(messages []chatMessage) []chatMessage {
+	var merged []chatMessage
+	for _, msg := range messages {
+		if n := len(merged); n > 0 && merged[n-1].Role == msg.Role {
+			merged[n-1].Content += "\n" + msg.Content
+			continue
+		}
+		merged = append(merged, msg)
+	}
+	return merged
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+// claudeRequest is the part of a Messages API request the tests check
+type claudeRequest struct {
+	Model     string        `json:"model"`
+	MaxTokens int           `json:"max_tokens"`
+	System    string        `json:"system"`
+	Messages  []chatMessage `json:"messages"`
+	Stream    bool          `json:"stream"`
+}
+
+func newTestClaude(t *testing.T, url string) *ClaudeService {
+	t.Helper()
+	cfg := &config.Config{AnthropicAPIKey: "key", AnthropicModel: "claude-test", AnthropicMaxTokens: 300, AnthropicURL: url}
+	claude, err := NewClaudeService(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claude
+}
+
+func TestClaudeSendsSystemPromptAndMessages(t *testing.T) {
+	var request claudeRequest
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"content": [{"type": "text", "text": "That sounds "}, {"type": "text", "text": "hard."}], "stop_reason": "end_turn"}`))
+	}))
+	defer server.Close()
+
+	history := []string{"Context: The caller is a veteran.", "User: Hi", "Therapist: Hello, I'm here.", "User: I lost my job"}
+	response, err := newTestClaude(t, server.URL).GenerateResponse(context.Background(), "I lost my job", history)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if response != "That sounds hard." {
+		t.Errorf("Expected the text blocks joined, got %q", response)
+	}
+	if header.Get("X-Api-Key") != "key" || header.Get("Anthropic-Version") != anthropicVersion {
+		t.Errorf("Expected the API key and version headers, got %v", header)
+	}
+	if request.Model != "claude-test" || request.MaxTokens != 300 || request.Stream {
+		t.Errorf("Expected a non-streaming request for the model, got %+v", request)
+	}
+	if !strings.HasSuffix(request.System, "\nThe caller is a veteran.") {
+		t.Errorf("Expected the context in the system prompt, got %q", request.System)
+	}
+	want := []chatMessage{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello, I'm here."},
+		{Role: "user", Content: "I lost my job"},
+	}
+	if !reflect.DeepEqual(request.Messages, want) {
+		t.Errorf("Expected messages %+v, got %+v", want, request.Messages)
+	}
+}
+
+func TestClaudeStreamsResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			"event: message_start\ndata: {\"type\": \"message_start\"}",
+			"event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"I hear \"}}",
+			"event: ping\ndata: {\"type\": \"ping\"}",
+			"event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"you.\"}}",
+			"event: message_stop\ndata: {\"type\": \"message_stop\"}",
+		} {
+			w.Write([]byte(event + "\n\n"))
+		}
+	}))
+	defer server.Close()
+
+	var chunks []string
+	response, err := newTestClaude(t, server.URL).GenerateResponseStream(context.Background(), "I lost my job", nil, func(text string) {
+		chunks = append(chunks, text)
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream: %v", err)
+	}
+	if response != "I hear you." || !reflect.DeepEqual(chunks, []string{"I hear ", "you."}) {
+		t.Errorf("Expected the streamed pieces, got %q from %q", response, chunks)
+	}
+}
+
+func TestClaudeReportsStreamErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"I hear \"}}\n\n"))
+		w.Write([]byte("event: error\ndata: {\"type\": \"error\", \"error\": {\"type\": \"overloaded_error\", \"message\": \"Overloaded\"}}\n\n"))
+	}))
+	defer server.Close()
+
+	response, err := newTestClaude(t, server.URL).GenerateResponseStream(context.Background(), "hi", nil, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Errorf("Expected the stream's error, got %v", err)
+	}
+	if response != "I hear " {
+		t.Errorf("Expected the text before the error, got %q", response)
+	}
+}
+
+func TestAlternateMessagesMergesConsecutiveRoles(t *testing.T) {
+	got := alternateMessages([]chatMessage{
+		{Role: "user", Content: "Hi"},
+		{Role: "user", Content: "Are you there?"},
+		{Role: "assistant", Content: "Yes."},
+	})
+	want := []chatMessage{{Role: "user", Content: "Hi\nAre you there?"}, {Role: "assistant", Content: "Yes."}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestClaudeSendsTheCallCorrelationID(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Write([]byte(`{"content": [{"type": "text", "text": "I hear you."}], "stop_reason": "end_turn"}`))
+	}))
+	defer server.Close()
+
+	id := NewCorrelationID()
+	ctx := WithCorrelationID(WithCallSID(context.Background(), "CA123"), id)
+	if _, err := newTestClaude(t, server.URL).GenerateResponse(ctx, "Hi", nil); err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if len(id) != 16 || header.Get(CorrelationHeader) != id {
+		t.Errorf("Expected the correlation ID %q sent, got %q", id, header.Get(CorrelationHeader))
+	}
+}
@@ -2,10 +2,42 @@ package services
 
 // ServiceContainer holds all services used by the application
 type ServiceContainer struct {
-	SpeechToText   *SpeechToTextService
-	TextToSpeech   *TextToSpeechService
-	Gemini         *GeminiService
+	SpeechToText   SpeechRecognizer
+	TextToSpeech   TextToSpeechProvider
+	AudioStore     AudioStore
+	LLM            LLMProvider
+	Generator      ResponseGenerator  // LLM used for turns, throttled when configured
+	LLMThrottle    *LLMThrottle       // nil when LLM_RATE_LIMIT is unset
+	History        *HistoryBudget     // nil sends the whole history
+	Budget         *TokenBudget       // nil tracks LLM usage without ceilings
+	Tools          *ToolDispatcher    // nil when the LLM doesn't call tools
+	Guardrail      *ResponseGuardrail // nil speaks responses unchecked
+	Actions        *ActionDispatcher  // nil unless the LLM replies with speech and actions
+	CallSummarizer *CallSummarizer    // nil leaves ended calls unsummarized
+	SessionNotes   *SessionNoteWriter // nil writes no clinician notes on ended calls
+	Analytics      *CallAnalytics
 	Twilio         *TwilioService
 	Conversation   *ConversationService
 	ChannelManager *ChannelManager
+	Janitor        *CallJanitor    // nil keeps ended calls in memory
+	Transcripts    TranscriptStore // nil keeps no record of calls once evicted
+	Events         EventPublishers // Webhooks and Pub/Sub, empty when none is configured
+	CallExport     *CallExporter   // nil when calls aren't exported to BigQuery
+	Referrals      *ReferralService
+	Voicemail      *VoicemailService
+	Fallbacks      *FallbackLibrary
+	Masker         *TermMasker   // nil when no terms are masked
+	Translator     Translator    // nil unless translation mode is enabled
+	Voices         *VoiceCatalog // Voices callers can choose between and those of their languages
+	Reloader       *ConfigReloader
+	Errors         *ErrorReporter    // nil when errors aren't sent to an error tracker
+	Personas       *PersonaRegistry  // nil leaves every call with the default persona
+	Tenants        *TenantRegistry   // nil serves every call as the configured organization
+	Health         *HealthChecker    // nil leaves dependencies out of health checks
+	Synthetic      *SyntheticMonitor // nil when no synthetic turns are run
+	SLOs           *SLOTracker       // nil when no latency SLO is set
+	Audit          *AuditLog         // nil records no audit entries
+	RateLimits     RateLimits
+	Callers        *CallerScreen // Caller allow and deny lists
+	AudioURLs      *URLSigner    // Signs the download links of saved audio
 }
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sealedPrefix marks content sealed by a ContentCipher, so content written before
+// encryption was turned on can still be told apart and read
+var sealedPrefix = []byte("cmh-aesgcm-v1:")
+
+// sealedStreamPrefix marks content sealed by a ContentCipher in segments as it was written
+var sealedStreamPrefix = []byte("cmh-aesgcm-stream-v1:")
+
+// sealedSegmentSize is how much of a stream is buffered before it's sealed and written,
+// e.g. 8 seconds of call audio
+const sealedSegmentSize = 64 * 1024
+
+// ErrSealedStreamTruncated is returned, along with the content before it, when a sealed
+// stream ends without its last segment, e.g. after a crash while it was written
+var ErrSealedStreamTruncated = errors.New("encrypted stream is truncated")
+
+// ContentCipher encrypts content stored at rest with AES-GCM
+type ContentCipher struct {
+	aead cipher.AEAD
+}
+
+// NewContentCipher creates a cipher from a base64 AES key of 16, 24 or 32 bytes; it
+// returns nil, which stores content in the clear, when the key is empty
+func NewContentCipher(key string) (*ContentCipher, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64: %w", err)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &ContentCipher{aead: aead}, nil
+}
+
+// Seal encrypts the content under a random nonce. The associated data, e.g. the call SID,
+// isn't stored but must be given again to open it, so sealed content can't be swapped
+// between records.
+func (c *ContentCipher) Seal(content, associated []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, sealedPrefix...), nonce...)
+	return c.aead.Seal(sealed, nonce, content, associated), nil
+}
+
+// Open decrypts sealed content, whole or streamed; content that isn't sealed is returned as is
+func (c *ContentCipher) Open(data, associated []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, sealedStreamPrefix) {
+		return c.openStream(data[len(sealedStreamPrefix):], associated)
+	}
+	if !IsSealed(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, errors.New("content is encrypted and no encryption key is configured")
+	}
+	data = data[len(sealedPrefix):]
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted content is truncated")
+	}
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	content, err := c.aead.Open(nil, nonce, ciphertext, associated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt content, is the key the one it was encrypted with? %w", err)
+	}
+	return content, nil
+}
+
+// IsSealed reports whether the data was sealed by a ContentCipher
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedPrefix)
+}
+
+// segmentAssociated binds a stream segment to the stream's associated data and its place in
+// it, so segments can't be reordered, dropped or the stream cut short unnoticed
+func segmentAssociated(associated []byte, index uint64, last bool) []byte {
+	ad := binary.BigEndian.AppendUint64(append([]byte{}, associated...), index)
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// SealWriter returns a writer sealing what is written to w in segments, for content too
+// long to hold in memory such as call recordings. Close seals the rest and closes w.
+func (c *ContentCipher) SealWriter(w io.WriteCloser, associated []byte) io.WriteCloser {
+	return &sealWriter{cipher: c, w: w, associated: associated}
+}
+
+// sealWriter buffers a stream and writes it sealed a segment at a time, each as its length
+// and the sealed segment
+type sealWriter struct {
+	cipher     *ContentCipher
+	w          io.WriteCloser
+	associated []byte
+	buf        []byte
+	index      uint64
+	started    bool
+	err        error
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= sealedSegmentSize && s.err == nil {
+		s.err = s.writeSegment(s.buf[:sealedSegmentSize], false)
+		s.buf = append(s.buf[:0], s.buf[sealedSegmentSize:]...)
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return len(p), nil
+}
+
+func (s *sealWriter) Close() error {
+	err := s.err
+	if err == nil {
+		err = s.writeSegment(s.buf, true)
+	}
+	s.err = errors.New("sealed stream is closed")
+	if closeErr := s.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeSegment seals and writes the next segment
+func (s *sealWriter) writeSegment(segment []byte, last bool) error {
+	aead := s.cipher.aead
+	if !s.started {
+		if _, err := s.w.Write(sealedStreamPrefix); err != nil {
+			return err
+		}
+		s.started = true
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nonce, nonce, segment, segmentAssociated(s.associated, s.index, last))
+	s.index++
+	if _, err := s.w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))); err != nil {
+		return err
+	}
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+// openStream decrypts the segments of a sealed stream
+func (c *ContentCipher) openStream(data, associated []byte) ([]byte, error) {
+	if c == nil {
+		return nil, errors.New("content is encrypted and no encryption key is configured")
+	}
+	nonceSize := c.aead.NonceSize()
+	var content []byte
+	for index := uint64(0); ; index++ {
+		if len(data) < 4 {
+			return content, ErrSealedStreamTruncated
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < nonceSize || len(data)-4 < size {
+			return content, ErrSealedStreamTruncated
+		}
+		sealed := data[4 : 4+size]
+		data = data[4+size:]
+
+		last := len(data) == 0
+		segment, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], segmentAssociated(associated, index, last))
+		if err != nil && last {
+			// Without its last segment, the stream was cut short
+			if segment, midErr := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], segmentAssociated(associated, index, false)); midErr == nil {
+				return append(content, segment...), ErrSealedStreamTruncated
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt content, is the key the one it was encrypted with? %w", err)
+		}
+		content = append(content, segment...)
+		if last {
+			return content, nil
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package services
 
 import (
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/ghophp/call-me-help/logger"
 )
@@ -10,29 +13,93 @@ import (
 type Message struct {
 	Role    string // "user" or "therapist"
 	Content string
+	// Words are the recognized word timings of a user message, when the STT provider reports them
+	Words []WordTiming
+	// Original is what was said or spoken in the caller's language when the call is
+	// translated; Content then holds the pivot-language text the LLM works with
+	Original string
+	// Language is the caller's language of Original
+	Language string
+	// Sentiment is the tone of a user message
+	Sentiment *Sentiment
+	// Model is the LLM model that produced a therapist message, empty for fallback phrases
+	Model string
+	// CreatedAt is when the message was added to the conversation
+	CreatedAt time.Time
+	// Source is how a user message was recognized, MessageSourceFinal or MessageSourceInterim;
+	// empty when it wasn't, e.g. in tests
+	Source string
+	// Confidence is the lowest STT confidence of a user message, 0 when none was reported
+	Confidence float32
+	// GenerationLatency is how long the LLM took to write a therapist message, and
+	// SynthesisLatency how long its first audio took to synthesize
+	GenerationLatency time.Duration
+	SynthesisLatency  time.Duration
 }
 
+// Sources of user messages
+const (
+	// MessageSourceFinal messages were made of final recognition results only
+	MessageSourceFinal = "final"
+	// MessageSourceInterim messages ended on an interim result, the caller having gone
+	// quiet before the recognizer settled on the words
+	MessageSourceInterim = "interim"
+)
+
 // Conversation represents a therapy conversation
 type Conversation struct {
-	ID       string
-	Messages []Message
-	mu       sync.Mutex
+	ID         string
+	CallerHash string // HashPhoneNumber of the caller, links sessions from the same number
+	Persona    string // Name of the therapist persona, empty for the default
+	Tenant     string // Name of the tenant the call belongs to, empty without tenants
+	CreatedAt  time.Time
+	Messages   []Message
+	Context    []string // Background known before the call, e.g. from a referral
+	// Summary stands in for Messages[:Summarized] in prompts once the history outgrows
+	// its token budget
+	Summary     string
+	Summarized  int
+	summarizing bool
+	callSummary *CallSummary // Written once the call has ended
+	usage       CallUsage    // LLM usage of the call's turns
+	riskFlags   []string     // Risks the LLM flagged during the call
+	tags        []string     // Added for triage, by hand or from how the call was classified
+	metadata    map[string]string
+	endedAt     time.Time // When the call ended, zero while it is live
+	profile     *CallerProfile
+	onRisk      func(flag, source string)
+	mu          sync.Mutex
 }
 
+// Where a risk was raised
+const (
+	RiskSourceCall    = "call"    // By the LLM during the call
+	RiskSourceSummary = "summary" // By the end-of-call summary
+)
+
 // ConversationService manages conversation history
 type ConversationService struct {
-	conversations map[string]*Conversation
+	conversations ConversationStore
+	profiles      map[string]*CallerProfile // By caller hash
+	profileStore  ProfileStore              // nil keeps profiles in memory only
 	mu            sync.Mutex
 	log           *logger.Logger
 }
 
-// NewConversationService creates a new conversation service
+// NewConversationService creates a new conversation service holding conversations in memory
 func NewConversationService() *ConversationService {
+	return NewConversationServiceWithStore(NewMemoryConversationStore())
+}
+
+// NewConversationServiceWithStore creates a new conversation service holding
+// conversations in the store
+func NewConversationServiceWithStore(store ConversationStore) *ConversationService {
 	log := logger.Component("Conversation")
 	log.Info("Creating new Conversation service")
 
 	return &ConversationService{
-		conversations: make(map[string]*Conversation),
+		conversations: store,
+		profiles:      make(map[string]*CallerProfile),
 		log:           log,
 	}
 }
@@ -42,7 +109,7 @@ func (c *ConversationService) GetOrCreateConversation(id string) *Conversation {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if conv, ok := c.conversations[id]; ok {
+	if conv, ok := c.conversations.GetConversation(id); ok {
 		c.log.Debug("Retrieved existing conversation for call %s", id)
 		return conv
 	}
@@ -50,48 +117,499 @@ func (c *ConversationService) GetOrCreateConversation(id string) *Conversation {
 	// Create a new conversation
 	c.log.Info("Creating new conversation for call %s", id)
 	conv := &Conversation{
-		ID:       id,
-		Messages: []Message{},
+		ID:        id,
+		CreatedAt: time.Now(),
+		Messages:  []Message{},
 	}
-	c.conversations[id] = conv
+	c.conversations.PutConversation(conv)
 	return conv
 }
 
-// AddUserMessage adds a user message to the conversation
-func (c *Conversation) AddUserMessage(content string) {
+// AttachCaller links the conversation to the profile of the caller with the phone number
+// hash, creating the profile on their first call, so what is known about them carries over
+func (c *ConversationService) AttachCaller(conv *Conversation, callerHash string) *CallerProfile {
+	profile, ok := c.CallerProfile(callerHash)
+	if !ok {
+		c.mu.Lock()
+		if profile, ok = c.profiles[callerHash]; !ok {
+			profile = &CallerProfile{CallerHash: callerHash}
+			c.profiles[callerHash] = profile
+		}
+		c.mu.Unlock()
+	}
+
+	profile.addCall(conv.ID, conv.CreatedAt)
+	conv.mu.Lock()
+	conv.CallerHash = callerHash
+	conv.profile = profile
+	flags := conv.riskFlags
+	conv.mu.Unlock()
+	profile.addRisks(conv.ID, flags...)
+	return profile
+}
+
+// CallerProfile returns the profile of the caller with the phone number hash
+func (c *ConversationService) CallerProfile(callerHash string) (*CallerProfile, bool) {
+	c.mu.Lock()
+	profile, ok := c.profiles[callerHash]
+	store := c.profileStore
+	c.mu.Unlock()
+	if ok || store == nil {
+		return profile, ok
+	}
+
+	// Callers from before a restart are loaded from the store once
+	profile, ok, err := store.LoadProfile(callerHash)
+	if err != nil {
+		c.log.Error("Failed to load the profile of caller %s: %v", callerHash, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if loaded, ok := c.profiles[callerHash]; ok {
+		return loaded, true
+	}
+	c.profiles[callerHash] = profile
+	return profile, true
+}
+
+// SetProfileStore has callers' profiles saved to and loaded from the store
+func (c *ConversationService) SetProfileStore(store ProfileStore) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.profileStore = store
+}
+
+// SaveProfile saves the caller's profile to the store, if there is one; failures are
+// logged, the profile staying in memory
+func (c *ConversationService) SaveProfile(profile *CallerProfile) {
+	c.mu.Lock()
+	store := c.profileStore
+	c.mu.Unlock()
+	if store == nil || profile == nil {
+		return
+	}
+	if err := store.SaveProfile(profile); err != nil {
+		c.log.Error("Failed to save the profile of caller %s: %v", profile.CallerHash, err)
+	}
+}
+
+// PromptCaller summarizes the caller's earlier calls for the conversation's prompt
+func (c *ConversationService) PromptCaller(conv *Conversation) PromptCaller {
+	if profile := conv.Profile(); profile != nil {
+		return profile.PromptCaller(conv.ID, conv.CreatedAt)
+	}
+	return PromptCaller{}
+}
+
+// GetConversation returns an existing conversation without creating one
+func (c *ConversationService) GetConversation(id string) (*Conversation, bool) {
+	return c.conversations.GetConversation(id)
+}
+
+// ConversationsForCaller returns the caller's conversations, oldest first
+func (c *ConversationService) ConversationsForCaller(callerHash string) []*Conversation {
+	var convs []*Conversation
+	for _, conv := range c.conversations.Conversations() {
+		if conv.CallerHash == callerHash {
+			convs = append(convs, conv)
+		}
+	}
+	sort.Slice(convs, func(i, j int) bool {
+		return convs[i].CreatedAt.Before(convs[j].CreatedAt)
+	})
+	return convs
+}
+
+// ConversationQuery selects the conversations to list; zero fields don't filter
+type ConversationQuery struct {
+	CallerHash string
+	From       time.Time // Conversations started at or after From
+	To         time.Time // Conversations started before To
+	Tags       []string  // Conversations with every one of the tags
+	Offset     int
+	Limit      int // 0 for every conversation after Offset
+}
+
+// ListConversations returns a page of the conversations matching the query, newest first,
+// and how many match in all
+func (c *ConversationService) ListConversations(query ConversationQuery) ([]*Conversation, int) {
+	var convs []*Conversation
+	for _, conv := range c.conversations.Conversations() {
+		switch {
+		case query.CallerHash != "" && conv.CallerHash != query.CallerHash:
+		case !query.From.IsZero() && conv.CreatedAt.Before(query.From):
+		case !query.To.IsZero() && !conv.CreatedAt.Before(query.To):
+		case len(query.Tags) > 0 && !conv.HasTags(query.Tags...):
+		default:
+			convs = append(convs, conv)
+		}
+	}
+
+	sort.Slice(convs, func(i, j int) bool {
+		if !convs[i].CreatedAt.Equal(convs[j].CreatedAt) {
+			return convs[i].CreatedAt.After(convs[j].CreatedAt)
+		}
+		return convs[i].ID < convs[j].ID
+	})
+	total := len(convs)
+	convs = convs[min(max(query.Offset, 0), total):]
+	if query.Limit > 0 && query.Limit < len(convs) {
+		convs = convs[:query.Limit]
+	}
+	return convs, total
+}
+
+// MatchesRecord reports whether a stored session record passes the query's filters
+func (q ConversationQuery) MatchesRecord(record TranscriptExport) bool {
+	switch {
+	case q.CallerHash != "" && record.CallerHash != q.CallerHash:
+	case !q.From.IsZero() && record.StartedAt.Before(q.From):
+	case !q.To.IsZero() && !record.StartedAt.Before(q.To):
+	default:
+		for _, tag := range q.Tags {
+			if !slices.Contains(record.Tags, tag) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Count returns how many conversations are held in memory
+func (c *ConversationService) Count() int {
+	return len(c.conversations.Conversations())
+}
+
+// EndedBefore returns the conversations whose call ended before the cutoff
+func (c *ConversationService) EndedBefore(cutoff time.Time) []*Conversation {
+	var convs []*Conversation
+	for _, conv := range c.conversations.Conversations() {
+		if ended := conv.EndedAt(); !ended.IsZero() && ended.Before(cutoff) {
+			convs = append(convs, conv)
+		}
+	}
+	return convs
+}
+
+// RemoveConversation drops a conversation from memory
+func (c *ConversationService) RemoveConversation(id string) {
+	c.conversations.DeleteConversation(id)
+}
+
+// Profile returns the profile of the conversation's caller, nil when the number is unknown
+func (c *Conversation) Profile() *CallerProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.profile
+}
+
+// SetPersona records the therapist persona the caller talks to
+func (c *Conversation) SetPersona(persona string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Persona = persona
+}
+
+// CurrentPersona returns the therapist persona the caller talks to, "" for the default
+func (c *Conversation) CurrentPersona() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Persona
+}
+
+// SetTenant records the tenant the call belongs to
+func (c *Conversation) SetTenant(tenant string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Tenant = tenant
+}
+
+// CurrentTenant returns the tenant the call belongs to, "" without tenants
+func (c *Conversation) CurrentTenant() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Tenant
+}
+
+// End marks the call as ended, recording how the caller sounded over it on their profile
+func (c *Conversation) End() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.endedAt.IsZero() {
+		return
+	}
+	c.endedAt = time.Now()
+
+	// The caller's mood over the call carries over to their next ones
+	if c.profile != nil {
+		var points []SentimentPoint
+		for i, msg := range c.Messages {
+			if msg.Sentiment != nil {
+				points = append(points, SentimentPoint{Index: i, Sentiment: *msg.Sentiment})
+			}
+		}
+		if score, emotion, ok := callMood(points); ok {
+			c.profile.addMood(CallMood{CallSID: c.ID, StartedAt: c.CreatedAt, Score: score, Emotion: emotion})
+		}
+	}
+}
+
+// EndedAt returns when the call ended, or the zero time while it is live
+func (c *Conversation) EndedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.endedAt
+}
+
+// SetCallSummary stores the summary of the ended call
+func (c *Conversation) SetCallSummary(summary *CallSummary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.callSummary = summary
+	if summary == nil {
+		return
+	}
+	if c.profile != nil {
+		c.profile.addRisks(c.ID, summary.RiskFlags...)
+		c.profile.setLastSummary(summary.Overview)
+	}
+	for _, flag := range summary.RiskFlags {
+		c.addTags(RiskTag(flag))
+	}
+	if len(summary.RiskFlags) > 0 || len(summary.FollowUps) > 0 {
+		c.addTags(TagFollowUpNeeded)
+	}
+	if c.onRisk != nil {
+		for _, flag := range summary.RiskFlags {
+			if !slices.Contains(c.riskFlags, flag) {
+				go c.onRisk(flag, RiskSourceSummary)
+			}
+		}
+	}
+}
+
+// CallSummary returns the summary of the ended call, or nil until it has been written
+func (c *Conversation) CallSummary() *CallSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.callSummary
+}
+
+// FlagRisk records a risk raised during the call, once
+func (c *Conversation) FlagRisk(flag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, raised := range c.riskFlags {
+		if raised == flag {
+			return
+		}
+	}
+	c.riskFlags = append(c.riskFlags, flag)
+	c.addTags(RiskTag(flag), TagFollowUpNeeded)
+	if c.profile != nil {
+		c.profile.addRisks(c.ID, flag)
+	}
+	if c.onRisk != nil {
+		go c.onRisk(flag, RiskSourceCall)
+	}
+}
+
+// OnRiskFlagged has the listener called with each risk raised on the call from now on,
+// whether during the call or by its summary, and where it was raised
+func (c *Conversation) OnRiskFlagged(listener func(flag, source string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onRisk = listener
+}
+
+// RiskFlags returns the risks raised during the call, in the order they were raised
+func (c *Conversation) RiskFlags() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.riskFlags...)
+}
+
+// AddUsage adds a generation's tokens and cost to the call's LLM usage, marking the call
+// limited when the generation was held to its budget
+func (c *Conversation) AddUsage(promptTokens, responseTokens int, cost float64, limited bool) CallUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usage.Generations++
+	c.usage.PromptTokens += promptTokens
+	c.usage.ResponseTokens += responseTokens
+	c.usage.Cost += cost
+	c.usage.Limited = c.usage.Limited || limited
+	return c.usage
+}
+
+// Usage returns the call's LLM usage so far
+func (c *Conversation) Usage() CallUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.usage
+}
+
+// MessageCount returns the number of messages exchanged so far
+func (c *Conversation) MessageCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.Messages)
+}
+
+// AddUserMessage adds a user message to the conversation with its word timings, if any
+func (c *Conversation) AddUserMessage(content string, words ...WordTiming) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.Messages = append(c.Messages, Message{
-		Role:    "user",
-		Content: content,
+		Role:      "user",
+		Content:   content,
+		Words:     words,
+		CreatedAt: time.Now(),
 	})
 }
 
+// AddTranslatedUserMessage adds a translated user message, keeping what the caller said
+func (c *Conversation) AddTranslatedUserMessage(content, original, language string, words ...WordTiming) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Messages = append(c.Messages, Message{
+		Role:      "user",
+		Content:   content,
+		Words:     words,
+		Original:  original,
+		Language:  language,
+		CreatedAt: time.Now(),
+	})
+}
+
+// Transcript returns a copy of the messages exchanged so far
+func (c *Conversation) Transcript() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Message(nil), c.Messages...)
+}
+
 // AddTherapistMessage adds a therapist message to the conversation
 func (c *Conversation) AddTherapistMessage(content string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.Messages = append(c.Messages, Message{
-		Role:    "therapist",
-		Content: content,
+		Role:      "therapist",
+		Content:   content,
+		CreatedAt: time.Now(),
+	})
+}
+
+// SetLastResponseModel records the model that produced the latest therapist message
+func (c *Conversation) SetLastResponseModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Role == "therapist" {
+			c.Messages[i].Model = model
+			return
+		}
+	}
+}
+
+// SetLastResponseLatency records how long the latest therapist message took to generate
+// and to start being spoken
+func (c *Conversation) SetLastResponseLatency(generation, synthesis time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Role == "therapist" {
+			c.Messages[i].GenerationLatency = generation
+			c.Messages[i].SynthesisLatency = synthesis
+			return
+		}
+	}
+}
+
+// SetLastUserRecognition records how the latest user message was recognized
+func (c *Conversation) SetLastUserRecognition(source string, confidence float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Role == "user" {
+			c.Messages[i].Source = source
+			c.Messages[i].Confidence = confidence
+			return
+		}
+	}
+}
+
+// AddTranslatedTherapistMessage adds a therapist message together with the translation
+// that was spoken to the caller
+func (c *Conversation) AddTranslatedTherapistMessage(content, original, language string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Messages = append(c.Messages, Message{
+		Role:      "therapist",
+		Content:   content,
+		Original:  original,
+		Language:  language,
+		CreatedAt: time.Now(),
 	})
 }
 
+// AddContext adds background information that should precede the conversation in the prompt
+func (c *Conversation) AddContext(note string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Context = append(c.Context, note)
+}
+
 // GetFormattedHistory returns the conversation history formatted for the LLM
 func (c *Conversation) GetFormattedHistory() []string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	var history []string
+	for _, note := range c.Context {
+		history = append(history, "Context: "+note)
+	}
 	for _, msg := range c.Messages {
-		if msg.Role == "user" {
-			history = append(history, "User: "+msg.Content)
-		} else {
-			history = append(history, "Therapist: "+msg.Content)
-		}
+		history = append(history, formatMessage(msg))
 	}
 
 	return history
 }
+
+// formatMessage formats a message for the prompt history
+func formatMessage(msg Message) string {
+	if msg.Role == "user" {
+		return "User: " + msg.Content
+	}
+	return "Therapist: " + msg.Content
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Tags added to conversations from how they were classified
+const (
+	TagFollowUpNeeded = "follow-up-needed" // A risk was raised or the summary suggests follow-ups
+	TagCrisis         = "crisis"           // The caller said something classified as a crisis
+	TagRequestedHuman = "requested-human"  // The caller asked to talk with a person
+	TagEscalated      = "escalated"        // The call was put through to a person
+	riskTagPrefix     = "risk:"            // Followed by the risk flag, e.g. risk:self_harm
+)
+
+// Limits on conversation metadata
+const (
+	maxMetadataKeys  = 50
+	maxMetadataKey   = 64
+	maxMetadataValue = 512
+)
+
+// tagPattern is what a normalized tag looks like: lowercase letters, digits and - _ : .
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_:.-]{0,63}$`)
+
+// NormalizeTag lowercases the tag and joins its words with dashes, e.g. "Follow up
+// needed" becomes follow-up-needed; it returns false for tags that stay invalid
+func NormalizeTag(tag string) (string, bool) {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	return tag, tagPattern.MatchString(tag)
+}
+
+// RiskTag is the tag of conversations a risk was flagged on
+func RiskTag(flag string) string {
+	return riskTagPrefix + flag
+}
+
+// IntentTag is the tag of conversations where the caller had the intent, empty for
+// intents that don't need triage
+func IntentTag(intent Intent) string {
+	switch intent {
+	case IntentCrisis:
+		return TagCrisis
+	case IntentRequestHuman:
+		return TagRequestedHuman
+	}
+	return ""
+}
+
+// AddTags tags the conversation, normalizing the tags; it returns an error, adding
+// none, if any is invalid
+func (c *Conversation) AddTags(tags ...string) error {
+	normalized := make([]string, 0, len(tags))
+	for _, raw := range tags {
+		tag, ok := NormalizeTag(raw)
+		if !ok {
+			return fmt.Errorf("invalid tag %q: use up to 64 letters, digits, and - _ : .", raw)
+		}
+		normalized = append(normalized, tag)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addTags(normalized...)
+	return nil
+}
+
+// addTags adds normalized tags the conversation doesn't have yet; c.mu must be held
+func (c *Conversation) addTags(tags ...string) {
+	for _, tag := range tags {
+		if tag != "" && !slices.Contains(c.tags, tag) {
+			c.tags = append(c.tags, tag)
+		}
+	}
+}
+
+// RemoveTag removes the tag, reporting whether the conversation had it
+func (c *Conversation) RemoveTag(tag string) bool {
+	tag, _ = NormalizeTag(tag)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := slices.Index(c.tags, tag)
+	if i < 0 {
+		return false
+	}
+	c.tags = slices.Delete(c.tags, i, i+1)
+	return true
+}
+
+// Tags returns the conversation's tags in the order they were added
+func (c *Conversation) Tags() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.tags...)
+}
+
+// HasTags reports whether the conversation has every one of the tags
+func (c *Conversation) HasTags(tags ...string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range tags {
+		if !slices.Contains(c.tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateMetadata sets the metadata entries, removing those with an empty value; it
+// returns an error, changing nothing, if an entry is too long or there would be too many
+func (c *Conversation) UpdateMetadata(entries map[string]string) error {
+	for key, value := range entries {
+		switch {
+		case strings.TrimSpace(key) == "":
+			return fmt.Errorf("metadata keys can't be empty")
+		case len(key) > maxMetadataKey:
+			return fmt.Errorf("metadata key %q is longer than %d bytes", key, maxMetadataKey)
+		case len(value) > maxMetadataValue:
+			return fmt.Errorf("metadata value of %q is longer than %d bytes", key, maxMetadataValue)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metadata := maps.Clone(c.metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	for key, value := range entries {
+		if value == "" {
+			delete(metadata, key)
+		} else {
+			metadata[key] = value
+		}
+	}
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("conversations can have up to %d metadata entries", maxMetadataKeys)
+	}
+	c.metadata = metadata
+	return nil
+}
+
+// Metadata returns a copy of the conversation's metadata
+func (c *Conversation) Metadata() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return maps.Clone(c.metadata)
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConversationTags(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("test-call")
+	if err := conv.AddTags("Follow up needed", "vip", "vip"); err != nil {
+		t.Fatalf("Expected the tags added, got %v", err)
+	}
+	if err := conv.AddTags("ok", "no/slashes"); err == nil {
+		t.Error("Expected an invalid tag rejected")
+	}
+	if got := conv.Tags(); !slices.Equal(got, []string{"follow-up-needed", "vip"}) {
+		t.Errorf("Expected normalized tags added once and nothing of a rejected batch, got %q", got)
+	}
+
+	if !conv.RemoveTag("VIP") || conv.RemoveTag("vip") {
+		t.Error("Expected the tag removed once")
+	}
+	if !conv.HasTags("follow-up-needed") || conv.HasTags("follow-up-needed", "vip") {
+		t.Errorf("Unexpected tags %q", conv.Tags())
+	}
+}
+
+func TestConversationTagsFromClassification(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("test-call")
+	conv.FlagRisk(RiskSelfHarm)
+	conv.SetCallSummary(&CallSummary{RiskFlags: []string{RiskSelfHarm, RiskAbuse}})
+	if got := conv.Tags(); !slices.Equal(got, []string{"risk:self_harm", TagFollowUpNeeded, "risk:abuse"}) {
+		t.Errorf("Expected risk and follow-up tags, got %q", got)
+	}
+
+	summarized := NewConversationService().GetOrCreateConversation("other-call")
+	summarized.SetCallSummary(&CallSummary{FollowUps: []string{"Call back next week"}})
+	if got := summarized.Tags(); !slices.Equal(got, []string{TagFollowUpNeeded}) {
+		t.Errorf("Expected suggested follow-ups to tag the call, got %q", got)
+	}
+
+	if IntentTag(IntentCrisis) != TagCrisis || IntentTag(IntentRequestHuman) != TagRequestedHuman || IntentTag(IntentVenting) != "" {
+		t.Error("Unexpected intent tags")
+	}
+}
+
+func TestConversationMetadata(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("test-call")
+	if err := conv.UpdateMetadata(map[string]string{"reviewer": "dana", "ticket": "T-12"}); err != nil {
+		t.Fatalf("Expected the metadata set, got %v", err)
+	}
+	if err := conv.UpdateMetadata(map[string]string{"ticket": "", "queue": "urgent"}); err != nil {
+		t.Fatalf("Expected the metadata updated, got %v", err)
+	}
+	if got := conv.Metadata(); len(got) != 2 || got["reviewer"] != "dana" || got["queue"] != "urgent" {
+		t.Errorf("Expected empty values to remove entries, got %v", got)
+	}
+
+	if err := conv.UpdateMetadata(map[string]string{"note": strings.Repeat("x", 513)}); err == nil {
+		t.Error("Expected a value too long rejected")
+	}
+	many := map[string]string{}
+	for i := range 50 {
+		many[strings.Repeat("k", i+1)] = "v"
+	}
+	if err := conv.UpdateMetadata(many); err == nil {
+		t.Error("Expected too many entries rejected")
+	}
+	if got := conv.Metadata(); len(got) != 2 {
+		t.Errorf("Expected a rejected update to change nothing, got %v", got)
+	}
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConversationService(t *testing.T) {
@@ -61,3 +63,62 @@ func TestConversationService(t *testing.T) {
 		t.Errorf("Expected 'Therapist: %s', got '%s'", testTherapistMsg, history[1])
 	}
 }
+
+func TestListConversations(t *testing.T) {
+	service := NewConversationService()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"call-1", "call-2", "call-3", "call-4"} {
+		conv := service.GetOrCreateConversation(id)
+		conv.CreatedAt = start.AddDate(0, 0, i)
+		if i%2 == 0 {
+			service.AttachCaller(conv, "caller-a")
+		}
+		if i < 2 {
+			conv.AddTags("follow-up-needed")
+		}
+	}
+	service.GetOrCreateConversation("call-2").FlagRisk(RiskSelfHarm)
+
+	ids := func(convs []*Conversation) (ids []string) {
+		for _, conv := range convs {
+			ids = append(ids, conv.ID)
+		}
+		return ids
+	}
+	for _, tc := range []struct {
+		query ConversationQuery
+		want  string
+		total int
+	}{
+		{ConversationQuery{}, "call-4 call-3 call-2 call-1", 4},
+		{ConversationQuery{Offset: 1, Limit: 2}, "call-3 call-2", 4},
+		{ConversationQuery{Offset: 9, Limit: 2}, "", 4},
+		{ConversationQuery{CallerHash: "caller-a"}, "call-3 call-1", 2},
+		{ConversationQuery{From: start.AddDate(0, 0, 1), To: start.AddDate(0, 0, 3)}, "call-3 call-2", 2},
+		{ConversationQuery{Tags: []string{TagFollowUpNeeded}}, "call-2 call-1", 2},
+		{ConversationQuery{Tags: []string{TagFollowUpNeeded, "risk:self_harm"}}, "call-2", 1},
+	} {
+		convs, total := service.ListConversations(tc.query)
+		if got := strings.Join(ids(convs), " "); got != tc.want || total != tc.total {
+			t.Errorf("%+v: expected %q of %d, got %q of %d", tc.query, tc.want, tc.total, got, total)
+		}
+	}
+}
+
+func TestConversationServiceUsesItsStore(t *testing.T) {
+	store := NewMemoryConversationStore()
+	store.PutConversation(&Conversation{ID: "held", CreatedAt: time.Now()})
+	service := NewConversationServiceWithStore(store)
+
+	if conv, ok := service.GetConversation("held"); !ok || conv.ID != "held" {
+		t.Fatalf("Expected the store's conversation, got %v", ok)
+	}
+	service.GetOrCreateConversation("new")
+	if _, ok := store.GetConversation("new"); !ok || service.Count() != 2 {
+		t.Errorf("Expected new conversations put in the store, got %d held", service.Count())
+	}
+	service.RemoveConversation("held")
+	if _, ok := store.GetConversation("held"); ok {
+		t.Error("Expected the conversation deleted from the store")
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// maxCrisisResources caps how many resources a lookup returns, to keep answers short enough to speak
+const maxCrisisResources = 4
+
+// CrisisResource is a helpline or service callers can be pointed to
+type CrisisResource struct {
+	Name        string `json:"name"`
+	Phone       string `json:"phone,omitempty"`
+	Text        string `json:"text,omitempty"` // How to reach it by text message
+	Description string `json:"description,omitempty"`
+	// Regions are the places it serves, e.g. "Texas" or "Austin"; none for nationwide
+	Regions []string `json:"regions,omitempty"`
+	// Topics are what it helps with, e.g. "domestic violence"; none for any crisis
+	Topics []string `json:"topics,omitempty"`
+}
+
+// defaultCrisisResources are the national US resources, always available to lookups
+var defaultCrisisResources = []CrisisResource{
+	{Name: "988 Suicide & Crisis Lifeline", Phone: "988", Text: "Text 988", Description: "Free, confidential support for anyone in distress, 24/7."},
+	{Name: "Crisis Text Line", Text: "Text HOME to 741741", Description: "Text with a trained crisis counselor, 24/7."},
+	{Name: "Veterans Crisis Line", Phone: "988, then press 1", Text: "Text 838255", Description: "Support for veterans, service members and their families.", Topics: []string{"veterans", "military"}},
+	{Name: "National Domestic Violence Hotline", Phone: "1-800-799-7233", Text: "Text START to 88788", Description: "Support and safety planning for people experiencing abuse.", Topics: []string{"domestic violence", "abuse", "relationship"}},
+	{Name: "RAINN National Sexual Assault Hotline", Phone: "1-800-656-4673", Description: "Confidential support for survivors of sexual violence.", Topics: []string{"sexual assault", "rape", "abuse"}},
+	{Name: "SAMHSA National Helpline", Phone: "1-800-662-4357", Description: "Treatment referrals for substance use and mental health, 24/7.", Topics: []string{"substance use", "addiction", "alcohol", "drugs"}},
+	{Name: "The Trevor Project", Phone: "1-866-488-7386", Text: "Text START to 678678", Description: "Crisis support for LGBTQ+ young people.", Topics: []string{"lgbtq", "gay", "transgender", "youth"}},
+}
+
+// CrisisResources looks up helplines by where the caller is and what they need
+type CrisisResources struct {
+	resources []CrisisResource
+}
+
+// LoadCrisisResources loads local resources from a JSON array of CrisisResource in the file,
+// listed ahead of the national defaults; an empty path uses only the defaults
+func LoadCrisisResources(path string) (*CrisisResources, error) {
+	log := logger.Component("CrisisResources")
+	if path == "" {
+		return &CrisisResources{resources: defaultCrisisResources}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var local []CrisisResource
+	if err := json.Unmarshal(data, &local); err != nil {
+		return nil, fmt.Errorf("parsing crisis resources %s: %w", path, err)
+	}
+	for i, resource := range local {
+		if resource.Name == "" || (resource.Phone == "" && resource.Text == "") {
+			return nil, fmt.Errorf("crisis resource %d in %s needs a name and a phone or text contact", i, path)
+		}
+	}
+
+	log.Info("Loaded %d local crisis resources from %s", len(local), path)
+	return &CrisisResources{resources: append(local, defaultCrisisResources...)}, nil
+}
+
+// Lookup returns the resources serving the region and topic, those specific to them first.
+// Either may be empty, in which case resources for any region or topic match.
+func (r *CrisisResources) Lookup(region, topic string) []CrisisResource {
+	region, topic = strings.ToLower(strings.TrimSpace(region)), strings.ToLower(strings.TrimSpace(topic))
+
+	var specific, general []CrisisResource
+	for _, resource := range r.resources {
+		regional, regionOK := matchesAny(resource.Regions, region)
+		topical, topicOK := matchesAny(resource.Topics, topic)
+		if !regionOK || !topicOK {
+			continue
+		}
+		if regional || topical {
+			specific = append(specific, resource)
+		} else {
+			general = append(general, resource)
+		}
+	}
+
+	results := append(specific, general...)
+	if len(results) > maxCrisisResources {
+		results = results[:maxCrisisResources]
+	}
+	return results
+}
+
+// matchesAny reports whether the resource's values are specific to the query, and whether
+// it applies at all: values that are empty apply to everything, and a value matches when
+// either it or the query contains the other, e.g. "Austin" and "austin, texas"
+func matchesAny(values []string, query string) (specific, ok bool) {
+	if len(values) == 0 {
+		return false, true
+	}
+	if query == "" {
+		// Without a region or topic, only resources for everyone apply
+		return false, false
+	}
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if strings.Contains(query, value) || strings.Contains(value, query) {
+			return true, true
+		}
+	}
+	return false, false
+}
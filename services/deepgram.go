@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"github.com/gorilla/websocket"
+)
+
+// DeepgramService implements SpeechRecognizer over Deepgram's streaming API
+type DeepgramService struct {
+	config     *config.Config
+	client     *http.Client
+	phraseSets *PhraseSetStore
+	log        *logger.Logger
+}
+
+// deepgramResult is the subset of a Deepgram "Results" message we use
+type deepgramResult struct {
+	Type        string `json:"type"`
+	IsFinal     bool   `json:"is_final"`
+	SpeechFinal bool   `json:"speech_final"`
+	Channel     struct {
+		Alternatives []struct {
+			Transcript string  `json:"transcript"`
+			Confidence float32 `json:"confidence"`
+			Words      []struct {
+				Word           string  `json:"word"`
+				PunctuatedWord string  `json:"punctuated_word"`
+				Start          float64 `json:"start"` // Seconds from the start of the stream
+				End            float64 `json:"end"`
+			} `json:"words"`
+		} `json:"alternatives"`
+	} `json:"channel"`
+}
+
+// NewDeepgramService creates a new Deepgram speech-to-text service
+func NewDeepgramService(cfg *config.Config) (*DeepgramService, error) {
+	log := logger.Component("Deepgram")
+	log.Info("Creating new Deepgram service with model %s", cfg.DeepgramModel)
+
+	if cfg.DeepgramAPIKey == "" {
+		log.Error("DEEPGRAM_API_KEY environment variable not set")
+		return nil, errors.New("DEEPGRAM_API_KEY is required for the deepgram STT provider")
+	}
+
+	return &DeepgramService{
+		config: cfg,
+		client: &http.Client{Timeout: 60 * time.Second},
+		log:    log,
+	}, nil
+}
+
+// Close is a no-op; Deepgram connections are per stream
+func (d *DeepgramService) Close() error {
+	d.log.Info("Closing Deepgram service")
+	return nil
+}
+
+// deepgramEncoding maps a Twilio media encoding to Deepgram's encoding parameter
+func deepgramEncoding(format AudioFormat) string {
+	switch format.Normalize().Encoding {
+	case EncodingAlaw:
+		return "alaw"
+	case EncodingLinear:
+		return "linear16"
+	default:
+		return "mulaw"
+	}
+}
+
+// StartStream opens a Deepgram live transcription websocket for the call
+func (d *DeepgramService) StartStream(ctx context.Context, format AudioFormat) (RecognitionStream, error) {
+	log := CallLogger(ctx, d.log)
+	format = format.Normalize()
+	log.Info("Starting Deepgram stream (%s, %d Hz)", format.Encoding, format.SampleRate)
+
+	params := url.Values{}
+	params.Set("model", d.config.DeepgramModel)
+	params.Set("language", d.config.STTLanguageCode)
+	params.Set("encoding", deepgramEncoding(format))
+	params.Set("sample_rate", strconv.Itoa(format.SampleRate))
+	params.Set("channels", strconv.Itoa(format.Channels))
+	params.Set("interim_results", strconv.FormatBool(d.config.STTInterimResults))
+	params.Set("punctuate", strconv.FormatBool(d.config.STTAutomaticPunctuation))
+	params.Set("endpointing", "300")
+	params.Set("profanity_filter", strconv.FormatBool(d.config.STTProfanityFilter))
+	for _, set := range d.phraseSets.Lookup(recognitionHints(ctx, d.config.STTLanguageCode)) {
+		for _, phrase := range set.Phrases {
+			if set.Boost > 0 {
+				phrase = fmt.Sprintf("%s:%g", phrase, set.Boost)
+			}
+			params.Add("keywords", phrase)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Token "+d.config.DeepgramAPIKey)
+	correlateHeader(ctx, header)
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, d.config.DeepgramURL+"?"+params.Encode(), header)
+	if err != nil {
+		log.Error("Failed to connect to Deepgram: %v", err)
+		return nil, err
+	}
+
+	stream := &deepgramStream{
+		conn:        conn,
+		transcripts: make(chan Transcript, 1024),
+		log:         log,
+	}
+	go stream.listen()
+
+	// Close the websocket when the call ends
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	return stream, nil
+}
+
+// TranscribeRecording transcribes a complete WAV recording with Deepgram's pre-recorded API
+func (d *DeepgramService) TranscribeRecording(ctx context.Context, wav []byte) (string, error) {
+	log := CallLogger(ctx, d.log)
+	log.Info("Transcribing %d bytes of recorded audio", len(wav))
+
+	endpoint := strings.Replace(d.config.DeepgramURL, "wss://", "https://", 1)
+	params := url.Values{}
+	params.Set("model", d.config.DeepgramModel)
+	params.Set("language", d.config.STTLanguageCode)
+	params.Set("punctuate", strconv.FormatBool(d.config.STTAutomaticPunctuation))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"?"+params.Encode(), bytes.NewReader(wav))
+	if err != nil {
+		return "", err
+	}
+	correlate(req)
+	req.Header.Set("Authorization", "Token "+d.config.DeepgramAPIKey)
+	req.Header.Set("Content-Type", "audio/wav")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		log.Error("Error calling Deepgram: %v", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Deepgram returned status %d: %s", resp.StatusCode, body)
+		return "", fmt.Errorf("deepgram: unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Results struct {
+			Channels []struct {
+				Alternatives []struct {
+					Transcript string `json:"transcript"`
+				} `json:"alternatives"`
+			} `json:"channels"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, channel := range result.Results.Channels {
+		if len(channel.Alternatives) > 0 {
+			parts = append(parts, channel.Alternatives[0].Transcript)
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// deepgramStream is a live Deepgram transcription session
+type deepgramStream struct {
+	conn        *websocket.Conn
+	transcripts chan Transcript
+	writeMu     sync.Mutex
+	closeOnce   sync.Once
+	log         *logger.Logger
+}
+
+func (s *deepgramStream) SendAudio(audio []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.BinaryMessage, audio)
+}
+
+func (s *deepgramStream) Transcripts() <-chan Transcript {
+	return s.transcripts
+}
+
+// Close asks Deepgram to flush remaining results; the reader closes the connection on EOF
+func (s *deepgramStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		err = s.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"CloseStream"}`))
+	})
+	return err
+}
+
+// listen reads Deepgram results until the connection closes
+func (s *deepgramStream) listen() {
+	defer func() {
+		s.log.Info("Closing Deepgram transcription channel")
+		close(s.transcripts)
+		s.conn.Close()
+	}()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				s.log.Info("Deepgram stream closed")
+			} else {
+				s.log.Error("Error receiving from Deepgram: %v", err)
+			}
+			return
+		}
+
+		var result deepgramResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			s.log.Warn("Ignoring unparseable Deepgram message: %v", err)
+			continue
+		}
+		if result.Type != "Results" || len(result.Channel.Alternatives) == 0 {
+			continue
+		}
+
+		alt := result.Channel.Alternatives[0]
+		if alt.Transcript == "" {
+			if result.SpeechFinal {
+				s.transcripts <- Transcript{EndOfSpeech: true}
+			}
+			continue
+		}
+
+		var words []WordTiming
+		for _, word := range alt.Words {
+			text := word.PunctuatedWord
+			if text == "" {
+				text = word.Word
+			}
+			words = append(words, WordTiming{
+				Word:  text,
+				Start: secondsToDuration(word.Start),
+				End:   secondsToDuration(word.End),
+			})
+		}
+
+		s.log.Info("Transcription (final=%t): %s", result.IsFinal, logger.Transcript(alt.Transcript))
+		s.transcripts <- Transcript{
+			Text:        alt.Transcript,
+			IsFinal:     result.IsFinal,
+			Confidence:  alt.Confidence,
+			EndOfSpeech: result.SpeechFinal,
+			Words:       words,
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// modulePath prefixes the functions of this application in stack traces
+const modulePath = "github.com/ghophp/call-me-help/"
+
+// QueueOccupancy is how full one of a call's channels is
+type QueueOccupancy struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+// occupancy reports the channel's length and capacity
+func occupancy[T any](ch chan T) QueueOccupancy {
+	return QueueOccupancy{Len: len(ch), Cap: cap(ch)}
+}
+
+// CallOccupancy is how much a call's pipeline holds: full channels point at the stage
+// that stopped reading them
+type CallOccupancy struct {
+	CallSID        string         `json:"callSid"`
+	State          string         `json:"state"`
+	AgeSeconds     float64        `json:"ageSeconds"`
+	AudioIn        QueueOccupancy `json:"audioIn"`
+	Transcriptions QueueOccupancy `json:"transcriptions"`
+	ResponseText   QueueOccupancy `json:"responseText"`
+	ResponseAudio  QueueOccupancy `json:"responseAudio"`
+	JitterDepthMs  int64          `json:"jitterDepthMs"` // Inbound audio waiting to be recognized
+}
+
+// Occupancy reports the channels of every call, oldest first
+func (cm *ChannelManager) Occupancy() []CallOccupancy {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	calls := make([]CallOccupancy, 0, len(cm.channels))
+	for _, channels := range cm.channels {
+		call := CallOccupancy{
+			CallSID:        channels.CallSID,
+			State:          channels.PipelineState(),
+			AgeSeconds:     time.Since(channels.CreatedAt).Seconds(),
+			AudioIn:        occupancy(channels.AudioInputChan),
+			Transcriptions: occupancy(channels.TranscriptionChan),
+			ResponseText:   occupancy(channels.ResponseTextChan),
+			ResponseAudio:  occupancy(channels.ResponseAudioChan),
+		}
+		if channels.jitterBuffer != nil {
+			call.JitterDepthMs = channels.jitterBuffer.Depth().Milliseconds()
+		}
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].AgeSeconds > calls[j].AgeSeconds })
+	return calls
+}
+
+// GoroutineGroup counts the goroutines blocked in the same place
+type GoroutineGroup struct {
+	// Function is the innermost of this application's functions on the stack, or the
+	// goroutine's top function when none is
+	Function string `json:"function"`
+	State    string `json:"state"` // e.g. running, chan receive or select
+	Count    int    `json:"count"`
+}
+
+// GoroutineGroups groups the running goroutines by where they are in this application and
+// what they wait on, largest group first: a group growing with every call is a leak
+func GoroutineGroups() []GoroutineGroup {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	return groupGoroutines(buf)
+}
+
+// groupGoroutines groups the goroutines of a runtime.Stack dump
+func groupGoroutines(dump []byte) []GoroutineGroup {
+	counts := make(map[GoroutineGroup]int)
+	for _, stack := range bytes.Split(dump, []byte("\n\n")) {
+		lines := bufio.NewScanner(bytes.NewReader(stack))
+		if !lines.Scan() {
+			continue
+		}
+		// goroutine 42 [chan receive, 3 minutes]:
+		header := lines.Text()
+		start, end := strings.Index(header, "["), strings.LastIndex(header, "]")
+		if !strings.HasPrefix(header, "goroutine ") || start < 0 || end < start {
+			continue
+		}
+		state, _, _ := strings.Cut(header[start+1:end], ",")
+
+		var top, own string
+		for lines.Scan() {
+			line := lines.Text()
+			if strings.HasPrefix(line, "\t") {
+				continue // The frame's file and line
+			}
+			function := strings.TrimPrefix(line, "created by ")
+			if i := strings.LastIndex(function, "("); i > 0 && !strings.HasPrefix(line, "created by ") {
+				function = function[:i]
+			} else if i := strings.Index(function, " in goroutine "); i > 0 {
+				function = function[:i]
+			}
+			if top == "" {
+				top = function
+			}
+			if strings.HasPrefix(function, modulePath) {
+				own = function
+				break
+			}
+		}
+		if own == "" {
+			own = top
+		}
+		counts[GoroutineGroup{Function: strings.TrimPrefix(own, modulePath), State: state}]++
+	}
+
+	groups := make([]GoroutineGroup, 0, len(counts))
+	for group, count := range counts {
+		group.Count = count
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Function < groups[j].Function
+	})
+	return groups
+}
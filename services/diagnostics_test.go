@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestGoroutinesAreGroupedByTheirOwnFunction(t *testing.T) {
+	dump := `goroutine 1 [running]:
+main.main()
+	/app/main.go:10 +0x1d
+
+goroutine 7 [chan receive, 3 minutes]:
+github.com/ghophp/call-me-help/services.(*ChannelManager).forwardAudio(0xc000010000, {0x1, 0x2})
+	/app/services/channel_manager.go:430 +0x85
+created by github.com/ghophp/call-me-help/services.(*ChannelManager).StartAudioProcessing in goroutine 6
+	/app/services/channel_manager.go:384 +0x1c5
+
+goroutine 8 [chan receive]:
+github.com/ghophp/call-me-help/services.(*ChannelManager).forwardAudio(0xc000010100, {0x1, 0x2})
+	/app/services/channel_manager.go:430 +0x85
+
+goroutine 9 [IO wait]:
+internal/poll.runtime_pollWait(0x7f, 0x72)
+	/usr/lib/go/src/runtime/netpoll.go:343 +0x85
+created by github.com/ghophp/call-me-help/handlers.HandleWebSocket.func1 in goroutine 5
+	/app/handlers/websocket.go:171 +0x3a
+`
+	groups := groupGoroutines([]byte(dump))
+	if len(groups) != 3 {
+		t.Fatalf("Expected three groups, got %+v", groups)
+	}
+	if want := (GoroutineGroup{Function: "services.(*ChannelManager).forwardAudio", State: "chan receive", Count: 2}); groups[0] != want {
+		t.Errorf("Expected the forwarding goroutines grouped first, got %+v", groups[0])
+	}
+	if want := (GoroutineGroup{Function: "handlers.HandleWebSocket.func1", State: "IO wait", Count: 1}); groups[1] != want {
+		t.Errorf("Expected a goroutine in the runtime grouped by what created it, got %+v", groups[1])
+	}
+	if want := (GoroutineGroup{Function: "main.main", State: "running", Count: 1}); groups[2] != want {
+		t.Errorf("Expected other goroutines grouped by their top function, got %+v", groups[2])
+	}
+}
+
+func TestChannelOccupancyOfEachCall(t *testing.T) {
+	cm := &ChannelManager{channels: make(map[string]*ChannelData)}
+	channels := &ChannelData{
+		CallSID:           "CA123",
+		AudioInputChan:    make(chan []byte, 4),
+		TranscriptionChan: make(chan Transcript, 4),
+		ResponseTextChan:  make(chan string, 4),
+		ResponseAudioChan: make(chan []byte, 2),
+	}
+	channels.ResponseAudioChan <- []byte{1}
+	channels.ResponseAudioChan <- []byte{2}
+	cm.channels["CA123"] = channels
+
+	calls := cm.Occupancy()
+	if len(calls) != 1 || calls[0].ResponseAudio != (QueueOccupancy{Len: 2, Cap: 2}) || calls[0].AudioIn.Cap != 4 {
+		t.Errorf("Expected the call's channels reported, got %+v", calls)
+	}
+	if len(GoroutineGroups()) == 0 {
+		t.Error("Expected the test's own goroutines grouped")
+	}
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// errorQueueSize is how many error events can wait to be sent before new ones are dropped
+const errorQueueSize = 100
+
+// panicField marks the log records of recovered panics, which are reported with their
+// stack by Recover rather than as logged
+const panicField = "panic"
+
+// ErrorReporter sends errors logged at ERROR and recovered panics, with the call they
+// happened on, to an error tracker speaking Sentry's protocol: Sentry itself, or a
+// compatible service such as GlitchTip. Events are sent in the background.
+type ErrorReporter struct {
+	endpoint    string // The project's envelope endpoint
+	dsn         string
+	auth        string // X-Sentry-Auth header
+	environment string
+	release     string
+	serverName  string
+	queue       chan errorEvent
+	client      *http.Client
+	log         *logger.Logger
+}
+
+// errorEvent is an event as Sentry's API takes it
+type errorEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"` // error, or fatal for panics
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	Message     *eventMessage     `json:"message,omitempty"`
+	Exception   *eventExceptions  `json:"exception,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Culprit     string            `json:"culprit,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type eventMessage struct {
+	Formatted string `json:"formatted"`
+}
+
+type eventExceptions struct {
+	Values []eventException `json:"values"`
+}
+
+type eventException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// NewErrorReporter creates a reporter sending to the DSN, e.g.
+// https://<key>@o0.ingest.sentry.io/<project>; it returns nil, which reports nothing,
+// when the DSN is empty
+func NewErrorReporter(dsn, environment, release string) (*ErrorReporter, error) {
+	if dsn == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, errors.New("the error reporting DSN must look like https://<key>@<host>/<project>")
+	}
+	path := strings.Trim(u.Path, "/")
+	prefix, project := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	if project == "" {
+		return nil, errors.New("the error reporting DSN has no project")
+	}
+
+	serverName, _ := os.Hostname()
+	return &ErrorReporter{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		dsn:         dsn,
+		auth:        "Sentry sentry_version=7, sentry_client=call-me-help/1.0, sentry_key=" + u.User.Username(),
+		environment: environment,
+		release:     release,
+		serverName:  serverName,
+		queue:       make(chan errorEvent, errorQueueSize),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		log:         logger.Component("ErrorReporter"),
+	}, nil
+}
+
+// CaptureLog queues a record logged at ERROR; it is what the reporter passes to
+// logger.OnError
+func (r *ErrorReporter) CaptureLog(record logger.ErrorEvent) {
+	if r == nil || record.Fields[panicField] != "" {
+		return
+	}
+	event := r.newEvent("error", record.Time, record.Fields)
+	event.Logger = record.Component
+	event.Message = &eventMessage{Formatted: record.Message}
+	event.Culprit = record.Source
+	if record.Component != "" {
+		event.Tags["component"] = record.Component
+	}
+	r.enqueue(event)
+}
+
+// CapturePanic queues a recovered panic with its stack and the call in the context
+func (r *ErrorReporter) CapturePanic(ctx context.Context, value interface{}, stack []byte) {
+	if r == nil {
+		return
+	}
+	event := r.newEvent("fatal", time.Now(), nil)
+	event.Exception = &eventExceptions{Values: []eventException{{Type: "panic", Value: logger.MaskPII(fmt.Sprint(value))}}}
+	event.Extra["stack"] = string(stack)
+	if callSID := CallSIDFromContext(ctx); callSID != "" {
+		event.Tags[logger.CallSIDKey] = callSID
+	}
+	if id := CorrelationIDFromContext(ctx); id != "" {
+		event.Tags[logger.CorrelationIDKey] = id
+	}
+	r.enqueue(event)
+}
+
+// Recover, deferred, stops a panic from crashing the process: it is logged and reported
+// with the call in the context. A nil reporter only logs it.
+func (r *ErrorReporter) Recover(ctx context.Context) {
+	if value := recover(); value != nil {
+		r.ReportPanic(ctx, value)
+	}
+}
+
+// ReportPanic logs and reports a panic recovered on the call in the context, with the
+// stack of the goroutine that recovered it
+func (r *ErrorReporter) ReportPanic(ctx context.Context, value interface{}) {
+	stack := debug.Stack()
+	CallLogger(ctx, logger.Component("Recovery")).With(panicField, true).Error("Recovered from panic: %v\n%s", value, stack)
+	r.CapturePanic(ctx, value, stack)
+}
+
+// newEvent creates an event whose fields become tags, as they are what events are
+// searched by, e.g. callSid
+func (r *ErrorReporter) newEvent(level string, at time.Time, fields map[string]string) errorEvent {
+	event := errorEvent{
+		EventID:     NewCorrelationID() + NewCorrelationID(), // 32 hex digits
+		Timestamp:   at.UTC().Format(time.RFC3339Nano),
+		Level:       level,
+		Platform:    "go",
+		Environment: r.environment,
+		Release:     r.release,
+		ServerName:  r.serverName,
+		Tags:        make(map[string]string),
+		Extra:       make(map[string]string),
+	}
+	for key, value := range fields {
+		event.Tags[key] = value
+	}
+	return event
+}
+
+// enqueue queues the event; it is dropped when the queue is full
+func (r *ErrorReporter) enqueue(event errorEvent) {
+	select {
+	case r.queue <- event:
+	default:
+		r.log.Warn("Error report queue full, dropping event %s", event.EventID)
+	}
+}
+
+// Run sends queued events until the context is cancelled
+func (r *ErrorReporter) Run(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-r.queue:
+			// Failures are logged below ERROR, so they aren't reported in turn
+			if err := r.send(ctx, event); err != nil {
+				r.log.Warn("Failed to report event %s: %v", event.EventID, err)
+			}
+		}
+	}
+}
+
+// send posts the event as an envelope
+func (r *ErrorReporter) send(ctx context.Context, event errorEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": event.EventID, "dsn": r.dsn})
+	item, _ := json.Marshal(map[string]interface{}{"type": "event", "length": len(payload)})
+
+	var body bytes.Buffer
+	for _, line := range [][]byte{header, item, payload} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// receiveEvents starts a tracker whose envelopes' events are sent on the channel
+func receiveEvents(t *testing.T) (*ErrorReporter, chan errorEvent) {
+	t.Helper()
+	events := make(chan errorEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/envelope/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("Expected an envelope for project 42 with the key, got %s %q", r.URL.Path, r.Header.Get("X-Sentry-Auth"))
+		}
+		lines := bufio.NewScanner(r.Body)
+		for i := 0; lines.Scan(); i++ {
+			if i == 2 {
+				var event errorEvent
+				json.Unmarshal(lines.Bytes(), &event)
+				events <- event
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	reporter, err := NewErrorReporter(strings.Replace(server.URL, "://", "://public@", 1)+"/42", "test", "v1")
+	if err != nil {
+		t.Fatalf("Expected the DSN accepted, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go reporter.Run(ctx)
+	return reporter, events
+}
+
+func nextEvent(t *testing.T, events chan errorEvent) errorEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected an event reported")
+	}
+	return errorEvent{}
+}
+
+func TestErrorsLoggedAreReportedWithTheCall(t *testing.T) {
+	reporter, events := receiveEvents(t)
+	logger.OnError(reporter.CaptureLog)
+	defer logger.OnError(nil)
+
+	logger.Component("TurnEngine").WithCall("CA123").Error("Failed to text +15551234567: %v", "timeout")
+
+	event := nextEvent(t, events)
+	if event.Level != "error" || event.Environment != "test" || event.Release != "v1" {
+		t.Errorf("Expected an error event of the environment and release, got %+v", event)
+	}
+	if event.Message == nil || event.Message.Formatted != "Failed to text [phone]: timeout" {
+		t.Errorf("Expected the message with the phone number masked, got %+v", event.Message)
+	}
+	if event.Tags["callSid"] != "CA123" || event.Tags["component"] != "TurnEngine" {
+		t.Errorf("Expected the call and component as tags, got %v", event.Tags)
+	}
+}
+
+func TestRecoveredPanicsAreReportedOnce(t *testing.T) {
+	reporter, events := receiveEvents(t)
+	logger.OnError(reporter.CaptureLog)
+	defer logger.OnError(nil)
+
+	ctx := WithCorrelationID(WithCallSID(context.Background(), "CA123"), "abc")
+	func() {
+		defer reporter.Recover(ctx)
+		panic("nil map")
+	}()
+
+	event := nextEvent(t, events)
+	if event.Level != "fatal" || event.Exception == nil || event.Exception.Values[0].Value != "nil map" {
+		t.Errorf("Expected a fatal event with the panic, got %+v", event)
+	}
+	if !strings.Contains(event.Extra["stack"], "TestRecoveredPanicsAreReportedOnce") {
+		t.Errorf("Expected the stack of the panic, got %q", event.Extra["stack"])
+	}
+	if event.Tags["callSid"] != "CA123" || event.Tags["correlationId"] != "abc" {
+		t.Errorf("Expected the call as tags, got %v", event.Tags)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected the panic's log record not reported again, got %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestErrorReporterNeedsAProject(t *testing.T) {
+	if reporter, err := NewErrorReporter("", "", ""); reporter != nil || err != nil {
+		t.Errorf("Expected no reporter without a DSN, got %v, %v", reporter, err)
+	}
+	if _, err := NewErrorReporter("https://key@sentry.example.com/", "", ""); err == nil {
+		t.Error("Expected a DSN without a project rejected")
+	}
+	reporter, _ := NewErrorReporter("https://key@sentry.example.com/relay/7", "", "")
+	if reporter.endpoint != "https://sentry.example.com/relay/api/7/envelope/" {
+		t.Errorf("Expected the path prefix kept, got %s", reporter.endpoint)
+	}
+}
@@ -0,0 +1,31 @@
+package services
+
+import "time"
+
+// EventPublisher delivers call events to downstream consumers. Publish must not block
+// the call path, so publishers queue events and deliver them in the background.
+type EventPublisher interface {
+	Publish(eventType, callSID string, data any)
+}
+
+// EventPublishers publishes each event to every publisher; it is empty, publishing
+// nothing, when none is configured
+type EventPublishers []EventPublisher
+
+// Publish hands the event to every publisher
+func (p EventPublishers) Publish(eventType, callSID string, data any) {
+	for _, publisher := range p {
+		publisher.Publish(eventType, callSID, data)
+	}
+}
+
+// newEvent wraps the event data with its ID, type, call and time
+func newEvent(eventType, callSID string, data any) WebhookEvent {
+	return WebhookEvent{
+		ID:        generateID("evt"),
+		Type:      eventType,
+		CallSID:   callSID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// FailureType is the kind of failure a fallback phrase covers
+type FailureType string
+
+const (
+	// FailureGeneration is a failed LLM call
+	FailureGeneration FailureType = "generation"
+	// FailureTimeout is an LLM call that ran out of time
+	FailureTimeout FailureType = "timeout"
+	// FailureEmptyResponse is an LLM call that produced nothing to say
+	FailureEmptyResponse FailureType = "empty_response"
+	// FailureLowConfidence is a caller utterance recognized with too little confidence to act on
+	FailureLowConfidence FailureType = "low_confidence"
+	// FailureSynthesis is a response that couldn't be turned into speech; its phrases are
+	// only useful pre-synthesized
+	FailureSynthesis FailureType = "synthesis"
+	// FailureUnsafeResponse is a response the guardrail kept from being spoken
+	FailureUnsafeResponse FailureType = "unsafe_response"
+	// PhraseBackchannel isn't a failure: it acknowledges the caller while a slow response
+	// is still being generated, and is only played pre-synthesized
+	PhraseBackchannel FailureType = "backchannel"
+)
+
+// defaultFallbackPhrases are used for anything the phrases file doesn't override
+var defaultFallbackPhrases = map[string]map[FailureType][]string{
+	"en-US": {
+		FailureGeneration: {
+			"I'm sorry, I'm having trouble understanding right now. Could you please repeat that?",
+			"I want to make sure I really hear you. Could you say that again for me?",
+			"I'm sorry, I missed that. Would you mind telling me once more?",
+			"Thank you for your patience with me. Could you share that with me again?",
+		},
+		FailureTimeout: {
+			"I'm sorry, I need a moment to gather my thoughts. Could you tell me a little more while I do?",
+			"Thank you for waiting. I'm still here with you. Could you say that one more time?",
+		},
+		FailureEmptyResponse: {
+			"I'm here and I'm listening. Could you tell me a bit more about that?",
+			"I'd like to understand better. Can you put that another way for me?",
+		},
+		FailureLowConfidence: {
+			"Sorry, could you repeat that?",
+			"I'm sorry, the line isn't very clear. Could you say that again?",
+			"I didn't quite catch that. Could you tell me once more?",
+			"I'm having trouble hearing you. Could you say that again?",
+		},
+		FailureSynthesis: {
+			"I'm sorry, please hold on a moment.",
+			"I'm having a little trouble on my end. Please hold on a moment and tell me again.",
+		},
+		FailureUnsafeResponse: {
+			"Your safety matters most right now. If you're thinking about hurting yourself, please call or text 988, or dial 911 if you're in danger. I'm still here with you.",
+			"I want to make sure you're safe. You can call or text 988 any time, day or night. Would you like to keep talking with me?",
+		},
+		PhraseBackchannel: {
+			"Mm-hmm, I hear you.",
+			"Mm-hmm.",
+			"I'm with you.",
+			"Okay, I hear you.",
+		},
+	},
+}
+
+// FallbackPhrase is a phrase to speak when a turn fails, with its audio if pre-synthesized
+type FallbackPhrase struct {
+	Text  string
+	Audio []byte
+}
+
+// FallbackLibrary holds the fallback phrases per language and failure type
+type FallbackLibrary struct {
+	language string // Used when a call's language has no phrases
+	phrases  map[string]map[FailureType][]string
+	audio    map[string][]byte // Pre-synthesized audio keyed by format and text
+	audioDir string            // Where pre-synthesized audio is cached, empty for memory only
+	mu       sync.RWMutex
+	log      *logger.Logger
+}
+
+// NewFallbackLibrary creates a library with the built-in phrases
+func NewFallbackLibrary(defaultLanguage string) *FallbackLibrary {
+	library := &FallbackLibrary{
+		language: defaultLanguage,
+		phrases:  make(map[string]map[FailureType][]string),
+		audio:    make(map[string][]byte),
+		log:      logger.Component("Fallbacks"),
+	}
+	library.merge(defaultFallbackPhrases)
+	return library
+}
+
+// LoadFallbackLibrary creates a library with the phrases file overlaid on the built-in phrases.
+// The file maps language codes to failure types to phrase lists, e.g. {"en-US": {"generation": ["..."]}}
+func LoadFallbackLibrary(path, defaultLanguage string) (*FallbackLibrary, error) {
+	library := NewFallbackLibrary(defaultLanguage)
+	if path == "" {
+		return library, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var phrases map[string]map[FailureType][]string
+	if err := json.Unmarshal(data, &phrases); err != nil {
+		return nil, err
+	}
+	library.merge(phrases)
+
+	library.log.Info("Loaded fallback phrases for %d languages from %s", len(phrases), path)
+	return library, nil
+}
+
+// SetAudioDir caches pre-synthesized audio in dir, so phrases synthesized once, or
+// shipped with the deployment, play from disk without a TTS call
+func (l *FallbackLibrary) SetAudioDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.audioDir = dir
+	return nil
+}
+
+// merge replaces phrase lists per language and failure type
+func (l *FallbackLibrary) merge(phrases map[string]map[FailureType][]string) {
+	for language, byFailure := range phrases {
+		language = strings.ToLower(language)
+		if l.phrases[language] == nil {
+			l.phrases[language] = make(map[FailureType][]string)
+		}
+		for failure, list := range byFailure {
+			if len(list) > 0 {
+				l.phrases[language][failure] = list
+			}
+		}
+	}
+}
+
+// candidates finds the phrases for a failure, falling back to the default language
+// and then to the generic generation failure
+func (l *FallbackLibrary) candidates(language string, failure FailureType) []string {
+	for _, lang := range []string{language, l.language, "en-US"} {
+		byFailure := l.phrasesFor(lang)
+		if list := byFailure[failure]; len(list) > 0 {
+			return list
+		}
+		if list := byFailure[FailureGeneration]; len(list) > 0 {
+			return list
+		}
+	}
+	return []string{defaultFallbackPhrases["en-US"][FailureGeneration][0]}
+}
+
+// phrasesFor returns a language's phrases, or those of another variant of it, e.g. es-ES
+// phrases for a caller detected speaking es-US
+func (l *FallbackLibrary) phrasesFor(language string) map[FailureType][]string {
+	language = strings.ToLower(language)
+	if byFailure, ok := l.phrases[language]; ok {
+		return byFailure
+	}
+	variant := ""
+	for other := range l.phrases {
+		if SameLanguage(other, language) && (variant == "" || other < variant) {
+			variant = other
+		}
+	}
+	return l.phrases[variant]
+}
+
+// Phrase returns the n-th phrase for the failure, rotating through the list, with
+// its audio when it was pre-synthesized for the format
+func (l *FallbackLibrary) Phrase(language string, failure FailureType, n int, format AudioFormat) FallbackPhrase {
+	list := l.candidates(language, failure)
+	text := list[n%len(list)]
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return FallbackPhrase{Text: text, Audio: l.audio[fallbackAudioKey(format, text)]}
+}
+
+// Backchannel returns the n-th backchannel phrase, rotating through the list, and whether
+// its audio is ready to play; unlike failures it never falls back to other phrases
+func (l *FallbackLibrary) Backchannel(language string, n int, format AudioFormat) (FallbackPhrase, bool) {
+	for _, lang := range []string{language, l.language, "en-US"} {
+		if list := l.phrasesFor(lang)[PhraseBackchannel]; len(list) > 0 {
+			phrase := l.Phrase(lang, PhraseBackchannel, n, format)
+			return phrase, phrase.Audio != nil
+		}
+	}
+	return FallbackPhrase{}, false
+}
+
+// Presynthesize prepares every phrase's audio in the format so fallbacks play without
+// waiting on TTS. Audio cached on disk is used as is; the rest is synthesized and cached.
+// After a synthesis error the remaining phrases are still loaded from disk where possible.
+func (l *FallbackLibrary) Presynthesize(ctx context.Context, synthesizer SpeechSynthesizer, format AudioFormat) error {
+	format = format.Normalize()
+	l.mu.RLock()
+	dir := l.audioDir
+	l.mu.RUnlock()
+
+	var synthErr error
+	loaded, synthesized := 0, 0
+	for _, byFailure := range l.phrases {
+		for _, list := range byFailure {
+			for _, text := range list {
+				key := fallbackAudioKey(format, text)
+				l.mu.RLock()
+				_, done := l.audio[key]
+				l.mu.RUnlock()
+				if done {
+					continue
+				}
+
+				path := ""
+				if dir != "" {
+					path = filepath.Join(dir, fallbackAudioFile(format, text))
+					if audio, err := os.ReadFile(path); err == nil && len(audio) > 0 {
+						l.store(key, audio)
+						loaded++
+						continue
+					}
+				}
+				if synthErr != nil {
+					continue
+				}
+
+				audio, err := synthesizer.SynthesizeSpeech(ctx, text, format)
+				if err != nil {
+					l.log.Error("Error pre-synthesizing fallback phrase %q: %v", text, err)
+					synthErr = err
+					continue
+				}
+				l.store(key, audio)
+				synthesized++
+
+				if path != "" {
+					if err := os.WriteFile(path, audio, 0644); err != nil {
+						l.log.Warn("Error caching fallback phrase audio to %s: %v", path, err)
+					}
+				}
+			}
+		}
+	}
+
+	l.log.Info("Prepared fallback phrases (%s, %d Hz): %d from disk, %d synthesized",
+		format.Encoding, format.SampleRate, loaded, synthesized)
+	return synthErr
+}
+
+// store keeps a phrase's audio in memory
+func (l *FallbackLibrary) store(key string, audio []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.audio[key] = audio
+}
+
+// fallbackAudioFile names a phrase's cached audio by format and a hash of the text, so
+// editing a phrase synthesizes it anew
+func fallbackAudioFile(format AudioFormat, text string) string {
+	format = format.Normalize()
+	sum := sha256.Sum256([]byte(text))
+	encoding := strings.TrimPrefix(strings.TrimPrefix(format.Encoding, "audio/"), "x-")
+	return fmt.Sprintf("%s_%d_%s.raw", encoding, format.SampleRate, hex.EncodeToString(sum[:8]))
+}
+
+// fallbackAudioKey identifies a phrase's audio in a given format
+func fallbackAudioKey(format AudioFormat, text string) string {
+	format = format.Normalize()
+	return fmt.Sprintf("%s|%d|%s", format.Encoding, format.SampleRate, text)
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFallbackLibraryRotatesPhrases(t *testing.T) {
+	library := NewFallbackLibrary("en-US")
+	phrases := defaultFallbackPhrases["en-US"][FailureGeneration]
+
+	for n := 0; n < len(phrases)*2; n++ {
+		got := library.Phrase("en-US", FailureGeneration, n, DefaultAudioFormat()).Text
+		if got != phrases[n%len(phrases)] {
+			t.Errorf("Phrase %d: expected %q, got %q", n, phrases[n%len(phrases)], got)
+		}
+	}
+}
+
+func TestFallbackLibraryFallsBackToDefaultLanguage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fallbacks.json")
+	err := os.WriteFile(path, []byte(`{"es-ES": {"generation": ["Lo siento, ¿puede repetirlo?"]}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	library, err := LoadFallbackLibrary(path, "en-US")
+	if err != nil {
+		t.Fatalf("Failed to load fallback phrases: %v", err)
+	}
+
+	// Spanish only overrides generation failures, so timeouts use the Spanish generation phrases
+	if got := library.Phrase("es-ES", FailureTimeout, 0, DefaultAudioFormat()).Text; got != "Lo siento, ¿puede repetirlo?" {
+		t.Errorf("Expected Spanish generation phrase, got %q", got)
+	}
+	// Unknown languages use the default language
+	if got := library.Phrase("fr-FR", FailureTimeout, 0, DefaultAudioFormat()).Text; got != defaultFallbackPhrases["en-US"][FailureTimeout][0] {
+		t.Errorf("Expected default timeout phrase, got %q", got)
+	}
+}
+
+func TestFallbackLibraryPresynthesizesAudio(t *testing.T) {
+	library := NewFallbackLibrary("en-US")
+	if err := library.Presynthesize(context.Background(), &fakeSynthesizer{}, DefaultAudioFormat()); err != nil {
+		t.Fatalf("Failed to pre-synthesize: %v", err)
+	}
+
+	phrase := library.Phrase("en-US", FailureEmptyResponse, 1, DefaultAudioFormat())
+	if string(phrase.Audio) != phrase.Text {
+		t.Errorf("Expected pre-synthesized audio for %q, got %q", phrase.Text, phrase.Audio)
+	}
+
+	other := AudioFormat{Encoding: EncodingLinear, SampleRate: 16000, Channels: 1}
+	if audio := library.Phrase("en-US", FailureEmptyResponse, 1, other).Audio; audio != nil {
+		t.Errorf("Expected no audio for a format that wasn't pre-synthesized, got %d bytes", len(audio))
+	}
+}
+
+func TestFallbackLibraryPlaysCachedAudioWithoutTTS(t *testing.T) {
+	dir := t.TempDir()
+	first := NewFallbackLibrary("en-US")
+	if err := first.SetAudioDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Presynthesize(context.Background(), &fakeSynthesizer{}, DefaultAudioFormat()); err != nil {
+		t.Fatalf("Failed to pre-synthesize: %v", err)
+	}
+
+	// A later start with TTS down plays the cached files
+	second := NewFallbackLibrary("en-US")
+	second.SetAudioDir(dir)
+	broken := &fakeSynthesizer{err: errors.New("tts unavailable")}
+	if err := second.Presynthesize(context.Background(), broken, DefaultAudioFormat()); err != nil {
+		t.Fatalf("Expected every phrase to load from disk, got %v", err)
+	}
+	phrase := second.Phrase("en-US", FailureSynthesis, 0, DefaultAudioFormat())
+	if string(phrase.Audio) != phrase.Text {
+		t.Errorf("Expected cached audio for %q, got %q", phrase.Text, phrase.Audio)
+	}
+}
+
+func TestTurnEnginePlaysFallbackAudioWhenSynthesisFails(t *testing.T) {
+	library := NewFallbackLibrary("en-US")
+	if err := library.Presynthesize(context.Background(), &fakeSynthesizer{}, DefaultAudioFormat()); err != nil {
+		t.Fatal(err)
+	}
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	engine := NewTurnEngine(channels, conversation, &fakeGenerator{replies: map[string]string{}}, &fakeSynthesizer{err: errors.New("tts unavailable")})
+	engine.Fallbacks = library
+
+	turn := engine.ProcessTranscription(context.Background(), "hello")
+
+	want := defaultFallbackPhrases["en-US"][FailureSynthesis][0]
+	if string(turn.Audio) != want {
+		t.Errorf("Expected the pre-synthesized apology, got %q", turn.Audio)
+	}
+	if got := <-channels.ResponseAudioChan; string(got) != want {
+		t.Errorf("Expected the apology sent to the call, got %q", got)
+	}
+}
+
+// slowGenerator is a ResponseGenerator taking its time to answer
+type slowGenerator struct {
+	delay time.Duration
+}
+
+func (s slowGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	time.Sleep(s.delay)
+	return "That sounds exhausting.", nil
+}
+
+func TestTurnEnginePlaysBackchannelWhileResponseIsSlow(t *testing.T) {
+	library := NewFallbackLibrary("en-US")
+	if err := library.Presynthesize(context.Background(), &fakeSynthesizer{}, DefaultAudioFormat()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		delay time.Duration
+		want  []string
+	}{
+		{100 * time.Millisecond, []string{defaultFallbackPhrases["en-US"][PhraseBackchannel][0], "That sounds exhausting."}},
+		{0, []string{"That sounds exhausting."}},
+	} {
+		channels := NewChannelManager().CreateChannels("test-call")
+		conversation := NewConversationService().GetOrCreateConversation("test-call")
+		engine := NewTurnEngine(channels, conversation, slowGenerator{tc.delay}, &fakeSynthesizer{})
+		engine.Fallbacks = library
+		engine.BackchannelDelay = 30 * time.Millisecond
+
+		engine.ProcessTranscription(context.Background(), "I haven't slept in days")
+		close(channels.ResponseAudioChan)
+		var got []string
+		for audio := range channels.ResponseAudioChan {
+			got = append(got, string(audio))
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%v response: expected %q played, got %q", tc.delay, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	"golang.org/x/oauth2/google"
+)
+
+// firestoreScope is the OAuth scope of the Cloud Firestore API
+const firestoreScope = "https://www.googleapis.com/auth/datastore"
+
+// Firestore collections, each after the configured prefix
+const (
+	firestoreConversations = "conversations"
+	firestoreCallers       = "callers"
+	firestoreCallbacks     = "callbacks"
+)
+
+// FirestoreStore keeps session records, callers' profiles and the callback queue in Cloud
+// Firestore through its REST API, authenticating with the application default
+// credentials. Each is one document whose content is the JSON record, encrypted when a cipher is configured, next
+// to a few plain fields to find documents by in the console.
+type FirestoreStore struct {
+	client   *http.Client
+	endpoint string // Documents root of the database
+	prefix   string // Prepended to collection names
+	cipher   *ContentCipher
+	log      *logger.Logger
+}
+
+// NewFirestoreStore creates a store on the project's database, "(default)" when empty,
+// prefixing its collections with prefix
+func NewFirestoreStore(ctx context.Context, projectID, database, prefix string, cipher *ContentCipher) (*FirestoreStore, error) {
+	log := logger.Component("Firestore")
+	if projectID == "" {
+		return nil, errors.New("GOOGLE_PROJECT_ID is required for the Firestore storage backend")
+	}
+	if database == "" {
+		database = "(default)"
+	}
+
+	client, err := google.DefaultClient(ctx, firestoreScope)
+	if err != nil {
+		log.Error("Error creating Firestore client: %v", err)
+		return nil, err
+	}
+	client.Timeout = 10 * time.Second
+
+	log.Info("Storing conversations and caller profiles in Firestore database %s of %s", database, projectID)
+	return &FirestoreStore{
+		client:   client,
+		endpoint: fmt.Sprintf("https://firestore.googleapis.com/v1/projects/%s/databases/%s/documents", projectID, url.PathEscape(database)),
+		prefix:   prefix,
+		cipher:   cipher,
+		log:      log,
+	}, nil
+}
+
+// WithPrefix returns a store whose collections also carry the prefix, e.g. a tenant's,
+// after the configured one
+func (s *FirestoreStore) WithPrefix(prefix string) TranscriptStore {
+	prefixed := *s
+	prefixed.prefix = s.prefix + prefix
+	return &prefixed
+}
+
+// Encrypted reports whether records are encrypted as they are saved
+func (s *FirestoreStore) Encrypted() bool {
+	return s != nil && s.cipher != nil
+}
+
+// firestoreValue is a typed Firestore field value; exactly one field is set
+type firestoreValue struct {
+	StringValue    *string `json:"stringValue,omitempty"`
+	TimestampValue *string `json:"timestampValue,omitempty"`
+	BooleanValue   *bool   `json:"booleanValue,omitempty"`
+	BytesValue     *string `json:"bytesValue,omitempty"` // Base64
+}
+
+// firestoreDocument is a document as read and written by the REST API
+type firestoreDocument struct {
+	Fields map[string]firestoreValue `json:"fields"`
+}
+
+// Save writes the conversation's session record, replacing any earlier one of the call
+func (s *FirestoreStore) Save(conv *Conversation) error {
+	record := NewTranscriptExport(conv)
+	content, err := s.seal(record, conv.ID)
+	if err != nil {
+		return fmt.Errorf("session record of call %s: %w", conv.ID, err)
+	}
+	startedAt := record.StartedAt.UTC().Format(time.RFC3339Nano)
+	encrypted := s.cipher != nil
+	doc := firestoreDocument{Fields: map[string]firestoreValue{
+		"callSid":   {StringValue: &record.CallSID},
+		"startedAt": {TimestampValue: &startedAt},
+		"encrypted": {BooleanValue: &encrypted},
+		"content":   {BytesValue: &content},
+	}}
+	if record.CallerHash != "" {
+		doc.Fields["callerHash"] = firestoreValue{StringValue: &record.CallerHash}
+	}
+	if err := s.put(context.Background(), firestoreConversations, conv.ID, doc); err != nil {
+		return err
+	}
+	s.log.Info("Stored call %s (encrypted: %v)", conv.ID, encrypted)
+	return nil
+}
+
+// Load reads the session record of a call; ok is false when it wasn't saved
+func (s *FirestoreStore) Load(callSID string) (record TranscriptExport, ok bool, err error) {
+	if s == nil {
+		return record, false, nil
+	}
+	ok, err = s.get(context.Background(), firestoreConversations, callSID, &record)
+	if err != nil {
+		return record, false, fmt.Errorf("session record of call %s: %w", callSID, err)
+	}
+	return record, ok, nil
+}
+
+// List reads the session records passing the query's filters; a caller's are queried by
+// their hash, the other filters are applied as the records are read. Records that can't
+// be read are logged and skipped.
+func (s *FirestoreStore) List(query ConversationQuery) ([]TranscriptExport, error) {
+	if s == nil {
+		return nil, nil
+	}
+	structured := map[string]any{
+		"from": []map[string]string{{"collectionId": s.prefix + firestoreConversations}},
+	}
+	if query.CallerHash != "" {
+		structured["where"] = map[string]any{"fieldFilter": map[string]any{
+			"field": map[string]string{"fieldPath": "callerHash"},
+			"op":    "EQUAL",
+			"value": firestoreValue{StringValue: &query.CallerHash},
+		}}
+	}
+	body, err := json.Marshal(map[string]any{"structuredQuery": structured})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.endpoint+":runQuery", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.log.Error("Error calling Firestore API: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		s.log.Error("Firestore API returned status %d querying %s: %s", resp.StatusCode, firestoreConversations, msg)
+		return nil, fmt.Errorf("firestore: unexpected status %d", resp.StatusCode)
+	}
+
+	// Each result holds a document, except a lone one holding only the read time when none match
+	var results []struct {
+		Document *firestoreDocument `json:"document"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	var records []TranscriptExport
+	for _, result := range results {
+		if result.Document == nil {
+			continue
+		}
+		var callSID string
+		if value := result.Document.Fields["callSid"].StringValue; value != nil {
+			callSID = *value
+		}
+		var record TranscriptExport
+		if err := s.open(*result.Document, callSID, &record); err != nil {
+			s.log.Warn("Skipping stored call %s: %v", callSID, err)
+			continue
+		}
+		if query.MatchesRecord(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// SaveProfile writes the caller's profile, replacing any earlier one
+func (s *FirestoreStore) SaveProfile(profile *CallerProfile) error {
+	content, err := s.seal(profile.Snapshot(), profile.CallerHash)
+	if err != nil {
+		return fmt.Errorf("profile of caller %s: %w", profile.CallerHash, err)
+	}
+	updatedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	encrypted := s.cipher != nil
+	doc := firestoreDocument{Fields: map[string]firestoreValue{
+		"callerHash": {StringValue: &profile.CallerHash},
+		"updatedAt":  {TimestampValue: &updatedAt},
+		"encrypted":  {BooleanValue: &encrypted},
+		"content":    {BytesValue: &content},
+	}}
+	return s.put(context.Background(), firestoreCallers, profile.CallerHash, doc)
+}
+
+// LoadProfile reads a caller's profile; ok is false when it wasn't saved
+func (s *FirestoreStore) LoadProfile(callerHash string) (*CallerProfile, bool, error) {
+	if s == nil {
+		return nil, false, nil
+	}
+	var snapshot CallerProfileSnapshot
+	ok, err := s.get(context.Background(), firestoreCallers, callerHash, &snapshot)
+	if err != nil || !ok {
+		if err != nil {
+			err = fmt.Errorf("profile of caller %s: %w", callerHash, err)
+		}
+		return nil, false, err
+	}
+	return RestoreCallerProfile(snapshot), true, nil
+}
+
+// SaveCallbacks writes the callback queue as one document, replacing the one saved before
+func (s *FirestoreStore) SaveCallbacks(offers []CallbackOffer) error {
+	content, err := s.seal(offers, callbackQueueID)
+	if err != nil {
+		return fmt.Errorf("callback queue: %w", err)
+	}
+	updatedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	encrypted := s.cipher != nil
+	doc := firestoreDocument{Fields: map[string]firestoreValue{
+		"updatedAt": {TimestampValue: &updatedAt},
+		"encrypted": {BooleanValue: &encrypted},
+		"content":   {BytesValue: &content},
+	}}
+	return s.put(context.Background(), firestoreCallbacks, callbackQueueID, doc)
+}
+
+// LoadCallbacks reads the saved callback queue
+func (s *FirestoreStore) LoadCallbacks() ([]CallbackOffer, error) {
+	if s == nil {
+		return nil, nil
+	}
+	var offers []CallbackOffer
+	if _, err := s.get(context.Background(), firestoreCallbacks, callbackQueueID, &offers); err != nil {
+		return nil, fmt.Errorf("callback queue: %w", err)
+	}
+	return offers, nil
+}
+
+// seal encodes the value as JSON, encrypts it bound to the document ID when there is a
+// cipher, and returns it as base64 for a bytes field
+func (s *FirestoreStore) seal(v any, id string) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if s.cipher != nil {
+		if data, err = s.cipher.Seal(data, []byte(id)); err != nil {
+			return "", fmt.Errorf("failed to encrypt: %w", err)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// documentURL is the URL of a document in one of the store's collections
+func (s *FirestoreStore) documentURL(collection, id string) string {
+	return s.endpoint + "/" + url.PathEscape(s.prefix+collection) + "/" + url.PathEscape(id)
+}
+
+// put creates or replaces a document
+func (s *FirestoreStore) put(ctx context.Context, collection, id string, doc firestoreDocument) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, s.documentURL(collection, id), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.log.Error("Error calling Firestore API: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		s.log.Error("Firestore API returned status %d writing %s/%s: %s", resp.StatusCode, collection, id, msg)
+		return fmt.Errorf("firestore: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// get reads a document's content into v, decrypting it if it was encrypted; ok is false
+// when there is no such document
+func (s *FirestoreStore) get(ctx context.Context, collection, id string, v any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.documentURL(collection, id), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.log.Error("Error calling Firestore API: %v", err)
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		s.log.Error("Firestore API returned status %d reading %s/%s: %s", resp.StatusCode, collection, id, msg)
+		return false, fmt.Errorf("firestore: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc firestoreDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return false, err
+	}
+	if err := s.open(doc, id, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// open decodes a document's content into v, decrypting it if it was encrypted
+func (s *FirestoreStore) open(doc firestoreDocument, id string, v any) error {
+	content := doc.Fields["content"].BytesValue
+	if content == nil {
+		return errors.New("document has no content")
+	}
+	data, err := base64.StdEncoding.DecodeString(*content)
+	if err != nil {
+		return fmt.Errorf("document content is corrupt: %w", err)
+	}
+	if data, err = s.cipher.Open(data, []byte(id)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("document content is corrupt: %w", err)
+	}
+	return nil
+}
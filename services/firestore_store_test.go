@@ -0,0 +1,168 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// fakeFirestore keeps documents written through the REST API in memory, by path
+type fakeFirestore struct {
+	docs map[string]string
+	mu   sync.Mutex
+}
+
+func (f *fakeFirestore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPatch:
+		body, _ := io.ReadAll(r.Body)
+		f.docs[r.URL.Path] = string(body)
+		w.Write(body)
+	case http.MethodPost:
+		f.runQuery(w, r)
+	case http.MethodGet:
+		doc, ok := f.docs[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"error": {"code": 404, "status": "NOT_FOUND"}}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(doc))
+	default:
+		http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+	}
+}
+
+// runQuery answers a structured query on a collection, with an optional string equality filter
+func (f *fakeFirestore) runQuery(w http.ResponseWriter, r *http.Request) {
+	root, ok := strings.CutSuffix(r.URL.Path, ":runQuery")
+	if !ok {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		StructuredQuery struct {
+			From []struct {
+				CollectionID string `json:"collectionId"`
+			} `json:"from"`
+			Where struct {
+				FieldFilter struct {
+					Field struct {
+						FieldPath string `json:"fieldPath"`
+					} `json:"field"`
+					Value firestoreValue `json:"value"`
+				} `json:"fieldFilter"`
+			} `json:"where"`
+		} `json:"structuredQuery"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	filter := body.StructuredQuery.Where.FieldFilter
+
+	results := []map[string]json.RawMessage{}
+	for path, doc := range f.docs {
+		if !strings.HasPrefix(path, root+"/"+body.StructuredQuery.From[0].CollectionID+"/") {
+			continue
+		}
+		var parsed firestoreDocument
+		json.Unmarshal([]byte(doc), &parsed)
+		if field := filter.Field.FieldPath; field != "" {
+			value := parsed.Fields[field].StringValue
+			if value == nil || *value != *filter.Value.StringValue {
+				continue
+			}
+		}
+		results = append(results, map[string]json.RawMessage{"document": json.RawMessage(doc)})
+	}
+	if len(results) == 0 {
+		results = append(results, map[string]json.RawMessage{"readTime": json.RawMessage(`"2024-03-01T12:00:00Z"`)})
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// newTestFirestore creates a store against a fake Firestore, without default credentials
+func newTestFirestore(t *testing.T, cipher *ContentCipher) (*FirestoreStore, *fakeFirestore) {
+	t.Helper()
+	fake := &fakeFirestore{docs: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &FirestoreStore{
+		client:   server.Client(),
+		endpoint: server.URL + "/v1/projects/p/databases/(default)/documents",
+		prefix:   "test_",
+		cipher:   cipher,
+		log:      logger.Component("Firestore"),
+	}, fake
+}
+
+func TestFirestoreStoreKeepsEncryptedSessionRecords(t *testing.T) {
+	store, fake := newTestFirestore(t, testCipher(t, 1))
+	if err := store.Save(exportConversation()); err != nil {
+		t.Fatalf("Expected the call stored, got %v", err)
+	}
+
+	doc, ok := fake.docs["/v1/projects/p/databases/(default)/documents/test_conversations/CA123"]
+	if !ok {
+		t.Fatalf("Expected the record in the prefixed conversations collection, got %v", fake.docs)
+	}
+	var fields struct {
+		Fields map[string]map[string]any `json:"fields"`
+	}
+	json.Unmarshal([]byte(doc), &fields)
+	if fields.Fields["callSid"]["stringValue"] != "CA123" || fields.Fields["encrypted"]["booleanValue"] != true || fields.Fields["startedAt"]["timestampValue"] == nil {
+		t.Errorf("Expected the plain fields set, got %v", fields.Fields)
+	}
+	if strings.Contains(doc, "sleep") {
+		t.Errorf("Expected the content encrypted, got %s", doc)
+	}
+
+	record, ok, err := store.Load("CA123")
+	if err != nil || !ok {
+		t.Fatalf("Expected the record read back, got %v, %v", ok, err)
+	}
+	if len(record.Messages) != 2 || record.Messages[0].Text != "I can't sleep (again)." || record.Persona != "calm" {
+		t.Errorf("Expected the record decrypted, got %+v", record)
+	}
+	if _, ok, err := store.Load("CA404"); ok || err != nil {
+		t.Errorf("Expected an unknown call not stored, got %v, %v", ok, err)
+	}
+
+	other, _ := newTestFirestore(t, testCipher(t, 2))
+	other.endpoint = store.endpoint
+	if _, _, err := other.Load("CA123"); err == nil {
+		t.Error("Expected another key to fail decrypting the record")
+	}
+}
+
+func TestFirestoreStoreListsRecords(t *testing.T) {
+	store, _ := newTestFirestore(t, testCipher(t, 1))
+	checkStoreLists(t, store)
+}
+
+func TestFirestoreStoreRestoresCallerProfiles(t *testing.T) {
+	store, _ := newTestFirestore(t, nil)
+	service := NewConversationService()
+	service.SetProfileStore(store)
+	conv := service.GetOrCreateConversation("CA1")
+	profile := service.AttachCaller(conv, "hash")
+	conv.FlagRisk("self_harm")
+	service.SaveProfile(profile)
+
+	// After a restart, the caller is recognized from the stored profile
+	restarted := NewConversationService()
+	restarted.SetProfileStore(store)
+	restored := restarted.AttachCaller(restarted.GetOrCreateConversation("CA2"), "hash")
+	snapshot := restored.Snapshot()
+	if len(snapshot.Calls) != 2 || snapshot.Calls[0].CallSID != "CA1" || len(snapshot.RiskHistory) != 1 {
+		t.Errorf("Expected the earlier call and risk restored, got %+v", snapshot)
+	}
+	if _, ok := restarted.CallerProfile("unknown"); ok {
+		t.Error("Expected no profile for an unknown caller")
+	}
+}
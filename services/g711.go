@@ -0,0 +1,138 @@
+package services
+
+// G.711 μ-law and A-law codecs used by the telephony audio path
+
+const (
+	mulawBias = 0x84
+	mulawClip = 32635
+)
+
+// MulawDecode converts a μ-law byte to a 16-bit linear PCM sample
+func MulawDecode(u byte) int16 {
+	u = ^u
+	sign := u & 0x80
+	exponent := (u >> 4) & 0x07
+	mantissa := u & 0x0F
+	sample := ((int(mantissa) << 3) + mulawBias) << exponent
+	sample -= mulawBias
+	if sign != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// MulawEncode converts a 16-bit linear PCM sample to a μ-law byte
+func MulawEncode(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		sign = 0x80
+		s = -s
+	}
+	if s > mulawClip {
+		s = mulawClip
+	}
+	s += mulawBias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+	return ^byte(sign | (exponent << 4) | mantissa)
+}
+
+// AlawDecode converts an A-law byte to a 16-bit linear PCM sample
+func AlawDecode(a byte) int16 {
+	a ^= 0x55
+	sign := a & 0x80
+	exponent := (a >> 4) & 0x07
+	mantissa := int(a & 0x0F)
+
+	var sample int
+	if exponent == 0 {
+		sample = (mantissa << 4) + 8
+	} else {
+		sample = ((mantissa << 4) + 0x108) << (exponent - 1)
+	}
+	if sign == 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// AlawEncode converts a 16-bit linear PCM sample to an A-law byte
+func AlawEncode(sample int16) byte {
+	s := int(sample) >> 3
+	mask := byte(0xD5)
+	if s < 0 {
+		mask = 0x55
+		s = -s - 1
+	}
+
+	segment := 0
+	for end := 0x1F; segment < 8 && s > end; end = end<<1 | 1 {
+		segment++
+	}
+	if segment >= 8 {
+		return 0x7F ^ mask
+	}
+
+	value := byte(segment << 4)
+	if segment < 2 {
+		value |= byte(s>>1) & 0x0F
+	} else {
+		value |= byte(s>>segment) & 0x0F
+	}
+	return value ^ mask
+}
+
+// DecodeSamples converts a payload in the given format to linear PCM samples
+func DecodeSamples(payload []byte, format AudioFormat) []int16 {
+	switch format.Normalize().Encoding {
+	case EncodingLinear:
+		// Twilio's audio/l16 is big-endian
+		samples := make([]int16, len(payload)/2)
+		for i := range samples {
+			samples[i] = int16(uint16(payload[2*i])<<8 | uint16(payload[2*i+1]))
+		}
+		return samples
+	case EncodingAlaw:
+		samples := make([]int16, len(payload))
+		for i, b := range payload {
+			samples[i] = AlawDecode(b)
+		}
+		return samples
+	default:
+		samples := make([]int16, len(payload))
+		for i, b := range payload {
+			samples[i] = MulawDecode(b)
+		}
+		return samples
+	}
+}
+
+// EncodeSamples converts linear PCM samples back to a payload in the given format
+func EncodeSamples(samples []int16, format AudioFormat) []byte {
+	switch format.Normalize().Encoding {
+	case EncodingLinear:
+		payload := make([]byte, len(samples)*2)
+		for i, s := range samples {
+			payload[2*i] = byte(uint16(s) >> 8)
+			payload[2*i+1] = byte(s)
+		}
+		return payload
+	case EncodingAlaw:
+		payload := make([]byte, len(samples))
+		for i, s := range samples {
+			payload[i] = AlawEncode(s)
+		}
+		return payload
+	default:
+		payload := make([]byte, len(samples))
+		for i, s := range samples {
+			payload[i] = MulawEncode(s)
+		}
+		return payload
+	}
+}
@@ -1,26 +1,58 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghophp/call-me-help/config"
 	"github.com/ghophp/call-me-help/logger"
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// genaiHarmCategories and genaiHarmThresholds map the REST API's names to the SDK's
+var (
+	genaiHarmCategories = map[string]genai.HarmCategory{
+		"HARM_CATEGORY_HARASSMENT":        genai.HarmCategoryHarassment,
+		"HARM_CATEGORY_HATE_SPEECH":       genai.HarmCategoryHateSpeech,
+		"HARM_CATEGORY_SEXUALLY_EXPLICIT": genai.HarmCategorySexuallyExplicit,
+		"HARM_CATEGORY_DANGEROUS_CONTENT": genai.HarmCategoryDangerousContent,
+	}
+	genaiHarmThresholds = map[string]genai.HarmBlockThreshold{
+		"BLOCK_LOW_AND_ABOVE":    genai.HarmBlockLowAndAbove,
+		"BLOCK_MEDIUM_AND_ABOVE": genai.HarmBlockMediumAndAbove,
+		"BLOCK_ONLY_HIGH":        genai.HarmBlockOnlyHigh,
+		"BLOCK_NONE":             genai.HarmBlockNone,
+	}
+)
+
 // GeminiService handles generation of AI responses using Google's Gemini
 type GeminiService struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
-	config *config.Config
-	log    *logger.Logger
+	client  *genai.Client
+	prompts *PromptStore
+	params  *GenerationSettings
+	config  *config.Config
+	log     *logger.Logger
+
+	// The SDK predates function calling, so tool turns use the REST API with the API key
+	apiKey     string
+	keyMu      sync.RWMutex // Guards the client and API key, swapped when the key rotates
+	httpClient *http.Client
+	restModels string // Models collection URL, the model and method are appended
 }
 
-// NewGeminiService creates a new Gemini service
-func NewGeminiService(ctx context.Context) (*GeminiService, error) {
+// NewGeminiService creates a new Gemini service whose system prompt is rendered from the
+// store's templates; a nil store uses the built-in prompt
+func NewGeminiService(ctx context.Context, prompts *PromptStore, generation *GenerationSettings) (*GeminiService, error) {
 	cfg := config.Load()
 	log := logger.Component("Gemini")
 
@@ -56,114 +88,309 @@ func NewGeminiService(ctx context.Context) (*GeminiService, error) {
 		log.Info("Gemini client created successfully using default credentials")
 	}
 
-	// Create a model instance
-	model := client.GenerativeModel("gemini-1.5-pro")
-	log.Info("Using Gemini model: gemini-1.5-pro")
-
-	// Set temperature for more consistent responses
-	model.SetTemperature(0.4)
-	log.Debug("Set Gemini temperature to 0.4")
-
-	// Configure safety settings for therapeutic context
-	model.SafetySettings = []*genai.SafetySetting{
-		{
-			Category:  genai.HarmCategoryHarassment,
-			Threshold: genai.HarmBlockThreshold(2), // Medium threshold
-		},
-		{
-			Category:  genai.HarmCategoryHateSpeech,
-			Threshold: genai.HarmBlockThreshold(2), // Medium threshold
-		},
-		{
-			Category:  genai.HarmCategorySexuallyExplicit,
-			Threshold: genai.HarmBlockThreshold(2), // Medium threshold
-		},
-		{
-			Category:  genai.HarmCategoryDangerousContent,
-			Threshold: genai.HarmBlockThreshold(2), // Medium threshold
-		},
-	}
-	log.Debug("Configured Gemini safety settings with medium threshold (2)")
+	log.Info("Using Gemini model: %s", cfg.GeminiModel)
 
 	return &GeminiService{
-		client: client,
-		model:  model,
-		config: cfg,
-		log:    log,
+		client:     client,
+		prompts:    prompts,
+		params:     generation,
+		config:     cfg,
+		log:        log,
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+		restModels: geminiRESTURL,
 	}, nil
 }
 
 // Close closes the Gemini client
 func (g *GeminiService) Close() error {
 	g.log.Info("Closing Gemini client")
-	g.client.Close()
+	client, _ := g.credentials()
+	client.Close()
+	return nil
+}
+
+// credentials returns the client and API key in use
+func (g *GeminiService) credentials() (*genai.Client, string) {
+	g.keyMu.RLock()
+	defer g.keyMu.RUnlock()
+	return g.client, g.apiKey
+}
+
+// Probe lists the models the credentials can use
+func (g *GeminiService) Probe(ctx context.Context) error {
+	client, _ := g.credentials()
+	_, err := client.ListModels(ctx).Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
+}
+
+// SetAPIKey switches to a rotated API key. Responses being generated finish on the
+// previous client, which is closed once they have had time to.
+func (g *GeminiService) SetAPIKey(ctx context.Context, apiKey string) error {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		g.log.Error("Keeping the previous API key, failed to create a client with the new one: %v", err)
+		return err
+	}
+	g.keyMu.Lock()
+	previous := g.client
+	g.client, g.apiKey = client, apiKey
+	g.keyMu.Unlock()
+
+	time.AfterFunc(time.Minute, func() { previous.Close() })
+	g.log.Info("Switched to the rotated Gemini API key")
 	return nil
 }
 
 // GenerateResponse generates a therapeutic response based on user input and conversation history
 func (g *GeminiService) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	log := CallLogger(ctx, g.log)
 	startTime := time.Now()
-	g.log.Info("Generating response for message: %q", userMessage)
-
-	// Build the prompt with system instructions and conversation history
-	prompt := `You are a professional psychotherapist providing helpful, empathetic advice to someone who needs mental health support.
-Your responses should be supportive, non-judgmental, and focused on providing constructive guidance.
-Always maintain a calm, compassionate tone. Prioritize the person's well-being and safety.
-Never encourage harmful behaviors and suggest professional help when appropriate.
-Keep responses concise and conversational - suitable for speaking in a phone call.
-`
-
-	// Add conversation history to build context
-	promptWithHistory := prompt
-	for i, msg := range conversationHistory {
-		promptWithHistory += "\n" + msg
-		if i < len(conversationHistory)-5 {
-			// Only log the most recent 5 messages to avoid very long logs
-			continue
-		}
-		g.log.Debug("History[%d]: %s", i, msg)
-	}
+	log.Info("Generating response for message: %q", logger.Transcript(userMessage))
 
-	// Add the current user message
-	promptWithHistory += "\nUser: " + userMessage + "\nTherapist: "
+	// The SDK can't set a response schema, structured replies go through the REST API
+	_, apiKey := g.credentials()
+	if params := g.params.For(ctx); params.responseSchema != nil && apiKey != "" {
+		return g.generateStructured(ctx, params, userMessage, conversationHistory)
+	}
 
-	g.log.Debug("Built prompt with %d conversation history messages", len(conversationHistory))
+	promptWithHistory := g.buildPrompt(ctx, userMessage, conversationHistory)
 
 	// Create a timeout for the API call
-	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	genCtx, cancel := context.WithTimeout(outgoingCorrelation(ctx), 30*time.Second)
 	defer cancel()
 
 	// Generate the response
-	g.log.Debug("Calling Gemini API...")
-	resp, err := g.model.GenerateContent(genCtx, genai.Text(promptWithHistory))
+	log.Debug("Calling Gemini API...")
+	resp, err := g.modelFor(ctx).GenerateContent(genCtx, genai.Text(promptWithHistory))
 	callDuration := time.Since(startTime)
 
 	if err != nil {
-		g.log.Error("Gemini API error after %v: %v", callDuration, err)
+		log.Error("Gemini API error after %v: %v", callDuration, err)
 		return "", err
 	}
 
-	g.log.Debug("Gemini API call completed in %v", callDuration)
+	log.Debug("Gemini API call completed in %v", callDuration)
 
 	if len(resp.Candidates) == 0 {
-		g.log.Warn("Gemini returned no candidates")
+		log.Warn("Gemini returned no candidates")
 		return "I'm sorry, I couldn't generate a response. Could you please rephrase your question?", nil
 	}
 
-	g.log.Debug("Gemini returned %d candidates", len(resp.Candidates))
+	log.Debug("Gemini returned %d candidates", len(resp.Candidates))
 
 	if len(resp.Candidates[0].Content.Parts) == 0 {
-		g.log.Warn("Gemini returned empty content parts")
+		log.Warn("Gemini returned empty content parts")
 		return "I'm sorry, I couldn't generate a response. Could you please rephrase your question?", nil
 	}
 
 	// Extract the text response
 	response := resp.Candidates[0].Content.Parts[0].(genai.Text)
 	responseStr := string(response)
-	g.log.Info("Gemini response (%d chars): %q", len(responseStr), responseStr)
+	log.Info("Gemini response (%d chars): %q", len(responseStr), logger.Transcript(responseStr))
 
 	totalDuration := time.Since(startTime)
-	g.log.Debug("Total response generation completed in %v", totalDuration)
+	log.Debug("Total response generation completed in %v", totalDuration)
 
 	return responseStr, nil
 }
+
+// GenerateResponseStream generates a response like GenerateResponse, streaming it from the
+// model and calling onText with each piece of text as it arrives
+func (g *GeminiService) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	log := CallLogger(ctx, g.log)
+	startTime := time.Now()
+	log.Info("Streaming response for message: %q", logger.Transcript(userMessage))
+
+	promptWithHistory := g.buildPrompt(ctx, userMessage, conversationHistory)
+
+	// Create a timeout for the whole stream
+	genCtx, cancel := context.WithTimeout(outgoingCorrelation(ctx), 30*time.Second)
+	defer cancel()
+
+	log.Debug("Calling Gemini streaming API...")
+	iter := g.modelFor(ctx).GenerateContentStream(genCtx, genai.Text(promptWithHistory))
+
+	var response strings.Builder
+	chunks := 0
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Error("Gemini streaming error after %v and %d chunks: %v", time.Since(startTime), chunks, err)
+			return response.String(), err
+		}
+
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+		for _, part := range resp.Candidates[0].Content.Parts {
+			text, ok := part.(genai.Text)
+			if !ok || text == "" {
+				continue
+			}
+			if chunks == 0 {
+				log.Debug("First Gemini chunk received in %v", time.Since(startTime))
+			}
+			chunks++
+			response.WriteString(string(text))
+			onText(string(text))
+		}
+	}
+
+	responseStr := response.String()
+	if responseStr == "" {
+		log.Warn("Gemini stream returned no text")
+		return "", nil
+	}
+	log.Info("Gemini streamed response (%d chars in %d chunks, %v): %q", len(responseStr), chunks, time.Since(startTime), logger.Transcript(responseStr))
+	return responseStr, nil
+}
+
+// GenerateResponseWithTools generates a response like GenerateResponse, offering the model
+// the dispatcher's tools and sending the results of the calls it makes back to it. Without
+// an API key it generates without tools.
+func (g *GeminiService) GenerateResponseWithTools(ctx context.Context, userMessage string, conversationHistory []string, tools *ToolDispatcher) (string, error) {
+	log := CallLogger(ctx, g.log)
+	if _, apiKey := g.credentials(); apiKey == "" {
+		log.Warn("Gemini tools need GEMINI_API_KEY, generating without them")
+		return g.GenerateResponse(ctx, userMessage, conversationHistory)
+	}
+	log.Info("Generating response with %d tools for message: %q", len(tools.Tools()), logger.Transcript(userMessage))
+
+	// Tool calls add round trips, so the whole exchange shares a longer timeout
+	genCtx, cancel := context.WithTimeout(ctx, 45*time.Second)
+	defer cancel()
+
+	params := g.params.For(ctx)
+	reportModel(ctx, g.modelName(params))
+	return generateGeminiWithTools(genCtx, g.buildPrompt(ctx, userMessage, conversationHistory), tools, params, func(ctx context.Context, body []byte) (*geminiResponse, error) {
+		return g.generateREST(ctx, g.modelName(params), body)
+	}, log)
+}
+
+// generateStructured generates a reply held to the params' response schema
+func (g *GeminiService) generateStructured(ctx context.Context, params GenerationParams, userMessage string, conversationHistory []string) (string, error) {
+	log := CallLogger(ctx, g.log)
+	startTime := time.Now()
+	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	reportModel(ctx, g.modelName(params))
+	contents := []geminiContent{{Role: "user", Parts: []geminiPart{{Text: g.buildPrompt(ctx, userMessage, conversationHistory)}}}}
+	body, err := geminiRequestBody(contents, nil, params)
+	if err != nil {
+		return "", err
+	}
+	resp, err := g.generateREST(genCtx, g.modelName(params), body)
+	if err != nil {
+		log.Error("Gemini API error after %v: %v", time.Since(startTime), err)
+		return "", err
+	}
+	response := resp.text()
+	log.Info("Gemini structured response (%d chars, %v): %q", len(response), time.Since(startTime), logger.Transcript(response))
+	return response, nil
+}
+
+// modelName returns the params' model, or the configured one
+func (g *GeminiService) modelName(params GenerationParams) string {
+	if params.Model != "" {
+		return params.Model
+	}
+	return g.config.GeminiModel
+}
+
+// modelFor returns the model configured with the generation params of the call's persona
+func (g *GeminiService) modelFor(ctx context.Context) *genai.GenerativeModel {
+	log := CallLogger(ctx, g.log)
+	params := g.params.For(ctx)
+	reportModel(ctx, g.modelName(params))
+	client, _ := g.credentials()
+	model := client.GenerativeModel(g.modelName(params))
+	if params.Temperature != nil {
+		model.SetTemperature(float32(*params.Temperature))
+	}
+	if params.TopP != nil {
+		model.SetTopP(float32(*params.TopP))
+	}
+	if params.TopK > 0 {
+		model.SetTopK(int32(params.TopK))
+	}
+	if params.MaxOutputTokens > 0 {
+		model.SetMaxOutputTokens(int32(params.MaxOutputTokens))
+	}
+
+	// Configure safety settings for therapeutic context
+	for _, category := range geminiSafetyCategories {
+		model.SafetySettings = append(model.SafetySettings, &genai.SafetySetting{
+			Category:  genaiHarmCategories[category],
+			Threshold: genaiHarmThresholds[params.safetyThreshold(category)],
+		})
+	}
+	log.Debug("Using Gemini model %s with %s", g.modelName(params), params)
+	return model
+}
+
+// generateREST sends a request to the model's generateContent REST method
+func (g *GeminiService) generateREST(ctx context.Context, model string, body []byte) (*geminiResponse, error) {
+	log := CallLogger(ctx, g.log)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.restModels+model+":generateContent", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", "application/json")
+	_, apiKey := g.credentials()
+	req.Header.Set("X-Goog-Api-Key", apiKey)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Gemini returned status %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("gemini: unexpected status %d", resp.StatusCode)
+	}
+
+	var result geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// buildPrompt builds the prompt with system instructions and conversation history
+func (g *GeminiService) buildPrompt(ctx context.Context, userMessage string, conversationHistory []string) string {
+	log := CallLogger(ctx, g.log)
+	prompt := buildGeminiPrompt(systemPrompt(ctx, g.prompts, g.config), userMessage, conversationHistory, log)
+	reportPromptTokens(ctx, EstimateTokens(prompt))
+	return prompt
+}
+
+// buildGeminiPrompt renders a single prompt of the system instructions, the conversation
+// history and the user message, which Gemini continues as the therapist
+func buildGeminiPrompt(system, userMessage string, conversationHistory []string, log *logger.Logger) string {
+	// Only log the most recent 5 messages to avoid very long logs
+	for i := max(0, len(conversationHistory)-5); i < len(conversationHistory); i++ {
+		log.Debug("History[%d]: %s", i, conversationHistory[i])
+	}
+
+	var prompt strings.Builder
+	if err := conversationTemplate.Execute(&prompt, conversationPrompt{
+		System:      system,
+		History:     conversationHistory,
+		UserMessage: userMessage,
+	}); err != nil {
+		log.Error("Failed to render conversation prompt: %v", err)
+	}
+
+	log.Debug("Built prompt with %d conversation history messages", len(conversationHistory))
+	return prompt.String()
+}
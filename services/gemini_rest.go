@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// geminiRESTURL is the AI Studio Gemini API's model collection
+const geminiRESTURL = "https://generativelanguage.googleapis.com/v1beta/models/"
+
+// maxToolRounds bounds how many times a turn sends tool results back to Gemini
+const maxToolRounds = 3
+
+// geminiSafetyCategories are the harm categories Gemini requests set a block threshold for
+var geminiSafetyCategories = []string{
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+}
+
+// geminiFunctionCall is a tool call the model returned
+type geminiFunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// geminiFunctionResponse is the result of a tool call sent back to the model
+type geminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// geminiPart is a piece of content: text, a tool call or a tool result
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// geminiContent is a turn of a generateContent request or response
+type geminiContent struct {
+	Role  string       `json:"role"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiResponse is a generateContent response, or one chunk of a streamed one
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+}
+
+// text joins the text parts of the first candidate
+func (r *geminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// functionCalls returns the tool calls of the first candidate
+func (r *geminiResponse) functionCalls() []ToolCall {
+	if len(r.Candidates) == 0 {
+		return nil
+	}
+	var calls []ToolCall
+	for _, part := range r.Candidates[0].Content.Parts {
+		if part.FunctionCall != nil {
+			calls = append(calls, ToolCall{Name: part.FunctionCall.Name, Args: part.FunctionCall.Args})
+		}
+	}
+	return calls
+}
+
+// geminiRequestBody builds a generateContent request with the generation params, offering
+// the tools when there are any
+func geminiRequestBody(contents []geminiContent, tools []Tool, params GenerationParams) ([]byte, error) {
+	safety := make([]map[string]string, len(geminiSafetyCategories))
+	for i, category := range geminiSafetyCategories {
+		safety[i] = map[string]string{"category": category, "threshold": params.safetyThreshold(category)}
+	}
+	generation := map[string]interface{}{}
+	if params.Temperature != nil {
+		generation["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		generation["topP"] = *params.TopP
+	}
+	if params.TopK > 0 {
+		generation["topK"] = params.TopK
+	}
+	if params.MaxOutputTokens > 0 {
+		generation["maxOutputTokens"] = params.MaxOutputTokens
+	}
+	if params.responseSchema != nil {
+		generation["responseMimeType"] = "application/json"
+		generation["responseSchema"] = params.responseSchema
+	}
+	request := map[string]interface{}{
+		"contents":         contents,
+		"generationConfig": generation,
+		"safetySettings":   safety,
+	}
+
+	if len(tools) > 0 {
+		declarations := make([]map[string]interface{}, 0, len(tools))
+		for _, tool := range tools {
+			properties := make(map[string]interface{}, len(tool.Parameters))
+			required := []string{}
+			for _, param := range tool.Parameters {
+				properties[param.Name] = map[string]string{"type": "STRING", "description": param.Description}
+				if param.Required {
+					required = append(required, param.Name)
+				}
+			}
+			declarations = append(declarations, map[string]interface{}{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  map[string]interface{}{"type": "OBJECT", "properties": properties, "required": required},
+			})
+		}
+		request["tools"] = []map[string]interface{}{{"functionDeclarations": declarations}}
+	}
+	return json.Marshal(request)
+}
+
+// generateGeminiWithTools runs Gemini's function calling loop: the prompt is sent with the
+// tools, the calls the model returns are dispatched and their results sent back, until the
+// model answers in text
+func generateGeminiWithTools(ctx context.Context, prompt string, tools *ToolDispatcher, params GenerationParams, send func(ctx context.Context, body []byte) (*geminiResponse, error), log *logger.Logger) (string, error) {
+	startTime := time.Now()
+	contents := []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}}
+
+	for round := 0; ; round++ {
+		body, err := geminiRequestBody(contents, tools.Tools(), params)
+		if err != nil {
+			return "", err
+		}
+		resp, err := send(ctx, body)
+		if err != nil {
+			return "", err
+		}
+
+		calls := resp.functionCalls()
+		if len(calls) == 0 {
+			response := resp.text()
+			log.Info("Gemini response after %d tool rounds (%d chars, %v): %q", round, len(response), time.Since(startTime), logger.Transcript(response))
+			return response, nil
+		}
+		if round == maxToolRounds {
+			return "", errors.New("gemini: too many rounds of tool calls")
+		}
+
+		// Send the model's calls back with their results, in the order it made them
+		results := geminiContent{Role: "user"}
+		for _, call := range calls {
+			log.Info("Gemini called tool %s", call.Name)
+			results.Parts = append(results.Parts, geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     call.Name,
+				Response: tools.Dispatch(ctx, call),
+			}})
+		}
+		model := resp.Candidates[0].Content
+		model.Role = "model"
+		contents = append(contents, model, results)
+	}
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"golang.org/x/oauth2/google"
+)
+
+// vertexScope is the OAuth scope of the Vertex AI API
+const vertexScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// VertexGeminiService generates responses with Gemini on Vertex AI, authenticating with
+// the application default credentials, e.g. a service account, instead of an API key
+type VertexGeminiService struct {
+	config  *config.Config
+	client  *http.Client
+	prompts *PromptStore
+	params  *GenerationSettings
+	models  string // Publisher models URL, the model and method are appended
+	log     *logger.Logger
+}
+
+// NewVertexGeminiService creates a Gemini service on Vertex AI whose system prompt is
+// rendered from the store's templates; a nil store uses the built-in prompt
+func NewVertexGeminiService(ctx context.Context, cfg *config.Config, prompts *PromptStore, generation *GenerationSettings) (*VertexGeminiService, error) {
+	log := logger.Component("VertexGemini")
+	log.Info("Creating new Vertex AI Gemini service with model %s in %s", cfg.GeminiModel, cfg.VertexLocation)
+
+	if cfg.VertexProjectID == "" || cfg.VertexLocation == "" {
+		log.Error("VERTEX_PROJECT_ID (or GOOGLE_PROJECT_ID) or VERTEX_LOCATION environment variable not set")
+		return nil, errors.New("VERTEX_PROJECT_ID and VERTEX_LOCATION are required for Gemini on Vertex AI")
+	}
+	switch cfg.VertexRequestType {
+	case "", "dedicated", "shared":
+	default:
+		return nil, fmt.Errorf("unknown VERTEX_REQUEST_TYPE %q, want dedicated or shared", cfg.VertexRequestType)
+	}
+
+	client, err := google.DefaultClient(ctx, vertexScope)
+	if err != nil {
+		log.Error("Error creating Vertex AI client: %v", err)
+		return nil, err
+	}
+
+	return &VertexGeminiService{
+		config:  cfg,
+		client:  client,
+		prompts: prompts,
+		params:  generation,
+		models: fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/",
+			cfg.VertexLocation, cfg.VertexProjectID, cfg.VertexLocation),
+		log: log,
+	}, nil
+}
+
+// Close is a no-op; Vertex AI requests are stateless
+func (v *VertexGeminiService) Close() error {
+	v.log.Info("Closing Vertex AI Gemini service")
+	return nil
+}
+
+// Probe fetches the configured model
+func (v *VertexGeminiService) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.models+v.config.GeminiModel, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching model %s: unexpected status %d", v.config.GeminiModel, resp.StatusCode)
+	}
+	return nil
+}
+
+// GenerateResponse generates a therapeutic response based on user input and conversation history
+func (v *VertexGeminiService) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	log := CallLogger(ctx, v.log)
+	startTime := time.Now()
+	log.Info("Generating response for message: %q", logger.Transcript(userMessage))
+
+	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	params := v.params.For(ctx)
+	result, err := v.generate(genCtx, params, v.promptBody(genCtx, userMessage, conversationHistory, params))
+	if err != nil {
+		log.Error("Vertex AI error after %v: %v", time.Since(startTime), err)
+		return "", err
+	}
+
+	response := result.text()
+	log.Info("Vertex AI Gemini response (%d chars, %v): %q", len(response), time.Since(startTime), logger.Transcript(response))
+	return response, nil
+}
+
+// GenerateResponseStream generates a response like GenerateResponse, streaming it from the
+// model and calling onText with each piece of text as it arrives
+func (v *VertexGeminiService) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	log := CallLogger(ctx, v.log)
+	startTime := time.Now()
+	log.Info("Streaming response for message: %q", logger.Transcript(userMessage))
+
+	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	params := v.params.For(ctx)
+	resp, err := v.post(genCtx, params, ":streamGenerateContent?alt=sse", v.promptBody(genCtx, userMessage, conversationHistory, params))
+	if err != nil {
+		log.Error("Vertex AI error after %v: %v", time.Since(startTime), err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// With alt=sse each data line is a generateContent response holding the next text
+	var response strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+			return response.String(), fmt.Errorf("vertex ai: bad stream chunk: %w", err)
+		}
+		text := chunk.text()
+		if text == "" {
+			continue
+		}
+		if response.Len() == 0 {
+			log.Debug("First Vertex AI chunk received in %v", time.Since(startTime))
+		}
+		response.WriteString(text)
+		onText(text)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error("Vertex AI streaming error after %v: %v", time.Since(startTime), err)
+		return response.String(), err
+	}
+
+	log.Info("Vertex AI Gemini streamed response (%d chars, %v): %q", response.Len(), time.Since(startTime), logger.Transcript(response.String()))
+	return response.String(), nil
+}
+
+// GenerateResponseWithTools generates a response like GenerateResponse, offering the model
+// the dispatcher's tools and sending the results of the calls it makes back to it
+func (v *VertexGeminiService) GenerateResponseWithTools(ctx context.Context, userMessage string, conversationHistory []string, tools *ToolDispatcher) (string, error) {
+	log := CallLogger(ctx, v.log)
+	log.Info("Generating response with %d tools for message: %q", len(tools.Tools()), logger.Transcript(userMessage))
+
+	// Tool calls add round trips, so the whole exchange shares a longer timeout
+	genCtx, cancel := context.WithTimeout(ctx, 45*time.Second)
+	defer cancel()
+
+	params := v.params.For(ctx)
+	prompt := buildGeminiPrompt(systemPrompt(ctx, v.prompts, v.config), userMessage, conversationHistory, log)
+	reportPromptTokens(ctx, EstimateTokens(prompt))
+	return generateGeminiWithTools(genCtx, prompt, tools, params, func(ctx context.Context, body []byte) (*geminiResponse, error) {
+		return v.generate(ctx, params, body)
+	}, log)
+}
+
+// promptBody builds the request for the prompt with system instructions and conversation
+// history; a body that can't be built is left for the request to fail on
+func (v *VertexGeminiService) promptBody(ctx context.Context, userMessage string, conversationHistory []string, params GenerationParams) []byte {
+	log := CallLogger(ctx, v.log)
+	prompt := buildGeminiPrompt(systemPrompt(ctx, v.prompts, v.config), userMessage, conversationHistory, log)
+	reportPromptTokens(ctx, EstimateTokens(prompt))
+	body, _ := geminiRequestBody([]geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}}, nil, params)
+	return body
+}
+
+// generate sends a request to the model's generateContent method and decodes the response
+func (v *VertexGeminiService) generate(ctx context.Context, params GenerationParams, body []byte) (*geminiResponse, error) {
+	resp, err := v.post(ctx, params, ":generateContent", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// post sends a request body to a method of the params' model, or the configured one, and
+// checks the status
+func (v *VertexGeminiService) post(ctx context.Context, params GenerationParams, method string, body []byte) (*http.Response, error) {
+	log := CallLogger(ctx, v.log)
+	model := v.config.GeminiModel
+	if params.Model != "" {
+		model = params.Model
+	}
+	reportModel(ctx, model)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.models+model+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", "application/json")
+	if v.config.VertexRequestType != "" {
+		// Controls whether provisioned throughput is used
+		req.Header.Set("X-Vertex-AI-LLM-Request-Type", v.config.VertexRequestType)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Vertex AI returned status %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("vertex ai: unexpected status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
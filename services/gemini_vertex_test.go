@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// newTestVertexGemini creates the service against a test server, without default credentials
+func newTestVertexGemini(url string, cfg *config.Config) *VertexGeminiService {
+	return &VertexGeminiService{
+		config: cfg,
+		client: &http.Client{},
+		models: url + "/v1/projects/p/locations/us-central1/publishers/google/models/",
+		log:    logger.Component("VertexGemini"),
+	}
+}
+
+func TestVertexGeminiRequestsProvisionedThroughput(t *testing.T) {
+	var path, requestType string
+	var request struct {
+		Contents []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+		SafetySettings []map[string]string `json:"safetySettings"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, requestType = r.URL.Path, r.Header.Get("X-Vertex-AI-LLM-Request-Type")
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "That sounds "}, {"text": "hard."}]}}]}`))
+	}))
+	defer server.Close()
+
+	vertex := newTestVertexGemini(server.URL, &config.Config{GeminiModel: "gemini-test", VertexRequestType: "dedicated"})
+	response, err := vertex.GenerateResponse(context.Background(), "I lost my job", []string{"User: Hi", "Therapist: Hello."})
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if response != "That sounds hard." {
+		t.Errorf("Expected the parts joined, got %q", response)
+	}
+	if path != "/v1/projects/p/locations/us-central1/publishers/google/models/gemini-test:generateContent" {
+		t.Errorf("Expected the model's generateContent method, got %s", path)
+	}
+	if requestType != "dedicated" {
+		t.Errorf("Expected the provisioned throughput request type, got %q", requestType)
+	}
+	if len(request.Contents) != 1 || !strings.HasSuffix(request.Contents[0].Parts[0].Text, "User: Hi\nTherapist: Hello.\nUser: I lost my job\nTherapist: ") {
+		t.Errorf("Expected the prompt with history as the user content, got %+v", request.Contents)
+	}
+	if len(request.SafetySettings) != len(geminiSafetyCategories) {
+		t.Errorf("Expected the safety settings, got %+v", request.SafetySettings)
+	}
+}
+
+func TestVertexGeminiStreamsResponse(t *testing.T) {
+	var query, requestType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, requestType = r.URL.RawQuery, r.Header.Get("X-Vertex-AI-LLM-Request-Type")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"candidates\": [{\"content\": {\"parts\": [{\"text\": \"I hear \"}]}}]}\r\n\r\n"))
+		w.Write([]byte("data: {\"candidates\": [{\"content\": {\"parts\": [{\"text\": \"you.\"}]}, \"finishReason\": \"STOP\"}]}\r\n\r\n"))
+	}))
+	defer server.Close()
+
+	var chunks []string
+	vertex := newTestVertexGemini(server.URL, &config.Config{})
+	response, err := vertex.GenerateResponseStream(context.Background(), "hi", nil, func(text string) {
+		chunks = append(chunks, text)
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream: %v", err)
+	}
+	if query != "alt=sse" || requestType != "" {
+		t.Errorf("Expected an SSE stream without a request type, got %q %q", query, requestType)
+	}
+	if response != "I hear you." || !reflect.DeepEqual(chunks, []string{"I hear ", "you."}) {
+		t.Errorf("Expected the streamed pieces, got %q from %q", response, chunks)
+	}
+}
+
+func TestNewVertexGeminiServiceValidatesConfig(t *testing.T) {
+	if _, err := NewVertexGeminiService(context.Background(), &config.Config{VertexLocation: "us-central1"}, nil, nil); err == nil {
+		t.Error("Expected an error without a project")
+	}
+	cfg := &config.Config{VertexProjectID: "p", VertexLocation: "us-central1", VertexRequestType: "reserved"}
+	if _, err := NewVertexGeminiService(context.Background(), cfg, nil, nil); err == nil {
+		t.Error("Expected an error for an unknown request type")
+	}
+}
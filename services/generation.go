@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// geminiSafetyThresholds are the block thresholds Gemini accepts, most to least strict
+var geminiSafetyThresholds = []string{"BLOCK_LOW_AND_ABOVE", "BLOCK_MEDIUM_AND_ABOVE", "BLOCK_ONLY_HIGH", "BLOCK_NONE"}
+
+// defaultSafetyThreshold blocks harmful content at medium probability and above
+const defaultSafetyThreshold = "BLOCK_MEDIUM_AND_ABOVE"
+
+// GenerationParams tune how the LLM generates responses. Unset fields of a persona's
+// overrides keep the configured values; unset configured values use the provider's defaults.
+type GenerationParams struct {
+	// Model replaces the provider's configured model, e.g. a faster one for a persona
+	Model           string   `json:"model,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	TopK            int      `json:"topK,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	// SafetyThresholds maps Gemini harm categories, e.g. HARASSMENT, to block thresholds;
+	// categories left out block at medium probability and above
+	SafetyThresholds map[string]string `json:"safetyThresholds,omitempty"`
+	// responseSchema holds Gemini to a JSON structure, set by WithAgentReply
+	responseSchema *geminiSchema
+}
+
+// merge returns the params with the set fields of overrides replacing them
+func (p GenerationParams) merge(overrides GenerationParams) GenerationParams {
+	if overrides.Model != "" {
+		p.Model = overrides.Model
+	}
+	if overrides.Temperature != nil {
+		p.Temperature = overrides.Temperature
+	}
+	if overrides.TopP != nil {
+		p.TopP = overrides.TopP
+	}
+	if overrides.TopK > 0 {
+		p.TopK = overrides.TopK
+	}
+	if overrides.MaxOutputTokens > 0 {
+		p.MaxOutputTokens = overrides.MaxOutputTokens
+	}
+	if len(overrides.SafetyThresholds) > 0 {
+		thresholds := make(map[string]string, len(p.SafetyThresholds)+len(overrides.SafetyThresholds))
+		for category, threshold := range p.SafetyThresholds {
+			thresholds[category] = threshold
+		}
+		for category, threshold := range overrides.SafetyThresholds {
+			thresholds[category] = threshold
+		}
+		p.SafetyThresholds = thresholds
+	}
+	return p
+}
+
+// safetyThreshold returns the block threshold of a Gemini harm category, given with or
+// without its HARM_CATEGORY_ prefix
+func (p GenerationParams) safetyThreshold(category string) string {
+	if threshold, ok := p.SafetyThresholds[strings.TrimPrefix(category, "HARM_CATEGORY_")]; ok {
+		return threshold
+	}
+	return defaultSafetyThreshold
+}
+
+// validate checks the params are within the ranges the providers accept
+func (p GenerationParams) validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature %v is outside 0 to 2", *p.Temperature)
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("topP %v is outside 0 to 1", *p.TopP)
+	}
+	if p.TopK < 0 || p.MaxOutputTokens < 0 {
+		return fmt.Errorf("topK and maxOutputTokens can't be negative")
+	}
+	for category, threshold := range p.SafetyThresholds {
+		known := false
+		for _, c := range geminiSafetyCategories {
+			known = known || c == "HARM_CATEGORY_"+category
+		}
+		if !known {
+			return fmt.Errorf("unknown safety category %q", category)
+		}
+		valid := false
+		for _, t := range geminiSafetyThresholds {
+			valid = valid || t == threshold
+		}
+		if !valid {
+			return fmt.Errorf("unknown safety threshold %q for %s, want one of %s",
+				threshold, category, strings.Join(geminiSafetyThresholds, ", "))
+		}
+	}
+	return nil
+}
+
+// GenerationSettings holds the configured generation params and each persona's overrides
+type GenerationSettings struct {
+	defaults  GenerationParams
+	overrides map[string]GenerationParams // persona -> overrides
+}
+
+// LoadGenerationSettings reads the generation params from the config, and the persona
+// overrides from LLM_PERSONA_PARAMS_FILE, a JSON object of persona to GenerationParams,
+// or else the persona_params section of the config file
+func LoadGenerationSettings(cfg *config.Config) (*GenerationSettings, error) {
+	log := logger.Component("Generation")
+
+	temperature := cfg.LLMTemperature
+	defaults := GenerationParams{
+		Temperature:     &temperature,
+		TopK:            cfg.LLMTopK,
+		MaxOutputTokens: cfg.LLMMaxOutputTokens,
+	}
+	if cfg.LLMTopP > 0 {
+		topP := cfg.LLMTopP
+		defaults.TopP = &topP
+	}
+	thresholds, err := parseSafetyThresholds(cfg.GeminiSafetyThresholds)
+	if err != nil {
+		return nil, err
+	}
+	defaults.SafetyThresholds = thresholds
+	if err := defaults.validate(); err != nil {
+		return nil, fmt.Errorf("invalid generation config: %w", err)
+	}
+
+	settings := &GenerationSettings{defaults: defaults, overrides: make(map[string]GenerationParams)}
+	source, data := cfg.LLMPersonaParamsFile, []byte(cfg.LLMPersonaParams)
+	switch {
+	case source != "":
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, err
+		}
+	case len(data) > 0:
+		source = "the config file"
+	default:
+		return settings, nil
+	}
+
+	var overrides map[string]GenerationParams
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing persona generation params %s: %w", source, err)
+	}
+	for persona, params := range overrides {
+		if len(params.SafetyThresholds) > 0 {
+			normalized := make(map[string]string, len(params.SafetyThresholds))
+			for category, threshold := range params.SafetyThresholds {
+				category = strings.TrimPrefix(strings.ToUpper(category), "HARM_CATEGORY_")
+				normalized[category] = strings.ToUpper(threshold)
+			}
+			params.SafetyThresholds = normalized
+		}
+		if err := params.validate(); err != nil {
+			return nil, fmt.Errorf("invalid generation params for persona %s: %w", persona, err)
+		}
+		settings.overrides[strings.ToLower(persona)] = params
+	}
+
+	log.Info("Loaded generation params overrides for %d personas from %s", len(overrides), source)
+	return settings, nil
+}
+
+// For returns the generation params of the persona the context's call uses, with the
+// model asked for by WithModel, the cap asked for by WithMaxOutputTokens and the structure
+// asked for by WithAgentReply. Nil settings give the default temperature and otherwise
+// the provider's defaults.
+func (s *GenerationSettings) For(ctx context.Context) GenerationParams {
+	var params GenerationParams
+	if s == nil {
+		temperature := responseTemperature
+		params = GenerationParams{Temperature: &temperature}
+	} else {
+		persona := PersonaFromContext(ctx)
+		if persona == "" {
+			persona = DefaultPersona
+		}
+		params = s.defaults
+		if overrides, ok := s.overrides[strings.ToLower(persona)]; ok {
+			params = params.merge(overrides)
+		}
+	}
+	if model := modelFromContext(ctx); model != "" {
+		params.Model = model
+	}
+	if tokens := maxOutputTokensFromContext(ctx); tokens > 0 && (params.MaxOutputTokens == 0 || tokens < params.MaxOutputTokens) {
+		params.MaxOutputTokens = tokens
+	}
+	if agentReplyFromContext(ctx) {
+		params.responseSchema = agentReplySchema
+	}
+	return params
+}
+
+// parseSafetyThresholds parses GEMINI_SAFETY_THRESHOLDS: one threshold for every category,
+// e.g. BLOCK_ONLY_HIGH, or category=threshold pairs, e.g. HARASSMENT=BLOCK_ONLY_HIGH
+func parseSafetyThresholds(value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	thresholds := make(map[string]string)
+	if !strings.Contains(value, "=") {
+		for _, category := range geminiSafetyCategories {
+			thresholds[strings.TrimPrefix(category, "HARM_CATEGORY_")] = strings.ToUpper(value)
+		}
+		return thresholds, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		category, threshold, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid safety threshold %q, want category=threshold", pair)
+		}
+		category = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(category)), "HARM_CATEGORY_")
+		thresholds[category] = strings.ToUpper(strings.TrimSpace(threshold))
+	}
+	return thresholds, nil
+}
+
+// String describes the params for logs
+func (p GenerationParams) String() string {
+	var parts []string
+	if p.Model != "" {
+		parts = append(parts, "model="+p.Model)
+	}
+	if p.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%v", *p.Temperature))
+	}
+	if p.TopP != nil {
+		parts = append(parts, fmt.Sprintf("topP=%v", *p.TopP))
+	}
+	if p.TopK > 0 {
+		parts = append(parts, fmt.Sprintf("topK=%d", p.TopK))
+	}
+	if p.MaxOutputTokens > 0 {
+		parts = append(parts, fmt.Sprintf("maxOutputTokens=%d", p.MaxOutputTokens))
+	}
+	categories := make([]string, 0, len(p.SafetyThresholds))
+	for category := range p.SafetyThresholds {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		parts = append(parts, category+"="+p.SafetyThresholds[category])
+	}
+	return strings.Join(parts, " ")
+}
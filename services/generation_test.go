@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+func TestGenerationSettingsApplyPersonaOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.json")
+	overrides := `{"Crisis": {"model": "gemini-1.5-flash", "temperature": 0.1, "safetyThresholds": {"harm_category_harassment": "block_none"}}}`
+	if err := os.WriteFile(path, []byte(overrides), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		LLMTemperature:         0.6,
+		LLMTopK:                40,
+		GeminiSafetyThresholds: "BLOCK_ONLY_HIGH",
+		LLMPersonaParamsFile:   path,
+	}
+	settings, err := LoadGenerationSettings(cfg)
+	if err != nil {
+		t.Fatalf("Failed to load settings: %v", err)
+	}
+
+	params := settings.For(context.Background())
+	if params.Model != "" || *params.Temperature != 0.6 || params.TopK != 40 || params.safetyThreshold("HARM_CATEGORY_HARASSMENT") != "BLOCK_ONLY_HIGH" {
+		t.Errorf("Expected the configured params by default, got %s", params)
+	}
+
+	params = settings.For(WithPersona(context.Background(), "crisis"))
+	if params.Model != "gemini-1.5-flash" || *params.Temperature != 0.1 || params.TopK != 40 {
+		t.Errorf("Expected the persona's overrides over the configured params, got %s", params)
+	}
+	if params.safetyThreshold("HARM_CATEGORY_HARASSMENT") != "BLOCK_NONE" || params.safetyThreshold("HARM_CATEGORY_HATE_SPEECH") != "BLOCK_ONLY_HIGH" {
+		t.Errorf("Expected the persona's safety thresholds merged, got %s", params)
+	}
+}
+
+func TestLoadGenerationSettingsRejectsInvalidParams(t *testing.T) {
+	for _, cfg := range []*config.Config{
+		{LLMTemperature: 3},
+		{LLMTemperature: 0.4, LLMTopP: 1.5},
+		{LLMTemperature: 0.4, GeminiSafetyThresholds: "BLOCK_SOME"},
+		{LLMTemperature: 0.4, GeminiSafetyThresholds: "VIOLENCE=BLOCK_NONE"},
+		{LLMTemperature: 0.4, GeminiSafetyThresholds: "HARASSMENT"},
+	} {
+		if _, err := LoadGenerationSettings(cfg); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestVertexGeminiSendsGenerationParams(t *testing.T) {
+	var path string
+	var request struct {
+		GenerationConfig map[string]float64  `json:"generationConfig"`
+		SafetySettings   []map[string]string `json:"safetySettings"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"candidates": [{"content": {"parts": [{"text": "Okay."}]}}]}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{GeminiModel: "gemini-1.5-pro", LLMTemperature: 0.4, LLMTopP: 0.9, LLMMaxOutputTokens: 256,
+		GeminiSafetyThresholds: "DANGEROUS_CONTENT=BLOCK_LOW_AND_ABOVE"}
+	settings, err := LoadGenerationSettings(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.overrides["calm"] = GenerationParams{Model: "gemini-1.5-flash"}
+	vertex := newTestVertexGemini(server.URL, cfg)
+	vertex.params = settings
+
+	if _, err := vertex.GenerateResponse(WithPersona(context.Background(), "calm"), "hi", nil); err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if path != "/v1/projects/p/locations/us-central1/publishers/google/models/gemini-1.5-flash:generateContent" {
+		t.Errorf("Expected the persona's model, got %s", path)
+	}
+	want := map[string]float64{"temperature": 0.4, "topP": 0.9, "maxOutputTokens": 256}
+	for key, value := range want {
+		if request.GenerationConfig[key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, request.GenerationConfig)
+		}
+	}
+	for _, setting := range request.SafetySettings {
+		want := "BLOCK_MEDIUM_AND_ABOVE"
+		if setting["category"] == "HARM_CATEGORY_DANGEROUS_CONTENT" {
+			want = "BLOCK_LOW_AND_ABOVE"
+		}
+		if setting["threshold"] != want {
+			t.Errorf("Expected %s at %s, got %s", setting["category"], want, setting["threshold"])
+		}
+	}
+}
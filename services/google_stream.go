@@ -0,0 +1,307 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/speech/apiv2/speechpb"
+	"github.com/ghophp/call-me-help/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Google ends streaming recognition after 5 minutes with OUT_OF_RANGE, so streams
+// are rolled over a little earlier, at the first utterance boundary after streamRestartAfter,
+// or regardless of one at streamRestartBy
+const (
+	streamRestartAfter = 4*time.Minute + 30*time.Second
+	streamRestartBy    = 4*time.Minute + 50*time.Second
+	// maxReplayAudio caps the unrecognized audio replayed into a replacement stream
+	maxReplayAudio = 10 * time.Second
+	// Streams lost to transient errors are re-established with exponential backoff
+	streamRetryBase  = 250 * time.Millisecond
+	streamRetryMax   = 8 * time.Second
+	maxStreamRetries = 5
+)
+
+// googleRecognitionStream adapts Google streaming recognition to RecognitionStream,
+// opening a new stream and resending the config whenever the current one hits the limit
+type googleRecognitionStream struct {
+	open func() (speechpb.Speech_StreamingRecognizeClient, error)
+
+	mu        sync.Mutex
+	stream    speechpb.Speech_StreamingRecognizeClient
+	startedAt time.Time
+	streamErr error // Why the current stream stopped receiving, if it did
+	closed    bool
+
+	// Audio sent since the last final result, replayed if the stream dies mid-utterance
+	replay      [][]byte
+	replayBytes int
+	maxReplay   int
+
+	// Word offsets restart with every stream, so each is shifted by the audio sent before it
+	bytesPerSecond int
+	sentBytes      int
+
+	restartAfter time.Duration
+	restartBy    time.Duration
+	restarts     int
+
+	// Consecutive retries after transient errors, reset once a stream delivers results
+	retries    int
+	retryBase  time.Duration
+	maxRetries int
+	nextRetry  time.Time
+
+	listeners   sync.WaitGroup
+	transcripts chan Transcript
+	log         *logger.Logger
+}
+
+// newGoogleRecognitionStream opens the first stream with open, which also opens every replacement
+func newGoogleRecognitionStream(format AudioFormat, open func() (speechpb.Speech_StreamingRecognizeClient, error), log *logger.Logger) (*googleRecognitionStream, error) {
+	g := &googleRecognitionStream{
+		open:           open,
+		maxReplay:      int(maxReplayAudio.Seconds()) * format.BytesPerSecond(),
+		bytesPerSecond: format.BytesPerSecond(),
+		restartAfter:   streamRestartAfter,
+		restartBy:      streamRestartBy,
+		retryBase:      streamRetryBase,
+		maxRetries:     maxStreamRetries,
+		transcripts:    make(chan Transcript, 1024),
+		log:            log,
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.connect(0); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// connect opens a stream whose audio starts at offset and starts its listener; the caller must hold the lock
+func (g *googleRecognitionStream) connect(offset time.Duration) error {
+	stream, err := g.open()
+	if err != nil {
+		return err
+	}
+
+	g.stream = stream
+	g.startedAt = time.Now()
+	g.streamErr = nil
+
+	g.listeners.Add(1)
+	go g.listen(stream, offset)
+	return nil
+}
+
+// audioDuration converts a byte count of call audio to its duration
+func (g *googleRecognitionStream) audioDuration(bytes int) time.Duration {
+	return time.Duration(bytes) * time.Second / time.Duration(g.bytesPerSecond)
+}
+
+// listen forwards a stream's results until it ends
+func (g *googleRecognitionStream) listen(stream speechpb.Speech_StreamingRecognizeClient, offset time.Duration) {
+	defer g.listeners.Done()
+
+	err := receiveResults(stream, g.log, func(transcript Transcript) {
+		transcript.Words = shiftWords(transcript.Words, offset)
+		g.mu.Lock()
+		if stream == g.stream {
+			// The stream works, so later failures start backing off from scratch
+			g.retries = 0
+			if transcript.IsFinal {
+				// Everything so far is recognized, nothing to replay
+				g.replay = nil
+				g.replayBytes = 0
+			}
+		}
+		g.mu.Unlock()
+		g.transcripts <- transcript
+	})
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if stream == g.stream {
+		if err == nil {
+			err = errors.New("stream ended")
+		}
+		g.streamErr = err
+	}
+}
+
+// isStreamLimit reports whether an error is Google's stream duration limit
+func isStreamLimit(err error) bool {
+	return status.Code(err) == codes.OutOfRange
+}
+
+// isTransient reports whether an error is worth retrying with a new stream
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// retryDelay is the backoff before the given retry attempt
+func (g *googleRecognitionStream) retryDelay(attempt int) time.Duration {
+	delay := g.retryBase << (attempt - 1)
+	if delay > streamRetryMax || delay <= 0 {
+		delay = streamRetryMax
+	}
+	return delay
+}
+
+// retry re-establishes a stream lost to a transient error once the backoff has passed.
+// Audio that arrives meanwhile is kept for replay. The caller must hold the lock.
+func (g *googleRecognitionStream) retry(audio []byte) (buffered bool, err error) {
+	if time.Now().Before(g.nextRetry) {
+		g.remember(audio)
+		return true, nil
+	}
+	if g.retries >= g.maxRetries {
+		g.log.Error("Giving up on streaming recognition after %d retries: %v", g.retries, g.streamErr)
+		return false, fmt.Errorf("streaming recognition failed after %d retries: %w", g.retries, g.streamErr)
+	}
+
+	g.retries++
+	g.nextRetry = time.Now().Add(g.retryDelay(g.retries))
+	if err := g.restart(fmt.Sprintf("transient %s error, retry %d", status.Code(g.streamErr), g.retries)); err != nil {
+		if !isTransient(err) {
+			return false, err
+		}
+		g.log.Warn("Retrying streaming recognition in %v", time.Until(g.nextRetry).Round(time.Millisecond))
+		g.streamErr = err
+		g.remember(audio)
+		return true, nil
+	}
+	return false, nil
+}
+
+// restart rolls over to a new stream; the caller must hold the lock. Audio not yet
+// recognized is replayed when the old stream died, since it may have been lost.
+func (g *googleRecognitionStream) restart(reason string) error {
+	g.restarts++
+	g.log.Info("Restarting streaming recognition (%s), restart #%d after %v",
+		reason, g.restarts, time.Since(g.startedAt).Round(time.Second))
+
+	died := g.streamErr != nil
+	// Ending the old stream lets it finalize whatever it already heard
+	g.stream.CloseSend()
+
+	// Replayed audio is heard again by the new stream, so its offsets start where the replay does
+	offset := g.audioDuration(g.sentBytes)
+	if died {
+		offset = g.audioDuration(g.sentBytes - g.replayBytes)
+	}
+	if err := g.connect(offset); err != nil {
+		g.log.Error("Failed to restart streaming recognition: %v", err)
+		return err
+	}
+
+	if !died {
+		g.replay = nil
+		g.replayBytes = 0
+		return nil
+	}
+
+	g.log.Info("Replaying %d bytes of unrecognized audio", g.replayBytes)
+	for _, chunk := range g.replay {
+		if err := g.send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send writes audio to the current stream; the caller must hold the lock
+func (g *googleRecognitionStream) send(audio []byte) error {
+	return g.stream.Send(&speechpb.StreamingRecognizeRequest{
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_Audio{
+			Audio: audio,
+		},
+	})
+}
+
+func (g *googleRecognitionStream) SendAudio(audio []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return errors.New("recognition stream closed")
+	}
+
+	switch {
+	case g.streamErr != nil && isStreamLimit(g.streamErr):
+		if err := g.restart("stream limit reached"); err != nil {
+			return err
+		}
+	case g.streamErr != nil && isTransient(g.streamErr):
+		buffered, err := g.retry(audio)
+		if buffered || err != nil {
+			return err
+		}
+	case g.streamErr != nil:
+		return g.streamErr
+	case time.Since(g.startedAt) >= g.restartAfter && g.replayBytes == 0:
+		// A final result covered all the audio sent, so the caller is between utterances
+		if err := g.restart("approaching stream limit"); err != nil {
+			return err
+		}
+	case time.Since(g.startedAt) >= g.restartBy:
+		if err := g.restart("stream limit imminent mid-utterance"); err != nil {
+			return err
+		}
+	}
+
+	g.remember(audio)
+	err := g.send(audio)
+	switch {
+	case err == nil || g.streamErr == nil:
+		return err
+	case isStreamLimit(g.streamErr):
+		// The stream hit the limit while we were sending; the chunk is in the replay buffer
+		return g.restart("stream limit reached")
+	case isTransient(g.streamErr):
+		// The chunk is replayed once the stream is re-established
+		return nil
+	}
+	return err
+}
+
+// remember keeps audio for replay, dropping the oldest beyond the cap; the caller must hold the lock
+func (g *googleRecognitionStream) remember(audio []byte) {
+	g.sentBytes += len(audio)
+	g.replay = append(g.replay, audio)
+	g.replayBytes += len(audio)
+	for g.replayBytes > g.maxReplay && len(g.replay) > 1 {
+		g.replayBytes -= len(g.replay[0])
+		g.replay = g.replay[1:]
+	}
+}
+
+func (g *googleRecognitionStream) Transcripts() <-chan Transcript {
+	return g.transcripts
+}
+
+// Close ends the current stream; the transcript channel closes once its results are drained
+func (g *googleRecognitionStream) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+
+	go func() {
+		g.listeners.Wait()
+		g.log.Info("Closing transcription channel")
+		close(g.transcripts)
+	}()
+	return g.stream.CloseSend()
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/speech/apiv2/speechpb"
+	"github.com/ghophp/call-me-help/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// scriptedRecognizeClient is a streaming recognize call whose results and failure are driven by the test
+type scriptedRecognizeClient struct {
+	mu      sync.Mutex
+	audio   []string
+	results chan *speechpb.StreamingRecognizeResponse
+	fail    chan error
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newScriptedRecognizeClient() *scriptedRecognizeClient {
+	return &scriptedRecognizeClient{
+		results: make(chan *speechpb.StreamingRecognizeResponse, 10),
+		fail:    make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *scriptedRecognizeClient) Send(request *speechpb.StreamingRecognizeRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.audio = append(c.audio, string(request.GetAudio()))
+	return nil
+}
+
+func (c *scriptedRecognizeClient) Recv() (*speechpb.StreamingRecognizeResponse, error) {
+	select {
+	case resp := <-c.results:
+		return resp, nil
+	case err := <-c.fail:
+		return nil, err
+	case <-c.done:
+		return nil, io.EOF
+	}
+}
+
+func (c *scriptedRecognizeClient) CloseSend() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *scriptedRecognizeClient) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.audio...)
+}
+
+func (c *scriptedRecognizeClient) Header() (metadata.MD, error) { return nil, nil }
+func (c *scriptedRecognizeClient) Trailer() metadata.MD         { return nil }
+func (c *scriptedRecognizeClient) Context() context.Context     { return context.Background() }
+func (c *scriptedRecognizeClient) SendMsg(interface{}) error    { return nil }
+func (c *scriptedRecognizeClient) RecvMsg(interface{}) error    { return nil }
+
+func finalResult(text string) *speechpb.StreamingRecognizeResponse {
+	return &speechpb.StreamingRecognizeResponse{
+		Results: []*speechpb.StreamingRecognitionResult{{
+			Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: text}},
+			IsFinal:      true,
+		}},
+	}
+}
+
+// newScriptedStream creates a recognition stream whose every connection is a new scripted client
+func newScriptedStream(t *testing.T) (*googleRecognitionStream, func() []*scriptedRecognizeClient) {
+	var mu sync.Mutex
+	var clients []*scriptedRecognizeClient
+	open := func() (speechpb.Speech_StreamingRecognizeClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		client := newScriptedRecognizeClient()
+		clients = append(clients, client)
+		return client, nil
+	}
+
+	stream, err := newGoogleRecognitionStream(DefaultAudioFormat(), open, logger.Component("SpeechToText"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream, func() []*scriptedRecognizeClient {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*scriptedRecognizeClient(nil), clients...)
+	}
+}
+
+func waitForTranscript(t *testing.T, stream *googleRecognitionStream, text string) {
+	t.Helper()
+	select {
+	case transcript := <-stream.Transcripts():
+		if transcript.Text != text {
+			t.Fatalf("Expected transcript %q, got %q", text, transcript.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for transcript %q", text)
+	}
+}
+
+func TestGoogleStreamRollsOverBeforeLimit(t *testing.T) {
+	stream, clients := newScriptedStream(t)
+	stream.restartAfter = 10 * time.Millisecond
+	first := clients()[0]
+
+	stream.SendAudio([]byte("a"))
+	time.Sleep(20 * time.Millisecond)
+	stream.SendAudio([]byte("b"))
+	if len(clients()) != 1 {
+		t.Fatalf("Expected no rollover mid-utterance, got %d streams", len(clients()))
+	}
+
+	first.results <- finalResult("hello")
+	waitForTranscript(t, stream, "hello")
+	stream.SendAudio([]byte("c"))
+
+	opened := clients()
+	if len(opened) != 2 {
+		t.Fatalf("Expected a second stream at the utterance boundary, got %d streams", len(opened))
+	}
+	// A planned rollover finalizes the old stream, so nothing is replayed
+	if got := opened[1].sent(); len(got) != 1 || got[0] != "c" {
+		t.Errorf("Expected only new audio on the new stream, got %v", got)
+	}
+
+	// Results from both streams reach the same channel
+	opened[1].results <- finalResult("still here")
+	waitForTranscript(t, stream, "still here")
+}
+
+func TestGoogleStreamRollsOverMidUtteranceCloseToLimit(t *testing.T) {
+	stream, clients := newScriptedStream(t)
+	stream.restartAfter = 10 * time.Millisecond
+	stream.restartBy = 10 * time.Millisecond
+
+	stream.SendAudio([]byte("a"))
+	time.Sleep(20 * time.Millisecond)
+	stream.SendAudio([]byte("b"))
+
+	if opened := clients(); len(opened) != 2 {
+		t.Fatalf("Expected a second stream without waiting for a final result, got %d streams", len(opened))
+	}
+}
+
+func TestGoogleStreamReplaysAudioAfterLimitError(t *testing.T) {
+	stream, clients := newScriptedStream(t)
+	first := clients()[0]
+
+	stream.SendAudio([]byte("a"))
+	first.results <- finalResult("hello")
+	waitForTranscript(t, stream, "hello")
+
+	stream.SendAudio([]byte("b"))
+	stream.SendAudio([]byte("c"))
+	first.fail <- status.Error(codes.OutOfRange, "exceeded maximum allowed stream duration")
+	time.Sleep(20 * time.Millisecond)
+
+	if err := stream.SendAudio([]byte("d")); err != nil {
+		t.Fatalf("Expected the stream to recover, got %v", err)
+	}
+
+	opened := clients()
+	if len(opened) != 2 {
+		t.Fatalf("Expected a replacement stream, got %d streams", len(opened))
+	}
+	// Audio after the last final result is replayed ahead of the new audio
+	if got := opened[1].sent(); len(got) != 3 || got[0] != "b" || got[1] != "c" || got[2] != "d" {
+		t.Errorf("Expected [b c d] on the new stream, got %v", got)
+	}
+
+	stream.Close()
+	select {
+	case _, ok := <-stream.Transcripts():
+		if ok {
+			t.Error("Expected no more transcripts after close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the transcript channel to close")
+	}
+}
+
+func TestGoogleStreamReportsOtherErrors(t *testing.T) {
+	stream, clients := newScriptedStream(t)
+	clients()[0].fail <- status.Error(codes.PermissionDenied, "denied")
+	time.Sleep(20 * time.Millisecond)
+
+	if err := stream.SendAudio([]byte("a")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected the stream error, got %v", err)
+	}
+	if len(clients()) != 1 {
+		t.Errorf("Expected no restart for non-limit errors, got %d streams", len(clients()))
+	}
+}
+
+func TestGoogleStreamOffsetsWordsAfterRollover(t *testing.T) {
+	stream, clients := newScriptedStream(t)
+	stream.restartAfter = 10 * time.Millisecond
+
+	// One second of 8kHz μ-law on the first stream
+	stream.SendAudio(make([]byte, 8000))
+	clients()[0].results <- finalResult("hi")
+	waitForTranscript(t, stream, "hi")
+	time.Sleep(20 * time.Millisecond)
+	stream.SendAudio([]byte("b"))
+
+	result := finalResult("okay")
+	result.Results[0].Alternatives[0].Words = []*speechpb.WordInfo{{
+		Word:        "okay",
+		StartOffset: durationpb.New(200 * time.Millisecond),
+		EndOffset:   durationpb.New(500 * time.Millisecond),
+	}}
+	clients()[1].results <- result
+
+	select {
+	case transcript := <-stream.Transcripts():
+		if len(transcript.Words) != 1 {
+			t.Fatalf("Expected one word, got %+v", transcript.Words)
+		}
+		if word := transcript.Words[0]; word.Start != 1200*time.Millisecond || word.End != 1500*time.Millisecond {
+			t.Errorf("Expected the word at 1.2s-1.5s of the call, got %v-%v", word.Start, word.End)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the transcript")
+	}
+}
+
+func TestGoogleStreamRetriesTransientErrors(t *testing.T) {
+	stream, clients := newScriptedStream(t)
+	stream.retryBase = 50 * time.Millisecond
+	first := clients()[0]
+
+	stream.SendAudio([]byte("a"))
+	first.fail <- status.Error(codes.Unavailable, "connection reset")
+	time.Sleep(20 * time.Millisecond)
+
+	// The first retry is immediate and replays what wasn't recognized
+	if err := stream.SendAudio([]byte("b")); err != nil {
+		t.Fatalf("Expected the stream to recover, got %v", err)
+	}
+	opened := clients()
+	if len(opened) != 2 {
+		t.Fatalf("Expected a replacement stream, got %d streams", len(opened))
+	}
+	if got := opened[1].sent(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected [a b] on the new stream, got %v", got)
+	}
+
+	// Failing again right away waits out the backoff, holding audio for the next stream
+	opened[1].fail <- status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	time.Sleep(20 * time.Millisecond)
+	stream.SendAudio([]byte("c"))
+	if len(clients()) != 2 {
+		t.Fatalf("Expected no new stream during the backoff, got %d streams", len(clients()))
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	stream.SendAudio([]byte("d"))
+	opened = clients()
+	if len(opened) != 3 {
+		t.Fatalf("Expected a new stream after the backoff, got %d streams", len(opened))
+	}
+	if got := opened[2].sent(); len(got) != 4 || got[2] != "c" || got[3] != "d" {
+		t.Errorf("Expected [a b c d] on the third stream, got %v", got)
+	}
+}
+
+func TestGoogleStreamGivesUpAfterMaxRetries(t *testing.T) {
+	var opened int
+	open := func() (speechpb.Speech_StreamingRecognizeClient, error) {
+		opened++
+		if opened > 1 {
+			return nil, status.Error(codes.Unavailable, "service unavailable")
+		}
+		client := newScriptedRecognizeClient()
+		client.fail <- status.Error(codes.Unavailable, "connection reset")
+		return client, nil
+	}
+
+	stream, err := newGoogleRecognitionStream(DefaultAudioFormat(), open, logger.Component("SpeechToText"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.retryBase = time.Millisecond
+	stream.maxRetries = 3
+	time.Sleep(20 * time.Millisecond)
+
+	var sendErr error
+	for i := 0; i < 20 && sendErr == nil; i++ {
+		sendErr = stream.SendAudio([]byte("a"))
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status.Code(errors.Unwrap(sendErr)) != codes.Unavailable {
+		t.Fatalf("Expected to give up with the transient error, got %v", sendErr)
+	}
+	if opened != 4 {
+		t.Errorf("Expected the first stream plus 3 retries, got %d attempts", opened)
+	}
+}
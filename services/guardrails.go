@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Kinds of problems the guardrail finds in responses
+const (
+	ViolationUnsafeAdvice = "unsafe_advice"
+	ViolationMedicalClaim = "medical_claim"
+	ViolationLegalClaim   = "legal_claim"
+	ViolationOffPersona   = "off_persona"
+	ViolationTooLong      = "too_long"
+)
+
+// ErrUnsafeResponse is returned for a response the guardrail replaced because it was unsafe
+var ErrUnsafeResponse = errors.New("response failed the safety guardrail")
+
+// guardrailRule flags sentences matching its pattern
+type guardrailRule struct {
+	violation string
+	pattern   *regexp.Regexp
+	// unless, when set, clears sentences that also match it, e.g. advice to see a doctor first
+	unless *regexp.Regexp
+}
+
+// guardrailSafeguards are sentences that warn or refer rather than advise
+var guardrailSafeguards = regexp.MustCompile(`(?i)\b(don'?t|do not|never|avoid|instead of|rather than|doctor|psychiatrist|prescriber|pharmacist|911|988|emergency)\b`)
+
+// guardrailRules are checked against each sentence of a response
+var guardrailRules = []guardrailRule{
+	{ViolationUnsafeAdvice, regexp.MustCompile(`(?i)\b(you should|you could|why not|go ahead and|just)\b[^.!?]*\b(hurt|harm|kill|cut|end)\b[^.!?]*\b(yourself|your life|it all)\b`), nil},
+	{ViolationUnsafeAdvice, regexp.MustCompile(`(?i)\b(stop|quit)\b[^.!?]*\btaking\b[^.!?]*\b(medication|medicine|meds|pills)\b`), guardrailSafeguards},
+	{ViolationUnsafeAdvice, regexp.MustCompile(`(?i)\b(lethal|fatal|overdose|deadly)\s+(dose|amount|quantity)\b`), guardrailSafeguards},
+	{ViolationUnsafeAdvice, regexp.MustCompile(`(?i)\b(drink|drinking|alcohol|drugs?|pills)\b[^.!?]*\bto (cope|feel better|relax|numb|forget|sleep)\b`), guardrailSafeguards},
+	{ViolationUnsafeAdvice, regexp.MustCompile(`(?i)\byou (don'?t|do not) need (professional help|help|therapy|a therapist|a doctor|your medication)\b`), nil},
+
+	{ViolationMedicalClaim, regexp.MustCompile(`(?i)\byou (have|are suffering from|clearly have|probably have|definitely have|might have)\b[^.!?]*\b(depression|bipolar|ptsd|adhd|ocd|schizophrenia|borderline|anxiety disorder|personality disorder|psychosis)\b`), nil},
+	{ViolationMedicalClaim, regexp.MustCompile(`(?i)\b\d+\s?(mg|milligrams?)\b`), nil},
+	{ViolationMedicalClaim, regexp.MustCompile(`(?i)\b(i prescribe|i recommend taking|you should (take|start|try|increase|decrease|double))\b[^.!?]*\b(antidepressants?|ssris?|prozac|zoloft|lexapro|xanax|valium|ativan|lithium|medication|pills|dose)\b`), nil},
+	{ViolationMedicalClaim, regexp.MustCompile(`(?i)\b(will|can|is guaranteed to) cure\b`), nil},
+
+	{ViolationLegalClaim, regexp.MustCompile(`(?i)\byou (will|would|are going to) (definitely |certainly )?(win|lose)\b`), nil},
+	{ViolationLegalClaim, regexp.MustCompile(`(?i)\byou (should|could) sue\b`), nil},
+	{ViolationLegalClaim, regexp.MustCompile(`(?i)\b(that's|that is|it's|it is|this is) (definitely |clearly )?(illegal|against the law)\b`), nil},
+	{ViolationLegalClaim, regexp.MustCompile(`(?i)\blegally,? you (can|can'?t|cannot|must|have to|are)\b`), nil},
+	{ViolationLegalClaim, regexp.MustCompile(`(?i)\byou have a (strong|good|solid) (case|claim)\b`), nil},
+
+	{ViolationOffPersona, regexp.MustCompile(`(?i)\bas an ai\b`), nil},
+	{ViolationOffPersona, regexp.MustCompile(`(?i)\b(a|an) (large )?language model\b`), nil},
+	{ViolationOffPersona, regexp.MustCompile(`(?i)\bmy (training data|knowledge cutoff)\b`), nil},
+}
+
+// guardrailReferrals close a response whose claims were removed, pointing the caller to
+// who can actually answer them
+var guardrailReferrals = map[string]string{
+	ViolationMedicalClaim: "A doctor or psychiatrist is the right person to talk with about diagnoses and medication.",
+	ViolationLegalClaim:   "A lawyer can tell you more about your legal options.",
+}
+
+// ResponseGuardrail checks generated responses before they are spoken. Unsafe advice
+// replaces the whole response with a safe fallback; medical and legal claims and
+// off-persona sentences are removed, with a referral for the claims; and responses too
+// long for a phone call are cut at a sentence boundary.
+type ResponseGuardrail struct {
+	// MaxChars is the longest response spoken, 0 for no limit; the first sentence is always kept
+	MaxChars int
+	log      *logger.Logger
+}
+
+// NewResponseGuardrail creates a guardrail limiting responses to maxChars
+func NewResponseGuardrail(maxChars int) *ResponseGuardrail {
+	return &ResponseGuardrail{MaxChars: maxChars, log: logger.Component("Guardrail")}
+}
+
+// GuardrailResult is a checked response
+type GuardrailResult struct {
+	Text       string   // What is safe to speak
+	Violations []string // Kinds of problems found
+	Unsafe     bool     // The response must not be spoken at all
+}
+
+// Check checks a whole response; a nil guardrail passes everything
+func (g *ResponseGuardrail) Check(response string) GuardrailResult {
+	filter := g.NewFilter()
+	if filter == nil {
+		return GuardrailResult{Text: response}
+	}
+
+	var kept []string
+	for _, sentence := range SplitSentences(response) {
+		if sentence = filter.Sentence(sentence); sentence != "" {
+			kept = append(kept, sentence)
+		}
+	}
+	kept = append(kept, filter.Finish()...)
+	return GuardrailResult{Text: strings.Join(kept, " "), Violations: filter.Violations(), Unsafe: filter.Unsafe()}
+}
+
+// GuardrailFilter checks a response a sentence at a time as it is generated
+type GuardrailFilter struct {
+	g          *ResponseGuardrail
+	chars      int
+	found      map[string]bool
+	violations []string
+	stopped    bool // Unsafe or over length, later sentences are dropped
+}
+
+// NewFilter starts checking a response; a nil guardrail gives a nil filter, which passes everything
+func (g *ResponseGuardrail) NewFilter() *GuardrailFilter {
+	if g == nil {
+		return nil
+	}
+	return &GuardrailFilter{g: g, found: make(map[string]bool)}
+}
+
+// Sentence returns the sentence if it can be spoken, or "" to drop it
+func (f *GuardrailFilter) Sentence(sentence string) string {
+	if f == nil {
+		return sentence
+	}
+	if f.stopped {
+		return ""
+	}
+
+	for _, rule := range guardrailRules {
+		if !rule.pattern.MatchString(sentence) || (rule.unless != nil && rule.unless.MatchString(sentence)) {
+			continue
+		}
+		f.flag(rule.violation)
+		f.g.log.Debug("Guardrail flagged %s: %q", rule.violation, logger.Transcript(sentence))
+		if rule.violation == ViolationUnsafeAdvice {
+			f.stopped = true
+		}
+		return ""
+	}
+
+	if f.g.MaxChars > 0 && f.chars > 0 && f.chars+len(sentence) > f.g.MaxChars {
+		f.flag(ViolationTooLong)
+		f.stopped = true
+		return ""
+	}
+	f.chars += len(sentence) + 1
+	return sentence
+}
+
+// Finish returns the sentences that close the response, referring the caller to a
+// professional for the claims that were removed
+func (f *GuardrailFilter) Finish() []string {
+	if f == nil || f.Unsafe() {
+		return nil
+	}
+	var closing []string
+	for _, violation := range []string{ViolationMedicalClaim, ViolationLegalClaim} {
+		if f.found[violation] {
+			closing = append(closing, guardrailReferrals[violation])
+		}
+	}
+	return closing
+}
+
+// Unsafe reports whether the response gave unsafe advice
+func (f *GuardrailFilter) Unsafe() bool {
+	return f != nil && f.found[ViolationUnsafeAdvice]
+}
+
+// Violations returns the kinds of problems found so far
+func (f *GuardrailFilter) Violations() []string {
+	if f == nil {
+		return nil
+	}
+	return f.violations
+}
+
+// flag records a kind of problem once
+func (f *GuardrailFilter) flag(violation string) {
+	if !f.found[violation] {
+		f.found[violation] = true
+		f.violations = append(f.violations, violation)
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResponseGuardrailCheck(t *testing.T) {
+	guardrail := NewResponseGuardrail(0)
+	tests := []struct {
+		name       string
+		response   string
+		text       string
+		violations []string
+		unsafe     bool
+	}{
+		{
+			name:     "safe",
+			response: "That sounds really hard. Would you like to tell me more?",
+			text:     "That sounds really hard. Would you like to tell me more?",
+		},
+		{
+			name:       "unsafe advice",
+			response:   "I understand. You could just stop taking your meds for a while.",
+			violations: []string{ViolationUnsafeAdvice},
+			unsafe:     true,
+		},
+		{
+			name:     "safe mention of medication",
+			response: "Please don't stop taking your medication without talking to your doctor.",
+			text:     "Please don't stop taking your medication without talking to your doctor.",
+		},
+		{
+			name:       "diagnosis",
+			response:   "It sounds like you have depression. Let's talk about your week.",
+			text:       "Let's talk about your week. " + guardrailReferrals[ViolationMedicalClaim],
+			violations: []string{ViolationMedicalClaim},
+		},
+		{
+			name:       "legal claim and off persona",
+			response:   "As an AI, I can't judge. You have a strong case against your landlord. That must be stressful.",
+			text:       "That must be stressful. " + guardrailReferrals[ViolationLegalClaim],
+			violations: []string{ViolationOffPersona, ViolationLegalClaim},
+		},
+	}
+	for _, tt := range tests {
+		got := guardrail.Check(tt.response)
+		if got.Unsafe != tt.unsafe || !reflect.DeepEqual(got.Violations, tt.violations) || (!tt.unsafe && got.Text != tt.text) {
+			t.Errorf("%s: Check(%q) = %+v, want %q %v unsafe=%v", tt.name, tt.response, got, tt.text, tt.violations, tt.unsafe)
+		}
+	}
+}
+
+func TestResponseGuardrailCutsLongResponses(t *testing.T) {
+	response := "First thought here. Second thought here. Third thought here."
+	got := NewResponseGuardrail(40).Check(response)
+	if got.Text != "First thought here. Second thought here." || !reflect.DeepEqual(got.Violations, []string{ViolationTooLong}) {
+		t.Errorf("Expected the response cut at a sentence boundary, got %+v", got)
+	}
+
+	// The first sentence is kept however long it is
+	long := strings.Repeat("very ", 20) + "long."
+	if got := NewResponseGuardrail(40).Check(long); got.Text != long {
+		t.Errorf("Expected the first sentence kept, got %+v", got)
+	}
+}
+
+func TestNilResponseGuardrailPassesEverything(t *testing.T) {
+	var guardrail *ResponseGuardrail
+	response := "As an AI, you have depression."
+	if got := guardrail.Check(response); got.Text != response || got.Unsafe || len(got.Violations) > 0 {
+		t.Errorf("Expected the response unchanged, got %+v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// HealthProber is a dependency that can check it is reachable with valid credentials,
+// cheaply and without side effects, e.g. by listing voices or fetching the account
+type HealthProber interface {
+	Probe(ctx context.Context) error
+}
+
+// DependencyStatus is the outcome of probing a dependency
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+	CheckedAt string  `json:"checkedAt"`
+}
+
+// maxProbeError is how much of a probe's error is reported, as health is unauthenticated
+const maxProbeError = 200
+
+// namedProber is a dependency the checker probes
+type namedProber struct {
+	name   string
+	prober HealthProber
+}
+
+// HealthChecker probes the dependencies calls need: speech recognition and synthesis, the
+// LLM, Twilio and storage. Results are kept for a while, so uptime monitors polling the
+// deep health check don't spend the providers' quota.
+type HealthChecker struct {
+	probers []namedProber
+	timeout time.Duration // Per-probe limit
+	ttl     time.Duration // How long results are reused
+
+	mu        sync.Mutex // Held while probing, so concurrent checks share the result
+	last      []DependencyStatus
+	checkedAt time.Time
+	log       *logger.Logger
+}
+
+// NewHealthChecker creates a checker giving each probe timeout and reusing results for ttl
+func NewHealthChecker(timeout, ttl time.Duration) *HealthChecker {
+	return &HealthChecker{timeout: timeout, ttl: ttl, log: logger.Component("HealthCheck")}
+}
+
+// Add probes the dependency under the name when it can be probed; it returns whether it can
+func (h *HealthChecker) Add(name string, dependency interface{}) bool {
+	prober, ok := dependency.(HealthProber)
+	if !ok || prober == nil {
+		h.log.Debug("Dependency %s can't be probed, leaving it out of the deep health check", name)
+		return false
+	}
+	h.probers = append(h.probers, namedProber{name: name, prober: prober})
+	return true
+}
+
+// Check probes every dependency concurrently, unless it was done within the ttl, and tells
+// whether they are all healthy. A nil checker has nothing to probe.
+func (h *HealthChecker) Check(ctx context.Context) ([]DependencyStatus, bool) {
+	if h == nil {
+		return nil, true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last == nil || time.Since(h.checkedAt) >= h.ttl {
+		h.last = h.probe(ctx)
+		h.checkedAt = time.Now()
+	}
+
+	healthy := true
+	for _, status := range h.last {
+		healthy = healthy && status.Healthy
+	}
+	return h.last, healthy
+}
+
+// probe runs every probe with its timeout
+func (h *HealthChecker) probe(ctx context.Context) []DependencyStatus {
+	statuses := make([]DependencyStatus, len(h.probers))
+	var wg sync.WaitGroup
+	for i, p := range h.probers {
+		wg.Add(1)
+		go func(i int, p namedProber) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := p.prober.Probe(probeCtx)
+			status := DependencyStatus{
+				Name:      p.name,
+				Healthy:   err == nil,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				CheckedAt: start.UTC().Format(time.RFC3339),
+			}
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					err = errors.New("timed out")
+				}
+				status.Error = logger.MaskPII(err.Error())
+				if len(status.Error) > maxProbeError {
+					status.Error = status.Error[:maxProbeError] + "..."
+				}
+				h.log.Warn("Dependency %s is unhealthy: %v", p.name, err)
+			}
+			statuses[i] = status
+		}(i, p)
+	}
+	wg.Wait()
+	return statuses
+}
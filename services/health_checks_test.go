@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProber answers probes with err, after delay
+type fakeProber struct {
+	err    error
+	delay  time.Duration
+	probes atomic.Int32
+}
+
+func (f *fakeProber) Probe(ctx context.Context) error {
+	f.probes.Add(1)
+	select {
+	case <-time.After(f.delay):
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestHealthCheckerReportsEachDependency(t *testing.T) {
+	checker := NewHealthChecker(50*time.Millisecond, time.Minute)
+	stt, twilio, slow := &fakeProber{}, &fakeProber{err: errors.New("401 for +15551234567")}, &fakeProber{delay: time.Second}
+	checker.Add("speechToText", stt)
+	checker.Add("twilio", twilio)
+	checker.Add("llm", slow)
+	if checker.Add("translation", struct{}{}) {
+		t.Error("Expected a dependency that can't be probed left out")
+	}
+
+	statuses, healthy := checker.Check(context.Background())
+	if healthy || len(statuses) != 3 {
+		t.Fatalf("Expected three dependencies, not all healthy, got %v %+v", healthy, statuses)
+	}
+	if !statuses[0].Healthy || statuses[0].Name != "speechToText" {
+		t.Errorf("Expected speech recognition healthy, got %+v", statuses[0])
+	}
+	if statuses[1].Healthy || statuses[1].Error != "401 for [phone]" {
+		t.Errorf("Expected Twilio failing with its error masked, got %+v", statuses[1])
+	}
+	if statuses[2].Healthy || statuses[2].Error != "timed out" || statuses[2].LatencyMs > 500 {
+		t.Errorf("Expected the slow LLM to time out, got %+v", statuses[2])
+	}
+
+	checker.Check(context.Background())
+	if stt.probes.Load() != 1 {
+		t.Errorf("Expected the results reused within the ttl, got %d probes", stt.probes.Load())
+	}
+}
+
+func TestFailoverSynthesizerIsHealthyWhileOneProviderIs(t *testing.T) {
+	failing, healthy := &fakeProber{err: errors.New("quota exceeded")}, &fakeProber{}
+	chain := &FailoverSynthesizer{chain: []namedSynthesizer{
+		{name: "google", provider: &probedSynthesizer{fakeProber: failing}},
+		{name: "polly", provider: &probedSynthesizer{fakeProber: healthy}},
+	}}
+	if err := chain.Probe(context.Background()); err != nil {
+		t.Errorf("Expected the chain healthy, got %v", err)
+	}
+
+	healthy.err = errors.New("throttled")
+	if err := chain.Probe(context.Background()); err == nil {
+		t.Error("Expected the chain failing once every provider is")
+	}
+}
+
+// probedSynthesizer is a text-to-speech provider that can be probed
+type probedSynthesizer struct {
+	*fakeProber
+}
+
+func (p *probedSynthesizer) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	return nil, nil
+}
+
+func (p *probedSynthesizer) Close() error { return nil }
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// summaryInstructions steers the model towards a running summary instead of a reply
+const summaryInstructions = "Summarize this part of a supportive phone conversation for the therapist who will continue it. " +
+	"Fold in the earlier summary if there is one. Keep what the caller shared about their situation, feelings, risks and " +
+	"what has already been suggested, in under 150 words of plain prose. Do not reply to the caller."
+
+// EstimateTokens roughly counts the LLM tokens in text, at about 4 characters per token
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// HistoryBudget keeps the conversation history sent to the LLM within a token budget. The
+// most recent messages are kept verbatim; older ones are dropped from the prompt and, when
+// there is a summarizer, folded into a running summary of the call in the background so
+// turns don't wait on it.
+type HistoryBudget struct {
+	// MaxTokens is the budget for the history; 0 sends the whole history
+	MaxTokens int
+	// KeepRecent is how many of the latest messages are always kept verbatim
+	KeepRecent int
+	// Summarizer, when set, writes the running summary of dropped messages
+	Summarizer ResponseGenerator
+
+	log *logger.Logger
+}
+
+// NewHistoryBudget creates a history budget
+func NewHistoryBudget(maxTokens, keepRecent int, summarizer ResponseGenerator) *HistoryBudget {
+	return &HistoryBudget{
+		MaxTokens:  maxTokens,
+		KeepRecent: keepRecent,
+		Summarizer: summarizer,
+		log:        logger.Component("HistoryBudget"),
+	}
+}
+
+// History returns the conversation's formatted history within the budget
+func (b *HistoryBudget) History(ctx context.Context, c *Conversation) []string {
+	c.mu.Lock()
+	fixed := make([]string, 0, len(c.Context)+1)
+	for _, note := range c.Context {
+		fixed = append(fixed, "Context: "+note)
+	}
+	if c.Summary != "" {
+		fixed = append(fixed, "Context: Summary of the call so far: "+c.Summary)
+	}
+	start := c.Summarized
+	recent := make([]string, 0, len(c.Messages)-start)
+	for _, msg := range c.Messages[start:] {
+		recent = append(recent, formatMessage(msg))
+	}
+	c.mu.Unlock()
+
+	if b.MaxTokens <= 0 {
+		return append(fixed, recent...)
+	}
+
+	// Keep messages from the newest back while they fit, and always the latest few
+	used := 0
+	for _, entry := range fixed {
+		used += EstimateTokens(entry)
+	}
+	cut := len(recent)
+	for cut > 0 {
+		tokens := EstimateTokens(recent[cut-1])
+		if len(recent)-cut >= b.KeepRecent && used+tokens > b.MaxTokens {
+			break
+		}
+		used += tokens
+		cut--
+	}
+	if cut == 0 {
+		return append(fixed, recent...)
+	}
+
+	b.log.Info("History of call %s over its %d token budget, dropping %d older messages from the prompt",
+		c.ID, b.MaxTokens, cut)
+	if b.Summarizer != nil {
+		b.summarize(ctx, c, start+cut)
+	}
+	return append(fixed, recent[cut:]...)
+}
+
+// summarize folds the messages up to through into the conversation's summary in the
+// background, unless a summary is already being written
+func (b *HistoryBudget) summarize(ctx context.Context, c *Conversation, through int) {
+	c.mu.Lock()
+	if c.summarizing || through <= c.Summarized {
+		c.mu.Unlock()
+		return
+	}
+	c.summarizing = true
+	var transcript strings.Builder
+	if c.Summary != "" {
+		transcript.WriteString("Earlier summary: " + c.Summary + "\n")
+	}
+	for _, msg := range c.Messages[c.Summarized:through] {
+		transcript.WriteString(formatMessage(msg) + "\n")
+	}
+	c.mu.Unlock()
+
+	// The summary is for later turns, so it may finish after this one
+	ctx, cancel := context.WithTimeout(withoutGenerationReport(context.WithoutCancel(ctx)), 30*time.Second)
+	go func() {
+		defer cancel()
+		startTime := time.Now()
+		summary, err := b.Summarizer.GenerateResponse(ctx, transcript.String(), []string{"Context: " + summaryInstructions})
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.summarizing = false
+		if err != nil || strings.TrimSpace(summary) == "" {
+			b.log.Error("Failed to summarize the history of call %s after %v: %v", c.ID, time.Since(startTime), err)
+			return
+		}
+		c.Summary = strings.TrimSpace(summary)
+		c.Summarized = through
+		b.log.Info("Summarized %d messages of call %s in %v (%d tokens)",
+			through, c.ID, time.Since(startTime), EstimateTokens(c.Summary))
+	}()
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLongConversation creates a conversation of alternating messages of about 10 tokens each
+func newLongConversation(messages int) *Conversation {
+	c := NewConversationService().GetOrCreateConversation("test-call")
+	c.AddContext("Referred by a partner clinic.")
+	for i := 0; i < messages; i++ {
+		text := strings.Repeat("x", 30) + string(rune('a'+i))
+		if i%2 == 0 {
+			c.AddUserMessage(text)
+		} else {
+			c.AddTherapistMessage(text)
+		}
+	}
+	return c
+}
+
+func TestHistoryBudgetSendsShortHistoriesWhole(t *testing.T) {
+	c := newLongConversation(4)
+	budget := NewHistoryBudget(1000, 2, nil)
+
+	if got := budget.History(context.Background(), c); !reflect.DeepEqual(got, c.GetFormattedHistory()) {
+		t.Errorf("Expected the whole history, got %q", got)
+	}
+}
+
+func TestHistoryBudgetKeepsRecentMessagesWithinBudget(t *testing.T) {
+	c := newLongConversation(10)
+	full := c.GetFormattedHistory()
+
+	// The context note and three messages fit in 45 tokens
+	got := NewHistoryBudget(45, 2, nil).History(context.Background(), c)
+	want := append([]string{full[0]}, full[len(full)-3:]...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the context and the latest messages that fit, got %q", got)
+	}
+
+	// The latest messages are kept even when they alone are over budget
+	got = NewHistoryBudget(5, 2, nil).History(context.Background(), c)
+	want = append([]string{full[0]}, full[len(full)-2:]...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the latest two messages kept, got %q", got)
+	}
+}
+
+func TestHistoryBudgetSummarizesDroppedMessages(t *testing.T) {
+	c := newLongConversation(10)
+	summarizer := &fakeGenerator{replies: map[string]string{}}
+	budget := NewHistoryBudget(45, 2, summarizer)
+
+	budget.History(context.Background(), c)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		summary, summarized := c.Summary, c.Summarized
+		c.mu.Unlock()
+		if summary != "" {
+			if summary != "Tell me more." || summarized != 7 {
+				t.Fatalf("Expected the first 7 messages summarized, got %d: %q", summarized, summary)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the summary")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	summarizer.mu.Lock()
+	calls := summarizer.calls
+	summarizer.mu.Unlock()
+	if len(calls) != 1 || !strings.Contains(calls[0], "User: ") {
+		t.Errorf("Expected the dropped messages sent to the summarizer, got %q", calls)
+	}
+
+	// Later prompts carry the summary in place of the summarized messages, within the same budget
+	full := c.GetFormattedHistory()
+	got := NewHistoryBudget(45, 2, nil).History(context.Background(), c)
+	want := append([]string{full[0], "Context: Summary of the call so far: Tell me more."}, full[len(full)-2:]...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the summary and the latest messages, got %q", got)
+	}
+}
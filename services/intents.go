@@ -0,0 +1,96 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// slowDownRequest matches a caller asking us to speak more slowly
+var slowDownRequest = regexp.MustCompile(`(?i)\b(?:slow(?:er)? down|(?:speak|talk|go)(?: a (?:little|bit))?(?: more)? slow(?:er|ly)|(?:speaking|talking|going) too fast)\b`)
+
+// IsSlowDownRequest reports whether the caller asked us to speak more slowly
+func IsSlowDownRequest(text string) bool {
+	return slowDownRequest.MatchString(text)
+}
+
+// Intent is what the caller is doing with an utterance
+type Intent string
+
+const (
+	// IntentGreeting opens the conversation, e.g. "hi, is anyone there"
+	IntentGreeting Intent = "greeting"
+	// IntentVenting shares feelings or what happened, the usual turn
+	IntentVenting Intent = "venting"
+	// IntentQuestion asks the therapist something
+	IntentQuestion Intent = "question"
+	// IntentCrisis mentions suicide, self-harm or immediate danger
+	IntentCrisis Intent = "crisis"
+	// IntentRequestHuman asks to talk with a person rather than the AI
+	IntentRequestHuman Intent = "request_human"
+	// IntentGoodbye ends the call
+	IntentGoodbye Intent = "goodbye"
+)
+
+var (
+	// crisisSpeech matches suicidal thoughts, self-harm and immediate danger
+	crisisSpeech = regexp.MustCompile(`(?i)\b(kill(ing)? myself|suicid(e|al)|end (my|it) (life|all)|take my (own )?life|(don'?t|do not) want to (live|be alive|wake up)|want(ed)? to die|better off dead|no reason to live|hurt(ing)? myself|cut(ting)? myself|overdos(e|ing)|(he|she|they|someone)('s| is| are)? (going to|gonna) (kill|hurt) me|i'?m not safe)\b`)
+	// humanRequest matches asking for a real person
+	humanRequest = regexp.MustCompile(`(?i)\b((talk|speak) (to|with) (a|an|the) (real |actual |live )?(person|human|counselor|operator|agent)|(real|actual|live) (person|human|counselor)|transfer me|put me through)\b`)
+	// goodbyeSpeech matches the caller taking their leave, ending a clause so "I have to go
+	// back to work" is not a goodbye
+	goodbyeSpeech = regexp.MustCompile(`(?i)^(ok(ay)?,? |well,? |alright,? |thanks?( you)?( so much)?,? )*(good ?bye|bye( bye| now)?|i (have|need|got) to go( now)?|i'?(ll| will) let you go|talk (to you )?(later|soon)|that'?s all( for (now|today))?|i'?m going to hang up|have a good (day|night|evening))\s*([.!,]|$)`)
+	// greetingSpeech matches an opening like "hello" or "good morning"
+	greetingSpeech = regexp.MustCompile(`(?i)^(hi|hello|hey|hiya|good (morning|afternoon|evening)|is (anyone|anybody|someone) there)\b`)
+	// questionStart matches an utterance opening like a question
+	questionStart = regexp.MustCompile(`(?i)^(what|why|how|when|where|who|which|can|could|would|should|is|are|do|does|did|will)\b`)
+)
+
+// ClassifyIntent tells what the caller is doing with a normalized transcription. Crisis
+// outranks everything, so "bye, I'm going to kill myself" is never taken as a goodbye.
+func ClassifyIntent(text string) Intent {
+	text = strings.TrimSpace(text)
+	words := len(strings.Fields(text))
+	switch {
+	case crisisSpeech.MatchString(text):
+		return IntentCrisis
+	case humanRequest.MatchString(text):
+		return IntentRequestHuman
+	case goodbyeSpeech.MatchString(text) && words <= 12:
+		return IntentGoodbye
+	case strings.HasSuffix(text, "?") || questionStart.MatchString(text):
+		return IntentQuestion
+	case greetingSpeech.MatchString(text) && words <= 6:
+		return IntentGreeting
+	default:
+		return IntentVenting
+	}
+}
+
+// Notes added to the prompt of turns whose intent is handled specially
+const (
+	crisisNote     = "The caller may be in crisis. Put their safety first: ask directly whether they are safe right now, stay calm and warm, and tell them they can call or text 988, the Suicide & Crisis Lifeline, or dial 911 in an emergency."
+	goodbyeNote    = "The caller is ending the call. Wrap up warmly in one or two sentences, remind them they can call back or reach 988 any time, and say goodbye. Don't ask a question."
+	transferNote   = "The caller asked to talk with a person. Tell them in one short sentence that you are connecting them now. Don't ask a question."
+	noTransferNote = "The caller asked to talk with a person, but no one can take calls on this line. Say so kindly, tell them they can call or text 988 to reach a trained counselor, and offer to keep listening."
+	questionNote   = "The caller asked a question. Answer it plainly first, then follow their lead."
+)
+
+// intentNote returns the prompt note of an intent, which for a request for a person
+// depends on whether the call can be transferred
+func intentNote(intent Intent, canTransfer bool) string {
+	switch intent {
+	case IntentCrisis:
+		return crisisNote
+	case IntentGoodbye:
+		return goodbyeNote
+	case IntentRequestHuman:
+		if canTransfer {
+			return transferNote
+		}
+		return noTransferNote
+	case IntentQuestion:
+		return questionNote
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIsSlowDownRequest(t *testing.T) {
+	cases := map[string]bool{
+		"Could you slow down please":          true,
+		"can you talk a little more slowly":   true,
+		"You're speaking too fast for me":     true,
+		"speak slower":                        true,
+		"I've been feeling slow lately":       false,
+		"Things at work are moving too fast":  false,
+		"I went for a slow walk this morning": false,
+	}
+	for text, want := range cases {
+		if got := IsSlowDownRequest(text); got != want {
+			t.Errorf("IsSlowDownRequest(%q): expected %t, got %t", text, want, got)
+		}
+	}
+}
+
+// rateRecorder is a SpeechSynthesizer that notes the speaking rate each request asked for
+type rateRecorder struct {
+	rates []float64
+}
+
+func (r *rateRecorder) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	r.rates = append(r.rates, speakingRate(ctx, 1))
+	return []byte(text), nil
+}
+
+func TestTurnEngineSlowsDownWhenAsked(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	synthesizer := &rateRecorder{}
+	engine := NewTurnEngine(channels, conversation, &fakeGenerator{replies: map[string]string{}}, synthesizer)
+
+	engine.ProcessTranscription(context.Background(), "I had a rough day")
+	engine.ProcessTranscription(context.Background(), "Sorry, could you slow down?")
+	engine.ProcessTranscription(context.Background(), "Please speak more slowly")
+	for i := 0; i < 5; i++ {
+		channels.SlowDown()
+	}
+
+	want := []float64{1, 0.85, 0.85 * 0.85}
+	for i, rate := range want {
+		if diff := synthesizer.rates[i] - rate; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("Turn %d: expected rate %.4f, got %.4f", i+1, rate, synthesizer.rates[i])
+		}
+	}
+	if rate := channels.SpeakingRate(); rate != minSpeakingRate {
+		t.Errorf("Expected the rate to stop at %.2f, got %.2f", minSpeakingRate, rate)
+	}
+}
+
+func TestClassifyIntent(t *testing.T) {
+	cases := map[string]Intent{
+		"Hello?":                                            IntentQuestion,
+		"Hi, good morning":                                  IntentGreeting,
+		"My boss yelled at me again today":                  IntentVenting,
+		"How do I stop overthinking everything?":            IntentQuestion,
+		"Is it normal to cry this much":                     IntentQuestion,
+		"I don't want to live anymore":                      IntentCrisis,
+		"Bye, I'm going to kill myself":                     IntentCrisis,
+		"Can I talk to a real person please":                IntentRequestHuman,
+		"I want a live counselor":                           IntentRequestHuman,
+		"I need to talk to someone about my dad":            IntentVenting,
+		"Okay, thank you so much, goodbye":                  IntentGoodbye,
+		"I have to go now":                                  IntentGoodbye,
+		"I have to go back to work tomorrow and I dread it": IntentVenting,
+	}
+	for text, want := range cases {
+		if got := ClassifyIntent(text); got != want {
+			t.Errorf("ClassifyIntent(%q): expected %s, got %s", text, want, got)
+		}
+	}
+}
+
+// callRecorder is a CallController noting what was done to calls
+type callRecorder struct {
+	done chan string
+}
+
+func (c *callRecorder) HangUp(callSID string) error {
+	c.done <- "hangup " + callSID
+	return nil
+}
+
+func (c *callRecorder) Transfer(callSID, number string) error {
+	c.done <- "transfer " + callSID + " " + number
+	return nil
+}
+
+func TestTurnEngineRoutesByIntent(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &summaryGenerator{response: "Take care, you can call back any time."}
+	calls := &callRecorder{done: make(chan string, 1)}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+	engine.Calls = calls
+
+	turn := engine.ProcessTranscription(context.Background(), "Can I speak to a human?")
+	if turn.Intent != IntentRequestHuman || turn.Action != ActionRespond || generator.history[len(generator.history)-1] != "Context: "+noTransferNote {
+		t.Errorf("Expected a request for a person answered without a transfer number, got %+v and %q", turn, generator.history)
+	}
+
+	engine.TransferNumber = "+15550100"
+	turn = engine.ProcessTranscription(context.Background(), "Can I speak to a human?")
+	if turn.Action != ActionEscalate || generator.history[len(generator.history)-1] != "Context: "+transferNote {
+		t.Errorf("Expected the caller put through, got %+v and %q", turn, generator.history)
+	}
+	select {
+	case done := <-calls.done:
+		if done != "transfer test-call +15550100" {
+			t.Errorf("Unexpected call action %q", done)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the call to be transferred once the response was heard")
+	}
+
+	turn = engine.ProcessTranscription(context.Background(), "Thanks, bye")
+	if turn.Intent != IntentGoodbye || turn.Action != ActionEnd || generator.history[len(generator.history)-1] != "Context: "+goodbyeNote {
+		t.Errorf("Expected the call wrapped up, got %+v and %q", turn, generator.history)
+	}
+	select {
+	case done := <-calls.done:
+		if done != "hangup test-call" {
+			t.Errorf("Unexpected call action %q", done)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the call to be ended after the goodbye")
+	}
+}
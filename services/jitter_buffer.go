@@ -0,0 +1,121 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MediaFrame is a chunk of inbound caller audio with Twilio's sequencing metadata
+type MediaFrame struct {
+	Chunk     int
+	Timestamp int // milliseconds since the stream started
+	Payload   []byte
+}
+
+// JitterBuffer reorders inbound media frames by timestamp and holds them for an
+// adaptive delay so late frames on lossy connections can still be placed in order
+type JitterBuffer struct {
+	mu            sync.Mutex
+	frames        []MediaFrame
+	newest        int
+	lastReleased  int
+	released      bool
+	depth         time.Duration
+	minDepth      time.Duration
+	maxDepth      time.Duration
+	droppedFrames int
+}
+
+// NewJitterBuffer creates a jitter buffer whose hold time adapts between minDepth and maxDepth
+func NewJitterBuffer(minDepth, maxDepth time.Duration) *JitterBuffer {
+	return &JitterBuffer{
+		depth:    minDepth,
+		minDepth: minDepth,
+		maxDepth: maxDepth,
+	}
+}
+
+// Push adds a frame to the buffer, dropping it if its slot was already played out
+func (jb *JitterBuffer) Push(frame MediaFrame) bool {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	if jb.released && frame.Timestamp <= jb.lastReleased {
+		jb.droppedFrames++
+		return false
+	}
+
+	if frame.Timestamp < jb.newest {
+		// Out-of-order arrival: grow the buffer to cover this much jitter
+		lateness := time.Duration(jb.newest-frame.Timestamp) * time.Millisecond
+		if lateness > jb.depth {
+			jb.depth = lateness
+			if jb.depth > jb.maxDepth {
+				jb.depth = jb.maxDepth
+			}
+		}
+	} else {
+		jb.newest = frame.Timestamp
+		// In-order arrival: slowly shrink back towards the minimum depth
+		if jb.depth > jb.minDepth {
+			jb.depth -= time.Millisecond
+		}
+	}
+
+	// Insert keeping the frames sorted by timestamp
+	i := sort.Search(len(jb.frames), func(i int) bool {
+		return jb.frames[i].Timestamp >= frame.Timestamp
+	})
+	jb.frames = append(jb.frames, MediaFrame{})
+	copy(jb.frames[i+1:], jb.frames[i:])
+	jb.frames[i] = frame
+	return true
+}
+
+// Ready returns, in order, the frames that have been held for the current depth
+func (jb *JitterBuffer) Ready() []MediaFrame {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	cutoff := jb.newest - int(jb.depth/time.Millisecond)
+	n := 0
+	for n < len(jb.frames) && jb.frames[n].Timestamp <= cutoff {
+		n++
+	}
+	return jb.release(n)
+}
+
+// Flush returns every buffered frame in order, regardless of depth
+func (jb *JitterBuffer) Flush() []MediaFrame {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	return jb.release(len(jb.frames))
+}
+
+// Depth returns the current adaptive hold time
+func (jb *JitterBuffer) Depth() time.Duration {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	return jb.depth
+}
+
+// DroppedFrames returns how many frames arrived too late to be played out
+func (jb *JitterBuffer) DroppedFrames() int {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	return jb.droppedFrames
+}
+
+// release pops the first n frames; the caller must hold the lock
+func (jb *JitterBuffer) release(n int) []MediaFrame {
+	if n == 0 {
+		return nil
+	}
+	out := make([]MediaFrame, n)
+	copy(out, jb.frames[:n])
+	jb.frames = jb.frames[n:]
+	jb.lastReleased = out[n-1].Timestamp
+	jb.released = true
+	return out
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func frame(ts int) MediaFrame {
+	return MediaFrame{Chunk: ts / 20, Timestamp: ts, Payload: []byte{byte(ts)}}
+}
+
+func timestamps(frames []MediaFrame) []int {
+	var out []int
+	for _, f := range frames {
+		out = append(out, f.Timestamp)
+	}
+	return out
+}
+
+func TestJitterBufferReordersFrames(t *testing.T) {
+	jb := NewJitterBuffer(40*time.Millisecond, 200*time.Millisecond)
+
+	for _, ts := range []int{0, 40, 20, 60, 80, 100} {
+		jb.Push(frame(ts))
+	}
+
+	got := timestamps(jb.Ready())
+	want := []int{0, 20, 40, 60}
+	if len(got) != len(want) {
+		t.Fatalf("Expected frames %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected frames %v, got %v", want, got)
+		}
+	}
+
+	rest := timestamps(jb.Flush())
+	if len(rest) != 2 || rest[0] != 80 || rest[1] != 100 {
+		t.Errorf("Expected remaining frames [80 100], got %v", rest)
+	}
+}
+
+func TestJitterBufferDropsFramesAlreadyPlayedOut(t *testing.T) {
+	jb := NewJitterBuffer(20*time.Millisecond, 200*time.Millisecond)
+
+	jb.Push(frame(0))
+	jb.Push(frame(20))
+	jb.Push(frame(40))
+	jb.Ready()
+
+	if jb.Push(frame(10)) {
+		t.Error("Expected frame older than the playout point to be dropped")
+	}
+	if jb.DroppedFrames() != 1 {
+		t.Errorf("Expected 1 dropped frame, got %d", jb.DroppedFrames())
+	}
+}
+
+func TestJitterBufferAdaptsDepth(t *testing.T) {
+	jb := NewJitterBuffer(40*time.Millisecond, 100*time.Millisecond)
+
+	jb.Push(frame(0))
+	jb.Push(frame(200))
+	jb.Push(frame(120))
+	if jb.Depth() != 80*time.Millisecond {
+		t.Errorf("Expected depth to grow to 80ms, got %v", jb.Depth())
+	}
+
+	jb.Push(frame(400))
+	jb.Push(frame(100))
+	if jb.Depth() != 100*time.Millisecond {
+		t.Errorf("Expected depth capped at 100ms, got %v", jb.Depth())
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// languageNames are the English names of the languages callers are most likely to speak,
+// by base language code
+var languageNames = map[string]string{
+	"ar": "Arabic",
+	"de": "German",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"hi": "Hindi",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"tl": "Tagalog",
+	"uk": "Ukrainian",
+	"vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+// LanguageName returns the English name of a language code, e.g. Spanish for es-MX, or
+// the code itself when the language isn't known
+func LanguageName(code string) string {
+	if name, ok := languageNames[strings.ToLower(strings.SplitN(code, "-", 2)[0])]; ok {
+		return name
+	}
+	return code
+}
+
+// LanguageNote tells the LLM which language to answer a caller in
+func LanguageNote(language string) string {
+	name := LanguageName(language)
+	return fmt.Sprintf("The caller is speaking %s. Respond only in %s, whatever language earlier messages were in.", name, name)
+}
+
+// callLanguage returns the caller's language from the context, or the configured one
+func callLanguage(ctx context.Context, configured string) string {
+	if language := LanguageFromContext(ctx); language != "" {
+		return language
+	}
+	return configured
+}
+
+// LanguageVoices maps language codes to the TTS voice responses in that language are
+// spoken in
+type LanguageVoices map[string]string
+
+// ParseLanguageVoices parses "language=voice" entries, e.g. "es=es-US-Neural2-A"
+func ParseLanguageVoices(entries []string) (LanguageVoices, error) {
+	voices := make(LanguageVoices, len(entries))
+	for _, entry := range entries {
+		language, voice, ok := strings.Cut(entry, "=")
+		language, voice = strings.ToLower(strings.TrimSpace(language)), strings.TrimSpace(voice)
+		if !ok || language == "" || voice == "" {
+			return nil, fmt.Errorf("invalid language voice %q, expected language=voice", entry)
+		}
+		voices[language] = voice
+	}
+	return voices, nil
+}
+
+// For returns the voice of a language, matching the exact code before its base language,
+// or "" when none is configured
+func (v LanguageVoices) For(language string) string {
+	language = strings.ToLower(language)
+	if voice, ok := v[language]; ok {
+		return voice
+	}
+	return v[strings.SplitN(language, "-", 2)[0]]
+}
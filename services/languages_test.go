@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseLanguageVoices(t *testing.T) {
+	voices, err := ParseLanguageVoices([]string{"es=es-US-Neural2-A", " fr-CA = fr-CA-Neural2-B "})
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if voice := voices.For("es-us"); voice != "es-US-Neural2-A" {
+		t.Errorf("Expected es-us to use the Spanish voice, got %q", voice)
+	}
+	if voice := voices.For("fr-FR"); voice != "" {
+		t.Errorf("Expected no voice for a variant without one, got %q", voice)
+	}
+	if _, err := ParseLanguageVoices([]string{"es-US-Neural2-A"}); err == nil {
+		t.Error("Expected an entry without a language to be rejected")
+	}
+
+	if name := LanguageName("es-MX"); name != "Spanish" {
+		t.Errorf("Expected Spanish for es-MX, got %q", name)
+	}
+	if name := LanguageName("xx-YY"); name != "xx-YY" {
+		t.Errorf("Expected unknown codes to be kept, got %q", name)
+	}
+}
+
+// languageRecorder is a ResponseGenerator that notes the language each request was for
+type languageRecorder struct {
+	languages []string
+	histories [][]string
+}
+
+func (l *languageRecorder) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	l.languages = append(l.languages, LanguageFromContext(ctx))
+	l.histories = append(l.histories, conversationHistory)
+	return "Cuéntame más.", nil
+}
+
+func TestTurnEngineAnswersInTheDetectedLanguage(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &languageRecorder{}
+	synthesizer := &voiceRecorder{}
+	engine := NewTurnEngine(channels, conversation, generator, synthesizer)
+	engine.Language = "en-US"
+	engine.LanguageVoices = LanguageVoices{"es": "es-US-Neural2-A"}
+
+	engine.ProcessTranscription(context.Background(), "hello")
+	engine.detectLanguage("es-us")
+	engine.ProcessTranscription(context.Background(), "me siento muy solo")
+
+	if generator.languages[0] != "" || generator.languages[1] != "es-us" {
+		t.Errorf("Expected Spanish only after it was detected, got %q", generator.languages)
+	}
+	history := strings.Join(generator.histories[1], "\n")
+	if !strings.Contains(history, "Context: The caller is speaking Spanish. Respond only in Spanish") {
+		t.Errorf("Expected the LLM to be told the caller's language, got %q", history)
+	}
+	if synthesizer.voices[0] != "" || synthesizer.voices[1] != "es-US-Neural2-A" {
+		t.Errorf("Expected the Spanish voice after detection, got %q", synthesizer.voices)
+	}
+
+	// A chosen English voice gives way to the caller's language, and comes back with English
+	channels.SetVoice("en-US-Neural2-F")
+	engine.ProcessTranscription(context.Background(), "gracias")
+	engine.detectLanguage("en-US")
+	engine.ProcessTranscription(context.Background(), "thank you")
+	if synthesizer.voices[2] != "es-US-Neural2-A" || synthesizer.voices[3] != "en-US-Neural2-F" {
+		t.Errorf("Expected the voice to follow the caller's language, got %q", synthesizer.voices)
+	}
+}
+
+func TestFallbackPhrasesForLanguageVariant(t *testing.T) {
+	library := NewFallbackLibrary("en-US")
+	library.merge(map[string]map[FailureType][]string{
+		"es-ES": {FailureGeneration: {"Perdona, ¿puedes repetirlo?"}},
+	})
+
+	phrase := library.Phrase("es-us", FailureTimeout, 0, DefaultAudioFormat())
+	if phrase.Text != "Perdona, ¿puedes repetirlo?" {
+		t.Errorf("Expected the es-ES phrase for an es-US caller, got %q", phrase.Text)
+	}
+}
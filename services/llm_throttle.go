@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// LLMThrottleStats reports how long LLM requests waited for the throttle
+type LLMThrottleStats struct {
+	Granted       int64   `json:"granted"`
+	Queued        int     `json:"queued"`
+	AverageWaitMs float64 `json:"averageWaitMs"`
+	MaxWaitMs     float64 `json:"maxWaitMs"`
+}
+
+// throttleWaiter is a request waiting for a token
+type throttleWaiter struct {
+	ready    chan struct{}
+	queuedAt time.Time
+}
+
+// LLMThrottle is a global token bucket for LLM requests that hands out tokens
+// round-robin across calls, so one chatty call can't starve the others
+type LLMThrottle struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	queues map[string][]*throttleWaiter // waiting requests per call
+	order  []string                     // calls with waiting requests, in round-robin order
+	timer  *time.Timer
+
+	granted   int64
+	totalWait time.Duration
+	maxWait   time.Duration
+
+	mu  sync.Mutex
+	log *logger.Logger
+}
+
+// NewLLMThrottle creates a throttle allowing rate requests per second with the given burst
+func NewLLMThrottle(rate float64, burst int) *LLMThrottle {
+	log := logger.Component("LLMThrottle")
+	log.Info("Creating LLM throttle: %.2f requests/s, burst %d", rate, burst)
+
+	if burst < 1 {
+		burst = 1
+	}
+	return &LLMThrottle{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		queues: make(map[string][]*throttleWaiter),
+		log:    log,
+	}
+}
+
+// Acquire blocks until the call is granted a token or the context is done
+func (t *LLMThrottle) Acquire(ctx context.Context, callSID string) error {
+	waiter := &throttleWaiter{ready: make(chan struct{}), queuedAt: time.Now()}
+
+	t.mu.Lock()
+	if len(t.queues[callSID]) == 0 {
+		t.order = append(t.order, callSID)
+	}
+	t.queues[callSID] = append(t.queues[callSID], waiter)
+	t.dispatch()
+	t.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		select {
+		case <-waiter.ready:
+			// Granted while we were giving up; the token is spent either way
+			return nil
+		default:
+		}
+		t.remove(callSID, waiter)
+		t.log.Warn("LLM request for call %s abandoned after waiting %v", callSID, time.Since(waiter.queuedAt))
+		return ctx.Err()
+	}
+}
+
+// Stats returns the queue-wait metrics
+func (t *LLMThrottle) Stats() LLMThrottleStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := LLMThrottleStats{
+		Granted:   t.granted,
+		MaxWaitMs: float64(t.maxWait) / float64(time.Millisecond),
+	}
+	for _, queue := range t.queues {
+		stats.Queued += len(queue)
+	}
+	if t.granted > 0 {
+		stats.AverageWaitMs = float64(t.totalWait) / float64(t.granted) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// dispatch grants available tokens round-robin across calls; the caller must hold the lock
+func (t *LLMThrottle) dispatch() {
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+
+	for len(t.order) > 0 && t.tokens >= 1 {
+		callSID := t.order[0]
+		t.order = t.order[1:]
+
+		queue := t.queues[callSID]
+		waiter := queue[0]
+		if len(queue) > 1 {
+			t.queues[callSID] = queue[1:]
+			// Back of the line until every other call has had a turn
+			t.order = append(t.order, callSID)
+		} else {
+			delete(t.queues, callSID)
+		}
+
+		t.tokens--
+		wait := now.Sub(waiter.queuedAt)
+		t.granted++
+		t.totalWait += wait
+		if wait > t.maxWait {
+			t.maxWait = wait
+		}
+		if wait > time.Second {
+			t.log.Info("LLM request for call %s waited %v for the throttle", callSID, wait)
+		}
+		close(waiter.ready)
+	}
+
+	// Come back when the next token is due
+	if len(t.order) > 0 && t.timer == nil {
+		delay := time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+		t.timer = time.AfterFunc(delay, func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timer = nil
+			t.dispatch()
+		})
+	}
+}
+
+// remove drops an abandoned waiter; the caller must hold the lock
+func (t *LLMThrottle) remove(callSID string, waiter *throttleWaiter) {
+	queue := t.queues[callSID]
+	for i, w := range queue {
+		if w == waiter {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		t.queues[callSID] = queue
+		return
+	}
+
+	delete(t.queues, callSID)
+	for i, sid := range t.order {
+		if sid == callSID {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// ThrottledGenerator gates another ResponseGenerator behind an LLMThrottle
+type ThrottledGenerator struct {
+	next     ResponseGenerator
+	throttle *LLMThrottle
+}
+
+// NewThrottledGenerator wraps a generator with the throttle
+func NewThrottledGenerator(next ResponseGenerator, throttle *LLMThrottle) *ThrottledGenerator {
+	return &ThrottledGenerator{next: next, throttle: throttle}
+}
+
+// GenerateResponse waits for the call's turn, then delegates to the wrapped generator
+func (g *ThrottledGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	if err := g.throttle.Acquire(ctx, CallSIDFromContext(ctx)); err != nil {
+		return "", err
+	}
+	return g.next.GenerateResponse(ctx, userMessage, conversationHistory)
+}
+
+// GenerateResponseStream waits for the call's turn, then streams from the wrapped generator;
+// one that can't stream has its whole response passed to onText at once
+func (g *ThrottledGenerator) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	if err := g.throttle.Acquire(ctx, CallSIDFromContext(ctx)); err != nil {
+		return "", err
+	}
+	if streaming, ok := g.next.(StreamingResponseGenerator); ok {
+		return streaming.GenerateResponseStream(ctx, userMessage, conversationHistory, onText)
+	}
+	response, err := g.next.GenerateResponse(ctx, userMessage, conversationHistory)
+	if err == nil {
+		onText(response)
+	}
+	return response, err
+}
+
+// GenerateResponseWithTools waits for the call's turn, then generates with the wrapped
+// generator's tools; one that can't call tools generates without them
+func (g *ThrottledGenerator) GenerateResponseWithTools(ctx context.Context, userMessage string, conversationHistory []string, tools *ToolDispatcher) (string, error) {
+	if err := g.throttle.Acquire(ctx, CallSIDFromContext(ctx)); err != nil {
+		return "", err
+	}
+	if calling, ok := g.next.(ToolCallingGenerator); ok {
+		return calling.GenerateResponseWithTools(ctx, userMessage, conversationHistory, tools)
+	}
+	return g.next.GenerateResponse(ctx, userMessage, conversationHistory)
+}
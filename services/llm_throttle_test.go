@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLLMThrottleSchedulesCallsFairly(t *testing.T) {
+	throttle := NewLLMThrottle(50, 1)
+
+	// Spend the initial burst so every request below has to queue
+	if err := throttle.Acquire(context.Background(), "warmup"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	granted := make(chan string, 4)
+	enqueue := func(callSID string) {
+		queued := throttle.Stats().Queued
+		go func() {
+			if err := throttle.Acquire(context.Background(), callSID); err == nil {
+				granted <- callSID
+			}
+		}()
+		for throttle.Stats().Queued == queued {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A chatty call queues three requests before a quiet call queues one
+	enqueue("chatty")
+	enqueue("chatty")
+	enqueue("chatty")
+	enqueue("quiet")
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		select {
+		case callSID := <-granted:
+			order = append(order, callSID)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for grants, got %v", order)
+		}
+	}
+
+	if order[1] != "quiet" {
+		t.Errorf("Expected the quiet call to be served second, got order %v", order)
+	}
+	if stats := throttle.Stats(); stats.Granted != 5 || stats.MaxWaitMs <= 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestLLMThrottleAbandonsOnContextCancel(t *testing.T) {
+	throttle := NewLLMThrottle(0.1, 1)
+	throttle.Acquire(context.Background(), "call")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := throttle.Acquire(ctx, "call"); err == nil {
+		t.Fatal("Expected context error while waiting for a token")
+	}
+	if queued := throttle.Stats().Queued; queued != 0 {
+		t.Errorf("Expected abandoned request to leave the queue, %d still queued", queued)
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TermMasker hides sensitive terms in transcripts before they are stored or exported,
+// keeping the first letter like the providers' profanity filters do ("d***")
+type TermMasker struct {
+	pattern *regexp.Regexp
+}
+
+// NewTermMasker creates a masker for the terms, matched as whole words regardless of case.
+// It returns nil, which masks nothing, when there are no terms.
+func NewTermMasker(terms []string) *TermMasker {
+	var quoted []string
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+
+	// Longest first so phrases win over the words inside them
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return &TermMasker{pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)}
+}
+
+// LoadTermMasker reads one term per line from path, skipping blank lines and # comments.
+// An empty path masks nothing.
+func LoadTermMasker(path string) (*TermMasker, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var terms []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		terms = append(terms, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewTermMasker(terms), nil
+}
+
+// Mask replaces every letter of each sensitive term after the first with an asterisk
+func (m *TermMasker) Mask(text string) string {
+	if m == nil {
+		return text
+	}
+	return m.pattern.ReplaceAllStringFunc(text, maskTerm)
+}
+
+// MaskWords masks the recognized words that make up sensitive terms
+func (m *TermMasker) MaskWords(words []WordTiming) []WordTiming {
+	if m == nil || len(words) == 0 {
+		return words
+	}
+
+	// Mask the joined text so multi-word terms are found, then split it back over the words
+	text := make([]string, len(words))
+	for i, word := range words {
+		text[i] = word.Word
+	}
+	masked := strings.Fields(m.Mask(strings.Join(text, " ")))
+	if len(masked) != len(words) {
+		return words
+	}
+
+	out := make([]WordTiming, len(words))
+	for i, word := range words {
+		word.Word = masked[i]
+		out[i] = word
+	}
+	return out
+}
+
+// maskTerm keeps the first letter of each word in the term and stars the rest
+func maskTerm(term string) string {
+	var b strings.Builder
+	first := true
+	for _, r := range term {
+		switch {
+		case r == ' ' || r == '\t':
+			first = true
+			b.WriteRune(r)
+		case first:
+			first = false
+			b.WriteRune(r)
+		default:
+			b.WriteRune('*')
+		}
+	}
+	return b.String()
+}
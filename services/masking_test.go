@@ -0,0 +1,63 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTermMaskerMasksWholeTermsIgnoringCase(t *testing.T) {
+	masker := NewTermMasker([]string{"damn", "acme pharmacy"})
+
+	cases := map[string]string{
+		"Damn, I went to Acme Pharmacy today": "D***, I went to A*** P******* today",
+		"the dam is full":                     "the dam is full",
+		"damnation":                           "damnation",
+	}
+	for input, want := range cases {
+		if got := masker.Mask(input); got != want {
+			t.Errorf("Mask(%q): expected %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestTermMaskerMasksWordTimings(t *testing.T) {
+	masker := NewTermMasker([]string{"acme pharmacy"})
+	words := []WordTiming{
+		{Word: "at", Start: 0, End: 100 * time.Millisecond},
+		{Word: "Acme", Start: 100 * time.Millisecond, End: 300 * time.Millisecond},
+		{Word: "Pharmacy", Start: 300 * time.Millisecond, End: 700 * time.Millisecond},
+	}
+
+	masked := masker.MaskWords(words)
+	if masked[0].Word != "at" || masked[1].Word != "A***" || masked[2].Word != "P*******" {
+		t.Errorf("Expected the phrase masked across words, got %+v", masked)
+	}
+	if masked[2].Start != 300*time.Millisecond {
+		t.Errorf("Expected timings to be kept, got %+v", masked[2])
+	}
+	if words[1].Word != "Acme" {
+		t.Error("Expected the original words to be left alone")
+	}
+}
+
+func TestLoadTermMaskerSkipsCommentsAndBlankLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "terms.txt")
+	if err := os.WriteFile(path, []byte("# clinic names\n\nacme\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	masker, err := LoadTermMasker(path)
+	if err != nil {
+		t.Fatalf("Failed to load terms: %v", err)
+	}
+	if got := masker.Mask("acme and clinic names"); got != "a*** and clinic names" {
+		t.Errorf("Expected only the listed term masked, got %q", got)
+	}
+
+	var none *TermMasker
+	if got := none.Mask("acme"); got != "acme" {
+		t.Errorf("Expected a nil masker to leave text alone, got %q", got)
+	}
+}
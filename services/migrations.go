@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// schemaFiles are the versioned schema changes of the SQL storage backends
+//
+//go:embed migrations/*.sql
+var schemaFiles embed.FS
+
+// migrationName is how schema files are named: a version, then a name, e.g. 0003_add_tags.sql
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// LoadMigrations reads the schema files in fsys, ordered by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, path := range paths {
+		match := migrationName.FindStringSubmatch(path)
+		if match == nil {
+			return nil, fmt.Errorf("schema file %s isn't named <version>_<name>.sql", path)
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: match[2], SQL: string(data)})
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("schema files %s and %s share version %d", migrations[i-1].Name, migrations[i].Name, migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate brings the database's schema up to date with the embedded schema files
+func Migrate(ctx context.Context, db *sql.DB) (int, error) {
+	sub, err := fs.Sub(schemaFiles, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	migrations, err := LoadMigrations(sub)
+	if err != nil {
+		return 0, err
+	}
+	return applyMigrations(ctx, db, migrations)
+}
+
+// applyMigrations applies the migrations the database hasn't had yet, oldest first, each
+// in a transaction that also records its version in schema_migrations. It returns how
+// many were applied.
+func applyMigrations(ctx context.Context, db *sql.DB, migrations []Migration) (int, error) {
+	log := logger.Component("Migrations")
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return 0, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return 0, fmt.Errorf("reading applied migrations: %w", err)
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		log.Info("Applying migration %d %s", migration.Version, migration.Name)
+		if err := applyMigration(ctx, db, migration); err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	log.Info("Schema is up to date, %d migration(s) applied", count)
+	return count, nil
+}
+
+// applyMigration runs a migration's statements and records it, all or nothing where the
+// database supports transactional DDL
+func applyMigration(ctx context.Context, db *sql.DB, migration Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitStatements(migration.SQL) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	// Versions and names come from file names, so they are safe to inline, which
+	// avoids the drivers' differing placeholders
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO schema_migrations (version, name) VALUES (%d, '%s')", migration.Version, migration.Name)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitStatements splits a schema file into statements, which end with a semicolon at
+// the end of a line, dropping comment lines
+func splitStatements(script string) []string {
+	var statements []string
+	var current []string
+	for _, line := range strings.Split(script, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statement := strings.TrimSuffix(strings.TrimSpace(strings.Join(current, "\n")), ";")
+			statements = append(statements, statement)
+			current = nil
+		}
+	}
+	if statement := strings.TrimSpace(strings.Join(current, "\n")); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
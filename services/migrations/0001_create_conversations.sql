@@ -0,0 +1,9 @@
+-- Session records of calls evicted from memory, as JSON, sealed when encryption is on
+CREATE TABLE IF NOT EXISTS conversations (
+    call_sid    VARCHAR(64) PRIMARY KEY,
+    caller_hash VARCHAR(64),
+    started_at  TIMESTAMP NOT NULL,
+    encrypted   BOOLEAN NOT NULL DEFAULT FALSE,
+    content     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS conversations_caller_hash ON conversations (caller_hash, started_at);
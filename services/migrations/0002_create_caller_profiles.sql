@@ -0,0 +1,7 @@
+-- Callers' profiles, as JSON, sealed when encryption is on
+CREATE TABLE IF NOT EXISTS caller_profiles (
+    caller_hash VARCHAR(64) PRIMARY KEY,
+    updated_at  TIMESTAMP NOT NULL,
+    encrypted   BOOLEAN NOT NULL DEFAULT FALSE,
+    content     TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+-- The queue of callback offers, as JSON, sealed when encryption is on
+CREATE TABLE IF NOT EXISTS callback_queues (
+    name        VARCHAR(64) PRIMARY KEY,
+    updated_at  TIMESTAMP NOT NULL,
+    encrypted   BOOLEAN NOT NULL DEFAULT FALSE,
+    content     TEXT NOT NULL
+);
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeDatabase records the statements run through the fake driver and the migration
+// versions inserted, failing statements that contain failOn
+type fakeDatabase struct {
+	statements []string
+	versions   []int64
+	failOn     string
+	mu         sync.Mutex
+}
+
+var (
+	fakeDatabases   = map[string]*fakeDatabase{}
+	fakeDatabasesMu sync.Mutex
+	insertedVersion = regexp.MustCompile(`INSERT INTO schema_migrations \(version, name\) VALUES \((\d+),`)
+)
+
+func init() {
+	sql.Register("fakesql", fakeDriver{})
+}
+
+// openFakeDatabase opens a database/sql handle on a new fake database
+func openFakeDatabase(t *testing.T, name string) (*sql.DB, *fakeDatabase) {
+	t.Helper()
+	fake := &fakeDatabase{}
+	fakeDatabasesMu.Lock()
+	fakeDatabases[name] = fake
+	fakeDatabasesMu.Unlock()
+	db, err := sql.Open("fakesql", name)
+	if err != nil {
+		t.Fatalf("Expected the fake database opened, got %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDatabasesMu.Lock()
+	defer fakeDatabasesMu.Unlock()
+	return &fakeConn{db: fakeDatabases[name]}, nil
+}
+
+type fakeConn struct {
+	db      *fakeDatabase
+	pending []string // Statements of the open transaction
+	pendVer []int64
+	inTx    bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx, c.pending, c.pendVer = true, nil, nil
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.statements = append(c.db.statements, c.pending...)
+	c.db.versions = append(c.db.versions, c.pendVer...)
+	c.inTx = false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.inTx = false
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	if db.failOn != "" && strings.Contains(s.query, db.failOn) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var version []int64
+	if match := insertedVersion.FindStringSubmatch(s.query); match != nil {
+		v, _ := strconv.ParseInt(match[1], 10, 64)
+		version = append(version, v)
+	}
+	if s.conn.inTx {
+		s.conn.pending = append(s.conn.pending, s.query)
+		s.conn.pendVer = append(s.conn.pendVer, version...)
+		return driver.RowsAffected(1), nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = append(db.statements, s.query)
+	db.versions = append(db.versions, version...)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return &fakeRows{versions: append([]int64(nil), db.versions...)}, nil
+}
+
+type fakeRows struct {
+	versions []int64
+}
+
+func (r *fakeRows) Columns() []string { return []string{"version"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.versions) == 0 {
+		return io.EOF
+	}
+	dest[0], r.versions = r.versions[0], r.versions[1:]
+	return nil
+}
+
+func TestMigrateAppliesPendingMigrationsOnce(t *testing.T) {
+	db, fake := openFakeDatabase(t, "migrate")
+	applied, err := Migrate(context.Background(), db)
+	if err != nil || applied != 3 {
+		t.Fatalf("Expected every schema file applied, got %d, %v", applied, err)
+	}
+	all := strings.Join(fake.statements, "\n")
+	for _, want := range []string{"CREATE TABLE IF NOT EXISTS schema_migrations", "CREATE TABLE IF NOT EXISTS conversations", "CREATE INDEX IF NOT EXISTS conversations_caller_hash", "CREATE TABLE IF NOT EXISTS caller_profiles", "CREATE TABLE IF NOT EXISTS callback_queues"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected %q run, got:\n%s", want, all)
+		}
+	}
+
+	if applied, err := Migrate(context.Background(), db); err != nil || applied != 0 {
+		t.Errorf("Expected nothing left to apply, got %d, %v", applied, err)
+	}
+}
+
+func TestMigrateStopsAtAFailingMigration(t *testing.T) {
+	db, fake := openFakeDatabase(t, "failing")
+	fake.failOn = "broken"
+	migrations := []Migration{
+		{Version: 1, Name: "first", SQL: "CREATE TABLE a (id INTEGER);"},
+		{Version: 2, Name: "second", SQL: "CREATE TABLE b (id INTEGER);\nCREATE TABLE broken (id INTEGER);"},
+		{Version: 3, Name: "third", SQL: "CREATE TABLE c (id INTEGER);"},
+	}
+	applied, err := applyMigrations(context.Background(), db, migrations)
+	if err == nil || applied != 1 || !strings.Contains(err.Error(), "migration 2 second") {
+		t.Fatalf("Expected the run stopped at the second migration, got %d, %v", applied, err)
+	}
+	if len(fake.versions) != 1 || strings.Contains(strings.Join(fake.statements, "\n"), "CREATE TABLE b") {
+		t.Errorf("Expected the failed migration rolled back, got %v", fake.statements)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"0002_add_index.sql": {Data: []byte("CREATE INDEX i ON t (c);")},
+		"0001_create.sql":    {Data: []byte("CREATE TABLE t (c INTEGER);")},
+	})
+	if err != nil || len(migrations) != 2 || migrations[0].Name != "create" || migrations[1].Version != 2 {
+		t.Errorf("Expected the migrations by version, got %+v, %v", migrations, err)
+	}
+	if _, err := LoadMigrations(fstest.MapFS{"create.sql": {}}); err == nil {
+		t.Error("Expected a file without a version rejected")
+	}
+	if _, err := LoadMigrations(fstest.MapFS{"1_a.sql": {}, "01_b.sql": {}}); err == nil {
+		t.Error("Expected two files with one version rejected")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("-- A table\nCREATE TABLE t (\n    c INTEGER\n);\n\nCREATE INDEX i ON t (c);\n")
+	if len(got) != 2 || got[0] != "CREATE TABLE t (\n    c INTEGER\n)" || got[1] != "CREATE INDEX i ON t (c)" {
+		t.Errorf("Expected two statements, got %q", got)
+	}
+}
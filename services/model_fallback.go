@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// errLatencyBudget is the cause of a primary model call cut short by the latency budget
+var errLatencyBudget = errors.New("primary model exceeded the latency budget")
+
+// modelContextKey is the context key type for a model replacing the configured one
+type modelContextKey struct{}
+
+// WithModel returns a context asking the LLM provider to use the model instead of the
+// configured or persona's one
+func WithModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelContextKey{}, model)
+}
+
+// modelFromContext returns the model stored by WithModel, or "" when there is none
+func modelFromContext(ctx context.Context) string {
+	model, _ := ctx.Value(modelContextKey{}).(string)
+	return model
+}
+
+// GenerationReport records how a response was generated
+type GenerationReport struct {
+	Model    string // Model that produced the response
+	Fallback bool   // Produced by the fallback model after the primary failed
+	// PromptTokens estimates the size of the prompt sent, with the system prompt
+	PromptTokens int
+}
+
+// generationReportContextKey is the context key type for the turn's generation report
+type generationReportContextKey struct{}
+
+// WithGenerationReport returns a context in which the LLM provider records the model it
+// used into the returned report
+func WithGenerationReport(ctx context.Context) (context.Context, *GenerationReport) {
+	report := &GenerationReport{}
+	return context.WithValue(ctx, generationReportContextKey{}, report), report
+}
+
+// withoutGenerationReport detaches work that outlives a turn, e.g. a summary, from the
+// turn's report
+func withoutGenerationReport(ctx context.Context) context.Context {
+	return context.WithValue(ctx, generationReportContextKey{}, (*GenerationReport)(nil))
+}
+
+// reportModel records the model a provider is generating with
+func reportModel(ctx context.Context, model string) {
+	if report, _ := ctx.Value(generationReportContextKey{}).(*GenerationReport); report != nil {
+		report.Model = model
+	}
+}
+
+// FallbackGenerator retries on a fallback model when the primary model fails or doesn't
+// answer within the latency budget, before the turn resorts to a canned phrase. Both
+// models are served by the same provider.
+type FallbackGenerator struct {
+	next          ResponseGenerator
+	fallbackModel string
+	// budget is how long the primary model has to respond, or to start streaming; 0 waits
+	// for the provider's own timeout
+	budget time.Duration
+	log    *logger.Logger
+}
+
+// NewFallbackGenerator wraps a generator to retry on the fallback model
+func NewFallbackGenerator(next ResponseGenerator, fallbackModel string, budget time.Duration) *FallbackGenerator {
+	return &FallbackGenerator{
+		next:          next,
+		fallbackModel: fallbackModel,
+		budget:        budget,
+		log:           logger.Component("ModelFallback"),
+	}
+}
+
+// GenerateResponse generates with the primary model, then with the fallback one if it fails
+func (g *FallbackGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	primaryCtx, cancel := g.primaryContext(ctx)
+	response, err := g.next.GenerateResponse(primaryCtx, userMessage, conversationHistory)
+	cancel()
+	if !g.retry(ctx, primaryCtx, err) {
+		return response, err
+	}
+
+	g.markFallback(ctx)
+	return g.next.GenerateResponse(WithModel(ctx, g.fallbackModel), userMessage, conversationHistory)
+}
+
+// GenerateResponseStream streams from the primary model, then from the fallback one if the
+// primary fails before any text arrives. Once text has been passed on it can't be taken
+// back, so later failures are returned as they are.
+func (g *FallbackGenerator) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	streaming, ok := g.next.(StreamingResponseGenerator)
+	if !ok {
+		response, err := g.GenerateResponse(ctx, userMessage, conversationHistory)
+		if err == nil {
+			onText(response)
+		}
+		return response, err
+	}
+
+	// The budget covers the wait for the first text, not the whole stream
+	primaryCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var started atomic.Bool
+	if g.budget > 0 {
+		timer := time.AfterFunc(g.budget, func() {
+			if !started.Load() {
+				cancel(errLatencyBudget)
+			}
+		})
+		defer timer.Stop()
+	}
+
+	response, err := streaming.GenerateResponseStream(primaryCtx, userMessage, conversationHistory, func(text string) {
+		started.Store(true)
+		onText(text)
+	})
+	if started.Load() || !g.retry(ctx, primaryCtx, err) {
+		return response, err
+	}
+
+	g.markFallback(ctx)
+	return streaming.GenerateResponseStream(WithModel(ctx, g.fallbackModel), userMessage, conversationHistory, onText)
+}
+
+// GenerateResponseWithTools generates with the wrapped generator's tools. Tool turns are not
+// retried on the fallback model, since the primary may already have acted for the caller.
+func (g *FallbackGenerator) GenerateResponseWithTools(ctx context.Context, userMessage string, conversationHistory []string, tools *ToolDispatcher) (string, error) {
+	if calling, ok := g.next.(ToolCallingGenerator); ok {
+		return calling.GenerateResponseWithTools(ctx, userMessage, conversationHistory, tools)
+	}
+	return g.GenerateResponse(ctx, userMessage, conversationHistory)
+}
+
+// primaryContext bounds the primary model's call by the latency budget
+func (g *FallbackGenerator) primaryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, g.budget, errLatencyBudget)
+}
+
+// retry reports whether a failed primary call should be retried on the fallback model,
+// which it shouldn't when it succeeded or the turn itself was cancelled
+func (g *FallbackGenerator) retry(ctx, primaryCtx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	callSID := CallSIDFromContext(ctx)
+	if errors.Is(context.Cause(primaryCtx), errLatencyBudget) {
+		g.log.Warn("Primary model took over %v for call %s, retrying on %s", g.budget, callSID, g.fallbackModel)
+	} else {
+		g.log.Warn("Primary model failed for call %s, retrying on %s: %v", callSID, g.fallbackModel, err)
+	}
+	return true
+}
+
+// markFallback records in the turn's report that the fallback model answered
+func (g *FallbackGenerator) markFallback(ctx context.Context) {
+	if report, _ := ctx.Value(generationReportContextKey{}).(*GenerationReport); report != nil {
+		report.Fallback = true
+	}
+}
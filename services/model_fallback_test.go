@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// modelGenerator is a mocked provider that behaves per model: the primary, "", fails or
+// hangs and the fallback answers
+type modelGenerator struct {
+	hang     bool     // The primary blocks until its context ends instead of failing
+	chunks   []string // Streamed by the primary before it fails
+	requests []string // Models asked for, in order
+}
+
+func (m *modelGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	return m.GenerateResponseStream(ctx, userMessage, conversationHistory, func(string) {})
+}
+
+func (m *modelGenerator) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	model := modelFromContext(ctx)
+	m.requests = append(m.requests, model)
+	if model == "" {
+		reportModel(ctx, "primary")
+		for _, chunk := range m.chunks {
+			onText(chunk)
+		}
+		if m.hang {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "", errors.New("model overloaded")
+	}
+	reportModel(ctx, model)
+	onText("From " + model + ".")
+	return "From " + model + ".", nil
+}
+
+func TestFallbackGeneratorRetriesOnFallbackModel(t *testing.T) {
+	next := &modelGenerator{}
+	generator := NewFallbackGenerator(next, "flash", 0)
+	ctx, report := WithGenerationReport(context.Background())
+
+	response, err := generator.GenerateResponse(ctx, "hi", nil)
+	if err != nil || response != "From flash." {
+		t.Fatalf("Expected the fallback model's response, got %q, %v", response, err)
+	}
+	if report.Model != "flash" || !report.Fallback {
+		t.Errorf("Expected the fallback model recorded, got %+v", report)
+	}
+}
+
+func TestFallbackGeneratorRetriesWhenPrimaryExceedsBudget(t *testing.T) {
+	next := &modelGenerator{hang: true}
+	generator := NewFallbackGenerator(next, "flash", 20*time.Millisecond)
+
+	start := time.Now()
+	response, err := generator.GenerateResponseStream(context.Background(), "hi", nil, func(string) {})
+	if err != nil || response != "From flash." {
+		t.Fatalf("Expected the fallback model's response, got %q, %v", response, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the primary cut off at the budget, took %v", elapsed)
+	}
+}
+
+func TestFallbackGeneratorKeepsStreamThatStarted(t *testing.T) {
+	next := &modelGenerator{chunks: []string{"I hear "}}
+	generator := NewFallbackGenerator(next, "flash", 0)
+
+	var streamed string
+	_, err := generator.GenerateResponseStream(context.Background(), "hi", nil, func(text string) { streamed += text })
+	if err == nil || streamed != "I hear " || len(next.requests) != 1 {
+		t.Errorf("Expected the primary's failure without a retry once text was streamed, got %v %q %q", err, streamed, next.requests)
+	}
+}
+
+func TestTurnEngineRecordsResponseModel(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := NewFallbackGenerator(&modelGenerator{}, "flash", 0)
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+
+	turn := engine.ProcessTranscription(context.Background(), "hello")
+
+	if turn.Action != ActionRespond || turn.Model != "flash" {
+		t.Errorf("Expected the fallback model's response, got %+v", turn)
+	}
+	if messages := conversation.Transcript(); messages[len(messages)-1].Model != "flash" {
+		t.Errorf("Expected the model stored with the message, got %+v", messages[len(messages)-1])
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// moodShift is how far apart two calls' moods must be to count as a change
+const moodShift = 0.3
+
+// Directions a caller's mood can move between calls
+const (
+	MoodBrighter = "brighter"
+	MoodLower    = "lower"
+	MoodSteady   = "steady"
+)
+
+// CallMood is how a caller sounded over one call: the mean sentiment of what they said
+// and the emotion heard most often
+type CallMood struct {
+	CallSID   string    `json:"callSid"`
+	StartedAt time.Time `json:"startedAt"`
+	Score     float64   `json:"score"`
+	Emotion   string    `json:"emotion,omitempty"`
+}
+
+// MoodTrend is how a caller's mood moved across their calls
+type MoodTrend struct {
+	CallerHash string     `json:"callerHash"`
+	Calls      []CallMood `json:"calls"` // Oldest first
+	Average    float64    `json:"average"`
+	// Change is the latest call's score less the one before it, and Direction what that
+	// amounts to; Direction is empty until there are two calls
+	Change    float64 `json:"change"`
+	Direction string  `json:"direction,omitempty"`
+}
+
+// callMood sums up the sentiment of the caller's messages; ok is false when none was scored
+func callMood(points []SentimentPoint) (score float64, emotion string, ok bool) {
+	if len(points) == 0 {
+		return 0, "", false
+	}
+	counts := make(map[string]int)
+	for _, point := range points {
+		score += point.Score
+		if point.Emotion != "" {
+			counts[point.Emotion]++
+		}
+	}
+	for _, candidate := range emotionPriority {
+		if counts[candidate] > counts[emotion] {
+			emotion = candidate
+		}
+	}
+	return math.Round(score/float64(len(points))*100) / 100, emotion, true
+}
+
+// moodDirection tells which way the mood moved by the change
+func moodDirection(change float64) string {
+	switch {
+	case change >= moodShift:
+		return MoodBrighter
+	case change <= -moodShift:
+		return MoodLower
+	}
+	return MoodSteady
+}
+
+// addMood records the mood of one of the caller's calls, replacing any earlier record of it
+func (p *CallerProfile) addMood(mood CallMood) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.moods = slices.DeleteFunc(p.moods, func(m CallMood) bool { return m.CallSID == mood.CallSID })
+	p.moods = append(p.moods, mood)
+	slices.SortStableFunc(p.moods, func(a, b CallMood) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+}
+
+// MoodTrend returns how the caller's mood moved across the calls it was recorded for
+func (p *CallerProfile) MoodTrend() MoodTrend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	trend := MoodTrend{CallerHash: p.CallerHash, Calls: append([]CallMood{}, p.moods...)}
+	if n := len(p.moods); n > 0 {
+		var total float64
+		for _, mood := range p.moods {
+			total += mood.Score
+		}
+		trend.Average = math.Round(total/float64(n)*100) / 100
+		if n > 1 {
+			trend.Change = math.Round((p.moods[n-1].Score-p.moods[n-2].Score)*100) / 100
+			trend.Direction = moodDirection(trend.Change)
+		}
+	}
+	return trend
+}
+
+// lastMood returns the mood of the caller's latest call before the one starting at the time
+func (p *CallerProfile) lastMood(callSID string, startedAt time.Time) *CallMood {
+	for i := len(p.moods) - 1; i >= 0; i-- {
+		if mood := p.moods[i]; mood.CallSID != callSID && mood.StartedAt.Before(startedAt) {
+			return &mood
+		}
+	}
+	return nil
+}
+
+// MoodContinuityNote compares how the caller sounds so far with their last call for the
+// prompt, so the AI can acknowledge it, e.g. that they sound brighter than last time. It
+// is empty until the caller has said a couple of things, and when nothing changed.
+func MoodContinuityNote(last *CallMood, trajectory []SentimentPoint) string {
+	if last == nil || len(trajectory) < 2 {
+		return ""
+	}
+	score, _, _ := callMood(trajectory)
+	when := "on " + last.StartedAt.Format("January 2")
+	switch moodDirection(score - last.Score) {
+	case MoodBrighter:
+		return fmt.Sprintf("The caller sounds brighter than on their last call %s (sentiment %.2f now, %.2f then). "+
+			"If it fits and you haven't already, gently acknowledge it, e.g. that they sound a bit brighter than last time.", when, score, last.Score)
+	case MoodLower:
+		return fmt.Sprintf("The caller sounds lower than on their last call %s (sentiment %.2f now, %.2f then). "+
+			"If you haven't already, gently check in on how things have been since.", when, score, last.Score)
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallerMoodAcrossCalls(t *testing.T) {
+	service := NewConversationService()
+	start := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	var profile *CallerProfile
+	for i, scores := range [][]float64{{-0.6, -0.4}, {-0.2, 0.4}} {
+		conv := service.GetOrCreateConversation([]string{"first", "second"}[i])
+		conv.CreatedAt = start.AddDate(0, 0, 7*i)
+		profile = service.AttachCaller(conv, "hash")
+		for _, score := range scores {
+			conv.AddUserMessage("...")
+			conv.SetLastUserSentiment(Sentiment{Score: score, Emotion: EmotionAnxiety})
+		}
+		conv.End()
+		conv.End()
+	}
+
+	trend := profile.MoodTrend()
+	if len(trend.Calls) != 2 || trend.Calls[0].Score != -0.5 || trend.Calls[0].Emotion != EmotionAnxiety || trend.Calls[1].Score != 0.1 {
+		t.Fatalf("Expected each call's mean mood recorded once, got %+v", trend.Calls)
+	}
+	if trend.Average != -0.2 || trend.Change != 0.6 || trend.Direction != MoodBrighter {
+		t.Errorf("Expected a brighter trend, got %+v", trend)
+	}
+
+	third := service.GetOrCreateConversation("third")
+	third.CreatedAt = start.AddDate(0, 0, 14)
+	service.AttachCaller(third, "hash")
+	caller := service.PromptCaller(third)
+	if caller.LastMood == nil || caller.LastMood.CallSID != "second" {
+		t.Fatalf("Expected the last call's mood for the prompt, got %+v", caller.LastMood)
+	}
+	if note := profile.PromptContext("third", third.CreatedAt); !strings.Contains(note, "On their last call on March 8 they sounded anxious (sentiment 0.10") {
+		t.Errorf("Expected the last mood in the context, got %q", note)
+	}
+}
+
+func TestMoodContinuityNote(t *testing.T) {
+	last := &CallMood{StartedAt: time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC), Score: -0.5}
+	points := func(scores ...float64) (trajectory []SentimentPoint) {
+		for _, score := range scores {
+			trajectory = append(trajectory, SentimentPoint{Sentiment: Sentiment{Score: score}})
+		}
+		return trajectory
+	}
+
+	for _, tc := range []struct {
+		name string
+		last *CallMood
+		now  []SentimentPoint
+		want string
+	}{
+		{"first call", nil, points(0.2, 0.4), ""},
+		{"too early to tell", last, points(0.5), ""},
+		{"brighter", last, points(0, 0.2), "sounds brighter than on their last call on March 1 (sentiment 0.10 now, -0.50 then)"},
+		{"lower", &CallMood{StartedAt: last.StartedAt, Score: 0.4}, points(-0.2, 0), "sounds lower than on their last call"},
+		{"steady", last, points(-0.5, -0.3), ""},
+	} {
+		note := MoodContinuityNote(tc.last, tc.now)
+		if tc.want == "" && note != "" || !strings.Contains(note, tc.want) {
+			t.Errorf("%s: expected %q in the note, got %q", tc.name, tc.want, note)
+		}
+	}
+}
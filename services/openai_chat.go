@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// OpenAIChatService implements ResponseGenerator over the OpenAI Chat Completions API, or
+// any server compatible with it, such as Ollama
+type OpenAIChatService struct {
+	config  *config.Config
+	client  *http.Client
+	prompts *PromptStore
+	params  *GenerationSettings
+	url     string
+	model   string
+	apiKey  string // Optional for local servers
+	log     *logger.Logger
+}
+
+// NewOpenAIChatService creates a new OpenAI response generator whose system prompt is
+// rendered from the store's templates; a nil store uses the built-in prompt
+func NewOpenAIChatService(cfg *config.Config, prompts *PromptStore, generation *GenerationSettings) (*OpenAIChatService, error) {
+	log := logger.Component("OpenAIChat")
+	log.Info("Creating new OpenAI chat service with model %s", cfg.OpenAIModel)
+
+	if cfg.OpenAIAPIKey == "" {
+		log.Error("OPENAI_API_KEY environment variable not set")
+		return nil, errors.New("OPENAI_API_KEY is required for the openai LLM provider")
+	}
+
+	return &OpenAIChatService{
+		config:  cfg,
+		client:  &http.Client{},
+		prompts: prompts,
+		params:  generation,
+		url:     cfg.OpenAIChatURL,
+		model:   cfg.OpenAIModel,
+		apiKey:  cfg.OpenAIAPIKey,
+		log:     log,
+	}, nil
+}
+
+// NewOllamaService creates a response generator for a self-hosted model served by Ollama,
+// or another OpenAI-compatible server, so transcripts never leave the deployment
+func NewOllamaService(cfg *config.Config, prompts *PromptStore, generation *GenerationSettings) (*OpenAIChatService, error) {
+	log := logger.Component("Ollama")
+	log.Info("Creating new Ollama service with model %s at %s", cfg.OllamaModel, cfg.OllamaURL)
+
+	if cfg.OllamaURL == "" || cfg.OllamaModel == "" {
+		log.Error("OLLAMA_URL or OLLAMA_MODEL environment variable not set")
+		return nil, errors.New("OLLAMA_URL and OLLAMA_MODEL are required for the ollama LLM provider")
+	}
+
+	return &OpenAIChatService{
+		config:  cfg,
+		client:  &http.Client{},
+		prompts: prompts,
+		params:  generation,
+		url:     cfg.OllamaURL,
+		model:   cfg.OllamaModel,
+		apiKey:  cfg.OllamaAPIKey,
+		log:     log,
+	}, nil
+}
+
+// Close is a no-op; OpenAI requests are stateless
+func (o *OpenAIChatService) Close() error {
+	o.log.Info("Closing OpenAI chat service")
+	return nil
+}
+
+// GenerateResponse generates a therapeutic response based on user input and conversation history
+func (o *OpenAIChatService) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	log := CallLogger(ctx, o.log)
+	startTime := time.Now()
+	log.Info("Generating response for message: %q", logger.Transcript(userMessage))
+
+	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := o.post(genCtx, userMessage, conversationHistory, false)
+	if err != nil {
+		log.Error("OpenAI API error after %v: %v", time.Since(startTime), err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var completion struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 {
+		log.Warn("OpenAI returned no choices")
+		return "", nil
+	}
+
+	response := completion.Choices[0].Message.Content
+	log.Info("OpenAI response (%d chars, %v): %q", len(response), time.Since(startTime), logger.Transcript(response))
+	return response, nil
+}
+
+// GenerateResponseStream generates a response like GenerateResponse, streaming it from the
+// model and calling onText with each piece of text as it arrives
+func (o *OpenAIChatService) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	log := CallLogger(ctx, o.log)
+	startTime := time.Now()
+	log.Info("Streaming response for message: %q", logger.Transcript(userMessage))
+
+	genCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := o.post(genCtx, userMessage, conversationHistory, true)
+	if err != nil {
+		log.Error("OpenAI API error after %v: %v", time.Since(startTime), err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// The stream is server-sent events, one JSON chunk per data line, ending with [DONE]
+	var response strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta chatMessage `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return response.String(), fmt.Errorf("openai chat: bad stream chunk: %w", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		if response.Len() == 0 {
+			log.Debug("First OpenAI chunk received in %v", time.Since(startTime))
+		}
+		response.WriteString(chunk.Choices[0].Delta.Content)
+		onText(chunk.Choices[0].Delta.Content)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error("OpenAI streaming error after %v: %v", time.Since(startTime), err)
+		return response.String(), err
+	}
+
+	log.Info("OpenAI streamed response (%d chars, %v): %q", response.Len(), time.Since(startTime), logger.Transcript(response.String()))
+	return response.String(), nil
+}
+
+// post sends the chat completion request and checks its status
+func (o *OpenAIChatService) post(ctx context.Context, userMessage string, conversationHistory []string, stream bool) (*http.Response, error) {
+	log := CallLogger(ctx, o.log)
+	system, messages := chatMessages(systemPrompt(ctx, o.prompts, o.config), userMessage, conversationHistory)
+	request := map[string]interface{}{
+		"model":    o.model,
+		"messages": append([]chatMessage{{Role: "system", Content: system}}, messages...),
+		"stream":   stream,
+	}
+	// Chat Completions has no top-k
+	params := o.params.For(ctx)
+	if params.Model != "" {
+		request["model"] = params.Model
+	}
+	reportModel(ctx, request["model"].(string))
+	reportPromptTokens(ctx, chatTokens(system, messages))
+	if params.Temperature != nil {
+		request["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		request["top_p"] = *params.TopP
+	}
+	if params.MaxOutputTokens > 0 {
+		request["max_tokens"] = params.MaxOutputTokens
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Built request with %d conversation history messages", len(conversationHistory))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("OpenAI chat returned status %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("openai chat: unexpected status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
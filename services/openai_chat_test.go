@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+// openAIChatRequest is the part of a chat completion request the tests check
+type openAIChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+func TestOpenAIChatSendsHistoryAsMessages(t *testing.T) {
+	var request openAIChatRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "That sounds hard."}}]}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAIAPIKey: "key", OpenAIModel: "gpt-4o-mini", OpenAIChatURL: server.URL}
+	openai, err := NewOpenAIChatService(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []string{"Context: The caller is a veteran.", "User: Hi", "Therapist: Hello, I'm here.", "User: I lost my job"}
+	response, err := openai.GenerateResponse(context.Background(), "I lost my job", history)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if response != "That sounds hard." {
+		t.Errorf("Expected the completion's content, got %q", response)
+	}
+	if auth != "Bearer key" || request.Model != "gpt-4o-mini" || request.Stream {
+		t.Errorf("Expected an authorized non-streaming request for the model, got %q %+v", auth, request)
+	}
+
+	want := []chatMessage{
+		{Role: "system", Content: defaultSystemPrompt + instructionShield + "\nThe caller is a veteran."},
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello, I'm here."},
+		{Role: "user", Content: "I lost my job"},
+	}
+	if !reflect.DeepEqual(request.Messages, want) {
+		t.Errorf("Expected messages %+v, got %+v", want, request.Messages)
+	}
+}
+
+func TestOpenAIChatStreamsResponse(t *testing.T) {
+	var request openAIChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"choices": [{"delta": {"role": "assistant"}}]}`,
+			`{"choices": [{"delta": {"content": "I hear "}}]}`,
+			`{"choices": [{"delta": {"content": "you."}}]}`,
+			`[DONE]`,
+		} {
+			w.Write([]byte("data: " + chunk + "\n\n"))
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAIAPIKey: "key", OpenAIModel: "gpt-4o-mini", OpenAIChatURL: server.URL}
+	openai, err := NewOpenAIChatService(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []string
+	response, err := openai.GenerateResponseStream(context.Background(), "I lost my job", nil, func(text string) {
+		chunks = append(chunks, text)
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream: %v", err)
+	}
+	if !request.Stream {
+		t.Error("Expected a streaming request")
+	}
+	if response != "I hear you." || !reflect.DeepEqual(chunks, []string{"I hear ", "you."}) {
+		t.Errorf("Expected the streamed pieces, got %q from %q", response, chunks)
+	}
+	if last := request.Messages[len(request.Messages)-1]; last.Role != "user" || last.Content != "I lost my job" {
+		t.Errorf("Expected the user message appended, got %+v", request.Messages)
+	}
+}
+
+func TestOpenAIChatReportsErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "rate limited"}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAIAPIKey: "key", OpenAIChatURL: server.URL}
+	openai, err := NewOpenAIChatService(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openai.GenerateResponse(context.Background(), "hi", nil); err == nil {
+		t.Error("Expected an error for a failed request")
+	}
+}
+
+func TestNewLLMProviderRejectsUnknownProvider(t *testing.T) {
+	if _, err := NewLLMProvider(context.Background(), &config.Config{LLMProvider: "eliza"}, nil, nil); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+	if _, err := NewLLMProvider(context.Background(), &config.Config{LLMProvider: "openai"}, nil, nil); err == nil {
+		t.Error("Expected an error for openai without an API key")
+	}
+}
+
+func TestOllamaUsesLocalEndpointWithoutAPIKey(t *testing.T) {
+	var request openAIChatRequest
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Values("Authorization")
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "I'm listening."}}]}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{LLMProvider: "ollama", OllamaURL: server.URL, OllamaModel: "llama3.1", OpenAIAPIKey: "not-for-ollama"}
+	ollama, err := NewLLMProvider(context.Background(), cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := ollama.GenerateResponse(context.Background(), "hi", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if response != "I'm listening." || request.Model != "llama3.1" {
+		t.Errorf("Expected the local model's response, got %q from %+v", response, request)
+	}
+	if len(auth) != 0 {
+		t.Errorf("Expected no credentials sent to the local server, got %q", auth)
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// openAIPCMRate is the sample rate of the raw PCM the OpenAI speech API returns
+const openAIPCMRate = 24000
+
+// OpenAITTSService implements SpeechSynthesizer over the OpenAI speech API
+type OpenAITTSService struct {
+	config *config.Config
+	client *http.Client
+	log    *logger.Logger
+}
+
+// NewOpenAITTSService creates a new OpenAI text-to-speech service
+func NewOpenAITTSService(cfg *config.Config) (*OpenAITTSService, error) {
+	log := logger.Component("OpenAITTS")
+	log.Info("Creating new OpenAI TTS service: model %s, voice %s", cfg.OpenAITTSModel, cfg.OpenAITTSVoice)
+
+	if cfg.OpenAIAPIKey == "" {
+		log.Error("OPENAI_API_KEY environment variable not set")
+		return nil, errors.New("OPENAI_API_KEY is required for the openai TTS provider")
+	}
+
+	return &OpenAITTSService{
+		config: cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		log:    log,
+	}, nil
+}
+
+// Close is a no-op; OpenAI requests are stateless
+func (o *OpenAITTSService) Close() error {
+	o.log.Info("Closing OpenAI TTS service")
+	return nil
+}
+
+// SynthesizeSpeech converts text to audio in the given output format
+func (o *OpenAITTSService) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	log := CallLogger(ctx, o.log)
+	startTime := time.Now()
+	format = format.Normalize()
+	log.Info("Synthesizing speech for text (%d chars): %q", len(text), logger.Transcript(text))
+
+	// The API has a speed but no pitch setting
+	body, err := json.Marshal(map[string]interface{}{
+		"model":           o.config.OpenAITTSModel,
+		"voice":           chosenVoice(ctx, o.config.OpenAITTSVoice),
+		"input":           text,
+		"response_format": "pcm",
+		"speed":           speakingRate(ctx, o.config.TTSSpeakingRate),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ttsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ttsCtx, http.MethodPost, o.config.OpenAITTSURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+o.config.OpenAIAPIKey)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		log.Error("OpenAI TTS error after %v: %v", time.Since(startTime), err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("OpenAI TTS returned status %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("openai tts: unexpected status %d", resp.StatusCode)
+	}
+
+	pcm, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The API returns 24kHz little-endian 16-bit PCM; resample and re-encode for the call
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	audio := EncodeSamples(ResampleSamples(samples, openAIPCMRate, format.SampleRate), format)
+
+	log.Info("Successfully synthesized %d bytes of audio in %v", len(audio), time.Since(startTime))
+	return audio, nil
+}
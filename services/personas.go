@@ -0,0 +1,162 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Persona is a therapist callers can talk to. Its name selects the <name>.tmpl system
+// prompt template and the persona's generation params overrides.
+type Persona struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"` // Name the therapist goes by, in the prompt and the menu
+	// Voice is the provider's voice name, empty for the configured voice
+	Voice string `json:"voice,omitempty"`
+	// SpeakingRate scales the configured speaking rate, e.g. 0.9 for a slower pace; 0 keeps it
+	SpeakingRate float64 `json:"speakingRate,omitempty"`
+	// Traits describe the persona's manner to its prompt template, e.g. warm or direct
+	Traits []string `json:"traits,omitempty"`
+	// Greeting is spoken when the call connects, empty to wait for the caller
+	Greeting string `json:"greeting,omitempty"`
+	// Numbers are the dialed numbers answered by this persona, in E.164
+	Numbers []string `json:"numbers,omitempty"`
+}
+
+// label names the persona to callers
+func (p Persona) label() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.Name
+}
+
+// PersonaRegistry holds the personas callers can choose between. An empty or nil
+// registry leaves every call with the default persona.
+type PersonaRegistry struct {
+	personas []Persona
+	mu       sync.RWMutex
+}
+
+// LoadPersonaRegistry loads a JSON array of Persona from the file; an empty path gives a
+// nil registry, which leaves every call with the default persona
+func LoadPersonaRegistry(path string) (*PersonaRegistry, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPersonaRegistry(data, path)
+}
+
+// NewPersonaRegistry parses a JSON array of Persona read from source, e.g. the personas
+// section of the config file
+func NewPersonaRegistry(data []byte, source string) (*PersonaRegistry, error) {
+	log := logger.Component("Personas")
+	var personas []Persona
+	if err := json.Unmarshal(data, &personas); err != nil {
+		return nil, fmt.Errorf("parsing personas %s: %w", source, err)
+	}
+
+	names := make(map[string]bool, len(personas))
+	numbers := make(map[string]string)
+	for i, persona := range personas {
+		name := strings.ToLower(strings.TrimSpace(persona.Name))
+		if name == "" {
+			return nil, fmt.Errorf("persona %d in %s needs a name", i, source)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("persona %q is defined twice in %s", persona.Name, source)
+		}
+		names[name] = true
+		if persona.SpeakingRate < 0 {
+			return nil, fmt.Errorf("persona %q has a negative speaking rate", persona.Name)
+		}
+		for _, number := range persona.Numbers {
+			if other, ok := numbers[number]; ok {
+				return nil, fmt.Errorf("number %s is assigned to personas %q and %q", number, other, persona.Name)
+			}
+			numbers[number] = persona.Name
+		}
+		personas[i].Name = name
+	}
+
+	log.Info("Loaded %d personas from %s", len(personas), source)
+	return &PersonaRegistry{personas: personas}, nil
+}
+
+// All returns the personas in the order they were configured
+func (r *PersonaRegistry) All() []Persona {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.personas
+}
+
+// Replace swaps in the personas of another registry, e.g. one reloaded from the file;
+// calls keep the persona they already talk with
+func (r *PersonaRegistry) Replace(from *PersonaRegistry) {
+	personas := from.All()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.personas = personas
+}
+
+// Lookup finds a persona by name, ignoring case
+func (r *PersonaRegistry) Lookup(name string) (Persona, bool) {
+	for _, persona := range r.All() {
+		if strings.EqualFold(persona.Name, strings.TrimSpace(name)) {
+			return persona, true
+		}
+	}
+	return Persona{}, false
+}
+
+// ForNumber returns the persona answering a dialed number
+func (r *PersonaRegistry) ForNumber(number string) (Persona, bool) {
+	for _, persona := range r.All() {
+		for _, n := range persona.Numbers {
+			if n == number {
+				return persona, true
+			}
+		}
+	}
+	return Persona{}, false
+}
+
+// ForDigit returns the persona a keypress picks from the persona menu, 1 for the first
+func (r *PersonaRegistry) ForDigit(digits string) (Persona, bool) {
+	personas := r.All()
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 || n > len(personas) {
+		return Persona{}, false
+	}
+	return personas[n-1], true
+}
+
+// OffersMenu reports whether callers have more than one persona to choose between
+func (r *PersonaRegistry) OffersMenu() bool {
+	return len(r.All()) > 1
+}
+
+// PersonaMenuPrompt is read to callers to offer the personas, numbered from 1
+func PersonaMenuPrompt(personas []Persona) string {
+	choices := make([]string, len(personas))
+	for i, persona := range personas {
+		choices[i] = fmt.Sprintf("press %d to talk with %s", i+1, persona.label())
+	}
+	return "To choose who you would like to talk with, " + strings.Join(choices, ", ") +
+		". Or stay on the line to talk with " + personas[0].label() + "."
+}
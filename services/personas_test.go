@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePersonas(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "personas.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write personas: %v", err)
+	}
+	return path
+}
+
+func TestLoadPersonaRegistry(t *testing.T) {
+	registry, err := LoadPersonaRegistry(writePersonas(t, `[
+		{"name": "Maya", "displayName": "Maya", "voice": "en-US-Neural2-F", "greeting": "Hi, I'm Maya.", "numbers": ["+15550001"]},
+		{"name": "sam", "speakingRate": 0.9}
+	]`))
+	if err != nil {
+		t.Fatalf("Failed to load personas: %v", err)
+	}
+
+	if persona, ok := registry.Lookup("MAYA"); !ok || persona.Name != "maya" {
+		t.Errorf("Expected names to match regardless of case, got %+v", persona)
+	}
+	if persona, ok := registry.ForNumber("+15550001"); !ok || persona.Voice != "en-US-Neural2-F" {
+		t.Errorf("Expected the dialed number to select maya, got %+v", persona)
+	}
+	if persona, ok := registry.ForDigit("2"); !ok || persona.Name != "sam" {
+		t.Errorf("Expected 2 to pick sam, got %+v", persona)
+	}
+	if _, ok := registry.ForDigit("3"); ok {
+		t.Error("Expected a digit past the menu to pick nothing")
+	}
+	if !registry.OffersMenu() {
+		t.Error("Expected two personas to be offered in a menu")
+	}
+	prompt := PersonaMenuPrompt(registry.All())
+	if !strings.Contains(prompt, "press 2 to talk with sam") || !strings.HasSuffix(prompt, "talk with Maya.") {
+		t.Errorf("Unexpected menu prompt: %q", prompt)
+	}
+
+	for name, content := range map[string]string{
+		"missing name":     `[{"voice": "x"}]`,
+		"duplicate name":   `[{"name": "a"}, {"name": "A"}]`,
+		"shared number":    `[{"name": "a", "numbers": ["+1"]}, {"name": "b", "numbers": ["+1"]}]`,
+		"negative pace":    `[{"name": "a", "speakingRate": -1}]`,
+		"not a json array": `{"name": "a"}`,
+	} {
+		if _, err := LoadPersonaRegistry(writePersonas(t, content)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestNilPersonaRegistry(t *testing.T) {
+	registry, err := LoadPersonaRegistry("")
+	if err != nil || registry != nil {
+		t.Fatalf("Expected no registry without a file, got %v, %v", registry, err)
+	}
+	if _, ok := registry.ForNumber("+15550001"); ok || registry.OffersMenu() {
+		t.Error("Expected a nil registry to select no persona")
+	}
+}
+
+// personaRecorder is a ResponseGenerator that notes the persona each request was for
+type personaRecorder struct {
+	personas []string
+	names    []string
+}
+
+func (p *personaRecorder) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	p.personas = append(p.personas, PersonaFromContext(ctx))
+	p.names = append(p.names, personaName(ctx, "configured"))
+	return "Tell me more.", nil
+}
+
+func TestTurnEngineAnswersAsThePersona(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &personaRecorder{}
+	synthesizer := &voiceRecorder{}
+	engine := NewTurnEngine(channels, conversation, generator, synthesizer)
+
+	engine.ProcessTranscription(context.Background(), "hello")
+	channels.SetPersona(Persona{Name: "maya", DisplayName: "Maya", Voice: "en-US-Neural2-F", SpeakingRate: 0.9})
+	engine.ProcessTranscription(context.Background(), "hello again")
+
+	if generator.personas[0] != "" || generator.personas[1] != "maya" {
+		t.Errorf("Expected the persona only after it was set, got %q", generator.personas)
+	}
+	if generator.names[0] != "configured" || generator.names[1] != "Maya" {
+		t.Errorf("Expected the persona's display name in the prompt, got %q", generator.names)
+	}
+	if synthesizer.voices[1] != "en-US-Neural2-F" || channels.SpeakingRate() != 0.9 {
+		t.Errorf("Expected the persona's voice and pace, got %q at %.2fx", synthesizer.voices[1], channels.SpeakingRate())
+	}
+}
+
+func TestTurnEngineSpeaksThePersonaGreeting(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	channels.SetPersona(Persona{Name: "maya", Greeting: "Hi, I'm Maya. What's on your mind?"})
+	engine := NewTurnEngine(channels, conversation, &personaRecorder{}, &fakeSynthesizer{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		engine.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case text := <-channels.ResponseTextChan:
+		if text != "Hi, I'm Maya. What's on your mind?" {
+			t.Errorf("Expected the greeting first, got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Greeting was not spoken")
+	}
+	cancel()
+	<-done
+
+	if transcript := conversation.Transcript(); len(transcript) != 1 || transcript[0].Role != "therapist" {
+		t.Errorf("Expected the greeting in the conversation, got %+v", transcript)
+	}
+}
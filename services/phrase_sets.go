@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// DefaultPersona selects the phrase sets used for every call in a language
+const DefaultPersona = "default"
+
+// PhraseSet is domain vocabulary to bias speech recognition toward
+type PhraseSet struct {
+	// Boost is how strongly to favor the phrases; providers without boosts ignore it
+	Boost   float32  `json:"boost"`
+	Phrases []string `json:"phrases"`
+}
+
+// PhraseSetStore holds speech adaptation phrase sets per language and persona,
+// reloaded whenever the file changes so recognition can be tuned without a restart
+type PhraseSetStore struct {
+	path    string
+	sets    map[string]map[string]PhraseSet // language -> persona -> phrases
+	modTime time.Time
+	mu      sync.RWMutex
+	log     *logger.Logger
+}
+
+// LoadPhraseSetStore reads the phrase sets file, which maps language codes to personas
+// to phrase sets, e.g. {"en-US": {"default": {"boost": 10, "phrases": ["988"]}}}.
+// An empty path gives an empty store.
+func LoadPhraseSetStore(path string) (*PhraseSetStore, error) {
+	store := &PhraseSetStore{
+		path: path,
+		sets: make(map[string]map[string]PhraseSet),
+		log:  logger.Component("PhraseSets"),
+	}
+	if path == "" {
+		return store, nil
+	}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload reads the file and swaps in its phrase sets if it parses
+func (s *PhraseSetStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]map[string]PhraseSet
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	sets := make(map[string]map[string]PhraseSet, len(raw))
+	count := 0
+	for language, byPersona := range raw {
+		language = strings.ToLower(language)
+		sets[language] = make(map[string]PhraseSet, len(byPersona))
+		for persona, set := range byPersona {
+			sets[language][strings.ToLower(persona)] = set
+			count += len(set.Phrases)
+		}
+	}
+
+	s.mu.Lock()
+	s.sets = sets
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+
+	s.log.Info("Loaded %d phrases for %d languages from %s", count, len(sets), s.path)
+	return nil
+}
+
+// Reload reads the file again, keeping the previous phrase sets if it fails to parse
+func (s *PhraseSetStore) Reload() error {
+	if s == nil || s.path == "" {
+		return nil
+	}
+	return s.reload()
+}
+
+// Watch reloads the file whenever its modification time changes, until the context is done.
+// A file that fails to parse is logged and the previous phrase sets are kept.
+func (s *PhraseSetStore) Watch(ctx context.Context, interval time.Duration) {
+	if s == nil || s.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				s.log.Warn("Cannot stat phrase sets file %s: %v", s.path, err)
+				continue
+			}
+
+			s.mu.RLock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := s.reload(); err != nil {
+				s.log.Error("Keeping previous phrase sets, failed to reload %s: %v", s.path, err)
+			}
+		}
+	}
+}
+
+// Lookup returns the phrase sets for a call: the language's default set plus the persona's, if any
+func (s *PhraseSetStore) Lookup(language, persona string) []PhraseSet {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byPersona := s.sets[strings.ToLower(language)]
+	var sets []PhraseSet
+	if set, ok := byPersona[DefaultPersona]; ok && len(set.Phrases) > 0 {
+		sets = append(sets, set)
+	}
+	persona = strings.ToLower(persona)
+	if persona != "" && persona != DefaultPersona {
+		if set, ok := byPersona[persona]; ok && len(set.Phrases) > 0 {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+func writePhraseSets(t *testing.T, path, contents string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Set the modification time explicitly so reloads don't depend on filesystem timestamp resolution
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPhraseSetStoreLooksUpLanguageAndPersona(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phrases.json")
+	writePhraseSets(t, path, `{
+		"en-US": {
+			"default": {"boost": 10, "phrases": ["988", "crisis line"]},
+			"veterans": {"boost": 15, "phrases": ["VA", "PTSD"]}
+		}
+	}`, time.Now())
+
+	store, err := LoadPhraseSetStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load phrase sets: %v", err)
+	}
+
+	if sets := store.Lookup("en-us", DefaultPersona); len(sets) != 1 || sets[0].Boost != 10 {
+		t.Errorf("Expected the default set, got %+v", sets)
+	}
+	if sets := store.Lookup("en-US", "Veterans"); len(sets) != 2 || sets[1].Phrases[1] != "PTSD" {
+		t.Errorf("Expected the default and persona sets, got %+v", sets)
+	}
+	if sets := store.Lookup("es-ES", DefaultPersona); len(sets) != 0 {
+		t.Errorf("Expected no sets for an unconfigured language, got %+v", sets)
+	}
+
+	var empty *PhraseSetStore
+	if sets := empty.Lookup("en-US", DefaultPersona); sets != nil {
+		t.Errorf("Expected a nil store to have no sets, got %+v", sets)
+	}
+}
+
+func TestPhraseSetStoreReloadsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phrases.json")
+	start := time.Now().Add(-time.Hour)
+	writePhraseSets(t, path, `{"en-US": {"default": {"phrases": ["before"]}}}`, start)
+
+	store, err := LoadPhraseSetStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load phrase sets: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, 5*time.Millisecond)
+
+	waitForPhrase := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if sets := store.Lookup("en-US", DefaultPersona); len(sets) == 1 && sets[0].Phrases[0] == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for phrase %q, have %+v", want, store.Lookup("en-US", DefaultPersona))
+	}
+
+	writePhraseSets(t, path, `{"en-US": {"default": {"phrases": ["after"]}}}`, start.Add(time.Minute))
+	waitForPhrase("after")
+
+	// A broken edit keeps the last good phrase sets
+	writePhraseSets(t, path, `{"en-US": `, start.Add(2*time.Minute))
+	time.Sleep(30 * time.Millisecond)
+	waitForPhrase("after")
+}
+
+func TestRecognitionAdaptsToTheCallsLanguageAndPersona(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phrases.json")
+	writePhraseSets(t, path, `{
+		"en-US": {"default": {"phrases": ["988"]}, "veterans": {"phrases": ["VA"]}},
+		"es-US": {"default": {"phrases": ["línea de crisis"]}}
+	}`, time.Now())
+	store, err := LoadPhraseSetStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load phrase sets: %v", err)
+	}
+	stt := &SpeechToTextService{config: &config.Config{STTLanguageCode: "en-US"}, phraseSets: store}
+
+	phrases := func(ctx context.Context) []string {
+		var values []string
+		if adaptation := stt.recognitionConfig(ctx, nil).Adaptation; adaptation != nil {
+			for _, set := range adaptation.PhraseSets {
+				for _, phrase := range set.GetInlinePhraseSet().Phrases {
+					values = append(values, phrase.Value)
+				}
+			}
+		}
+		return values
+	}
+
+	if got := phrases(context.Background()); len(got) != 1 || got[0] != "988" {
+		t.Errorf("Expected the configured language's default set, got %v", got)
+	}
+	language, persona := "", "veterans"
+	ctx := WithRecognitionHints(context.Background(), func() (string, string) { return language, persona })
+	if got := phrases(ctx); len(got) != 2 || got[1] != "VA" {
+		t.Errorf("Expected the persona's set too, got %v", got)
+	}
+	// A language detected mid-call applies to the next stream
+	language = "es-US"
+	if got := phrases(ctx); len(got) != 1 || got[0] != "línea de crisis" {
+		t.Errorf("Expected the detected language's set, got %v", got)
+	}
+}
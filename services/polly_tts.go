@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// PollyTTSService implements SpeechSynthesizer over the Amazon Polly REST API
+type PollyTTSService struct {
+	config   *config.Config
+	client   *http.Client
+	creds    AWSCredentials
+	endpoint string
+	log      *logger.Logger
+}
+
+// NewPollyTTSService creates a new Amazon Polly text-to-speech service
+func NewPollyTTSService(cfg *config.Config) (*PollyTTSService, error) {
+	log := logger.Component("PollyTTS")
+	log.Info("Creating new Polly TTS service with voice %s (%s engine)", cfg.PollyVoice, cfg.PollyEngine)
+
+	if cfg.AWSAccessKeyID == "" || cfg.AWSSecretAccessKey == "" || cfg.AWSRegion == "" {
+		log.Error("AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY or AWS_REGION environment variable not set")
+		return nil, errors.New("AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION are required for the polly TTS provider")
+	}
+
+	return &PollyTTSService{
+		config: cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		creds: AWSCredentials{
+			AccessKeyID:     cfg.AWSAccessKeyID,
+			SecretAccessKey: cfg.AWSSecretAccessKey,
+			SessionToken:    cfg.AWSSessionToken,
+		},
+		endpoint: fmt.Sprintf("https://polly.%s.amazonaws.com/v1/speech", cfg.AWSRegion),
+		log:      log,
+	}, nil
+}
+
+// Close is a no-op; Polly requests are stateless
+func (p *PollyTTSService) Close() error {
+	p.log.Info("Closing Polly TTS service")
+	return nil
+}
+
+// SynthesizeSpeech converts text to audio in the given output format
+func (p *PollyTTSService) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	log := CallLogger(ctx, p.log)
+	startTime := time.Now()
+	format = format.Normalize()
+	log.Info("Synthesizing speech for text (%d chars): %q", len(text), logger.Transcript(text))
+
+	// Polly only produces PCM at 8 or 16kHz
+	switch format.SampleRate {
+	case 8000, 16000:
+	default:
+		return nil, fmt.Errorf("polly tts: unsupported sample rate %d", format.SampleRate)
+	}
+
+	request := map[string]string{
+		"Engine":       p.config.PollyEngine,
+		"OutputFormat": "pcm",
+		"SampleRate":   strconv.Itoa(format.SampleRate),
+		"Text":         text,
+		"TextType":     "text",
+		"VoiceId":      chosenVoice(ctx, p.config.PollyVoice),
+	}
+	if ssml, ok := p.ssml(ctx, text); ok {
+		request["Text"], request["TextType"] = ssml, "ssml"
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	ttsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ttsCtx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", "application/json")
+	signAWSRequest(req, body, p.creds, p.config.AWSRegion, "polly", time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		log.Error("Polly TTS error after %v: %v", time.Since(startTime), err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Polly TTS returned status %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("polly tts: unexpected status %d", resp.StatusCode)
+	}
+
+	audio, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Polly returns little-endian 16-bit PCM; re-encode for the call, e.g. to μ-law
+	samples := make([]int16, len(audio)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(audio[2*i:]))
+	}
+	audio = EncodeSamples(samples, format)
+
+	log.Info("Successfully synthesized %d bytes of audio in %v", len(audio), time.Since(startTime))
+	return audio, nil
+}
+
+// ssml returns the SSML for the text when SSML, or a rate or pitch Polly only takes in SSML,
+// is configured. Neural voices ignore pitch, so it's only set for the standard engine.
+func (p *PollyTTSService) ssml(ctx context.Context, text string) (string, bool) {
+	rate := speakingRate(ctx, p.config.TTSSpeakingRate)
+	pitch := p.config.TTSPitch
+	if p.config.PollyEngine != "standard" {
+		pitch = 0
+	}
+	if !p.config.TTSSSML && rate == 1 && pitch == 0 {
+		return "", false
+	}
+
+	var body string
+	if p.config.TTSSSML {
+		body = ssmlBody(text, ProsodyFromContext(ctx))
+	} else {
+		var escaped strings.Builder
+		xml.EscapeText(&escaped, []byte(text))
+		body = escaped.String()
+	}
+	if rate != 1 || pitch != 0 {
+		// Polly takes pitch as a percentage; a semitone is about 6%
+		body = fmt.Sprintf(`<prosody rate="%.0f%%" pitch="%+.0f%%">%s</prosody>`, rate*100, pitch*6, body)
+	}
+	return "<speak>" + body + "</speak>", true
+}
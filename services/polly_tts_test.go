@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+func TestPollyTTSConvertsPCMToMulaw(t *testing.T) {
+	var request map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("Expected a signed request, got %q", r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		pcm := make([]byte, 4)
+		for i, sample := range []int16{1000, -1000} {
+			binary.LittleEndian.PutUint16(pcm[2*i:], uint16(sample))
+		}
+		w.Write(pcm)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{AWSRegion: "us-east-1", AWSAccessKeyID: "AKID", AWSSecretAccessKey: "secret",
+		PollyVoice: "Joanna", PollyEngine: "neural"}
+	polly, err := NewPollyTTSService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	polly.endpoint = server.URL
+
+	audio, err := polly.SynthesizeSpeech(context.Background(), "Hello", DefaultAudioFormat())
+	if err != nil {
+		t.Fatalf("Failed to synthesize: %v", err)
+	}
+	if request["SampleRate"] != "8000" || request["OutputFormat"] != "pcm" || request["VoiceId"] != "Joanna" {
+		t.Errorf("Expected 8kHz PCM from Joanna, requested %v", request)
+	}
+	if len(audio) != 2 || audio[0] != MulawEncode(1000) || audio[1] != MulawEncode(-1000) {
+		t.Errorf("Expected the PCM re-encoded as μ-law, got %v", audio)
+	}
+}
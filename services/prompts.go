@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// defaultSystemPrompt is used when no template is found for the persona
+const defaultSystemPrompt = `You are a professional psychotherapist providing helpful, empathetic advice to someone who needs mental health support.
+Your responses should be supportive, non-judgmental, and focused on providing constructive guidance.
+Always maintain a calm, compassionate tone. Prioritize the person's well-being and safety.
+Never encourage harmful behaviors and suggest professional help when appropriate.
+Keep responses concise and conversational - suitable for speaking in a phone call.
+`
+
+// promptExtension is the file extension of system prompt templates
+const promptExtension = ".tmpl"
+
+// PromptData is what system prompt templates can refer to, e.g. {{.PersonaName}}
+type PromptData struct {
+	Persona     string    // Persona the prompt is for, the template's file name
+	PersonaName string    // Name the therapist goes by, may be empty
+	Traits      []string  // Persona's traits, e.g. warm or direct
+	CallSID     string    // Call the prompt is for
+	Language    string    // Caller's language code, e.g. en-US
+	Time        time.Time // When the prompt is rendered, e.g. {{.Time.Format "Monday"}}
+	TimeOfDay   string    // morning, afternoon, evening or night at Time
+	// Mood is the caller's latest detected emotion, e.g. anxiety, empty when none stood out
+	Mood string
+	// MoodScore is the sentiment of what the caller last said, from -1 to 1
+	MoodScore float64
+	Caller    PromptCaller
+}
+
+// PromptCaller is what is known about the caller when the call starts
+type PromptCaller struct {
+	PreviousCalls int       // Earlier calls from the same number
+	LastCall      time.Time // Start of the previous call, zero on a first call
+	ReferredBy    string    // Partner organization that referred the caller, if any
+	PastRisks     []string  // Risks flagged on earlier calls, e.g. self_harm
+	LastMood      *CallMood // How the caller sounded on their previous call, nil when unknown
+}
+
+// Returning reports whether the caller has called before
+func (c PromptCaller) Returning() bool {
+	return c.PreviousCalls > 0
+}
+
+// samplePromptData exercises every field when templates are checked as they load
+var samplePromptData = PromptData{
+	Persona:     DefaultPersona,
+	PersonaName: "Sam",
+	Traits:      []string{"warm"},
+	CallSID:     "CA00000000000000000000000000000000",
+	Language:    "en-US",
+	Time:        time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+	TimeOfDay:   "morning",
+	Mood:        EmotionAnxiety,
+	MoodScore:   -0.4,
+	Caller: PromptCaller{PreviousCalls: 1, LastCall: time.Date(2023, 12, 25, 20, 0, 0, 0, time.UTC), ReferredBy: "Sample Clinic", PastRisks: []string{RiskSelfHarm},
+		LastMood: &CallMood{StartedAt: time.Date(2023, 12, 25, 20, 0, 0, 0, time.UTC), Score: -0.4, Emotion: EmotionAnxiety}},
+}
+
+// TimeOfDay names the part of the day, e.g. evening at 19:00
+func TimeOfDay(t time.Time) string {
+	switch hour := t.Hour(); {
+	case hour >= 5 && hour < 12:
+		return "morning"
+	case hour >= 12 && hour < 17:
+		return "afternoon"
+	case hour >= 17 && hour < 22:
+		return "evening"
+	default:
+		return "night"
+	}
+}
+
+// promptDataContextKey is the context key type for the call's prompt data
+type promptDataContextKey struct{}
+
+// WithPromptData returns a context carrying what the call's system prompt is rendered
+// with; fields left empty are filled from the call's other values and the config
+func WithPromptData(ctx context.Context, data PromptData) context.Context {
+	return context.WithValue(ctx, promptDataContextKey{}, data)
+}
+
+// promptDataFromContext returns the data stored by WithPromptData, or empty data
+func promptDataFromContext(ctx context.Context) PromptData {
+	data, _ := ctx.Value(promptDataContextKey{}).(PromptData)
+	return data
+}
+
+// conversationTemplate lays out a single prompt of the system instructions, the
+// conversation history and the user message, which the LLM continues as the therapist
+var conversationTemplate = template.Must(template.New("conversation").Parse(
+	`{{.System}}{{range .History}}
+{{.}}{{end}}
+User: {{.UserMessage}}
+Therapist: `))
+
+// conversationPrompt is what conversationTemplate is rendered with
+type conversationPrompt struct {
+	System      string
+	History     []string
+	UserMessage string
+}
+
+// PromptStore holds the system prompt templates of each persona, loaded from
+// <dir>/<persona>.tmpl and reloaded whenever the directory changes so prompts can be
+// tuned without a restart
+type PromptStore struct {
+	dir       string
+	templates map[string]*template.Template // persona -> template
+	signature string                        // File names and modification times last loaded
+	mu        sync.RWMutex
+	log       *logger.Logger
+}
+
+// LoadPromptStore parses the templates in dir. An empty or missing dir gives an empty
+// store, which renders the built-in prompt.
+func LoadPromptStore(dir string) (*PromptStore, error) {
+	store := &PromptStore{
+		dir:       dir,
+		templates: make(map[string]*template.Template),
+		log:       logger.Component("Prompts"),
+	}
+	if dir == "" {
+		return store, nil
+	}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload parses every template in the directory and swaps them in if they all parse
+func (s *PromptStore) reload() error {
+	files, signature, err := s.scan()
+	if err != nil {
+		return err
+	}
+
+	templates := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		persona := strings.ToLower(strings.TrimSuffix(filepath.Base(file), promptExtension))
+		tmpl, err := template.New(persona).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return err
+		}
+		// Catch templates that parse but can't render, e.g. a misspelled field
+		if err := tmpl.Execute(io.Discard, samplePromptData); err != nil {
+			return fmt.Errorf("checking %s: %w", file, err)
+		}
+		templates[persona] = tmpl
+	}
+
+	s.mu.Lock()
+	s.templates = templates
+	s.signature = signature
+	s.mu.Unlock()
+
+	s.log.Info("Loaded %d system prompt templates from %s", len(templates), s.dir)
+	return nil
+}
+
+// scan lists the template files with a signature of their names and modification times
+// that changes whenever one is added, removed or edited
+func (s *PromptStore) scan() ([]string, string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+promptExtension))
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(files)
+
+	var signature strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, "", err
+		}
+		fmt.Fprintf(&signature, "%s@%d;", file, info.ModTime().UnixNano())
+	}
+	return files, signature.String(), nil
+}
+
+// Reload parses the templates again, keeping the previous ones if any fails to parse
+func (s *PromptStore) Reload() error {
+	if s == nil || s.dir == "" {
+		return nil
+	}
+	return s.reload()
+}
+
+// Watch reloads the templates whenever the directory's templates change, until the context
+// is done. Templates that fail to parse are logged and the previous ones are kept.
+func (s *PromptStore) Watch(ctx context.Context, interval time.Duration) {
+	if s == nil || s.dir == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, signature, err := s.scan()
+			if err != nil {
+				s.log.Warn("Cannot scan prompts directory %s: %v", s.dir, err)
+				continue
+			}
+
+			s.mu.RLock()
+			changed := signature != s.signature
+			s.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := s.reload(); err != nil {
+				s.log.Error("Keeping previous system prompts, failed to reload %s: %v", s.dir, err)
+			}
+		}
+	}
+}
+
+// Render returns the system prompt for the persona, falling back to the default persona's
+// template and then to the built-in prompt when there is none or it fails to render
+func (s *PromptStore) Render(data PromptData) string {
+	if s == nil {
+		return defaultSystemPrompt
+	}
+	if data.Persona == "" {
+		data.Persona = DefaultPersona
+	}
+	if data.Time.IsZero() {
+		data.Time = time.Now()
+	}
+	if data.TimeOfDay == "" {
+		data.TimeOfDay = TimeOfDay(data.Time)
+	}
+
+	s.mu.RLock()
+	tmpl, ok := s.templates[strings.ToLower(data.Persona)]
+	if !ok {
+		tmpl, ok = s.templates[DefaultPersona]
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return defaultSystemPrompt
+	}
+
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, data); err != nil {
+		s.log.Error("Failed to render system prompt for persona %s, using the built-in one: %v", data.Persona, err)
+		return defaultSystemPrompt
+	}
+	return strings.TrimRight(prompt.String(), "\n") + "\n"
+}
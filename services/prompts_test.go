@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// writePromptTemplate writes <dir>/<persona>.tmpl with the given modification time
+func writePromptTemplate(t *testing.T, dir, persona, contents string, modTime time.Time) {
+	t.Helper()
+	writePhraseSets(t, filepath.Join(dir, persona+".tmpl"), contents, modTime)
+}
+
+func TestPromptStoreRendersPersonaTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writePromptTemplate(t, dir, "default", `You are {{.PersonaName}}, speaking {{.Language}} on call {{.CallSID}}.`, time.Now())
+	writePromptTemplate(t, dir, "veterans", `You support veterans.`, time.Now())
+
+	store, err := LoadPromptStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to load prompts: %v", err)
+	}
+
+	got := store.Render(PromptData{PersonaName: "Sam", CallSID: "CA123", Language: "en-US"})
+	if got != "You are Sam, speaking en-US on call CA123.\n" {
+		t.Errorf("Expected the default template rendered, got %q", got)
+	}
+	if got := store.Render(PromptData{Persona: "Veterans"}); got != "You support veterans.\n" {
+		t.Errorf("Expected the persona's template, got %q", got)
+	}
+	if got := store.Render(PromptData{Persona: "students", PersonaName: "Sam"}); !strings.HasPrefix(got, "You are Sam") {
+		t.Errorf("Expected an unknown persona to use the default template, got %q", got)
+	}
+
+	var empty *PromptStore
+	if got := empty.Render(PromptData{}); got != defaultSystemPrompt {
+		t.Errorf("Expected a nil store to use the built-in prompt, got %q", got)
+	}
+	missing, err := LoadPromptStore(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("Expected a missing directory to load empty, got %v", err)
+	}
+	if got := missing.Render(PromptData{}); got != defaultSystemPrompt {
+		t.Errorf("Expected an empty store to use the built-in prompt, got %q", got)
+	}
+}
+
+func TestPromptStoreRejectsBrokenTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writePromptTemplate(t, dir, "default", `You are {{.PersonaName`, time.Now())
+
+	if _, err := LoadPromptStore(dir); err == nil {
+		t.Error("Expected an error for a template that doesn't parse")
+	}
+
+	writePromptTemplate(t, dir, "default", `You are {{.PersonaNmae}}.`, time.Now())
+	if _, err := LoadPromptStore(dir); err == nil {
+		t.Error("Expected an error for a template referring to an unknown field")
+	}
+}
+
+func TestPromptStoreRendersRuntimeValues(t *testing.T) {
+	dir := t.TempDir()
+	writePromptTemplate(t, dir, "default", `Good {{.TimeOfDay}}. You are {{range .Traits}}{{.}} {{end}}
+{{- if .Caller.Returning}}The caller has called {{.Caller.PreviousCalls}} times before.{{end}}
+{{- with .Caller.ReferredBy}} Referred by {{.}}.{{end}}
+{{- if .Mood}} They sound {{.Mood}} ({{printf "%.1f" .MoodScore}}).{{end}}`, time.Now())
+
+	store, err := LoadPromptStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to load prompts: %v", err)
+	}
+
+	got := store.Render(PromptData{
+		Time:      time.Date(2024, 3, 1, 19, 30, 0, 0, time.UTC),
+		Traits:    []string{"warm", "direct"},
+		Mood:      EmotionSadness,
+		MoodScore: -0.6,
+		Caller:    PromptCaller{PreviousCalls: 2, ReferredBy: "Hope Clinic"},
+	})
+	want := "Good evening. You are warm direct The caller has called 2 times before. Referred by Hope Clinic. They sound sadness (-0.6).\n"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestBuildGeminiPrompt(t *testing.T) {
+	got := buildGeminiPrompt("Be kind.\n", "I can't sleep", []string{"User: hi", "Therapist: Hello."}, logger.Component("Test"))
+	want := "Be kind.\n\nUser: hi\nTherapist: Hello.\nUser: I can't sleep\nTherapist: "
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestTurnEngineFillsPromptData(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	channels.SetPersona(Persona{Name: "maya", Traits: []string{"gentle"}})
+	generator := &promptDataRecorder{}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+	engine.Caller = PromptCaller{PreviousCalls: 1}
+
+	engine.ProcessTranscription(context.Background(), "I feel so anxious and worried")
+
+	data := generator.data
+	if data.Mood != EmotionAnxiety || data.MoodScore >= 0 {
+		t.Errorf("Expected the caller's anxiety in the prompt data, got %q at %.2f", data.Mood, data.MoodScore)
+	}
+	if len(data.Traits) != 1 || data.Traits[0] != "gentle" || !data.Caller.Returning() {
+		t.Errorf("Expected the persona's traits and the caller's history, got %+v", data)
+	}
+}
+
+// promptDataRecorder is a ResponseGenerator that keeps the prompt data of the last request
+type promptDataRecorder struct {
+	data PromptData
+}
+
+func (p *promptDataRecorder) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	p.data = promptDataFromContext(ctx)
+	return "Tell me more.", nil
+}
+
+func TestConversationServicePromptCaller(t *testing.T) {
+	service := NewConversationService()
+	first := service.GetOrCreateConversation("first")
+	first.CreatedAt = time.Now().Add(-time.Hour)
+	service.AttachCaller(first, "hash")
+	current := service.GetOrCreateConversation("current")
+	service.AttachCaller(current, "hash")
+
+	caller := service.PromptCaller(current)
+	if caller.PreviousCalls != 1 || !caller.LastCall.Equal(first.CreatedAt) {
+		t.Errorf("Expected one earlier call, got %+v", caller)
+	}
+	if service.PromptCaller(first).Returning() {
+		t.Error("Expected the first call to have no earlier calls")
+	}
+}
+
+func TestPromptStoreReloadsChanges(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writePromptTemplate(t, dir, "default", "Before.", start)
+
+	store, err := LoadPromptStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to load prompts: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, 5*time.Millisecond)
+
+	waitForPrompt := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if store.Render(PromptData{}) == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for prompt %q, have %q", want, store.Render(PromptData{}))
+	}
+
+	writePromptTemplate(t, dir, "default", "After.", start.Add(time.Minute))
+	waitForPrompt("After.\n")
+
+	// A new persona is picked up too
+	writePromptTemplate(t, dir, "veterans", "Veterans.", start)
+	deadline := time.Now().Add(time.Second)
+	for store.Render(PromptData{Persona: "veterans"}) != "Veterans.\n" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := store.Render(PromptData{Persona: "veterans"}); got != "Veterans.\n" {
+		t.Errorf("Expected the added persona's template, got %q", got)
+	}
+
+	// A broken edit keeps the last good templates
+	writePromptTemplate(t, dir, "default", "{{.Broken", start.Add(2*time.Minute))
+	time.Sleep(30 * time.Millisecond)
+	waitForPrompt("After.\n")
+}
+
+func TestShippedDefaultPromptMatchesBuiltIn(t *testing.T) {
+	store, err := LoadPromptStore("../prompts")
+	if err != nil {
+		t.Fatalf("Failed to load the shipped prompts: %v", err)
+	}
+
+	if got := store.Render(PromptData{}); got != defaultSystemPrompt {
+		t.Errorf("Expected the shipped default prompt to match the built-in one, got %q", got)
+	}
+	if got := store.Render(PromptData{PersonaName: "Sam"}); !strings.Contains(got, "Your name is Sam") {
+		t.Errorf("Expected the persona name in the prompt, got %q", got)
+	}
+}
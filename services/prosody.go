@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// SpeechIntent is what a spoken sentence is doing for the caller
+type SpeechIntent string
+
+const (
+	// SpeechNeutral is conversation that is neither comforting nor informing
+	SpeechNeutral SpeechIntent = "neutral"
+	// SpeechComforting acknowledges and soothes the caller's feelings
+	SpeechComforting SpeechIntent = "comforting"
+	// SpeechInformational gives resources, numbers or steps the caller should catch
+	SpeechInformational SpeechIntent = "informational"
+)
+
+var (
+	// comfortingSpeech matches empathic, validating phrasing
+	comfortingSpeech = regexp.MustCompile(`(?i)\b(i'?m (so |really |very )?sorry|that sounds|it sounds like|i hear you|i understand|you'?re not alone|it'?s (okay|ok|understandable|normal)|that must|take your time|i'?m here|you matter|be gentle|it makes sense)\b`)
+	// informationalSpeech matches resources and instructions, besides the phone numbers ssmlNumber finds
+	informationalSpeech = regexp.MustCompile(`(?i)\b(?:(?:call|text|dial|visit|website|hotline|helpline|lifeline|press|steps?|available 24)\b|(?:first|second|next),)`)
+)
+
+// ClassifySpeechIntent tells apart sentences that inform, which must be clear, from those
+// that comfort, which should be soft
+func ClassifySpeechIntent(sentence string) SpeechIntent {
+	switch {
+	case ssmlNumber.MatchString(sentence) || informationalSpeech.MatchString(sentence):
+		return SpeechInformational
+	case comfortingSpeech.MatchString(sentence):
+		return SpeechComforting
+	default:
+		return SpeechNeutral
+	}
+}
+
+// Prosody is how a sentence is delivered in SSML
+type Prosody struct {
+	Rate   float64       // Fraction of the voice's default rate, e.g. 0.95
+	Pitch  float64       // Semitones from the voice's default pitch
+	Volume string        // SSML volume, e.g. soft, empty for the default
+	Pause  time.Duration // Break between sentences
+	// lead adds the pause before the text, for a sentence synthesized on its own after
+	// another one
+	lead bool
+}
+
+// DefaultProsody is a little slower and lower than the default voice so the therapist
+// sounds calm, with a pause between sentences
+var DefaultProsody = Prosody{Rate: 0.95, Pitch: -1, Pause: 350 * time.Millisecond}
+
+// intentProsody is the delivery of each speech intent: comfort is softer and slower,
+// information clear and steady
+var intentProsody = map[SpeechIntent]Prosody{
+	SpeechNeutral:       DefaultProsody,
+	SpeechComforting:    {Rate: 0.88, Pitch: -2, Volume: "soft", Pause: 500 * time.Millisecond},
+	SpeechInformational: {Rate: 0.93, Pitch: 0, Volume: "medium", Pause: 400 * time.Millisecond},
+}
+
+// emotionProsody adjusts the delivery to how the caller sounds: slower with longer pauses
+// for distress, lower for anger and a little brighter for joy
+var emotionProsody = map[string]struct {
+	rate, pitch float64
+	pause       time.Duration
+}{
+	EmotionHopelessness: {-0.05, -0.5, 150 * time.Millisecond},
+	EmotionSadness:      {-0.04, -0.5, 100 * time.Millisecond},
+	EmotionAnxiety:      {-0.06, 0, 150 * time.Millisecond},
+	EmotionAnger:        {-0.03, -1, 50 * time.Millisecond},
+	EmotionJoy:          {0.03, 1, 0},
+}
+
+// ProsodyFor returns the delivery of a sentence with the intent, for a caller who last
+// sounded the emotion, which may be empty
+func ProsodyFor(emotion string, intent SpeechIntent) Prosody {
+	prosody, ok := intentProsody[intent]
+	if !ok {
+		prosody = DefaultProsody
+	}
+	if adjust, ok := emotionProsody[emotion]; ok {
+		prosody.Rate += adjust.rate
+		prosody.Pitch += adjust.pitch
+		prosody.Pause += adjust.pause
+	}
+	return prosody
+}
+
+// attributes returns the SSML <prosody> attributes
+func (p Prosody) attributes() string {
+	attrs := fmt.Sprintf(`rate="%.0f%%" pitch="%+gst"`, p.Rate*100, p.Pitch)
+	if p.Volume != "" {
+		attrs += ` volume="` + p.Volume + `"`
+	}
+	return attrs
+}
+
+// pauseBreak returns the SSML <break> of the pause
+func (p Prosody) pauseBreak() string {
+	return fmt.Sprintf(`<break time="%dms"/>`, p.Pause.Milliseconds())
+}
+
+// prosodyContextKey is the context key type for a sentence's prosody
+type prosodyContextKey struct{}
+
+// WithProsody returns a context asking speech synthesis to deliver the text with the prosody
+func WithProsody(ctx context.Context, prosody Prosody) context.Context {
+	return context.WithValue(ctx, prosodyContextKey{}, prosody)
+}
+
+// ProsodyFromContext returns the prosody stored by WithProsody, or DefaultProsody
+func ProsodyFromContext(ctx context.Context) Prosody {
+	if prosody, ok := ctx.Value(prosodyContextKey{}).(Prosody); ok {
+		return prosody
+	}
+	return DefaultProsody
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestClassifySpeechIntent(t *testing.T) {
+	for sentence, want := range map[string]SpeechIntent{
+		"That sounds really painful.":                  SpeechComforting,
+		"You're not alone in this.":                    SpeechComforting,
+		"You can call 988 any time, day or night.":     SpeechInformational,
+		"The number is 1-800-662-4357.":                SpeechInformational,
+		"First, try to find a quiet place to sit.":     SpeechInformational,
+		"What would you like to talk about?":           SpeechNeutral,
+		"I'm sorry, you can text HOME to the hotline.": SpeechInformational,
+	} {
+		if got := ClassifySpeechIntent(sentence); got != want {
+			t.Errorf("%q: expected %s, got %s", sentence, want, got)
+		}
+	}
+}
+
+func TestProsodyFor(t *testing.T) {
+	comforting := ProsodyFor("", SpeechComforting)
+	informational := ProsodyFor("", SpeechInformational)
+	if comforting.Rate >= informational.Rate || comforting.Volume != "soft" || comforting.Pause <= informational.Pause {
+		t.Errorf("Expected comfort softer and slower than information, got %+v and %+v", comforting, informational)
+	}
+
+	sad := ProsodyFor(EmotionSadness, SpeechComforting)
+	if sad.Rate >= comforting.Rate || sad.Pause <= comforting.Pause {
+		t.Errorf("Expected a sad caller to be spoken to more slowly, got %+v", sad)
+	}
+	if got := ProsodyFor("", SpeechNeutral); got != DefaultProsody {
+		t.Errorf("Expected neutral speech to a calm caller to use the default, got %+v", got)
+	}
+}
+
+func TestBuildSSMLWithProsody(t *testing.T) {
+	prosody := ProsodyFor(EmotionAnxiety, SpeechComforting)
+	prosody.lead = true
+	got := BuildSSMLWithProsody("It's okay. Take your time.", prosody)
+	want := `<speak><break time="650ms"/><prosody rate="82%" pitch="-2st" volume="soft">It&#39;s okay.` +
+		`<break time="650ms"/>Take your time.</prosody></speak>`
+	if got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+// prosodyRecorder is a SpeechSynthesizer that notes the prosody each sentence asked for
+type prosodyRecorder struct {
+	mu        sync.Mutex
+	prosodies map[string]Prosody
+}
+
+func (p *prosodyRecorder) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prosodies[text] = ProsodyFromContext(ctx)
+	return []byte(text), nil
+}
+
+func TestTurnEngineDeliversSentencesByIntentAndEmotion(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &fakeGenerator{replies: map[string]string{
+		"I feel so hopeless": "That sounds so heavy. You can call 988 any time.",
+	}}
+	synthesizer := &prosodyRecorder{prosodies: map[string]Prosody{}}
+	engine := NewTurnEngine(channels, conversation, generator, synthesizer)
+
+	engine.ProcessTranscription(context.Background(), "I feel so hopeless")
+
+	comfort := synthesizer.prosodies["That sounds so heavy."]
+	info := synthesizer.prosodies["You can call 988 any time."]
+	if comfort.Volume != "soft" || comfort.Rate >= ProsodyFor("", SpeechComforting).Rate || comfort.lead {
+		t.Errorf("Expected the first sentence comforting, slowed for hopelessness and without a pause, got %+v", comfort)
+	}
+	if info.Volume != "medium" || !info.lead {
+		t.Errorf("Expected the second sentence informational after a pause, got %+v", info)
+	}
+}
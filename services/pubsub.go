@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	"golang.org/x/oauth2/google"
+)
+
+// pubSubScope is the OAuth scope of the Pub/Sub API
+const pubSubScope = "https://www.googleapis.com/auth/pubsub"
+
+// Pub/Sub publishing settings
+const (
+	pubSubQueueSize = 1000
+	pubSubMaxBatch  = 100                   // Messages per publish request
+	pubSubBatchWait = 50 * time.Millisecond // How long a batch waits to fill up
+)
+
+// pubSubMessage is a message as sent to the publish method
+type pubSubMessage struct {
+	Data       string            `json:"data"` // Base64
+	Attributes map[string]string `json:"attributes"`
+}
+
+// PubSubPublisher publishes call events to a Pub/Sub topic through its REST API in the
+// background, batched and retried with backoff, so the call path never waits on it.
+// Messages carry the event as JSON, as webhooks receive it, with its type, call SID and
+// ID as attributes for subscription filters.
+type PubSubPublisher struct {
+	maxAttempts int
+	// Backoff is the wait before the first retry, doubled for each further one
+	Backoff time.Duration
+
+	queue    chan pubSubMessage
+	client   *http.Client
+	endpoint string // The topic's publish method
+	log      *logger.Logger
+}
+
+// NewPubSubPublisher creates a publisher to the topic, given by name in the project or
+// as projects/<project>/topics/<name>; it returns nil, which publishes nothing, when no
+// topic is set
+func NewPubSubPublisher(ctx context.Context, projectID, topic string, maxAttempts int) (*PubSubPublisher, error) {
+	if topic == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(topic, "projects/") {
+		if projectID == "" {
+			return nil, errors.New("GOOGLE_PROJECT_ID is required to publish to a Pub/Sub topic given by name")
+		}
+		topic = fmt.Sprintf("projects/%s/topics/%s", projectID, topic)
+	}
+
+	client, err := google.DefaultClient(ctx, pubSubScope)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 10 * time.Second
+
+	return newPubSubPublisher(client, "https://pubsub.googleapis.com/v1/"+topic+":publish", maxAttempts), nil
+}
+
+// newPubSubPublisher creates a publisher publishing at the endpoint
+func newPubSubPublisher(client *http.Client, endpoint string, maxAttempts int) *PubSubPublisher {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &PubSubPublisher{
+		maxAttempts: maxAttempts,
+		Backoff:     time.Second,
+		queue:       make(chan pubSubMessage, pubSubQueueSize),
+		client:      client,
+		endpoint:    endpoint,
+		log:         logger.Component("PubSub"),
+	}
+}
+
+// Publish queues the event; events are dropped when the queue is full
+func (p *PubSubPublisher) Publish(eventType, callSID string, data any) {
+	if p == nil {
+		return
+	}
+	event := newEvent(eventType, callSID, data)
+	body, err := json.Marshal(event)
+	if err != nil {
+		p.log.Error("Failed to encode %s event for call %s: %v", eventType, callSID, err)
+		return
+	}
+	message := pubSubMessage{
+		Data:       base64.StdEncoding.EncodeToString(body),
+		Attributes: map[string]string{"type": eventType, "callSid": callSID, "eventId": event.ID},
+	}
+	select {
+	case p.queue <- message:
+	default:
+		p.log.Warn("Pub/Sub queue full, dropping %s event for call %s", eventType, callSID)
+	}
+}
+
+// Run publishes queued events until the context is cancelled. Events queued together
+// are published in one request, in the order they were queued.
+func (p *PubSubPublisher) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	p.log.Info("Publishing call events to Pub/Sub")
+	for {
+		var batch []pubSubMessage
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-p.queue:
+			batch = append(batch, message)
+		}
+		timeout := time.After(pubSubBatchWait)
+	fill:
+		for len(batch) < pubSubMaxBatch {
+			select {
+			case message := <-p.queue:
+				batch = append(batch, message)
+			case <-timeout:
+				break fill
+			case <-ctx.Done():
+				break fill
+			}
+		}
+		p.deliver(ctx, batch)
+	}
+}
+
+// deliver publishes a batch until it is accepted, it is rejected outright or the attempts
+// run out. Network errors, 429s and 5xx responses are retried.
+func (p *PubSubPublisher) deliver(ctx context.Context, batch []pubSubMessage) {
+	wait := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := p.publish(ctx, batch)
+		if err == nil {
+			p.log.Debug("Published %d event(s)", len(batch))
+			return
+		}
+		var status statusError
+		if errors.As(err, &status) && !status.retryable() {
+			p.log.Error("Pub/Sub rejected %d event(s): %v", len(batch), err)
+			return
+		}
+		if attempt >= p.maxAttempts {
+			p.log.Error("Giving up publishing %d event(s) after %d attempt(s): %v", len(batch), attempt, err)
+			return
+		}
+		p.log.Warn("Publishing %d event(s) failed, retrying in %v: %v", len(batch), wait, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// publish makes one publish request
+func (p *PubSubPublisher) publish(ctx context.Context, batch []pubSubMessage) error {
+	body, err := json.Marshal(map[string]any{"messages": batch})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		p.log.Error("Pub/Sub API returned status %d: %s", resp.StatusCode, msg)
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
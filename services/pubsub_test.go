@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPubSubPublisherBatchesEvents(t *testing.T) {
+	received := make(chan []pubSubMessage, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []pubSubMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		received <- request.Messages
+		w.Write([]byte(`{"messageIds": ["1", "2"]}`))
+	}))
+	defer server.Close()
+
+	publisher := newPubSubPublisher(server.Client(), server.URL, 3)
+	publisher.Publish(EventTranscriptionFinal, "CA123", TranscriptionEvent{Text: "I can't sleep"})
+	publisher.Publish(EventResponseGenerated, "CA123", ResponseEvent{Text: "That sounds hard."})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.Run(ctx)
+
+	select {
+	case messages := <-received:
+		if len(messages) != 2 {
+			t.Fatalf("Expected both events in one request, got %d", len(messages))
+		}
+		if attrs := messages[0].Attributes; attrs["type"] != EventTranscriptionFinal || attrs["callSid"] != "CA123" || attrs["eventId"] == "" {
+			t.Errorf("Expected the event's attributes, got %v", attrs)
+		}
+		data, _ := base64.StdEncoding.DecodeString(messages[1].Data)
+		var event WebhookEvent
+		if err := json.Unmarshal(data, &event); err != nil || event.Type != EventResponseGenerated || event.ID != messages[1].Attributes["eventId"] {
+			t.Errorf("Expected the event as data, got %s, %v", data, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the events published")
+	}
+}
+
+func TestPubSubPublisherRetries(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	publisher := newPubSubPublisher(server.Client(), server.URL, 5)
+	publisher.Backoff = time.Millisecond
+	publisher.deliver(context.Background(), []pubSubMessage{{Data: "e30="}})
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("Expected the publish retried until accepted, got %d attempt(s)", got)
+	}
+}
+
+func TestEventPublishersFanOut(t *testing.T) {
+	first, second := newPubSubPublisher(http.DefaultClient, "", 1), newPubSubPublisher(http.DefaultClient, "", 1)
+	EventPublishers{first, second}.Publish(EventCallEnded, "CA123", CallEndedEvent{})
+	if len(first.queue) != 1 || len(second.queue) != 1 {
+		t.Errorf("Expected the event queued on every publisher, got %d and %d", len(first.queue), len(second.queue))
+	}
+	EventPublishers(nil).Publish(EventCallEnded, "CA123", CallEndedEvent{})
+}
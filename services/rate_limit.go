@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// rateBucket is a key's tokens as of last
+type rateBucket struct {
+	tokens  float64
+	last    time.Time
+	limited bool // Whether the last request was refused, so refusals are logged once per run
+}
+
+// RateLimiter is a token bucket per key, e.g. per client IP or caller number. Keys start
+// with a full bucket of burst tokens, refilled at the rate.
+type RateLimiter struct {
+	name  string
+	rate  float64 // Tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+	refused atomic.Int64
+	now     func() time.Time
+	log     *logger.Logger
+}
+
+// RateLimits are the rate limits of the API, the Twilio webhooks and calls per caller
+// number; nil limiters limit nothing
+type RateLimits struct {
+	API      *RateLimiter // Per client IP
+	Webhooks *RateLimiter // Per Twilio account, or per client IP without signature checks
+	Callers  *RateLimiter // Per caller number, of incoming calls
+}
+
+// RateLimitStats are how many requests each limiter refused
+type RateLimitStats struct {
+	API      int64 `json:"api"`
+	Webhooks int64 `json:"webhooks"`
+	Callers  int64 `json:"callers"`
+}
+
+// Stats returns how many requests were refused so far
+func (l RateLimits) Stats() RateLimitStats {
+	return RateLimitStats{API: l.API.Refused(), Webhooks: l.Webhooks.Refused(), Callers: l.Callers.Refused()}
+}
+
+// NewRateLimiter creates a limiter allowing each key count requests per period, in bursts
+// of up to burst; it returns nil, which allows everything, when count is 0
+func NewRateLimiter(name string, count int, period time.Duration, burst int) *RateLimiter {
+	if count <= 0 || period <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	log := logger.Component("RateLimiter")
+	log.Info("Limiting %s to %d per %v, bursts of %d", name, count, period, burst)
+	return &RateLimiter{
+		name:    name,
+		rate:    float64(count) / period.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*rateBucket),
+		now:     time.Now,
+		log:     log,
+	}
+}
+
+// Allow takes a token of the key, reporting whether there was one and, when there wasn't,
+// how long until there is
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0
+	}
+
+	l.refused.Add(1)
+	if !b.limited {
+		b.limited = true
+		l.log.Warn("Rate limiting %s of %s", l.name, key)
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Refused returns how many requests were refused so far
+func (l *RateLimiter) Refused() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.refused.Load()
+}
+
+// prune forgets keys whose bucket has refilled, which a new bucket would start as anyway
+func (l *RateLimiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Run forgets idle keys every interval until the context is cancelled, so the buckets
+// don't grow with every address ever seen
+func (l *RateLimiter) Run(ctx context.Context, interval time.Duration) {
+	if l == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.prune()
+		}
+	}
+}
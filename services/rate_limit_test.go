@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsEachKeysBucket(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter("calls", 6, time.Minute, 2)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("Expected a burst of 2 allowed, refused request %d", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("a")
+	if ok || wait != 10*time.Second {
+		t.Errorf("Expected the third request refused for 10s, got %t, %v", ok, wait)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("Expected another key to have its own bucket")
+	}
+
+	now = now.Add(10 * time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("Expected a token refilled after 10s")
+	}
+	if limiter.Refused() != 1 {
+		t.Errorf("Expected one refusal counted, got %d", limiter.Refused())
+	}
+
+	now = now.Add(time.Minute)
+	limiter.prune()
+	if len(limiter.buckets) != 0 {
+		t.Errorf("Expected refilled buckets forgotten, got %d", len(limiter.buckets))
+	}
+}
+
+func TestNoRateLimiterAllowsEverything(t *testing.T) {
+	limiter := NewRateLimiter("API requests", 0, time.Minute, 10)
+	if ok, _ := limiter.Allow("a"); !ok || limiter != nil {
+		t.Error("Expected no limit without a rate")
+	}
+	if stats := (RateLimits{}).Stats(); stats != (RateLimitStats{}) {
+		t.Errorf("Expected nothing refused, got %+v", stats)
+	}
+}
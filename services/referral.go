@@ -0,0 +1,305 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Referral is a caller pre-registered by a partner organization
+type Referral struct {
+	ID                string     `json:"id"`
+	Organization      string     `json:"organization"`
+	PhoneNumber       string     `json:"phoneNumber"`
+	Reason            string     `json:"reason"`
+	PreferredLanguage string     `json:"preferredLanguage,omitempty"`
+	CallbackURL       string     `json:"callbackUrl,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	CallSID           string     `json:"callSid,omitempty"`
+	CompletedAt       *time.Time `json:"completedAt,omitempty"`
+}
+
+// Longest organization name and reason a referral takes, in characters
+const (
+	maxReferralOrganization = 100
+	maxReferralReason       = 500
+)
+
+// languagePattern matches a BCP-47 language code, e.g. es-US, or nothing
+var languagePattern = regexp.MustCompile(`^([A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*)?$`)
+
+// PromptContext renders the referral as context for the therapist prompt
+func (r *Referral) PromptContext() string {
+	context := fmt.Sprintf("The caller was referred by %s. Referral reason: %s.", r.Organization, r.Reason)
+	if r.PreferredLanguage != "" {
+		context += fmt.Sprintf(" The caller's preferred language is %s.", r.PreferredLanguage)
+	}
+	return context
+}
+
+// ReferralService keeps referrals keyed by phone number until the referred caller calls
+type ReferralService struct {
+	referrals map[string]*Referral // pending referrals by phone number
+	active    map[string]*Referral // claimed referrals by call SID
+	completed []*Referral
+	ttl       time.Duration // How long a referral waits for the caller, 0 forever
+	mu        sync.Mutex
+	client    *http.Client
+	log       *logger.Logger
+}
+
+// NewReferralService creates a referral service whose referrals expire if the caller
+// hasn't called within ttl; 0 keeps them until they do
+func NewReferralService(ttl time.Duration) *ReferralService {
+	log := logger.Component("Referral")
+	log.Info("Creating new Referral service")
+
+	return &ReferralService{
+		referrals: make(map[string]*Referral),
+		active:    make(map[string]*Referral),
+		ttl:       ttl,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		log:       log,
+	}
+}
+
+// expired reports whether a pending referral has waited longer than the TTL
+func (s *ReferralService) expired(ref *Referral, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(ref.CreatedAt) > s.ttl
+}
+
+// Register validates and stores a referral, replacing any pending one for the same number.
+// The organization and reason go into the therapist prompt, so they are sanitized like
+// what callers say and refused when they read as instructions to the LLM.
+func (s *ReferralService) Register(ref Referral) (*Referral, error) {
+	ref.PhoneNumber = normalizePhoneNumber(ref.PhoneNumber)
+	if ref.PhoneNumber == "" {
+		return nil, errors.New("phoneNumber is required")
+	}
+	ref.Organization = SanitizeCallerInput(ref.Organization)
+	ref.Reason = SanitizeCallerInput(ref.Reason)
+	ref.PreferredLanguage = SanitizeCallerInput(ref.PreferredLanguage)
+	switch {
+	case ref.Organization == "":
+		return nil, errors.New("organization is required")
+	case utf8.RuneCountInString(ref.Organization) > maxReferralOrganization:
+		return nil, fmt.Errorf("organization is longer than %d characters", maxReferralOrganization)
+	case ref.Reason == "":
+		return nil, errors.New("reason is required")
+	case utf8.RuneCountInString(ref.Reason) > maxReferralReason:
+		return nil, fmt.Errorf("reason is longer than %d characters", maxReferralReason)
+	case !languagePattern.MatchString(ref.PreferredLanguage):
+		return nil, errors.New("preferredLanguage must be a language code, e.g. es-US")
+	}
+	for _, field := range []string{ref.Organization, ref.Reason} {
+		if kinds := DetectInjection(field); len(kinds) > 0 {
+			s.log.Warn("Refused referral from %s reading as %s", maskPhoneNumber(ref.PhoneNumber), strings.Join(kinds, ", "))
+			return nil, errors.New("organization and reason must describe the referral, not instruct the assistant")
+		}
+	}
+	if ref.CallbackURL != "" && !strings.HasPrefix(ref.CallbackURL, "https://") && !strings.HasPrefix(ref.CallbackURL, "http://") {
+		return nil, errors.New("callbackUrl must be an http(s) URL")
+	}
+
+	ref.ID = generateID("ref")
+	ref.CreatedAt = time.Now()
+	ref.CallSID = ""
+	ref.CompletedAt = nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for number, pending := range s.referrals {
+		if s.expired(pending, ref.CreatedAt) {
+			s.log.Info("Referral %s expired without a call", pending.ID)
+			delete(s.referrals, number)
+		}
+	}
+	s.referrals[ref.PhoneNumber] = &ref
+
+	s.log.Info("Registered referral %s from %s for %s", ref.ID, ref.Organization, maskPhoneNumber(ref.PhoneNumber))
+	return &ref, nil
+}
+
+// Claim attaches the pending referral for a phone number to a call, if there is one
+func (s *ReferralService) Claim(phoneNumber, callSID string) (*Referral, bool) {
+	phoneNumber = normalizePhoneNumber(phoneNumber)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref, ok := s.referrals[phoneNumber]
+	if !ok {
+		return nil, false
+	}
+	delete(s.referrals, phoneNumber)
+	if s.expired(ref, time.Now()) {
+		s.log.Info("Referral %s expired without a call", ref.ID)
+		return nil, false
+	}
+	ref.CallSID = callSID
+	s.active[callSID] = ref
+
+	s.log.Info("Referral %s claimed by call %s", ref.ID, callSID)
+	return ref, true
+}
+
+// Active returns the referral claimed by a call that hasn't ended yet
+func (s *ReferralService) Active(callSID string) (*Referral, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref, ok := s.active[callSID]
+	return ref, ok
+}
+
+// Complete marks the referral for a call as done and notifies the referring organization
+func (s *ReferralService) Complete(callSID string) {
+	s.mu.Lock()
+	ref, ok := s.active[callSID]
+	if ok {
+		delete(s.active, callSID)
+		now := time.Now()
+		ref.CompletedAt = &now
+		s.completed = append(s.completed, ref)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	s.log.Info("Referral %s completed by call %s", ref.ID, callSID)
+	if ref.CallbackURL != "" {
+		go s.notify(ref)
+	}
+}
+
+// notify sends the completion webhook to the referring organization
+func (s *ReferralService) notify(ref *Referral) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":       "referral.completed",
+		"referralId":  ref.ID,
+		"callSid":     ref.CallSID,
+		"completedAt": ref.CompletedAt,
+	})
+	if err != nil {
+		s.log.Error("Error encoding completion webhook for referral %s: %v", ref.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ref.CallbackURL, bytes.NewReader(payload))
+	if err != nil {
+		s.log.Error("Error building completion webhook for referral %s: %v", ref.ID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.log.Error("Error sending completion webhook for referral %s: %v", ref.ID, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		s.log.Warn("Completion webhook for referral %s returned status %d", ref.ID, resp.StatusCode)
+		return
+	}
+	s.log.Info("Completion webhook delivered for referral %s", ref.ID)
+}
+
+// ForCaller returns every referral registered for the caller's phone number hash
+func (s *ReferralService) ForCaller(callerHash string) []Referral {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var refs []Referral
+	collect := func(ref *Referral) {
+		if HashPhoneNumber(ref.PhoneNumber) == callerHash {
+			refs = append(refs, *ref)
+		}
+	}
+	for _, ref := range s.referrals {
+		collect(ref)
+	}
+	for _, ref := range s.active {
+		collect(ref)
+	}
+	for _, ref := range s.completed {
+		collect(ref)
+	}
+	return refs
+}
+
+// callerHashKey keys HashPhoneNumber, set once at startup; without one numbers are hashed
+// unkeyed, which trying every number can reverse
+var callerHashKey []byte
+
+// SetCallerHashKey has caller hashes made with an HMAC keyed by the secret. Call it before
+// anything is hashed; changing it changes every caller's hash.
+func SetCallerHashKey(secret string) {
+	callerHashKey = []byte(secret)
+}
+
+// HashPhoneNumber returns a stable, non-reversible identifier for a caller's phone number
+func HashPhoneNumber(phone string) string {
+	number := []byte(normalizePhoneNumber(phone))
+	if len(callerHashKey) == 0 {
+		sum := sha256.Sum256(number)
+		return hex.EncodeToString(sum[:12])
+	}
+	mac := hmac.New(sha256.New, callerHashKey)
+	mac.Write(number)
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// normalizePhoneNumber strips formatting and returns the number in E.164, so numbers
+// compare equal regardless of how they were typed. A number without a country code is
+// taken as North American, with the +1 Twilio gives it.
+func normalizePhoneNumber(phone string) string {
+	var b strings.Builder
+	phone = strings.TrimSpace(phone)
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	switch {
+	case digits == "":
+		return ""
+	case strings.HasPrefix(phone, "+"):
+		return "+" + digits
+	case strings.HasPrefix(digits, "00"):
+		return "+" + digits[2:]
+	case len(digits) == 10:
+		return "+1" + digits
+	}
+	return "+" + digits
+}
+
+// generateID returns a random identifier with the given prefix
+func generateID(prefix string) string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
+	}
+	return prefix + "_" + hex.EncodeToString(buf)
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Settings a reload picks up; everything else, like the port, providers and
+// credentials, stays as it was until a restart
+const (
+	ReloadLogLevel   = "logLevel"
+	ReloadPrompts    = "prompts"
+	ReloadPhraseSets = "phraseSets"
+	ReloadPersonas   = "personas"
+	ReloadVoices     = "voices"
+	ReloadCallers    = "callerLists"
+)
+
+// ReloadReport tells what a reload picked up and what kept its previous value
+type ReloadReport struct {
+	Reloaded []string          `json:"reloaded"`
+	Failed   map[string]string `json:"failed,omitempty"` // Setting to why it was kept
+	LogLevel string            `json:"logLevel"`
+}
+
+// ConfigReloader reloads the settings that can change while calls are live: the log
+// level, system prompts, phrase sets, personas, voices and the caller allow and deny lists.
+// Calls in progress keep the persona and voice they use.
+type ConfigReloader struct {
+	configFile string // Read again on each reload, empty when there is none
+	prompts    *PromptStore
+	phraseSets *PhraseSetStore
+	personas   *PersonaRegistry
+	voices     *VoiceCatalog
+	callers    *CallerScreen
+	mu         sync.Mutex
+	log        *logger.Logger
+}
+
+// NewConfigReloader creates a reloader of the running services' settings
+func NewConfigReloader(configFile string, prompts *PromptStore, phraseSets *PhraseSetStore, personas *PersonaRegistry, voices *VoiceCatalog, callers *CallerScreen) *ConfigReloader {
+	return &ConfigReloader{
+		configFile: configFile,
+		prompts:    prompts,
+		phraseSets: phraseSets,
+		personas:   personas,
+		voices:     voices,
+		callers:    callers,
+		log:        logger.Component("Reload"),
+	}
+}
+
+// Reload reads the config file and the files it points to again and swaps in what
+// changed. A setting that fails to load keeps its previous value; the error is reported
+// and the rest still reload. It fails as a whole only when the config file is unreadable.
+func (r *ConfigReloader) Reload() (ReloadReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := ReloadReport{Reloaded: []string{}, Failed: map[string]string{}}
+	if r.configFile != "" {
+		if err := config.LoadFile(r.configFile); err != nil {
+			r.log.Error("Keeping the running configuration, failed to reload %s: %v", r.configFile, err)
+			return report, err
+		}
+	}
+	cfg := config.Load()
+	outcome := func(setting string, err error) {
+		if err != nil {
+			report.Failed[setting] = err.Error()
+			r.log.Error("Keeping the previous %s, failed to reload them: %v", setting, err)
+			return
+		}
+		report.Reloaded = append(report.Reloaded, setting)
+	}
+
+	level, ok := logger.ParseLevel(cfg.LogLevel)
+	if ok {
+		logger.SetLevel(level)
+		logger.SetTranscriptLogging(cfg.LogTranscripts)
+		outcome(ReloadLogLevel, nil)
+	} else {
+		outcome(ReloadLogLevel, fmt.Errorf("unknown log level %q", cfg.LogLevel))
+	}
+	report.LogLevel = logger.GetDefaultLogger().Level().String()
+
+	outcome(ReloadPrompts, r.prompts.Reload())
+	outcome(ReloadPhraseSets, r.phraseSets.Reload())
+
+	if r.personas != nil {
+		personas, err := LoadPersonaRegistry(cfg.PersonasFile)
+		if cfg.PersonasFile == "" && len(cfg.Personas) > 0 {
+			personas, err = NewPersonaRegistry(cfg.Personas, "the config file")
+		}
+		if err == nil {
+			r.personas.Replace(personas)
+		}
+		outcome(ReloadPersonas, err)
+	}
+
+	if r.voices != nil {
+		options, err := ParseVoiceOptions(cfg.TTSVoiceOptions)
+		if err == nil {
+			var languages LanguageVoices
+			if languages, err = ParseLanguageVoices(cfg.TTSLanguageVoices); err == nil {
+				r.voices.Set(options, languages)
+			}
+		}
+		outcome(ReloadVoices, err)
+	}
+
+	if r.callers != nil {
+		callers, err := NewCallerScreen(cfg.CallerAllowlist, cfg.CallerDenylist)
+		if err == nil {
+			r.callers.Set(callers)
+		}
+		outcome(ReloadCallers, err)
+	}
+
+	if len(report.Failed) == 0 {
+		report.Failed = nil
+	}
+	r.log.Info("Reloaded %v, log level %s", report.Reloaded, report.LogLevel)
+	return report, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+func TestConfigReloaderSwapsSettingsInPlace(t *testing.T) {
+	previous := logger.GetDefaultLogger().Level()
+	t.Cleanup(func() { logger.SetLevel(previous) })
+
+	personasFile := writePersonas(t, `[{"name": "maya"}]`)
+	t.Setenv("PERSONAS_FILE", personasFile)
+	t.Setenv("TTS_VOICE_OPTIONS", "calm=en-US-Neural2-F")
+	t.Setenv("LOG_LEVEL", "WARN")
+
+	personas, err := LoadPersonaRegistry(personasFile)
+	if err != nil {
+		t.Fatalf("Failed to load personas: %v", err)
+	}
+	voices := NewVoiceCatalog(nil, nil)
+	reloader := NewConfigReloader("", nil, nil, personas, voices, nil)
+
+	if err := os.WriteFile(personasFile, []byte(`[{"name": "maya"}, {"name": "sam"}]`), 0o644); err != nil {
+		t.Fatalf("Failed to write personas: %v", err)
+	}
+	report, err := reloader.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if len(report.Failed) != 0 || report.LogLevel != "WARN" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if _, ok := personas.Lookup("sam"); !ok {
+		t.Error("Expected the running registry to pick up the new persona")
+	}
+	if options := voices.Options(); len(options) != 1 || options[0].Voice != "en-US-Neural2-F" {
+		t.Errorf("Expected the voice options to be reloaded, got %+v", options)
+	}
+	if logger.Component("Other").Level() != logger.WARN {
+		t.Error("Expected component loggers to follow the reloaded level")
+	}
+
+	// A broken file keeps what was loaded while the rest still reloads
+	if err := os.WriteFile(personasFile, []byte(`{"name": "broken"}`), 0o644); err != nil {
+		t.Fatalf("Failed to write personas: %v", err)
+	}
+	t.Setenv("TTS_VOICE_OPTIONS", "warm=en-US-Neural2-C")
+	report, err = reloader.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if _, failed := report.Failed[ReloadPersonas]; !failed {
+		t.Errorf("Expected the personas to be reported as failed, got %+v", report)
+	}
+	if len(personas.All()) != 2 {
+		t.Errorf("Expected the previous personas to be kept, got %+v", personas.All())
+	}
+	if options := voices.Options(); len(options) != 1 || options[0].Label != "warm" {
+		t.Errorf("Expected the voices to reload despite the personas failing, got %+v", options)
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import "math"
+
+// resampleTaps is the length of the anti-aliasing filter applied before downsampling
+const resampleTaps = 31
+
+// ResampleSamples converts 16-bit PCM from one sample rate to another. Downsampling is
+// low-pass filtered first so that content above the new Nyquist frequency doesn't alias
+// into the speech band; the new samples are then linearly interpolated.
+func ResampleSamples(samples []int16, from, to int) []int16 {
+	if from == to || from <= 0 || to <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	input := make([]float64, len(samples))
+	for i, s := range samples {
+		input[i] = float64(s)
+	}
+	if to < from {
+		input = lowPass(input, 0.45*float64(to)/float64(from))
+	}
+
+	n := int(int64(len(samples)) * int64(to) / int64(from))
+	out := make([]int16, n)
+	step := float64(from) / float64(to)
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		frac := pos - float64(j)
+		next := j + 1
+		if next >= len(input) {
+			next = len(input) - 1
+		}
+		out[i] = clampSample(input[j]*(1-frac) + input[next]*frac)
+	}
+	return out
+}
+
+// lowPass filters with a Hann-windowed sinc whose cutoff is a fraction of the sample rate
+func lowPass(input []float64, cutoff float64) []float64 {
+	taps := make([]float64, resampleTaps)
+	mid := resampleTaps / 2
+	var sum float64
+	for i := range taps {
+		x := float64(i - mid)
+		sinc := 2 * cutoff
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(resampleTaps-1))
+		taps[i] = sinc * window
+		sum += taps[i]
+	}
+
+	out := make([]float64, len(input))
+	for i := range input {
+		var acc float64
+		for k, tap := range taps {
+			if j := i + k - mid; j >= 0 && j < len(input) {
+				acc += input[j] * tap
+			}
+		}
+		// Normalize so the filter has unity gain for speech
+		out[i] = acc / sum
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func tone(freq float64, rate, n int) []int16 {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return samples
+}
+
+func rms(samples []int16) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func TestResampleKeepsSpeechBand(t *testing.T) {
+	out := ResampleSamples(tone(300, 24000, 2400), 24000, 8000)
+	if len(out) != 800 {
+		t.Fatalf("Expected 800 samples, got %d", len(out))
+	}
+	// Skip the filter's edges; a full-scale 8000 sine has an RMS of about 5657
+	if level := rms(out[50:750]); level < 5200 || level > 6100 {
+		t.Errorf("Expected a 300Hz tone to pass, got RMS %.0f", level)
+	}
+}
+
+func TestResampleFiltersAliases(t *testing.T) {
+	// 7kHz would fold back to 1kHz at 8kHz without filtering
+	out := ResampleSamples(tone(7000, 24000, 2400), 24000, 8000)
+	if level := rms(out[50:750]); level > 600 {
+		t.Errorf("Expected a 7kHz tone to be filtered out, got RMS %.0f", level)
+	}
+}
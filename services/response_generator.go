@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+// responseTemperature keeps responses consistent across LLM providers when no
+// temperature is configured
+const responseTemperature = 0.4
+
+// ResponseGenerator produces the therapist's reply to a user message
+type ResponseGenerator interface {
+	GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error)
+}
+
+// StreamingResponseGenerator is a ResponseGenerator that can also stream its reply,
+// calling onText with each piece of text as it is generated
+type StreamingResponseGenerator interface {
+	ResponseGenerator
+	GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error)
+}
+
+// ToolCallingGenerator is a ResponseGenerator that can call the dispatcher's tools while
+// generating its reply, feeding their results back to the model for the final answer
+type ToolCallingGenerator interface {
+	ResponseGenerator
+	GenerateResponseWithTools(ctx context.Context, userMessage string, conversationHistory []string, tools *ToolDispatcher) (string, error)
+}
+
+// LLMProvider is a response generation backend that holds resources
+type LLMProvider interface {
+	ResponseGenerator
+	// Close releases the provider's resources
+	Close() error
+}
+
+// NewLLMProvider creates the LLM provider selected by LLM_PROVIDER, with its system prompt
+// rendered from the prompt templates and the generation params of each call's persona
+func NewLLMProvider(ctx context.Context, cfg *config.Config, prompts *PromptStore, generation *GenerationSettings) (LLMProvider, error) {
+	switch strings.ToLower(cfg.LLMProvider) {
+	case "", "gemini":
+		if strings.ToLower(cfg.GeminiBackend) == "vertex" {
+			return NewVertexGeminiService(ctx, cfg, prompts, generation)
+		}
+		return NewGeminiService(ctx, prompts, generation)
+	case "openai":
+		return NewOpenAIChatService(cfg, prompts, generation)
+	case "claude":
+		return NewClaudeService(cfg, prompts, generation)
+	case "ollama":
+		return NewOllamaService(cfg, prompts, generation)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.LLMProvider)
+	}
+}
+
+// chatMessage is one message of a chat-style LLM request
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatMessages turns the formatted conversation history into chat messages for providers
+// with user and assistant roles. Context notes are added to the system prompt. The user
+// message is appended unless the history already ends with it.
+func chatMessages(systemPrompt, userMessage string, conversationHistory []string) (string, []chatMessage) {
+	system := strings.TrimRight(systemPrompt, "\n")
+	var messages []chatMessage
+	for _, entry := range conversationHistory {
+		switch {
+		case strings.HasPrefix(entry, "Context: "):
+			system += "\n" + strings.TrimPrefix(entry, "Context: ")
+		case strings.HasPrefix(entry, "User: "):
+			messages = append(messages, chatMessage{Role: "user", Content: strings.TrimPrefix(entry, "User: ")})
+		case strings.HasPrefix(entry, "Therapist: "):
+			messages = append(messages, chatMessage{Role: "assistant", Content: strings.TrimPrefix(entry, "Therapist: ")})
+		}
+	}
+	if n := len(messages); n == 0 || messages[n-1].Role != "user" || messages[n-1].Content != userMessage {
+		messages = append(messages, chatMessage{Role: "user", Content: userMessage})
+	}
+	return system, messages
+}
+
+// systemPrompt renders the system prompt for the call the context belongs to, with the
+// runtime values the turn engine gathered for it, followed by the instruction shield
+func systemPrompt(ctx context.Context, prompts *PromptStore, cfg *config.Config) string {
+	data := promptDataFromContext(ctx)
+	data.Persona = PersonaFromContext(ctx)
+	data.PersonaName = personaName(ctx, cfg.PersonaName)
+	data.CallSID = CallSIDFromContext(ctx)
+	data.Language = callLanguage(ctx, cfg.STTLanguageCode)
+	return prompts.Render(data) + instructionShield + "\n"
+}
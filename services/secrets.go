@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"golang.org/x/oauth2/google"
+)
+
+// secretManagerScope is the OAuth scope of the Secret Manager API
+const secretManagerScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// SecretReferencePrefix starts variable values that are Secret Manager references:
+// sm://<secret>, sm://<secret>/<version> or sm://projects/<project>/secrets/<secret>[/versions/<version>]
+const SecretReferencePrefix = "sm://"
+
+// cachedSecret is a secret version's value and when it was fetched
+type cachedSecret struct {
+	value     string
+	fetchedAt time.Time
+}
+
+// SecretResolver swaps Secret Manager references in the environment for the secrets'
+// values, so credentials like TWILIO_AUTH_TOKEN, GEMINI_API_KEY or DATABASE_URL needn't
+// be set in plain text. Values are cached: pinned versions for good, the latest version
+// for the refresh interval, after which Run fetches them again and reports rotations.
+type SecretResolver struct {
+	projectID  string
+	refresh    time.Duration
+	references map[string]string // Variable to the secret version it references
+	cache      map[string]cachedSecret
+	rotated    map[string][]func(value string)
+	mu         sync.Mutex
+
+	client   *http.Client
+	endpoint string // The API's base URL, the version's name and method are appended
+	log      *logger.Logger
+}
+
+// NewSecretResolver creates a resolver of the Secret Manager references in the
+// environment, resolving names without a project in the given one; it returns nil, which
+// resolves nothing, when no variable holds a reference
+func NewSecretResolver(ctx context.Context, projectID string, refresh time.Duration) (*SecretResolver, error) {
+	if len(secretReferences()) == 0 {
+		return nil, nil
+	}
+	client, err := google.DefaultClient(ctx, secretManagerScope)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 10 * time.Second
+	return newSecretResolver(client, "https://secretmanager.googleapis.com/v1", projectID, refresh), nil
+}
+
+// newSecretResolver creates a resolver accessing secrets at the endpoint
+func newSecretResolver(client *http.Client, endpoint, projectID string, refresh time.Duration) *SecretResolver {
+	return &SecretResolver{
+		projectID:  projectID,
+		refresh:    refresh,
+		references: secretReferences(),
+		cache:      make(map[string]cachedSecret),
+		rotated:    make(map[string][]func(string)),
+		client:     client,
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		log:        logger.Component("Secrets"),
+	}
+}
+
+// secretReferences are the variables holding Secret Manager references
+func secretReferences() map[string]string {
+	references := make(map[string]string)
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(value, SecretReferencePrefix) {
+			references[name] = value
+		}
+	}
+	return references
+}
+
+// secretVersion is the resource name of the secret version a reference points to
+func (r *SecretResolver) secretVersion(reference string) (string, error) {
+	name := strings.Trim(strings.TrimPrefix(reference, SecretReferencePrefix), "/")
+	if strings.HasPrefix(name, "projects/") {
+		parts := strings.Split(name, "/")
+		switch {
+		case len(parts) == 4 && parts[2] == "secrets" && parts[3] != "":
+			return name + "/versions/latest", nil
+		case len(parts) == 6 && parts[2] == "secrets" && parts[4] == "versions" && parts[5] != "":
+			return name, nil
+		}
+		return "", fmt.Errorf("invalid secret reference %q", reference)
+	}
+
+	secret, version, _ := strings.Cut(name, "/")
+	if version == "" {
+		version = "latest"
+	}
+	if secret == "" || strings.Contains(version, "/") {
+		return "", fmt.Errorf("invalid secret reference %q", reference)
+	}
+	if r.projectID == "" {
+		return "", fmt.Errorf("GOOGLE_PROJECT_ID is required to resolve secret reference %q", reference)
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", r.projectID, secret, version), nil
+}
+
+// ResolveEnv replaces each reference in the environment with its secret's value. It
+// fails, naming the variable, when a secret can't be read.
+func (r *SecretResolver) ResolveEnv(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.references))
+	for name := range r.references {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version, err := r.secretVersion(r.references[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		value, err := r.resolve(ctx, version, false)
+		if err != nil {
+			return fmt.Errorf("%s: reading %s: %w", name, version, err)
+		}
+		os.Setenv(name, value)
+		config.MarkSecret(name)
+	}
+	r.log.Info("Resolved %d secret reference(s) from Secret Manager", len(names))
+	return nil
+}
+
+// OnRotate calls fn with the variable's new value whenever a refresh finds its secret
+// rotated, so services holding it can switch over
+func (r *SecretResolver) OnRotate(variable string, fn func(value string)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotated[variable] = append(r.rotated[variable], fn)
+}
+
+// Run fetches the secrets on the latest version again every refresh interval until the
+// context is cancelled
+func (r *SecretResolver) Run(ctx context.Context) {
+	if r == nil || r.refresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh fetches the secrets on the latest version again and updates the variables
+// whose secret was rotated. A secret that can't be read keeps its previous value.
+func (r *SecretResolver) Refresh(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	for name, reference := range r.references {
+		version, err := r.secretVersion(reference)
+		if err != nil || !strings.HasSuffix(version, "/versions/latest") {
+			continue // Pinned versions never change
+		}
+		value, err := r.resolve(ctx, version, true)
+		if err != nil {
+			r.log.Warn("Keeping the current %s, failed to refresh it: %v", name, err)
+			continue
+		}
+		if value == os.Getenv(name) {
+			continue
+		}
+		os.Setenv(name, value)
+		r.log.Info("Secret for %s was rotated", name)
+
+		r.mu.Lock()
+		callbacks := append([]func(string){}, r.rotated[name]...)
+		r.mu.Unlock()
+		for _, fn := range callbacks {
+			fn(value)
+		}
+	}
+}
+
+// resolve returns the secret version's value, from the cache unless it has expired or
+// force is set
+func (r *SecretResolver) resolve(ctx context.Context, version string, force bool) (string, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[version]
+	r.mu.Unlock()
+	pinned := !strings.HasSuffix(version, "/versions/latest")
+	if ok && !force && (pinned || r.refresh <= 0 || time.Since(cached.fetchedAt) < r.refresh) {
+		return cached.value, nil
+	}
+
+	value, err := r.access(ctx, version)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.cache[version] = cachedSecret{value: value, fetchedAt: time.Now()}
+	r.mu.Unlock()
+	return value, nil
+}
+
+// access reads a secret version through the API
+func (r *SecretResolver) access(ctx context.Context, version string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.endpoint+"/"+version+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		r.log.Error("Secret Manager API returned status %d: %s", resp.StatusCode, msg)
+		return "", statusError(resp.StatusCode)
+	}
+	var result struct {
+		Payload struct {
+			Data string `json:"data"` // Base64
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	value, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return "", errors.New("secret payload is not base64")
+	}
+	return string(value), nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSecretManager serves secret versions by resource name, counting the reads
+type fakeSecretManager struct {
+	values map[string]string
+	reads  int
+	mu     sync.Mutex
+}
+
+func (f *fakeSecretManager) set(name, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[name] = value
+}
+
+func (f *fakeSecretManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads++
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/"), ":access")
+	value, ok := f.values[name]
+	if !ok {
+		http.Error(w, `{"error": {"code": 404, "status": "NOT_FOUND"}}`, http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, `{"name": %q, "payload": {"data": %q}}`, name, base64.StdEncoding.EncodeToString([]byte(value)))
+}
+
+func newTestSecretResolver(t *testing.T, refresh time.Duration) (*SecretResolver, *fakeSecretManager) {
+	t.Helper()
+	fake := &fakeSecretManager{values: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return newSecretResolver(server.Client(), server.URL+"/v1", "p", refresh), fake
+}
+
+func TestSecretResolverResolvesReferencesInTheEnvironment(t *testing.T) {
+	t.Setenv("TWILIO_AUTH_TOKEN", "sm://twilio-token")
+	t.Setenv("GEMINI_API_KEY", "sm://projects/other/secrets/gemini/versions/3")
+	t.Setenv("DATABASE_URL", "sm://twilio-token/latest") // Same version as the token
+	t.Setenv("TWILIO_ACCOUNT_SID", "AC123")
+	resolver, fake := newTestSecretResolver(t, time.Hour)
+	fake.set("projects/p/secrets/twilio-token/versions/latest", "token-1")
+	fake.set("projects/other/secrets/gemini/versions/3", "gemini-key")
+
+	if err := resolver.ResolveEnv(context.Background()); err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	for name, want := range map[string]string{
+		"TWILIO_AUTH_TOKEN":  "token-1",
+		"GEMINI_API_KEY":     "gemini-key",
+		"DATABASE_URL":       "token-1",
+		"TWILIO_ACCOUNT_SID": "AC123",
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("Expected %s to be %q, got %q", name, want, got)
+		}
+	}
+	if fake.reads != 2 {
+		t.Errorf("Expected each secret version read once, got %d reads", fake.reads)
+	}
+
+	// Refreshing picks up rotations of the latest version and leaves pinned ones alone
+	rotated := ""
+	resolver.OnRotate("TWILIO_AUTH_TOKEN", func(value string) { rotated = value })
+	fake.set("projects/p/secrets/twilio-token/versions/latest", "token-2")
+	fake.set("projects/other/secrets/gemini/versions/3", "changed")
+	resolver.Refresh(context.Background())
+	if os.Getenv("TWILIO_AUTH_TOKEN") != "token-2" || rotated != "token-2" {
+		t.Errorf("Expected the rotated token to be picked up, got %q and %q", os.Getenv("TWILIO_AUTH_TOKEN"), rotated)
+	}
+	if os.Getenv("GEMINI_API_KEY") != "gemini-key" {
+		t.Errorf("Expected the pinned version to be kept, got %q", os.Getenv("GEMINI_API_KEY"))
+	}
+}
+
+func TestSecretResolverFailures(t *testing.T) {
+	t.Setenv("TWILIO_AUTH_TOKEN", "sm://missing")
+	resolver, _ := newTestSecretResolver(t, time.Hour)
+	if err := resolver.ResolveEnv(context.Background()); err == nil || !strings.Contains(err.Error(), "TWILIO_AUTH_TOKEN") {
+		t.Errorf("Expected a missing secret to fail naming the variable, got %v", err)
+	}
+
+	for _, reference := range []string{"sm://", "sm://a/b/c", "sm://projects/p/keys/a"} {
+		if _, err := resolver.secretVersion(reference); err == nil {
+			t.Errorf("Expected %q to be rejected", reference)
+		}
+	}
+	resolver.projectID = ""
+	if _, err := resolver.secretVersion("sm://name"); err == nil {
+		t.Error("Expected a name without a project to need GOOGLE_PROJECT_ID")
+	}
+
+	var none *SecretResolver
+	if err := none.ResolveEnv(context.Background()); err != nil {
+		t.Errorf("Expected a nil resolver to resolve nothing, got %v", err)
+	}
+	none.Refresh(context.Background())
+}
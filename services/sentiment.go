@@ -0,0 +1,192 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Emotions recognized in what callers say
+const (
+	EmotionHopelessness = "hopelessness"
+	EmotionAnxiety      = "anxiety"
+	EmotionSadness      = "sadness"
+	EmotionAnger        = "anger"
+	EmotionJoy          = "joy"
+)
+
+// emotionPriority breaks ties between emotions, most concerning first
+var emotionPriority = []string{EmotionHopelessness, EmotionAnxiety, EmotionSadness, EmotionAnger, EmotionJoy}
+
+// Sentiment is the tone of an utterance
+type Sentiment struct {
+	// Score runs from -1, very negative, to 1, very positive
+	Score float64 `json:"score"`
+	// Emotion is the dominant emotion, empty when none was recognized
+	Emotion string `json:"emotion,omitempty"`
+}
+
+// sentimentTerm is a word or phrase with the emotion it expresses and how strongly
+type sentimentTerm struct {
+	emotion string
+	valence float64
+}
+
+// sentimentLexicon maps words, and a few phrases, to the emotion they express. Strong
+// terms count double.
+var sentimentLexicon = map[string]sentimentTerm{}
+
+func init() {
+	add := func(emotion string, valence float64, terms ...string) {
+		for _, term := range terms {
+			sentimentLexicon[term] = sentimentTerm{emotion: emotion, valence: valence}
+		}
+	}
+	add(EmotionHopelessness, -2, "hopeless", "pointless", "worthless", "useless", "trapped", "burden",
+		"give up", "giving up", "no point", "no way out", "can't go on", "cannot go on", "better off without me")
+	add(EmotionAnxiety, -1, "anxious", "worried", "worry", "worrying", "nervous", "scared", "afraid",
+		"stressed", "stress", "overwhelmed", "fear", "restless", "uneasy")
+	add(EmotionAnxiety, -2, "panic", "panicking", "terrified")
+	add(EmotionSadness, -1, "sad", "down", "depressed", "lonely", "alone", "cry", "crying", "cried",
+		"grief", "grieving", "hurt", "hurting", "empty", "unhappy", "tired", "lost")
+	add(EmotionSadness, -2, "miserable", "heartbroken", "devastated")
+	add(EmotionAnger, -1, "angry", "mad", "annoyed", "frustrated", "frustrating", "unfair", "resent", "hate")
+	add(EmotionAnger, -2, "furious", "pissed")
+	add(EmotionJoy, 1, "happy", "glad", "better", "good", "great", "relieved", "grateful", "thankful",
+		"calm", "hopeful", "proud", "okay", "fine", "love", "helped", "helps", "helpful")
+}
+
+var (
+	// sentimentWord splits text into words, keeping contractions together
+	sentimentWord = regexp.MustCompile(`[a-z]+(?:'[a-z]+)?`)
+	// sentimentNegation flips the word after it, e.g. "not happy"
+	sentimentNegation = map[string]bool{"not": true, "no": true, "never": true, "don't": true, "didn't": true,
+		"isn't": true, "wasn't": true, "can't": true, "won't": true, "hardly": true}
+)
+
+// AnalyzeSentiment scores the tone of an utterance and its dominant emotion with a word
+// lexicon, which is fast enough to run on every turn without an API call
+func AnalyzeSentiment(text string) Sentiment {
+	words := sentimentWord.FindAllString(strings.ToLower(strings.ReplaceAll(text, "’", "'")), -1)
+
+	var total float64
+	weights := make(map[string]float64)
+	score := func(term sentimentTerm, negated bool) {
+		if negated {
+			// "not happy" is negative, but "not scared" isn't a feeling of its own
+			total -= term.valence / 2
+			return
+		}
+		total += term.valence
+		weights[term.emotion] += math.Abs(term.valence)
+	}
+
+	for i := 0; i < len(words); i++ {
+		negated := i > 0 && sentimentNegation[words[i-1]]
+
+		// Phrases of up to five words take precedence over their words
+		matched := false
+		for n := 5; n >= 2 && !matched; n-- {
+			if i+n > len(words) {
+				continue
+			}
+			if term, ok := sentimentLexicon[strings.Join(words[i:i+n], " ")]; ok {
+				score(term, negated)
+				i += n - 1
+				matched = true
+			}
+		}
+		if !matched {
+			if term, ok := sentimentLexicon[words[i]]; ok {
+				score(term, negated)
+			}
+		}
+	}
+
+	sentiment := Sentiment{Score: math.Round(total/math.Sqrt(total*total+4)*100) / 100}
+	var strongest float64
+	for _, emotion := range emotionPriority {
+		if weights[emotion] > strongest {
+			sentiment.Emotion, strongest = emotion, weights[emotion]
+		}
+	}
+	return sentiment
+}
+
+// SentimentPoint is the sentiment of one of the caller's messages
+type SentimentPoint struct {
+	Index int `json:"index"` // Position of the message in the transcript
+	Sentiment
+}
+
+// SetLastUserSentiment records the sentiment of the caller's latest message
+func (c *Conversation) SetLastUserSentiment(sentiment Sentiment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Role == "user" {
+			c.Messages[i].Sentiment = &sentiment
+			return
+		}
+	}
+}
+
+// SentimentTrajectory returns the sentiment of the caller's messages in order
+func (c *Conversation) SentimentTrajectory() []SentimentPoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var points []SentimentPoint
+	for i, msg := range c.Messages {
+		if msg.Sentiment != nil {
+			points = append(points, SentimentPoint{Index: i, Sentiment: *msg.Sentiment})
+		}
+	}
+	return points
+}
+
+// SentimentNote describes how the caller sounds for the prompt, so the AI can adapt its
+// tone, or is empty when nothing stands out
+func SentimentNote(trajectory []SentimentPoint) string {
+	if len(trajectory) == 0 {
+		return ""
+	}
+	latest := trajectory[len(trajectory)-1]
+	if latest.Emotion == "" && math.Abs(latest.Score) < 0.3 {
+		return ""
+	}
+
+	note := fmt.Sprintf("The caller's last message sounds %s (sentiment %.2f from -1 to 1)",
+		emotionDescription(latest.Emotion, latest.Score), latest.Score)
+	if len(trajectory) > 1 {
+		switch change := latest.Score - trajectory[0].Score; {
+		case change <= -0.3:
+			note += ", more negative than earlier in the call"
+		case change >= 0.3:
+			note += ", more positive than earlier in the call"
+		}
+	}
+	return note + ". Adapt your tone to how they are feeling."
+}
+
+// emotionDescription puts an emotion into words
+func emotionDescription(emotion string, score float64) string {
+	switch emotion {
+	case EmotionHopelessness:
+		return "hopeless"
+	case EmotionAnxiety:
+		return "anxious"
+	case EmotionSadness:
+		return "sad"
+	case EmotionAnger:
+		return "angry"
+	case EmotionJoy:
+		return "positive"
+	}
+	if score < 0 {
+		return "negative"
+	}
+	return "positive"
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeSentiment(t *testing.T) {
+	tests := []struct {
+		text    string
+		emotion string
+		sign    int
+	}{
+		{"I feel so hopeless, there's no point anymore", EmotionHopelessness, -1},
+		{"I'm really anxious and scared about tomorrow", EmotionAnxiety, -1},
+		{"I've been so lonely since she left, I cry every night", EmotionSadness, -1},
+		{"I'm furious at my boss, it's so unfair", EmotionAnger, -1},
+		{"Talking helped, I feel a bit better and calm now", EmotionJoy, 1},
+		{"I'm not happy", "", -1},
+		{"I went to the store", "", 0},
+	}
+	for _, tt := range tests {
+		got := AnalyzeSentiment(tt.text)
+		if got.Emotion != tt.emotion {
+			t.Errorf("AnalyzeSentiment(%q) emotion = %q, want %q", tt.text, got.Emotion, tt.emotion)
+		}
+		if sign := compareScore(got.Score); sign != tt.sign || got.Score < -1 || got.Score > 1 {
+			t.Errorf("AnalyzeSentiment(%q) score = %.2f, want sign %d", tt.text, got.Score, tt.sign)
+		}
+	}
+}
+
+// compareScore returns the sign of a score
+func compareScore(score float64) int {
+	switch {
+	case score < 0:
+		return -1
+	case score > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestSentimentTrajectoryAndNote(t *testing.T) {
+	c := NewConversationService().GetOrCreateConversation("test-call")
+	c.AddUserMessage("Things are okay I guess")
+	c.SetLastUserSentiment(AnalyzeSentiment("Things are okay I guess"))
+	c.AddTherapistMessage("I'm glad to hear that.")
+	c.AddUserMessage("Actually I feel hopeless and worthless")
+	c.SetLastUserSentiment(AnalyzeSentiment("Actually I feel hopeless and worthless"))
+
+	trajectory := c.SentimentTrajectory()
+	if len(trajectory) != 2 || trajectory[0].Index != 0 || trajectory[1].Index != 2 {
+		t.Fatalf("Expected the two user messages in order, got %+v", trajectory)
+	}
+
+	note := SentimentNote(trajectory)
+	if !strings.Contains(note, "hopeless") || !strings.Contains(note, "more negative than earlier") {
+		t.Errorf("Expected the note to describe the latest emotion and the trend, got %q", note)
+	}
+	if note := SentimentNote([]SentimentPoint{{Sentiment: AnalyzeSentiment("I went to the store")}}); note != "" {
+		t.Errorf("Expected no note for a neutral message, got %q", note)
+	}
+}
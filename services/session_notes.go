@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// sessionNotesInstructions asks the model for SOAP notes as JSON instead of a reply
+var sessionNotesInstructions = "The phone conversation above has ended. Write clinical session notes on it in the SOAP style for the clinician reviewing the call. " +
+	"Answer with only a JSON object, without a code block, with these fields: " +
+	`"subjective", what the caller reported in their own terms: their concerns, feelings and circumstances; ` +
+	`"assessment", your clinical impression of the caller's state, needs and risks, without diagnosing; ` +
+	`"plan", up to 5 concrete next steps for the clinician. ` +
+	"Write in the third person, stick to what was said and do not reply to the caller."
+
+// SessionNotes are SOAP-style clinical notes on a call. Subjective, Assessment and Plan are
+// written by the LLM from the transcript; Objective is measured from the call itself.
+type SessionNotes struct {
+	CallSID    string    `json:"callSid"`
+	Subjective string    `json:"subjective"`
+	Objective  string    `json:"objective"`
+	Assessment string    `json:"assessment"`
+	Plan       []string  `json:"plan"`
+	Model      string    `json:"model,omitempty"` // Model that wrote the notes
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// SessionNoteWriter writes the session notes of ended calls. It keeps them apart from the
+// conversations, so they stay available once a call is evicted from memory.
+type SessionNoteWriter struct {
+	generator ResponseGenerator
+	timeout   time.Duration
+	notes     map[string]*SessionNotes // By call SID
+	mu        sync.Mutex
+	log       *logger.Logger
+}
+
+// NewSessionNoteWriter creates a writer writing with the generator
+func NewSessionNoteWriter(generator ResponseGenerator) *SessionNoteWriter {
+	return &SessionNoteWriter{
+		generator: generator,
+		timeout:   60 * time.Second,
+		notes:     make(map[string]*SessionNotes),
+		log:       logger.Component("SessionNotes"),
+	}
+}
+
+// Write writes and keeps the session notes of an ended call. Calls where the caller said
+// nothing get no notes; a nil writer does nothing.
+func (w *SessionNoteWriter) Write(ctx context.Context, conv *Conversation) (*SessionNotes, error) {
+	if w == nil {
+		return nil, nil
+	}
+	transcript, spoke := callTranscript(conv)
+	if !spoke {
+		w.log.Debug("Not writing notes on call %s, the caller said nothing", conv.ID)
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(WithCallSID(ctx, conv.ID), w.timeout)
+	defer cancel()
+	ctx, report := WithGenerationReport(ctx)
+	startTime := time.Now()
+	response, err := w.generator.GenerateResponse(ctx, transcript, []string{"Context: " + sessionNotesInstructions})
+	if err != nil {
+		w.log.Error("Failed to write notes on call %s after %v: %v", conv.ID, time.Since(startTime), err)
+		return nil, err
+	}
+
+	notes, err := parseSessionNotes(response)
+	if err != nil {
+		w.log.Error("Unusable notes on call %s: %v", conv.ID, err)
+		return nil, err
+	}
+	notes.CallSID = conv.ID
+	notes.Objective = objectiveNotes(conv)
+	notes.Model = report.Model
+	notes.CreatedAt = time.Now()
+
+	w.mu.Lock()
+	w.notes[conv.ID] = notes
+	w.mu.Unlock()
+
+	w.log.Info("Wrote notes on call %s in %v, %d plan step(s)", conv.ID, time.Since(startTime), len(notes.Plan))
+	return notes, nil
+}
+
+// Notes returns the session notes of the call, if they were written
+func (w *SessionNoteWriter) Notes(callSID string) (*SessionNotes, bool) {
+	if w == nil {
+		return nil, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	notes, ok := w.notes[callSID]
+	return notes, ok
+}
+
+// parseSessionNotes reads the JSON notes from the model's response, which may still be
+// wrapped in a code block
+func parseSessionNotes(response string) (*SessionNotes, error) {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("no JSON object in the response")
+	}
+
+	var notes SessionNotes
+	if err := json.Unmarshal([]byte(response[start:end+1]), &notes); err != nil {
+		return nil, fmt.Errorf("parsing notes: %w", err)
+	}
+	if strings.TrimSpace(notes.Subjective) == "" || strings.TrimSpace(notes.Assessment) == "" {
+		return nil, errors.New("notes have no subjective or assessment")
+	}
+	if notes.Plan == nil {
+		notes.Plan = []string{}
+	}
+	return &notes, nil
+}
+
+// objectiveNotes describes what was measured on the call: how long it ran, how often the
+// caller spoke, how their sentiment moved and the risks flagged while it was live
+func objectiveNotes(conv *Conversation) string {
+	transcript := conv.Transcript()
+	turns := 0
+	var last time.Time
+	for _, msg := range transcript {
+		if msg.Role == "user" {
+			turns++
+		}
+		if msg.CreatedAt.After(last) {
+			last = msg.CreatedAt
+		}
+	}
+	if ended := conv.EndedAt(); !ended.IsZero() {
+		last = ended
+	}
+
+	notes := []string{fmt.Sprintf("Call of %s; the caller spoke %d time(s).", formatCallDuration(last.Sub(conv.CreatedAt)), turns)}
+	if points := conv.SentimentTrajectory(); len(points) > 0 {
+		first, latest := points[0].Sentiment, points[len(points)-1].Sentiment
+		notes = append(notes, fmt.Sprintf("Sentiment went from %s to %s.", formatSentiment(first), formatSentiment(latest)))
+	}
+	if flags := conv.RiskFlags(); len(flags) > 0 {
+		notes = append(notes, "Risks flagged during the call: "+strings.Join(flags, ", ")+".")
+	} else {
+		notes = append(notes, "No risks were flagged during the call.")
+	}
+	return strings.Join(notes, " ")
+}
+
+// formatCallDuration writes a duration in whole minutes, or seconds for short calls
+func formatCallDuration(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d second(s)", int(d.Seconds()))
+	}
+	return fmt.Sprintf("%d minute(s)", int(d.Round(time.Minute).Minutes()))
+}
+
+// formatSentiment writes a sentiment score with its emotion, e.g. -0.40 (anxious)
+func formatSentiment(s Sentiment) string {
+	if s.Emotion == "" {
+		return fmt.Sprintf("%.2f", s.Score)
+	}
+	return fmt.Sprintf("%.2f (%s)", s.Score, s.Emotion)
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionNoteWriterKeepsTheNotes(t *testing.T) {
+	conversations := NewConversationService()
+	conv := conversations.GetOrCreateConversation("test-call")
+	conv.CreatedAt = time.Now().Add(-12 * time.Minute)
+	conv.AddUserMessage("I lost my job and I can't stop worrying")
+	conv.SetLastUserSentiment(Sentiment{Score: -0.6, Emotion: "anxious"})
+	conv.AddTherapistMessage("That sounds really hard.")
+	conv.AddUserMessage("Talking helps a bit")
+	conv.SetLastUserSentiment(Sentiment{Score: 0.1})
+	conv.FlagRisk(RiskSubstanceUse)
+	conv.End()
+
+	generator := &summaryGenerator{response: "```json\n" + `{
+		"subjective": "The caller reports losing their job and constant worry.",
+		"assessment": "Acute stress after job loss, coping through talking.",
+		"plan": ["Follow up within a week", "Share employment support resources"]
+	}` + "\n```"}
+	writer := NewSessionNoteWriter(generator)
+	notes, err := writer.Write(context.Background(), conv)
+	if err != nil {
+		t.Fatalf("Failed to write notes: %v", err)
+	}
+
+	if !strings.Contains(generator.history[0], "SOAP") || !strings.Contains(generator.prompt, "User: I lost my job") {
+		t.Errorf("Expected the transcript with the notes instructions, got %q and %q", generator.prompt, generator.history)
+	}
+	if notes.CallSID != "test-call" || len(notes.Plan) != 2 || notes.Model != "summary-model" || notes.CreatedAt.IsZero() {
+		t.Errorf("Unexpected notes: %+v", notes)
+	}
+	want := "Call of 12 minute(s); the caller spoke 2 time(s). Sentiment went from -0.60 (anxious) to 0.10. Risks flagged during the call: substance_use."
+	if notes.Objective != want {
+		t.Errorf("Expected the objective measured from the call\nwant %q\n got %q", want, notes.Objective)
+	}
+
+	// The notes stay once the conversation is gone
+	conversations.RemoveConversation("test-call")
+	if kept, ok := writer.Notes("test-call"); !ok || kept != notes {
+		t.Error("Expected the notes kept apart from the conversation")
+	}
+}
+
+func TestSessionNoteWriterSkipsAndFails(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("test-call")
+	conv.AddTherapistMessage("Hello, how are you feeling today?")
+	generator := &summaryGenerator{response: `{"subjective": "Nothing."}`}
+	writer := NewSessionNoteWriter(generator)
+
+	if notes, err := writer.Write(context.Background(), conv); notes != nil || err != nil || generator.prompt != "" {
+		t.Errorf("Expected a call where the caller said nothing to be skipped, got %+v, %v", notes, err)
+	}
+
+	conv.AddUserMessage("I'm fine")
+	if _, err := writer.Write(context.Background(), conv); err == nil {
+		t.Error("Expected notes without an assessment to be rejected")
+	}
+	if _, ok := writer.Notes("test-call"); ok {
+		t.Error("Expected no notes kept after a failure")
+	}
+
+	var nilWriter *SessionNoteWriter
+	if notes, err := nilWriter.Write(context.Background(), conv); notes != nil || err != nil {
+		t.Errorf("Expected a nil writer to do nothing, got %+v, %v", notes, err)
+	}
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// sloMinSamples is how many turns the short window needs before an alert fires, so a
+// couple of slow turns on a quiet line don't page anyone
+const sloMinSamples = 10
+
+// SLO alert statuses
+const (
+	SLOFiring   = "firing"
+	SLOResolved = "resolved"
+)
+
+// SLOAlertEvent is the data of slo.alert: a stage burning its error budget too fast, or
+// back within it
+type SLOAlertEvent struct {
+	Status        string  `json:"status"` // firing or resolved
+	Stage         string  `json:"stage"`
+	ThresholdMs   int64   `json:"thresholdMs"`
+	Objective     float64 `json:"objective"`
+	Compliance    float64 `json:"compliance"`
+	BurnRate      float64 `json:"burnRate"`
+	ShortBurnRate float64 `json:"shortBurnRate"`
+}
+
+// SLOStatus is how a stage is doing against its latency objective over the window
+type SLOStatus struct {
+	Stage         string  `json:"stage"`
+	ThresholdMs   int64   `json:"thresholdMs"`
+	Objective     float64 `json:"objective"`  // Share of turns that should be within the threshold
+	Compliance    float64 `json:"compliance"` // Share that were, 1 without turns
+	Turns         int     `json:"turns"`
+	BurnRate      float64 `json:"burnRate"`      // How fast the error budget is spent, 1 spends it exactly
+	ShortBurnRate float64 `json:"shortBurnRate"` // The same over the last twelfth of the window
+	Alerting      bool    `json:"alerting"`
+}
+
+// sloBucket counts a minute's turns
+type sloBucket struct {
+	minute int64
+	good   int
+	total  int
+}
+
+// sloStage is a stage's threshold and its turns over the window, a bucket per minute
+type sloStage struct {
+	threshold time.Duration
+	buckets   []sloBucket
+	alerting  bool
+}
+
+// SLOTracker tracks the share of turns whose stages stay within their latency thresholds
+// over a rolling window, and alerts webhooks and Slack when a stage burns its error budget
+// faster than allowed, over both the window and its last twelfth so a brief spike
+// doesn't page and a lasting one does quickly
+type SLOTracker struct {
+	objective float64
+	window    int // Minutes
+	burnAlert float64
+	stages    map[string]*sloStage
+
+	// Events receive slo.alert events; Slack, when set, is an incoming webhook URL
+	Events EventPublishers
+	Slack  string
+
+	mu     sync.Mutex
+	now    func() time.Time
+	client *http.Client
+	log    *logger.Logger
+}
+
+// NewSLOTracker creates a tracker of the stages' thresholds, e.g. generation within 2s,
+// where objective is the share of turns that should meet them over window, and alerts fire
+// at burnAlert times the sustainable burn rate. It returns nil, tracking nothing, when no
+// stage has a threshold.
+func NewSLOTracker(thresholds map[string]time.Duration, objective float64, window time.Duration, burnAlert float64) *SLOTracker {
+	minutes := int(window.Minutes())
+	if minutes < 1 {
+		minutes = 1
+	}
+	stages := make(map[string]*sloStage)
+	for stage, threshold := range thresholds {
+		if threshold > 0 {
+			stages[stage] = &sloStage{threshold: threshold, buckets: make([]sloBucket, minutes)}
+		}
+	}
+	if len(stages) == 0 {
+		return nil
+	}
+	return &SLOTracker{
+		objective: objective,
+		window:    minutes,
+		burnAlert: burnAlert,
+		stages:    stages,
+		now:       time.Now,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		log:       logger.Component("SLO"),
+	}
+}
+
+// Observe records how long a turn's stage took; stages without a threshold are ignored
+func (t *SLOTracker) Observe(stage string, latency time.Duration) {
+	if t == nil || latency <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.stages[stage]
+	if !ok {
+		return
+	}
+	minute := t.now().Unix() / 60
+	b := &s.buckets[minute%int64(len(s.buckets))]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	if latency <= s.threshold {
+		b.good++
+	}
+}
+
+// count sums the stage's turns over the last minutes
+func (t *SLOTracker) count(s *sloStage, minutes int) (good, total int) {
+	now := t.now().Unix() / 60
+	for _, b := range s.buckets {
+		if b.total > 0 && b.minute > now-int64(minutes) {
+			good += b.good
+			total += b.total
+		}
+	}
+	return good, total
+}
+
+// burnRate is how many times faster than sustainable the turns spend the error budget
+func (t *SLOTracker) burnRate(good, total int) float64 {
+	if total == 0 || t.objective >= 1 {
+		return 0
+	}
+	return (float64(total-good) / float64(total)) / (1 - t.objective)
+}
+
+// status reports the stage; the caller holds the lock
+func (t *SLOTracker) status(stage string, s *sloStage) (SLOStatus, int) {
+	good, total := t.count(s, t.window)
+	shortGood, shortTotal := t.count(s, max(t.window/12, 1))
+	status := SLOStatus{
+		Stage:         stage,
+		ThresholdMs:   s.threshold.Milliseconds(),
+		Objective:     t.objective,
+		Compliance:    1,
+		Turns:         total,
+		BurnRate:      t.burnRate(good, total),
+		ShortBurnRate: t.burnRate(shortGood, shortTotal),
+		Alerting:      s.alerting,
+	}
+	if total > 0 {
+		status.Compliance = float64(good) / float64(total)
+	}
+	return status, shortTotal
+}
+
+// Status reports every stage, by name; nil when no SLO is set
+func (t *SLOTracker) Status() []SLOStatus {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]SLOStatus, 0, len(t.stages))
+	for stage, s := range t.stages {
+		status, _ := t.status(stage, s)
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Stage < statuses[j].Stage })
+	return statuses
+}
+
+// Evaluate fires alerts for stages burning their budget too fast, and resolves them once
+// the window's burn rate is back under the threshold
+func (t *SLOTracker) Evaluate(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	var alerts []SLOAlertEvent
+	t.mu.Lock()
+	for stage, s := range t.stages {
+		status, shortTotal := t.status(stage, s)
+		switch {
+		case !s.alerting && shortTotal >= sloMinSamples && status.BurnRate >= t.burnAlert && status.ShortBurnRate >= t.burnAlert:
+			s.alerting = true
+			alerts = append(alerts, alertEvent(SLOFiring, status))
+		case s.alerting && status.BurnRate < t.burnAlert:
+			s.alerting = false
+			alerts = append(alerts, alertEvent(SLOResolved, status))
+		}
+	}
+	t.mu.Unlock()
+
+	for _, alert := range alerts {
+		t.alert(ctx, alert)
+	}
+}
+
+// alertEvent creates the alert of a stage's status
+func alertEvent(state string, status SLOStatus) SLOAlertEvent {
+	return SLOAlertEvent{
+		Status:        state,
+		Stage:         status.Stage,
+		ThresholdMs:   status.ThresholdMs,
+		Objective:     status.Objective,
+		Compliance:    status.Compliance,
+		BurnRate:      status.BurnRate,
+		ShortBurnRate: status.ShortBurnRate,
+	}
+}
+
+// alert publishes the alert and posts it to Slack
+func (t *SLOTracker) alert(ctx context.Context, alert SLOAlertEvent) {
+	text := fmt.Sprintf("SLO %s: %s within %dms for %.1f%% of turns over the last %d minutes, objective %.1f%%, burn rate %.1fx",
+		alert.Status, alert.Stage, alert.ThresholdMs, alert.Compliance*100, t.window, alert.Objective*100, alert.BurnRate)
+	if alert.Status == SLOFiring {
+		t.log.Warn("%s", text)
+	} else {
+		t.log.Info("%s", text)
+	}
+	t.Events.Publish(EventSLOAlert, "", alert)
+
+	if t.Slack == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Slack, bytes.NewReader(body))
+	if err != nil {
+		t.log.Warn("Failed to post SLO alert to Slack: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		t.log.Warn("Failed to post SLO alert to Slack: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		t.log.Warn("Failed to post SLO alert to Slack: %v", statusError(resp.StatusCode))
+	}
+}
+
+// Run evaluates the SLOs every interval until the context is cancelled
+func (t *SLOTracker) Run(ctx context.Context, interval time.Duration) {
+	if t == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Evaluate(ctx)
+		}
+	}
+}
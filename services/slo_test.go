@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventRecorder is an EventPublisher keeping the events published
+type eventRecorder struct {
+	events []WebhookEvent
+}
+
+func (r *eventRecorder) Publish(eventType, callSID string, data any) {
+	r.events = append(r.events, newEvent(eventType, callSID, data))
+}
+
+func TestSLOTrackerAlertsWhileTheBudgetBurns(t *testing.T) {
+	slack := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&message)
+		slack <- message.Text
+	}))
+	defer server.Close()
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tracker := NewSLOTracker(map[string]time.Duration{StageGeneration: 2 * time.Second, StageSynthesis: 0}, 0.9, time.Hour, 2)
+	tracker.now = func() time.Time { return now }
+	events := &eventRecorder{}
+	tracker.Events = EventPublishers{events}
+	tracker.Slack = server.URL
+
+	// 7 of 10 turns within the threshold spend the 10% budget 3 times too fast
+	for i := 0; i < 10; i++ {
+		latency := time.Second
+		if i < 3 {
+			latency = 3 * time.Second
+		}
+		tracker.Observe(StageGeneration, latency)
+		tracker.Observe(StageSynthesis, latency) // Without an SLO
+	}
+	tracker.Evaluate(context.Background())
+
+	status := tracker.Status()
+	if len(status) != 1 || status[0].Compliance != 0.7 || status[0].Turns != 10 || !status[0].Alerting {
+		t.Fatalf("Expected generation alerting at 70%% compliance, got %+v", status)
+	}
+	if len(events.events) != 1 || events.events[0].Type != EventSLOAlert || events.events[0].Data.(SLOAlertEvent).Status != SLOFiring {
+		t.Errorf("Expected a firing slo.alert event, got %+v", events.events)
+	}
+	if text := <-slack; !strings.Contains(text, "firing: generation within 2000ms for 70.0%") {
+		t.Errorf("Expected the alert posted to Slack, got %q", text)
+	}
+
+	// Evaluating again doesn't repeat the alert
+	tracker.Evaluate(context.Background())
+	if len(events.events) != 1 {
+		t.Errorf("Expected one alert while the budget burns, got %d", len(events.events))
+	}
+
+	// Once the slow turns leave the window, the alert resolves
+	now = now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		tracker.Observe(StageGeneration, time.Second)
+	}
+	tracker.Evaluate(context.Background())
+	if len(events.events) != 2 || events.events[1].Data.(SLOAlertEvent).Status != SLOResolved {
+		t.Errorf("Expected the alert resolved, got %+v", events.events)
+	}
+	if text := <-slack; !strings.Contains(text, "resolved") {
+		t.Errorf("Expected the resolution posted to Slack, got %q", text)
+	}
+}
+
+func TestSLOTrackerNeedsEnoughTurnsToAlert(t *testing.T) {
+	tracker := NewSLOTracker(map[string]time.Duration{StageSynthesis: time.Second}, 0.95, time.Hour, 2)
+	tracker.Observe(StageSynthesis, 5*time.Second)
+	tracker.Observe(StageSynthesis, 5*time.Second)
+	tracker.Evaluate(context.Background())
+	if status := tracker.Status(); status[0].Alerting || status[0].BurnRate < 19 {
+		t.Errorf("Expected two slow turns not to alert, got %+v", status)
+	}
+
+	if NewSLOTracker(map[string]time.Duration{StageGeneration: 0}, 0.95, time.Hour, 2) != nil {
+		t.Error("Expected no tracker without a threshold")
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+// Transcript is a recognition result from a speech-to-text provider
+type Transcript struct {
+	Text       string
+	IsFinal    bool
+	Confidence float32
+	// Stability is how unlikely an interim result is to change, 0 when the provider doesn't say
+	Stability float32
+	// EndOfSpeech is set when the provider detected the caller stopped talking;
+	// it may come with a final result or on its own with empty text
+	EndOfSpeech bool
+	// Words are the word time offsets of a final result, when the provider reports them
+	Words []WordTiming
+	// Language is the language the provider detected the caller speaking, when it listens
+	// for more than one
+	Language string
+}
+
+// WordTiming is when a recognized word was spoken, relative to the start of the call's
+// recognized audio. With voice activity detection on, skipped silence isn't counted.
+type WordTiming struct {
+	Word  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// secondsToDuration converts the fractional seconds providers report offsets in
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// shiftWords offsets word timings by base, for providers whose times restart per stream or segment
+func shiftWords(words []WordTiming, base time.Duration) []WordTiming {
+	for i := range words {
+		words[i].Start += base
+		words[i].End += base
+	}
+	return words
+}
+
+// RecognitionStream is a single streaming recognition session for a call
+type RecognitionStream interface {
+	// SendAudio streams a chunk of caller audio to the provider
+	SendAudio(audio []byte) error
+	// Transcripts delivers results until the stream ends, then is closed
+	Transcripts() <-chan Transcript
+	// Close signals the end of the audio
+	Close() error
+}
+
+// SpeechRecognizer is a speech-to-text provider
+type SpeechRecognizer interface {
+	// StartStream opens a streaming recognition session for audio in the given format
+	StartStream(ctx context.Context, format AudioFormat) (RecognitionStream, error)
+	// TranscribeRecording transcribes a complete 8kHz 16-bit WAV recording
+	TranscribeRecording(ctx context.Context, wav []byte) (string, error)
+	// Close releases the provider's resources
+	Close() error
+}
+
+// NewSpeechRecognizer creates the speech-to-text provider selected by STT_PROVIDER.
+// Providers that support speech adaptation bias recognition toward the phrase sets.
+func NewSpeechRecognizer(ctx context.Context, cfg *config.Config, phraseSets *PhraseSetStore) (SpeechRecognizer, error) {
+	switch strings.ToLower(cfg.STTProvider) {
+	case "", "google":
+		stt, err := NewSpeechToTextService(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stt.phraseSets = phraseSets
+		return stt, nil
+	case "deepgram":
+		stt, err := NewDeepgramService(cfg)
+		if err != nil {
+			return nil, err
+		}
+		stt.phraseSets = phraseSets
+		return stt, nil
+	case "whisper":
+		return NewWhisperService(cfg)
+	case "assemblyai":
+		return NewAssemblyAIService(cfg)
+	case "azure":
+		return NewAzureSpeechService(cfg)
+	default:
+		return nil, fmt.Errorf("unknown STT provider %q", cfg.STTProvider)
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// TextToSpeechProvider is a speech synthesis backend that holds resources
+type TextToSpeechProvider interface {
+	SpeechSynthesizer
+	// Close releases the provider's resources
+	Close() error
+}
+
+// NewTextToSpeechProvider creates the text-to-speech providers listed in TTS_PROVIDERS,
+// or the one selected by TTS_PROVIDER. Several providers are tried in order.
+func NewTextToSpeechProvider(ctx context.Context, cfg *config.Config) (TextToSpeechProvider, error) {
+	names := cfg.TTSProviders
+	if len(names) == 0 {
+		names = []string{cfg.TTSProvider}
+	}
+
+	var chain []namedSynthesizer
+	for _, name := range names {
+		provider, err := newTextToSpeechProvider(ctx, name, cfg)
+		if err != nil {
+			for _, created := range chain {
+				created.provider.Close()
+			}
+			return nil, err
+		}
+		chain = append(chain, namedSynthesizer{name: name, provider: provider})
+	}
+	if len(chain) == 1 {
+		return chain[0].provider, nil
+	}
+	return newFailoverSynthesizer(chain, time.Duration(cfg.TTSProviderTimeoutMs)*time.Millisecond), nil
+}
+
+// newTextToSpeechProvider creates a single text-to-speech provider by name
+func newTextToSpeechProvider(ctx context.Context, name string, cfg *config.Config) (TextToSpeechProvider, error) {
+	switch strings.ToLower(name) {
+	case "", "google":
+		return NewTextToSpeechService(ctx)
+	case "azure":
+		return NewAzureTTSService(cfg)
+	case "polly":
+		return NewPollyTTSService(cfg)
+	case "openai":
+		return NewOpenAITTSService(cfg)
+	default:
+		return nil, fmt.Errorf("unknown TTS provider %q", name)
+	}
+}
+
+// namedSynthesizer is a provider in a failover chain
+type namedSynthesizer struct {
+	name     string
+	provider TextToSpeechProvider
+}
+
+// FailoverSynthesizer tries its providers in order until one synthesizes the text, so the
+// caller still hears a response when the primary provider fails or is slow
+type FailoverSynthesizer struct {
+	chain   []namedSynthesizer
+	timeout time.Duration // Per-provider limit, 0 for none
+	log     *logger.Logger
+}
+
+// newFailoverSynthesizer creates a failover chain of providers
+func newFailoverSynthesizer(chain []namedSynthesizer, timeout time.Duration) *FailoverSynthesizer {
+	log := logger.Component("TTSFailover")
+	names := make([]string, len(chain))
+	for i, p := range chain {
+		names[i] = p.name
+	}
+	log.Info("TTS failover chain: %s (timeout %v per provider)", strings.Join(names, " -> "), timeout)
+
+	return &FailoverSynthesizer{chain: chain, timeout: timeout, log: log}
+}
+
+// SynthesizeSpeech returns the audio of the first provider that succeeds
+func (f *FailoverSynthesizer) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	log := CallLogger(ctx, f.log)
+	var errs []error
+	for i, p := range f.chain {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if f.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, f.timeout)
+		}
+		audio, err := p.provider.SynthesizeSpeech(attemptCtx, text, format)
+		cancel()
+		if err == nil {
+			if i > 0 {
+				log.Warn("Synthesized with fallback provider %s", p.name)
+			}
+			return audio, nil
+		}
+
+		log.Error("TTS provider %s failed: %v", p.name, err)
+		errs = append(errs, fmt.Errorf("%s: %w", p.name, err))
+		// The call is over or the turn was abandoned, no provider will help
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Probe probes the providers in the chain that can be, and fails when none of them is
+// healthy, as calls are still answered while one is. A chain with none that can be passes.
+func (f *FailoverSynthesizer) Probe(ctx context.Context) error {
+	var errs []error
+	probed := 0
+	for _, p := range f.chain {
+		prober, ok := p.provider.(HealthProber)
+		if !ok {
+			continue
+		}
+		probed++
+		err := prober.Probe(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.name, err))
+	}
+	if probed == 0 {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every provider in the chain
+func (f *FailoverSynthesizer) Close() error {
+	var errs []error
+	for _, p := range f.chain {
+		errs = append(errs, p.provider.Close())
+	}
+	return errors.Join(errs...)
+}
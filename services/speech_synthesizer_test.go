@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubProvider is a TextToSpeechProvider that fails, stalls or speaks the text bytes
+type stubProvider struct {
+	err    error
+	stall  bool
+	calls  int
+	closed bool
+}
+
+func (s *stubProvider) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	s.calls++
+	if s.stall {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []byte(text), nil
+}
+
+func (s *stubProvider) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestFailoverSynthesizerFallsThroughFailingAndSlowProviders(t *testing.T) {
+	failing := &stubProvider{err: errors.New("quota exceeded")}
+	slow := &stubProvider{stall: true}
+	working := &stubProvider{}
+	chain := newFailoverSynthesizer([]namedSynthesizer{
+		{name: "google", provider: failing},
+		{name: "azure", provider: slow},
+		{name: "polly", provider: working},
+	}, 20*time.Millisecond)
+
+	audio, err := chain.SynthesizeSpeech(context.Background(), "hello", DefaultAudioFormat())
+	if err != nil || string(audio) != "hello" {
+		t.Fatalf("Expected the third provider's audio, got %q, %v", audio, err)
+	}
+	if failing.calls != 1 || slow.calls != 1 || working.calls != 1 {
+		t.Errorf("Expected each provider tried once, got %d/%d/%d", failing.calls, slow.calls, working.calls)
+	}
+
+	chain.Close()
+	if !failing.closed || !slow.closed || !working.closed {
+		t.Error("Expected every provider to be closed")
+	}
+}
+
+func TestFailoverSynthesizerReportsEveryFailure(t *testing.T) {
+	chain := newFailoverSynthesizer([]namedSynthesizer{
+		{name: "google", provider: &stubProvider{err: errors.New("unavailable")}},
+		{name: "azure", provider: &stubProvider{err: errors.New("bad key")}},
+	}, 0)
+
+	_, err := chain.SynthesizeSpeech(context.Background(), "hello", DefaultAudioFormat())
+	if err == nil || err.Error() != "google: unavailable\nazure: bad key" {
+		t.Errorf("Expected both failures reported, got %v", err)
+	}
+}
@@ -2,38 +2,126 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 
-	speech "cloud.google.com/go/speech/apiv1"
-	"cloud.google.com/go/speech/apiv1/speechpb"
+	speech "cloud.google.com/go/speech/apiv2"
+	"cloud.google.com/go/speech/apiv2/speechpb"
 	"github.com/ghophp/call-me-help/config"
 	"github.com/ghophp/call-me-help/logger"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// SpeechToTextService handles transcription of audio to text
+// SpeechToTextService handles transcription of audio to text with Speech-to-Text V2
 type SpeechToTextService struct {
-	client *speech.Client
-	config *config.Config
-	log    *logger.Logger
+	client     *speech.Client
+	config     *config.Config
+	recognizer string // Full recognizer resource name
+	phraseSets *PhraseSetStore
+	log        *logger.Logger
 }
 
-// NewSpeechToTextService creates a new speech-to-text service
+// NewSpeechToTextService creates a new speech-to-text service, creating the
+// configured recognizer resource if it doesn't exist yet
 func NewSpeechToTextService(ctx context.Context) (*SpeechToTextService, error) {
 	log := logger.Component("SpeechToText")
 	log.Info("Creating new Speech-to-Text service")
 
-	client, err := speech.NewClient(ctx)
+	cfg := config.Load()
+	if cfg.GoogleProjectID == "" {
+		log.Error("GOOGLE_PROJECT_ID environment variable not set")
+		return nil, errors.New("GOOGLE_PROJECT_ID is required for Speech-to-Text V2 recognizers")
+	}
+
+	// Regional recognizers are only reachable through their regional endpoint
+	var opts []option.ClientOption
+	if cfg.STTLocation != "global" {
+		opts = append(opts, option.WithEndpoint(cfg.STTLocation+"-speech.googleapis.com:443"))
+	}
+
+	client, err := speech.NewClient(ctx, opts...)
 	if err != nil {
 		log.Error("Error creating Speech-to-Text client: %v", err)
 		return nil, err
 	}
 	log.Info("Speech-to-Text client created successfully")
 
-	return &SpeechToTextService{
-		client: client,
-		config: config.Load(),
-		log:    log,
-	}, nil
+	s := &SpeechToTextService{
+		client:     client,
+		config:     cfg,
+		recognizer: recognizerName(cfg),
+		log:        log,
+	}
+
+	if err := s.ensureRecognizer(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recognizerName expands STT_RECOGNIZER to a full resource name; "_" is the implicit recognizer
+func recognizerName(cfg *config.Config) string {
+	if strings.HasPrefix(cfg.STTRecognizer, "projects/") {
+		return cfg.STTRecognizer
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/recognizers/%s", cfg.GoogleProjectID, cfg.STTLocation, cfg.STTRecognizer)
+}
+
+// ensureRecognizer creates the recognizer resource with the configured defaults when it is missing
+func (s *SpeechToTextService) ensureRecognizer(ctx context.Context) error {
+	log := CallLogger(ctx, s.log)
+	if strings.HasSuffix(s.recognizer, "/recognizers/_") {
+		log.Info("Using the implicit recognizer in %s", s.config.STTLocation)
+		return nil
+	}
+
+	_, err := s.client.GetRecognizer(ctx, &speechpb.GetRecognizerRequest{Name: s.recognizer})
+	if err == nil {
+		log.Info("Using recognizer %s", s.recognizer)
+		return nil
+	}
+	if status.Code(err) != codes.NotFound {
+		log.Error("Error looking up recognizer %s: %v", s.recognizer, err)
+		return err
+	}
+
+	parent, id, _ := strings.Cut(s.recognizer, "/recognizers/")
+	log.Info("Creating recognizer %s", s.recognizer)
+	op, err := s.client.CreateRecognizer(ctx, &speechpb.CreateRecognizerRequest{
+		Parent:       parent,
+		RecognizerId: id,
+		Recognizer: &speechpb.Recognizer{
+			DefaultRecognitionConfig: s.recognitionConfig(ctx, nil),
+		},
+	})
+	if err == nil {
+		_, err = op.Wait(ctx)
+	}
+	if err != nil {
+		log.Error("Error creating recognizer %s: %v", s.recognizer, err)
+		return err
+	}
+	return nil
+}
+
+// Probe looks the recognizer up, or lists the location's recognizers when the implicit
+// one is used
+func (s *SpeechToTextService) Probe(ctx context.Context) error {
+	if parent, id, _ := strings.Cut(s.recognizer, "/recognizers/"); id == "_" {
+		_, err := s.client.ListRecognizers(ctx, &speechpb.ListRecognizersRequest{Parent: parent, PageSize: 1}).Next()
+		if err == iterator.Done {
+			return nil
+		}
+		return err
+	}
+	_, err := s.client.GetRecognizer(ctx, &speechpb.GetRecognizerRequest{Name: s.recognizer})
+	return err
 }
 
 // Close closes the speech client
@@ -42,47 +130,168 @@ func (s *SpeechToTextService) Close() error {
 	return s.client.Close()
 }
 
-// StreamingRecognize performs streaming speech recognition
-func (s *SpeechToTextService) StreamingRecognize(ctx context.Context) (<-chan string, speechpb.Speech_StreamingRecognizeClient, error) {
-	s.log.Info("Starting streaming recognition")
+// StartStream opens a streaming recognition session that transparently rolls over
+// to a new stream before Google's stream duration limit, implementing SpeechRecognizer
+func (s *SpeechToTextService) StartStream(ctx context.Context, format AudioFormat) (RecognitionStream, error) {
+	log := CallLogger(ctx, s.log)
+	format = format.Normalize()
+	log.Info("Starting streaming recognition (%s, %d Hz)", format.Encoding, format.SampleRate)
 
-	// Create output channel with generous buffer
-	transcriptionChan := make(chan string, 1024)
+	return newGoogleRecognitionStream(format, func() (speechpb.Speech_StreamingRecognizeClient, error) {
+		return s.openStream(ctx, format)
+	}, log)
+}
+
+// TranscribeRecording transcribes a complete 8kHz 16-bit WAV recording
+func (s *SpeechToTextService) TranscribeRecording(ctx context.Context, wav []byte) (string, error) {
+	return s.Recognize(ctx, wav)
+}
 
-	s.log.Debug("Attempting to establish STT stream connection...")
-	stream, err := s.client.StreamingRecognize(ctx)
+// StreamingRecognize performs streaming speech recognition for audio in the given format
+func (s *SpeechToTextService) StreamingRecognize(ctx context.Context, format AudioFormat) (<-chan Transcript, speechpb.Speech_StreamingRecognizeClient, error) {
+	log := CallLogger(ctx, s.log)
+	format = format.Normalize()
+	log.Info("Starting streaming recognition (%s, %d Hz)", format.Encoding, format.SampleRate)
+
+	stream, err := s.openStream(ctx, format)
 	if err != nil {
-		s.log.Error("Failed to create streaming recognition: %v", err)
 		return nil, nil, err
 	}
 
+	// Create output channel with generous buffer
+	transcriptionChan := make(chan Transcript, 1024)
+
+	// Start reading results in a goroutine
+	go s.ListenForResults(stream, transcriptionChan)
+
+	return transcriptionChan, stream, nil
+}
+
+// openStream connects a streaming recognize call and sends the recognition config
+func (s *SpeechToTextService) openStream(ctx context.Context, format AudioFormat) (speechpb.Speech_StreamingRecognizeClient, error) {
+	log := CallLogger(ctx, s.log)
+	log.Debug("Attempting to establish STT stream connection...")
+	stream, err := s.client.StreamingRecognize(outgoingCorrelation(ctx))
+	if err != nil {
+		log.Error("Failed to create streaming recognition: %v", err)
+		return nil, err
+	}
+
 	// Send configuration first
 	err = stream.Send(&speechpb.StreamingRecognizeRequest{
+		Recognizer: s.recognizer,
 		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
-			StreamingConfig: &speechpb.StreamingRecognitionConfig{
-				Config: &speechpb.RecognitionConfig{
-					Encoding:        speechpb.RecognitionConfig_MULAW,
-					SampleRateHertz: 8000,
-					LanguageCode:    "en-US",
-				},
-				InterimResults: true,
-			},
+			StreamingConfig: s.streamingConfig(ctx, format),
 		},
 	})
 
 	if err != nil {
-		s.log.Error("Failed to send config to streaming recognition: %v", err)
-		return nil, nil, err
+		log.Error("Failed to send config to streaming recognition: %v", err)
+		return nil, err
 	}
 
-	// Start reading results in a goroutine
-	go s.ListenForResults(stream, transcriptionChan)
+	return stream, nil
+}
 
-	return transcriptionChan, stream, nil
+// Recognize transcribes a short, complete recording such as a voicemail; the encoding
+// is detected from the container (WAV, FLAC, MP3)
+func (s *SpeechToTextService) Recognize(ctx context.Context, audio []byte) (string, error) {
+	log := CallLogger(ctx, s.log)
+	log.Info("Recognizing %d bytes of recorded audio", len(audio))
+
+	resp, err := s.client.Recognize(ctx, &speechpb.RecognizeRequest{
+		Recognizer:  s.recognizer,
+		Config:      s.recognitionConfig(ctx, nil),
+		AudioSource: &speechpb.RecognizeRequest_Content{Content: audio},
+	})
+	if err != nil {
+		log.Error("Error recognizing recorded audio: %v", err)
+		return "", err
+	}
+
+	var parts []string
+	for _, result := range resp.Results {
+		if len(result.Alternatives) > 0 {
+			parts = append(parts, strings.TrimSpace(result.Alternatives[0].Transcript))
+		}
+	}
+
+	transcript := strings.Join(parts, " ")
+	log.Info("Recognized recording (%d chars)", len(transcript))
+	return transcript, nil
+}
+
+// recognitionConfig builds the model and feature settings, adapted to the call's language
+// and persona; a nil decoding config lets the API detect the encoding from the audio's container
+func (s *SpeechToTextService) recognitionConfig(ctx context.Context, decoding *speechpb.ExplicitDecodingConfig) *speechpb.RecognitionConfig {
+	config := &speechpb.RecognitionConfig{
+		Model:         s.config.STTModel,
+		LanguageCodes: append([]string{s.config.STTLanguageCode}, s.config.STTAlternativeLanguages...),
+		Features: &speechpb.RecognitionFeatures{
+			EnableAutomaticPunctuation: s.config.STTAutomaticPunctuation,
+			EnableWordTimeOffsets:      true,
+			ProfanityFilter:            s.config.STTProfanityFilter,
+		},
+	}
+
+	// Phrase sets are looked up per request so reloads and a switch of language apply to the next stream
+	if sets := s.phraseSets.Lookup(recognitionHints(ctx, s.config.STTLanguageCode)); len(sets) > 0 {
+		adaptation := &speechpb.SpeechAdaptation{}
+		for _, set := range sets {
+			phraseSet := &speechpb.PhraseSet{Boost: set.Boost}
+			for _, phrase := range set.Phrases {
+				phraseSet.Phrases = append(phraseSet.Phrases, &speechpb.PhraseSet_Phrase{Value: phrase})
+			}
+			adaptation.PhraseSets = append(adaptation.PhraseSets, &speechpb.SpeechAdaptation_AdaptationPhraseSet{
+				Value: &speechpb.SpeechAdaptation_AdaptationPhraseSet_InlinePhraseSet{InlinePhraseSet: phraseSet},
+			})
+		}
+		config.Adaptation = adaptation
+	}
+
+	if decoding != nil {
+		config.DecodingConfig = &speechpb.RecognitionConfig_ExplicitDecodingConfig{ExplicitDecodingConfig: decoding}
+	} else {
+		config.DecodingConfig = &speechpb.RecognitionConfig_AutoDecodingConfig{AutoDecodingConfig: &speechpb.AutoDetectDecodingConfig{}}
+	}
+	return config
+}
+
+// streamingConfig builds the recognition config from the negotiated format and the configured overrides
+func (s *SpeechToTextService) streamingConfig(ctx context.Context, format AudioFormat) *speechpb.StreamingRecognitionConfig {
+	encoding := format.STTEncoding()
+	if s.config.STTEncoding != "" {
+		if value, ok := speechpb.ExplicitDecodingConfig_AudioEncoding_value[s.config.STTEncoding]; ok {
+			encoding = speechpb.ExplicitDecodingConfig_AudioEncoding(value)
+		} else {
+			s.log.Warn("Unknown STT_ENCODING %q, using negotiated %s", s.config.STTEncoding, encoding)
+		}
+	}
+
+	sampleRate := format.SampleRate
+	if s.config.STTSampleRate > 0 {
+		sampleRate = s.config.STTSampleRate
+	}
+
+	s.log.Debug("Recognition config: recognizer=%s, language=%s, model=%s, encoding=%s, sampleRate=%d, punctuation=%t",
+		s.recognizer, s.config.STTLanguageCode, s.config.STTModel, encoding, sampleRate, s.config.STTAutomaticPunctuation)
+
+	return &speechpb.StreamingRecognitionConfig{
+		Config: s.recognitionConfig(ctx, &speechpb.ExplicitDecodingConfig{
+			Encoding:          encoding,
+			SampleRateHertz:   int32(sampleRate),
+			AudioChannelCount: int32(format.Channels),
+		}),
+		StreamingFeatures: &speechpb.StreamingRecognitionFeatures{
+			InterimResults: s.config.STTInterimResults,
+			// Speech activity events tell the turn engine when the caller stops talking
+			EnableVoiceActivityEvents: true,
+		},
+	}
 }
 
 // ListenForResults listens for transcription results
-func (s *SpeechToTextService) ListenForResults(stream speechpb.Speech_StreamingRecognizeClient, transcriptionChan chan<- string) {
+func (s *SpeechToTextService) ListenForResults(stream speechpb.Speech_StreamingRecognizeClient, transcriptionChan chan<- Transcript) {
 	s.log.Info("Starting to listen for Speech-to-Text results")
 
 	defer func() {
@@ -90,18 +299,30 @@ func (s *SpeechToTextService) ListenForResults(stream speechpb.Speech_StreamingR
 		close(transcriptionChan)
 	}()
 
+	receiveResults(stream, s.log, func(transcript Transcript) {
+		transcriptionChan <- transcript
+	})
+}
+
+// receiveResults reads results from a stream until it ends, returning nil on a clean end of stream
+func receiveResults(stream speechpb.Speech_StreamingRecognizeClient, log *logger.Logger, emit func(Transcript)) error {
 	for {
 		resp, err := stream.Recv()
 		if err == io.EOF {
-			s.log.Info("Stream closed")
-			return
+			log.Info("Stream closed")
+			return nil
 		}
 		if err != nil {
-			s.log.Error("Error receiving from stream: %v", err)
-			return
+			log.Error("Error receiving from stream: %v", err)
+			return err
+		}
+
+		if resp.SpeechEventType == speechpb.StreamingRecognizeResponse_SPEECH_ACTIVITY_END {
+			log.Debug("Speech activity ended")
+			emit(Transcript{EndOfSpeech: true})
 		}
 
-		s.log.Debug("Received response with %d results", len(resp.Results))
+		log.Debug("Received response with %d results", len(resp.Results))
 		for _, result := range resp.Results {
 			for _, alt := range result.Alternatives {
 				isFinal := result.IsFinal
@@ -111,10 +332,26 @@ func (s *SpeechToTextService) ListenForResults(stream speechpb.Speech_StreamingR
 				}
 
 				transcript := alt.Transcript
-				s.log.Info("Transcription (%s): %s", status, transcript)
+				log.Info("Transcription (%s): %s", status, logger.Transcript(transcript))
+
+				var words []WordTiming
+				for _, word := range alt.Words {
+					words = append(words, WordTiming{
+						Word:  word.Word,
+						Start: word.StartOffset.AsDuration(),
+						End:   word.EndOffset.AsDuration(),
+					})
+				}
 
 				// Send transcript to the channel
-				transcriptionChan <- transcript
+				emit(Transcript{
+					Text:       transcript,
+					IsFinal:    isFinal,
+					Confidence: alt.Confidence,
+					Stability:  result.Stability,
+					Words:      words,
+					Language:   result.LanguageCode,
+				})
 			}
 		}
 	}
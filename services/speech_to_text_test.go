@@ -7,7 +7,7 @@ import (
 	"testing"
 	"time"
 
-	"cloud.google.com/go/speech/apiv1/speechpb"
+	"cloud.google.com/go/speech/apiv2/speechpb"
 	"github.com/ghophp/call-me-help/logger"
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc/metadata"
@@ -45,7 +45,7 @@ func TestSpeechToTextIntegration(t *testing.T) {
 	defer stt.Close()
 
 	// Start streaming recognition
-	transcriptionChan, stream, err := stt.StreamingRecognize(ctx)
+	transcriptionChan, stream, err := stt.StreamingRecognize(ctx, DefaultAudioFormat())
 	if err != nil {
 		t.Fatalf("Failed to start streaming recognition: %v", err)
 	}
@@ -58,8 +58,8 @@ func TestSpeechToTextIntegration(t *testing.T) {
 
 	// Send audio data to the recognition stream
 	err = stream.Send(&speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
-			AudioContent: audioData,
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_Audio{
+			Audio: audioData,
 		},
 	})
 	if err != nil {
@@ -72,8 +72,8 @@ func TestSpeechToTextIntegration(t *testing.T) {
 		if !ok {
 			t.Fatal("Transcription channel closed unexpectedly")
 		}
-		t.Logf("Received transcription: %s", transcript)
-		if transcript == "" {
+		t.Logf("Received transcription: %s", transcript.Text)
+		if transcript.Text == "" {
 			t.Error("Received empty transcription")
 		}
 	case <-time.After(10 * time.Second):
@@ -102,7 +102,7 @@ func TestStreamingRecognizeWithSynthesizedAudio(t *testing.T) {
 	defer stt.Close()
 
 	// Start streaming recognition
-	transcriptionChan, stream, err := stt.StreamingRecognize(ctx)
+	transcriptionChan, stream, err := stt.StreamingRecognize(ctx, DefaultAudioFormat())
 	if err != nil {
 		t.Fatalf("Failed to start streaming recognition: %v", err)
 	}
@@ -119,8 +119,8 @@ func TestStreamingRecognizeWithSynthesizedAudio(t *testing.T) {
 
 	// Send audio data to the recognition stream
 	err = stream.Send(&speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
-			AudioContent: audioData,
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_Audio{
+			Audio: audioData,
 		},
 	})
 	if err != nil {
@@ -140,11 +140,11 @@ func TestStreamingRecognizeWithSynthesizedAudio(t *testing.T) {
 				}
 				return
 			}
-			t.Logf("Received transcription: %s", transcript)
+			t.Logf("Received transcription: %s", transcript.Text)
 			receivedTranscription = true
 
 			// If we have a final result containing "hello", we're good
-			if transcript != "" && (transcript == "hello" || transcript == "hello world") {
+			if transcript.Text == "hello" || transcript.Text == "hello world" {
 				return
 			}
 		case <-timeout:
@@ -179,7 +179,7 @@ func TestSpeechToTextChannelCommunication(t *testing.T) {
 	}
 
 	// Create a channel to receive transcriptions
-	transcriptionChan := make(chan string, 10)
+	transcriptionChan := make(chan Transcript, 10)
 
 	// Create a new speech-to-text service
 	stt := &SpeechToTextService{
@@ -192,8 +192,11 @@ func TestSpeechToTextChannelCommunication(t *testing.T) {
 	// Wait for the result with timeout
 	select {
 	case transcript := <-transcriptionChan:
-		if transcript != "hello world" {
-			t.Errorf("Expected 'hello world', got '%s'", transcript)
+		if transcript.Text != "hello world" {
+			t.Errorf("Expected 'hello world', got '%s'", transcript.Text)
+		}
+		if !transcript.IsFinal {
+			t.Error("Expected transcript to be marked final")
 		}
 	case <-time.After(2 * time.Second):
 		t.Fatal("Timed out waiting for transcription")
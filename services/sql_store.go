@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+	_ "github.com/lib/pq" // The postgres driver, DATABASE_DRIVER's default
+)
+
+// SQLStore keeps session records, callers' profiles and the callback queue in the
+// conversations, caller_profiles and callback_queues tables of a SQL database, migrated
+// by Migrate. Each row's content is the
+// JSON record, encrypted and base64 encoded when a cipher is configured, next to a few
+// plain columns to find rows by.
+type SQLStore struct {
+	db       *sql.DB
+	numbered bool // Whether the driver takes $1 placeholders instead of ?
+	cipher   *ContentCipher
+	log      *logger.Logger
+}
+
+// NewSQLStore creates a store on the database, opened with the named database/sql driver
+func NewSQLStore(db *sql.DB, driver string, cipher *ContentCipher) *SQLStore {
+	log := logger.Component("SQLStore")
+	log.Info("Storing conversations and caller profiles in the %s database", driver)
+	return &SQLStore{
+		db:       db,
+		numbered: driver == "postgres" || driver == "pgx",
+		cipher:   cipher,
+		log:      log,
+	}
+}
+
+// Encrypted reports whether records are encrypted as they are saved
+func (s *SQLStore) Encrypted() bool {
+	return s != nil && s.cipher != nil
+}
+
+// Save writes the conversation's session record, replacing any earlier one of the call
+func (s *SQLStore) Save(conv *Conversation) error {
+	record := NewTranscriptExport(conv)
+	content, err := s.seal(record, conv.ID)
+	if err != nil {
+		return fmt.Errorf("session record of call %s: %w", conv.ID, err)
+	}
+	var callerHash any
+	if record.CallerHash != "" {
+		callerHash = record.CallerHash
+	}
+	if err := s.replace(context.Background(), "conversations", "call_sid", conv.ID,
+		"INSERT INTO conversations (call_sid, caller_hash, started_at, encrypted, content) VALUES (?, ?, ?, ?, ?)",
+		conv.ID, callerHash, record.StartedAt.UTC(), s.cipher != nil, content); err != nil {
+		return fmt.Errorf("session record of call %s: %w", conv.ID, err)
+	}
+	s.log.Info("Stored call %s (encrypted: %v)", conv.ID, s.cipher != nil)
+	return nil
+}
+
+// Load reads the session record of a call; ok is false when it wasn't saved
+func (s *SQLStore) Load(callSID string) (record TranscriptExport, ok bool, err error) {
+	if s == nil {
+		return record, false, nil
+	}
+	ok, err = s.get(context.Background(), "SELECT encrypted, content FROM conversations WHERE call_sid = ?", callSID, &record)
+	if err != nil {
+		return record, false, fmt.Errorf("session record of call %s: %w", callSID, err)
+	}
+	return record, ok, nil
+}
+
+// List reads the session records passing the query's filters; a caller's are found by
+// their indexed hash, the other filters are applied as the records are read. Records
+// that can't be read are logged and skipped.
+func (s *SQLStore) List(query ConversationQuery) ([]TranscriptExport, error) {
+	if s == nil {
+		return nil, nil
+	}
+	statement := "SELECT call_sid, encrypted, content FROM conversations"
+	var args []any
+	if query.CallerHash != "" {
+		statement += " WHERE caller_hash = ?"
+		args = append(args, query.CallerHash)
+	}
+	rows, err := s.db.QueryContext(context.Background(), s.bind(statement), args...)
+	if err != nil {
+		s.log.Error("Error listing session records: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []TranscriptExport
+	for rows.Next() {
+		var callSID, content string
+		var encrypted bool
+		if err := rows.Scan(&callSID, &encrypted, &content); err != nil {
+			return nil, err
+		}
+		var record TranscriptExport
+		if err := s.open(encrypted, content, callSID, &record); err != nil {
+			s.log.Warn("Skipping stored call %s: %v", callSID, err)
+			continue
+		}
+		if query.MatchesRecord(record) {
+			records = append(records, record)
+		}
+	}
+	return records, rows.Err()
+}
+
+// SaveProfile writes the caller's profile, replacing any earlier one
+func (s *SQLStore) SaveProfile(profile *CallerProfile) error {
+	content, err := s.seal(profile.Snapshot(), profile.CallerHash)
+	if err != nil {
+		return fmt.Errorf("profile of caller %s: %w", profile.CallerHash, err)
+	}
+	if err := s.replace(context.Background(), "caller_profiles", "caller_hash", profile.CallerHash,
+		"INSERT INTO caller_profiles (caller_hash, updated_at, encrypted, content) VALUES (?, ?, ?, ?)",
+		profile.CallerHash, time.Now().UTC(), s.cipher != nil, content); err != nil {
+		return fmt.Errorf("profile of caller %s: %w", profile.CallerHash, err)
+	}
+	return nil
+}
+
+// LoadProfile reads a caller's profile; ok is false when it wasn't saved
+func (s *SQLStore) LoadProfile(callerHash string) (*CallerProfile, bool, error) {
+	if s == nil {
+		return nil, false, nil
+	}
+	var snapshot CallerProfileSnapshot
+	ok, err := s.get(context.Background(), "SELECT encrypted, content FROM caller_profiles WHERE caller_hash = ?", callerHash, &snapshot)
+	if err != nil || !ok {
+		if err != nil {
+			err = fmt.Errorf("profile of caller %s: %w", callerHash, err)
+		}
+		return nil, false, err
+	}
+	return RestoreCallerProfile(snapshot), true, nil
+}
+
+// SaveCallbacks writes the callback queue as one row, replacing the one saved before
+func (s *SQLStore) SaveCallbacks(offers []CallbackOffer) error {
+	content, err := s.seal(offers, callbackQueueID)
+	if err != nil {
+		return fmt.Errorf("callback queue: %w", err)
+	}
+	if err := s.replace(context.Background(), "callback_queues", "name", callbackQueueID,
+		"INSERT INTO callback_queues (name, updated_at, encrypted, content) VALUES (?, ?, ?, ?)",
+		callbackQueueID, time.Now().UTC(), s.cipher != nil, content); err != nil {
+		return fmt.Errorf("callback queue: %w", err)
+	}
+	return nil
+}
+
+// LoadCallbacks reads the saved callback queue
+func (s *SQLStore) LoadCallbacks() ([]CallbackOffer, error) {
+	if s == nil {
+		return nil, nil
+	}
+	var offers []CallbackOffer
+	if _, err := s.get(context.Background(), "SELECT encrypted, content FROM callback_queues WHERE name = ?", callbackQueueID, &offers); err != nil {
+		return nil, fmt.Errorf("callback queue: %w", err)
+	}
+	return offers, nil
+}
+
+// bind rewrites the query's ? placeholders to $1, $2... for drivers that number them
+func (s *SQLStore) bind(query string) string {
+	if !s.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// replace deletes the table's row with the key and inserts the new one in a transaction,
+// which every SQL dialect can do, unlike an upsert
+func (s *SQLStore) replace(ctx context.Context, table, keyColumn, key, insert string, args ...any) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.bind("DELETE FROM "+table+" WHERE "+keyColumn+" = ?"), key); err != nil {
+		s.log.Error("Error deleting %s row %s: %v", table, key, err)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.bind(insert), args...); err != nil {
+		s.log.Error("Error inserting %s row %s: %v", table, key, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// seal encodes the value as JSON and, when there is a cipher, encrypts it bound to the
+// row's key, as base64 for the text column
+func (s *SQLStore) seal(v any, key string) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if s.cipher == nil {
+		return string(data), nil
+	}
+	if data, err = s.cipher.Seal(data, []byte(key)); err != nil {
+		return "", fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// open decodes a row's content into v, decrypting it if it was encrypted
+func (s *SQLStore) open(encrypted bool, content, key string, v any) error {
+	data := []byte(content)
+	if encrypted {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(content); err != nil {
+			return fmt.Errorf("row content is corrupt: %w", err)
+		}
+		if data, err = s.cipher.Open(data, []byte(key)); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("row content is corrupt: %w", err)
+	}
+	return nil
+}
+
+// get reads the content of the row the query selects by key into v; ok is false when
+// there is no such row
+func (s *SQLStore) get(ctx context.Context, query, key string, v any) (bool, error) {
+	var encrypted bool
+	var content string
+	err := s.db.QueryRowContext(ctx, s.bind(query), key).Scan(&encrypted, &content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		s.log.Error("Error reading row %s: %v", key, err)
+		return false, err
+	}
+	if err := s.open(encrypted, content, key, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tableDatabase keeps rows by table and by their first column, which is enough for the
+// statements SQLStore runs
+type tableDatabase struct {
+	rows map[string]map[string]map[string]driver.Value
+	mu   sync.Mutex
+}
+
+var (
+	tableDatabases   = map[string]*tableDatabase{}
+	tableDatabasesMu sync.Mutex
+
+	deleteRow = regexp.MustCompile(`^DELETE FROM (\w+) WHERE \w+ = \$1$`)
+	insertRow = regexp.MustCompile(`^INSERT INTO (\w+) \(([^)]+)\) VALUES`)
+	selectRow = regexp.MustCompile(`^SELECT (.+) FROM (\w+)(?: WHERE (\w+) = \$1)?$`)
+)
+
+func init() {
+	sql.Register("tablesql", tableDriver{})
+}
+
+// openTableDatabase opens a database/sql handle on a new table database
+func openTableDatabase(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	tableDatabasesMu.Lock()
+	tableDatabases[name] = &tableDatabase{rows: map[string]map[string]map[string]driver.Value{}}
+	tableDatabasesMu.Unlock()
+	db, err := sql.Open("tablesql", name)
+	if err != nil {
+		t.Fatalf("Expected the table database opened, got %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type tableDriver struct{}
+
+func (tableDriver) Open(name string) (driver.Conn, error) {
+	tableDatabasesMu.Lock()
+	defer tableDatabasesMu.Unlock()
+	return &tableConn{db: tableDatabases[name]}, nil
+}
+
+type tableConn struct{ db *tableDatabase }
+
+func (c *tableConn) Prepare(query string) (driver.Stmt, error) {
+	return &tableStmt{db: c.db, query: query}, nil
+}
+func (c *tableConn) Close() error              { return nil }
+func (c *tableConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *tableConn) Commit() error             { return nil }
+func (c *tableConn) Rollback() error           { return nil }
+
+type tableStmt struct {
+	db    *tableDatabase
+	query string
+}
+
+func (s *tableStmt) Close() error  { return nil }
+func (s *tableStmt) NumInput() int { return -1 }
+
+func (s *tableStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if match := deleteRow.FindStringSubmatch(s.query); match != nil {
+		delete(s.db.rows[match[1]], args[0].(string))
+		return driver.RowsAffected(1), nil
+	}
+	match := insertRow.FindStringSubmatch(s.query)
+	if match == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	row := map[string]driver.Value{}
+	for i, column := range strings.Split(match[2], ", ") {
+		row[column] = args[i]
+	}
+	if s.db.rows[match[1]] == nil {
+		s.db.rows[match[1]] = map[string]map[string]driver.Value{}
+	}
+	s.db.rows[match[1]][args[0].(string)] = row
+	return driver.RowsAffected(1), nil
+}
+
+func (s *tableStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	match := selectRow.FindStringSubmatch(s.query)
+	if match == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	rows := &tableRows{columns: strings.Split(match[1], ", ")}
+	for _, row := range s.db.rows[match[2]] {
+		if match[3] == "" || row[match[3]] == args[0] {
+			rows.rows = append(rows.rows, row)
+		}
+	}
+	return rows, nil
+}
+
+type tableRows struct {
+	columns []string
+	rows    []map[string]driver.Value
+}
+
+func (r *tableRows) Columns() []string { return r.columns }
+func (r *tableRows) Close() error      { return nil }
+func (r *tableRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	for i, column := range r.columns {
+		dest[i] = r.rows[0][column]
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStoreKeepsRecordsAndProfiles(t *testing.T) {
+	db := openTableDatabase(t, "records")
+	store := NewSQLStore(db, "postgres", nil)
+
+	conv := exportConversation()
+	if err := store.Save(conv); err != nil {
+		t.Fatalf("Expected the call stored, got %v", err)
+	}
+	// Saving again replaces the row
+	if err := store.Save(conv); err != nil {
+		t.Fatalf("Expected the call stored again, got %v", err)
+	}
+	record, ok, err := store.Load("CA123")
+	if err != nil || !ok || len(record.Messages) != 2 || record.Persona != "calm" {
+		t.Fatalf("Expected the record read back, got %+v, %v, %v", record, ok, err)
+	}
+	if _, ok, err := store.Load("CA404"); ok || err != nil {
+		t.Errorf("Expected an unknown call not stored, got %v, %v", ok, err)
+	}
+
+	profile := &CallerProfile{CallerHash: "abc123"}
+	if err := store.SaveProfile(profile); err != nil {
+		t.Fatalf("Expected the profile stored, got %v", err)
+	}
+	if loaded, ok, err := store.LoadProfile("abc123"); err != nil || !ok || loaded.CallerHash != "abc123" {
+		t.Errorf("Expected the profile read back, got %+v, %v, %v", loaded, ok, err)
+	}
+
+	if offers, err := store.LoadCallbacks(); err != nil || len(offers) != 0 {
+		t.Errorf("Expected no callback queue saved yet, got %+v, %v", offers, err)
+	}
+	if err := store.SaveCallbacks([]CallbackOffer{{ID: "cb1", CallSID: "CA123"}}); err != nil {
+		t.Fatalf("Expected the callback queue stored, got %v", err)
+	}
+	if offers, err := store.LoadCallbacks(); err != nil || len(offers) != 1 || offers[0].ID != "cb1" {
+		t.Errorf("Expected the callback queue read back, got %+v, %v", offers, err)
+	}
+}
+
+func TestSQLStoreListsRecords(t *testing.T) {
+	checkStoreLists(t, NewSQLStore(openTableDatabase(t, "listed"), "postgres", testCipher(t, 1)))
+}
+
+func TestSQLStoreEncryptsContent(t *testing.T) {
+	db := openTableDatabase(t, "encrypted")
+	store := NewSQLStore(db, "postgres", testCipher(t, 1))
+	if err := store.Save(exportConversation()); err != nil {
+		t.Fatalf("Expected the call stored, got %v", err)
+	}
+
+	content := tableDatabases["encrypted"].rows["conversations"]["CA123"]["content"].(string)
+	if strings.Contains(content, "sleep") {
+		t.Errorf("Expected the content encrypted, got %q", content)
+	}
+	if record, ok, err := store.Load("CA123"); err != nil || !ok || record.Messages[0].Text != "I can't sleep (again)." {
+		t.Errorf("Expected the record decrypted, got %+v, %v, %v", record, ok, err)
+	}
+	if _, _, err := NewSQLStore(db, "postgres", testCipher(t, 2)).Load("CA123"); err == nil {
+		t.Error("Expected another key to fail decrypting the record")
+	}
+}
+
+func TestSQLStoreBindsPlaceholders(t *testing.T) {
+	query := "DELETE FROM t WHERE a = ? AND b = ?"
+	if got := NewSQLStore(nil, "postgres", nil).bind(query); got != "DELETE FROM t WHERE a = $1 AND b = $2" {
+		t.Errorf("Expected numbered placeholders, got %q", got)
+	}
+	if got := NewSQLStore(nil, "mysql", nil).bind(query); got != query {
+		t.Errorf("Expected ? placeholders kept, got %q", got)
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+)
+
+var (
+	// sentenceEnd matches the punctuation and space that end a sentence
+	sentenceEnd = regexp.MustCompile(`[.!?]+["')\]]*\s+`)
+	// sentenceAbbreviation matches words whose trailing period doesn't end a sentence
+	sentenceAbbreviation = regexp.MustCompile(`(?i)\b(?:mr|mrs|ms|dr|st|e\.g|i\.e|vs|etc)\.$`)
+
+	// ssmlNumber matches phone numbers, read as telephone numbers, and short service
+	// numbers like 988 and 911, read digit by digit
+	ssmlNumber = regexp.MustCompile(`(?:\+?1[\s.-]?)?(?:\(\d{3}\)\s?|\b\d{3}[\s.-])\d{3}[\s.-]\d{4}\b|\b(?:[2-9]11|988)\b`)
+)
+
+// SplitSentences splits text into sentences, keeping their punctuation
+func SplitSentences(text string) []string {
+	sentences, rest := splitCompleteSentences(text)
+	if rest = strings.TrimSpace(rest); rest != "" {
+		sentences = append(sentences, rest)
+	}
+	return sentences
+}
+
+// splitCompleteSentences splits off the sentences whose ending punctuation is followed by
+// space, returning the text after them, which may be an unfinished sentence
+func splitCompleteSentences(text string) ([]string, string) {
+	var sentences []string
+	start := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(text, -1) {
+		candidate := strings.TrimSpace(text[start:loc[1]])
+		if sentenceAbbreviation.MatchString(candidate) {
+			continue
+		}
+		if candidate != "" {
+			sentences = append(sentences, candidate)
+		}
+		start = loc[1]
+	}
+	return sentences, text[start:]
+}
+
+// SentenceStream splits text that arrives in pieces, such as a streamed LLM response,
+// into sentences as soon as they are complete
+type SentenceStream struct {
+	rest string
+}
+
+// Write adds a piece of text and returns the sentences it completed
+func (s *SentenceStream) Write(text string) []string {
+	sentences, rest := splitCompleteSentences(s.rest + text)
+	s.rest = rest
+	return sentences
+}
+
+// Flush returns whatever is left once the text has ended
+func (s *SentenceStream) Flush() []string {
+	rest := s.rest
+	s.rest = ""
+	return SplitSentences(rest)
+}
+
+// BuildSSML turns a response into an SSML document for natural-sounding delivery
+func BuildSSML(text string) string {
+	return BuildSSMLWithProsody(text, DefaultProsody)
+}
+
+// BuildSSMLWithProsody turns a response into an SSML document delivered with the prosody
+func BuildSSMLWithProsody(text string, prosody Prosody) string {
+	return "<speak>" + ssmlBody(text, prosody) + "</speak>"
+}
+
+// ssmlBody is the SSML markup of the response without the enclosing <speak> element,
+// for providers that wrap it themselves
+func ssmlBody(text string, prosody Prosody) string {
+	var b strings.Builder
+	if prosody.lead {
+		b.WriteString(prosody.pauseBreak())
+	}
+	b.WriteString(`<prosody ` + prosody.attributes() + `>`)
+	for i, sentence := range SplitSentences(text) {
+		if i > 0 {
+			b.WriteString(prosody.pauseBreak())
+		}
+		writeSSMLNumbers(&b, sentence)
+	}
+	b.WriteString("</prosody>")
+	return b.String()
+}
+
+// writeSSMLNumbers writes the escaped sentence, marking numbers up so they are read
+// the way a person would say them
+func writeSSMLNumbers(b *strings.Builder, sentence string) {
+	last := 0
+	for _, loc := range ssmlNumber.FindAllStringIndex(sentence, -1) {
+		xml.EscapeText(b, []byte(sentence[last:loc[0]]))
+		number := sentence[loc[0]:loc[1]]
+		interpret := "telephone"
+		if len(number) == 3 {
+			interpret = "characters"
+		}
+		b.WriteString(`<say-as interpret-as="` + interpret + `">`)
+		xml.EscapeText(b, []byte(number))
+		b.WriteString("</say-as>")
+		last = loc[1]
+	}
+	xml.EscapeText(b, []byte(sentence[last:]))
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	got := SplitSentences("That sounds hard. Did you talk to Dr. Smith about it? I'm here!  ")
+	want := []string{"That sounds hard.", "Did you talk to Dr. Smith about it?", "I'm here!"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestSentenceStream(t *testing.T) {
+	var stream SentenceStream
+	chunks := []string{"That sounds ", "hard. Did you talk to Dr.", " Smith about it? I'm", " here!"}
+	want := [][]string{nil, {"That sounds hard."}, {"Did you talk to Dr. Smith about it?"}, nil}
+	for i, chunk := range chunks {
+		if got := stream.Write(chunk); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("Write(%q): expected %q, got %q", chunk, want[i], got)
+		}
+	}
+	if got := stream.Flush(); !reflect.DeepEqual(got, []string{"I'm here!"}) {
+		t.Errorf("Expected the last sentence on flush, got %q", got)
+	}
+}
+
+func TestBuildSSML(t *testing.T) {
+	got := BuildSSML("You can call 988 or 1-800-273-8255. You & me <both> matter.")
+	want := `<speak><prosody rate="95%" pitch="-1st">You can call <say-as interpret-as="characters">988</say-as> or ` +
+		`<say-as interpret-as="telephone">1-800-273-8255</say-as>.<break time="350ms"/>` +
+		`You &amp; me &lt;both&gt; matter.</prosody></speak>`
+	if got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
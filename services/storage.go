@@ -0,0 +1,117 @@
+package services
+
+import (
+	"io"
+	"sync"
+)
+
+// Storage backends
+const (
+	StorageDisk      = "disk"
+	StorageFirestore = "firestore"
+	StorageSQL       = "sql"
+)
+
+// ConversationStore holds the conversations of live and recently ended calls, which the
+// janitor deletes once they are past retention
+type ConversationStore interface {
+	// GetConversation returns a held conversation
+	GetConversation(id string) (*Conversation, bool)
+	// PutConversation holds the conversation, replacing any with its ID
+	PutConversation(conv *Conversation)
+	// DeleteConversation drops a conversation
+	DeleteConversation(id string)
+	// Conversations returns every held conversation, in no particular order
+	Conversations() []*Conversation
+}
+
+// AudioStore keeps the audio of calls: synthesized responses and, with the caller's
+// consent, recordings of what they said
+type AudioStore interface {
+	AudioSaver
+	// CreateInboundRecording opens a recording of the call's raw inbound audio
+	CreateInboundRecording(callSID string) (io.WriteCloser, error)
+	// ReadAudioFile reads a saved response or recording by its file name, decrypted
+	ReadAudioFile(filename string) ([]byte, error)
+}
+
+// TranscriptStore keeps the session records of calls once they are evicted from memory
+type TranscriptStore interface {
+	// Save writes the conversation's session record, replacing any earlier one of the call
+	Save(conv *Conversation) error
+	// Load reads the session record of a call; ok is false when it wasn't saved
+	Load(callSID string) (record TranscriptExport, ok bool, err error)
+	// List reads the session records passing the query's filters, in no particular
+	// order; the query's Offset and Limit are left to the caller, who pages them together
+	// with the calls still in memory
+	List(query ConversationQuery) ([]TranscriptExport, error)
+}
+
+// ProfileStore keeps callers' profiles across restarts; without one, profiles are only
+// held in memory
+type ProfileStore interface {
+	// SaveProfile writes the profile, replacing any earlier one of the caller
+	SaveProfile(profile *CallerProfile) error
+	// LoadProfile reads the profile of a caller; ok is false when it wasn't saved
+	LoadProfile(callerHash string) (profile *CallerProfile, ok bool, err error)
+}
+
+// CallbackStore keeps the queue of callback offers across restarts; without one, the
+// queue is only held in memory
+type CallbackStore interface {
+	// SaveCallbacks writes the queue, replacing the one saved before
+	SaveCallbacks(offers []CallbackOffer) error
+	// LoadCallbacks reads the saved queue, oldest first; it is empty when none was saved
+	LoadCallbacks() ([]CallbackOffer, error)
+}
+
+// callbackQueueID names the saved callback queue, which encrypted queues are bound to
+const callbackQueueID = "callbacks"
+
+// MemoryConversationStore holds conversations in memory, the default conversation store
+type MemoryConversationStore struct {
+	conversations map[string]*Conversation
+	mu            sync.Mutex
+}
+
+// NewMemoryConversationStore creates an empty in-memory store
+func NewMemoryConversationStore() *MemoryConversationStore {
+	return &MemoryConversationStore{conversations: make(map[string]*Conversation)}
+}
+
+// GetConversation returns a held conversation
+func (s *MemoryConversationStore) GetConversation(id string) (*Conversation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[id]
+	return conv, ok
+}
+
+// PutConversation holds the conversation, replacing any with its ID
+func (s *MemoryConversationStore) PutConversation(conv *Conversation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conversations[conv.ID] = conv
+}
+
+// DeleteConversation drops a conversation
+func (s *MemoryConversationStore) DeleteConversation(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conversations, id)
+}
+
+// Conversations returns every held conversation
+func (s *MemoryConversationStore) Conversations() []*Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convs := make([]*Conversation, 0, len(s.conversations))
+	for _, conv := range s.conversations {
+		convs = append(convs, conv)
+	}
+	return convs
+}
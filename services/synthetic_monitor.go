@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// SyntheticCallSID is the call the synthetic turns are made on, in logs and provider requests
+const SyntheticCallSID = "synthetic"
+
+// Stages of a synthetic turn
+const (
+	StageAudio       = "audio" // Preparing the canned audio
+	StageRecognition = "recognition"
+	StageGeneration  = "generation"
+	StageSynthesis   = "synthesis"
+)
+
+// syntheticTrailingSilence follows the canned audio so recognizers detect the end of speech
+const syntheticTrailingSilence = time.Second
+
+// SyntheticLatencies are how long the stages of a synthetic turn took, in milliseconds
+type SyntheticLatencies struct {
+	RecognitionMs float64 `json:"recognitionMs"`
+	GenerationMs  float64 `json:"generationMs"`
+	SynthesisMs   float64 `json:"synthesisMs"`
+	TotalMs       float64 `json:"totalMs"`
+}
+
+// SyntheticResult is the outcome of a synthetic turn
+type SyntheticResult struct {
+	Healthy     bool               `json:"healthy"`
+	FailedStage string             `json:"failedStage,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	RanAt       string             `json:"ranAt"`
+	Transcript  string             `json:"transcript,omitempty"` // What the canned audio was recognized as
+	Latencies   SyntheticLatencies `json:"latencies"`
+}
+
+// SyntheticStats are the synthetic turns run so far
+type SyntheticStats struct {
+	Runs                int              `json:"runs"`
+	Failures            int              `json:"failures"`
+	ConsecutiveFailures int              `json:"consecutiveFailures"`
+	Last                *SyntheticResult `json:"last,omitempty"`
+}
+
+// SyntheticMonitor runs a synthetic turn through the call pipeline on a schedule: canned
+// audio of a caller's phrase is recognized, answered by the LLM and the answer synthesized,
+// so a provider outage shows on /health before a caller runs into it
+type SyntheticMonitor struct {
+	stt       SpeechRecognizer
+	llm       ResponseGenerator
+	tts       SpeechSynthesizer
+	phrase    string
+	audioFile string // Raw canned audio in the call format, synthesized from the phrase when missing
+	format    AudioFormat
+	interval  time.Duration
+	timeout   time.Duration
+
+	mu    sync.Mutex
+	audio []byte // The canned audio, once loaded or synthesized
+	stats SyntheticStats
+	log   *logger.Logger
+}
+
+// NewSyntheticMonitor creates a monitor running a turn with the phrase every interval, each
+// given timeout; it returns nil, which runs nothing, when interval is 0
+func NewSyntheticMonitor(stt SpeechRecognizer, llm ResponseGenerator, tts SpeechSynthesizer, phrase, audioFile string, interval, timeout time.Duration) *SyntheticMonitor {
+	if interval <= 0 {
+		return nil
+	}
+	return &SyntheticMonitor{
+		stt:       stt,
+		llm:       llm,
+		tts:       tts,
+		phrase:    phrase,
+		audioFile: audioFile,
+		format:    DefaultAudioFormat(),
+		interval:  interval,
+		timeout:   timeout,
+		log:       logger.Component("SyntheticMonitor"),
+	}
+}
+
+// Run runs a synthetic turn every interval, the first right away, until the context is cancelled
+func (m *SyntheticMonitor) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.log.Info("Running a synthetic turn every %v", m.interval)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs a synthetic turn and records its result
+func (m *SyntheticMonitor) RunOnce(ctx context.Context) SyntheticResult {
+	ctx = WithCorrelationID(WithCallSID(ctx, SyntheticCallSID), NewCorrelationID())
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	start := time.Now()
+	result := SyntheticResult{RanAt: start.UTC().Format(time.RFC3339)}
+	stage, err := m.turn(ctx, &result)
+	result.Latencies.TotalMs = msSince(start)
+	result.Healthy = err == nil
+
+	log := CallLogger(ctx, m.log)
+	if err != nil {
+		result.FailedStage = stage
+		result.Error = err.Error()
+		log.Error("Synthetic turn failed at %s: %v", stage, err)
+	} else {
+		log.Info("Synthetic turn took %.0fms (recognition %.0fms, generation %.0fms, synthesis %.0fms)",
+			result.Latencies.TotalMs, result.Latencies.RecognitionMs, result.Latencies.GenerationMs, result.Latencies.SynthesisMs)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.Runs++
+	if err != nil {
+		m.stats.Failures++
+		m.stats.ConsecutiveFailures++
+	} else {
+		m.stats.ConsecutiveFailures = 0
+	}
+	m.stats.Last = &result
+	return result
+}
+
+// turn runs the stages, returning the one that failed
+func (m *SyntheticMonitor) turn(ctx context.Context, result *SyntheticResult) (string, error) {
+	audio, err := m.cannedAudio(ctx)
+	if err != nil {
+		return StageAudio, err
+	}
+
+	start := time.Now()
+	transcript, err := m.recognize(ctx, audio)
+	result.Latencies.RecognitionMs = msSince(start)
+	if err != nil {
+		return StageRecognition, err
+	}
+	result.Transcript = transcript
+
+	start = time.Now()
+	response, err := m.llm.GenerateResponse(ctx, transcript, nil)
+	result.Latencies.GenerationMs = msSince(start)
+	if err == nil && strings.TrimSpace(response) == "" {
+		err = errors.New("the response was empty")
+	}
+	if err != nil {
+		return StageGeneration, err
+	}
+
+	start = time.Now()
+	speech, err := m.tts.SynthesizeSpeech(ctx, response, m.format)
+	result.Latencies.SynthesisMs = msSince(start)
+	if err == nil && len(speech) == 0 {
+		err = errors.New("no audio was synthesized")
+	}
+	if err != nil {
+		return StageSynthesis, err
+	}
+	return "", nil
+}
+
+// cannedAudio returns the phrase's audio: from the audio file, or synthesized once and
+// saved to it
+func (m *SyntheticMonitor) cannedAudio(ctx context.Context) ([]byte, error) {
+	m.mu.Lock()
+	audio := m.audio
+	m.mu.Unlock()
+	if audio != nil {
+		return audio, nil
+	}
+
+	if m.audioFile != "" {
+		if audio, err := os.ReadFile(m.audioFile); err == nil && len(audio) > 0 {
+			m.keep(audio)
+			return audio, nil
+		}
+	}
+	audio, err := m.tts.SynthesizeSpeech(ctx, m.phrase, m.format)
+	if err != nil {
+		return nil, fmt.Errorf("synthesizing the canned phrase: %w", err)
+	}
+	if m.audioFile != "" {
+		if err := os.WriteFile(m.audioFile, audio, 0o644); err != nil {
+			m.log.Warn("Failed to save the canned audio to %s: %v", m.audioFile, err)
+		}
+	}
+	m.keep(audio)
+	return audio, nil
+}
+
+// keep keeps the canned audio for the next turns
+func (m *SyntheticMonitor) keep(audio []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audio = audio
+}
+
+// recognize streams the audio, followed by silence, as a call does and returns the final
+// transcript
+func (m *SyntheticMonitor) recognize(ctx context.Context, audio []byte) (string, error) {
+	stream, err := m.stt.StartStream(ctx, m.format)
+	if err != nil {
+		return "", err
+	}
+	silence := EncodeSamples(make([]int16, int(syntheticTrailingSilence.Seconds()*float64(m.format.SampleRate))), m.format)
+	audio = append(append([]byte{}, audio...), silence...)
+	chunk := m.format.BytesPerSecond() / 50 // 20ms, as Twilio sends it
+	for len(audio) > 0 {
+		n := min(chunk, len(audio))
+		if err := stream.SendAudio(audio[:n]); err != nil {
+			stream.Close()
+			return "", err
+		}
+		audio = audio[n:]
+	}
+	if err := stream.Close(); err != nil {
+		return "", err
+	}
+
+	var finals []string
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case transcript, ok := <-stream.Transcripts():
+			if !ok {
+				text := strings.TrimSpace(strings.Join(finals, " "))
+				if text == "" {
+					return "", errors.New("nothing was recognized")
+				}
+				return text, nil
+			}
+			if transcript.IsFinal && transcript.Text != "" {
+				finals = append(finals, transcript.Text)
+			}
+		}
+	}
+}
+
+// Stats returns the synthetic turns run so far; nil when the monitor is off
+func (m *SyntheticMonitor) Stats() *SyntheticStats {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	return &stats
+}
+
+// msSince is the time since start in milliseconds
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedRecognizer recognizes every stream as the text
+type scriptedRecognizer struct {
+	text    string
+	streams []*recordingStream
+}
+
+func (s *scriptedRecognizer) StartStream(ctx context.Context, format AudioFormat) (RecognitionStream, error) {
+	stream := &recordingStream{transcripts: make(chan Transcript, 1)}
+	if s.text != "" {
+		stream.transcripts <- Transcript{Text: s.text, IsFinal: true}
+	}
+	close(stream.transcripts)
+	s.streams = append(s.streams, stream)
+	return stream, nil
+}
+
+func (s *scriptedRecognizer) TranscribeRecording(ctx context.Context, wav []byte) (string, error) {
+	return s.text, nil
+}
+
+func (s *scriptedRecognizer) Close() error { return nil }
+
+func TestSyntheticTurnRunsThroughEveryStage(t *testing.T) {
+	audioFile := filepath.Join(t.TempDir(), "synthetic.ulaw")
+	stt, tts := &scriptedRecognizer{text: "I feel stressed"}, &stubProvider{}
+	monitor := NewSyntheticMonitor(stt, slowGenerator{}, tts, "I feel stressed", audioFile, time.Minute, time.Second)
+
+	result := monitor.RunOnce(context.Background())
+	if !result.Healthy || result.Transcript != "I feel stressed" || result.Latencies.TotalMs <= 0 {
+		t.Fatalf("Expected a healthy turn, got %+v", result)
+	}
+	// The phrase is synthesized once for the canned audio, then the response
+	if tts.calls != 2 {
+		t.Errorf("Expected the phrase and the response synthesized, got %d syntheses", tts.calls)
+	}
+	if audio, _ := os.ReadFile(audioFile); string(audio) != "I feel stressed" {
+		t.Errorf("Expected the canned audio saved, got %q", audio)
+	}
+	sent := stt.streams[0].sent()
+	if len(sent) != 51 || !strings.HasPrefix(string(sent[0]), "I feel stressed") || len(sent[0]) != 160 {
+		t.Errorf("Expected the canned audio streamed in 20ms chunks followed by a second of silence, got %d chunks", len(sent))
+	}
+
+	monitor.RunOnce(context.Background())
+	if tts.calls != 3 {
+		t.Errorf("Expected the canned audio reused, got %d syntheses", tts.calls)
+	}
+}
+
+func TestSyntheticTurnReportsTheFailingStage(t *testing.T) {
+	tts := &stubProvider{}
+	monitor := NewSyntheticMonitor(&scriptedRecognizer{}, slowGenerator{}, tts, "Hello", "", time.Minute, time.Second)
+
+	result := monitor.RunOnce(context.Background())
+	if result.Healthy || result.FailedStage != StageRecognition {
+		t.Errorf("Expected recognition failing when nothing is recognized, got %+v", result)
+	}
+
+	monitor.RunOnce(context.Background())
+	stats := monitor.Stats()
+	if stats.Runs != 2 || stats.ConsecutiveFailures != 2 || stats.Last.FailedStage != StageRecognition {
+		t.Errorf("Expected two failed runs, got %+v", stats)
+	}
+
+	if NewSyntheticMonitor(nil, nil, nil, "", "", 0, 0).Stats() != nil {
+		t.Error("Expected no stats when synthetic turns are off")
+	}
+}
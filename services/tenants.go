@@ -0,0 +1,193 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// storagePrefixPattern is what tenants' storage prefixes may contain, as they become part
+// of collection names and directories
+var storagePrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// Tenant is an organization served from this process, with its own Twilio account,
+// numbers, default persona and storage
+type Tenant struct {
+	Name string `json:"name"`
+	// TwilioAccountSID and the auth token of the tenant's Twilio account, which calls to its
+	// numbers come through; unset uses the configured account. The token can be read from
+	// an environment variable instead, which may hold a Secret Manager reference.
+	TwilioAccountSID    string `json:"twilioAccountSid,omitempty"`
+	TwilioAuthToken     string `json:"twilioAuthToken,omitempty"`
+	TwilioAuthTokenEnv  string `json:"twilioAuthTokenEnv,omitempty"`
+	TwilioPhoneNumber   string `json:"twilioPhoneNumber,omitempty"` // Texts are sent from it
+	TransferPhoneNumber string `json:"transferPhoneNumber,omitempty"`
+	// Numbers are the dialed numbers of the tenant, in E.164
+	Numbers []string `json:"numbers,omitempty"`
+	// Persona answers the tenant's calls, and with it its prompt template, unless the
+	// dialed number or the caller picks another; empty for the default
+	Persona string `json:"persona,omitempty"`
+	// StoragePrefix keeps the tenant's records apart: it prefixes Firestore collections and
+	// names a subdirectory of the archive
+	StoragePrefix string `json:"storagePrefix,omitempty"`
+}
+
+// TenantRegistry holds the tenants served by the process, and the Twilio accounts of
+// those with their own. A nil registry serves every call as the single configured
+// organization.
+type TenantRegistry struct {
+	tenants []Tenant
+	twilio  map[string]*TwilioService // By tenant name
+}
+
+// LoadTenantRegistry loads a JSON array of Tenant from the file; an empty path gives a nil
+// registry
+func LoadTenantRegistry(path string) (*TenantRegistry, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTenantRegistry(data, path)
+}
+
+// NewTenantRegistry parses a JSON array of Tenant read from source, e.g. the tenants
+// section of the config file
+func NewTenantRegistry(data []byte, source string) (*TenantRegistry, error) {
+	log := logger.Component("Tenants")
+	var tenants []Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("parsing tenants %s: %w", source, err)
+	}
+
+	registry := &TenantRegistry{tenants: tenants, twilio: make(map[string]*TwilioService)}
+	names := make(map[string]bool, len(tenants))
+	numbers := make(map[string]string)
+	accounts := make(map[string]string)
+	for i := range tenants {
+		tenant := &tenants[i]
+		tenant.Name = strings.ToLower(strings.TrimSpace(tenant.Name))
+		if tenant.Name == "" {
+			return nil, fmt.Errorf("tenant %d in %s needs a name", i, source)
+		}
+		if names[tenant.Name] {
+			return nil, fmt.Errorf("tenant %q is defined twice in %s", tenant.Name, source)
+		}
+		names[tenant.Name] = true
+		if !storagePrefixPattern.MatchString(tenant.StoragePrefix) {
+			return nil, fmt.Errorf("tenant %q: storage prefix %q may only hold letters, digits, - and _", tenant.Name, tenant.StoragePrefix)
+		}
+		for _, number := range tenant.Numbers {
+			if other, ok := numbers[number]; ok {
+				return nil, fmt.Errorf("number %s is assigned to tenants %q and %q", number, other, tenant.Name)
+			}
+			numbers[number] = tenant.Name
+		}
+
+		if tenant.TwilioAuthTokenEnv != "" {
+			tenant.TwilioAuthToken = os.Getenv(tenant.TwilioAuthTokenEnv)
+		}
+		if (tenant.TwilioAccountSID == "") != (tenant.TwilioAuthToken == "") {
+			return nil, fmt.Errorf("tenant %q needs both a Twilio account SID and auth token, or neither", tenant.Name)
+		}
+		if tenant.TwilioAccountSID != "" {
+			if other, ok := accounts[tenant.TwilioAccountSID]; ok {
+				return nil, fmt.Errorf("Twilio account %s is assigned to tenants %q and %q", maskString(tenant.TwilioAccountSID), other, tenant.Name)
+			}
+			accounts[tenant.TwilioAccountSID] = tenant.Name
+			registry.twilio[tenant.Name] = newTenantTwilioService(*tenant)
+		}
+	}
+
+	log.Info("Loaded %d tenants from %s", len(tenants), source)
+	return registry, nil
+}
+
+// newTenantTwilioService creates a Twilio service on the tenant's account
+func newTenantTwilioService(tenant Tenant) *TwilioService {
+	cfg := *config.Load()
+	cfg.TwilioAccountSID, cfg.TwilioAuthToken = tenant.TwilioAccountSID, tenant.TwilioAuthToken
+	if tenant.TwilioPhoneNumber != "" {
+		cfg.TwilioPhoneNumber = tenant.TwilioPhoneNumber
+	}
+	return &TwilioService{
+		client: newTwilioClient(&cfg),
+		config: &cfg,
+		log:    logger.Component("TwilioService:" + tenant.Name),
+	}
+}
+
+// All returns the tenants in the order they were configured
+func (r *TenantRegistry) All() []Tenant {
+	if r == nil {
+		return nil
+	}
+	return r.tenants
+}
+
+// Lookup finds a tenant by name, ignoring case
+func (r *TenantRegistry) Lookup(name string) (Tenant, bool) {
+	for _, tenant := range r.All() {
+		if strings.EqualFold(tenant.Name, strings.TrimSpace(name)) {
+			return tenant, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// ForCall returns the tenant a call belongs to: the one owning the dialed number, or
+// else the one whose Twilio account the call came through
+func (r *TenantRegistry) ForCall(to, accountSID string) (Tenant, bool) {
+	for _, tenant := range r.All() {
+		for _, number := range tenant.Numbers {
+			if number == to {
+				return tenant, true
+			}
+		}
+	}
+	for _, tenant := range r.All() {
+		if accountSID != "" && tenant.TwilioAccountSID == accountSID {
+			return tenant, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// Twilio returns the Twilio service on the tenant's account, or fallback when the tenant
+// uses the configured account or is unknown
+func (r *TenantRegistry) Twilio(name string, fallback *TwilioService) *TwilioService {
+	if r == nil {
+		return fallback
+	}
+	if twilio, ok := r.twilio[name]; ok {
+		return twilio
+	}
+	return fallback
+}
+
+// PrefixedStore is a TranscriptStore that can keep records apart under a prefix
+type PrefixedStore interface {
+	TranscriptStore
+	WithPrefix(prefix string) TranscriptStore
+}
+
+// Store returns where the tenant's records are kept: the base store under the tenant's
+// storage prefix, or the base store itself when it has none
+func (r *TenantRegistry) Store(base TranscriptStore, name string) TranscriptStore {
+	tenant, ok := r.Lookup(name)
+	if !ok || tenant.StoragePrefix == "" {
+		return base
+	}
+	if prefixed, ok := base.(PrefixedStore); ok {
+		return prefixed.WithPrefix(tenant.StoragePrefix)
+	}
+	return base
+}
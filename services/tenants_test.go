@@ -0,0 +1,79 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTenantRegistry(t *testing.T) {
+	t.Setenv("ACME_TWILIO_TOKEN", "acme-token")
+	registry, err := NewTenantRegistry([]byte(`[
+		{"name": "Acme", "twilioAccountSid": "ACacme", "twilioAuthTokenEnv": "ACME_TWILIO_TOKEN",
+		 "numbers": ["+15550001"], "persona": "calm", "storagePrefix": "acme_"},
+		{"name": "beta", "numbers": ["+15550002"]}
+	]`), "test")
+	if err != nil {
+		t.Fatalf("Failed to load tenants: %v", err)
+	}
+
+	if tenant, ok := registry.ForCall("+15550002", "ACacme"); !ok || tenant.Name != "beta" {
+		t.Errorf("Expected the dialed number to pick the tenant first, got %+v", tenant)
+	}
+	if tenant, ok := registry.ForCall("+15559999", "ACacme"); !ok || tenant.Name != "acme" || tenant.TwilioAuthToken != "acme-token" {
+		t.Errorf("Expected the account to pick acme with its token from the environment, got %+v", tenant)
+	}
+	if _, ok := registry.ForCall("+15559999", "ACother"); ok {
+		t.Error("Expected calls to other numbers and accounts to belong to no tenant")
+	}
+
+	fallback := &TwilioService{}
+	if twilio := registry.Twilio("acme", fallback); twilio == fallback || twilio.config.TwilioAccountSID != "ACacme" {
+		t.Error("Expected acme's calls controlled through its own account")
+	}
+	if registry.Twilio("beta", fallback) != fallback || (*TenantRegistry)(nil).Twilio("acme", fallback) != fallback {
+		t.Error("Expected tenants without an account to use the configured one")
+	}
+
+	for name, content := range map[string]string{
+		"missing name":      `[{"numbers": ["+1"]}]`,
+		"duplicate name":    `[{"name": "a"}, {"name": "A"}]`,
+		"shared number":     `[{"name": "a", "numbers": ["+1"]}, {"name": "b", "numbers": ["+1"]}]`,
+		"sid without token": `[{"name": "a", "twilioAccountSid": "AC1"}]`,
+		"shared account":    `[{"name": "a", "twilioAccountSid": "AC1", "twilioAuthToken": "x"}, {"name": "b", "twilioAccountSid": "AC1", "twilioAuthToken": "y"}]`,
+		"unsafe prefix":     `[{"name": "a", "storagePrefix": "../b"}]`,
+	} {
+		if _, err := NewTenantRegistry([]byte(content), "test"); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestTenantRecordsAreKeptApart(t *testing.T) {
+	dir := t.TempDir()
+	archive := NewTranscriptArchive(dir, nil)
+	registry, err := NewTenantRegistry([]byte(`[{"name": "acme", "storagePrefix": "acme"}, {"name": "beta"}]`), "test")
+	if err != nil {
+		t.Fatalf("Failed to load tenants: %v", err)
+	}
+
+	conv := exportConversation()
+	conv.SetTenant("acme")
+	if err := registry.Store(archive, conv.CurrentTenant()).Save(conv); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "acme", "CA123.json")); err != nil {
+		t.Errorf("Expected the record under the tenant's prefix: %v", err)
+	}
+
+	record, ok, err := registry.Store(archive, "acme").Load("CA123")
+	if err != nil || !ok || record.Tenant != "acme" {
+		t.Errorf("Expected the tenant's record read back, got %+v, %v, %v", record, ok, err)
+	}
+	if _, ok, _ := registry.Store(archive, "beta").Load("CA123"); ok {
+		t.Error("Expected another tenant not to see the record")
+	}
+	if _, ok, _ := archive.Load("CA123"); ok {
+		t.Error("Expected the record outside the shared archive")
+	}
+}
@@ -2,9 +2,6 @@ package services
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"regexp"
 	"strings"
 	"time"
 
@@ -18,9 +15,13 @@ import (
 type TextToSpeechService struct {
 	client *texttospeech.Client
 	config *config.Config
+	voice  *texttospeechpb.VoiceSelectionParams
 	log    *logger.Logger
 }
 
+// defaultGoogleVoice is used when neither a voice nor a language is configured
+const defaultGoogleVoice = "en-US-Standard-I"
+
 // NewTextToSpeechService creates a new text-to-speech service
 func NewTextToSpeechService(ctx context.Context) (*TextToSpeechService, error) {
 	log := logger.Component("TextToSpeech")
@@ -33,45 +34,113 @@ func NewTextToSpeechService(ctx context.Context) (*TextToSpeechService, error) {
 	}
 	log.Info("Text-to-Speech client created successfully")
 
+	cfg := config.Load()
+	voice := googleVoice(cfg)
+	log.Info("Using voice %q (language %s, gender %s), effects profiles %v",
+		voice.Name, voice.LanguageCode, voice.SsmlGender, cfg.TTSEffectsProfiles)
+
 	return &TextToSpeechService{
 		client: client,
-		config: config.Load(),
+		config: cfg,
+		voice:  voice,
 		log:    log,
 	}, nil
 }
 
+// googleVoice selects the configured voice. Without a voice or language the original
+// en-US voice is kept; in translation mode the caller's language is spoken.
+func googleVoice(cfg *config.Config) *texttospeechpb.VoiceSelectionParams {
+	name, language := cfg.TTSVoice, cfg.TTSLanguageCode
+	if language == "" {
+		switch {
+		case name != "":
+			language = voiceLocale(name)
+		case cfg.TranslationEnabled:
+			language = cfg.STTLanguageCode
+		default:
+			name, language = defaultGoogleVoice, "en-US"
+		}
+	}
+
+	gender, ok := texttospeechpb.SsmlVoiceGender_value[strings.ToUpper(cfg.TTSGender)]
+	if !ok {
+		logger.Component("TextToSpeech").Warn("Unknown TTS_GENDER %q, using NEUTRAL", cfg.TTSGender)
+		gender = int32(texttospeechpb.SsmlVoiceGender_NEUTRAL)
+	}
+
+	return &texttospeechpb.VoiceSelectionParams{
+		LanguageCode: language,
+		Name:         name,
+		SsmlGender:   texttospeechpb.SsmlVoiceGender(gender),
+	}
+}
+
+// voiceFor returns the call's chosen voice, when it has one, then the default voice of
+// the caller's language when they speak another one, and otherwise the configured voice
+func (t *TextToSpeechService) voiceFor(ctx context.Context) *texttospeechpb.VoiceSelectionParams {
+	name := VoiceFromContext(ctx)
+	if name == "" {
+		if language := LanguageFromContext(ctx); language != "" && !SameLanguage(language, t.voice.LanguageCode) {
+			return &texttospeechpb.VoiceSelectionParams{LanguageCode: language, SsmlGender: t.voice.SsmlGender}
+		}
+		return t.voice
+	}
+	language := voiceLocale(name)
+	if language == "" {
+		language = t.voice.LanguageCode
+	}
+	return &texttospeechpb.VoiceSelectionParams{LanguageCode: language, Name: name, SsmlGender: t.voice.SsmlGender}
+}
+
+// voiceLocale returns the locale a voice name starts with, e.g. "es-ES" for
+// es-ES-Wavenet-B or es-ES-ElviraNeural, or "" when the name has none
+func voiceLocale(name string) string {
+	if parts := strings.SplitN(name, "-", 3); len(parts) == 3 {
+		return parts[0] + "-" + parts[1]
+	}
+	return ""
+}
+
 // Close closes the TTS client
 func (t *TextToSpeechService) Close() error {
 	t.log.Info("Closing Text-to-Speech client")
 	return t.client.Close()
 }
 
-// SynthesizeSpeech converts text to audio
-func (t *TextToSpeechService) SynthesizeSpeech(ctx context.Context, text string) ([]byte, error) {
+// Probe lists the voices of the configured language
+func (t *TextToSpeechService) Probe(ctx context.Context) error {
+	_, err := t.client.ListVoices(ctx, &texttospeechpb.ListVoicesRequest{LanguageCode: t.voice.LanguageCode})
+	return err
+}
+
+// SynthesizeSpeech converts text to audio in the given output format
+func (t *TextToSpeechService) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	log := CallLogger(ctx, t.log)
 	startTime := time.Now()
-	t.log.Info("Synthesizing speech for text (%d chars): %q", len(text), text)
+	format = format.Normalize()
+	log.Info("Synthesizing speech for text (%d chars): %q", len(text), logger.Transcript(text))
+
+	input := &texttospeechpb.SynthesisInput{
+		InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
+	}
+	if t.config.TTSSSML {
+		input.InputSource = &texttospeechpb.SynthesisInput_Ssml{Ssml: BuildSSMLWithProsody(text, ProsodyFromContext(ctx))}
+	}
 
 	req := texttospeechpb.SynthesizeSpeechRequest{
-		Input: &texttospeechpb.SynthesisInput{
-			InputSource: &texttospeechpb.SynthesisInput_Text{
-				Text: text,
-			},
-		},
-		Voice: &texttospeechpb.VoiceSelectionParams{
-			LanguageCode: "en-US",
-			SsmlGender:   texttospeechpb.SsmlVoiceGender_NEUTRAL,
-			Name:         "en-US-Standard-I", // Using a specific voice for consistency
-		},
+		Input: input,
+		Voice: t.voiceFor(ctx),
 		AudioConfig: &texttospeechpb.AudioConfig{
-			AudioEncoding:   texttospeechpb.AudioEncoding_MULAW,
-			SampleRateHertz: 8000, // 8kHz for telephony (Twilio requirement)
-			EffectsProfileId: []string{
-				"telephony-class-application", // Optimize for telephony
-			},
+			// The encoding and rate always match the call's media stream so Twilio can play it
+			AudioEncoding:    format.TTSEncoding(),
+			SampleRateHertz:  int32(format.SampleRate),
+			EffectsProfileId: t.config.TTSEffectsProfiles,
+			SpeakingRate:     speakingRate(ctx, t.config.TTSSpeakingRate),
+			Pitch:            t.config.TTSPitch,
 		},
 	}
 
-	t.log.Debug("Configured TTS request: language=%s, gender=%s, encoding=%s, sampleRate=%d, voice=%s",
+	log.Debug("Configured TTS request: language=%s, gender=%s, encoding=%s, sampleRate=%d, voice=%s",
 		req.Voice.LanguageCode,
 		req.Voice.SsmlGender,
 		req.AudioConfig.AudioEncoding,
@@ -82,63 +151,22 @@ func (t *TextToSpeechService) SynthesizeSpeech(ctx context.Context, text string)
 	ttsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	t.log.Debug("Calling Text-to-Speech API...")
-	resp, err := t.client.SynthesizeSpeech(ttsCtx, &req)
+	log.Debug("Calling Text-to-Speech API...")
+	resp, err := t.client.SynthesizeSpeech(outgoingCorrelation(ttsCtx), &req)
 	callDuration := time.Since(startTime)
 
 	if err != nil {
-		t.log.Error("Text-to-Speech API error after %v: %v", callDuration, err)
+		log.Error("Text-to-Speech API error after %v: %v", callDuration, err)
 		return nil, err
 	}
 
-	t.log.Debug("Text-to-Speech API call completed in %v", callDuration)
+	log.Debug("Text-to-Speech API call completed in %v", callDuration)
 
 	if resp == nil || resp.AudioContent == nil || len(resp.AudioContent) == 0 {
-		t.log.Warn("Text-to-Speech returned empty audio content")
+		log.Warn("Text-to-Speech returned empty audio content")
 		return []byte{}, nil
 	}
 
-	t.log.Info("Successfully synthesized %d bytes of audio", len(resp.AudioContent))
+	log.Info("Successfully synthesized %d bytes of audio", len(resp.AudioContent))
 	return resp.AudioContent, nil
 }
-
-// SaveAudioToFile saves audio content to a file
-func (t *TextToSpeechService) SaveAudioToFile(callSID string, text string, audioData []byte) error {
-	// Use the configured output directory
-	outputDir := t.config.AudioOutputDirectory
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		t.log.Error("Failed to create output directory: %v", err)
-		return err
-	}
-
-	// Create a unique filename based on call SID and timestamp
-	timestamp := time.Now().Format("20060102-150405.000")
-	sanitizedText := sanitizeFilename(text)
-	if len(sanitizedText) > 30 {
-		sanitizedText = sanitizedText[:30] // Limit text length in filename
-	}
-
-	filename := fmt.Sprintf("%s/%s_%s_%s.raw", outputDir, callSID, timestamp, sanitizedText)
-
-	// Save the audio data to file
-	t.log.Info("Saving %d bytes of audio to file: %s", len(audioData), filename)
-	if err := os.WriteFile(filename, audioData, 0644); err != nil {
-		t.log.Error("Failed to save audio to file: %v", err)
-		return err
-	}
-
-	t.log.Info("Successfully saved audio to file: %s", filename)
-	return nil
-}
-
-// sanitizeFilename removes special characters from a string to make it safe for use in a filename
-func sanitizeFilename(input string) string {
-	// Replace spaces with underscores
-	result := strings.ReplaceAll(input, " ", "_")
-
-	// Remove non-alphanumeric characters
-	reg := regexp.MustCompile("[^a-zA-Z0-9_]")
-	result = reg.ReplaceAllString(result, "")
-
-	return result
-}
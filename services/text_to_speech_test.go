@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/ghophp/call-me-help/config"
+)
+
+func TestGoogleVoiceSelection(t *testing.T) {
+	cases := []struct {
+		name         string
+		cfg          config.Config
+		wantName     string
+		wantLanguage string
+		wantGender   texttospeechpb.SsmlVoiceGender
+	}{
+		{
+			name:         "defaults keep the original voice",
+			cfg:          config.Config{TTSGender: "NEUTRAL", STTLanguageCode: "en-US"},
+			wantName:     "en-US-Standard-I",
+			wantLanguage: "en-US",
+			wantGender:   texttospeechpb.SsmlVoiceGender_NEUTRAL,
+		},
+		{
+			name:         "language is taken from the voice name",
+			cfg:          config.Config{TTSVoice: "es-ES-Wavenet-B", TTSGender: "male"},
+			wantName:     "es-ES-Wavenet-B",
+			wantLanguage: "es-ES",
+			wantGender:   texttospeechpb.SsmlVoiceGender_MALE,
+		},
+		{
+			name:         "translation mode speaks the caller's language",
+			cfg:          config.Config{TranslationEnabled: true, STTLanguageCode: "pt-BR", TTSGender: "FEMALE"},
+			wantLanguage: "pt-BR",
+			wantGender:   texttospeechpb.SsmlVoiceGender_FEMALE,
+		},
+		{
+			name:         "unknown genders fall back to neutral",
+			cfg:          config.Config{TTSLanguageCode: "fr-FR", TTSGender: "robot"},
+			wantLanguage: "fr-FR",
+			wantGender:   texttospeechpb.SsmlVoiceGender_NEUTRAL,
+		},
+	}
+
+	for _, tc := range cases {
+		voice := googleVoice(&tc.cfg)
+		if voice.Name != tc.wantName || voice.LanguageCode != tc.wantLanguage || voice.SsmlGender != tc.wantGender {
+			t.Errorf("%s: expected %q/%s/%s, got %q/%s/%s", tc.name,
+				tc.wantName, tc.wantLanguage, tc.wantGender, voice.Name, voice.LanguageCode, voice.SsmlGender)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Timeline entry kinds
+const (
+	TimelineReferral  = "referral"
+	TimelineSession   = "session"
+	TimelineSummary   = "summary"
+	TimelineRisk      = "risk"
+	TimelineMood      = "mood"
+	TimelineGoals     = "goals"
+	TimelineFollowUp  = "followUp"
+	TimelineVoicemail = "voicemail"
+	TimelineCallback  = "callback"
+)
+
+// TimelineEntry is one event in a caller's history
+type TimelineEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"`
+	CallSID   string    `json:"callSid,omitempty"`
+	Summary   string    `json:"summary"`
+}
+
+// CallerTimeline aggregates everything known about a caller in chronological order: their
+// referrals, sessions with their summaries, the risks flagged and mood scored on them, the
+// goals set in their session notes, and the follow-ups and callbacks queued for them. The
+// sessions of calls evicted from memory are read from the store, when there is one.
+func CallerTimeline(svc *ServiceContainer, store TranscriptStore, callerHash string) ([]TimelineEntry, error) {
+	var entries []TimelineEntry
+
+	for _, ref := range svc.Referrals.ForCaller(callerHash) {
+		entries = append(entries, TimelineEntry{
+			Timestamp: ref.CreatedAt,
+			Kind:      TimelineReferral,
+			CallSID:   ref.CallSID,
+			Summary:   fmt.Sprintf("Referred by %s: %s", ref.Organization, ref.Reason),
+		})
+	}
+
+	// The profile knows the caller's calls and risks after their conversations are evicted
+	var snapshot CallerProfileSnapshot
+	if profile, ok := svc.Conversation.CallerProfile(callerHash); ok {
+		snapshot = profile.Snapshot()
+	}
+	calls := make(map[string]time.Time)
+	for _, call := range snapshot.Calls {
+		calls[call.CallSID] = call.StartedAt
+	}
+	records := make(map[string]TranscriptExport)
+	if store != nil {
+		stored, err := store.List(ConversationQuery{CallerHash: callerHash})
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range stored {
+			records[record.CallSID] = record
+			calls[record.CallSID] = record.StartedAt
+		}
+	}
+	for _, conv := range svc.Conversation.ConversationsForCaller(callerHash) {
+		calls[conv.ID] = conv.CreatedAt
+	}
+	for callSID, startedAt := range calls {
+		var stored *TranscriptExport
+		if record, ok := records[callSID]; ok {
+			stored = &record
+		}
+		entries = append(entries, sessionEntries(svc, callSID, startedAt, stored)...)
+	}
+
+	for _, risk := range snapshot.RiskHistory {
+		entries = append(entries, TimelineEntry{
+			Timestamp: risk.FlaggedAt,
+			Kind:      TimelineRisk,
+			CallSID:   risk.CallSID,
+			Summary:   "Risk flagged: " + risk.Flag,
+		})
+	}
+	for _, mood := range snapshot.Moods {
+		summary := fmt.Sprintf("Mood score %.2f", mood.Score)
+		if mood.Emotion != "" {
+			summary += ", mostly " + mood.Emotion
+		}
+		entries = append(entries, TimelineEntry{
+			Timestamp: mood.StartedAt,
+			Kind:      TimelineMood,
+			CallSID:   mood.CallSID,
+			Summary:   summary,
+		})
+	}
+
+	for _, offer := range svc.Voicemail.Callbacks() {
+		if HashPhoneNumber(offer.PhoneNumber) != callerHash {
+			continue
+		}
+		entries = append(entries, callbackEntry(offer))
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	return entries, nil
+}
+
+// sessionEntries are a call's session, with its summary, suggested follow-ups and the goals
+// of its session notes once they were written; record is the call's stored session record,
+// nil when it has none
+func sessionEntries(svc *ServiceContainer, callSID string, startedAt time.Time, record *TranscriptExport) []TimelineEntry {
+	session := TimelineEntry{Timestamp: startedAt, Kind: TimelineSession, CallSID: callSID, Summary: "Live session"}
+	var summary *CallSummary
+	if conv, ok := svc.Conversation.GetConversation(callSID); ok {
+		session.Summary = fmt.Sprintf("Live session with %d messages", conv.MessageCount())
+		summary = conv.CallSummary()
+	} else if record != nil {
+		session.Summary = fmt.Sprintf("Archived session with %d messages", len(record.Messages))
+		summary = record.Summary
+	}
+	entries := []TimelineEntry{session}
+
+	if summary != nil {
+		entries = append(entries, TimelineEntry{
+			Timestamp: summary.CreatedAt,
+			Kind:      TimelineSummary,
+			CallSID:   callSID,
+			Summary:   summary.Overview,
+		})
+		for _, followUp := range summary.FollowUps {
+			entries = append(entries, TimelineEntry{
+				Timestamp: summary.CreatedAt,
+				Kind:      TimelineFollowUp,
+				CallSID:   callSID,
+				Summary:   followUp,
+			})
+		}
+	}
+	if notes, ok := svc.SessionNotes.Notes(callSID); ok && len(notes.Plan) > 0 {
+		entries = append(entries, TimelineEntry{
+			Timestamp: notes.CreatedAt,
+			Kind:      TimelineGoals,
+			CallSID:   callSID,
+			Summary:   strings.Join(notes.Plan, "; "),
+		})
+	}
+	return entries
+}
+
+// callbackEntry is a voicemail with the callback it offered, or a callback asked for on a call
+func callbackEntry(offer CallbackOffer) TimelineEntry {
+	entry := TimelineEntry{Timestamp: offer.CreatedAt, Kind: TimelineVoicemail, CallSID: offer.CallSID}
+	switch {
+	case offer.Source == CallbackRequested:
+		entry.Kind = TimelineCallback
+		entry.Summary = "Callback requested"
+		if offer.RequestedTime != "" {
+			entry.Summary += " for " + offer.RequestedTime
+		}
+	case offer.ReplySent:
+		entry.Summary = "Voicemail left, SMS reply sent and callback offered"
+	default:
+		entry.Summary = "Voicemail left and callback queued, the SMS reply failed to send"
+	}
+	return entry
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallerTimelineMergesEverythingKnown(t *testing.T) {
+	svc := &ServiceContainer{
+		Conversation: NewConversationService(),
+		Referrals:    NewReferralService(0),
+		Voicemail:    NewVoicemailService(nil, nil, nil, 0),
+	}
+	caller := HashPhoneNumber("+15551234567")
+
+	conv := svc.Conversation.GetOrCreateConversation("CA1")
+	svc.Conversation.AttachCaller(conv, caller)
+	conv.AddUserMessage("I can't sleep")
+	conv.FlagRisk(RiskSelfHarm)
+	conv.SetCallSummary(&CallSummary{Overview: "Trouble sleeping", FollowUps: []string{"Check in tomorrow"}, CreatedAt: time.Now()})
+	svc.Voicemail.ScheduleCallback("CA1", "+15551234567", "tomorrow morning", "")
+	svc.Voicemail.mu.Lock()
+	svc.Voicemail.callbacks = append(svc.Voicemail.callbacks, CallbackOffer{Source: CallbackVoicemail, PhoneNumber: "+15551234567", CallSID: "CA2", CreatedAt: time.Now()})
+	svc.Voicemail.mu.Unlock()
+
+	// An earlier call evicted from memory is only in the archive
+	archive := NewTranscriptArchive(t.TempDir(), nil)
+	archived := exportConversation()
+	archived.CallerHash = caller
+	archived.SetCallSummary(&CallSummary{Overview: "First call", CreatedAt: archived.CreatedAt.Add(time.Minute)})
+	if err := archive.Save(archived); err != nil {
+		t.Fatal(err)
+	}
+
+	timeline, err := CallerTimeline(svc, archive, caller)
+	if err != nil {
+		t.Fatalf("Expected the timeline built, got %v", err)
+	}
+	if len(timeline) < 2 || timeline[0].Summary != "Archived session with 2 messages" || timeline[1].Summary != "First call" {
+		t.Errorf("Expected the archived session and its summary first, got %+v", timeline)
+	}
+	kinds := map[string]string{}
+	for _, entry := range timeline {
+		if entry.CallSID != archived.ID {
+			kinds[entry.Kind] = entry.Summary
+		}
+	}
+	for kind, want := range map[string]string{
+		TimelineSession:   "Live session with 1 messages",
+		TimelineSummary:   "Trouble sleeping",
+		TimelineFollowUp:  "Check in tomorrow",
+		TimelineRisk:      "Risk flagged: " + RiskSelfHarm,
+		TimelineCallback:  "Callback requested for tomorrow morning",
+		TimelineVoicemail: "Voicemail left and callback queued, the SMS reply failed to send",
+	} {
+		if kinds[kind] != want {
+			t.Errorf("Expected the %s entry %q, got %q", kind, want, kinds[kind])
+		}
+	}
+}
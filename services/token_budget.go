@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// CallUsage is how much of the LLM a call has used. Prompt tokens are estimated from the
+// text each provider sent, response tokens from the text it returned.
+type CallUsage struct {
+	Generations    int     `json:"generations"`
+	PromptTokens   int     `json:"promptTokens"`
+	ResponseTokens int     `json:"responseTokens"`
+	Cost           float64 `json:"cost"` // In the currency the prices are configured in
+	// Limited is set once the call's responses were shortened or moved to the budget model
+	Limited bool `json:"limited"`
+}
+
+// Tokens returns the prompt and response tokens together
+func (u CallUsage) Tokens() int {
+	return u.PromptTokens + u.ResponseTokens
+}
+
+// BudgetLevel is how close a call is to its LLM budget
+type BudgetLevel int
+
+const (
+	// BudgetOK leaves generation as configured
+	BudgetOK BudgetLevel = iota
+	// BudgetNear asks for shorter responses
+	BudgetNear
+	// BudgetExceeded also moves the call to the budget model
+	BudgetExceeded
+)
+
+// budgetBriefNote asks the LLM to keep responses short once a call nears its budget
+const budgetBriefNote = "Keep each response to one or two short sentences from now on."
+
+// TokenBudget keeps each call's LLM usage within a token and a cost ceiling. Near a
+// ceiling responses are kept short, and past it they also come from the cheaper budget
+// model. Calls are never cut off for going over: a caller in distress still gets answers.
+type TokenBudget struct {
+	// MaxTokens is the most prompt and response tokens a call should use, 0 for no ceiling
+	MaxTokens int
+	// MaxCost is the most a call should cost, 0 for no ceiling
+	MaxCost float64
+	// PromptPrice and ResponsePrice are the prices of 1000 tokens
+	PromptPrice   float64
+	ResponsePrice float64
+	// WarnFraction is the share of a ceiling past which responses are kept short
+	WarnFraction float64
+	// Model answers calls over a ceiling, empty to keep the configured model
+	Model string
+	// MaxOutputTokens caps responses near and over a ceiling, 0 for only asking the LLM to be brief
+	MaxOutputTokens int
+
+	log *logger.Logger
+}
+
+// NewTokenBudget creates a budget with the ceilings; it returns nil, which only tracks
+// usage, when neither is set
+func NewTokenBudget(maxTokens int, maxCost float64) *TokenBudget {
+	if maxTokens <= 0 && maxCost <= 0 {
+		return nil
+	}
+	return &TokenBudget{
+		MaxTokens:    maxTokens,
+		MaxCost:      maxCost,
+		WarnFraction: 0.8,
+		log:          logger.Component("TokenBudget"),
+	}
+}
+
+// Cost prices the tokens of a generation; a nil budget has no prices
+func (b *TokenBudget) Cost(promptTokens, responseTokens int) float64 {
+	if b == nil {
+		return 0
+	}
+	return (float64(promptTokens)*b.PromptPrice + float64(responseTokens)*b.ResponsePrice) / 1000
+}
+
+// Level returns how close the usage is to the nearest ceiling
+func (b *TokenBudget) Level(usage CallUsage) BudgetLevel {
+	if b == nil {
+		return BudgetOK
+	}
+	var used float64
+	if b.MaxTokens > 0 {
+		used = float64(usage.Tokens()) / float64(b.MaxTokens)
+	}
+	if b.MaxCost > 0 {
+		used = max(used, usage.Cost/b.MaxCost)
+	}
+	switch {
+	case used >= 1:
+		return BudgetExceeded
+	case used >= b.WarnFraction:
+		return BudgetNear
+	default:
+		return BudgetOK
+	}
+}
+
+// Limit prepares a turn of a call with the usage: near a ceiling the history gets a note
+// asking for brevity and responses are capped, and past it the budget model is used
+func (b *TokenBudget) Limit(ctx context.Context, usage CallUsage, history []string) (context.Context, []string, BudgetLevel) {
+	level := b.Level(usage)
+	if level == BudgetOK {
+		return ctx, history, level
+	}
+
+	callSID := CallSIDFromContext(ctx)
+	if level == BudgetExceeded && b.Model != "" {
+		ctx = WithModel(ctx, b.Model)
+		b.log.Warn("Call %s is over its LLM budget (%d tokens, %.4f), answering with %s", callSID, usage.Tokens(), usage.Cost, b.Model)
+	} else {
+		b.log.Info("Call %s is near its LLM budget (%d tokens, %.4f), keeping responses short", callSID, usage.Tokens(), usage.Cost)
+	}
+	if b.MaxOutputTokens > 0 {
+		ctx = WithMaxOutputTokens(ctx, b.MaxOutputTokens)
+	}
+	return ctx, append(history, "Context: "+budgetBriefNote), level
+}
+
+// maxOutputTokensContextKey is the context key type for a cap on the response length
+type maxOutputTokensContextKey struct{}
+
+// WithMaxOutputTokens returns a context capping the response at the tokens, below the
+// configured and persona's limits
+func WithMaxOutputTokens(ctx context.Context, tokens int) context.Context {
+	return context.WithValue(ctx, maxOutputTokensContextKey{}, tokens)
+}
+
+// maxOutputTokensFromContext returns the cap stored by WithMaxOutputTokens, or 0 when there is none
+func maxOutputTokensFromContext(ctx context.Context) int {
+	tokens, _ := ctx.Value(maxOutputTokensContextKey{}).(int)
+	return tokens
+}
+
+// reportPromptTokens records the estimated size of the prompt a provider is sending
+func reportPromptTokens(ctx context.Context, tokens int) {
+	if report, _ := ctx.Value(generationReportContextKey{}).(*GenerationReport); report != nil {
+		report.PromptTokens = tokens
+	}
+}
+
+// chatTokens estimates the tokens of a chat-style request
+func chatTokens(system string, messages []chatMessage) int {
+	var text strings.Builder
+	text.WriteString(system)
+	for _, msg := range messages {
+		text.WriteString("\n" + msg.Content)
+	}
+	return EstimateTokens(text.String())
+}
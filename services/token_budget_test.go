@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// budgetGenerator is a ResponseGenerator noting how each request was limited
+type budgetGenerator struct {
+	models    []string
+	maxTokens []int
+	briefed   []bool
+}
+
+func (b *budgetGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	model := modelFromContext(ctx)
+	if model == "" {
+		model = "primary"
+	}
+	reportModel(ctx, model)
+	reportPromptTokens(ctx, 400)
+	b.models = append(b.models, model)
+	b.maxTokens = append(b.maxTokens, maxOutputTokensFromContext(ctx))
+	b.briefed = append(b.briefed, conversationHistory[len(conversationHistory)-1] == "Context: "+budgetBriefNote)
+	return "I hear you, that sounds hard.", nil // 8 tokens
+}
+
+func TestTokenBudgetLevels(t *testing.T) {
+	budget := NewTokenBudget(1000, 0.05)
+	budget.PromptPrice, budget.ResponsePrice = 0.01, 0.03
+
+	for _, tc := range []struct {
+		usage CallUsage
+		want  BudgetLevel
+	}{
+		{CallUsage{PromptTokens: 500, ResponseTokens: 100, Cost: 0.008}, BudgetOK},
+		{CallUsage{PromptTokens: 750, ResponseTokens: 50, Cost: 0.009}, BudgetNear},
+		{CallUsage{PromptTokens: 100, Cost: 0.041}, BudgetNear},
+		{CallUsage{PromptTokens: 1000, Cost: 0.01}, BudgetExceeded},
+	} {
+		if got := budget.Level(tc.usage); got != tc.want {
+			t.Errorf("%+v: expected level %d, got %d", tc.usage, tc.want, got)
+		}
+	}
+
+	if cost := budget.Cost(1000, 500); math.Abs(cost-0.025) > 1e-9 {
+		t.Errorf("Expected a cost of 0.025, got %v", cost)
+	}
+	if NewTokenBudget(0, 0) != nil {
+		t.Error("Expected no budget without ceilings")
+	}
+	var none *TokenBudget
+	if none.Level(CallUsage{PromptTokens: 1 << 20}) != BudgetOK || none.Cost(1000, 1000) != 0 {
+		t.Error("Expected a nil budget to never limit or price")
+	}
+}
+
+func TestTurnEngineHoldsCallsToTheirBudget(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &budgetGenerator{}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+	engine.Budget = NewTokenBudget(800, 0)
+	engine.Budget.WarnFraction = 0.5
+	engine.Budget.Model = "budget-model"
+	engine.Budget.MaxOutputTokens = 60
+
+	// 408 tokens a turn: fine, then past the 400 warning, then over the ceiling
+	for _, message := range []string{"Hi", "I can't sleep", "I keep worrying"} {
+		engine.ProcessTranscription(context.Background(), message)
+	}
+
+	if generator.models[0] != "primary" || generator.maxTokens[0] != 0 || generator.briefed[0] {
+		t.Errorf("Expected the first turn unlimited, got %q %v %v", generator.models, generator.maxTokens, generator.briefed)
+	}
+	if generator.models[1] != "primary" || generator.maxTokens[1] != 60 || !generator.briefed[1] {
+		t.Errorf("Expected the second turn kept short, got %q %v %v", generator.models, generator.maxTokens, generator.briefed)
+	}
+	if generator.models[2] != "budget-model" || !generator.briefed[2] {
+		t.Errorf("Expected the third turn on the budget model, got %q", generator.models)
+	}
+
+	usage := conversation.Usage()
+	if usage.Generations != 3 || usage.PromptTokens != 1200 || usage.ResponseTokens != 24 || !usage.Limited {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
+
+func TestGenerationParamsCappedByContext(t *testing.T) {
+	var settings *GenerationSettings
+	if got := settings.For(WithMaxOutputTokens(context.Background(), 80)).MaxOutputTokens; got != 80 {
+		t.Errorf("Expected the cap without a configured limit, got %d", got)
+	}
+
+	settings = &GenerationSettings{defaults: GenerationParams{MaxOutputTokens: 50}}
+	if got := settings.For(WithMaxOutputTokens(context.Background(), 80)).MaxOutputTokens; got != 50 {
+		t.Errorf("Expected the lower configured limit kept, got %d", got)
+	}
+}
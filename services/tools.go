@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// maxToolSMSLength keeps texted resources within a few SMS segments
+const maxToolSMSLength = 480
+
+// ToolParameter is a string argument of a tool
+type ToolParameter struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// ToolCall is a tool the LLM asked to run, with its arguments
+type ToolCall struct {
+	Name string
+	Args map[string]interface{}
+}
+
+// arg returns a string argument, or "" when it is missing
+func (c ToolCall) arg(name string) string {
+	value, _ := c.Args[name].(string)
+	return strings.TrimSpace(value)
+}
+
+// Tool is an action the LLM can take during a call
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  []ToolParameter
+	// Run performs the action and returns what the LLM should know about the result
+	Run func(ctx context.Context, call ToolCall) (map[string]interface{}, error)
+}
+
+// ToolDispatcher holds the tools offered to the LLM and runs the calls it returns
+type ToolDispatcher struct {
+	tools  []Tool
+	byName map[string]Tool
+	log    *logger.Logger
+}
+
+// NewToolDispatcher creates a dispatcher offering the tools
+func NewToolDispatcher(tools ...Tool) *ToolDispatcher {
+	d := &ToolDispatcher{
+		tools:  tools,
+		byName: make(map[string]Tool, len(tools)),
+		log:    logger.Component("ToolDispatcher"),
+	}
+	for _, tool := range tools {
+		d.byName[tool.Name] = tool
+	}
+	return d
+}
+
+// Tools returns the tools offered to the LLM
+func (d *ToolDispatcher) Tools() []Tool {
+	if d == nil {
+		return nil
+	}
+	return d.tools
+}
+
+// Dispatch runs a tool call. Failures are returned as an error result rather than an
+// error, so the LLM can tell the caller what didn't work.
+func (d *ToolDispatcher) Dispatch(ctx context.Context, call ToolCall) map[string]interface{} {
+	callSID := CallSIDFromContext(ctx)
+	tool, ok := d.byName[call.Name]
+	if !ok {
+		d.log.Warn("LLM called unknown tool %q on call %s", call.Name, callSID)
+		return map[string]interface{}{"error": fmt.Sprintf("unknown tool %q", call.Name)}
+	}
+	for _, param := range tool.Parameters {
+		if param.Required && call.arg(param.Name) == "" {
+			return map[string]interface{}{"error": fmt.Sprintf("missing argument %q", param.Name)}
+		}
+	}
+
+	d.log.Info("Running tool %s on call %s", call.Name, callSID)
+	result, err := tool.Run(ctx, call)
+	if err != nil {
+		d.log.Error("Tool %s failed on call %s: %v", call.Name, callSID, err)
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return result
+}
+
+// SMSSender sends text messages to callers
+type SMSSender interface {
+	SendMessage(to, message string) error
+}
+
+// NewCallTools creates the dispatcher of the actions offered during calls: looking up
+// crisis resources, scheduling a callback and texting the caller resources
+func NewCallTools(resources *CrisisResources, sms SMSSender, callbacks *VoicemailService) *ToolDispatcher {
+	return NewToolDispatcher(
+		Tool{
+			Name:        "lookup_crisis_resources",
+			Description: "Find crisis helplines and support services for the caller, local ones first when their location is known.",
+			Parameters: []ToolParameter{
+				{Name: "location", Description: "City, state or region the caller mentioned, if any"},
+				{Name: "topic", Description: "What the caller needs help with, e.g. suicide, domestic violence, substance use, veterans, sexual assault, LGBTQ"},
+			},
+			Run: func(ctx context.Context, call ToolCall) (map[string]interface{}, error) {
+				found := resources.Lookup(call.arg("location"), call.arg("topic"))
+				return map[string]interface{}{"resources": found}, nil
+			},
+		},
+		Tool{
+			Name:        "schedule_callback",
+			Description: "Schedule a counselor to call the caller back at this number. Only use it when the caller asks for a callback.",
+			Parameters: []ToolParameter{
+				{Name: "preferred_time", Description: "When the caller would like to be called, in their words, e.g. tomorrow evening"},
+				{Name: "reason", Description: "Short note for the counselor about what the caller wants to talk about"},
+			},
+			Run: func(ctx context.Context, call ToolCall) (map[string]interface{}, error) {
+				number := CallerNumberFromContext(ctx)
+				if number == "" {
+					return nil, errors.New("the caller's number is unknown")
+				}
+				offer := callbacks.ScheduleCallback(CallSIDFromContext(ctx), number, call.arg("preferred_time"), call.arg("reason"))
+				return map[string]interface{}{"scheduled": true, "callbackId": offer.ID, "preferredTime": offer.RequestedTime}, nil
+			},
+		},
+		Tool{
+			Name:        "send_resources_sms",
+			Description: "Text resources to the caller's phone. Only use it when the caller agrees to receive a text message.",
+			Parameters: []ToolParameter{
+				{Name: "message", Description: "Short text listing the resources to send, with their phone numbers", Required: true},
+			},
+			Run: func(ctx context.Context, call ToolCall) (map[string]interface{}, error) {
+				number := CallerNumberFromContext(ctx)
+				if number == "" {
+					return nil, errors.New("the caller's number is unknown")
+				}
+				message := call.arg("message")
+				if runes := []rune(message); len(runes) > maxToolSMSLength {
+					message = string(runes[:maxToolSMSLength])
+				}
+				// Every text carries the crisis contacts, whatever the model wrote
+				if err := sms.SendMessage(number, message+"\n"+voicemailResources); err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{"sent": true}, nil
+			},
+		},
+	)
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghophp/call-me-help/config"
+)
+
+// fakeSMS records the text messages sent
+type fakeSMS struct {
+	to, messages []string
+}
+
+func (f *fakeSMS) SendMessage(to, message string) error {
+	f.to = append(f.to, to)
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+func TestCrisisResourcesLookupPutsLocalFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resources.json")
+	local := `[{"name": "Austin Crisis Line", "phone": "512-472-4357", "regions": ["Austin", "Travis County"]}]`
+	if err := os.WriteFile(path, []byte(local), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resources, err := LoadCrisisResources(path)
+	if err != nil {
+		t.Fatalf("Failed to load resources: %v", err)
+	}
+
+	found := resources.Lookup("Austin, Texas", "")
+	if len(found) == 0 || found[0].Name != "Austin Crisis Line" {
+		t.Errorf("Expected the local line first, got %+v", found)
+	}
+	if found := resources.Lookup("Denver", "veterans"); len(found) == 0 || found[0].Name != "Veterans Crisis Line" {
+		t.Errorf("Expected the topic's line first and no other region's, got %+v", found)
+	}
+	for _, resource := range resources.Lookup("", "") {
+		if len(resource.Regions) > 0 || len(resource.Topics) > 0 {
+			t.Errorf("Expected only general resources without a region or topic, got %+v", resource)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"name": "No contact"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCrisisResources(path); err == nil {
+		t.Error("Expected an error for a resource without a contact")
+	}
+}
+
+func TestCallToolsActForTheCaller(t *testing.T) {
+	resources, _ := LoadCrisisResources("")
+	sms := &fakeSMS{}
+	callbacks := NewVoicemailService(nil, nil, nil, 0)
+	tools := NewCallTools(resources, sms, callbacks)
+	ctx := WithCallerNumber(WithCallSID(context.Background(), "CA1"), "+15550001111")
+
+	result := tools.Dispatch(ctx, ToolCall{Name: "send_resources_sms", Args: map[string]interface{}{"message": "988 Lifeline: call or text 988"}})
+	if result["sent"] != true || len(sms.messages) != 1 || sms.to[0] != "+15550001111" ||
+		!strings.HasSuffix(sms.messages[0], voicemailResources) {
+		t.Errorf("Expected the resources texted with the crisis contacts, got %v %q", result, sms.messages)
+	}
+
+	result = tools.Dispatch(ctx, ToolCall{Name: "schedule_callback", Args: map[string]interface{}{"preferred_time": "tomorrow evening"}})
+	offers := callbacks.Callbacks()
+	if result["scheduled"] != true || len(offers) != 1 || offers[0].CallSID != "CA1" || offers[0].RequestedTime != "tomorrow evening" {
+		t.Errorf("Expected the callback queued, got %v %+v", result, offers)
+	}
+
+	if result := tools.Dispatch(ctx, ToolCall{Name: "send_resources_sms"}); result["error"] == nil {
+		t.Errorf("Expected an error result without a message, got %v", result)
+	}
+	if result := tools.Dispatch(context.Background(), ToolCall{Name: "schedule_callback"}); result["error"] == nil {
+		t.Errorf("Expected an error result without the caller's number, got %v", result)
+	}
+	if result := tools.Dispatch(ctx, ToolCall{Name: "transfer_funds"}); result["error"] == nil {
+		t.Errorf("Expected an error result for an unknown tool, got %v", result)
+	}
+}
+
+func TestVertexGeminiFeedsToolResultsBack(t *testing.T) {
+	type toolRequest struct {
+		Contents []geminiContent `json:"contents"`
+		Tools    []struct {
+			FunctionDeclarations []struct {
+				Name string `json:"name"`
+			} `json:"functionDeclarations"`
+		} `json:"tools"`
+	}
+	var requests []toolRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request toolRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "lookup_crisis_resources", "args": {"topic": "veterans"}}}]}}]}`))
+			return
+		}
+		w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "You can call 988 and press 1."}]}}]}`))
+	}))
+	defer server.Close()
+
+	resources, _ := LoadCrisisResources("")
+	tools := NewCallTools(resources, &fakeSMS{}, NewVoicemailService(nil, nil, nil, 0))
+	vertex := newTestVertexGemini(server.URL, &config.Config{})
+	response, err := vertex.GenerateResponseWithTools(context.Background(), "I'm a veteran and struggling", nil, tools)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if response != "You can call 988 and press 1." {
+		t.Errorf("Expected the final answer, got %q", response)
+	}
+	if len(requests) != 2 || len(requests[0].Tools) != 1 || len(requests[0].Tools[0].FunctionDeclarations) != 3 {
+		t.Fatalf("Expected two requests offering the three tools, got %+v", requests)
+	}
+
+	contents := requests[1].Contents
+	if len(contents) != 3 || contents[1].Role != "model" || contents[1].Parts[0].FunctionCall == nil {
+		t.Fatalf("Expected the prompt, the model's call and the result, got %+v", contents)
+	}
+	result := contents[2].Parts[0].FunctionResponse
+	if result == nil || result.Name != "lookup_crisis_resources" {
+		t.Fatalf("Expected the tool's result, got %+v", contents[2])
+	}
+	found, _ := result.Response["resources"].([]interface{})
+	if len(found) == 0 || !strings.Contains(found[0].(map[string]interface{})["name"].(string), "Veterans") {
+		t.Errorf("Expected the veterans line in the result, got %v", result.Response)
+	}
+}
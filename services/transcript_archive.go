@@ -0,0 +1,223 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// TranscriptArchive keeps the session records of calls evicted from memory, callers'
+// profiles and the callback queue on disk, one file each, encrypted when a cipher is
+// configured
+type TranscriptArchive struct {
+	dir    string
+	cipher *ContentCipher // nil writes records in the clear
+	log    *logger.Logger
+}
+
+// NewTranscriptArchive creates an archive writing to dir; it returns nil, which archives
+// nothing, when dir is empty
+func NewTranscriptArchive(dir string, cipher *ContentCipher) *TranscriptArchive {
+	if dir == "" {
+		return nil
+	}
+	return &TranscriptArchive{
+		dir:    dir,
+		cipher: cipher,
+		log:    logger.Component("TranscriptArchive"),
+	}
+}
+
+// WithPrefix returns an archive in the prefix's subdirectory, e.g. for a tenant's records
+func (a *TranscriptArchive) WithPrefix(prefix string) TranscriptStore {
+	if a == nil {
+		return a
+	}
+	return &TranscriptArchive{dir: filepath.Join(a.dir, prefix), cipher: a.cipher, log: a.log}
+}
+
+// Encrypted reports whether records are encrypted as they are saved
+func (a *TranscriptArchive) Encrypted() bool {
+	return a != nil && a.cipher != nil
+}
+
+// Save writes the conversation's session record, replacing any earlier one of the call
+func (a *TranscriptArchive) Save(conv *Conversation) error {
+	data, err := json.Marshal(NewTranscriptExport(conv))
+	if err != nil {
+		return err
+	}
+	if a.cipher != nil {
+		if data, err = a.cipher.Seal(data, []byte(conv.ID)); err != nil {
+			return fmt.Errorf("failed to encrypt session record: %w", err)
+		}
+	}
+	if err := writeFileAtomic(a.path(conv.ID), data); err != nil {
+		return err
+	}
+	a.log.Info("Archived call %s (%d bytes, encrypted: %v)", conv.ID, len(data), a.cipher != nil)
+	return nil
+}
+
+// Load reads the session record of an archived call, decrypting it if it was encrypted;
+// ok is false when the call wasn't archived
+func (a *TranscriptArchive) Load(callSID string) (record TranscriptExport, ok bool, err error) {
+	if a == nil {
+		return record, false, nil
+	}
+	data, err := os.ReadFile(a.path(callSID))
+	if errors.Is(err, os.ErrNotExist) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	if data, err = a.cipher.Open(data, []byte(callSID)); err != nil {
+		return record, false, fmt.Errorf("session record of call %s: %w", callSID, err)
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, false, fmt.Errorf("session record of call %s is corrupt: %w", callSID, err)
+	}
+	return record, true, nil
+}
+
+// List reads the archived session records passing the query's filters; records that
+// can't be read are logged and skipped
+func (a *TranscriptArchive) List(query ConversationQuery) ([]TranscriptExport, error) {
+	if a == nil {
+		return nil, nil
+	}
+	files, err := os.ReadDir(a.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []TranscriptExport
+	for _, file := range files {
+		callSID, ok := strings.CutSuffix(file.Name(), ".json")
+		if file.IsDir() || !ok {
+			continue
+		}
+		record, ok, err := a.Load(callSID)
+		if err != nil {
+			a.log.Warn("Skipping archived call %s: %v", callSID, err)
+			continue
+		}
+		if ok && query.MatchesRecord(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// SaveProfile writes the caller's profile, replacing any earlier one
+func (a *TranscriptArchive) SaveProfile(profile *CallerProfile) error {
+	data, err := json.Marshal(profile.Snapshot())
+	if err != nil {
+		return err
+	}
+	if a.cipher != nil {
+		if data, err = a.cipher.Seal(data, []byte(profile.CallerHash)); err != nil {
+			return fmt.Errorf("failed to encrypt caller profile: %w", err)
+		}
+	}
+	return writeFileAtomic(a.profilePath(profile.CallerHash), data)
+}
+
+// LoadProfile reads a caller's profile, decrypting it if it was encrypted; ok is false
+// when it wasn't saved
+func (a *TranscriptArchive) LoadProfile(callerHash string) (*CallerProfile, bool, error) {
+	if a == nil {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(a.profilePath(callerHash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if data, err = a.cipher.Open(data, []byte(callerHash)); err != nil {
+		return nil, false, fmt.Errorf("profile of caller %s: %w", callerHash, err)
+	}
+	var snapshot CallerProfileSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, false, fmt.Errorf("profile of caller %s is corrupt: %w", callerHash, err)
+	}
+	return RestoreCallerProfile(snapshot), true, nil
+}
+
+// SaveCallbacks writes the callback queue, replacing the one saved before
+func (a *TranscriptArchive) SaveCallbacks(offers []CallbackOffer) error {
+	data, err := json.Marshal(offers)
+	if err != nil {
+		return err
+	}
+	if a.cipher != nil {
+		if data, err = a.cipher.Seal(data, []byte(callbackQueueID)); err != nil {
+			return fmt.Errorf("failed to encrypt the callback queue: %w", err)
+		}
+	}
+	return writeFileAtomic(a.callbacksPath(), data)
+}
+
+// LoadCallbacks reads the saved callback queue, decrypting it if it was encrypted
+func (a *TranscriptArchive) LoadCallbacks() ([]CallbackOffer, error) {
+	if a == nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(a.callbacksPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, err = a.cipher.Open(data, []byte(callbackQueueID)); err != nil {
+		return nil, fmt.Errorf("callback queue: %w", err)
+	}
+	var offers []CallbackOffer
+	if err := json.Unmarshal(data, &offers); err != nil {
+		return nil, fmt.Errorf("callback queue is corrupt: %w", err)
+	}
+	return offers, nil
+}
+
+// path is the file of a call's session record
+func (a *TranscriptArchive) path(callSID string) string {
+	return filepath.Join(a.dir, sanitizeFilename(callSID)+".json")
+}
+
+// profilePath is the file of a caller's profile
+func (a *TranscriptArchive) profilePath(callerHash string) string {
+	return filepath.Join(a.dir, "callers", sanitizeFilename(callerHash)+".json")
+}
+
+// callbacksPath is the file of the callback queue, out of the records' directory
+func (a *TranscriptArchive) callbacksPath() string {
+	return filepath.Join(a.dir, "callbacks", "queue.json")
+}
+
+// writeFileAtomic writes the file aside and renames it into place, so a crash never
+// leaves a partial one, creating its directory if needed
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testCipher(t *testing.T, seed byte) *ContentCipher {
+	t.Helper()
+	cipher, err := NewContentCipher(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{seed}, 32)))
+	if err != nil {
+		t.Fatalf("Expected a cipher, got %v", err)
+	}
+	return cipher
+}
+
+func TestTranscriptArchiveEncryptsRecords(t *testing.T) {
+	dir := t.TempDir()
+	archive := NewTranscriptArchive(dir, testCipher(t, 1))
+	if err := archive.Save(exportConversation()); err != nil {
+		t.Fatalf("Expected the call archived, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "CA123.json"))
+	if err != nil {
+		t.Fatalf("Expected the record on disk, got %v", err)
+	}
+	if !IsSealed(data) || bytes.Contains(data, []byte("sleep")) || bytes.Contains(data, []byte("CA123")) {
+		t.Errorf("Expected the record encrypted on disk, got %q", data)
+	}
+
+	record, ok, err := archive.Load("CA123")
+	if err != nil || !ok {
+		t.Fatalf("Expected the record read back, got %v, %v", ok, err)
+	}
+	if len(record.Messages) != 2 || record.Messages[0].Text != "I can't sleep (again)." || record.Persona != "calm" {
+		t.Errorf("Expected the record decrypted, got %+v", record)
+	}
+	if _, ok, err := archive.Load("CA404"); ok || err != nil {
+		t.Errorf("Expected an unknown call not archived, got %v, %v", ok, err)
+	}
+}
+
+func TestTranscriptArchiveRejectsWrongKeyAndSwappedRecords(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewTranscriptArchive(dir, testCipher(t, 1)).Save(exportConversation()); err != nil {
+		t.Fatalf("Expected the call archived, got %v", err)
+	}
+
+	if _, _, err := NewTranscriptArchive(dir, testCipher(t, 2)).Load("CA123"); err == nil {
+		t.Error("Expected another key to fail decrypting the record")
+	}
+	if _, _, err := NewTranscriptArchive(dir, nil).Load("CA123"); err == nil {
+		t.Error("Expected an encrypted record unreadable without a key")
+	}
+
+	// A record copied over another call's can't be passed off as it
+	data, _ := os.ReadFile(filepath.Join(dir, "CA123.json"))
+	os.WriteFile(filepath.Join(dir, "CA999.json"), data, 0600)
+	if _, _, err := NewTranscriptArchive(dir, testCipher(t, 1)).Load("CA999"); err == nil {
+		t.Error("Expected a record under another call's name to fail decrypting")
+	}
+}
+
+func TestTranscriptArchiveReadsRecordsWrittenInTheClear(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewTranscriptArchive(dir, nil).Save(exportConversation()); err != nil {
+		t.Fatalf("Expected the call archived, got %v", err)
+	}
+
+	record, ok, err := NewTranscriptArchive(dir, testCipher(t, 1)).Load("CA123")
+	if err != nil || !ok || record.CallSID != "CA123" {
+		t.Errorf("Expected a record from before encryption was turned on read, got %+v, %v", record, err)
+	}
+}
+
+// checkStoreLists saves two calls to the store and checks that List filters them
+func checkStoreLists(t *testing.T, store TranscriptStore) {
+	t.Helper()
+	other := NewConversationService().GetOrCreateConversation("CA456")
+	other.CallerHash = "abc123"
+	other.CreatedAt = time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)
+	if err := other.AddTags("urgent"); err != nil {
+		t.Fatal(err)
+	}
+	for _, conv := range []*Conversation{exportConversation(), other} {
+		if err := store.Save(conv); err != nil {
+			t.Fatalf("Expected call %s stored, got %v", conv.ID, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		query ConversationQuery
+		want  []string
+	}{
+		{ConversationQuery{}, []string{"CA123", "CA456"}},
+		{ConversationQuery{CallerHash: "abc123"}, []string{"CA456"}},
+		{ConversationQuery{From: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)}, []string{"CA456"}},
+		{ConversationQuery{To: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)}, []string{"CA123"}},
+		{ConversationQuery{Tags: []string{"urgent"}}, []string{"CA456"}},
+		{ConversationQuery{CallerHash: "unknown"}, nil},
+	} {
+		records, err := store.List(tc.query)
+		var got []string
+		for _, record := range records {
+			got = append(got, record.CallSID)
+		}
+		slices.Sort(got)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("Expected %v listed for %+v, got %v, %v", tc.want, tc.query, got, err)
+		}
+	}
+}
+
+func TestTranscriptArchiveListsRecords(t *testing.T) {
+	archive := NewTranscriptArchive(t.TempDir(), testCipher(t, 1))
+	// Profiles are kept next to the records, and aren't listed
+	if err := archive.SaveProfile(&CallerProfile{CallerHash: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	checkStoreLists(t, archive)
+
+	var missing *TranscriptArchive
+	if records, err := missing.List(ConversationQuery{}); records != nil || err != nil {
+		t.Errorf("Expected a nil archive to list nothing, got %v, %v", records, err)
+	}
+}
+
+func TestNewContentCipher(t *testing.T) {
+	if cipher, err := NewContentCipher(""); cipher != nil || err != nil {
+		t.Errorf("Expected no cipher without a key, got %v, %v", cipher, err)
+	}
+	if _, err := NewContentCipher("not base64!"); err == nil {
+		t.Error("Expected a key that isn't base64 rejected")
+	}
+	if _, err := NewContentCipher(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("Expected a key of the wrong length rejected")
+	}
+}
+
+func TestTranscriptArchiveKeepsCallerProfiles(t *testing.T) {
+	dir := t.TempDir()
+	archive := NewTranscriptArchive(dir, testCipher(t, 1))
+	service := NewConversationService()
+	service.SetProfileStore(archive)
+	service.SaveProfile(service.AttachCaller(service.GetOrCreateConversation("CA1"), "hash"))
+
+	data, err := os.ReadFile(filepath.Join(dir, "callers", "hash.json"))
+	if err != nil || !IsSealed(data) {
+		t.Fatalf("Expected the profile encrypted on disk, got %q, %v", data, err)
+	}
+
+	profile, ok, err := NewTranscriptArchive(dir, testCipher(t, 1)).LoadProfile("hash")
+	if err != nil || !ok || len(profile.Snapshot().Calls) != 1 {
+		t.Errorf("Expected the profile read back, got %v, %v", ok, err)
+	}
+	if _, ok, err := archive.LoadProfile("unknown"); ok || err != nil {
+		t.Errorf("Expected an unknown caller not stored, got %v, %v", ok, err)
+	}
+}
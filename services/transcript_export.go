@@ -0,0 +1,333 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Transcript export formats
+const (
+	ExportJSON = "json"
+	ExportText = "txt"
+	ExportPDF  = "pdf"
+)
+
+// exportContentTypes are the content types of the export formats
+var exportContentTypes = map[string]string{
+	ExportJSON: "application/json",
+	ExportText: "text/plain; charset=utf-8",
+	ExportPDF:  "application/pdf",
+}
+
+// TranscriptExport is a session record of a call: its metadata and what was said, when
+type TranscriptExport struct {
+	CallSID    string            `json:"callSid"`
+	CallerHash string            `json:"callerHash,omitempty"`
+	Persona    string            `json:"persona,omitempty"`
+	Tenant     string            `json:"tenant,omitempty"`
+	StartedAt  time.Time         `json:"startedAt"`
+	EndedAt    *time.Time        `json:"endedAt,omitempty"` // Unset while the call is live
+	RiskFlags  []string          `json:"riskFlags,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Summary    *CallSummary      `json:"summary,omitempty"` // Written once the call has ended
+	Messages   []ExportedMessage `json:"messages"`
+	ExportedAt time.Time         `json:"exportedAt"`
+}
+
+// ExportedMessage is one message of a session record
+type ExportedMessage struct {
+	Time    time.Time `json:"time"`
+	Speaker string    `json:"speaker"` // Caller or Therapist
+	Text    string    `json:"text"`
+	// Original and Language are what was said or spoken in the caller's language on translated calls
+	Original string `json:"original,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Source and Confidence are how a caller message was recognized
+	Source     string  `json:"source,omitempty"`
+	Confidence float32 `json:"confidence,omitempty"`
+	// Model, GenerationMs and SynthesisMs are which LLM wrote a therapist message, how long
+	// it took and how long TTS took to start speaking it
+	Model        string `json:"model,omitempty"`
+	GenerationMs int64  `json:"generationMs,omitempty"`
+	SynthesisMs  int64  `json:"synthesisMs,omitempty"`
+}
+
+// NewTranscriptExport builds the session record of a conversation
+func NewTranscriptExport(conv *Conversation) TranscriptExport {
+	export := TranscriptExport{
+		CallSID:    conv.ID,
+		CallerHash: conv.CallerHash,
+		Persona:    conv.CurrentPersona(),
+		Tenant:     conv.CurrentTenant(),
+		StartedAt:  conv.CreatedAt,
+		RiskFlags:  conv.RiskFlags(),
+		Tags:       conv.Tags(),
+		Metadata:   conv.Metadata(),
+		Summary:    conv.CallSummary(),
+		Messages:   []ExportedMessage{},
+		ExportedAt: time.Now(),
+	}
+	if ended := conv.EndedAt(); !ended.IsZero() {
+		export.EndedAt = &ended
+	}
+	for _, msg := range conv.Transcript() {
+		speaker := "Therapist"
+		if msg.Role == "user" {
+			speaker = "Caller"
+		}
+		export.Messages = append(export.Messages, ExportedMessage{
+			Time:         msg.CreatedAt,
+			Speaker:      speaker,
+			Text:         msg.Content,
+			Original:     msg.Original,
+			Language:     msg.Language,
+			Source:       msg.Source,
+			Confidence:   msg.Confidence,
+			Model:        msg.Model,
+			GenerationMs: msg.GenerationLatency.Milliseconds(),
+			SynthesisMs:  msg.SynthesisLatency.Milliseconds(),
+		})
+	}
+	return export
+}
+
+// ExportTranscript renders the conversation's session record in the format, returning
+// the document and its content type
+func ExportTranscript(conv *Conversation, format string) ([]byte, string, error) {
+	return NewTranscriptExport(conv).Render(format)
+}
+
+// Render renders the session record in the format, returning the document and its
+// content type
+func (t TranscriptExport) Render(format string) ([]byte, string, error) {
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown export format %q, use json, txt or pdf", format)
+	}
+	switch format {
+	case ExportText:
+		return []byte(strings.Join(t.Lines(), "\n") + "\n"), contentType, nil
+	case ExportPDF:
+		return renderPDF("Session record "+t.CallSID, t.Lines()), contentType, nil
+	default:
+		data, err := json.MarshalIndent(t, "", "  ")
+		return data, contentType, err
+	}
+}
+
+// Lines lays out the session record as plain text: a header with the call's metadata and
+// summary, then each message with its time and speaker. Times are in UTC.
+func (t TranscriptExport) Lines() []string {
+	stamp := func(at time.Time) string {
+		if at.IsZero() {
+			return "--:--:--"
+		}
+		return at.UTC().Format(time.TimeOnly)
+	}
+
+	lines := []string{
+		"Session record of call " + t.CallSID,
+		"Started: " + t.StartedAt.UTC().Format("2006-01-02 15:04:05 MST"),
+	}
+	if t.CallerHash != "" {
+		lines = append(lines, "Caller: "+t.CallerHash)
+	}
+	if t.Persona != "" {
+		lines = append(lines, "Persona: "+t.Persona)
+	}
+	if len(t.RiskFlags) > 0 {
+		lines = append(lines, "Risk flags: "+strings.Join(t.RiskFlags, ", "))
+	}
+	if len(t.Tags) > 0 {
+		lines = append(lines, "Tags: "+strings.Join(t.Tags, ", "))
+	}
+	lines = append(lines, "Exported: "+t.ExportedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+
+	if s := t.Summary; s != nil {
+		lines = append(lines, "", "Summary: "+s.Overview)
+		if len(s.Topics) > 0 {
+			lines = append(lines, "Topics: "+strings.Join(s.Topics, ", "))
+		}
+		if len(s.FollowUps) > 0 {
+			lines = append(lines, "Follow-ups: "+strings.Join(s.FollowUps, "; "))
+		}
+	}
+
+	lines = append(lines, "")
+	if len(t.Messages) == 0 {
+		lines = append(lines, "No messages were exchanged.")
+	}
+	for _, msg := range t.Messages {
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", stamp(msg.Time), msg.Speaker, msg.Text))
+		if msg.Original != "" {
+			lines = append(lines, fmt.Sprintf("           (%s) %s", msg.Language, msg.Original))
+		}
+		if details := msg.details(); details != "" {
+			lines = append(lines, "           "+details)
+		}
+	}
+	return lines
+}
+
+// details describes how the message went through the pipeline, e.g. "final, confidence
+// 0.92" for the caller or "gemini-1.5-pro, generated in 1.2s, first audio in 0.4s"
+func (m ExportedMessage) details() string {
+	var details []string
+	if m.Source != "" {
+		details = append(details, m.Source)
+	}
+	if m.Confidence > 0 {
+		details = append(details, fmt.Sprintf("confidence %.2f", m.Confidence))
+	}
+	if m.Model != "" {
+		details = append(details, m.Model)
+	}
+	if m.GenerationMs > 0 {
+		details = append(details, fmt.Sprintf("generated in %.1fs", float64(m.GenerationMs)/1000))
+	}
+	if m.SynthesisMs > 0 {
+		details = append(details, fmt.Sprintf("first audio in %.1fs", float64(m.SynthesisMs)/1000))
+	}
+	return strings.Join(details, ", ")
+}
+
+// Layout of exported PDFs: US Letter pages of 10pt Courier, which is 6pt wide a character
+const (
+	pdfPageWidth  = 612
+	pdfPageHeight = 792
+	pdfMargin     = 54
+	pdfFontSize   = 10
+	pdfLeading    = 13
+	pdfLineChars  = (pdfPageWidth - 2*pdfMargin) / 6
+	pdfPageLines  = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+)
+
+// renderPDF lays the lines out on as many pages as they need, wrapping long ones. It
+// writes the few PDF objects a text document needs itself rather than pull in a library.
+func renderPDF(title string, lines []string) []byte {
+	var wrapped []string
+	for _, line := range lines {
+		wrapped = append(wrapped, wrapLine(line, pdfLineChars)...)
+	}
+	var pages [][]string
+	for len(wrapped) > pdfPageLines {
+		pages = append(pages, wrapped[:pdfPageLines])
+		wrapped = wrapped[pdfPageLines:]
+	}
+	pages = append(pages, wrapped)
+
+	// Objects 1 to 4 are the catalog, the page tree, the font and the document info;
+	// each page is followed by its content stream
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title (%s) /Producer (call-me-help) >>", pdfString(title)),
+	)
+	for i, page := range pages {
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin-pdfFontSize)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+		}
+		content.WriteString("ET")
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+
+	var doc bytes.Buffer
+	doc.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = doc.Len()
+		fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := doc.Len()
+	fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&doc, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return doc.Bytes()
+}
+
+// wrapLine breaks a line into lines of at most width characters at spaces, keeping its
+// indentation and indenting the continuations further; words too long for a line are split
+func wrapLine(line string, width int) []string {
+	indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+	if len(indent) > width/2 {
+		indent = indent[:width/2]
+	}
+	var lines []string
+	current, empty := indent, true
+	for _, word := range strings.Fields(line) {
+		for word != "" {
+			sep := " "
+			if empty {
+				sep = ""
+			}
+			runes := []rune(word)
+			room := width - utf8.RuneCountInString(current) - len(sep)
+			if len(runes) <= room {
+				current, empty, word = current+sep+word, false, ""
+				continue
+			}
+			if (empty || len(runes) > width-len(indent)-4) && room > 0 {
+				current, word = current+sep+string(runes[:room]), string(runes[room:])
+			}
+			lines = append(lines, current)
+			current, empty = indent+"    ", true
+		}
+	}
+	if !empty || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// winAnsiPunctuation maps the typographic characters WinAnsiEncoding has outside Latin-1
+var winAnsiPunctuation = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfString encodes text for a PDF literal string in WinAnsiEncoding, escaping what the
+// syntax needs and writing other bytes in octal so the document stays ASCII. Characters
+// the encoding lacks become question marks.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		c, ok := winAnsiPunctuation[r]
+		switch {
+		case ok:
+		case r == '\t':
+			c = ' '
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		default:
+			c = '?'
+		}
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
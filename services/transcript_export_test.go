@@ -0,0 +1,103 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportConversation() *Conversation {
+	conv := NewConversationService().GetOrCreateConversation("CA123")
+	conv.CreatedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	conv.SetPersona("calm")
+	conv.AddTranslatedUserMessage("I can't sleep (again).", "No puedo dormir (otra vez).", "es-US")
+	conv.AddTherapistMessage("That sounds exhausting — " + strings.Repeat("tell me more ", 12))
+	conv.Messages[0].CreatedAt = conv.CreatedAt.Add(5 * time.Second)
+	conv.Messages[1].CreatedAt = conv.CreatedAt.Add(8 * time.Second)
+	conv.SetLastUserRecognition(MessageSourceFinal, 0.92)
+	conv.SetLastResponseModel("gemini-1.5-pro")
+	conv.SetLastResponseLatency(1234*time.Millisecond, 420*time.Millisecond)
+	conv.FlagRisk(RiskSubstanceUse)
+	return conv
+}
+
+func TestExportTranscriptText(t *testing.T) {
+	data, contentType, err := ExportTranscript(exportConversation(), ExportText)
+	if err != nil || contentType != "text/plain; charset=utf-8" {
+		t.Fatalf("Expected a text export, got %q, %v", contentType, err)
+	}
+	for _, want := range []string{
+		"Session record of call CA123\nStarted: 2024-03-01 12:00:00 UTC\nPersona: calm\nRisk flags: substance_use\n",
+		"\n[12:00:05] Caller: I can't sleep (again).\n           (es-US) No puedo dormir (otra vez).\n           final, confidence 0.92\n[12:00:08] Therapist: That sounds exhausting",
+		"\n           gemini-1.5-pro, generated in 1.2s, first audio in 0.4s\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in the export, got:\n%s", want, data)
+		}
+	}
+
+	if _, _, err := ExportTranscript(exportConversation(), "docx"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestExportTranscriptJSON(t *testing.T) {
+	data, _, err := ExportTranscript(exportConversation(), ExportJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export TranscriptExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Messages) != 2 || export.Messages[0].Speaker != "Caller" || export.Messages[0].Original != "No puedo dormir (otra vez)." || !export.Messages[1].Time.Equal(export.StartedAt.Add(8*time.Second)) || export.Messages[1].GenerationMs != 1234 {
+		t.Errorf("Unexpected export: %+v", export)
+	}
+}
+
+func TestExportTranscriptPDF(t *testing.T) {
+	data, contentType, err := ExportTranscript(exportConversation(), ExportPDF)
+	if err != nil || contentType != "application/pdf" {
+		t.Fatalf("Expected a PDF export, got %q, %v", contentType, err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("Expected a PDF document, got:\n%s", data)
+	}
+	// Readers find objects through the cross-reference table
+	start, _ := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(string(data))[1])
+	if !bytes.HasPrefix(data[start:], []byte("xref\n")) {
+		t.Errorf("Expected startxref to point at the xref table")
+	}
+	for _, entry := range regexp.MustCompile(`(\d{10}) 00000 n`).FindAllStringSubmatch(string(data), -1) {
+		offset, _ := strconv.Atoi(entry[1])
+		if !regexp.MustCompile(`^\d+ 0 obj\n`).Match(data[offset:]) {
+			t.Errorf("Expected an object at offset %d", offset)
+		}
+	}
+	for _, want := range []string{`([12:00:05] Caller: I can't sleep \(again\).) Tj`, `exhausting \227 tell`} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("Expected %q in the PDF", want)
+		}
+	}
+}
+
+func TestWrapLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{"short line", []string{"short line"}},
+		{"one two three four", []string{"one two", "    three", "    four"}},
+		{"  (es) uno dos tres", []string{"  (es) uno", "      dos", "      tres"}},
+		{"abcdefghijklmnop", []string{"abcdefghij", "    klmnop"}},
+		{"", []string{""}},
+	} {
+		if got := wrapLine(tc.line, 10); strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("wrapLine(%q): expected %q, got %q", tc.line, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+	"golang.org/x/oauth2/google"
+)
+
+// Translator translates text between languages, given as BCP-47 codes like "es-MX"
+type Translator interface {
+	Translate(ctx context.Context, text, source, target string) (string, error)
+}
+
+// translationScope is the OAuth scope of the Cloud Translation API
+const translationScope = "https://www.googleapis.com/auth/cloud-translation"
+
+// TranslationService translates with the Google Cloud Translation v3 REST API,
+// authenticating with the application default credentials
+type TranslationService struct {
+	config   *config.Config
+	client   *http.Client
+	endpoint string
+	log      *logger.Logger
+}
+
+// NewTranslationService creates a new translation service
+func NewTranslationService(ctx context.Context) (*TranslationService, error) {
+	log := logger.Component("Translation")
+	log.Info("Creating new Translation service")
+
+	cfg := config.Load()
+	if cfg.GoogleProjectID == "" {
+		log.Error("GOOGLE_PROJECT_ID environment variable not set")
+		return nil, errors.New("GOOGLE_PROJECT_ID is required for translation")
+	}
+
+	client, err := google.DefaultClient(ctx, translationScope)
+	if err != nil {
+		log.Error("Error creating Translation client: %v", err)
+		return nil, err
+	}
+	client.Timeout = 10 * time.Second
+
+	return &TranslationService{
+		config:   cfg,
+		client:   client,
+		endpoint: fmt.Sprintf("https://translation.googleapis.com/v3/projects/%s/locations/global:translateText", cfg.GoogleProjectID),
+		log:      log,
+	}, nil
+}
+
+// Translate translates text from the source to the target language
+func (t *TranslationService) Translate(ctx context.Context, text, source, target string) (string, error) {
+	log := CallLogger(ctx, t.log)
+	if strings.TrimSpace(text) == "" || SameLanguage(source, target) {
+		return text, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"contents":           []string{text},
+		"sourceLanguageCode": source,
+		"targetLanguageCode": target,
+		"mimeType":           "text/plain",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	startTime := time.Now()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		log.Error("Error calling Translation API: %v", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Translation API returned status %d: %s", resp.StatusCode, msg)
+		return "", fmt.Errorf("translation: unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Translations []struct {
+			TranslatedText string `json:"translatedText"`
+		} `json:"translations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Translations) == 0 {
+		return "", errors.New("translation: empty response")
+	}
+
+	log.Debug("Translated %d chars %s -> %s in %v", len(text), source, target, time.Since(startTime))
+	return result.Translations[0].TranslatedText, nil
+}
+
+// SameLanguage reports whether two language codes share a base language, e.g. "en-US" and "en-GB"
+func SameLanguage(a, b string) bool {
+	base := func(code string) string {
+		return strings.ToLower(strings.SplitN(code, "-", 2)[0])
+	}
+	return base(a) == base(b)
+}
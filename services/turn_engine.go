@@ -0,0 +1,1076 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// SpeechSynthesizer converts response text to audio in the call's format
+type SpeechSynthesizer interface {
+	SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error)
+}
+
+// AudioSaver persists synthesized audio for later review
+type AudioSaver interface {
+	SaveAudioToFile(callSID string, text string, audioData []byte, format AudioFormat) error
+}
+
+// TurnAction describes what the AI decided to do with a caller turn
+type TurnAction string
+
+const (
+	// ActionRespond is a regular spoken reply
+	ActionRespond TurnAction = "respond"
+	// ActionClarify asks the caller to repeat or rephrase
+	ActionClarify TurnAction = "clarify"
+	// ActionEscalate hands the caller over to a human or crisis resource
+	ActionEscalate TurnAction = "escalate"
+	// ActionEnd wraps up the call
+	ActionEnd TurnAction = "end"
+)
+
+// CallController acts on a live call outside its media stream
+type CallController interface {
+	HangUp(callSID string) error
+	Transfer(callSID, number string) error
+}
+
+// Turn is the outcome of processing one caller utterance
+type Turn struct {
+	Transcript string
+	Intent     Intent
+	Action     TurnAction
+	Response   string
+	Audio      []byte
+	// Model produced the response, empty for fallback phrases
+	Model string
+	// GenerationLatency is how long the LLM took to respond, SynthesisLatency how long the
+	// response's first audio took to synthesize; 0 when neither was needed
+	GenerationLatency time.Duration
+	SynthesisLatency  time.Duration
+}
+
+// Recognition is how the recognizer heard a caller turn
+type Recognition struct {
+	Words      []WordTiming
+	Source     string  // MessageSourceFinal, or MessageSourceInterim when the turn ended on an interim result
+	Confidence float32 // Lowest confidence of the final results, 0 when none was reported
+}
+
+// TranscriptionBuffer collects the transcripts of the caller's current utterance
+type TranscriptionBuffer struct {
+	LastActivity time.Time
+	// Transcriptions are the interim results since the last final one
+	Transcriptions []string
+	// Finals are the final results of the utterance so far
+	Finals []string
+	// Words are the word timings of the final results
+	Words []WordTiming
+	// Confidence is the lowest confidence reported for the final results, 0 when none was
+	Confidence      float32
+	LastTranscript  string
+	LastFinal       time.Time
+	SpeechEnded     bool
+	ProcessingSince time.Time
+	IsProcessing    bool
+}
+
+// NewTranscriptionBuffer creates a new transcription buffer
+func NewTranscriptionBuffer() *TranscriptionBuffer {
+	return &TranscriptionBuffer{
+		LastActivity:   time.Now(),
+		Transcriptions: make([]string, 0),
+	}
+}
+
+// AddTranscription adds an interim transcription to the buffer; the caller is still talking
+func (tb *TranscriptionBuffer) AddTranscription(transcription string) {
+	tb.LastActivity = time.Now()
+	tb.Transcriptions = append(tb.Transcriptions, transcription)
+	tb.LastTranscript = transcription
+	tb.SpeechEnded = false
+}
+
+// NoteSpeech records that the caller is still talking without buffering what they said
+func (tb *TranscriptionBuffer) NoteSpeech() {
+	tb.LastActivity = time.Now()
+	tb.SpeechEnded = false
+}
+
+// AddFinal adds a final transcription and its word timings, replacing the interim results it settles
+func (tb *TranscriptionBuffer) AddFinal(transcription string, words ...WordTiming) {
+	tb.addFinal(transcription, 0, words)
+}
+
+// addFinal adds a final transcription with its confidence; providers report 0 when they have none
+func (tb *TranscriptionBuffer) addFinal(transcription string, confidence float32, words []WordTiming) {
+	if confidence > 0 && (tb.Confidence == 0 || confidence < tb.Confidence) {
+		tb.Confidence = confidence
+	}
+	tb.LastActivity = time.Now()
+	tb.LastFinal = tb.LastActivity
+	tb.Finals = append(tb.Finals, transcription)
+	tb.Words = append(tb.Words, words...)
+	tb.Transcriptions = make([]string, 0)
+	tb.LastTranscript = transcription
+}
+
+// MarkSpeechEnded records that the provider detected the end of the caller's speech
+func (tb *TranscriptionBuffer) MarkSpeechEnded() {
+	tb.SpeechEnded = true
+}
+
+// ShouldProcess determines if the buffer should be processed. Final results are answered
+// once the provider signals the end of speech or no more speech follows within finalGrace;
+// interim-only results fall back to waiting for silenceDuration.
+func (tb *TranscriptionBuffer) ShouldProcess(finalGrace, silenceDuration time.Duration) bool {
+	if tb.IsProcessing {
+		return false
+	}
+	if len(tb.Transcriptions) > 0 {
+		return time.Since(tb.LastActivity) > silenceDuration
+	}
+	return len(tb.Finals) > 0 && (tb.SpeechEnded || time.Since(tb.LastFinal) >= finalGrace)
+}
+
+// StartProcessing marks the buffer as being processed
+func (tb *TranscriptionBuffer) StartProcessing() {
+	tb.ProcessingSince = time.Now()
+	tb.IsProcessing = true
+}
+
+// FinishProcessing resets the buffer after processing
+func (tb *TranscriptionBuffer) FinishProcessing() {
+	tb.Transcriptions = make([]string, 0)
+	tb.Finals = nil
+	tb.Words = nil
+	tb.Confidence = 0
+	tb.SpeechEnded = false
+	tb.IsProcessing = false
+}
+
+// Recognition returns how the buffered utterance was heard
+func (tb *TranscriptionBuffer) Recognition() Recognition {
+	source := MessageSourceFinal
+	if n := len(tb.Transcriptions); n > 0 && strings.TrimSpace(tb.Transcriptions[n-1]) != "" {
+		source = MessageSourceInterim
+	}
+	return Recognition{Words: tb.Words, Source: source, Confidence: tb.Confidence}
+}
+
+// NormalizeTranscriptions joins the final results with the latest interim one
+func (tb *TranscriptionBuffer) NormalizeTranscriptions() string {
+	parts := make([]string, 0, len(tb.Finals)+1)
+	for _, final := range tb.Finals {
+		if final = strings.TrimSpace(final); final != "" {
+			parts = append(parts, final)
+		}
+	}
+
+	// Interim results are cumulative, so the last one is the most complete
+	if len(tb.Transcriptions) > 0 {
+		if interim := strings.TrimSpace(tb.Transcriptions[len(tb.Transcriptions)-1]); interim != "" {
+			parts = append(parts, interim)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// TurnEngine turns the stream of transcriptions for a call into AI turns
+type TurnEngine struct {
+	Channels     *ChannelData
+	Conversation *Conversation
+	Generator    ResponseGenerator
+	Synthesizer  SpeechSynthesizer
+	// AudioSaver is optional; when set, synthesized audio is persisted
+	AudioSaver AudioSaver
+	// Fallbacks supplies the phrases spoken when no response could be generated
+	Fallbacks *FallbackLibrary
+	// Language selects the fallback phrases; empty uses the library default. A language
+	// the recognizer detects the caller speaking replaces it for the rest of the call.
+	Language string
+	// LanguageVoices are the voices of the languages callers may be detected speaking;
+	// languages without one use the TTS provider's default voice for them
+	LanguageVoices LanguageVoices
+	// Translator, when set and Language differs from PivotLanguage, translates the caller
+	// into the pivot language for the LLM and the responses back into Language
+	Translator    Translator
+	PivotLanguage string
+	// Masker, when set, hides sensitive terms in the messages stored in the conversation
+	Masker *TermMasker
+	// History, when set, keeps the history sent to the LLM within a token budget
+	History *HistoryBudget
+	// Tools, when set and the generator can call them, lets the LLM take actions for the
+	// caller; those turns are answered once the tool results are back, without streaming
+	Tools *ToolDispatcher
+	// Guardrail, when set, checks responses before they are spoken
+	Guardrail *ResponseGuardrail
+	// Actions, when set, has the LLM reply with speech and actions to take, e.g. flagging a
+	// risk or ending the call, and runs them; those turns are answered without streaming
+	Actions *ActionDispatcher
+	// Budget, when set, keeps the call's LLM usage within its ceilings; usage is tracked either way
+	Budget *TokenBudget
+	// Caller is what was known about the caller when the call started, for the prompt
+	Caller PromptCaller
+	// Calls, when set, ends calls the caller says goodbye to and transfers those asking
+	// for a person to TransferNumber, once the response has been heard
+	Calls          CallController
+	TransferNumber string
+	// MinConfidence is the confidence below which final results are not answered and the
+	// caller is asked to repeat instead, and interim results are ignored; 0 accepts everything
+	MinConfidence float32
+	// MinStability is the stability below which interim results are ignored, as they are
+	// likely to change; 0 accepts everything
+	MinStability float32
+
+	// FinalGrace is how long to wait after a final result for the caller to continue
+	// when the provider doesn't signal the end of speech
+	FinalGrace time.Duration
+	// SilenceDuration is how long the caller must be quiet before we respond to interim results
+	SilenceDuration time.Duration
+	// TickInterval is how often the end-of-turn detector runs
+	TickInterval time.Duration
+	// SynthesisWorkers is how many sentences of a response are synthesized at once
+	SynthesisWorkers int
+	// BackchannelDelay is how long a response can take before a short acknowledgement is
+	// played, so the line doesn't feel dead; 0 never plays one
+	BackchannelDelay time.Duration
+
+	// OnTurn, when set, is called after every completed turn
+	OnTurn func(Turn)
+	// Events, when set, publishes what was said and answered on every turn
+	Events EventPublishers
+	// Errors, when set, reports panics of the goroutines speaking responses, which are
+	// recovered either way
+	Errors *ErrorReporter
+	// SLOs, when set, tracks the turns' generation and synthesis latencies
+	SLOs *SLOTracker
+	// Audit, when set, records the calls the engine ends or transfers
+	Audit *AuditLog
+
+	fallbackCount    map[FailureType]int // Rotation position per failure type
+	backchannelCount int                 // Rotation position of the backchannel phrases
+	turns            int                 // Caller turns processed, numbering them in the logs
+	log              *logger.Logger
+}
+
+// NewTurnEngine creates a turn engine with the default timing
+func NewTurnEngine(channels *ChannelData, conversation *Conversation, generator ResponseGenerator, synthesizer SpeechSynthesizer) *TurnEngine {
+	return &TurnEngine{
+		Channels:         channels,
+		Conversation:     conversation,
+		Generator:        generator,
+		Synthesizer:      synthesizer,
+		Fallbacks:        NewFallbackLibrary("en-US"),
+		FinalGrace:       700 * time.Millisecond,
+		SilenceDuration:  2 * time.Second,
+		TickInterval:     100 * time.Millisecond,
+		SynthesisWorkers: 1,
+		BackchannelDelay: 1500 * time.Millisecond,
+		log:              logger.Component("TurnEngine").WithCall(channels.CallSID).WithCorrelation(channels.CorrelationID),
+	}
+}
+
+// Run processes transcriptions and generates responses until the context is done
+func (e *TurnEngine) Run(ctx context.Context) {
+	callSID := e.Channels.CallSID
+	e.log.Info("Transcription processor started for call %s", callSID)
+
+	// Add a ticker to periodically check if we're receiving transcriptions
+	ticker := time.NewTicker(e.TickInterval)
+	defer ticker.Stop()
+
+	// Create a transcription buffer
+	buffer := NewTranscriptionBuffer()
+
+	// The call's persona opens the conversation when it has a greeting
+	if greeting := e.Channels.Persona().Greeting; greeting != "" {
+		e.greet(ctx, greeting)
+	}
+
+	// Configure end-of-turn detection
+	e.log.Info("End-of-turn detection configured: final grace %v, interim silence %v", e.FinalGrace, e.SilenceDuration)
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.log.Info("Transcription processor context done for call %s", callSID)
+			return
+		case <-ticker.C:
+			// Check if we should process the buffer
+			if buffer.ShouldProcess(e.FinalGrace, e.SilenceDuration) {
+				silenceTime := time.Since(buffer.LastActivity)
+				e.log.Info("End of turn after %v silence (speech ended: %t, finals: %d), processing transcriptions for call %s",
+					silenceTime, buffer.SpeechEnded, len(buffer.Finals), callSID)
+
+				// Mark as processing to avoid concurrent processing
+				buffer.StartProcessing()
+
+				// Normalize transcriptions
+				normalized := buffer.NormalizeTranscriptions()
+				e.log.Info("Normalized transcription for call %s: %q", callSID, logger.Transcript(normalized))
+
+				if normalized != "" {
+					// Process the normalized transcription, unless it was heard too poorly to act on
+					var turn Turn
+					if buffer.Confidence > 0 && buffer.Confidence < e.MinConfidence {
+						turn = e.askToRepeat(ctx, normalized, buffer.Confidence)
+					} else {
+						turn = e.processTurn(ctx, normalized, buffer.Recognition())
+					}
+					if e.OnTurn != nil {
+						e.OnTurn(turn)
+					}
+				}
+
+				// Reset buffer
+				buffer.FinishProcessing()
+			}
+
+			// Periodically log status
+			if time.Since(buffer.LastActivity) > 10*time.Second && len(buffer.Transcriptions) > 0 {
+				e.log.Debug("Transcription buffer status: %d items, last activity %v ago",
+					len(buffer.Transcriptions), time.Since(buffer.LastActivity))
+			}
+
+		case transcript := <-e.Channels.TranscriptionChan:
+			if transcript.Text != "" {
+				e.log.Debug("Transcription received for call %s (final=%t): %q", callSID, transcript.IsFinal, logger.Transcript(transcript.Text))
+				if transcript.IsFinal {
+					e.detectLanguage(transcript.Language)
+					buffer.addFinal(transcript.Text, transcript.Confidence, transcript.Words)
+				} else if e.settled(transcript) {
+					buffer.AddTranscription(transcript.Text)
+				} else {
+					// Too unsure to answer if the turn ended on it, but the caller is talking
+					buffer.NoteSpeech()
+				}
+			}
+			if transcript.EndOfSpeech {
+				e.log.Debug("End of speech detected for call %s", callSID)
+				buffer.MarkSpeechEnded()
+			}
+		}
+	}
+}
+
+// ProcessTranscription runs a single normalized transcription through the LLM and TTS
+func (e *TurnEngine) ProcessTranscription(ctx context.Context, transcription string) Turn {
+	return e.processTurn(ctx, transcription, Recognition{})
+}
+
+// processTurn handles a caller utterance, keeping how it was heard with the stored message
+func (e *TurnEngine) processTurn(ctx context.Context, transcription string, heard Recognition) Turn {
+	callSID := e.Channels.CallSID
+	turn := Turn{Transcript: transcription, Action: ActionRespond}
+	e.turns++
+	log := e.log.WithTurn(e.turns)
+	e.Channels.SetPipelineState(PipelineThinking)
+	defer e.Channels.SetPipelineState(PipelineListening)
+	if CallSIDFromContext(ctx) == "" {
+		ctx = WithCallSID(ctx, callSID)
+	}
+	ctx = e.personaContext(ctx)
+
+	// Keep what the caller said from passing as instructions to the LLM
+	attempts := DetectInjection(transcription)
+	transcription = SanitizeCallerInput(transcription)
+
+	// Translate the caller into the LLM's language and add the user message to the conversation
+	prompt := transcription
+	if e.translating() {
+		translated, err := e.Translator.Translate(ctx, transcription, e.callerLanguage(), e.PivotLanguage)
+		if err != nil {
+			// The LLM can usually still make sense of the original
+			log.Error("Error translating caller for call %s: %v", callSID, err)
+		} else {
+			prompt = SanitizeCallerInput(translated)
+			if len(attempts) == 0 {
+				attempts = DetectInjection(prompt)
+			}
+		}
+		e.Conversation.AddTranslatedUserMessage(e.Masker.Mask(prompt), e.Masker.Mask(transcription), e.callerLanguage(), e.Masker.MaskWords(heard.Words)...)
+		e.Events.Publish(EventTranscriptionFinal, callSID, TranscriptionEvent{
+			Text:       e.Masker.Mask(prompt),
+			Original:   e.Masker.Mask(transcription),
+			Language:   e.callerLanguage(),
+			Source:     heard.Source,
+			Confidence: heard.Confidence,
+		})
+	} else {
+		e.Conversation.AddUserMessage(e.Masker.Mask(transcription), e.Masker.MaskWords(heard.Words)...)
+		e.Events.Publish(EventTranscriptionFinal, callSID, TranscriptionEvent{
+			Text:       e.Masker.Mask(transcription),
+			Source:     heard.Source,
+			Confidence: heard.Confidence,
+		})
+	}
+	e.Conversation.SetLastUserRecognition(heard.Source, heard.Confidence)
+	log.Info("Added user message to conversation for call %s: %q", callSID, logger.Transcript(prompt))
+
+	// What the caller is doing decides how the turn is handled
+	turn.Intent = ClassifyIntent(prompt)
+	log.Info("Caller intent on call %s: %s", callSID, turn.Intent)
+	if tag := IntentTag(turn.Intent); tag != "" {
+		e.Conversation.AddTags(tag)
+	}
+
+	sentiment := AnalyzeSentiment(prompt)
+	e.Conversation.SetLastUserSentiment(sentiment)
+	log.Debug("Caller sentiment on call %s: %.2f %s", callSID, sentiment.Score, sentiment.Emotion)
+
+	// Callers who find us hard to follow can ask us to slow down for the rest of the call
+	if IsSlowDownRequest(prompt) {
+		log.Info("Caller on call %s asked to slow down, speaking rate now %.2fx", callSID, e.Channels.SlowDown())
+	}
+
+	// Get conversation history
+	history := e.Conversation.GetFormattedHistory()
+	if e.History != nil {
+		history = e.History.History(ctx, e.Conversation)
+	}
+	trajectory := e.Conversation.SentimentTrajectory()
+	if note := SentimentNote(trajectory); note != "" {
+		history = append(history, "Context: "+note)
+	}
+	if note := MoodContinuityNote(e.Caller.LastMood, trajectory); note != "" {
+		history = append(history, "Context: "+note)
+	}
+	if len(attempts) > 0 {
+		log.Warn("Caller on call %s tried to change the instructions: %s", callSID, strings.Join(attempts, ", "))
+		history = append(history, "Context: "+injectionNote)
+	}
+	if note := intentNote(turn.Intent, e.canTransfer()); note != "" {
+		history = append(history, "Context: "+note)
+	}
+	ctx = WithPromptData(ctx, PromptData{
+		Traits:    e.Channels.Persona().Traits,
+		Mood:      sentiment.Emotion,
+		MoodScore: sentiment.Score,
+		Caller:    e.Caller,
+	})
+	// A caller detected speaking another language is answered in it, unless translation
+	// already takes care of that
+	if language := e.Channels.Language(); language != "" && !e.translating() {
+		ctx = WithLanguage(ctx, language)
+		history = append(history, "Context: "+LanguageNote(language))
+	}
+	// Calls running out of budget get shorter responses, then the budget model
+	ctx, history, budgetLevel := e.Budget.Limit(ctx, e.Conversation.Usage(), history)
+	log.Debug("Retrieved conversation history for call %s, %d messages", callSID, len(history))
+
+	// Generate AI response, recording which model produced it
+	log.Info("Generating AI response for call %s", callSID)
+	ctx, report := WithGenerationReport(ctx)
+	startTime := time.Now()
+	quiet := e.startBackchannel()
+	defer quiet()
+	var response string
+	var err error
+	var streamed *speechPipeline
+	var actions []AgentAction
+	if generator, ok := e.Generator.(ToolCallingGenerator); ok && e.Tools != nil {
+		toolCtx := WithCallerNumber(ctx, e.Channels.CallerNumber)
+		response, err = generator.GenerateResponseWithTools(toolCtx, prompt, history, e.Tools)
+	} else if e.Actions != nil {
+		// Only the speech of a structured reply is spoken, so it's parsed whole
+		history = append(history, "Context: "+agentReplyInstructions)
+		response, err = e.Generator.GenerateResponse(WithAgentReply(ctx), prompt, history)
+		if err == nil {
+			reply := ParseAgentReply(response)
+			response, actions = reply.Speech, reply.Actions
+		}
+	} else if generator, ok := e.Generator.(StreamingResponseGenerator); ok && !e.translating() {
+		// Speak each sentence as it arrives; translation needs the whole response first
+		response, streamed, err = e.streamResponse(ctx, generator, prompt, history, quiet)
+	} else {
+		response, err = e.Generator.GenerateResponse(ctx, prompt, history)
+	}
+	elapsed := time.Since(startTime)
+	turn.GenerationLatency = elapsed
+	e.recordUsage(report, prompt, history, response, budgetLevel)
+
+	// Check the response is safe to speak; a streamed one was checked a sentence at a time
+	if err == nil && streamed == nil {
+		result := e.Guardrail.Check(response)
+		if len(result.Violations) > 0 {
+			log.Warn("Guardrail flagged the response for call %s: %s", callSID, strings.Join(result.Violations, ", "))
+		}
+		if result.Unsafe {
+			err = ErrUnsafeResponse
+		} else {
+			response = result.Text
+		}
+	}
+
+	var fallback *FallbackPhrase
+	if err != nil {
+		log.Error("Error generating response for call %s: %v (after %v)", callSID, err, elapsed)
+		// Ask the caller to repeat in case of error
+		failure := FailureGeneration
+		switch {
+		case errors.Is(err, ErrUnsafeResponse):
+			failure = FailureUnsafeResponse
+		case errors.Is(err, context.DeadlineExceeded):
+			failure = FailureTimeout
+		}
+		fallback = e.nextFallback(failure)
+	} else if strings.TrimSpace(response) == "" {
+		log.Warn("Empty AI response for call %s after %v", callSID, elapsed)
+		fallback = e.nextFallback(FailureEmptyResponse)
+	} else {
+		log.Info("AI response generated for call %s in %v by %s", callSID, elapsed, report.Model)
+		if report.Fallback {
+			log.Warn("Response for call %s came from the fallback model %s", callSID, report.Model)
+		}
+	}
+
+	// Translate the response back for the caller; fallback phrases are already in their language
+	spoken := response
+	if fallback == nil && e.translating() {
+		spoken, err = e.Translator.Translate(ctx, response, e.PivotLanguage, e.callerLanguage())
+		if err != nil {
+			log.Error("Error translating response for call %s: %v", callSID, err)
+			fallback = e.nextFallback(FailureGeneration)
+		}
+	}
+	if fallback != nil {
+		spoken = fallback.Text
+		turn.Action = ActionClarify
+	} else {
+		turn.Model = report.Model
+		switch {
+		case turn.Intent == IntentGoodbye:
+			turn.Action = ActionEnd
+		case turn.Intent == IntentRequestHuman && e.canTransfer():
+			turn.Action = ActionEscalate
+		}
+		if e.Actions.Dispatch(e.Conversation, e.Channels.CallerNumber, actions) && turn.Action == ActionRespond {
+			turn.Action = ActionEnd
+		}
+	}
+	turn.Response = spoken
+
+	// Add AI response to conversation
+	switch {
+	case fallback != nil:
+		e.Conversation.AddTherapistMessage(e.Masker.Mask(spoken))
+	case e.translating():
+		e.Conversation.AddTranslatedTherapistMessage(e.Masker.Mask(response), e.Masker.Mask(spoken), e.callerLanguage())
+	default:
+		e.Conversation.AddTherapistMessage(e.Masker.Mask(response))
+	}
+	e.Conversation.SetLastResponseModel(turn.Model)
+	log.Info("Added therapist response to conversation for call %s", callSID)
+
+	// A streamed response is already being spoken
+	quiet()
+	if streamed != nil {
+		e.sendText(turn.Response)
+		e.finishSpeech(&turn, streamed)
+	} else {
+		e.speak(ctx, &turn, fallback)
+	}
+	e.Conversation.SetLastResponseLatency(turn.GenerationLatency, turn.SynthesisLatency)
+	e.SLOs.Observe(StageGeneration, turn.GenerationLatency)
+	e.SLOs.Observe(StageSynthesis, turn.SynthesisLatency)
+	e.Events.Publish(EventResponseGenerated, callSID, ResponseEvent{
+		Text:         e.Masker.Mask(turn.Response),
+		Model:        turn.Model,
+		Intent:       turn.Intent,
+		Action:       turn.Action,
+		GenerationMs: turn.GenerationLatency.Milliseconds(),
+		SynthesisMs:  turn.SynthesisLatency.Milliseconds(),
+	})
+	e.followThrough(ctx, turn)
+	return turn
+}
+
+// followThroughMargin is how long past the response's audio a call is ended or transferred
+var followThroughMargin = 500 * time.Millisecond
+
+// followThrough ends a turn's call after a goodbye, or transfers it to a person, once the
+// caller has heard the response. Nothing happens if the call ends first.
+func (e *TurnEngine) followThrough(ctx context.Context, turn Turn) {
+	if e.Calls == nil || (turn.Action != ActionEnd && turn.Action != ActionEscalate) {
+		return
+	}
+	callSID := e.Channels.CallSID
+	playback := time.Duration(len(turn.Audio))*time.Second/time.Duration(e.Channels.GetAudioFormat().BytesPerSecond()) + followThroughMargin
+	e.log.Info("Call %s will %s in %v, after the response is heard", callSID, turn.Action, playback)
+
+	time.AfterFunc(playback, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		var err error
+		if turn.Action == ActionEnd {
+			err = e.Calls.HangUp(callSID)
+		} else {
+			err = e.Calls.Transfer(callSID, e.TransferNumber)
+		}
+		if err != nil {
+			e.log.Error("Failed to %s call %s: %v", turn.Action, callSID, err)
+		} else if turn.Action == ActionEscalate {
+			e.Conversation.AddTags(TagEscalated)
+			e.Audit.RecordSystem(AuditCallTransfer, callSID, map[string]string{"to": e.TransferNumber})
+		} else {
+			e.Audit.RecordSystem(AuditCallEnd, callSID, nil)
+		}
+	})
+}
+
+// startBackchannel plays a pre-synthesized acknowledgement if the response isn't ready to
+// speak within BackchannelDelay. The returned func, which can be called more than once,
+// stops it from playing; once it returns, an acknowledgement has either played or won't.
+func (e *TurnEngine) startBackchannel() (quiet func()) {
+	if e.BackchannelDelay <= 0 || e.Fallbacks == nil {
+		return func() {}
+	}
+
+	var mu sync.Mutex
+	done := false
+	timer := time.AfterFunc(e.BackchannelDelay, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+		done = true
+		// The phrases were pre-synthesized in the default voice only
+		if _, custom := e.speechContext(context.Background()); custom {
+			return
+		}
+		phrase, ok := e.Fallbacks.Backchannel(e.callerLanguage(), e.backchannelCount, e.Channels.GetAudioFormat())
+		if !ok {
+			return
+		}
+		e.backchannelCount++
+		e.log.Info("Response for call %s is taking over %v, playing %q", e.Channels.CallSID, e.BackchannelDelay, phrase.Text)
+		e.sendAudio(phrase.Audio)
+	})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		done = true
+		timer.Stop()
+	}
+}
+
+// canTransfer reports whether callers asking for a person can be put through to one
+func (e *TurnEngine) canTransfer() bool {
+	return e.Calls != nil && e.TransferNumber != ""
+}
+
+// recordUsage adds a generation's estimated tokens and cost to the call's usage. Prompt
+// tokens come from the provider's report, or from the prompt and history when it has none.
+func (e *TurnEngine) recordUsage(report *GenerationReport, prompt string, history []string, response string, level BudgetLevel) {
+	if report.Model == "" && response == "" {
+		return // Nothing reached the LLM
+	}
+	promptTokens := report.PromptTokens
+	if promptTokens == 0 {
+		promptTokens = EstimateTokens(strings.Join(history, "\n") + "\n" + prompt)
+	}
+	responseTokens := EstimateTokens(response)
+	usage := e.Conversation.AddUsage(promptTokens, responseTokens, e.Budget.Cost(promptTokens, responseTokens), level != BudgetOK)
+	e.log.Debug("LLM usage of call %s: %d prompt and %d response tokens, %.4f", e.Channels.CallSID, usage.PromptTokens, usage.ResponseTokens, usage.Cost)
+}
+
+// speak sends the turn's response text to the call and synthesizes it, using the
+// fallback's pre-synthesized audio when there is some. Responses are synthesized a sentence
+// at a time and each sentence is sent as soon as it's ready, so the caller hears the start
+// of a long response while the rest is still being synthesized.
+func (e *TurnEngine) speak(ctx context.Context, turn *Turn, fallback *FallbackPhrase) {
+	callSID := e.Channels.CallSID
+	e.sendText(turn.Response)
+	ctx, custom := e.speechContext(ctx)
+
+	// Convert response to speech, unless it is a fallback pre-synthesized in the default voice
+	if fallback != nil && fallback.Audio != nil && !custom {
+		e.log.Info("Using pre-synthesized fallback audio for call %s", callSID)
+		turn.Audio = fallback.Audio
+		e.sendAudio(turn.Audio)
+		e.saveAudio(turn)
+		return
+	}
+
+	sentences := SplitSentences(turn.Response)
+	e.log.Info("Converting response to speech for call %s, %d sentence(s)", callSID, len(sentences))
+	speech := e.newSpeechPipeline(ctx)
+	for _, sentence := range sentences {
+		speech.Add(sentence)
+	}
+	e.finishSpeech(turn, speech)
+}
+
+// speechContext carries the voice the caller chose, if any, their language and their pace;
+// custom reports whether any differs from the defaults pre-synthesized audio was made with
+func (e *TurnEngine) speechContext(ctx context.Context) (_ context.Context, custom bool) {
+	voice := e.Channels.Voice()
+	if language := e.Channels.Language(); language != "" && !SameLanguage(language, e.Language) {
+		// A chosen voice speaking another language gives way to one of the caller's
+		if locale := voiceLocale(voice); voice == "" || (locale != "" && !SameLanguage(locale, language)) {
+			voice = e.LanguageVoices.For(language)
+		}
+		ctx = WithLanguage(ctx, language)
+		custom = true
+	}
+	if voice != "" {
+		ctx = WithVoice(ctx, voice)
+		custom = true
+	}
+	if rate := e.Channels.SpeakingRate(); rate != 1 {
+		ctx = WithSpeakingRate(ctx, rate)
+		custom = true
+	}
+	return ctx, custom
+}
+
+// finishSpeech waits for the pipeline to finish speaking the turn's response, then keeps
+// and saves its audio
+func (e *TurnEngine) finishSpeech(turn *Turn, speech *speechPipeline) {
+	callSID := e.Channels.CallSID
+	audioData := speech.Finish()
+	if audioData == nil {
+		// Rather than leave the caller in silence, apologize with audio that needs no TTS call
+		if phrase := e.nextFallback(FailureSynthesis); phrase.Audio != nil {
+			e.log.Warn("Playing pre-synthesized audio for call %s after TTS failed", callSID)
+			e.sendAudio(phrase.Audio)
+			turn.Audio = phrase.Audio
+		}
+		return
+	}
+
+	e.log.Info("Text-to-speech conversion completed for call %s in %v, %d bytes",
+		callSID, time.Since(speech.start), len(audioData))
+	turn.Audio = audioData
+	turn.SynthesisLatency = speech.firstAudio
+	e.saveAudio(turn)
+}
+
+// saveAudio saves the turn's audio to a file when an AudioSaver is set
+func (e *TurnEngine) saveAudio(turn *Turn) {
+	if e.AudioSaver == nil {
+		return
+	}
+	callSID := e.Channels.CallSID
+	if err := e.AudioSaver.SaveAudioToFile(callSID, turn.Response, turn.Audio, e.Channels.GetAudioFormat()); err != nil {
+		e.log.Error("Error saving TTS audio to file for call %s: %v", callSID, err)
+		// Continue even if saving fails - this is a non-critical operation
+	}
+}
+
+// streamResponse generates the response with a streaming generator, handing each sentence
+// to a speech pipeline as soon as it is complete so the caller hears the start of the answer
+// while the model is still generating. If the stream fails after sentences were handed
+// over, the response is cut short there rather than replaced, since the caller is already
+// hearing it. The pipeline is nil when nothing was handed over.
+func (e *TurnEngine) streamResponse(ctx context.Context, generator StreamingResponseGenerator, prompt string, history []string, quiet func()) (string, *speechPipeline, error) {
+	speechCtx, _ := e.speechContext(ctx)
+	speech := e.newSpeechPipeline(speechCtx)
+
+	// Each sentence is checked by the guardrail before it is spoken
+	var stream SentenceStream
+	var spoken []string
+	filter := e.Guardrail.NewFilter()
+	say := func(sentence string) {
+		quiet()
+		speech.Add(sentence)
+		spoken = append(spoken, sentence)
+	}
+	response, err := generator.GenerateResponseStream(ctx, prompt, history, func(text string) {
+		for _, sentence := range stream.Write(text) {
+			if sentence = filter.Sentence(sentence); sentence != "" {
+				say(sentence)
+			}
+		}
+	})
+	if err == nil {
+		for _, sentence := range stream.Flush() {
+			if sentence = filter.Sentence(sentence); sentence != "" {
+				say(sentence)
+			}
+		}
+	}
+
+	if violations := filter.Violations(); len(violations) > 0 {
+		e.log.Warn("Guardrail flagged the response for call %s: %s", e.Channels.CallSID, strings.Join(violations, ", "))
+	}
+	if filter.Unsafe() {
+		if len(spoken) == 0 {
+			speech.Finish()
+			return "", nil, ErrUnsafeResponse
+		}
+		// Part of the response was already spoken, so follow it with the safety message
+		say(e.nextFallback(FailureUnsafeResponse).Text)
+	}
+	if len(spoken) == 0 {
+		speech.Finish()
+		return response, nil, err
+	}
+	for _, sentence := range filter.Finish() {
+		say(sentence)
+	}
+	if err != nil {
+		e.log.Warn("Response stream for call %s failed after %d sentence(s), keeping them: %v",
+			e.Channels.CallSID, len(spoken), err)
+	}
+	if err != nil || strings.TrimSpace(response) == "" || len(filter.Violations()) > 0 {
+		response = strings.Join(spoken, " ")
+	}
+	return response, speech, nil
+}
+
+// sentenceAudio is the synthesized audio of one sentence of a response
+type sentenceAudio struct {
+	audio  []byte
+	err    error
+	queued time.Time // When the sentence was queued for synthesis
+}
+
+// speechPipeline synthesizes a response's sentences as they are added, with up to
+// SynthesisWorkers requests in flight, and sends their audio to the call in order, so the
+// first sentence can play while later ones are still being synthesized
+type speechPipeline struct {
+	e      *TurnEngine
+	ctx    context.Context
+	cancel context.CancelFunc
+	format AudioFormat
+	start  time.Time
+	// emotion is how the caller last sounded, which the sentences' delivery adapts to
+	emotion string
+
+	pending chan string             // Sentences waiting for a synthesis slot
+	queue   chan chan sentenceAudio // Results in the order the sentences were added
+	done    chan struct{}           // Closed once every result has been played
+	audio   []byte                  // Audio sent so far
+	// firstAudio is how long the first sentence took from being queued to being ready
+	firstAudio time.Duration
+}
+
+// newSpeechPipeline starts a pipeline speaking into the call's channels
+func (e *TurnEngine) newSpeechPipeline(ctx context.Context) *speechPipeline {
+	workers := e.SynthesisWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &speechPipeline{
+		e:       e,
+		ctx:     ctx,
+		cancel:  cancel,
+		format:  e.Channels.GetAudioFormat(),
+		start:   time.Now(),
+		emotion: e.callerEmotion(),
+		pending: make(chan string, 64),
+		queue:   make(chan chan sentenceAudio, 64),
+		done:    make(chan struct{}),
+	}
+	go p.dispatch(workers)
+	go p.play()
+	return p
+}
+
+// Add queues a sentence to be synthesized and spoken after the ones before it
+func (p *speechPipeline) Add(sentence string) {
+	p.pending <- sentence
+}
+
+// Finish waits until every added sentence has been spoken and returns the audio sent,
+// nil when there was none
+func (p *speechPipeline) Finish() []byte {
+	close(p.pending)
+	<-p.done
+	p.cancel()
+	return p.audio
+}
+
+// dispatch starts a synthesis request for each sentence once a slot is free
+func (p *speechPipeline) dispatch(workers int) {
+	defer p.e.Errors.Recover(p.ctx)
+	defer close(p.queue)
+	slots := make(chan struct{}, workers)
+	n := 0
+	for sentence := range p.pending {
+		// Each sentence is delivered for what it does, after a pause when it isn't the first
+		prosody := ProsodyFor(p.emotion, ClassifySpeechIntent(sentence))
+		prosody.lead = n > 0
+		ctx := WithProsody(p.ctx, prosody)
+		n++
+
+		result := make(chan sentenceAudio, 1)
+		queued := time.Now()
+		p.queue <- result
+		select {
+		case slots <- struct{}{}:
+		case <-p.ctx.Done():
+			result <- sentenceAudio{err: p.ctx.Err()}
+			continue
+		}
+		go func(sentence string) {
+			defer func() { <-slots }()
+			defer func() {
+				if value := recover(); value != nil {
+					p.e.Errors.ReportPanic(ctx, value)
+					result <- sentenceAudio{err: fmt.Errorf("synthesis panicked: %v", value), queued: queued}
+				}
+			}()
+			audio, err := p.e.Synthesizer.SynthesizeSpeech(ctx, sentence, p.format)
+			result <- sentenceAudio{audio: audio, err: err, queued: queued}
+		}(sentence)
+	}
+}
+
+// play sends each sentence's audio as soon as it and the ones before it are ready
+func (p *speechPipeline) play() {
+	defer p.e.Errors.Recover(p.ctx)
+	defer close(p.done)
+	callSID := p.e.Channels.CallSID
+	failed := false
+	i := 0
+	for result := range p.queue {
+		i++
+		sentence := <-result
+		if failed {
+			continue
+		}
+		if sentence.err != nil {
+			// Stop rather than skip, a response with a sentence missing can read wrong
+			p.e.log.Error("Error synthesizing sentence %d for call %s: %v (after %v)",
+				i, callSID, sentence.err, time.Since(p.start))
+			failed = true
+			p.cancel()
+			continue
+		}
+		if i == 1 {
+			p.firstAudio = time.Since(sentence.queued)
+			p.e.log.Info("First audio ready for call %s in %v", callSID, time.Since(p.start))
+		}
+		p.e.sendAudio(sentence.audio)
+		p.audio = append(p.audio, sentence.audio...)
+	}
+}
+
+// sendText sends the response text to the channel
+func (e *TurnEngine) sendText(text string) {
+	callSID := e.Channels.CallSID
+	select {
+	case e.Channels.ResponseTextChan <- text:
+		e.log.Debug("Text response sent to channel for call %s", callSID)
+	default:
+		e.Channels.counters.droppedResponses.Add(1)
+		e.log.Warn("ResponseTextChan is full for call %s, dropping message", callSID)
+	}
+}
+
+// sendAudio sends audio to the channel for the websocket sender to handle
+func (e *TurnEngine) sendAudio(audio []byte) {
+	callSID := e.Channels.CallSID
+	select {
+	case e.Channels.ResponseAudioChan <- audio:
+		e.Channels.SetPipelineState(PipelineSpeaking)
+		e.log.Debug("Audio response of %d bytes sent to channel for call %s", len(audio), callSID)
+	default:
+		e.Channels.counters.droppedResponses.Add(1)
+		e.log.Warn("ResponseAudioChan is full for call %s, dropping audio", callSID)
+	}
+}
+
+// detectLanguage switches the call to the language the recognizer heard the caller speak
+func (e *TurnEngine) detectLanguage(language string) {
+	if language == "" || SameLanguage(language, e.callerLanguage()) {
+		return
+	}
+	e.log.Info("Caller on call %s is speaking %s, switching from %s", e.Channels.CallSID, language, e.callerLanguage())
+	e.Channels.SetLanguage(language)
+}
+
+// callerEmotion returns the emotion the caller last sounded, or "" when none stood out
+func (e *TurnEngine) callerEmotion() string {
+	trajectory := e.Conversation.SentimentTrajectory()
+	if len(trajectory) == 0 {
+		return ""
+	}
+	return trajectory[len(trajectory)-1].Emotion
+}
+
+// callerLanguage returns the language the caller was detected speaking, or the configured one
+func (e *TurnEngine) callerLanguage() string {
+	if language := e.Channels.Language(); language != "" {
+		return language
+	}
+	return e.Language
+}
+
+// personaContext carries the call's persona, which can change mid-call, to the LLM
+func (e *TurnEngine) personaContext(ctx context.Context) context.Context {
+	persona := e.Channels.Persona()
+	if persona.Name == "" {
+		return ctx
+	}
+	ctx = WithPersona(ctx, persona.Name)
+	if persona.DisplayName != "" {
+		ctx = WithPersonaName(ctx, persona.DisplayName)
+	}
+	return ctx
+}
+
+// greet speaks the persona's greeting and adds it to the conversation, so the LLM knows
+// what the caller is answering
+func (e *TurnEngine) greet(ctx context.Context, greeting string) {
+	e.log.Info("Greeting caller on call %s as %s", e.Channels.CallSID, e.Channels.Persona().Name)
+	e.Conversation.AddTherapistMessage(greeting)
+	turn := Turn{Action: ActionRespond, Response: greeting}
+	e.speak(WithCallSID(ctx, e.Channels.CallSID), &turn, nil)
+}
+
+// settled reports whether an interim result is confident and stable enough to answer if the
+// caller stops on it; providers report 0 for what they don't measure, which passes
+func (e *TurnEngine) settled(transcript Transcript) bool {
+	if transcript.Confidence > 0 && transcript.Confidence < e.MinConfidence {
+		return false
+	}
+	return transcript.Stability == 0 || transcript.Stability >= e.MinStability
+}
+
+// askToRepeat answers a poorly recognized utterance with a clarifying question instead of
+// passing a likely mis-transcription to the LLM
+func (e *TurnEngine) askToRepeat(ctx context.Context, transcription string, confidence float32) Turn {
+	e.log.Info("Asking caller to repeat on call %s: confidence %.2f below %.2f for %q",
+		e.Channels.CallSID, confidence, e.MinConfidence, logger.Transcript(transcription))
+
+	fallback := e.nextFallback(FailureLowConfidence)
+	turn := Turn{Transcript: transcription, Action: ActionClarify, Response: fallback.Text}
+	e.speak(ctx, &turn, fallback)
+	return turn
+}
+
+// translating reports whether the caller's language differs from the LLM's
+func (e *TurnEngine) translating() bool {
+	language := e.callerLanguage()
+	return e.Translator != nil && language != "" && e.PivotLanguage != "" && !SameLanguage(language, e.PivotLanguage)
+}
+
+// nextFallback picks the next fallback phrase for the failure, rotating so repeated
+// failures on a call don't repeat the same words
+func (e *TurnEngine) nextFallback(failure FailureType) *FallbackPhrase {
+	if e.Fallbacks == nil {
+		e.Fallbacks = NewFallbackLibrary("en-US")
+	}
+	if e.fallbackCount == nil {
+		e.fallbackCount = make(map[FailureType]int)
+	}
+
+	phrase := e.Fallbacks.Phrase(e.callerLanguage(), failure, e.fallbackCount[failure], e.Channels.GetAudioFormat())
+	e.fallbackCount[failure]++
+	return &phrase
+}
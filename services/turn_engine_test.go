@@ -0,0 +1,726 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGenerator is a mocked ResponseGenerator with scripted replies
+type fakeGenerator struct {
+	mu      sync.Mutex
+	replies map[string]string
+	err     error
+	calls   []string
+}
+
+func (f *fakeGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, userMessage)
+	if f.err != nil {
+		return "", f.err
+	}
+	if reply, ok := f.replies[userMessage]; ok {
+		return reply, nil
+	}
+	return "Tell me more.", nil
+}
+
+// fakeSynthesizer is a mocked SpeechSynthesizer that "speaks" the text bytes
+type fakeSynthesizer struct {
+	err error
+}
+
+func (f *fakeSynthesizer) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte(text), nil
+}
+
+// scriptEvent is a transcript delivered after a delay from the previous event
+type scriptEvent struct {
+	after      time.Duration
+	transcript Transcript
+}
+
+// expectedTurn is an AI action the script expects, in order
+type expectedTurn struct {
+	action     TurnAction
+	transcript string
+	response   string
+}
+
+// callScript scripts a call as a sequence of timed transcript events and expected
+// AI actions, and runs it against a TurnEngine with mocked providers
+type callScript struct {
+	t           *testing.T
+	generator   *fakeGenerator
+	synthesizer *fakeSynthesizer
+	silence     time.Duration
+	timeout     time.Duration
+	configure   []func(*TurnEngine)
+	events      []scriptEvent
+	expected    []expectedTurn
+}
+
+// newCallScript creates a script with fast timings suitable for unit tests
+func newCallScript(t *testing.T) *callScript {
+	return &callScript{
+		t:           t,
+		generator:   &fakeGenerator{replies: map[string]string{}},
+		synthesizer: &fakeSynthesizer{},
+		silence:     40 * time.Millisecond,
+		timeout:     2 * time.Second,
+	}
+}
+
+// Reply scripts the generator's answer to a transcript
+func (s *callScript) Reply(transcript, response string) *callScript {
+	s.generator.replies[transcript] = response
+	return s
+}
+
+// FailGeneration makes every generator call fail with err
+func (s *callScript) FailGeneration(err error) *callScript {
+	s.generator.err = err
+	return s
+}
+
+// Configure applies extra settings to the engine before the call starts
+func (s *callScript) Configure(fn func(*TurnEngine)) *callScript {
+	s.configure = append(s.configure, fn)
+	return s
+}
+
+// Say delivers an interim transcript after the given delay
+func (s *callScript) Say(after time.Duration, text string) *callScript {
+	s.events = append(s.events, scriptEvent{after: after, transcript: Transcript{Text: text}})
+	return s
+}
+
+// SayFinal delivers a final transcript after the given delay
+func (s *callScript) SayFinal(after time.Duration, text string) *callScript {
+	s.events = append(s.events, scriptEvent{after: after, transcript: Transcript{Text: text, IsFinal: true}})
+	return s
+}
+
+// SayFinalWithConfidence delivers a final transcript with the provider's confidence after the given delay
+func (s *callScript) SayFinalWithConfidence(after time.Duration, text string, confidence float32) *callScript {
+	s.events = append(s.events, scriptEvent{after: after, transcript: Transcript{Text: text, IsFinal: true, Confidence: confidence}})
+	return s
+}
+
+// SayInterim delivers an interim transcript with the provider's confidence and stability after the given delay
+func (s *callScript) SayInterim(after time.Duration, text string, confidence, stability float32) *callScript {
+	s.events = append(s.events, scriptEvent{after: after, transcript: Transcript{Text: text, Confidence: confidence, Stability: stability}})
+	return s
+}
+
+// EndSpeech delivers the provider's end-of-speech signal after the given delay
+func (s *callScript) EndSpeech(after time.Duration) *callScript {
+	s.events = append(s.events, scriptEvent{after: after, transcript: Transcript{EndOfSpeech: true}})
+	return s
+}
+
+// Pause waits long enough for the engine to treat the caller as silent
+func (s *callScript) Pause() time.Duration {
+	return s.silence * 4
+}
+
+// ExpectRespond expects a regular reply to the transcript
+func (s *callScript) ExpectRespond(transcript, response string) *callScript {
+	s.expected = append(s.expected, expectedTurn{action: ActionRespond, transcript: transcript, response: response})
+	return s
+}
+
+// ExpectClarify expects the AI to ask the caller to repeat the transcript
+func (s *callScript) ExpectClarify(transcript string) *callScript {
+	s.expected = append(s.expected, expectedTurn{action: ActionClarify, transcript: transcript})
+	return s
+}
+
+// ExpectEscalate expects the AI to escalate after the transcript
+func (s *callScript) ExpectEscalate(transcript string) *callScript {
+	s.expected = append(s.expected, expectedTurn{action: ActionEscalate, transcript: transcript})
+	return s
+}
+
+// ExpectEnd expects the AI to end the call after the transcript
+func (s *callScript) ExpectEnd(transcript string) *callScript {
+	s.expected = append(s.expected, expectedTurn{action: ActionEnd, transcript: transcript})
+	return s
+}
+
+// Run plays the script and checks the turns the engine produced
+func (s *callScript) Run() []Turn {
+	s.t.Helper()
+
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+
+	engine := NewTurnEngine(channels, conversation, s.generator, s.synthesizer)
+	engine.SilenceDuration = s.silence
+	engine.FinalGrace = s.silence / 2
+	engine.TickInterval = s.silence / 8
+	for _, fn := range s.configure {
+		fn(engine)
+	}
+
+	turns := make(chan Turn, len(s.expected)+8)
+	engine.OnTurn = func(turn Turn) { turns <- turn }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	go func() {
+		for _, event := range s.events {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(event.after):
+			}
+			channels.TranscriptionChan <- event.transcript
+		}
+	}()
+
+	var got []Turn
+	deadline := time.After(s.timeout)
+	for len(got) < len(s.expected) {
+		select {
+		case turn := <-turns:
+			got = append(got, turn)
+		case <-deadline:
+			s.t.Fatalf("timed out after %d of %d expected turns: %+v", len(got), len(s.expected), got)
+		}
+	}
+
+	// Give the engine a chance to produce turns nobody expected
+	select {
+	case turn := <-turns:
+		s.t.Fatalf("unexpected extra turn: %+v", turn)
+	case <-time.After(s.Pause()):
+	}
+
+	for i, want := range s.expected {
+		if got[i].Action != want.action {
+			s.t.Errorf("turn %d: expected action %s, got %s", i, want.action, got[i].Action)
+		}
+		if got[i].Transcript != want.transcript {
+			s.t.Errorf("turn %d: expected transcript %q, got %q", i, want.transcript, got[i].Transcript)
+		}
+		if want.response != "" && got[i].Response != want.response {
+			s.t.Errorf("turn %d: expected response %q, got %q", i, want.response, got[i].Response)
+		}
+	}
+	return got
+}
+
+func TestTurnEngineRespondsAfterSilence(t *testing.T) {
+	newCallScript(t).
+		Reply("I feel anxious today", "I'm sorry to hear that. What's on your mind?").
+		Say(0, "I feel anxious today").
+		ExpectRespond("I feel anxious today", "I'm sorry to hear that. What's on your mind?").
+		Run()
+}
+
+func TestTurnEngineMergesInterimTranscripts(t *testing.T) {
+	s := newCallScript(t)
+	s.Say(0, "I feel").
+		Say(5*time.Millisecond, "I feel like").
+		Say(5*time.Millisecond, "I feel like nobody listens").
+		ExpectRespond("I feel like nobody listens", "").
+		Run()
+}
+
+func TestTurnEngineSplitsTurnsOnPause(t *testing.T) {
+	s := newCallScript(t)
+	s.Say(0, "hello").
+		Say(s.Pause(), "I had a rough week").
+		ExpectRespond("hello", "").
+		ExpectRespond("I had a rough week", "").
+		Run()
+}
+
+func TestTurnEngineRespondsToFinalWithoutWaitingForSilence(t *testing.T) {
+	newCallScript(t).
+		// Interim-only silence detection would never fire within the test timeout
+		Configure(func(e *TurnEngine) { e.SilenceDuration = time.Minute }).
+		SayFinal(0, "I can't sleep").
+		ExpectRespond("I can't sleep", "").
+		Run()
+}
+
+func TestTurnEngineJoinsFinalsWithinGrace(t *testing.T) {
+	newCallScript(t).
+		SayFinal(0, "I lost my job").
+		SayFinal(5*time.Millisecond, "and I don't know what to do").
+		ExpectRespond("I lost my job and I don't know what to do", "").
+		Run()
+}
+
+func TestTurnEngineWaitsWhileCallerKeepsTalking(t *testing.T) {
+	s := newCallScript(t)
+	s.Configure(func(e *TurnEngine) { e.FinalGrace = time.Millisecond }).
+		SayFinal(0, "I think").
+		Say(0, "that").
+		Say(5*time.Millisecond, "that nobody cares").
+		ExpectRespond("I think that nobody cares", "").
+		Run()
+}
+
+func TestTurnEngineRespondsAtEndOfSpeech(t *testing.T) {
+	newCallScript(t).
+		Configure(func(e *TurnEngine) { e.FinalGrace = time.Minute }).
+		SayFinal(0, "hello").
+		EndSpeech(5*time.Millisecond).
+		ExpectRespond("hello", "").
+		Run()
+}
+
+func TestTurnEngineClarifiesWhenGenerationFails(t *testing.T) {
+	turns := newCallScript(t).
+		FailGeneration(errors.New("model unavailable")).
+		Say(0, "can you help me").
+		ExpectClarify("can you help me").
+		Run()
+
+	expected := defaultFallbackPhrases["en-US"][FailureGeneration][0]
+	if turns[0].Response != expected {
+		t.Errorf("Expected clarify response %q, got %q", expected, turns[0].Response)
+	}
+}
+
+func TestTurnEngineRotatesFallbackPhrases(t *testing.T) {
+	s := newCallScript(t)
+	turns := s.FailGeneration(errors.New("model unavailable")).
+		Say(0, "hello").
+		Say(s.Pause(), "hello again").
+		ExpectClarify("hello").
+		ExpectClarify("hello again").
+		Run()
+
+	if turns[0].Response == turns[1].Response {
+		t.Errorf("Expected different fallback phrases for repeated failures, got %q twice", turns[0].Response)
+	}
+}
+
+func TestTurnEngineAsksToRepeatLowConfidenceFinals(t *testing.T) {
+	s := newCallScript(t)
+	turns := s.Configure(func(e *TurnEngine) { e.MinConfidence = 0.6 }).
+		Reply("I feel anxious", "Tell me more about that.").
+		SayFinalWithConfidence(0, "I feel and shush", 0.3).
+		EndSpeech(0).
+		SayFinalWithConfidence(s.Pause(), "I feel anxious", 0.9).
+		EndSpeech(0).
+		ExpectClarify("I feel and shush").
+		ExpectRespond("I feel anxious", "Tell me more about that.").
+		Run()
+
+	expected := defaultFallbackPhrases["en-US"][FailureLowConfidence][0]
+	if turns[0].Response != expected {
+		t.Errorf("Expected clarify response %q, got %q", expected, turns[0].Response)
+	}
+	s.generator.mu.Lock()
+	defer s.generator.mu.Unlock()
+	if len(s.generator.calls) != 1 || s.generator.calls[0] != "I feel anxious" {
+		t.Errorf("Expected only the confident transcript to reach the generator, got %v", s.generator.calls)
+	}
+}
+
+func TestTurnEngineIgnoresUnsettledInterims(t *testing.T) {
+	newCallScript(t).
+		Configure(func(e *TurnEngine) { e.MinConfidence = 0.6; e.MinStability = 0.5 }).
+		SayInterim(0, "I feel", 0, 0.9).
+		SayInterim(5*time.Millisecond, "I feel lonely at night", 0.8, 0.6).
+		SayInterim(5*time.Millisecond, "I feel lonely at nine", 0.8, 0.1).
+		SayInterim(5*time.Millisecond, "I feel lonely at night time", 0.3, 0).
+		ExpectRespond("I feel lonely at night", "").
+		Run()
+}
+
+func TestTurnEngineEscalatesAndEndsCalls(t *testing.T) {
+	s := newCallScript(t)
+	calls := &callRecorder{done: make(chan string, 2)}
+	s.Configure(func(e *TurnEngine) { e.Calls = calls; e.TransferNumber = "+15550100" }).
+		Say(0, "Can I speak to a human?").
+		Say(s.Pause(), "Thanks, bye").
+		ExpectEscalate("Can I speak to a human?").
+		ExpectEnd("Thanks, bye").
+		Run()
+}
+
+func TestTurnEngineRespondsWithoutAudioWhenSynthesisFails(t *testing.T) {
+	s := newCallScript(t)
+	s.synthesizer.err = errors.New("tts down")
+	turns := s.Say(0, "are you there").
+		ExpectRespond("are you there", "").
+		Run()
+
+	if turns[0].Audio != nil {
+		t.Errorf("Expected no audio when synthesis fails, got %d bytes", len(turns[0].Audio))
+	}
+}
+
+func TestTurnEngineStoresWordTimingsWithMessage(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	engine := NewTurnEngine(channels, conversation, &fakeGenerator{replies: map[string]string{}}, &fakeSynthesizer{})
+	engine.FinalGrace = 10 * time.Millisecond
+	engine.TickInterval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	channels.TranscriptionChan <- Transcript{Text: "hello there", IsFinal: true, Words: []WordTiming{
+		{Word: "hello", Start: 100 * time.Millisecond, End: 400 * time.Millisecond},
+		{Word: "there", Start: 450 * time.Millisecond, End: 800 * time.Millisecond},
+	}}
+
+	deadline := time.Now().Add(time.Second)
+	for conversation.MessageCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	messages := conversation.Transcript()
+	if len(messages) == 0 {
+		t.Fatal("Expected the caller message to be stored")
+	}
+	if words := messages[0].Words; len(words) != 2 || words[1].Word != "there" || words[1].Start != 450*time.Millisecond {
+		t.Errorf("Expected the word timings on the caller message, got %+v", words)
+	}
+}
+
+// fakeTranslator translates with a phrase table keyed by target language
+type fakeTranslator struct {
+	phrases map[string]map[string]string
+	err     error
+}
+
+func (f *fakeTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	if translated, ok := f.phrases[target][text]; ok {
+		return translated, nil
+	}
+	return text, nil
+}
+
+func TestTurnEngineTranslatesForCallersInOtherLanguages(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &fakeGenerator{replies: map[string]string{"I feel alone": "I'm here with you."}}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+	engine.Language = "es-MX"
+	engine.PivotLanguage = "en-US"
+	engine.Translator = &fakeTranslator{phrases: map[string]map[string]string{
+		"en-US": {"me siento solo": "I feel alone"},
+		"es-MX": {"I'm here with you.": "Estoy aquí contigo."},
+	}}
+
+	turn := engine.ProcessTranscription(context.Background(), "me siento solo")
+
+	generator.mu.Lock()
+	calls := generator.calls
+	generator.mu.Unlock()
+	if len(calls) != 1 || calls[0] != "I feel alone" {
+		t.Errorf("Expected the LLM to get the English translation, got %q", calls)
+	}
+	if turn.Response != "Estoy aquí contigo." || string(turn.Audio) != "Estoy aquí contigo." {
+		t.Errorf("Expected the response spoken in Spanish, got %q", turn.Response)
+	}
+
+	messages := conversation.Transcript()
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+	if messages[0].Content != "I feel alone" || messages[0].Original != "me siento solo" || messages[0].Language != "es-MX" {
+		t.Errorf("Expected the caller's translation pair stored, got %+v", messages[0])
+	}
+	if messages[1].Content != "I'm here with you." || messages[1].Original != "Estoy aquí contigo." {
+		t.Errorf("Expected the response's translation pair stored, got %+v", messages[1])
+	}
+}
+
+func TestTurnEngineFallsBackWhenResponseTranslationFails(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	engine := NewTurnEngine(channels, conversation, &fakeGenerator{replies: map[string]string{}}, &fakeSynthesizer{})
+	engine.Fallbacks = NewFallbackLibrary("es-MX")
+	engine.Language = "es-MX"
+	engine.PivotLanguage = "en-US"
+	engine.Translator = &fakeTranslator{err: errors.New("translation unavailable")}
+
+	turn := engine.ProcessTranscription(context.Background(), "hola")
+
+	if turn.Action != ActionClarify {
+		t.Errorf("Expected a fallback instead of an untranslated response, got %s %q", turn.Action, turn.Response)
+	}
+	if turn.Response == "Tell me more." {
+		t.Error("Expected the English response not to be spoken")
+	}
+}
+
+func TestTurnEngineSendsResponseAudioSentenceBySentence(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &fakeGenerator{replies: map[string]string{"hi": "Hello. How are you feeling today?"}}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+
+	turn := engine.ProcessTranscription(context.Background(), "hi")
+
+	var sent []string
+	for len(channels.ResponseAudioChan) > 0 {
+		sent = append(sent, string(<-channels.ResponseAudioChan))
+	}
+	if len(sent) != 2 || sent[0] != "Hello." || sent[1] != "How are you feeling today?" {
+		t.Errorf("Expected each sentence sent as it was synthesized, got %q", sent)
+	}
+	if string(turn.Audio) != "Hello.How are you feeling today?" {
+		t.Errorf("Expected the turn to keep the whole response's audio, got %q", turn.Audio)
+	}
+}
+
+// delayedSynthesizer is a SpeechSynthesizer that takes longer for earlier sentences and
+// tracks how many requests run at once
+type delayedSynthesizer struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	delays      map[string]time.Duration
+}
+
+func (d *delayedSynthesizer) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	d.mu.Lock()
+	d.inFlight++
+	if d.inFlight > d.maxInFlight {
+		d.maxInFlight = d.inFlight
+	}
+	d.mu.Unlock()
+
+	time.Sleep(d.delays[text])
+
+	d.mu.Lock()
+	d.inFlight--
+	d.mu.Unlock()
+	return []byte(text), nil
+}
+
+func TestTurnEngineSynthesizesSentencesInParallelInOrder(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &fakeGenerator{replies: map[string]string{"hi": "One. Two. Three. Four."}}
+	synthesizer := &delayedSynthesizer{delays: map[string]time.Duration{
+		"One.": 60 * time.Millisecond, "Two.": 40 * time.Millisecond,
+		"Three.": 20 * time.Millisecond, "Four.": 20 * time.Millisecond,
+	}}
+	engine := NewTurnEngine(channels, conversation, generator, synthesizer)
+	engine.SynthesisWorkers = 2
+
+	turn := engine.ProcessTranscription(context.Background(), "hi")
+
+	var sent []string
+	for len(channels.ResponseAudioChan) > 0 {
+		sent = append(sent, string(<-channels.ResponseAudioChan))
+	}
+	if strings.Join(sent, " ") != "One. Two. Three. Four." {
+		t.Errorf("Expected the sentences to play in order, got %q", sent)
+	}
+	if string(turn.Audio) != "One.Two.Three.Four." {
+		t.Errorf("Expected the turn's audio in order, got %q", turn.Audio)
+	}
+	if synthesizer.maxInFlight != 2 {
+		t.Errorf("Expected 2 sentences synthesized at once, got %d", synthesizer.maxInFlight)
+	}
+}
+
+// fakeStreamingGenerator is a mocked StreamingResponseGenerator that streams scripted chunks
+type fakeStreamingGenerator struct {
+	chunks []string
+	err    error
+	// afterFirst, when set, runs after the first chunk, while the rest is still "generating"
+	afterFirst func()
+}
+
+func (f *fakeStreamingGenerator) GenerateResponse(ctx context.Context, userMessage string, conversationHistory []string) (string, error) {
+	return f.GenerateResponseStream(ctx, userMessage, conversationHistory, func(string) {})
+}
+
+func (f *fakeStreamingGenerator) GenerateResponseStream(ctx context.Context, userMessage string, conversationHistory []string, onText func(string)) (string, error) {
+	for i, chunk := range f.chunks {
+		onText(chunk)
+		if i == 0 && f.afterFirst != nil {
+			f.afterFirst()
+		}
+	}
+	return strings.Join(f.chunks, ""), f.err
+}
+
+func TestTurnEngineSpeaksStreamedResponseWhileGenerating(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	var first string
+	generator := &fakeStreamingGenerator{
+		chunks: []string{"I hear you. That ", "sounds really ", "hard."},
+		afterFirst: func() {
+			select {
+			case audio := <-channels.ResponseAudioChan:
+				first = string(audio)
+			case <-time.After(time.Second):
+			}
+		},
+	}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+
+	turn := engine.ProcessTranscription(context.Background(), "I lost my job")
+
+	if first != "I hear you." {
+		t.Errorf("Expected the first sentence spoken before generation finished, got %q", first)
+	}
+	if rest := string(<-channels.ResponseAudioChan); rest != "That sounds really hard." {
+		t.Errorf("Expected the second sentence after the stream ended, got %q", rest)
+	}
+	if turn.Action != ActionRespond || turn.Response != "I hear you. That sounds really hard." {
+		t.Errorf("Expected the full streamed response, got %+v", turn)
+	}
+	if string(turn.Audio) != "I hear you.That sounds really hard." {
+		t.Errorf("Expected the turn's audio to cover both sentences, got %q", turn.Audio)
+	}
+}
+
+func TestTurnEngineKeepsSpokenSentencesWhenStreamFails(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &fakeStreamingGenerator{
+		chunks: []string{"I hear you. ", "That sou"},
+		err:    errors.New("stream broken"),
+	}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+
+	turn := engine.ProcessTranscription(context.Background(), "I lost my job")
+
+	if turn.Action != ActionRespond || turn.Response != "I hear you." {
+		t.Errorf("Expected the response cut short at the spoken sentence, got %+v", turn)
+	}
+	if string(turn.Audio) != "I hear you." {
+		t.Errorf("Expected only the spoken sentence's audio, got %q", turn.Audio)
+	}
+}
+
+func TestTurnEngineFallsBackWhenStreamFailsBeforeASentence(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &fakeStreamingGenerator{chunks: []string{"That sou"}, err: errors.New("stream broken")}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+
+	turn := engine.ProcessTranscription(context.Background(), "I lost my job")
+
+	if turn.Action != ActionClarify {
+		t.Errorf("Expected a clarifying fallback, got %+v", turn)
+	}
+	if len(turn.Audio) == 0 {
+		t.Error("Expected the fallback to be spoken")
+	}
+}
+
+func TestTurnEngineReplacesUnsafeResponses(t *testing.T) {
+	turns := newCallScript(t).
+		Reply("I can't sleep", "Try drinking a little alcohol to relax before bed.").
+		Configure(func(e *TurnEngine) { e.Guardrail = NewResponseGuardrail(0) }).
+		Say(0, "I can't sleep").
+		ExpectClarify("I can't sleep").
+		Run()
+
+	expected := defaultFallbackPhrases["en-US"][FailureUnsafeResponse][0]
+	if turns[0].Response != expected {
+		t.Errorf("Expected the safety fallback %q, got %q", expected, turns[0].Response)
+	}
+}
+
+func TestTurnEngineGuardsStreamedSentences(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	generator := &fakeStreamingGenerator{
+		chunks: []string{"I hear you. ", "You should just end it all. ", "It will pass."},
+	}
+	engine := NewTurnEngine(channels, conversation, generator, &fakeSynthesizer{})
+	engine.Guardrail = NewResponseGuardrail(0)
+
+	turn := engine.ProcessTranscription(context.Background(), "Everything is awful")
+
+	safety := defaultFallbackPhrases["en-US"][FailureUnsafeResponse][0]
+	if turn.Response != "I hear you. "+safety {
+		t.Errorf("Expected the spoken sentence followed by the safety message, got %q", turn.Response)
+	}
+	if messages := conversation.Transcript(); messages[len(messages)-1].Content != turn.Response {
+		t.Errorf("Expected only what was spoken stored, got %q", messages[len(messages)-1].Content)
+	}
+}
+
+func TestTranscriptionBufferRecognition(t *testing.T) {
+	buffer := NewTranscriptionBuffer()
+	buffer.addFinal("I lost my job", 0.8, []WordTiming{{Word: "job", End: time.Second}})
+	if heard := buffer.Recognition(); heard.Source != MessageSourceFinal || heard.Confidence != 0.8 || len(heard.Words) != 1 {
+		t.Errorf("Expected a final recognition, got %+v", heard)
+	}
+	buffer.AddTranscription("and I")
+	if heard := buffer.Recognition(); heard.Source != MessageSourceInterim {
+		t.Errorf("Expected a turn ending on an interim result, got %+v", heard)
+	}
+}
+
+func TestTurnEngineRecordsPipelineMetadata(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	engine := NewTurnEngine(channels, conversation, slowGenerator{10 * time.Millisecond}, &fakeSynthesizer{})
+
+	turn := engine.processTurn(context.Background(), "I haven't slept", Recognition{Source: MessageSourceInterim, Confidence: 0.87})
+	messages := conversation.Transcript()
+	if len(messages) != 2 {
+		t.Fatalf("Expected the caller and the response stored, got %+v", messages)
+	}
+	if caller := messages[0]; caller.Source != MessageSourceInterim || caller.Confidence != 0.87 || caller.CreatedAt.IsZero() {
+		t.Errorf("Expected how the caller was heard kept, got %+v", caller)
+	}
+	response := messages[1]
+	if response.GenerationLatency < 10*time.Millisecond || response.GenerationLatency != turn.GenerationLatency {
+		t.Errorf("Expected the generation latency kept, got %v", response.GenerationLatency)
+	}
+	if response.SynthesisLatency <= 0 || response.SynthesisLatency != turn.SynthesisLatency {
+		t.Errorf("Expected the synthesis latency kept, got %v", response.SynthesisLatency)
+	}
+}
+
+func TestCallPipelineStatsFollowTheTurns(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	engine := NewTurnEngine(channels, conversation, &fakeGenerator{replies: map[string]string{}}, &fakeSynthesizer{})
+
+	if stats := CallPipelineStats(conversation, channels); stats.State != PipelineConnecting || stats.Turns != 0 {
+		t.Errorf("Expected a connecting call without turns, got %+v", stats)
+	}
+
+	engine.ProcessTranscription(context.Background(), "I can't sleep")
+	engine.ProcessTranscription(context.Background(), "Work is hard")
+	channels.CountAudioOut(320)
+
+	stats := CallPipelineStats(conversation, channels)
+	if stats.State != PipelineListening || stats.Turns != 2 || stats.AudioBytesOut != 320 {
+		t.Errorf("Expected two turns, 320 bytes out and the call listening, got %+v", stats)
+	}
+	if stats.Latencies.GenerationMs <= 0 || stats.Drops != (CallDrops{}) {
+		t.Errorf("Expected the generation latency and no drops, got %+v", stats)
+	}
+
+	conversation.End()
+	if stats := CallPipelineStats(conversation, channels); stats.State != PipelineEnded {
+		t.Errorf("Expected the ended call reported, got %+v", stats)
+	}
+}
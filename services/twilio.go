@@ -1,9 +1,22 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/ghophp/call-me-help/config"
 	"github.com/ghophp/call-me-help/logger"
 	"github.com/twilio/twilio-go"
+	twilioClient "github.com/twilio/twilio-go/client"
 	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
 )
 
@@ -11,6 +24,7 @@ import (
 type TwilioService struct {
 	client *twilio.RestClient
 	config *config.Config
+	mu     sync.RWMutex // Guards the client and config, swapped when the auth token rotates
 	log    *logger.Logger
 }
 
@@ -21,19 +35,38 @@ func NewTwilioService() *TwilioService {
 
 	log.Info("Initializing Twilio service with account SID: %s", maskString(cfg.TwilioAccountSID))
 
-	// Create a new Twilio client
-	client := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: cfg.TwilioAccountSID,
-		Password: cfg.TwilioAuthToken,
-	})
-
 	return &TwilioService{
-		client: client,
+		client: newTwilioClient(cfg),
 		config: cfg,
 		log:    log,
 	}
 }
 
+// newTwilioClient creates a REST client with the account's credentials
+func newTwilioClient(cfg *config.Config) *twilio.RestClient {
+	return twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username: cfg.TwilioAccountSID,
+		Password: cfg.TwilioAuthToken,
+	})
+}
+
+// account returns the REST client and config in use
+func (t *TwilioService) account() (*twilio.RestClient, *config.Config) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.client, t.config
+}
+
+// SetAuthToken switches to a rotated auth token for the requests that follow
+func (t *TwilioService) SetAuthToken(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cfg := *t.config
+	cfg.TwilioAuthToken = token
+	t.config, t.client = &cfg, newTwilioClient(&cfg)
+	t.log.Info("Switched to the rotated Twilio auth token")
+}
+
 // GenerateTwiML generates TwiML for an incoming call
 func (t *TwilioService) GenerateTwiML(callbackURL string) string {
 	t.log.Info("Generating TwiML with Stream URL: %s", callbackURL)
@@ -42,7 +75,7 @@ func (t *TwilioService) GenerateTwiML(callbackURL string) string {
 	twiml := `<?xml version="1.0" encoding="UTF-8"?>
 <Response>
   <Connect>
-    <Stream url="` + callbackURL + `" />
+    <Stream url="` + html.EscapeString(callbackURL) + `" />
   </Connect>
 </Response>`
 
@@ -50,16 +83,164 @@ func (t *TwilioService) GenerateTwiML(callbackURL string) string {
 	return twiml
 }
 
+// GenerateConsentTwiML generates TwiML that reads the recording notice and posts the caller's
+// keypress, or no keypress once the gather times out, to actionURL
+func (t *TwilioService) GenerateConsentTwiML(notice, actionURL string) string {
+	t.log.Info("Generating recording consent TwiML with action URL: %s", actionURL)
+	return t.generateGatherTwiML(notice, actionURL)
+}
+
+// GenerateVoiceMenuTwiML generates TwiML that offers the voices and posts the caller's
+// keypress, or no keypress once the gather times out, to actionURL
+func (t *TwilioService) GenerateVoiceMenuTwiML(options []VoiceOption, actionURL string) string {
+	t.log.Info("Generating voice menu TwiML with %d voices and action URL: %s", len(options), actionURL)
+	return t.generateGatherTwiML(VoiceMenuPrompt(options), actionURL)
+}
+
+// GeneratePersonaMenuTwiML generates TwiML that offers the personas and posts the caller's
+// keypress, or no keypress once the gather times out, to actionURL
+func (t *TwilioService) GeneratePersonaMenuTwiML(personas []Persona, actionURL string) string {
+	t.log.Info("Generating persona menu TwiML with %d personas and action URL: %s", len(personas), actionURL)
+	return t.generateGatherTwiML(PersonaMenuPrompt(personas), actionURL)
+}
+
+// generateGatherTwiML reads the prompt while waiting for a single keypress
+func (t *TwilioService) generateGatherTwiML(prompt, actionURL string) string {
+	actionURL = html.EscapeString(actionURL)
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+  <Gather numDigits="1" timeout="5" action="` + actionURL + `" method="POST">
+    <Say>` + html.EscapeString(prompt) + `</Say>
+  </Gather>
+  <Redirect method="POST">` + actionURL + `</Redirect>
+</Response>`
+}
+
+// GenerateVoicemailTwiML generates TwiML that records a voicemail and posts it to actionURL
+func (t *TwilioService) GenerateVoicemailTwiML(actionURL string) string {
+	t.log.Info("Generating voicemail TwiML with action URL: %s", actionURL)
+
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+  <Say>Thank you for calling. Please leave a message after the tone, and we will send you a text message shortly.</Say>
+  <Record action="` + html.EscapeString(actionURL) + `" method="POST" maxLength="60" playBeep="true" />
+  <Say>We did not receive a message. Goodbye.</Say>
+</Response>`
+}
+
+// GenerateHangupTwiML generates TwiML that says a message and ends the call
+func (t *TwilioService) GenerateHangupTwiML(message string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+  <Say>` + html.EscapeString(message) + `</Say>
+  <Hangup />
+</Response>`
+}
+
+// ValidateRequest checks a webhook's X-Twilio-Signature was made with the account's auth
+// token over the URL Twilio requested and the form it posted
+func (t *TwilioService) ValidateRequest(url string, params map[string]string, signature string) bool {
+	_, cfg := t.account()
+	if cfg.TwilioAuthToken == "" || signature == "" {
+		return false
+	}
+	validator := twilioClient.NewRequestValidator(cfg.TwilioAuthToken)
+	return validator.Validate(url, params, signature)
+}
+
+// twilioAccountsURL is the collection of Twilio accounts, the account SID is appended
+const twilioAccountsURL = "https://api.twilio.com/2010-04-01/Accounts/"
+
+// ErrRecordingURL is returned for recording URLs that aren't the account's recordings
+var ErrRecordingURL = errors.New("not a recording of the Twilio account")
+
+// recordingSIDPattern matches what follows the account's recordings collection in a recording URL
+var recordingSIDPattern = regexp.MustCompile(`^RE[0-9a-zA-Z]+$`)
+
+// CheckRecordingURL checks the URL is one of the account's recordings on the Twilio API,
+// since fetching it sends the account's credentials
+func (t *TwilioService) CheckRecordingURL(recordingURL string) error {
+	_, cfg := t.account()
+	prefix := twilioAccountsURL + cfg.TwilioAccountSID + "/Recordings/"
+	sid, ok := strings.CutPrefix(recordingURL, prefix)
+	if cfg.TwilioAccountSID == "" || !ok || !recordingSIDPattern.MatchString(sid) {
+		return ErrRecordingURL
+	}
+	return nil
+}
+
+// FetchRecording downloads a recording as 8kHz 16-bit WAV using the account credentials
+func (t *TwilioService) FetchRecording(ctx context.Context, recordingURL string) ([]byte, error) {
+	if err := t.CheckRecordingURL(recordingURL); err != nil {
+		return nil, err
+	}
+	t.log.Info("Fetching recording: %s", recordingURL)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, recordingURL+".wav", nil)
+	if err != nil {
+		return nil, err
+	}
+	_, cfg := t.account()
+	req.SetBasicAuth(cfg.TwilioAccountSID, cfg.TwilioAuthToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.log.Error("Error fetching recording: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.log.Error("Fetching recording returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("fetching recording: unexpected status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Probe fetches the account, which fails when the credentials are wrong or it is suspended
+func (t *TwilioService) Probe(ctx context.Context) error {
+	_, cfg := t.account()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, twilioAccountsURL+cfg.TwilioAccountSID+".json", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(cfg.TwilioAccountSID, cfg.TwilioAuthToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var account struct {
+		Status string `json:"status"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching the account: unexpected status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return err
+	}
+	if account.Status != "active" {
+		return fmt.Errorf("the account is %s", account.Status)
+	}
+	return nil
+}
+
 // SendMessage sends an SMS message using Twilio
 func (t *TwilioService) SendMessage(to, message string) error {
-	t.log.Info("Sending SMS to %s: %s", maskPhoneNumber(to), message)
+	t.log.Info("Sending SMS to %s: %s", maskPhoneNumber(to), logger.Transcript(message))
 
+	client, cfg := t.account()
 	params := &twilioApi.CreateMessageParams{}
 	params.SetTo(to)
-	params.SetFrom(t.config.TwilioPhoneNumber)
+	params.SetFrom(cfg.TwilioPhoneNumber)
 	params.SetBody(message)
 
-	resp, err := t.client.Api.CreateMessage(params)
+	resp, err := client.Api.CreateMessage(params)
 	if err != nil {
 		t.log.Error("Error sending SMS: %v", err)
 		return err
@@ -69,6 +250,32 @@ func (t *TwilioService) SendMessage(to, message string) error {
 	return nil
 }
 
+// HangUp ends a live call
+func (t *TwilioService) HangUp(callSID string) error {
+	params := &twilioApi.UpdateCallParams{}
+	params.SetStatus("completed")
+	client, _ := t.account()
+	if _, err := client.Api.UpdateCall(callSID, params); err != nil {
+		t.log.Error("Error hanging up call %s: %v", callSID, err)
+		return err
+	}
+	t.log.Info("Hung up call %s", callSID)
+	return nil
+}
+
+// Transfer dials the number on a live call, which ends its media stream
+func (t *TwilioService) Transfer(callSID, number string) error {
+	params := &twilioApi.UpdateCallParams{}
+	params.SetTwiml(`<Response><Dial>` + html.EscapeString(number) + `</Dial></Response>`)
+	client, _ := t.account()
+	if _, err := client.Api.UpdateCall(callSID, params); err != nil {
+		t.log.Error("Error transferring call %s: %v", callSID, err)
+		return err
+	}
+	t.log.Info("Transferred call %s to %s", callSID, maskPhoneNumber(number))
+	return nil
+}
+
 // Helper function to mask sensitive data
 func maskString(input string) string {
 	if len(input) <= 8 {
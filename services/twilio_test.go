@@ -0,0 +1,67 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestCheckRecordingURLOnlyAllowsTheAccountsRecordings(t *testing.T) {
+	t.Setenv("TWILIO_ACCOUNT_SID", "AC123")
+	twilio := NewTwilioService()
+
+	if err := twilio.CheckRecordingURL("https://api.twilio.com/2010-04-01/Accounts/AC123/Recordings/RE0123abc"); err != nil {
+		t.Errorf("Expected the account's recording to be allowed, got %v", err)
+	}
+	for _, url := range []string{
+		"https://attacker.example/2010-04-01/Accounts/AC123/Recordings/RE0123abc",
+		"http://api.twilio.com/2010-04-01/Accounts/AC123/Recordings/RE0123abc",
+		"https://api.twilio.com/2010-04-01/Accounts/AC999/Recordings/RE0123abc",
+		"https://api.twilio.com/2010-04-01/Accounts/AC123/Recordings/RE0123abc/../../../Messages",
+		"https://api.twilio.com/2010-04-01/Accounts/AC123/Recordings/RE0123abc?x=1",
+		"https://api.twilio.com.attacker.example/2010-04-01/Accounts/AC123/Recordings/RE0123abc",
+	} {
+		if err := twilio.CheckRecordingURL(url); err != ErrRecordingURL {
+			t.Errorf("Expected %s to be refused, got %v", url, err)
+		}
+	}
+}
+
+func TestValidateRequestChecksTheSignature(t *testing.T) {
+	t.Setenv("TWILIO_AUTH_TOKEN", "secret")
+	twilio := NewTwilioService()
+
+	url := "https://example.com/twilio/voicemail"
+	params := map[string]string{"CallSid": "CA1", "RecordingUrl": "https://api.twilio.com/x"}
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(url + "CallSidCA1RecordingUrlhttps://api.twilio.com/x"))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if !twilio.ValidateRequest(url, params, signature) {
+		t.Error("Expected the signed request to be valid")
+	}
+	params["From"] = "+15551234567"
+	if twilio.ValidateRequest(url, params, signature) {
+		t.Error("Expected a changed form to be refused")
+	}
+	if twilio.ValidateRequest(url, params, "") {
+		t.Error("Expected an unsigned request to be refused")
+	}
+}
+
+func TestTwiMLEscapesURLs(t *testing.T) {
+	twilio := NewTwilioService()
+	actionURL := `https://example.com"/><Dial>+15551234567</Dial><x a="/twilio/voicemail`
+
+	for name, twiml := range map[string]string{
+		"gather":    twilio.GenerateConsentTwiML("Notice", actionURL),
+		"voicemail": twilio.GenerateVoicemailTwiML(actionURL),
+		"stream":    twilio.GenerateTwiML(actionURL),
+	} {
+		if strings.Contains(twiml, "<Dial>") {
+			t.Errorf("Expected the %s TwiML to escape the URL, got %s", name, twiml)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Why a signed URL was refused
+var (
+	ErrURLExpired   = errors.New("the link has expired")
+	ErrURLSignature = errors.New("the link's signature is missing or wrong")
+)
+
+// URLSigner signs URL paths with an HMAC and an expiry, so a link to a recording works for
+// whoever has it, but only until it expires
+type URLSigner struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewURLSigner creates a signer of links valid for ttl. Without a secret it signs with a
+// random key, so links stop working when the process restarts and aren't accepted by
+// other instances.
+func NewURLSigner(secret string, ttl time.Duration) *URLSigner {
+	key := []byte(secret)
+	if secret == "" {
+		logger.Component("URLSigner").Warn("AUDIO_URL_SECRET is not set, signing audio links with a random key that changes on restart")
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &URLSigner{key: key, ttl: ttl, now: time.Now}
+}
+
+// signature is the HMAC of the path and expiry
+func (s *URLSigner) signature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the query parameters that make the path's link valid until the TTL passes
+func (s *URLSigner) Sign(path string) url.Values {
+	expires := s.now().Add(s.ttl).Unix()
+	return url.Values{
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {s.signature(path, expires)},
+	}
+}
+
+// Verify checks the path's link was signed, with the query's parameters, and hasn't expired
+func (s *URLSigner) Verify(path string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrURLSignature
+	}
+	if !hmac.Equal([]byte(query.Get("signature")), []byte(s.signature(path, expires))) {
+		return ErrURLSignature
+	}
+	if s.now().Unix() > expires {
+		return ErrURLExpired
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignedURLsExpire(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	signer := NewURLSigner("s3cret", 15*time.Minute)
+	signer.now = func() time.Time { return now }
+
+	query := signer.Sign("/audio/download/CA1_a.raw")
+	if err := signer.Verify("/audio/download/CA1_a.raw", query); err != nil {
+		t.Fatalf("Expected the link valid, got %v", err)
+	}
+	if err := signer.Verify("/audio/download/CA2_a.raw", query); err != ErrURLSignature {
+		t.Errorf("Expected the signature not to work for another file, got %v", err)
+	}
+
+	extended := query
+	extended.Set("expires", "9999999999")
+	if err := signer.Verify("/audio/download/CA1_a.raw", extended); err != ErrURLSignature {
+		t.Errorf("Expected an extended expiry refused, got %v", err)
+	}
+
+	query = signer.Sign("/audio/download/CA1_a.raw")
+	now = now.Add(16 * time.Minute)
+	if err := signer.Verify("/audio/download/CA1_a.raw", query); err != ErrURLExpired {
+		t.Errorf("Expected the link expired, got %v", err)
+	}
+	if err := NewURLSigner("other", time.Hour).Verify("/audio/download/CA1_a.raw", NewURLSigner("s3cret", time.Hour).Sign("/audio/download/CA1_a.raw")); err != ErrURLSignature {
+		t.Errorf("Expected a link signed with another secret refused, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"math"
+	"time"
+)
+
+// VADConfig tunes the energy-based voice activity detector in front of speech recognition
+type VADConfig struct {
+	Enabled bool
+	// Threshold is the minimum RMS level (16-bit PCM) of a speech frame; the
+	// detector raises it above the call's background noise as it learns it
+	Threshold float64
+	// Hangover keeps streaming after speech drops out, so pauses between words
+	// and the recognizer's own endpointing still see some silence
+	Hangover time.Duration
+	// PreRoll is how much audio from before speech starts is sent with it, so onsets aren't clipped
+	PreRoll time.Duration
+}
+
+// vadNoiseMargin is how far above the background noise floor a frame must be to count as speech
+const vadNoiseMargin = 3.0
+
+// VoiceActivityDetector gates audio frames so only speech (plus hangover and pre-roll) is streamed
+type VoiceActivityDetector struct {
+	config VADConfig
+	format AudioFormat
+
+	noiseFloor float64
+	speaking   bool
+	// silence is how long the current stretch of non-speech frames has lasted
+	silence time.Duration
+
+	preRoll         [][]byte
+	preRollDuration time.Duration
+
+	passed  time.Duration
+	dropped time.Duration
+}
+
+// NewVoiceActivityDetector creates a detector for audio in the given format
+func NewVoiceActivityDetector(config VADConfig, format AudioFormat) *VoiceActivityDetector {
+	return &VoiceActivityDetector{
+		config: config,
+		format: format.Normalize(),
+	}
+}
+
+// frameDuration is how much audio a payload holds in the detector's format
+func (v *VoiceActivityDetector) frameDuration(frame []byte) time.Duration {
+	return time.Duration(len(frame)) * time.Second / time.Duration(v.format.BytesPerSecond())
+}
+
+// isSpeech classifies a frame by its energy against the threshold and learned noise floor
+func (v *VoiceActivityDetector) isSpeech(frame []byte) bool {
+	samples := DecodeSamples(frame, v.format)
+	if len(samples) == 0 {
+		return false
+	}
+
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	rms := math.Sqrt(sum / float64(len(samples)))
+
+	threshold := math.Max(v.config.Threshold, v.noiseFloor*vadNoiseMargin)
+	if rms >= threshold {
+		return true
+	}
+
+	// Track the background level slowly so a noisy line doesn't read as constant speech
+	if v.noiseFloor == 0 {
+		v.noiseFloor = rms
+	} else {
+		v.noiseFloor = 0.95*v.noiseFloor + 0.05*rms
+	}
+	return false
+}
+
+// Process takes the next inbound frame and returns the frames to stream, which may be
+// none during silence or several when speech starts and the pre-roll is released
+func (v *VoiceActivityDetector) Process(frame []byte) [][]byte {
+	duration := v.frameDuration(frame)
+
+	if v.isSpeech(frame) {
+		v.silence = 0
+		v.passed += duration
+		if v.speaking {
+			return [][]byte{frame}
+		}
+
+		v.speaking = true
+		out := append(v.preRoll, frame)
+		v.passed += v.preRollDuration
+		v.dropped -= v.preRollDuration
+		v.preRoll = nil
+		v.preRollDuration = 0
+		return out
+	}
+
+	if v.speaking {
+		v.silence += duration
+		if v.silence <= v.config.Hangover {
+			v.passed += duration
+			return [][]byte{frame}
+		}
+		v.speaking = false
+	}
+
+	// Hold recent silence as pre-roll for the next utterance
+	v.dropped += duration
+	v.preRoll = append(v.preRoll, frame)
+	v.preRollDuration += duration
+	for len(v.preRoll) > 1 && v.preRollDuration > v.config.PreRoll {
+		v.preRollDuration -= v.frameDuration(v.preRoll[0])
+		v.preRoll = v.preRoll[1:]
+	}
+	return nil
+}
+
+// Speaking reports whether the detector is currently passing audio through
+func (v *VoiceActivityDetector) Speaking() bool {
+	return v.speaking
+}
+
+// Stats returns how much audio was streamed and how much was skipped as silence
+func (v *VoiceActivityDetector) Stats() (passed, dropped time.Duration) {
+	return v.passed, v.dropped
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// toneFrame is a 20ms μ-law frame of a square wave at the given amplitude
+func toneFrame(amplitude int16) []byte {
+	samples := make([]int16, 160)
+	for i := range samples {
+		if (i/10)%2 == 0 {
+			samples[i] = amplitude
+		} else {
+			samples[i] = -amplitude
+		}
+	}
+	return EncodeSamples(samples, DefaultAudioFormat())
+}
+
+func testVAD() *VoiceActivityDetector {
+	return NewVoiceActivityDetector(VADConfig{
+		Enabled:   true,
+		Threshold: 300,
+		Hangover:  60 * time.Millisecond,
+		PreRoll:   40 * time.Millisecond,
+	}, DefaultAudioFormat())
+}
+
+func TestVADDropsSilence(t *testing.T) {
+	vad := testVAD()
+	for i := 0; i < 50; i++ {
+		if out := vad.Process(toneFrame(20)); len(out) != 0 {
+			t.Fatalf("Expected silence frame %d to be held back, got %d frames", i, len(out))
+		}
+	}
+	if vad.Speaking() {
+		t.Error("Expected no speech during silence")
+	}
+	if passed, dropped := vad.Stats(); passed != 0 || dropped != time.Second {
+		t.Errorf("Expected 0s streamed and 1s skipped, got %v and %v", passed, dropped)
+	}
+}
+
+func TestVADReleasesPreRollAtSpeechOnset(t *testing.T) {
+	vad := testVAD()
+	for i := 0; i < 10; i++ {
+		vad.Process(toneFrame(20))
+	}
+
+	out := vad.Process(toneFrame(4000))
+	// 40ms of pre-roll is two 20ms frames, then the speech frame itself
+	if len(out) != 3 {
+		t.Fatalf("Expected pre-roll plus the speech frame, got %d frames", len(out))
+	}
+	if !vad.Speaking() {
+		t.Error("Expected speech to be detected")
+	}
+}
+
+func TestVADHangoverThenStops(t *testing.T) {
+	vad := testVAD()
+	vad.Process(toneFrame(4000))
+
+	// 60ms hangover keeps three silent frames flowing, then the gate closes
+	for i := 0; i < 3; i++ {
+		if out := vad.Process(toneFrame(20)); len(out) != 1 {
+			t.Fatalf("Expected hangover frame %d to be streamed, got %d frames", i, len(out))
+		}
+	}
+	if out := vad.Process(toneFrame(20)); len(out) != 0 {
+		t.Errorf("Expected silence after the hangover to be held back, got %d frames", len(out))
+	}
+	if vad.Speaking() {
+		t.Error("Expected speech to have ended after the hangover")
+	}
+}
+
+func TestVADAdaptsToNoiseFloor(t *testing.T) {
+	vad := testVAD()
+	// Steady line noise raises the bar for speech above the fixed threshold
+	for i := 0; i < 200; i++ {
+		vad.Process(toneFrame(200))
+	}
+	if vad.Speaking() {
+		t.Fatal("Expected background noise below the threshold not to count as speech")
+	}
+	if out := vad.Process(toneFrame(400)); len(out) != 0 {
+		t.Errorf("Expected a frame barely above the noise to be held back, got %d frames", len(out))
+	}
+	if out := vad.Process(toneFrame(3000)); len(out) == 0 {
+		t.Error("Expected speech well above the noise floor to be streamed")
+	}
+}
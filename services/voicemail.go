@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// voicemailResources is appended to every voicemail reply so the caller always gets crisis contacts
+const voicemailResources = "If you are in crisis, call or text 988 (Suicide & Crisis Lifeline) or dial 911."
+
+// voicemailInstructions steers the model towards a short SMS instead of a spoken answer
+const voicemailInstructions = "The caller left a voicemail on the message-only line. Reply with a short, compassionate text message " +
+	"(under 300 characters) acknowledging what they shared. Offer that someone can call them back. Do not use markdown."
+
+// maxCallbacks bounds the callback queue; past it, the oldest offers are dropped
+const maxCallbacks = 500
+
+// Where a callback offer came from
+const (
+	CallbackVoicemail = "voicemail" // Offered in the SMS reply to a voicemail
+	CallbackRequested = "requested" // Asked for by the caller during a call
+)
+
+// CallbackOffer is a queued offer to call a voicemail caller back
+type CallbackOffer struct {
+	ID          string    `json:"id"`
+	Source      string    `json:"source"`
+	PhoneNumber string    `json:"phoneNumber"`
+	CallSID     string    `json:"callSid"`
+	Transcript  string    `json:"transcript"`
+	Reply       string    `json:"reply"`
+	ReplySent   bool      `json:"replySent"` // Whether the SMS reply to a voicemail went out
+	CreatedAt   time.Time `json:"createdAt"`
+	// RequestedTime and Reason are set on callbacks callers asked for during a call
+	RequestedTime string `json:"requestedTime,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// VoicemailService handles the message-only line: transcribe, reply by SMS, queue a callback
+type VoicemailService struct {
+	stt       SpeechRecognizer
+	generator ResponseGenerator
+	masker    *TermMasker
+	retention time.Duration // How long an offer stays queued, 0 until the queue is full
+	callbacks []CallbackOffer
+	store     CallbackStore // nil keeps the queue in memory only
+	mu        sync.Mutex
+	log       *logger.Logger
+}
+
+// NewVoicemailService creates a new voicemail service whose callback offers are dropped
+// once they have been queued for retention, 0 keeping them until the queue is full
+func NewVoicemailService(stt SpeechRecognizer, generator ResponseGenerator, masker *TermMasker, retention time.Duration) *VoicemailService {
+	log := logger.Component("Voicemail")
+	log.Info("Creating new Voicemail service")
+
+	return &VoicemailService{
+		stt:       stt,
+		generator: generator,
+		masker:    masker,
+		retention: retention,
+		log:       log,
+	}
+}
+
+// SetCallbackStore has the callback queue saved to the store, restoring the one saved
+// before a restart
+func (v *VoicemailService) SetCallbackStore(store CallbackStore) error {
+	saved, err := store.LoadCallbacks()
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.store = store
+	v.callbacks = append(saved, v.callbacks...)
+	v.prune(time.Now())
+	v.log.Info("Restored %d queued callback offers", len(v.callbacks))
+	return nil
+}
+
+// ProcessRecording transcribes a voicemail, texts the caller a reply with resources and
+// queues a callback offer. The recording is fetched and the reply sent through twilio,
+// the account the call came in on.
+func (v *VoicemailService) ProcessRecording(ctx context.Context, twilio *TwilioService, callSID, from, recordingURL string) error {
+	v.log.Info("Processing voicemail for call %s from %s", callSID, maskPhoneNumber(from))
+
+	audio, err := twilio.FetchRecording(ctx, recordingURL)
+	if err != nil {
+		return err
+	}
+
+	// Twilio serves recordings as 16-bit 8kHz WAV
+	transcript, err := v.stt.TranscribeRecording(ctx, audio)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(transcript) == "" {
+		transcript = "(no speech detected)"
+	}
+
+	reply, err := v.generator.GenerateResponse(ctx, transcript, []string{"Context: " + voicemailInstructions})
+	if err != nil {
+		v.log.Error("Error generating voicemail reply for call %s: %v", callSID, err)
+		reply = "Thank you for reaching out. We heard your message and someone can call you back."
+	}
+	reply = strings.TrimSpace(reply) + "\n" + voicemailResources
+
+	// The callback is queued even when the text fails, so the caller still hears back
+	sendErr := twilio.SendMessage(from, reply)
+	offer := CallbackOffer{
+		ID:          generateID("cb"),
+		Source:      CallbackVoicemail,
+		PhoneNumber: from,
+		CallSID:     callSID,
+		Transcript:  v.masker.Mask(transcript),
+		Reply:       reply,
+		ReplySent:   sendErr == nil,
+		CreatedAt:   time.Now(),
+	}
+	v.queue(offer)
+
+	v.log.Info("Queued callback offer %s for call %s (SMS reply sent: %v)", offer.ID, callSID, offer.ReplySent)
+	return sendErr
+}
+
+// ScheduleCallback queues a callback the caller asked for during a live call
+func (v *VoicemailService) ScheduleCallback(callSID, phoneNumber, requestedTime, reason string) CallbackOffer {
+	offer := CallbackOffer{
+		ID:            generateID("cb"),
+		Source:        CallbackRequested,
+		PhoneNumber:   phoneNumber,
+		CallSID:       callSID,
+		CreatedAt:     time.Now(),
+		RequestedTime: requestedTime,
+		Reason:        v.masker.Mask(reason),
+	}
+	v.queue(offer)
+
+	v.log.Info("Scheduled callback %s for call %s", offer.ID, callSID)
+	return offer
+}
+
+// Callbacks returns the queued callback offers, oldest first
+func (v *VoicemailService) Callbacks() []CallbackOffer {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.prune(time.Now()) {
+		v.save()
+	}
+	out := make([]CallbackOffer, len(v.callbacks))
+	copy(out, v.callbacks)
+	return out
+}
+
+// queue adds the offer to the callback queue and saves the queue
+func (v *VoicemailService) queue(offer CallbackOffer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.callbacks = append(v.callbacks, offer)
+	v.prune(time.Now())
+	v.save()
+}
+
+// prune drops the offers past the retention and, over maxCallbacks, the oldest ones,
+// reporting whether any were dropped. The caller holds the lock.
+func (v *VoicemailService) prune(now time.Time) bool {
+	kept := v.callbacks[:0]
+	for _, offer := range v.callbacks {
+		if v.retention <= 0 || now.Sub(offer.CreatedAt) < v.retention {
+			kept = append(kept, offer)
+		}
+	}
+	if over := len(kept) - maxCallbacks; over > 0 {
+		v.log.Warn("Callback queue is full, dropping the %d oldest offers", over)
+		kept = kept[over:]
+	}
+	dropped := len(kept) != len(v.callbacks)
+	v.callbacks = kept
+	return dropped
+}
+
+// save writes the queue to the store, if there is one; failures are logged, the queue
+// staying in memory. The caller holds the lock.
+func (v *VoicemailService) save() {
+	if v.store == nil {
+		return
+	}
+	if err := v.store.SaveCallbacks(v.callbacks); err != nil {
+		v.log.Error("Failed to save the callback queue: %v", err)
+	}
+}
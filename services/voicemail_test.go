@@ -0,0 +1,46 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVoicemailServiceKeepsCallbacksAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	voicemail := NewVoicemailService(nil, nil, nil, time.Hour)
+	if err := voicemail.SetCallbackStore(NewTranscriptArchive(dir, testCipher(t, 1))); err != nil {
+		t.Fatalf("Expected an empty queue restored, got %v", err)
+	}
+	voicemail.queue(CallbackOffer{ID: "cb-old", CallSID: "CA0", CreatedAt: time.Now().Add(-2 * time.Hour)})
+	voicemail.ScheduleCallback("CA1", "+15550100", "tomorrow morning", "check in")
+
+	data, err := os.ReadFile(filepath.Join(dir, "callbacks", "queue.json"))
+	if err != nil || !IsSealed(data) {
+		t.Fatalf("Expected the queue encrypted on disk, got %q, %v", data, err)
+	}
+
+	restored := NewVoicemailService(nil, nil, nil, time.Hour)
+	if err := restored.SetCallbackStore(NewTranscriptArchive(dir, testCipher(t, 1))); err != nil {
+		t.Fatalf("Expected the queue restored, got %v", err)
+	}
+	if callbacks := restored.Callbacks(); len(callbacks) != 1 || callbacks[0].CallSID != "CA1" || callbacks[0].PhoneNumber != "+15550100" {
+		t.Errorf("Expected the offer past retention dropped and the other restored, got %+v", callbacks)
+	}
+	if err := NewVoicemailService(nil, nil, nil, 0).SetCallbackStore(NewTranscriptArchive(dir, testCipher(t, 2))); err == nil {
+		t.Error("Expected another key to fail decrypting the queue")
+	}
+}
+
+func TestVoicemailServiceBoundsTheCallbackQueue(t *testing.T) {
+	voicemail := NewVoicemailService(nil, nil, nil, 0)
+	for i := 0; i < maxCallbacks+10; i++ {
+		voicemail.ScheduleCallback(fmt.Sprintf("CA%d", i), "", "", "")
+	}
+	callbacks := voicemail.Callbacks()
+	if len(callbacks) != maxCallbacks || callbacks[0].CallSID != "CA10" {
+		t.Errorf("Expected the %d newest offers kept, got %d from %s", maxCallbacks, len(callbacks), callbacks[0].CallSID)
+	}
+}
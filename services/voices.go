@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// VoiceOption is a voice callers can choose for their call. Voice is the provider's
+// voice name, e.g. en-US-Neural2-F for Google or Joanna for Polly.
+type VoiceOption struct {
+	Label string `json:"label"`
+	Voice string `json:"voice"`
+}
+
+// ParseVoiceOptions parses "label=voice" entries, e.g. "calm=en-US-Neural2-F"
+func ParseVoiceOptions(entries []string) ([]VoiceOption, error) {
+	var options []VoiceOption
+	for _, entry := range entries {
+		label, voice, ok := strings.Cut(entry, "=")
+		label, voice = strings.TrimSpace(label), strings.TrimSpace(voice)
+		if !ok || label == "" || voice == "" {
+			return nil, fmt.Errorf("invalid voice option %q, expected label=voice", entry)
+		}
+		options = append(options, VoiceOption{Label: label, Voice: voice})
+	}
+	return options, nil
+}
+
+// FindVoiceOption looks an option up by label, ignoring case
+func FindVoiceOption(options []VoiceOption, label string) (VoiceOption, bool) {
+	for _, option := range options {
+		if strings.EqualFold(option.Label, strings.TrimSpace(label)) {
+			return option, true
+		}
+	}
+	return VoiceOption{}, false
+}
+
+// VoiceOptionForDigit returns the option a keypress picks from the voice menu, 1 for the first
+func VoiceOptionForDigit(options []VoiceOption, digits string) (VoiceOption, bool) {
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 || n > len(options) {
+		return VoiceOption{}, false
+	}
+	return options[n-1], true
+}
+
+// VoiceMenuPrompt is read to callers to offer the voices, numbered from 1
+func VoiceMenuPrompt(options []VoiceOption) string {
+	choices := make([]string, len(options))
+	for i, option := range options {
+		choices[i] = fmt.Sprintf("press %d for %s", i+1, option.Label)
+	}
+	return "To choose the voice you will hear, " + strings.Join(choices, ", ") +
+		". Or stay on the line to continue with the default voice."
+}
+
+// chosenVoice returns the call's chosen voice from the context, or the configured one
+func chosenVoice(ctx context.Context, configured string) string {
+	if voice := VoiceFromContext(ctx); voice != "" {
+		return voice
+	}
+	return configured
+}
+
+// VoiceCatalog holds the voices callers can choose between and those of the languages
+// they may speak, both replaced when the configuration is reloaded
+type VoiceCatalog struct {
+	options   []VoiceOption
+	languages LanguageVoices
+	mu        sync.RWMutex
+}
+
+// NewVoiceCatalog creates a catalog of the voices
+func NewVoiceCatalog(options []VoiceOption, languages LanguageVoices) *VoiceCatalog {
+	return &VoiceCatalog{options: options, languages: languages}
+}
+
+// Options returns the voices callers can choose between, none to skip the menu
+func (c *VoiceCatalog) Options() []VoiceOption {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.options
+}
+
+// Languages returns the voices of the languages callers may be detected speaking
+func (c *VoiceCatalog) Languages() LanguageVoices {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.languages
+}
+
+// Set replaces the voices; calls keep the voice they already use
+func (c *VoiceCatalog) Set(options []VoiceOption, languages LanguageVoices) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.options, c.languages = options, languages
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseVoiceOptions(t *testing.T) {
+	options, err := ParseVoiceOptions([]string{"calm=en-US-Neural2-F", " warm = en-US-Neural2-D "})
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if option, ok := VoiceOptionForDigit(options, "2"); !ok || option.Voice != "en-US-Neural2-D" {
+		t.Errorf("Expected 2 to pick the warm voice, got %+v", option)
+	}
+	if _, ok := VoiceOptionForDigit(options, "3"); ok {
+		t.Error("Expected a digit past the menu to pick nothing")
+	}
+	if option, ok := FindVoiceOption(options, "Calm"); !ok || option.Voice != "en-US-Neural2-F" {
+		t.Errorf("Expected labels to match regardless of case, got %+v", option)
+	}
+
+	if _, err := ParseVoiceOptions([]string{"en-US-Neural2-F"}); err == nil {
+		t.Error("Expected an entry without a label to be rejected")
+	}
+}
+
+// voiceRecorder is a SpeechSynthesizer that notes the voice each request asked for
+type voiceRecorder struct {
+	voices []string
+}
+
+func (v *voiceRecorder) SynthesizeSpeech(ctx context.Context, text string, format AudioFormat) ([]byte, error) {
+	v.voices = append(v.voices, VoiceFromContext(ctx))
+	return []byte(text), nil
+}
+
+func TestTurnEngineSpeaksInTheCallersVoice(t *testing.T) {
+	channels := NewChannelManager().CreateChannels("test-call")
+	conversation := NewConversationService().GetOrCreateConversation("test-call")
+	synthesizer := &voiceRecorder{}
+	engine := NewTurnEngine(channels, conversation, &fakeGenerator{replies: map[string]string{}}, synthesizer)
+
+	engine.ProcessTranscription(context.Background(), "hello")
+	channels.SetVoice("en-US-Neural2-D")
+	engine.ProcessTranscription(context.Background(), "hello again")
+
+	if len(synthesizer.voices) != 2 || synthesizer.voices[0] != "" || synthesizer.voices[1] != "en-US-Neural2-D" {
+		t.Errorf("Expected the chosen voice only after it was set, got %q", synthesizer.voices)
+	}
+}
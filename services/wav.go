@@ -0,0 +1,66 @@
+package services
+
+import (
+	"encoding/binary"
+)
+
+// WAV format codes
+const (
+	wavFormatPCM   = 1
+	wavFormatAlaw  = 6
+	wavFormatMulaw = 7
+)
+
+// EncodePCMWAV wraps mono 16-bit linear samples in a WAV container
+func EncodePCMWAV(samples []int16, sampleRate int) []byte {
+	data := PCM16LE(samples)
+	return append(wavHeader(wavFormatPCM, sampleRate, 1, 16, len(data)), data...)
+}
+
+// EncodeWAV wraps call audio in a WAV container so it plays in standard players. G.711
+// audio is stored as is with its own format code; audio that already is a WAV file is
+// returned unchanged.
+func EncodeWAV(audio []byte, format AudioFormat) []byte {
+	if len(audio) >= 12 && string(audio[0:4]) == "RIFF" && string(audio[8:12]) == "WAVE" {
+		return audio
+	}
+
+	format = format.Normalize()
+	switch format.Encoding {
+	case EncodingMulaw:
+		return append(wavHeader(wavFormatMulaw, format.SampleRate, format.Channels, 8, len(audio)), audio...)
+	case EncodingAlaw:
+		return append(wavHeader(wavFormatAlaw, format.SampleRate, format.Channels, 8, len(audio)), audio...)
+	default:
+		return EncodePCMWAV(DecodeSamples(audio, format), format.SampleRate)
+	}
+}
+
+// PCM16LE serializes samples as little-endian 16-bit PCM, as WAV and most APIs expect
+func PCM16LE(samples []int16) []byte {
+	data := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+	return data
+}
+
+// wavHeader builds a canonical 44-byte RIFF/WAVE header
+func wavHeader(formatCode, sampleRate, channels, bitsPerSample, dataLen int) []byte {
+	blockAlign := channels * bitsPerSample / 8
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+dataLen))
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], uint16(formatCode))
+	binary.LittleEndian.PutUint16(header[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:], uint16(bitsPerSample))
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(dataLen))
+	return header
+}
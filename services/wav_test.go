@@ -0,0 +1,43 @@
+package services
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncodeWAVKeepsMulawWithItsFormatCode(t *testing.T) {
+	audio := []byte{0xff, 0x7f, 0x00}
+	wav := EncodeWAV(audio, DefaultAudioFormat())
+
+	if len(wav) != 44+len(audio) || string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" {
+		t.Fatalf("Expected a 44-byte RIFF header before the audio, got %d bytes", len(wav))
+	}
+	if code := binary.LittleEndian.Uint16(wav[20:]); code != wavFormatMulaw {
+		t.Errorf("Expected the μ-law format code, got %d", code)
+	}
+	if rate := binary.LittleEndian.Uint32(wav[24:]); rate != 8000 {
+		t.Errorf("Expected 8kHz, got %d", rate)
+	}
+	if bits := binary.LittleEndian.Uint16(wav[34:]); bits != 8 {
+		t.Errorf("Expected 8 bits per sample, got %d", bits)
+	}
+	if string(wav[44:]) != string(audio) {
+		t.Error("Expected the μ-law bytes stored unchanged")
+	}
+
+	if again := EncodeWAV(wav, DefaultAudioFormat()); len(again) != len(wav) {
+		t.Error("Expected a WAV file to be returned unchanged")
+	}
+}
+
+func TestEncodeWAVConvertsBigEndianLinear(t *testing.T) {
+	format := AudioFormat{Encoding: EncodingLinear, SampleRate: 16000, Channels: 1}
+	wav := EncodeWAV([]byte{0x01, 0x02}, format)
+
+	if code := binary.LittleEndian.Uint16(wav[20:]); code != wavFormatPCM {
+		t.Errorf("Expected the PCM format code, got %d", code)
+	}
+	if sample := binary.LittleEndian.Uint16(wav[44:]); sample != 0x0102 {
+		t.Errorf("Expected the sample stored little-endian, got %#x", sample)
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Call lifecycle events published to webhooks
+const (
+	EventCallStarted        = "call.started"
+	EventTranscriptionFinal = "transcription.final"
+	EventResponseGenerated  = "response.generated"
+	EventRiskFlagged        = "risk.flagged"
+	EventCallEnded          = "call.ended"
+)
+
+// EventSLOAlert is published when a stage starts or stops burning its latency SLO's
+// error budget too fast; it has no call
+const EventSLOAlert = "slo.alert"
+
+// Webhook delivery settings
+const (
+	webhookQueueSize = 1000
+	webhookWorkers   = 4
+)
+
+// WebhookEvent is the JSON body POSTed to webhooks, and the data of Pub/Sub messages
+type WebhookEvent struct {
+	ID        string    `json:"id"` // Unique per event, retries of a delivery repeat it
+	Type      string    `json:"type"`
+	CallSID   string    `json:"callSid"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// CallStartedEvent is the data of call.started
+type CallStartedEvent struct {
+	CallerHash string `json:"callerHash,omitempty"`
+	Persona    string `json:"persona,omitempty"`
+	Language   string `json:"language,omitempty"`
+}
+
+// TranscriptionEvent is the data of transcription.final: what the caller said, as stored
+type TranscriptionEvent struct {
+	Text       string  `json:"text"`
+	Original   string  `json:"original,omitempty"`
+	Language   string  `json:"language,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	Confidence float32 `json:"confidence,omitempty"`
+}
+
+// ResponseEvent is the data of response.generated: what the caller was answered, in
+// their language
+type ResponseEvent struct {
+	Text         string     `json:"text"`
+	Model        string     `json:"model,omitempty"` // Empty for fallback phrases
+	Intent       Intent     `json:"intent"`
+	Action       TurnAction `json:"action"`
+	GenerationMs int64      `json:"generationMs"`
+	SynthesisMs  int64      `json:"synthesisMs"`
+}
+
+// RiskEventData is the data of risk.flagged
+type RiskEventData struct {
+	Flag   string `json:"flag"`
+	Source string `json:"source"` // call or summary
+}
+
+// CallEndedEvent is the data of call.ended
+type CallEndedEvent struct {
+	DurationSeconds float64  `json:"durationSeconds"`
+	MessageCount    int      `json:"messageCount"`
+	RiskFlags       []string `json:"riskFlags,omitempty"`
+}
+
+// webhookDelivery is an event on its way to one URL
+type webhookDelivery struct {
+	url   string
+	event string // Type, for logging
+	id    string
+	body  []byte
+}
+
+// WebhookPublisher POSTs call events to the configured URLs in the background, signed
+// with HMAC-SHA256 and retried with backoff, so the call path never waits on them.
+// Deliveries run concurrently, so events can arrive out of order; use createdAt.
+type WebhookPublisher struct {
+	urls        []string
+	secret      []byte
+	maxAttempts int
+	// Backoff is the wait before the first retry, doubled for each further one
+	Backoff time.Duration
+
+	queue  chan webhookDelivery
+	client *http.Client
+	log    *logger.Logger
+}
+
+// NewWebhookPublisher creates a publisher for the URLs, making up to maxAttempts attempts
+// at each delivery; it returns nil, which publishes nothing, when there are no URLs.
+// Events are always signed, so a secret is required.
+func NewWebhookPublisher(urls []string, secret string, maxAttempts int, timeout time.Duration) (*WebhookPublisher, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	if secret == "" {
+		return nil, errors.New("a secret is required to sign webhook events")
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &WebhookPublisher{
+		urls:        urls,
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		Backoff:     time.Second,
+		queue:       make(chan webhookDelivery, webhookQueueSize),
+		client:      &http.Client{Timeout: timeout},
+		log:         logger.Component("Webhooks"),
+	}, nil
+}
+
+// Publish queues the event for every URL; events are dropped when the queue is full
+func (p *WebhookPublisher) Publish(eventType, callSID string, data any) {
+	if p == nil {
+		return
+	}
+	event := newEvent(eventType, callSID, data)
+	body, err := json.Marshal(event)
+	if err != nil {
+		p.log.Error("Failed to encode %s event for call %s: %v", eventType, callSID, err)
+		return
+	}
+	for _, url := range p.urls {
+		select {
+		case p.queue <- webhookDelivery{url: url, event: eventType, id: event.ID, body: body}:
+		default:
+			p.log.Warn("Webhook queue full, dropping %s event for call %s to %s", eventType, callSID, url)
+		}
+	}
+}
+
+// Run delivers queued events until the context is cancelled
+func (p *WebhookPublisher) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	p.log.Info("Publishing call events to %d webhook(s)", len(p.urls))
+	for range webhookWorkers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-p.queue:
+					p.deliver(ctx, delivery)
+				}
+			}
+		}()
+	}
+	<-ctx.Done()
+}
+
+// deliver POSTs an event until it is accepted, it is rejected outright or the attempts
+// run out. Network errors, 429s and 5xx responses are retried.
+func (p *WebhookPublisher) deliver(ctx context.Context, delivery webhookDelivery) {
+	wait := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := p.post(ctx, delivery)
+		if err == nil {
+			p.log.Debug("Delivered %s event %s to %s", delivery.event, delivery.id, delivery.url)
+			return
+		}
+		var status statusError
+		if errors.As(err, &status) && !status.retryable() {
+			p.log.Error("Webhook %s rejected %s event %s: %v", delivery.url, delivery.event, delivery.id, err)
+			return
+		}
+		if attempt >= p.maxAttempts {
+			p.log.Error("Giving up on %s event %s to %s after %d attempt(s): %v", delivery.event, delivery.id, delivery.url, attempt, err)
+			return
+		}
+		p.log.Warn("Delivering %s event %s to %s failed, retrying in %v: %v", delivery.event, delivery.id, delivery.url, wait, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// post makes one delivery attempt
+func (p *WebhookPublisher) post(ctx context.Context, delivery webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", delivery.id)
+	req.Header.Set("Webhook-Timestamp", timestamp)
+	req.Header.Set("Webhook-Signature", "v1="+SignWebhook(p.secret, timestamp, delivery.body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook is the hex HMAC-SHA256 of the timestamp, a dot and the body under the secret;
+// receivers recompute it to check an event came from us and wasn't altered or replayed
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// statusError is an endpoint answering with a non-2xx status
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+// retryable reports whether the status is worth trying again
+func (e statusError) retryable() bool {
+	return e == http.StatusTooManyRequests || e >= 500
+}
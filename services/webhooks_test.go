@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookPublisherDeliversSignedEvents(t *testing.T) {
+	received := make(chan WebhookEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("Webhook-Signature"); got != "v1="+SignWebhook([]byte("shh"), r.Header.Get("Webhook-Timestamp"), body) {
+			t.Errorf("Expected the event signed, got %q", got)
+		}
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Expected a JSON event, got %v", err)
+		}
+		if r.Header.Get("Webhook-Id") != event.ID {
+			t.Errorf("Expected the event ID in the headers, got %q", r.Header.Get("Webhook-Id"))
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	publisher, err := NewWebhookPublisher([]string{server.URL}, "shh", 3, time.Second)
+	if err != nil {
+		t.Fatalf("Expected a publisher, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.Run(ctx)
+
+	publisher.Publish(EventRiskFlagged, "CA123", RiskEventData{Flag: RiskSelfHarm, Source: RiskSourceCall})
+	select {
+	case event := <-received:
+		data, _ := event.Data.(map[string]any)
+		if event.Type != EventRiskFlagged || event.CallSID != "CA123" || data["flag"] != RiskSelfHarm {
+			t.Errorf("Expected the risk event, got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the event delivered")
+	}
+}
+
+func TestWebhookPublisherRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int32
+	}{
+		{"server errors are retried", []int{503, 500, 200}, 3},
+		{"rate limits are retried", []int{429, 204}, 2},
+		{"rejections are not", []int{400, 200}, 1},
+		{"attempts run out", []int{500, 500, 500, 500}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[attempts.Add(1)-1])
+			}))
+			defer server.Close()
+
+			publisher, _ := NewWebhookPublisher([]string{server.URL}, "shh", 3, time.Second)
+			publisher.Backoff = time.Millisecond
+			publisher.Publish(EventCallEnded, "CA123", CallEndedEvent{})
+			publisher.deliver(context.Background(), <-publisher.queue)
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("Expected %d attempt(s), got %d", tt.attempts, got)
+			}
+		})
+	}
+}
+
+func TestNewWebhookPublisher(t *testing.T) {
+	if publisher, err := NewWebhookPublisher(nil, "", 5, time.Second); publisher != nil || err != nil {
+		t.Errorf("Expected no publisher without URLs, got %v, %v", publisher, err)
+	}
+	if _, err := NewWebhookPublisher([]string{"https://example.com/hook"}, "", 5, time.Second); err == nil {
+		t.Error("Expected a secret required to sign events")
+	}
+}
+
+func TestConversationReportsFlaggedRisks(t *testing.T) {
+	conv := NewConversationService().GetOrCreateConversation("CA123")
+	flagged := make(chan RiskEventData, 4)
+	conv.OnRiskFlagged(func(flag, source string) {
+		flagged <- RiskEventData{Flag: flag, Source: source}
+	})
+
+	conv.FlagRisk(RiskSelfHarm)
+	conv.FlagRisk(RiskSelfHarm)
+	conv.SetCallSummary(&CallSummary{RiskFlags: []string{RiskSelfHarm, RiskAbuse}})
+
+	var got []RiskEventData
+	for range 2 {
+		select {
+		case risk := <-flagged:
+			got = append(got, risk)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected two risks reported, got %+v", got)
+		}
+	}
+	select {
+	case risk := <-flagged:
+		t.Errorf("Expected each risk reported once, also got %+v", risk)
+	case <-time.After(50 * time.Millisecond):
+	}
+	want := map[RiskEventData]bool{{RiskSelfHarm, RiskSourceCall}: true, {RiskAbuse, RiskSourceSummary}: true}
+	for _, risk := range got {
+		if !want[risk] {
+			t.Errorf("Unexpected risk reported: %+v", risk)
+		}
+	}
+}
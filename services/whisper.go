@@ -0,0 +1,256 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghophp/call-me-help/config"
+	"github.com/ghophp/call-me-help/logger"
+)
+
+// Segmentation settings for batching streamed audio into Whisper requests
+const (
+	whisperMinSegment      = time.Second
+	whisperTrailingSilence = 600 * time.Millisecond
+	whisperSilenceLevel    = 500 // Peak sample magnitude below which a frame counts as silence
+	whisperPollInterval    = 100 * time.Millisecond
+)
+
+// WhisperService implements SpeechRecognizer by batching audio into short segments
+// and sending them to an OpenAI-compatible transcription endpoint (OpenAI or whisper.cpp)
+type WhisperService struct {
+	config *config.Config
+	client *http.Client
+	log    *logger.Logger
+}
+
+// NewWhisperService creates a new Whisper speech-to-text service
+func NewWhisperService(cfg *config.Config) (*WhisperService, error) {
+	log := logger.Component("Whisper")
+	log.Info("Creating new Whisper service: %s (model %s)", cfg.WhisperURL, cfg.WhisperModel)
+
+	if cfg.WhisperAPIKey == "" && strings.Contains(cfg.WhisperURL, "api.openai.com") {
+		log.Error("WHISPER_API_KEY environment variable not set")
+		return nil, errors.New("WHISPER_API_KEY is required for the OpenAI Whisper API")
+	}
+
+	return &WhisperService{
+		config: cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		log:    log,
+	}, nil
+}
+
+// Close is a no-op; Whisper requests are stateless
+func (w *WhisperService) Close() error {
+	w.log.Info("Closing Whisper service")
+	return nil
+}
+
+// StartStream starts segmenting the call's audio for batch transcription
+func (w *WhisperService) StartStream(ctx context.Context, format AudioFormat) (RecognitionStream, error) {
+	log := CallLogger(ctx, w.log)
+	format = format.Normalize()
+	log.Info("Starting Whisper segmenter (%s, %d Hz)", format.Encoding, format.SampleRate)
+
+	stream := &whisperStream{
+		service:     w,
+		format:      format,
+		transcripts: make(chan Transcript, 64),
+		closed:      make(chan struct{}),
+	}
+	go stream.run(ctx)
+	return stream, nil
+}
+
+// TranscribeRecording transcribes a complete WAV recording
+func (w *WhisperService) TranscribeRecording(ctx context.Context, wav []byte) (string, error) {
+	text, _, err := w.transcribe(ctx, wav)
+	return text, err
+}
+
+// transcribe uploads one WAV file to the transcription endpoint, returning the text and
+// its word timings relative to the start of the file
+func (w *WhisperService) transcribe(ctx context.Context, wav []byte) (string, []WordTiming, error) {
+	log := CallLogger(ctx, w.log)
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "segment.wav")
+	if err != nil {
+		return "", nil, err
+	}
+	part.Write(wav)
+	form.WriteField("model", w.config.WhisperModel)
+	form.WriteField("response_format", "verbose_json")
+	form.WriteField("timestamp_granularities[]", "word")
+	// Whisper takes ISO-639-1 codes, e.g. "en" rather than "en-US"
+	form.WriteField("language", strings.ToLower(strings.SplitN(w.config.STTLanguageCode, "-", 2)[0]))
+	form.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.WhisperURL, &body)
+	if err != nil {
+		return "", nil, err
+	}
+	correlate(req)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if w.config.WhisperAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+w.config.WhisperAPIKey)
+	}
+
+	startTime := time.Now()
+	resp, err := w.client.Do(req)
+	if err != nil {
+		log.Error("Error calling Whisper: %v", err)
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Error("Whisper returned status %d: %s", resp.StatusCode, msg)
+		return "", nil, fmt.Errorf("whisper: unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Text  string `json:"text"`
+		Words []struct {
+			Word  string  `json:"word"`
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+		} `json:"words"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", nil, err
+	}
+
+	var words []WordTiming
+	for _, word := range result.Words {
+		words = append(words, WordTiming{
+			Word:  strings.TrimSpace(word.Word),
+			Start: secondsToDuration(word.Start),
+			End:   secondsToDuration(word.End),
+		})
+	}
+
+	log.Debug("Whisper transcribed %d bytes in %v", len(wav), time.Since(startTime))
+	return strings.TrimSpace(result.Text), words, nil
+}
+
+// whisperStream buffers a call's audio and cuts it into segments at pauses
+type whisperStream struct {
+	service     *WhisperService
+	format      AudioFormat
+	samples     []int16
+	silentFor   time.Duration
+	hasSpeech   bool
+	offset      time.Duration // Where the buffered segment starts in the call's audio
+	mu          sync.Mutex
+	transcripts chan Transcript
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func (s *whisperStream) SendAudio(audio []byte) error {
+	samples := DecodeSamples(audio, s.format)
+	frameDuration := time.Duration(len(samples)) * time.Second / time.Duration(s.format.SampleRate)
+
+	peak := 0
+	for _, sample := range samples {
+		magnitude := int(sample)
+		if magnitude < 0 {
+			magnitude = -magnitude
+		}
+		if magnitude > peak {
+			peak = magnitude
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, samples...)
+	if peak < whisperSilenceLevel {
+		s.silentFor += frameDuration
+	} else {
+		s.silentFor = 0
+		s.hasSpeech = true
+	}
+	return nil
+}
+
+func (s *whisperStream) Transcripts() <-chan Transcript {
+	return s.transcripts
+}
+
+func (s *whisperStream) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// run cuts segments at pauses or at the maximum length and transcribes them in order
+func (s *whisperStream) run(ctx context.Context) {
+	defer close(s.transcripts)
+
+	ticker := time.NewTicker(whisperPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.closed:
+			s.flush(ctx, true)
+			return
+		case <-ticker.C:
+			s.flush(ctx, false)
+		}
+	}
+}
+
+// flush transcribes the buffered segment if it is complete, or unconditionally when force is set
+func (s *whisperStream) flush(ctx context.Context, force bool) {
+	maxSegment := time.Duration(s.service.config.WhisperSegmentSeconds) * time.Second
+
+	s.mu.Lock()
+	buffered := time.Duration(len(s.samples)) * time.Second / time.Duration(s.format.SampleRate)
+	complete := buffered >= maxSegment ||
+		(buffered >= whisperMinSegment && s.silentFor >= whisperTrailingSilence)
+	if !force && !complete {
+		s.mu.Unlock()
+		return
+	}
+
+	segment := s.samples
+	hasSpeech := s.hasSpeech
+	offset := s.offset
+	s.offset += buffered
+	s.samples = nil
+	s.silentFor = 0
+	s.hasSpeech = false
+	s.mu.Unlock()
+
+	// Don't pay for transcribing silence
+	if !hasSpeech || len(segment) == 0 {
+		return
+	}
+
+	text, words, err := s.service.transcribe(ctx, EncodePCMWAV(segment, s.format.SampleRate))
+	if err != nil || text == "" {
+		return
+	}
+
+	s.service.log.Info("Transcription (Final): %s", logger.Transcript(text))
+	transcript := Transcript{Text: text, IsFinal: true, Confidence: 1, EndOfSpeech: true, Words: shiftWords(words, offset)}
+	select {
+	case s.transcripts <- transcript:
+	case <-ctx.Done():
+	}
+}